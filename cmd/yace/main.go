@@ -5,13 +5,21 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	awssession "github.com/aws/aws-sdk-go/aws/session"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/sync/semaphore"
 
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/job"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/otlp"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/remotewrite"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/session"
 )
@@ -30,6 +38,17 @@ var (
 	scrapingInterval      int
 	metricsPerQuery       int
 	labelsSnakeCase       bool
+	otlpEndpoint          string
+	otlpHeaders           cli.StringSlice
+	remoteWriteURL        string
+	remoteWriteUsername   string
+	remoteWritePassword   string
+	remoteWriteToken      string
+	remoteWriteRetries    int
+	disablePrometheus     bool
+	scrapeToken           string
+	// healthMaxConsecutiveFailures must be set before NewScraper is called
+	healthMaxConsecutiveFailures int
 
 	cfg = config.ScrapeConf{}
 )
@@ -63,8 +82,18 @@ func main() {
 		&cli.IntFlag{Name: "cloudwatch-concurrency", Value: 5, Usage: "Maximum number of concurrent requests to CloudWatch API.", Destination: &cloudwatchConcurrency},
 		&cli.IntFlag{Name: "tag-concurrency", Value: 5, Usage: "Maximum number of concurrent requests to Resource Tagging API.", Destination: &tagConcurrency},
 		&cli.IntFlag{Name: "scraping-interval", Value: 300, Usage: "Seconds to wait between scraping the AWS metrics", Destination: &scrapingInterval, EnvVars: []string{"scraping-interval"}},
-		&cli.IntFlag{Name: "metrics-per-query", Value: 500, Usage: "Number of metrics made in a single GetMetricsData request", Destination: &metricsPerQuery, EnvVars: []string{"metrics-per-query"}},
+		&cli.IntFlag{Name: "metrics-per-query", Value: 500, Usage: "Number of metrics made in a single GetMetricsData request, at most 500. Every statistic of a metric counts as a metric.", Destination: &metricsPerQuery, EnvVars: []string{"metrics-per-query"}},
 		&cli.BoolFlag{Name: "labels-snake-case", Value: false, Usage: "If labels should be output in snake case instead of camel case", Destination: &labelsSnakeCase},
+		&cli.StringFlag{Name: "otlp-endpoint", Value: "", Usage: "OTLP/HTTP endpoint to push the metrics to after every scrape, e.g. http://localhost:4318/v1/metrics. Pushing is disabled when empty.", Destination: &otlpEndpoint, EnvVars: []string{"otlp-endpoint"}},
+		&cli.StringSliceFlag{Name: "otlp-header", Usage: "Header added to the OTLP push requests as key=value, e.g. for authentication. Can be repeated.", Destination: &otlpHeaders},
+		&cli.StringFlag{Name: "remote-write-url", Value: "", Usage: "Prometheus remote write endpoint to push the metrics to after every scrape, e.g. http://localhost:9090/api/v1/write. Pushing is disabled when empty.", Destination: &remoteWriteURL, EnvVars: []string{"remote-write-url"}},
+		&cli.StringFlag{Name: "remote-write-username", Value: "", Usage: "Username of the basic auth of the remote write requests.", Destination: &remoteWriteUsername, EnvVars: []string{"remote-write-username"}},
+		&cli.StringFlag{Name: "remote-write-password", Value: "", Usage: "Password of the basic auth of the remote write requests.", Destination: &remoteWritePassword, EnvVars: []string{"remote-write-password"}},
+		&cli.StringFlag{Name: "remote-write-bearer-token", Value: "", Usage: "Bearer token of the remote write requests, instead of basic auth.", Destination: &remoteWriteToken, EnvVars: []string{"remote-write-bearer-token"}},
+		&cli.IntFlag{Name: "remote-write-max-retries", Value: 3, Usage: "Number of times a remote write request failing with a network error, a 5xx or a 429 status is retried.", Destination: &remoteWriteRetries},
+		&cli.BoolFlag{Name: "disable-prometheus-endpoint", Value: false, Usage: "Don't expose the metrics on the /metrics endpoint, e.g. when they are only pushed with OTLP or remote write.", Destination: &disablePrometheus},
+		&cli.StringFlag{Name: "scrape-token", Value: "", Usage: "Bearer token of the POST /scrape endpoint, which triggers a scrape on demand. The endpoint is disabled when empty.", Destination: &scrapeToken, EnvVars: []string{"scrape-token"}},
+		&cli.IntFlag{Name: "health-max-consecutive-failures", Value: 3, Usage: "Number of scrapes in a row failing for every job after which /live reports YACE as unhealthy. 0 disables the check.", Destination: &healthMaxConsecutiveFailures, EnvVars: []string{"health-max-consecutive-failures"}},
 	}
 
 	yace.Commands = []*cli.Command{
@@ -75,6 +104,7 @@ func main() {
 			},
 			Action: func(c *cli.Context) error {
 				log.Println("Parse config..")
+				cfg.DefaultRegion = defaultRegion
 				if err := cfg.Load(&configFile, services.CheckServiceName); err != nil {
 					log.Fatal("Couldn't read ", configFile, ": ", err)
 					os.Exit(1)
@@ -107,19 +137,48 @@ func startScraper(_ *cli.Context) error {
 	}
 
 	log.Println("Parse config..")
+	// The default region is only looked up when a job has no regions, the reloads of the config keep it
+	cfg.DefaultRegion = defaultRegion
 	if err := cfg.Load(&configFile, services.CheckServiceName); err != nil {
 		return fmt.Errorf("Couldn't read %s: %w", configFile, err)
 	}
 
+	var err error
+	metricsPerQuery, err = job.ClampMetricsPerQuery(metricsPerQuery, logger.NewLogrusLogger(log.StandardLogger()))
+	if err != nil {
+		return err
+	}
+
+	if disablePrometheus && otlpEndpoint == "" && remoteWriteURL == "" {
+		return fmt.Errorf("The Prometheus endpoint is disabled and no OTLP or remote write endpoint is set, metrics wouldn't be exported anywhere")
+	}
+	if remoteWriteUsername != "" && remoteWriteToken != "" {
+		return fmt.Errorf("Remote write can't use both basic auth and a bearer token")
+	}
+	if remoteWriteRetries < 0 {
+		return fmt.Errorf("The number of remote write retries should not be negative, got %d", remoteWriteRetries)
+	}
+
+	headers, err := parseHeaders(otlpHeaders.Value())
+	if err != nil {
+		return err
+	}
+
 	log.Println("Startup completed")
 
 	s := NewScraper()
-	cache := session.NewSessionCache(cfg, fips, logger.NewLogrusLogger(log.StandardLogger()))
-
-	ctx, cancelRunningScrape := context.WithCancel(context.Background())
-	go s.decoupled(ctx, cache)
+	if otlpEndpoint != "" {
+		s.otlpExporter = otlp.NewExporter(otlpEndpoint, headers, version, time.Duration(scrapingInterval)*time.Second)
+	}
+	if remoteWriteURL != "" {
+		auth := remotewrite.Auth{Username: remoteWriteUsername, Password: remoteWritePassword, BearerToken: remoteWriteToken}
+		s.remoteWriteExporter = remotewrite.NewExporter(remoteWriteURL, auth, version, remoteWriteRetries, time.Duration(scrapingInterval)*time.Second)
+	}
+	s.start(session.NewSessionCache(cfg, fips, logger.NewLogrusLogger(log.StandardLogger())))
 
-	http.HandleFunc("/metrics", s.makeHandler(ctx, cache))
+	if !disablePrometheus {
+		http.HandleFunc("/metrics", s.makeHandler())
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(`<html>
@@ -136,6 +195,13 @@ func startScraper(_ *cli.Context) error {
 		_, _ = w.Write([]byte("ok"))
 	})
 
+	http.HandleFunc("/ready", s.health.ReadinessHandler())
+	http.HandleFunc("/live", s.health.LivenessHandler())
+
+	if scrapeToken != "" {
+		http.HandleFunc("/scrape", s.makeTriggerHandler(scrapeToken))
+	}
+
 	http.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusNotFound)
@@ -147,12 +213,53 @@ func startScraper(_ *cli.Context) error {
 		}
 
 		log.Println("Reset session cache")
-		cache = session.NewSessionCache(cfg, fips, logger.NewLogrusLogger(log.StandardLogger()))
-
-		cancelRunningScrape()
-		ctx, cancelRunningScrape = context.WithCancel(context.Background())
-		go s.decoupled(ctx, cache)
+		s.start(session.NewSessionCache(cfg, fips, logger.NewLogrusLogger(log.StandardLogger())))
 	})
 
 	return http.ListenAndServe(addr, nil)
 }
+
+// imdsTimeout bounds the lookup of the region in the instance metadata, which only answers on EC2
+const imdsTimeout = 2 * time.Second
+
+// defaultRegion returns the region of the jobs without regions, that of the environment (AWS_REGION or
+// AWS_DEFAULT_REGION), or else that of the EC2 instance the exporter runs on, EKS nodes included, from
+// the instance metadata. It returns an empty region when neither is available.
+func defaultRegion() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			return region
+		}
+	}
+
+	sess, err := awssession.NewSession(&aws.Config{
+		HTTPClient: &http.Client{Timeout: imdsTimeout},
+		MaxRetries: aws.Int(0),
+	})
+	if err != nil {
+		log.Debug("Couldn't create a session to look up the default region: ", err)
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), imdsTimeout)
+	defer cancel()
+	region, err := ec2metadata.New(sess).RegionWithContext(ctx)
+	if err != nil {
+		log.Debug("AWS_REGION is not set and the region isn't available from the instance metadata: ", err)
+		return ""
+	}
+	log.Info("Using the region of the instance metadata, ", region, ", for the jobs without regions")
+	return region
+}
+
+// parseHeaders parses the key=value headers of the otlp-header flag
+func parseHeaders(values []string) (map[string]string, error) {
+	headers := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("Invalid OTLP header %q, expected key=value", value)
+		}
+		headers[key] = val
+	}
+	return headers, nil
+}
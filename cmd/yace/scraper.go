@@ -2,23 +2,51 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 
 	exporter "github.com/nerdswords/yet-another-cloudwatch-exporter/pkg"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/health"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/otlp"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/remotewrite"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/session"
 )
 
 type scraper struct {
 	cloudwatchSemaphore chan struct{}
 	tagSemaphore        chan struct{}
-	registry            *prometheus.Registry
+	// otlpExporter pushes the metrics after every scrape when set
+	otlpExporter *otlp.Exporter
+	// remoteWriteExporter pushes the metrics with Prometheus remote write after every scrape when set
+	remoteWriteExporter *remotewrite.Exporter
+	health              *health.Tracker
+	// triggered makes the concurrent scrapes triggered on demand share a single scrape
+	triggered singleflight.Group
+
+	// mu guards current, which is replaced on reload, and registry, which is replaced by every scrape, while the
+	// handlers read them
+	mu       sync.Mutex
+	current  scrapeRun
+	registry *prometheus.Registry
+}
+
+// scrapeRun is the context and session cache of the periodic scrapes started by start, which the scrapes
+// triggered on demand use too
+type scrapeRun struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	cache  session.SessionCache
 }
 
 func NewScraper() *scraper {
@@ -26,18 +54,43 @@ func NewScraper() *scraper {
 		cloudwatchSemaphore: make(chan struct{}, cloudwatchConcurrency),
 		tagSemaphore:        make(chan struct{}, tagConcurrency),
 		registry:            prometheus.NewRegistry(),
+		health:              health.NewTracker(healthMaxConsecutiveFailures),
 	}
 }
 
-func (s *scraper) makeHandler(ctx context.Context, cache session.SessionCache) func(http.ResponseWriter, *http.Request) {
+func (s *scraper) makeHandler() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		handler := promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{
+		s.mu.Lock()
+		registry := s.registry
+		s.mu.Unlock()
+		handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{
 			DisableCompression: false,
 		})
 		handler.ServeHTTP(w, r)
 	}
 }
 
+// start starts scraping periodically with cache, stopping the scrapes started by the previous call if any
+func (s *scraper) start(cache session.SessionCache) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	previous := s.current
+	s.current = scrapeRun{ctx: ctx, cancel: cancel, cache: cache}
+	s.mu.Unlock()
+
+	if previous.cancel != nil {
+		previous.cancel()
+	}
+	go s.decoupled(ctx, cache)
+}
+
+// run returns the context and session cache of the current periodic scrapes
+func (s *scraper) run() (context.Context, session.SessionCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current.ctx, s.current.cache
+}
+
 func (s *scraper) decoupled(ctx context.Context, cache session.SessionCache) {
 	log.Debug("Starting scraping async")
 	log.Debug("Scrape initially first time")
@@ -60,6 +113,57 @@ func (s *scraper) decoupled(ctx context.Context, cache session.SessionCache) {
 
 var observedMetricLabels = map[string]model.LabelSet{}
 
+// makeTriggerHandler returns the handler of the /scrape endpoint, scraping on demand the requests with token as
+// bearer token. By default the request returns once the scrape is done, with ?async=true it returns right away.
+// The scrapes use the context and session cache of the current periodic scrapes.
+func (s *scraper) makeTriggerHandler(token string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		authorization := r.Header.Get("Authorization")
+		bearer := strings.TrimPrefix(authorization, "Bearer ")
+		if bearer == authorization || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cache := s.run()
+		if r.URL.Query().Get("async") == "true" {
+			go func() { _ = s.triggerScrape(ctx, cache) }()
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if err := s.triggerScrape(ctx, cache); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// triggerScrape scrapes on demand once the scrape in progress, if any, is done. The triggers received in the meantime
+// share the same scrape.
+func (s *scraper) triggerScrape(ctx context.Context, cache session.SessionCache) error {
+	_, err, _ := s.triggered.Do("scrape", func() (interface{}, error) {
+		if err := sem.Acquire(ctx, 1); err != nil {
+			return nil, err
+		}
+		defer sem.Release(1)
+
+		log.Info("Scrape triggered on demand")
+		err := s.runScrape(ctx, cache)
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		promutil.TriggeredScrapesCounter.WithLabelValues(result).Inc()
+		return nil, err
+	})
+	return err
+}
+
 func (s *scraper) scrape(ctx context.Context, cache session.SessionCache) {
 	if !sem.TryAcquire(1) {
 		// This shouldn't happen under normal use, users should adjust their configuration when this occurs.
@@ -70,15 +174,48 @@ func (s *scraper) scrape(ctx context.Context, cache session.SessionCache) {
 	}
 	defer sem.Release(1)
 
+	_ = s.runScrape(ctx, cache)
+}
+
+// runScrape scrapes the metrics and replaces those of the registry with them, the caller must hold sem
+func (s *scraper) runScrape(ctx context.Context, cache session.SessionCache) error {
 	newRegistry := prometheus.NewRegistry()
 	for _, metric := range exporter.Metrics {
 		if err := newRegistry.Register(metric); err != nil {
 			log.Warning("Could not register cloudwatch api metric")
 		}
 	}
-	exporter.UpdateMetrics(ctx, cfg, newRegistry, metricsPerQuery, labelsSnakeCase, s.cloudwatchSemaphore, s.tagSemaphore, cache, observedMetricLabels, logger.NewLogrusLogger(log.StandardLogger()))
+	metrics, jobErrs, err := exporter.ScrapeMetrics(ctx, cfg, metricsPerQuery, labelsSnakeCase, s.cloudwatchSemaphore, s.tagSemaphore, cache, observedMetricLabels, logger.NewLogrusLogger(log.StandardLogger()))
+	s.health.Record(time.Now(), metrics, jobErrs, err)
+	if err != nil {
+		log.Error("Error migrating cloudwatch metrics to prometheus metrics: ", err)
+	} else {
+		newRegistry.MustRegister(promutil.NewPrometheusCollector(metrics))
+	}
 
-	// this might have a data race to access registry
+	s.mu.Lock()
 	s.registry = newRegistry
+	s.mu.Unlock()
 	log.Debug("Metrics scraped.")
+
+	if err != nil {
+		return err
+	}
+	if s.otlpExporter != nil {
+		if pushErr := s.otlpExporter.Push(ctx, metrics, time.Now()); pushErr != nil {
+			log.Error("Couldn't push metrics with OTLP: ", pushErr)
+			err = pushErr
+		} else {
+			log.Debug("Metrics pushed with OTLP.")
+		}
+	}
+	if s.remoteWriteExporter != nil {
+		if pushErr := s.remoteWriteExporter.Push(ctx, metrics, time.Now()); pushErr != nil {
+			log.Error("Couldn't push metrics with remote write: ", pushErr)
+			err = pushErr
+		} else {
+			log.Debug("Metrics pushed with remote write.")
+		}
+	}
+	return err
 }
@@ -2,72 +2,588 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net/url"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
 )
 
+// AllRegions can be used in the regions of a job to scrape every region
+// enabled for the account of each role.
+const AllRegions = "*"
+
+// maxDimensionFilters is the maximum number of dimension filters accepted by ListMetrics
+const maxDimensionFilters = 10
+
+// recentlyActivePeriod is the period of ListMetrics with RecentlyActive, the only supported one being PT3H
+const recentlyActivePeriod = 3 * 60 * 60
+
 type ScrapeConf struct {
 	ApiVersion      string             `yaml:"apiVersion"`
 	StsRegion       string             `yaml:"sts-region"`
 	Discovery       Discovery          `yaml:"discovery"`
 	Static          []*Static          `yaml:"static"`
 	CustomNamespace []*CustomNamespace `yaml:"customNamespace"`
+	Alarms          []*Alarms          `yaml:"alarms"`
+	LogsInsights    []*LogsInsights    `yaml:"logsInsights"`
+	// RDSEnhancedMonitoring are the jobs exporting the OS metrics of RDS Enhanced Monitoring
+	RDSEnhancedMonitoring []*RDSEnhancedMonitoring `yaml:"rdsEnhancedMonitoring"`
+	// Endpoints overrides the CloudWatch and tagging endpoints of the jobs without endpoints of their own
+	Endpoints *Endpoints `yaml:"endpoints"`
+	// LabelSanitization is the policy applied to the dimension and tag label values of the exported series
+	LabelSanitization LabelSanitization `yaml:"labelSanitization"`
+	// CredentialRefresh is how the clients of the roles and the credentials they assume are refreshed
+	CredentialRefresh CredentialRefresh `yaml:"credentialRefresh"`
+	// MetricDefaults are the global defaults of the settings of the metrics of all the jobs
+	MetricDefaults MetricDefaults `yaml:",inline"`
+	// DefaultRegion returns the region of the jobs without regions, e.g. from AWS_REGION, or an empty region when it
+	// can't be determined. It isn't read from the config file but set by the caller before Load. It's only called
+	// when a job has no regions, at most once per ScrapeConf: its result is kept across the Loads of the ScrapeConf.
+	DefaultRegion func() string `yaml:"-"`
+	// defaultRegion is the result of DefaultRegion once it's called
+	defaultRegion *string
+}
+
+// minAssumeRoleDuration is the shortest duration of an assumed role session accepted by STS
+const minAssumeRoleDuration = 15 * time.Minute
+
+// CredentialRefresh configures how the clients of the roles, and the credentials of the roles they assume, are refreshed
+type CredentialRefresh struct {
+	// SessionCacheTTL is how long the clients of the roles are reused across scrapes, with the credentials they
+	// assumed. They are created again for every scrape when zero.
+	SessionCacheTTL time.Duration `yaml:"sessionCacheTTL"`
+	// ExpiryWindow is how long before their expiry the assumed credentials are refreshed, even in the middle of
+	// a scrape, so that no request is signed with credentials about to expire
+	ExpiryWindow time.Duration `yaml:"expiryWindow"`
+	// Duration is the duration of the assumed role sessions, the default of the AWS SDK (15m) when zero
+	Duration time.Duration `yaml:"duration"`
+}
+
+func (r CredentialRefresh) validate() error {
+	if r.SessionCacheTTL < 0 {
+		return fmt.Errorf("CredentialRefresh: SessionCacheTTL should not be negative")
+	}
+	if r.ExpiryWindow < 0 {
+		return fmt.Errorf("CredentialRefresh: ExpiryWindow should not be negative")
+	}
+	if r.Duration != 0 && r.Duration < minAssumeRoleDuration {
+		return fmt.Errorf("CredentialRefresh: Duration should be at least %s", minAssumeRoleDuration)
+	}
+	duration := r.Duration
+	if duration == 0 {
+		duration = minAssumeRoleDuration
+	}
+	if r.ExpiryWindow >= duration {
+		return fmt.Errorf("CredentialRefresh: ExpiryWindow should be shorter than the Duration of the sessions (%s)", duration)
+	}
+	return nil
+}
+
+// Built-in defaults of NilToZero and AddCloudwatchTimestamp
+const (
+	DefaultNilToZero              = false
+	DefaultAddCloudwatchTimestamp = false
+)
+
+// MetricDefaults are settings of metrics which can be set globally, for every job, and for each job. They are
+// resolved when the configuration is validated, in this order: metric > job > global > built-in default.
+// StatisticSettings override the resolved metric settings in turn.
+type MetricDefaults struct {
+	NilToZero              *bool `yaml:"nilToZero"`
+	AddCloudwatchTimestamp *bool `yaml:"addCloudwatchTimestamp"`
+}
+
+// resolveBool returns the first of values which is set, or builtin when none is
+func resolveBool(builtin bool, values ...*bool) *bool {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
+	}
+	return aws.Bool(builtin)
+}
+
+// validate checks that the defaults don't enable both NilToZero and AddCloudwatchTimestamp, since missing
+// datapoints aren't exported with AddCloudwatchTimestamp
+func (d MetricDefaults) validate(parent string) error {
+	if aws.BoolValue(d.NilToZero) && aws.BoolValue(d.AddCloudwatchTimestamp) {
+		return fmt.Errorf("%v: NilToZero can not be enabled together with AddCloudwatchTimestamp", parent)
+	}
+	return nil
+}
+
+// resolveDefaults sets the NilToZero and AddCloudwatchTimestamp of m which aren't set to those of its job,
+// those of the global configuration, or the built-in defaults, in this order
+func (m *Metric) resolveDefaults(job MetricDefaults, global MetricDefaults) {
+	m.NilToZero = resolveBool(DefaultNilToZero, m.NilToZero, job.NilToZero, global.NilToZero)
+	m.AddCloudwatchTimestamp = resolveBool(DefaultAddCloudwatchTimestamp, m.AddCloudwatchTimestamp, job.AddCloudwatchTimestamp, global.AddCloudwatchTimestamp)
+}
+
+const (
+	// LabelSanitizationReplace replaces the invalid characters of label values and truncates those longer than MaxLength
+	LabelSanitizationReplace = "replace"
+	// LabelSanitizationDrop drops the labels whose value has invalid characters or is longer than MaxLength
+	LabelSanitizationDrop = "drop"
+)
+
+// LabelSanitization configures how the label values with invalid UTF-8 or control characters, or longer
+// than MaxLength bytes, are exported
+type LabelSanitization struct {
+	// Policy is LabelSanitizationReplace when empty
+	Policy string `yaml:"policy"`
+	// MaxLength is the maximum length of label values, unlimited when 0
+	MaxLength int `yaml:"maxLength"`
+}
+
+func (l *LabelSanitization) validate() error {
+	switch l.Policy {
+	case "", LabelSanitizationReplace, LabelSanitizationDrop:
+	default:
+		return fmt.Errorf("LabelSanitization: Policy %s is unknown, should be %s or %s", l.Policy, LabelSanitizationReplace, LabelSanitizationDrop)
+	}
+	if l.MaxLength < 0 {
+		return fmt.Errorf("LabelSanitization: MaxLength should not be negative")
+	}
+	return nil
+}
+
+// Endpoints are custom endpoint URLs of the CloudWatch and tagging clients, e.g. those of VPC interface
+// endpoints. The clients of the other services, STS included, keep their default endpoints.
+type Endpoints struct {
+	Cloudwatch string `yaml:"cloudwatch"`
+	Tagging    string `yaml:"tagging"`
+	// DisableEndpointResolution uses the URLs as is. By default the endpoint of the service is still
+	// resolved for the region of the client, for its signing region and name, and only its URL is replaced.
+	DisableEndpointResolution bool `yaml:"disableEndpointResolution"`
+}
+
+func (e *Endpoints) validate(parent string) error {
+	for _, endpoint := range []struct{ service, url string }{{"Cloudwatch", e.Cloudwatch}, {"Tagging", e.Tagging}} {
+		if endpoint.url == "" {
+			continue
+		}
+		u, err := url.Parse(endpoint.url)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("Endpoints in %v: %s endpoint %s should be an http or https URL", parent, endpoint.service, endpoint.url)
+		}
+	}
+	return nil
 }
 
 type Discovery struct {
 	ExportedTagsOnMetrics ExportedTagsOnMetrics `yaml:"exportedTagsOnMetrics"`
 	Jobs                  []*Job                `yaml:"jobs"`
+	Retry                 Retry                 `yaml:"retry"`
+	// Jitter is the maximum random delay before each job starts calling AWS,
+	// to spread the API calls of all the jobs instead of firing them at once
+	Jitter time.Duration `yaml:"jitter"`
+	// ListMetricsCacheTTL is how long ListMetrics responses are reused, nil or zero disables caching
+	ListMetricsCacheTTL *time.Duration `yaml:"listMetricsCacheTTL"`
+	// AccountAlias adds the IAM alias of the account of every job as the account_alias label
+	AccountAlias bool `yaml:"accountAlias"`
+	// RoleLabel adds the Alias, or else the RoleArn, of the role of every job as the role label, to tell apart
+	// the series of the resources scraped with several roles
+	RoleLabel bool `yaml:"roleLabel"`
+	// RateLimits pace the API requests of the jobs of every account and region
+	RateLimits RateLimits `yaml:"rateLimits"`
+	// CircuitBreaker stops sending the requests to the APIs of an account and region which keep failing, nil disables it
+	CircuitBreaker *CircuitBreaker `yaml:"circuitBreaker"`
+}
+
+// CircuitBreaker opens after Failures failed requests in a row to an API of an account and region. The requests are then
+// failed without being sent for Cooldown, after which a single request tests whether the API recovered.
+type CircuitBreaker struct {
+	Failures int           `yaml:"failures"`
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// GetFailures returns Failures, or model.DefaultCircuitBreakerFailures when not set
+func (c *CircuitBreaker) GetFailures() int {
+	if c.Failures > 0 {
+		return c.Failures
+	}
+	return model.DefaultCircuitBreakerFailures
+}
+
+// GetCooldown returns Cooldown, or model.DefaultCircuitBreakerCooldown when not set
+func (c *CircuitBreaker) GetCooldown() time.Duration {
+	if c.Cooldown > 0 {
+		return c.Cooldown
+	}
+	return model.DefaultCircuitBreakerCooldown
+}
+
+func (c *CircuitBreaker) validate() error {
+	if c.Failures < 0 {
+		return fmt.Errorf("Discovery circuit breaker: Failures should not be negative")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("Discovery circuit breaker: Cooldown should not be negative")
+	}
+	return nil
+}
+
+// RateLimits are the rate limits of the APIs whose requests are paced, per account and region. The requests
+// to an API without rate limit are only bounded by the concurrency limits.
+type RateLimits struct {
+	GetMetricData *RateLimit `yaml:"getMetricData"`
+	ListMetrics   *RateLimit `yaml:"listMetrics"`
+	// Tagging limits the GetResources requests to the Resource Groups Tagging API
+	Tagging *RateLimit `yaml:"tagging"`
+}
+
+// RateLimit is a token bucket: Burst requests can be sent at once, and the bucket refills
+// with RequestsPerSecond. Burst defaults to RequestsPerSecond rounded up.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+}
+
+// GetBurst returns Burst, or RequestsPerSecond rounded up when not set
+func (r *RateLimit) GetBurst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return int(math.Ceil(r.RequestsPerSecond))
+}
+
+func (r *RateLimits) validate() error {
+	for _, limit := range []struct {
+		api   string
+		limit *RateLimit
+	}{
+		{"GetMetricData", r.GetMetricData},
+		{"ListMetrics", r.ListMetrics},
+		{"Tagging", r.Tagging},
+	} {
+		if limit.limit == nil {
+			continue
+		}
+		if err := limit.limit.validate(limit.api); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RateLimit) validate(api string) error {
+	if r.RequestsPerSecond <= 0 {
+		return fmt.Errorf("Discovery rate limit of %s: RequestsPerSecond should be positive", api)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("Discovery rate limit of %s: Burst should not be negative", api)
+	}
+	return nil
+}
+
+// GetListMetricsCacheTTL returns ListMetricsCacheTTL, or 0, caching disabled, when not set
+func (d *Discovery) GetListMetricsCacheTTL() time.Duration {
+	if d.ListMetricsCacheTTL == nil {
+		return 0
+	}
+	return *d.ListMetricsCacheTTL
+}
+
+// Retry configures how failed CloudWatch API calls are retried. A zero value
+// disables retries.
+type Retry struct {
+	MaxAttempts int           `yaml:"maxAttempts"`
+	BaseDelay   time.Duration `yaml:"baseDelay"`
+	// PartialData queries the GetMetricData results which are still PartialData after their last page once more
+	PartialData bool `yaml:"partialData"`
 }
 
 type ExportedTagsOnMetrics map[string][]string
 
 type Job struct {
-	Regions                   []string    `yaml:"regions"`
-	Type                      string      `yaml:"type"`
-	Roles                     []Role      `yaml:"roles"`
-	SearchTags                []model.Tag `yaml:"searchTags"`
-	CustomTags                []model.Tag `yaml:"customTags"`
-	DimensionNameRequirements []string    `yaml:"dimensionNameRequirements"`
-	Metrics                   []*Metric   `yaml:"metrics"`
-	Length                    int64       `yaml:"length"`
-	Delay                     int64       `yaml:"delay"`
-	Period                    int64       `yaml:"period"`
-	RoundingPeriod            *int64      `yaml:"roundingPeriod"`
-	Statistics                []string    `yaml:"statistics"`
-	AddCloudwatchTimestamp    *bool       `yaml:"addCloudwatchTimestamp"`
-	NilToZero                 *bool       `yaml:"nilToZero"`
+	Regions                   []string                  `yaml:"regions"`
+	Type                      string                    `yaml:"type"`
+	Roles                     []Role                    `yaml:"roles"`
+	SearchTags                []model.Tag               `yaml:"searchTags"`
+	ExcludeTags               []model.Tag               `yaml:"excludeTags"`
+	CustomTags                []model.Tag               `yaml:"customTags"`
+	DimensionNameRequirements DimensionNameRequirements `yaml:"dimensionNameRequirements"`
+	Metrics                   []*Metric                 `yaml:"metrics"`
+	Length                    int64                     `yaml:"length"`
+	Delay                     int64                     `yaml:"delay"`
+	Period                    int64                     `yaml:"period"`
+	RoundingPeriod            *int64                    `yaml:"roundingPeriod"`
+	AlignToPeriod             bool                      `yaml:"alignToPeriod"`
+	Statistics                []string                  `yaml:"statistics"`
+	AddCloudwatchTimestamp    *bool                     `yaml:"addCloudwatchTimestamp"`
+	NilToZero                 *bool                     `yaml:"nilToZero"`
+	MetricPrefix              string                    `yaml:"metricPrefix"`
+	MetricRenames             map[string]string         `yaml:"metricRenames"`
+	Timeout                   time.Duration             `yaml:"timeout"`
+	// ScrapeInterval is how often the job is scraped when it's longer than the interval of the scrapes, e.g. for
+	// daily metrics. The scrapes in between export the results of its last successful scrape. Every scrape when 0.
+	ScrapeInterval time.Duration `yaml:"scrapeInterval"`
+	// ExportedNamespace replaces the part of the names of the metrics of the job derived from their namespace, e.g. aws_ec2
+	ExportedNamespace string `yaml:"exportedNamespace"`
+	// ResourceDiscovery selects how the resources of the job are discovered, ResourceDiscoveryTagging when empty
+	ResourceDiscovery string `yaml:"resourceDiscovery"`
+	// ConfigAggregator is the AWS Config aggregator queried with ResourceDiscoveryConfig. Without it, the
+	// resources recorded by AWS Config in the account and region of the job are queried.
+	ConfigAggregator *ConfigAggregator `yaml:"configAggregator"`
+	// MaxSeries limits the number of series queried by the job for each region and role, 0 means no limit
+	MaxSeries       int    `yaml:"maxSeries"`
+	OnLimitExceeded string `yaml:"onLimitExceeded"`
+	// IncrementalDiscovery reuses the resources discovered by the previous scrapes of the job
+	// instead of discovering all of them every scrape, only with ResourceDiscoveryConfig. Full discovery when nil.
+	IncrementalDiscovery *IncrementalDiscovery `yaml:"incrementalDiscovery"`
+	// DimensionValueRequirements only selects the metrics with dimension values matching all of them
+	DimensionValueRequirements []DimensionValueRequirement `yaml:"dimensionValueRequirements"`
+	// StaticDimensions are added to the dimensions of every metric queried by the job,
+	// except those the metric already has
+	StaticDimensions []Dimension `yaml:"staticDimensions"`
+	// ScanBy is the order of the datapoints returned by GetMetricData, ScanByTimestampDescending when empty
+	ScanBy string `yaml:"scanBy"`
+	// RelatedTags add tags of resources of other types, related to the metrics by a dimension, to the metrics of the job
+	RelatedTags []RelatedTags `yaml:"relatedTags"`
+	// Endpoints overrides the CloudWatch and tagging endpoints of the job
+	Endpoints *Endpoints `yaml:"endpoints"`
+	// ExportResourceUp exports yace_resource_up for every resource discovered by the job
+	ExportResourceUp bool `yaml:"exportResourceUp"`
+	// RecentlyActiveOnly only lists the metrics with data points in the last 3 hours
+	RecentlyActiveOnly bool `yaml:"recentlyActiveOnly"`
+	// CloudFormationStack only keeps the discovered resources which are resources of the stack
+	CloudFormationStack *CloudFormationStack `yaml:"cloudFormationStack"`
+	// DropDimensions are the dimensions of the metrics of the job not exported as labels, see Metric.DropDimensions
+	DropDimensions []string `yaml:"dropDimensions"`
+	// ExportedTagsMode is how the exportedTagsOnMetrics of the type of the job are exported,
+	// ExportedTagsModeLabels when empty
+	ExportedTagsMode string `yaml:"exportedTagsMode"`
+	// MaxDatapointAge drops the datapoints older than it at the time of the scrape, none when zero
+	MaxDatapointAge time.Duration `yaml:"maxDatapointAge"`
+	// SampleRatio is the share of the discovered resources, between 0 and 1, whose metrics are queried. The
+	// resources are selected by a hash of their ARN, so the same ones are selected every scrape. All when 0.
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// TagsOnMetrics returns the tags exported as labels of the metrics of the job, none with ExportedTagsModeInfo
+func (j *Job) TagsOnMetrics(tagsOnMetrics ExportedTagsOnMetrics) ExportedTagsOnMetrics {
+	if j.ExportedTagsMode == ExportedTagsModeInfo {
+		return nil
+	}
+	return tagsOnMetrics
+}
+
+// CloudFormationStack selects the resources of a CloudFormation stack, in the region and account of the job
+type CloudFormationStack struct {
+	// Name is the name or id of the stack
+	Name string `yaml:"name"`
+	// IncludeNestedStacks also selects the resources of the stacks nested in the stack, at any depth
+	IncludeNestedStacks bool `yaml:"includeNestedStacks"`
+}
+
+// RelatedTags exports Tags of the resources of Type, discovered in the region of the job, whose id is the
+// value of the Dimension of a metric, e.g. the tags of the EC2 instance of the InstanceId dimension
+type RelatedTags struct {
+	Type      string   `yaml:"type"`
+	Dimension string   `yaml:"dimension"`
+	Tags      []string `yaml:"tags"`
+}
+
+// IncrementalDiscovery configures the incremental resource discovery of a job
+type IncrementalDiscovery struct {
+	// FullRefreshInterval is the time after which all the resources are discovered again,
+	// DefaultFullRefreshInterval when 0
+	FullRefreshInterval time.Duration `yaml:"fullRefreshInterval"`
+}
+
+// DefaultFullRefreshInterval is the default interval between two full discoveries of a job with incremental discovery
+const DefaultFullRefreshInterval = time.Hour
+
+// GetFullRefreshInterval returns the interval between two full discoveries of the resources
+func (d *IncrementalDiscovery) GetFullRefreshInterval() time.Duration {
+	if d.FullRefreshInterval > 0 {
+		return d.FullRefreshInterval
+	}
+	return DefaultFullRefreshInterval
+}
+
+const (
+	// OnLimitExceededTruncate only queries the first MaxSeries series of a job exceeding its limit
+	OnLimitExceededTruncate = "truncate"
+	// OnLimitExceededSkip doesn't query any series of a job exceeding its limit
+	OnLimitExceededSkip = "skip"
+)
+
+const (
+	// ScanByTimestampDescending returns the most recent datapoint of the GetMetricData window first
+	ScanByTimestampDescending = "TimestampDescending"
+	// ScanByTimestampAscending returns the oldest datapoint of the GetMetricData window first
+	ScanByTimestampAscending = "TimestampAscending"
+)
+
+const (
+	// ExportedTagsModeLabels exports the tags as labels of every metric of the resources
+	ExportedTagsModeLabels = "labels"
+	// ExportedTagsModeInfo only exports the tags on the info series of the resources, joined to their metrics by the name label
+	ExportedTagsModeInfo = "info"
+)
+
+const (
+	// ResourceDiscoveryTagging discovers resources with the Resource Groups Tagging API of every region of the job
+	ResourceDiscoveryTagging = "tagging"
+	// ResourceDiscoveryConfig discovers resources with AWS Config advanced queries
+	ResourceDiscoveryConfig = "config"
+)
+
+// ConfigAggregator is an AWS Config aggregator, which lists the resources of several regions at once
+type ConfigAggregator struct {
+	Name   string `yaml:"name"`
+	Region string `yaml:"region"`
 }
 
 type Static struct {
-	Name       string      `yaml:"name"`
-	Regions    []string    `yaml:"regions"`
-	Roles      []Role      `yaml:"roles"`
-	Namespace  string      `yaml:"namespace"`
-	CustomTags []model.Tag `yaml:"customTags"`
-	Dimensions []Dimension `yaml:"dimensions"`
-	Metrics    []*Metric   `yaml:"metrics"`
+	Name          string            `yaml:"name"`
+	Regions       []string          `yaml:"regions"`
+	Roles         []Role            `yaml:"roles"`
+	Namespace     string            `yaml:"namespace"`
+	CustomTags    []model.Tag       `yaml:"customTags"`
+	Dimensions    []Dimension       `yaml:"dimensions"`
+	Metrics       []*Metric         `yaml:"metrics"`
+	MetricPrefix  string            `yaml:"metricPrefix"`
+	MetricRenames map[string]string `yaml:"metricRenames"`
+	Timeout       time.Duration     `yaml:"timeout"`
+	// ScrapeInterval is how often the job is scraped, see Job.ScrapeInterval
+	ScrapeInterval time.Duration `yaml:"scrapeInterval"`
+	// ExportedNamespace replaces the part of the names of the metrics of the job derived from their namespace
+	ExportedNamespace string `yaml:"exportedNamespace"`
+	// Endpoints overrides the CloudWatch endpoint of the job
+	Endpoints *Endpoints `yaml:"endpoints"`
+	// NilToZero and AddCloudwatchTimestamp are the defaults of the metrics of the job
+	NilToZero              *bool `yaml:"nilToZero"`
+	AddCloudwatchTimestamp *bool `yaml:"addCloudwatchTimestamp"`
+	// Period, Length and Delay are the defaults of the metrics of the job
+	Period int64 `yaml:"period"`
+	Length int64 `yaml:"length"`
+	Delay  int64 `yaml:"delay"`
 }
 
+// maxStatisticsDatapoints is the maximum number of datapoints returned by a GetMetricStatistics call
+const maxStatisticsDatapoints = 1440
+
 type CustomNamespace struct {
-	Regions                   []string    `yaml:"regions"`
-	Name                      string      `yaml:"name"`
-	Namespace                 string      `yaml:"namespace"`
-	Roles                     []Role      `yaml:"roles"`
-	Metrics                   []*Metric   `yaml:"metrics"`
-	Statistics                []string    `yaml:"statistics"`
-	NilToZero                 *bool       `yaml:"nilToZero"`
-	Period                    int64       `yaml:"period"`
-	Length                    int64       `yaml:"length"`
-	Delay                     int64       `yaml:"delay"`
-	AddCloudwatchTimestamp    *bool       `yaml:"addCloudwatchTimestamp"`
-	CustomTags                []model.Tag `yaml:"customTags"`
-	DimensionNameRequirements []string    `yaml:"dimensionNameRequirements"`
-	RoundingPeriod            *int64      `yaml:"roundingPeriod"`
+	Regions                   []string                  `yaml:"regions"`
+	Name                      string                    `yaml:"name"`
+	Namespace                 string                    `yaml:"namespace"`
+	Roles                     []Role                    `yaml:"roles"`
+	Metrics                   []*Metric                 `yaml:"metrics"`
+	Statistics                []string                  `yaml:"statistics"`
+	NilToZero                 *bool                     `yaml:"nilToZero"`
+	Period                    int64                     `yaml:"period"`
+	Length                    int64                     `yaml:"length"`
+	Delay                     int64                     `yaml:"delay"`
+	AddCloudwatchTimestamp    *bool                     `yaml:"addCloudwatchTimestamp"`
+	CustomTags                []model.Tag               `yaml:"customTags"`
+	DimensionNameRequirements DimensionNameRequirements `yaml:"dimensionNameRequirements"`
+	DimensionFilters          []Dimension               `yaml:"dimensionFilters"`
+	RoundingPeriod            *int64                    `yaml:"roundingPeriod"`
+	AlignToPeriod             bool                      `yaml:"alignToPeriod"`
+	MetricPrefix              string                    `yaml:"metricPrefix"`
+	MetricRenames             map[string]string         `yaml:"metricRenames"`
+	Timeout                   time.Duration             `yaml:"timeout"`
+	// ScrapeInterval is how often the job is scraped, see Job.ScrapeInterval
+	ScrapeInterval time.Duration `yaml:"scrapeInterval"`
+	// ExportedNamespace replaces the part of the names of the metrics of the job derived from their namespace
+	ExportedNamespace string `yaml:"exportedNamespace"`
+	MaxSeries         int    `yaml:"maxSeries"`
+	OnLimitExceeded   string `yaml:"onLimitExceeded"`
+	// DimensionValueRequirements only selects the metrics with dimension values matching all of them
+	DimensionValueRequirements []DimensionValueRequirement `yaml:"dimensionValueRequirements"`
+	// ScanBy is the order of the datapoints returned by GetMetricData, ScanByTimestampDescending when empty
+	ScanBy string `yaml:"scanBy"`
+	// IncludeLinkedAccounts also scrapes the metrics of the source accounts linked to the monitoring account
+	// of the job with CloudWatch cross-account observability, labeled with their own account id
+	IncludeLinkedAccounts bool `yaml:"includeLinkedAccounts"`
+	// Endpoints overrides the CloudWatch endpoint of the job
+	Endpoints *Endpoints `yaml:"endpoints"`
+	// RecentlyActiveOnly only lists the metrics with data points in the last 3 hours
+	RecentlyActiveOnly bool `yaml:"recentlyActiveOnly"`
+	// DropDimensions are the dimensions of the metrics of the job not exported as labels, see Metric.DropDimensions
+	DropDimensions []string `yaml:"dropDimensions"`
+	// MaxDatapointAge drops the datapoints older than it at the time of the scrape, none when zero
+	MaxDatapointAge time.Duration `yaml:"maxDatapointAge"`
+}
+
+// Alarms is a job exporting the state of the CloudWatch alarms of its regions and roles
+type Alarms struct {
+	Name    string   `yaml:"name"`
+	Regions []string `yaml:"regions"`
+	Roles   []Role   `yaml:"roles"`
+	// NamePrefix only selects the alarms whose name starts with it
+	NamePrefix string `yaml:"namePrefix"`
+	// Namespaces only selects the metric alarms on metrics of these namespaces.
+	// Composite alarms, which have no metric, are always selected.
+	Namespaces []string      `yaml:"namespaces"`
+	Timeout    time.Duration `yaml:"timeout"`
+	// Endpoints overrides the CloudWatch endpoint of the job
+	Endpoints *Endpoints `yaml:"endpoints"`
+}
+
+// maxLogsInsightsLogGroups and maxLogsInsightsLimit are the limits of the Logs Insights StartQuery API
+const (
+	maxLogsInsightsLogGroups = 50
+	maxLogsInsightsLimit     = 10000
+)
+
+// LogsInsights is a job exporting the numeric fields of the results of a CloudWatch Logs Insights query
+// as gauges, for each of its regions and roles
+type LogsInsights struct {
+	Name          string   `yaml:"name"`
+	Regions       []string `yaml:"regions"`
+	Roles         []Role   `yaml:"roles"`
+	LogGroupNames []string `yaml:"logGroupNames"`
+	Query         string   `yaml:"query"`
+	// Length is how far back the logs are queried in seconds, up to Delay seconds ago
+	Length int64 `yaml:"length"`
+	Delay  int64 `yaml:"delay"`
+	// Limit is the maximum number of rows returned by the query, the limit of the query itself when zero
+	Limit int64 `yaml:"limit"`
+	// LabelFields are the fields of the results exported as labels of the other fields of their row
+	LabelFields []string `yaml:"labelFields"`
+	// QueryTimeout is how long the results of the query are waited for
+	QueryTimeout time.Duration `yaml:"queryTimeout"`
+	// ExportPartialResults exports the results returned so far by a query which didn't complete within
+	// QueryTimeout, instead of nothing. The job is reported as failed either way.
+	ExportPartialResults bool          `yaml:"exportPartialResults"`
+	Timeout              time.Duration `yaml:"timeout"`
+}
+
+// RDSEnhancedMonitoringGroups are the groups of OS metrics of the RDS Enhanced Monitoring events which can be exported,
+// and DefaultRDSEnhancedMonitoringGroups the ones exported when a job doesn't select any
+var (
+	RDSEnhancedMonitoringGroups        = []string{"cpuUtilization", "loadAverageMinute", "memory", "swap", "tasks", "diskIO", "physicalDeviceIO", "fileSys", "network"}
+	DefaultRDSEnhancedMonitoringGroups = []string{"cpuUtilization", "loadAverageMinute", "memory", "diskIO"}
+)
+
+// RDSEnhancedMonitoring is a job exporting as gauges the OS metrics which RDS Enhanced Monitoring publishes to CloudWatch
+// Logs, for the RDS instances discovered with the tagging API in each of its regions and roles
+type RDSEnhancedMonitoring struct {
+	Name       string      `yaml:"name"`
+	Regions    []string    `yaml:"regions"`
+	Roles      []Role      `yaml:"roles"`
+	SearchTags []model.Tag `yaml:"searchTags"`
+	// ExportedTags are the tags of the instances exported as labels of their metrics
+	ExportedTags []string `yaml:"exportedTags"`
+	// Groups are the groups of OS metrics exported, DefaultRDSEnhancedMonitoringGroups when empty
+	Groups []string `yaml:"groups"`
+	// MaxAge is how old the latest OS metrics of an instance can be to be exported
+	MaxAge  time.Duration `yaml:"maxAge"`
+	Timeout time.Duration `yaml:"timeout"`
 }
 
 type Metric struct {
@@ -78,6 +594,387 @@ type Metric struct {
 	Delay                  int64    `yaml:"delay"`
 	NilToZero              *bool    `yaml:"nilToZero"`
 	AddCloudwatchTimestamp *bool    `yaml:"addCloudwatchTimestamp"`
+	ExportAllDataPoints    bool     `yaml:"exportAllDataPoints"`
+	PercentilesAsSummary   bool     `yaml:"percentilesAsSummary"`
+	PercentilesAsLabels    bool     `yaml:"percentilesAsLabels"`
+	DropNoData             bool     `yaml:"dropNoData"`
+	Id                     string   `yaml:"id"`
+	Expression             string   `yaml:"expression"`
+	// SampleCountAsCount exports the SampleCount statistic with a _count suffix instead of _sample_count,
+	// pairing it with the _sum of the Sum statistic
+	SampleCountAsCount bool `yaml:"sampleCountAsCount"`
+	// AnomalyDetection exports the anomaly detection band of the metric alongside it
+	AnomalyDetection *AnomalyDetection `yaml:"anomalyDetection"`
+	// StatisticSettings overrides the settings of the metric for some of its statistics
+	StatisticSettings map[string]*StatisticSettings `yaml:"statisticSettings"`
+	// TreatMissingData set to TreatMissingDataNotBreaching exports MissingDataValue for the
+	// series of the resources still discovered which returned no datapoint
+	TreatMissingData string   `yaml:"treatMissingData"`
+	MissingDataValue *float64 `yaml:"missingDataValue"`
+	// Unit restricts the datapoints of the metric to a CloudWatch unit, e.g. Bytes
+	Unit string `yaml:"unit"`
+	// ExportUnit exports the unit of the metric as a unit label
+	ExportUnit bool `yaml:"exportUnit"`
+	// NameRegex selects the metrics of the namespace with a matching name instead of Name. Each of
+	// them is scraped like a metric of its own with the settings of the metric, up to MaxNameRegexMatches.
+	NameRegex           string `yaml:"nameRegex"`
+	MaxNameRegexMatches int    `yaml:"maxNameRegexMatches"`
+	// Label is the GetMetricData label template of the metric, e.g. ${PROP('Dim.InstanceId')}.
+	// The label returned by CloudWatch is exported as the LabelAs label when it is set.
+	Label   string `yaml:"label"`
+	LabelAs string `yaml:"labelAs"`
+	// Transform converts the datapoints of the metric before they are exported, e.g. from bytes to GiB
+	Transform *Transform `yaml:"transform"`
+	// CarryForward exports the last datapoint of a series when a scrape returns none, until it's older than CarryForward
+	CarryForward time.Duration `yaml:"carryForward"`
+	// Type is the Prometheus type of the metric, MetricTypeGauge by default. Counters are named with a _total suffix.
+	// With Accumulate, they add up the datapoints of a series across scrapes instead of exporting the latest one.
+	Type       string `yaml:"type"`
+	Accumulate bool   `yaml:"accumulate"`
+	// DropDimensions are the dimensions of the metric still queried, but not exported as labels, in addition to
+	// those of the job. The series which only differ by them are merged, according to their statistic.
+	DropDimensions []string `yaml:"dropDimensions"`
+	// Help is the description of the metric exported as the help of its series, instead of the built-in one if any
+	Help string `yaml:"help"`
+	// Series are the series of the metric of a discovery job queried with their dimensions, without listing
+	// the metric with ListMetrics. They have the tags of the discovered resource their dimensions match, if any.
+	Series []MetricSeries `yaml:"series"`
+	// Aggregate is the metric math function, one of AggregateFunctions, aggregating all the series of the metric
+	// selected by the job into a single one per statistic, in place of the series
+	Aggregate string `yaml:"aggregate"`
+	// ExportTimestamp exports the CloudWatch timestamp of the exported datapoints of the metric as a
+	// <name>_timestamp_seconds gauge, which is sampled at scrape time whatever AddCloudwatchTimestamp
+	ExportTimestamp bool `yaml:"exportTimestamp"`
+	// AutoPeriod is set by period: auto. The period of the metric is then picked for every scrape, the
+	// finest one CloudWatch retains the datapoints of its window at, and Period is 0.
+	AutoPeriod bool `yaml:"-"`
+}
+
+// PeriodAuto is the period of the metrics whose period is picked for every scrape, see Metric.AutoPeriod
+const PeriodAuto = "auto"
+
+// UnmarshalYAML accepts PeriodAuto as period, setting AutoPeriod, besides a number of seconds
+func (m *Metric) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Metric
+	var period struct {
+		Period interface{} `yaml:"period"`
+	}
+	if err := unmarshal(&period); err != nil || period.Period != PeriodAuto {
+		return unmarshal((*plain)(m))
+	}
+
+	// The other fields are decoded without the period, which doesn't fit in Period
+	var fields yaml.MapSlice
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+	for i, field := range fields {
+		if field.Key == "period" {
+			fields = append(fields[:i], fields[i+1:]...)
+			break
+		}
+	}
+	out, err := yaml.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(out, (*plain)(m)); err != nil {
+		return err
+	}
+	m.AutoPeriod = true
+	return nil
+}
+
+// AggregateFunctions are the metric math functions aggregating the series of a metric
+var AggregateFunctions = []string{"SUM", "AVG", "MIN", "MAX"}
+
+// MetricSeries is a series of a metric, selected by the values of all its dimensions
+type MetricSeries struct {
+	Dimensions []Dimension `yaml:"dimensions"`
+}
+
+const (
+	MetricTypeGauge   = "gauge"
+	MetricTypeCounter = "counter"
+)
+
+// IsCounter returns whether m is exported as a Prometheus counter
+func (m *Metric) IsCounter() bool {
+	return m.Type == MetricTypeCounter
+}
+
+// RequestedUnit returns the unit the datapoints of m are restricted to, nil for any unit
+func (m *Metric) RequestedUnit() *string {
+	if m.Unit == "" {
+		return nil
+	}
+	return aws.String(m.Unit)
+}
+
+// LabelTemplate returns the GetMetricData label template of m, nil when it has none
+func (m *Metric) LabelTemplate() *string {
+	if m.Label == "" {
+		return nil
+	}
+	return aws.String(m.Label)
+}
+
+// DefaultMaxNameRegexMatches is the number of metric names a NameRegex expands to at most by default
+const DefaultMaxNameRegexMatches = 100
+
+// GetMaxNameRegexMatches returns the number of metric names the NameRegex of m expands to at most
+func (m *Metric) GetMaxNameRegexMatches() int {
+	if m.MaxNameRegexMatches > 0 {
+		return m.MaxNameRegexMatches
+	}
+	return DefaultMaxNameRegexMatches
+}
+
+// TreatMissingDataNotBreaching exports a value, 0 by default, instead of nothing or NaN for the series of existing resources without data
+const TreatMissingDataNotBreaching = "notBreaching"
+
+// NotBreachingValue returns the value exported for the series of m without data when
+// missing data is treated as not breaching, nil otherwise
+func (m *Metric) NotBreachingValue() *float64 {
+	if m.TreatMissingData != TreatMissingDataNotBreaching {
+		return nil
+	}
+	value := float64(0)
+	if m.MissingDataValue != nil {
+		value = *m.MissingDataValue
+	}
+	return &value
+}
+
+// StatisticSettings are the settings of a metric which can be set per statistic, unset ones are inherited from the metric
+type StatisticSettings struct {
+	NilToZero              *bool      `yaml:"nilToZero"`
+	AddCloudwatchTimestamp *bool      `yaml:"addCloudwatchTimestamp"`
+	Transform              *Transform `yaml:"transform"`
+}
+
+// NilToZeroFor returns the NilToZero setting of the statistic of m
+func (m *Metric) NilToZeroFor(statistic string) *bool {
+	if settings, ok := m.StatisticSettings[statistic]; ok && settings != nil && settings.NilToZero != nil {
+		return settings.NilToZero
+	}
+	return m.NilToZero
+}
+
+// AddCloudwatchTimestampFor returns the AddCloudwatchTimestamp setting of the statistic of m
+func (m *Metric) AddCloudwatchTimestampFor(statistic string) *bool {
+	if settings, ok := m.StatisticSettings[statistic]; ok && settings != nil && settings.AddCloudwatchTimestamp != nil {
+		return settings.AddCloudwatchTimestamp
+	}
+	return m.AddCloudwatchTimestamp
+}
+
+// TransformFor returns the Transform of the statistic of m, nil when its datapoints are exported as is.
+// The Transform of the metric doesn't apply to SampleCount, which counts datapoints whatever their unit.
+func (m *Metric) TransformFor(statistic string) *Transform {
+	if settings, ok := m.StatisticSettings[statistic]; ok && settings != nil && settings.Transform != nil {
+		return settings.Transform
+	}
+	if statistic == "SampleCount" {
+		return nil
+	}
+	return m.Transform
+}
+
+// Transform converts a datapoint to value * scale + Offset, where scale is the factor of the
+// named Conversion if any, else Scale, 1 when neither is set
+type Transform struct {
+	Scale      *float64 `yaml:"scale"`
+	Offset     float64  `yaml:"offset"`
+	Conversion string   `yaml:"conversion"`
+}
+
+// Conversions are the named conversions of Transform, by the factor they scale datapoints with
+var Conversions = map[string]float64{
+	"bitsToBytes":           1.0 / 8,
+	"bytesToKiB":            1.0 / (1 << 10),
+	"bytesToMiB":            1.0 / (1 << 20),
+	"bytesToGiB":            1.0 / (1 << 30),
+	"percentToRatio":        1.0 / 100,
+	"millisecondsToSeconds": 1e-3,
+	"microsecondsToSeconds": 1e-6,
+}
+
+// Apply returns the transformed value
+func (t *Transform) Apply(value float64) float64 {
+	scale := 1.0
+	if factor, ok := Conversions[t.Conversion]; ok {
+		scale = factor
+	} else if t.Scale != nil {
+		scale = *t.Scale
+	}
+	return value*scale + t.Offset
+}
+
+func (t *Transform) validate(parent string) error {
+	if t.Conversion != "" {
+		if _, ok := Conversions[t.Conversion]; !ok {
+			names := make([]string, 0, len(Conversions))
+			for name := range Conversions {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%v: Transform Conversion %s is unknown, should be one of %s", parent, t.Conversion, strings.Join(names, ", "))
+		}
+		if t.Scale != nil {
+			return fmt.Errorf("%v: Transform Scale can't be set together with Conversion", parent)
+		}
+	}
+	if t.Scale != nil && *t.Scale == 0 {
+		return fmt.Errorf("%v: Transform Scale should not be 0", parent)
+	}
+	return nil
+}
+
+// AnomalyDetection configures the CloudWatch anomaly detection band exported for a metric
+type AnomalyDetection struct {
+	// Band is the width of the band in standard deviations, DefaultAnomalyDetectionBand when 0
+	Band float64 `yaml:"band"`
+}
+
+// DefaultAnomalyDetectionBand is the width of the anomaly detection band used by CloudWatch by default
+const DefaultAnomalyDetectionBand = 2
+
+var (
+	accountIdRegexp = regexp.MustCompile(`^[0-9]{12}$`)
+	metricIdRegexp  = regexp.MustCompile(`^[a-z][a-zA-Z0-9_]*$`)
+	labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// roleSessionNameRegexp matches the session names accepted by STS
+	roleSessionNameRegexp = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+	// expressionIdRegexp matches the metric ids referenced in a metric math expression.
+	// CloudWatch functions are upper case, so anything starting lower case is an id.
+	expressionIdRegexp = regexp.MustCompile(`\b[a-z][a-zA-Z0-9_]*\b`)
+)
+
+// ExpressionIds returns the ids of the sibling metrics referenced by the
+// metric math expression of m, in order of first appearance.
+func (m *Metric) ExpressionIds() []string {
+	ids := []string{}
+	seen := map[string]struct{}{}
+	for _, id := range expressionIdRegexp.FindAllString(m.Expression, -1) {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// ReplaceExpressionIds returns the metric math expression of m with every
+// referenced metric id replaced by its value in ids.
+func (m *Metric) ReplaceExpressionIds(ids map[string]string) string {
+	return expressionIdRegexp.ReplaceAllStringFunc(m.Expression, func(id string) string {
+		if replacement, ok := ids[id]; ok {
+			return replacement
+		}
+		return id
+	})
+}
+
+// DimensionNameRequirements selects metrics by the names of their dimensions. Names only matches the metrics
+// with exactly these dimensions, Contains the ones with at least these dimensions, AnyOf the ones matching
+// any of its requirements and AllOf the ones matching all of them. The requirements which are set must all
+// match, and no requirement matches every metric. In YAML, a flat list of names is a shorthand for Names.
+type DimensionNameRequirements struct {
+	Names    []string                    `yaml:"-"`
+	Contains []string                    `yaml:"contains"`
+	AnyOf    []DimensionNameRequirements `yaml:"anyOf"`
+	AllOf    []DimensionNameRequirements `yaml:"allOf"`
+}
+
+// UnmarshalYAML accepts either a list of names, for Names, or a mapping of the other requirements
+func (r *DimensionNameRequirements) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var names []string
+	if err := unmarshal(&names); err == nil {
+		*r = DimensionNameRequirements{Names: names}
+		return nil
+	}
+	type plain DimensionNameRequirements
+	return unmarshal((*plain)(r))
+}
+
+// IsEmpty returns whether r has no requirement, matching every metric
+func (r DimensionNameRequirements) IsEmpty() bool {
+	return len(r.Names) == 0 && len(r.Contains) == 0 && len(r.AnyOf) == 0 && len(r.AllOf) == 0
+}
+
+// Matches returns whether a metric with the dimensions named dimensionNames meets r
+func (r DimensionNameRequirements) Matches(dimensionNames []string) bool {
+	if len(r.Names) > 0 {
+		if len(r.Names) != len(dimensionNames) || !containsAll(r.Names, dimensionNames) {
+			return false
+		}
+	}
+	if !containsAll(dimensionNames, r.Contains) {
+		return false
+	}
+	if len(r.AnyOf) > 0 {
+		matched := false
+		for _, requirement := range r.AnyOf {
+			if requirement.Matches(dimensionNames) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for _, requirement := range r.AllOf {
+		if !requirement.Matches(dimensionNames) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAll returns whether all the elements of subset are in set
+func containsAll(set []string, subset []string) bool {
+	for _, s := range subset {
+		found := false
+		for _, e := range set {
+			if e == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks that the nested requirements of r aren't empty, they would match every metric
+func (r DimensionNameRequirements) validate(parent string) error {
+	for idx, requirement := range r.AnyOf {
+		if requirement.IsEmpty() {
+			return fmt.Errorf("DimensionNameRequirements anyOf [%d] in %v: should not be empty", idx, parent)
+		}
+		if err := requirement.validate(parent); err != nil {
+			return err
+		}
+	}
+	for idx, requirement := range r.AllOf {
+		if requirement.IsEmpty() {
+			return fmt.Errorf("DimensionNameRequirements allOf [%d] in %v: should not be empty", idx, parent)
+		}
+		if err := requirement.validate(parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DimensionValueRequirement is met by the metrics having dimension Name with a value matching ValueRegex
+type DimensionValueRequirement struct {
+	Name       string `yaml:"name"`
+	ValueRegex string `yaml:"valueRegex"`
 }
 
 type Dimension struct {
@@ -88,6 +985,30 @@ type Dimension struct {
 type Role struct {
 	RoleArn    string `yaml:"roleArn"`
 	ExternalID string `yaml:"externalId"`
+	// SourceRole is assumed before RoleArn, for RoleArn to only be assumable
+	// from an intermediate role. It can itself have a SourceRole, forming a chain.
+	SourceRole *Role `yaml:"sourceRole"`
+	// Partition is the AWS partition of the role, e.g. aws-us-gov or aws-cn, used to resolve
+	// the endpoints of its clients. The standard aws partition is used when empty.
+	Partition string `yaml:"partition"`
+	// WebIdentityTokenFile assumes RoleArn with AssumeRoleWithWebIdentity and the token of the file,
+	// e.g. the service account token of IRSA, instead of the credentials of the exporter
+	WebIdentityTokenFile string `yaml:"webIdentityTokenFile"`
+	// RoleSessionName is the session name of the assumed role, generated when empty
+	RoleSessionName string `yaml:"roleSessionName"`
+	// AccountId is used as the account id of the role when it can't be determined with STS
+	AccountId string `yaml:"accountId"`
+	// Alias is the value of the role label of the metrics of the role with RoleLabel, its RoleArn when empty
+	Alias string `yaml:"alias"`
+	// CloudwatchConcurrency and TagConcurrency limit the concurrent API calls of the jobs of
+	// the role, within the global limits. Unlimited when 0.
+	CloudwatchConcurrency int `yaml:"cloudwatchConcurrency"`
+	TagConcurrency        int `yaml:"tagConcurrency"`
+	// Endpoints are the custom endpoints of the clients of the role, those of its job or the global
+	// ones. Roles with different endpoints have their own clients.
+	Endpoints Endpoints `yaml:"-"`
+	// CredentialRefresh is the global CredentialRefresh, applied to the credentials of the whole role chain
+	CredentialRefresh CredentialRefresh `yaml:"-"`
 }
 
 func (r *Role) ValidateRole(roleIdx int, parent string) error {
@@ -95,52 +1016,323 @@ func (r *Role) ValidateRole(roleIdx int, parent string) error {
 		return fmt.Errorf("Role [%d] in %v: RoleArn should not be empty", roleIdx, parent)
 	}
 
+	if r.AccountId != "" && !accountIdRegexp.MatchString(r.AccountId) {
+		return fmt.Errorf("Role [%d] in %v: AccountId should be a 12 digit AWS account id", roleIdx, parent)
+	}
+
+	for hop, hopIdx := r, 0; hop != nil; hop, hopIdx = hop.SourceRole, hopIdx+1 {
+		if hop.RoleSessionName != "" && !roleSessionNameRegexp.MatchString(hop.RoleSessionName) {
+			return fmt.Errorf("Role [%d] in %v: RoleSessionName of role chain hop [%d] should be 2 to 64 letters, digits or characters of +=,.@-", roleIdx, parent, hopIdx)
+		}
+		if hop.WebIdentityTokenFile == "" {
+			continue
+		}
+		if hop.RoleArn == "" {
+			return fmt.Errorf("Role [%d] in %v: RoleArn should not be empty with WebIdentityTokenFile", roleIdx, parent)
+		}
+		if hop.ExternalID != "" {
+			return fmt.Errorf("Role [%d] in %v: ExternalID can not be set together with WebIdentityTokenFile", roleIdx, parent)
+		}
+		// The web identity is the source of the credentials of the chain, the other roles are assumed with them
+		if hop.SourceRole != nil {
+			return fmt.Errorf("Role [%d] in %v: WebIdentityTokenFile can only be set on the first role of the chain, without SourceRole", roleIdx, parent)
+		}
+	}
+
+	if r.CloudwatchConcurrency < 0 || r.TagConcurrency < 0 {
+		return fmt.Errorf("Role [%d] in %v: CloudwatchConcurrency and TagConcurrency should not be negative", roleIdx, parent)
+	}
+
+	if r.Partition != "" && !isKnownPartition(r.Partition) {
+		return fmt.Errorf("Role [%d] in %v: Partition %s is not a known AWS partition", roleIdx, parent, r.Partition)
+	}
+
+	if r.SourceRole != nil {
+		for hop, hopIdx := r, 0; hop != nil; hop, hopIdx = hop.SourceRole, hopIdx+1 {
+			if hop.RoleArn == "" {
+				return fmt.Errorf("Role [%d] in %v: RoleArn should not be empty in role chain hop [%d]", roleIdx, parent, hopIdx)
+			}
+			// Roles can't be assumed across partitions, the whole chain uses the partition of the role
+			if hop.Partition != "" && hop.Partition != r.Partition {
+				return fmt.Errorf("Role [%d] in %v: Partition of role chain hop [%d] should be empty or the same as the role's", roleIdx, parent, hopIdx)
+			}
+		}
+	}
+
+	return nil
+}
+
+// isKnownPartition returns true for the ids of the partitions known to the AWS SDK, e.g. aws, aws-cn or aws-us-gov
+func isKnownPartition(id string) bool {
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain returns the roles to assume in order to assume r, starting with the
+// first SourceRole and ending with r itself.
+func (r Role) Chain() []Role {
+	var chain []Role
+	for hop := &r; hop != nil; hop = hop.SourceRole {
+		chain = append([]Role{*hop}, chain...)
+	}
+	return chain
+}
+
+// dedupeRoles makes identical role chains share the same SourceRole pointers,
+// so that roles can still be compared and used as map keys.
+func dedupeRoles(roles []Role, known map[Role]*Role) {
+	for i := range roles {
+		roles[i] = *dedupeRole(roles[i], known)
+	}
+}
+
+func dedupeRole(role Role, known map[Role]*Role) *Role {
+	if role.SourceRole != nil {
+		role.SourceRole = dedupeRole(*role.SourceRole, known)
+	}
+	if r, ok := known[role]; ok {
+		return r
+	}
+	known[role] = &role
+	return &role
+}
+
+// setRoleCredentialRefresh sets the credential refresh policy of the roles of a job to the global one
+func setRoleCredentialRefresh(roles []Role, refresh CredentialRefresh) {
+	for i := range roles {
+		roles[i].CredentialRefresh = refresh
+	}
+}
+
+// setRoleEndpoints sets the endpoints of the roles of a job to those of the job, or to the global ones
+func setRoleEndpoints(roles []Role, job *Endpoints, global *Endpoints) {
+	e := job
+	if e == nil {
+		e = global
+	}
+	if e == nil {
+		return
+	}
+	for i := range roles {
+		roles[i].Endpoints = *e
+	}
+}
+
+func (c *ScrapeConf) Load(file *string, validSvc func(string) bool) error {
+	yamlFile, err := os.ReadFile(*file)
+	if err != nil {
+		return err
+	}
+	err = yaml.Unmarshal(yamlFile, c)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range c.Discovery.Jobs {
+		if len(job.Roles) == 0 {
+			job.Roles = []Role{{}} // use current IAM role
+		}
+	}
+
+	for _, job := range c.CustomNamespace {
+		if len(job.Roles) == 0 {
+			job.Roles = []Role{{}} // use current IAM role
+		}
+	}
+
+	for _, job := range c.Static {
+		if len(job.Roles) == 0 {
+			job.Roles = []Role{{}} // use current IAM role
+		}
+	}
+
+	for _, job := range c.Alarms {
+		if len(job.Roles) == 0 {
+			job.Roles = []Role{{}} // use current IAM role
+		}
+	}
+
+	for _, job := range c.LogsInsights {
+		if len(job.Roles) == 0 {
+			job.Roles = []Role{{}} // use current IAM role
+		}
+		if job.Length == 0 {
+			job.Length = model.DefaultLengthSeconds
+		}
+		if job.QueryTimeout == 0 {
+			job.QueryTimeout = model.DefaultLogsInsightsQueryTimeout
+		}
+	}
+
+	for _, job := range c.RDSEnhancedMonitoring {
+		if len(job.Roles) == 0 {
+			job.Roles = []Role{{}} // use current IAM role
+		}
+		if len(job.Groups) == 0 {
+			job.Groups = DefaultRDSEnhancedMonitoringGroups
+		}
+		if job.MaxAge == 0 {
+			job.MaxAge = model.DefaultRDSEnhancedMonitoringMaxAge
+		}
+	}
+
+	if c.CredentialRefresh.ExpiryWindow == 0 {
+		c.CredentialRefresh.ExpiryWindow = model.DefaultCredentialExpiryWindow
+	}
+
+	if err := c.setDefaultRegions(); err != nil {
+		return err
+	}
+
+	err = c.Validate(validSvc)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range c.Discovery.Jobs {
+		setRoleCredentialRefresh(job.Roles, c.CredentialRefresh)
+	}
+	for _, job := range c.CustomNamespace {
+		setRoleCredentialRefresh(job.Roles, c.CredentialRefresh)
+	}
+	for _, job := range c.Static {
+		setRoleCredentialRefresh(job.Roles, c.CredentialRefresh)
+	}
+	for _, job := range c.Alarms {
+		setRoleCredentialRefresh(job.Roles, c.CredentialRefresh)
+	}
+	for _, job := range c.LogsInsights {
+		setRoleCredentialRefresh(job.Roles, c.CredentialRefresh)
+	}
+	for _, job := range c.RDSEnhancedMonitoring {
+		setRoleCredentialRefresh(job.Roles, c.CredentialRefresh)
+	}
+
+	for _, job := range c.Discovery.Jobs {
+		setRoleEndpoints(job.Roles, job.Endpoints, c.Endpoints)
+	}
+	for _, job := range c.CustomNamespace {
+		setRoleEndpoints(job.Roles, job.Endpoints, c.Endpoints)
+	}
+	for _, job := range c.Static {
+		setRoleEndpoints(job.Roles, job.Endpoints, c.Endpoints)
+	}
+	for _, job := range c.Alarms {
+		setRoleEndpoints(job.Roles, job.Endpoints, c.Endpoints)
+	}
+
+	knownRoles := map[Role]*Role{}
+	for _, job := range c.Discovery.Jobs {
+		dedupeRoles(job.Roles, knownRoles)
+	}
+	for _, job := range c.CustomNamespace {
+		dedupeRoles(job.Roles, knownRoles)
+	}
+	for _, job := range c.Static {
+		dedupeRoles(job.Roles, knownRoles)
+	}
+	for _, job := range c.Alarms {
+		dedupeRoles(job.Roles, knownRoles)
+	}
+	for _, job := range c.LogsInsights {
+		dedupeRoles(job.Roles, knownRoles)
+	}
+	for _, job := range c.RDSEnhancedMonitoring {
+		dedupeRoles(job.Roles, knownRoles)
+	}
 	return nil
 }
 
-func (c *ScrapeConf) Load(file *string, validSvc func(string) bool) error {
-	yamlFile, err := os.ReadFile(*file)
-	if err != nil {
-		return err
-	}
-	err = yaml.Unmarshal(yamlFile, c)
-	if err != nil {
-		return err
+// setDefaultRegions sets the regions of the jobs without regions to the default region, and fails when it can't
+// be determined
+func (c *ScrapeConf) setDefaultRegions() error {
+	setDefault := func(regions *[]string, parent string) error {
+		if len(*regions) > 0 {
+			return nil
+		}
+		if c.defaultRegion == nil {
+			var region string
+			if c.DefaultRegion != nil {
+				region = c.DefaultRegion()
+			}
+			c.defaultRegion = &region
+		}
+		if *c.defaultRegion == "" {
+			return fmt.Errorf("%v: Regions should not be empty when no default region is set", parent)
+		}
+		*regions = []string{*c.defaultRegion}
+		return nil
 	}
 
-	for _, job := range c.Discovery.Jobs {
-		if len(job.Roles) == 0 {
-			job.Roles = []Role{{}} // use current IAM role
+	for idx, job := range c.Discovery.Jobs {
+		if err := setDefault(&job.Regions, fmt.Sprintf("Discovery job [%s/%d]", job.Type, idx)); err != nil {
+			return err
 		}
 	}
-
-	for _, job := range c.CustomNamespace {
-		if len(job.Roles) == 0 {
-			job.Roles = []Role{{}} // use current IAM role
+	for idx, job := range c.CustomNamespace {
+		if err := setDefault(&job.Regions, fmt.Sprintf("CustomNamespace job [%s/%d]", job.Namespace, idx)); err != nil {
+			return err
 		}
 	}
-
-	for _, job := range c.Static {
-		if len(job.Roles) == 0 {
-			job.Roles = []Role{{}} // use current IAM role
+	for idx, job := range c.Static {
+		if err := setDefault(&job.Regions, fmt.Sprintf("Static job [%s/%d]", job.Name, idx)); err != nil {
+			return err
 		}
 	}
-
-	err = c.Validate(validSvc)
-	if err != nil {
-		return err
+	for idx, job := range c.Alarms {
+		if err := setDefault(&job.Regions, fmt.Sprintf("Alarms job [%s/%d]", job.Name, idx)); err != nil {
+			return err
+		}
+	}
+	for idx, job := range c.LogsInsights {
+		if err := setDefault(&job.Regions, fmt.Sprintf("LogsInsights job [%s/%d]", job.Name, idx)); err != nil {
+			return err
+		}
+	}
+	for idx, job := range c.RDSEnhancedMonitoring {
+		if err := setDefault(&job.Regions, fmt.Sprintf("RDSEnhancedMonitoring job [%s/%d]", job.Name, idx)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
 func (c *ScrapeConf) Validate(validSvc func(string) bool) error {
-	if c.Discovery.Jobs == nil && c.Static == nil && c.CustomNamespace == nil {
-		return fmt.Errorf("At least 1 Discovery job, 1 Static or one CustomNamespace must be defined")
+	if c.Discovery.Jobs == nil && c.Static == nil && c.CustomNamespace == nil && c.Alarms == nil && c.LogsInsights == nil && c.RDSEnhancedMonitoring == nil {
+		return fmt.Errorf("At least 1 Discovery job, 1 Static, one CustomNamespace, one Alarms, one LogsInsights or one RDSEnhancedMonitoring must be defined")
+	}
+
+	if c.Discovery.Retry.MaxAttempts < 0 {
+		return fmt.Errorf("Discovery retry: MaxAttempts should not be negative")
+	}
+	if c.Discovery.Retry.BaseDelay < 0 {
+		return fmt.Errorf("Discovery retry: BaseDelay should not be negative")
+	}
+	if c.Discovery.Jitter < 0 {
+		return fmt.Errorf("Discovery: Jitter should not be negative")
+	}
+	if c.Discovery.ListMetricsCacheTTL != nil && *c.Discovery.ListMetricsCacheTTL < 0 {
+		return fmt.Errorf("Discovery: ListMetricsCacheTTL should not be negative")
+	}
+	if err := c.Discovery.RateLimits.validate(); err != nil {
+		return err
+	}
+	if c.Discovery.CircuitBreaker != nil {
+		if err := c.Discovery.CircuitBreaker.validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.MetricDefaults.validate("Metric defaults"); err != nil {
+		return err
 	}
 
 	if c.Discovery.Jobs != nil {
 		for idx, job := range c.Discovery.Jobs {
-			err := job.validateDiscoveryJob(idx, validSvc)
+			err := job.validateDiscoveryJob(idx, validSvc, c.MetricDefaults)
 			if err != nil {
 				return err
 			}
@@ -149,7 +1341,7 @@ func (c *ScrapeConf) Validate(validSvc func(string) bool) error {
 
 	if c.CustomNamespace != nil {
 		for idx, job := range c.CustomNamespace {
-			err := job.validateCustomNamespaceJob(idx)
+			err := job.validateCustomNamespaceJob(idx, c.MetricDefaults)
 			if err != nil {
 				return err
 			}
@@ -158,12 +1350,45 @@ func (c *ScrapeConf) Validate(validSvc func(string) bool) error {
 
 	if c.Static != nil {
 		for idx, job := range c.Static {
-			err := job.validateStaticJob(idx)
+			err := job.validateStaticJob(idx, c.MetricDefaults)
 			if err != nil {
 				return err
 			}
 		}
 	}
+
+	for idx, job := range c.Alarms {
+		if err := job.validateAlarmsJob(idx); err != nil {
+			return err
+		}
+	}
+
+	for idx, job := range c.LogsInsights {
+		if err := job.validateLogsInsightsJob(idx); err != nil {
+			return err
+		}
+	}
+
+	for idx, job := range c.RDSEnhancedMonitoring {
+		if err := job.validateRDSEnhancedMonitoringJob(idx); err != nil {
+			return err
+		}
+	}
+
+	if c.Endpoints != nil {
+		if err := c.Endpoints.validate("global configuration"); err != nil {
+			return err
+		}
+	}
+
+	if err := c.LabelSanitization.validate(); err != nil {
+		return err
+	}
+
+	if err := c.CredentialRefresh.validate(); err != nil {
+		return err
+	}
+
 	if c.ApiVersion != "" && c.ApiVersion != "v1alpha1" {
 		return fmt.Errorf("apiVersion line missing or version is unknown (%s)", c.ApiVersion)
 	}
@@ -171,7 +1396,7 @@ func (c *ScrapeConf) Validate(validSvc func(string) bool) error {
 	return nil
 }
 
-func (j *Job) validateDiscoveryJob(jobIdx int, validSvc func(string) bool) error {
+func (j *Job) validateDiscoveryJob(jobIdx int, validSvc func(string) bool, defaults MetricDefaults) error {
 	if j.Type != "" {
 		if !validSvc(j.Type) {
 			return fmt.Errorf("Discovery job [%d]: Service is not in known list!: %s", jobIdx, j.Type)
@@ -180,6 +1405,14 @@ func (j *Job) validateDiscoveryJob(jobIdx int, validSvc func(string) bool) error
 		return fmt.Errorf("Discovery job [%d]: Type should not be empty", jobIdx)
 	}
 	parent := fmt.Sprintf("Discovery job [%s/%d]", j.Type, jobIdx)
+	if j.Endpoints != nil {
+		if err := j.Endpoints.validate(parent); err != nil {
+			return err
+		}
+	}
+	if j.CloudFormationStack != nil && j.CloudFormationStack.Name == "" {
+		return fmt.Errorf("CloudFormationStack in %v: Name should not be empty", parent)
+	}
 	if len(j.Roles) > 0 {
 		for roleIdx, role := range j.Roles {
 			if err := role.ValidateRole(roleIdx, parent); err != nil {
@@ -193,24 +1426,316 @@ func (j *Job) validateDiscoveryJob(jobIdx int, validSvc func(string) bool) error
 	if len(j.Metrics) == 0 {
 		return fmt.Errorf("Discovery job [%s/%d]: Metrics should not be empty", j.Type, jobIdx)
 	}
+	if err := (MetricDefaults{NilToZero: j.NilToZero, AddCloudwatchTimestamp: j.AddCloudwatchTimestamp}).validate(parent); err != nil {
+		return err
+	}
 	for metricIdx, metric := range j.Metrics {
+		if err := metric.validateConflicts(metricIdx, parent); err != nil {
+			return err
+		}
+		metric.resolveDefaults(MetricDefaults{NilToZero: j.NilToZero, AddCloudwatchTimestamp: j.AddCloudwatchTimestamp}, defaults)
+		metric.DropDimensions = mergeDropDimensions(j.DropDimensions, metric.DropDimensions)
 		err := metric.validateMetric(metricIdx, parent, j)
 		if err != nil {
 			return err
 		}
 	}
+	if j.RecentlyActiveOnly {
+		if err := validateRecentlyActiveOnly(j.Metrics, parent); err != nil {
+			return err
+		}
+	}
+	if err := validateExpressions(j.Metrics, parent); err != nil {
+		return err
+	}
+
+	if err := validateMetricRenames(j.MetricRenames, j.Metrics, parent); err != nil {
+		return err
+	}
+
+	if err := validateExportedNamespace(j.ExportedNamespace, parent); err != nil {
+		return err
+	}
+
+	if err := j.DimensionNameRequirements.validate(parent); err != nil {
+		return err
+	}
+
+	if err := validateDimensionValueRequirements(j.DimensionValueRequirements, parent); err != nil {
+		return err
+	}
+
+	if j.Timeout < 0 {
+		return fmt.Errorf("%v: Timeout should not be negative", parent)
+	}
+	if j.ScrapeInterval < 0 {
+		return fmt.Errorf("%v: ScrapeInterval should not be negative", parent)
+	}
+
+	if j.MaxDatapointAge < 0 {
+		return fmt.Errorf("%v: MaxDatapointAge should not be negative", parent)
+	}
+
+	if j.SampleRatio < 0 || j.SampleRatio > 1 {
+		return fmt.Errorf("%v: SampleRatio should be between 0 and 1, got %v", parent, j.SampleRatio)
+	}
+
+	if err := validateRounding(j.RoundingPeriod, j.AlignToPeriod, j.Metrics, parent); err != nil {
+		return err
+	}
+
+	if err := validateSeriesLimit(j.MaxSeries, j.OnLimitExceeded, parent); err != nil {
+		return err
+	}
+
+	if err := validateScanBy(j.ScanBy, parent); err != nil {
+		return err
+	}
+
+	switch j.ExportedTagsMode {
+	case "", ExportedTagsModeLabels, ExportedTagsModeInfo:
+	default:
+		return fmt.Errorf("%v: ExportedTagsMode %s is unknown, should be %s or %s", parent, j.ExportedTagsMode, ExportedTagsModeLabels, ExportedTagsModeInfo)
+	}
+
+	switch j.ResourceDiscovery {
+	case "", ResourceDiscoveryTagging:
+		if j.ConfigAggregator != nil {
+			return fmt.Errorf("%v: ConfigAggregator can only be set with ResourceDiscovery %s", parent, ResourceDiscoveryConfig)
+		}
+	case ResourceDiscoveryConfig:
+		if j.ConfigAggregator != nil && (j.ConfigAggregator.Name == "" || j.ConfigAggregator.Region == "") {
+			return fmt.Errorf("%v: ConfigAggregator name and region should not be empty", parent)
+		}
+	default:
+		return fmt.Errorf("%v: ResourceDiscovery %s is unknown, should be %s or %s", parent, j.ResourceDiscovery, ResourceDiscoveryTagging, ResourceDiscoveryConfig)
+	}
+
+	if j.IncrementalDiscovery != nil && j.IncrementalDiscovery.FullRefreshInterval < 0 {
+		return fmt.Errorf("%v: IncrementalDiscovery fullRefreshInterval should not be negative", parent)
+	}
+	if j.IncrementalDiscovery != nil && j.ResourceDiscovery != ResourceDiscoveryConfig {
+		return fmt.Errorf("%v: IncrementalDiscovery requires ResourceDiscovery %s, the tagging API can't list the changed resources", parent, ResourceDiscoveryConfig)
+	}
+
+	seenDimensions := make(map[string]struct{}, len(j.StaticDimensions))
+	for idx, dimension := range j.StaticDimensions {
+		if dimension.Name == "" || dimension.Value == "" {
+			return fmt.Errorf("StaticDimension [%d] in %v: Name and Value should not be empty", idx, parent)
+		}
+		if _, ok := seenDimensions[dimension.Name]; ok {
+			return fmt.Errorf("StaticDimension [%s/%d] in %v: Name is defined more than once", dimension.Name, idx, parent)
+		}
+		seenDimensions[dimension.Name] = struct{}{}
+	}
+
+	seenRelatedTags := make(map[string]struct{}, len(j.RelatedTags))
+	for idx, related := range j.RelatedTags {
+		if !validSvc(related.Type) {
+			return fmt.Errorf("RelatedTags [%s/%d] in %v: Service is not in known list!: %s", related.Type, idx, parent, related.Type)
+		}
+		if related.Dimension == "" {
+			return fmt.Errorf("RelatedTags [%s/%d] in %v: Dimension should not be empty", related.Type, idx, parent)
+		}
+		if len(related.Tags) == 0 {
+			return fmt.Errorf("RelatedTags [%s/%d] in %v: Tags should not be empty", related.Type, idx, parent)
+		}
+		// Related tags are exported as tag_<type>_<key>, the same type twice would export the same labels
+		if _, ok := seenRelatedTags[related.Type]; ok {
+			return fmt.Errorf("RelatedTags [%s/%d] in %v: Type is defined more than once", related.Type, idx, parent)
+		}
+		seenRelatedTags[related.Type] = struct{}{}
+	}
 
 	return nil
 }
 
-func (j *CustomNamespace) validateCustomNamespaceJob(jobIdx int) error {
+func (j *CustomNamespace) validateCustomNamespaceJob(jobIdx int, defaults MetricDefaults) error {
 	if j.Name == "" {
 		return fmt.Errorf("CustomNamespace job [%v]: Name should not be empty", jobIdx)
 	}
 	if j.Namespace == "" {
 		return fmt.Errorf("CustomNamespace job [%v]: Namespace should not be empty", jobIdx)
 	}
-	parent := fmt.Sprintf("CustomNamespace job [%s/%d]", j.Namespace, jobIdx)
+	parent := fmt.Sprintf("CustomNamespace job [%s/%d]", j.Namespace, jobIdx)
+	if j.Endpoints != nil {
+		if err := j.Endpoints.validate(parent); err != nil {
+			return err
+		}
+	}
+	if len(j.Roles) > 0 {
+		for roleIdx, role := range j.Roles {
+			if err := role.ValidateRole(roleIdx, parent); err != nil {
+				return err
+			}
+		}
+	}
+	if j.Regions == nil || len(j.Regions) == 0 {
+		return fmt.Errorf("CustomNamespace job [%s/%d]: Regions should not be empty", j.Name, jobIdx)
+	}
+	if err := (MetricDefaults{NilToZero: j.NilToZero, AddCloudwatchTimestamp: j.AddCloudwatchTimestamp}).validate(parent); err != nil {
+		return err
+	}
+	for metricIdx, metric := range j.Metrics {
+		if err := metric.validateConflicts(metricIdx, parent); err != nil {
+			return err
+		}
+		metric.resolveDefaults(MetricDefaults{NilToZero: j.NilToZero, AddCloudwatchTimestamp: j.AddCloudwatchTimestamp}, defaults)
+
+		if metric.Delay == 0 {
+			metric.Delay = j.Delay
+		}
+
+		if metric.Length == 0 {
+			metric.Length = j.Length
+		}
+
+		if metric.Period == 0 && !metric.AutoPeriod {
+			metric.Period = j.Period
+		}
+
+		if len(metric.Statistics) == 0 {
+			metric.Statistics = j.Statistics
+		}
+
+		metric.DropDimensions = mergeDropDimensions(j.DropDimensions, metric.DropDimensions)
+
+		err := metric.validateMetric(metricIdx, parent, nil)
+		if err != nil {
+			return err
+		}
+	}
+	if j.RecentlyActiveOnly {
+		if err := validateRecentlyActiveOnly(j.Metrics, parent); err != nil {
+			return err
+		}
+	}
+	if err := validateExpressions(j.Metrics, parent); err != nil {
+		return err
+	}
+
+	if err := validateMetricRenames(j.MetricRenames, j.Metrics, parent); err != nil {
+		return err
+	}
+
+	if err := validateExportedNamespace(j.ExportedNamespace, parent); err != nil {
+		return err
+	}
+
+	if err := j.DimensionNameRequirements.validate(parent); err != nil {
+		return err
+	}
+
+	if err := validateDimensionValueRequirements(j.DimensionValueRequirements, parent); err != nil {
+		return err
+	}
+
+	if j.Timeout < 0 {
+		return fmt.Errorf("%v: Timeout should not be negative", parent)
+	}
+	if j.ScrapeInterval < 0 {
+		return fmt.Errorf("%v: ScrapeInterval should not be negative", parent)
+	}
+
+	if j.MaxDatapointAge < 0 {
+		return fmt.Errorf("%v: MaxDatapointAge should not be negative", parent)
+	}
+
+	if err := validateRounding(j.RoundingPeriod, j.AlignToPeriod, j.Metrics, parent); err != nil {
+		return err
+	}
+
+	if err := validateSeriesLimit(j.MaxSeries, j.OnLimitExceeded, parent); err != nil {
+		return err
+	}
+
+	if err := validateScanBy(j.ScanBy, parent); err != nil {
+		return err
+	}
+
+	if len(j.DimensionFilters) > maxDimensionFilters {
+		return fmt.Errorf("%v: DimensionFilters should not have more than %d entries", parent, maxDimensionFilters)
+	}
+	for filterIdx, filter := range j.DimensionFilters {
+		if filter.Name == "" {
+			return fmt.Errorf("%v: Name should not be empty in dimension filter [%d]", parent, filterIdx)
+		}
+	}
+
+	return nil
+}
+
+// validateDimensionValueRequirements checks that the dimension value requirements of a job have a name and a valid regex
+func validateDimensionValueRequirements(requirements []DimensionValueRequirement, parent string) error {
+	for idx, requirement := range requirements {
+		if requirement.Name == "" {
+			return fmt.Errorf("DimensionValueRequirement [%d] in %v: Name should not be empty", idx, parent)
+		}
+		if _, err := regexp.Compile(requirement.ValueRegex); err != nil {
+			return fmt.Errorf("DimensionValueRequirement [%s/%d] in %v: ValueRegex is invalid: %w", requirement.Name, idx, parent, err)
+		}
+	}
+	return nil
+}
+
+// validateSeriesLimit checks the series limit of a job
+func validateSeriesLimit(maxSeries int, onLimitExceeded string, parent string) error {
+	if maxSeries < 0 {
+		return fmt.Errorf("%v: MaxSeries should not be negative", parent)
+	}
+	switch onLimitExceeded {
+	case "", OnLimitExceededTruncate, OnLimitExceededSkip:
+		return nil
+	default:
+		return fmt.Errorf("%v: OnLimitExceeded %s is unknown, should be %s or %s", parent, onLimitExceeded, OnLimitExceededTruncate, OnLimitExceededSkip)
+	}
+}
+
+func validateScanBy(scanBy string, parent string) error {
+	switch scanBy {
+	case "", ScanByTimestampDescending, ScanByTimestampAscending:
+		return nil
+	default:
+		return fmt.Errorf("%v: ScanBy %s is unknown, should be %s or %s", parent, scanBy, ScanByTimestampDescending, ScanByTimestampAscending)
+	}
+}
+
+// validateRounding checks that the periods of the metrics fall on the boundaries of an explicit
+// rounding period when the GetMetricData window is aligned to it
+func validateRounding(roundingPeriod *int64, alignToPeriod bool, metrics []*Metric, parent string) error {
+	if roundingPeriod == nil {
+		return nil
+	}
+	if *roundingPeriod < 0 {
+		return fmt.Errorf("%v: RoundingPeriod should not be negative", parent)
+	}
+	if !alignToPeriod {
+		return nil
+	}
+	if *roundingPeriod == 0 {
+		return fmt.Errorf("%v: RoundingPeriod should not be 0 with AlignToPeriod", parent)
+	}
+	for _, metric := range metrics {
+		if metric.Expression == "" && metric.Period > 0 && *roundingPeriod%metric.Period != 0 {
+			return fmt.Errorf("%v: RoundingPeriod %d should be a multiple of the period %d of metric %s with AlignToPeriod", parent, *roundingPeriod, metric.Period, metric.Name)
+		}
+	}
+	return nil
+}
+
+func (j *Static) validateStaticJob(jobIdx int, defaults MetricDefaults) error {
+	if j.Name == "" {
+		return fmt.Errorf("Static job [%v]: Name should not be empty", jobIdx)
+	}
+	if j.Namespace == "" {
+		return fmt.Errorf("Static job [%s/%d]: Namespace should not be empty", j.Name, jobIdx)
+	}
+	parent := fmt.Sprintf("Static job [%s/%d]", j.Name, jobIdx)
+	if j.Endpoints != nil {
+		if err := j.Endpoints.validate(parent); err != nil {
+			return err
+		}
+	}
 	if len(j.Roles) > 0 {
 		for roleIdx, role := range j.Roles {
 			if err := role.ValidateRole(roleIdx, parent); err != nil {
@@ -218,78 +1743,209 @@ func (j *CustomNamespace) validateCustomNamespaceJob(jobIdx int) error {
 			}
 		}
 	}
-	if j.Regions == nil || len(j.Regions) == 0 {
-		return fmt.Errorf("CustomNamespace job [%s/%d]: Regions should not be empty", j.Name, jobIdx)
+	if len(j.Regions) == 0 {
+		return fmt.Errorf("Static job [%s/%d]: Regions should not be empty", j.Name, jobIdx)
+	}
+	if err := (MetricDefaults{NilToZero: j.NilToZero, AddCloudwatchTimestamp: j.AddCloudwatchTimestamp}).validate(parent); err != nil {
+		return err
 	}
 	for metricIdx, metric := range j.Metrics {
-		if metric.AddCloudwatchTimestamp == nil {
-			metric.AddCloudwatchTimestamp = j.AddCloudwatchTimestamp
+		if metric.Expression != "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: Expression is not supported in static jobs", metric.Name, metricIdx, parent)
 		}
-
-		if metric.Delay == 0 {
-			metric.Delay = j.Delay
+		if metric.AnomalyDetection != nil {
+			return fmt.Errorf("Metric [%s/%d] in %v: AnomalyDetection is not supported in static jobs", metric.Name, metricIdx, parent)
 		}
-
-		if metric.Length == 0 {
-			metric.Length = j.Length
+		if len(metric.StatisticSettings) > 0 {
+			return fmt.Errorf("Metric [%s/%d] in %v: StatisticSettings is not supported in static jobs", metric.Name, metricIdx, parent)
+		}
+		if metric.NameRegex != "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: NameRegex is not supported in static jobs", metric.Name, metricIdx, parent)
+		}
+		if metric.Aggregate != "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: Aggregate is not supported in static jobs", metric.Name, metricIdx, parent)
+		}
+		if metric.Label != "" || metric.LabelAs != "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: Label and LabelAs are not supported in static jobs", metric.Name, metricIdx, parent)
+		}
+		if metric.AutoPeriod {
+			return fmt.Errorf("Metric [%s/%d] in %v: Period auto is not supported in static jobs", metric.Name, metricIdx, parent)
+		}
+		if err := metric.validateConflicts(metricIdx, parent); err != nil {
+			return err
+		}
+		metric.resolveDefaults(MetricDefaults{NilToZero: j.NilToZero, AddCloudwatchTimestamp: j.AddCloudwatchTimestamp}, defaults)
+		if err := metric.validateNilToZero(metric.Statistics, metricIdx, parent); err != nil {
+			return err
+		}
+		if err := metric.validateSampleCountAsCount(metric.Statistics, metricIdx, parent); err != nil {
+			return err
 		}
 
 		if metric.Period == 0 {
 			metric.Period = j.Period
 		}
-
-		if metric.NilToZero == nil {
-			metric.NilToZero = j.NilToZero
+		if metric.Length == 0 {
+			metric.Length = j.Length
 		}
-
-		if len(metric.Statistics) == 0 {
-			metric.Statistics = j.Statistics
+		if metric.Length == 0 {
+			metric.Length = metric.Period
+		}
+		if metric.Delay == 0 {
+			metric.Delay = j.Delay
 		}
 
 		err := metric.validateMetric(metricIdx, parent, nil)
 		if err != nil {
 			return err
 		}
+		if err := metric.validateStatisticsWindow(metricIdx, parent); err != nil {
+			return err
+		}
+	}
+
+	if err := validateMetricRenames(j.MetricRenames, j.Metrics, parent); err != nil {
+		return err
+	}
+
+	if err := validateExportedNamespace(j.ExportedNamespace, parent); err != nil {
+		return err
+	}
+
+	if j.Timeout < 0 {
+		return fmt.Errorf("%v: Timeout should not be negative", parent)
+	}
+	if j.ScrapeInterval < 0 {
+		return fmt.Errorf("%v: ScrapeInterval should not be negative", parent)
 	}
 
 	return nil
 }
 
-func (j *Static) validateStaticJob(jobIdx int) error {
+func (j *Alarms) validateAlarmsJob(jobIdx int) error {
 	if j.Name == "" {
-		return fmt.Errorf("Static job [%v]: Name should not be empty", jobIdx)
+		return fmt.Errorf("Alarms job [%v]: Name should not be empty", jobIdx)
 	}
-	if j.Namespace == "" {
-		return fmt.Errorf("Static job [%s/%d]: Namespace should not be empty", j.Name, jobIdx)
+	parent := fmt.Sprintf("Alarms job [%s/%d]", j.Name, jobIdx)
+	if j.Endpoints != nil {
+		if err := j.Endpoints.validate(parent); err != nil {
+			return err
+		}
 	}
-	parent := fmt.Sprintf("Static job [%s/%d]", j.Name, jobIdx)
-	if len(j.Roles) > 0 {
-		for roleIdx, role := range j.Roles {
-			if err := role.ValidateRole(roleIdx, parent); err != nil {
-				return err
-			}
+	for roleIdx, role := range j.Roles {
+		if err := role.ValidateRole(roleIdx, parent); err != nil {
+			return err
 		}
 	}
 	if len(j.Regions) == 0 {
-		return fmt.Errorf("Static job [%s/%d]: Regions should not be empty", j.Name, jobIdx)
+		return fmt.Errorf("%v: Regions should not be empty", parent)
 	}
-	for metricIdx, metric := range j.Metrics {
-		err := metric.validateMetric(metricIdx, parent, nil)
-		if err != nil {
+	for _, namespace := range j.Namespaces {
+		if namespace == "" {
+			return fmt.Errorf("%v: Namespaces should not be empty strings", parent)
+		}
+	}
+	if j.Timeout < 0 {
+		return fmt.Errorf("%v: Timeout should not be negative", parent)
+	}
+	return nil
+}
+
+func (j *LogsInsights) validateLogsInsightsJob(jobIdx int) error {
+	if j.Name == "" {
+		return fmt.Errorf("LogsInsights job [%v]: Name should not be empty", jobIdx)
+	}
+	parent := fmt.Sprintf("LogsInsights job [%s/%d]", j.Name, jobIdx)
+	for roleIdx, role := range j.Roles {
+		if err := role.ValidateRole(roleIdx, parent); err != nil {
 			return err
 		}
 	}
+	if len(j.Regions) == 0 {
+		return fmt.Errorf("%v: Regions should not be empty", parent)
+	}
+	if len(j.LogGroupNames) == 0 {
+		return fmt.Errorf("%v: LogGroupNames should not be empty", parent)
+	}
+	if len(j.LogGroupNames) > maxLogsInsightsLogGroups {
+		return fmt.Errorf("%v: LogGroupNames should not contain more than %d log groups", parent, maxLogsInsightsLogGroups)
+	}
+	for _, name := range j.LogGroupNames {
+		if name == "" {
+			return fmt.Errorf("%v: LogGroupNames should not be empty strings", parent)
+		}
+	}
+	if j.Query == "" {
+		return fmt.Errorf("%v: Query should not be empty", parent)
+	}
+	if j.Length < 0 {
+		return fmt.Errorf("%v: Length should not be negative", parent)
+	}
+	if j.Delay < 0 {
+		return fmt.Errorf("%v: Delay should not be negative", parent)
+	}
+	if j.Limit < 0 || j.Limit > maxLogsInsightsLimit {
+		return fmt.Errorf("%v: Limit should be between 0 and %d", parent, maxLogsInsightsLimit)
+	}
+	labelFields := make(map[string]struct{}, len(j.LabelFields))
+	for _, field := range j.LabelFields {
+		if field == "" {
+			return fmt.Errorf("%v: LabelFields should not be empty strings", parent)
+		}
+		if _, ok := labelFields[field]; ok {
+			return fmt.Errorf("%v: LabelField %s is defined more than once", parent, field)
+		}
+		switch field {
+		case "query", "log_group", "region", "account_id", "account_alias":
+			return fmt.Errorf("%v: LabelField %s is already used by the exporter", parent, field)
+		}
+		labelFields[field] = struct{}{}
+	}
+	if j.QueryTimeout < 0 {
+		return fmt.Errorf("%v: QueryTimeout should not be negative", parent)
+	}
+	if j.Timeout < 0 {
+		return fmt.Errorf("%v: Timeout should not be negative", parent)
+	}
+	return nil
+}
 
+// validateStatisticsWindow checks the window of a metric of a static job against the limits of
+// GetMetricStatistics: a period of 1, 5, 10 or 30 seconds for high resolution metrics or else a
+// multiple of 60 seconds, and at most maxStatisticsDatapoints periods in the window.
+func (m *Metric) validateStatisticsWindow(metricIdx int, parent string) error {
+	switch {
+	case m.Period == 1, m.Period == 5, m.Period == 10, m.Period == 30, m.Period%60 == 0:
+	default:
+		return fmt.Errorf("Metric [%s/%d] in %v: Period should be 1, 5, 10, 30 or a multiple of 60", m.Name, metricIdx, parent)
+	}
+	if m.Length < m.Period {
+		return fmt.Errorf("Metric [%s/%d] in %v: Length should not be lower than Period", m.Name, metricIdx, parent)
+	}
+	if m.Length/m.Period > maxStatisticsDatapoints {
+		return fmt.Errorf("Metric [%s/%d] in %v: Length should not be more than %d times Period", m.Name, metricIdx, parent, maxStatisticsDatapoints)
+	}
+	if m.Delay < 0 {
+		return fmt.Errorf("Metric [%s/%d] in %v: Delay should not be negative", m.Name, metricIdx, parent)
+	}
 	return nil
 }
 
 func (m *Metric) validateMetric(metricIdx int, parent string, discovery *Job) error {
-	if m.Name == "" {
+	if m.NameRegex != "" {
+		if err := m.validateNameRegex(metricIdx, parent); err != nil {
+			return err
+		}
+	} else if m.Name == "" {
 		return fmt.Errorf("Metric [%s/%d] in %v: Name should not be empty", m.Name, metricIdx, parent)
 	}
 
+	if m.Id != "" && !metricIdRegexp.MatchString(m.Id) {
+		return fmt.Errorf("Metric [%s/%d] in %v: Id should start with a lowercase letter and contain only letters, numbers and underscores", m.Name, metricIdx, parent)
+	}
+
 	mStatistics := m.Statistics
-	if len(mStatistics) == 0 && discovery != nil {
+	if len(mStatistics) == 0 && discovery != nil && m.Expression == "" {
 		if len(discovery.Statistics) > 0 {
 			mStatistics = discovery.Statistics
 		} else {
@@ -298,14 +1954,14 @@ func (m *Metric) validateMetric(metricIdx int, parent string, discovery *Job) er
 	}
 
 	mPeriod := m.Period
-	if mPeriod == 0 && discovery != nil {
+	if mPeriod == 0 && discovery != nil && !m.AutoPeriod {
 		if discovery.Period != 0 {
 			mPeriod = discovery.Period
 		} else {
 			mPeriod = model.DefaultPeriodSeconds
 		}
 	}
-	if mPeriod < 1 {
+	if mPeriod < 1 && !m.AutoPeriod {
 		return fmt.Errorf("Metric [%s/%d] in %v: Period value should be a positive integer", m.Name, metricIdx, parent)
 	}
 	mLength := m.Length
@@ -317,30 +1973,168 @@ func (m *Metric) validateMetric(metricIdx int, parent string, discovery *Job) er
 		}
 	}
 
+	// The GetMetricData window of a metric ends the delay of its job before the scrape unless it has its own
 	mDelay := m.Delay
 	if mDelay == 0 && discovery != nil {
-		if discovery.Delay != 0 {
-			mDelay = discovery.Delay
-		} else {
-			mDelay = model.DefaultDelaySeconds
+		mDelay = discovery.Delay
+	}
+
+	if m.ExportAllDataPoints && (m.AddCloudwatchTimestamp == nil || !*m.AddCloudwatchTimestamp) {
+		return fmt.Errorf("Metric [%s/%d] in %v: ExportAllDataPoints can only be enabled together with AddCloudwatchTimestamp", m.Name, metricIdx, parent)
+	}
+	// Expressions are exported as the Expression statistic
+	statistics := mStatistics
+	if m.Expression != "" {
+		statistics = []string{"Expression"}
+	}
+	if err := m.validateNilToZero(statistics, metricIdx, parent); err != nil {
+		return err
+	}
+
+	for statistic, settings := range m.StatisticSettings {
+		found := false
+		for _, mStatistic := range mStatistics {
+			if mStatistic == statistic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Metric [%s/%d] in %v: StatisticSettings of %s which is not a statistic of the metric", m.Name, metricIdx, parent, statistic)
+		}
+		if m.ExportAllDataPoints && settings != nil && settings.AddCloudwatchTimestamp != nil && !*settings.AddCloudwatchTimestamp {
+			return fmt.Errorf("Metric [%s/%d] in %v: ExportAllDataPoints can only be enabled together with AddCloudwatchTimestamp, which is disabled for %s", m.Name, metricIdx, parent, statistic)
+		}
+		if settings != nil && settings.Transform != nil {
+			if err := settings.Transform.validate(fmt.Sprintf("Metric [%s/%d] in %v, statistic %s", m.Name, metricIdx, parent, statistic)); err != nil {
+				return err
+			}
 		}
 	}
 
-	mNilToZero := m.NilToZero
-	if mNilToZero == nil && discovery != nil {
-		if discovery.NilToZero != nil {
-			mNilToZero = discovery.NilToZero
-		} else {
-			mNilToZero = aws.Bool(false)
+	if m.Transform != nil {
+		if err := m.Transform.validate(fmt.Sprintf("Metric [%s/%d] in %v", m.Name, metricIdx, parent)); err != nil {
+			return err
 		}
 	}
 
-	mAddCloudwatchTimestamp := m.AddCloudwatchTimestamp
-	if mAddCloudwatchTimestamp == nil && discovery != nil {
-		if discovery.AddCloudwatchTimestamp != nil {
-			mAddCloudwatchTimestamp = discovery.AddCloudwatchTimestamp
-		} else {
-			mAddCloudwatchTimestamp = aws.Bool(false)
+	if m.Unit != "" {
+		if m.Expression != "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: Unit is not supported for expressions", m.Name, metricIdx, parent)
+		}
+		found := false
+		for _, unit := range cloudwatch.StandardUnit_Values() {
+			if unit == m.Unit {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("Metric [%s/%d] in %v: Unit %s is not a CloudWatch unit", m.Name, metricIdx, parent, m.Unit)
+		}
+	}
+
+	if m.LabelAs != "" {
+		if m.Label == "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: LabelAs can only be set together with Label", m.Name, metricIdx, parent)
+		}
+		if !labelNameRegexp.MatchString(m.LabelAs) || strings.HasPrefix(m.LabelAs, "__") {
+			return fmt.Errorf("Metric [%s/%d] in %v: LabelAs %s is not a valid Prometheus label name", m.Name, metricIdx, parent, m.LabelAs)
+		}
+		switch m.LabelAs {
+		case "name", "region", "account_id", "account_alias", "role", "unit", "quantile":
+			return fmt.Errorf("Metric [%s/%d] in %v: LabelAs %s is already used by the exporter", m.Name, metricIdx, parent, m.LabelAs)
+		}
+	}
+
+	switch m.TreatMissingData {
+	case "":
+		if m.MissingDataValue != nil {
+			return fmt.Errorf("Metric [%s/%d] in %v: MissingDataValue can only be set together with TreatMissingData %s", m.Name, metricIdx, parent, TreatMissingDataNotBreaching)
+		}
+	case TreatMissingDataNotBreaching:
+		if discovery == nil {
+			return fmt.Errorf("Metric [%s/%d] in %v: TreatMissingData is only supported in discovery jobs", m.Name, metricIdx, parent)
+		}
+	default:
+		return fmt.Errorf("Metric [%s/%d] in %v: TreatMissingData %s is unknown, should be %s", m.Name, metricIdx, parent, m.TreatMissingData, TreatMissingDataNotBreaching)
+	}
+
+	if m.Aggregate != "" {
+		if !containsAll(AggregateFunctions, []string{m.Aggregate}) {
+			return fmt.Errorf("Metric [%s/%d] in %v: Aggregate %s is unknown, should be one of %s", m.Name, metricIdx, parent, m.Aggregate, strings.Join(AggregateFunctions, ", "))
+		}
+		if m.Id != "" || m.Expression != "" || m.AnomalyDetection != nil || m.PercentilesAsSummary {
+			return fmt.Errorf("Metric [%s/%d] in %v: Aggregate can not be set together with Id, Expression, AnomalyDetection or PercentilesAsSummary", m.Name, metricIdx, parent)
+		}
+	}
+
+	if len(m.Series) > 0 {
+		if discovery == nil {
+			return fmt.Errorf("Metric [%s/%d] in %v: Series are only supported in discovery jobs", m.Name, metricIdx, parent)
+		}
+		if m.NameRegex != "" || m.Expression != "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: Series can not be set together with NameRegex or Expression", m.Name, metricIdx, parent)
+		}
+		for seriesIdx, series := range m.Series {
+			if len(series.Dimensions) == 0 {
+				return fmt.Errorf("Series [%d] of Metric [%s/%d] in %v: Dimensions should not be empty", seriesIdx, m.Name, metricIdx, parent)
+			}
+			for _, dimension := range series.Dimensions {
+				if dimension.Name == "" || dimension.Value == "" {
+					return fmt.Errorf("Series [%d] of Metric [%s/%d] in %v: Dimension name and value should not be empty", seriesIdx, m.Name, metricIdx, parent)
+				}
+			}
+		}
+	}
+
+	if m.CarryForward < 0 {
+		return fmt.Errorf("Metric [%s/%d] in %v: CarryForward should not be negative", m.Name, metricIdx, parent)
+	}
+	if m.CarryForward > 0 && m.ExportAllDataPoints {
+		return fmt.Errorf("Metric [%s/%d] in %v: CarryForward can not be enabled together with ExportAllDataPoints", m.Name, metricIdx, parent)
+	}
+
+	if err := m.validateType(mStatistics, metricIdx, parent); err != nil {
+		return err
+	}
+
+	for _, dimension := range m.DropDimensions {
+		if dimension == "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: DropDimensions should not contain empty names", m.Name, metricIdx, parent)
+		}
+	}
+	// The quantiles of summaries can't be merged
+	if len(m.DropDimensions) > 0 && m.PercentilesAsSummary {
+		return fmt.Errorf("Metric [%s/%d] in %v: DropDimensions can not be used together with PercentilesAsSummary", m.Name, metricIdx, parent)
+	}
+
+	if m.PercentilesAsSummary && m.ExportAllDataPoints {
+		return fmt.Errorf("Metric [%s/%d] in %v: PercentilesAsSummary can not be enabled together with ExportAllDataPoints", m.Name, metricIdx, parent)
+	}
+
+	if m.PercentilesAsLabels && m.PercentilesAsSummary {
+		return fmt.Errorf("Metric [%s/%d] in %v: PercentilesAsLabels can not be enabled together with PercentilesAsSummary", m.Name, metricIdx, parent)
+	}
+
+	if err := m.validateSampleCountAsCount(mStatistics, metricIdx, parent); err != nil {
+		return err
+	}
+
+	// Every datapoint is already exported with its timestamp, and summaries with the latest one of their quantiles
+	if m.ExportTimestamp && (m.ExportAllDataPoints || m.PercentilesAsSummary) {
+		return fmt.Errorf("Metric [%s/%d] in %v: ExportTimestamp can not be enabled together with ExportAllDataPoints or PercentilesAsSummary", m.Name, metricIdx, parent)
+	}
+
+	if m.AnomalyDetection != nil {
+		if m.Expression != "" {
+			return fmt.Errorf("Metric [%s/%d] in %v: AnomalyDetection is not supported for expressions", m.Name, metricIdx, parent)
+		}
+		if m.AnomalyDetection.Band < 0 {
+			return fmt.Errorf("Metric [%s/%d] in %v: AnomalyDetection band should not be negative", m.Name, metricIdx, parent)
+		}
+		if m.AnomalyDetection.Band == 0 {
+			m.AnomalyDetection.Band = DefaultAnomalyDetectionBand
 		}
 	}
 
@@ -352,9 +2146,253 @@ func (m *Metric) validateMetric(metricIdx int, parent string, discovery *Job) er
 	m.Length = mLength
 	m.Period = mPeriod
 	m.Delay = mDelay
-	m.NilToZero = mNilToZero
-	m.AddCloudwatchTimestamp = mAddCloudwatchTimestamp
 	m.Statistics = mStatistics
 
 	return nil
 }
+
+// validateConflicts checks that the settings of a metric don't silently cancel each other out. It runs before the
+// metric inherits the settings of its job, which only apply to the settings the metric doesn't set.
+func (m *Metric) validateConflicts(metricIdx int, parent string) error {
+	if m.Expression != "" {
+		// An expression is a single series exported without statistic suffix
+		if len(m.Statistics) > 0 || m.PercentilesAsSummary || m.PercentilesAsLabels {
+			return fmt.Errorf("Metric [%s/%d] in %v: Statistics, PercentilesAsSummary and PercentilesAsLabels are not supported for expressions", m.Name, metricIdx, parent)
+		}
+		// It's queried with the shortest period of the metrics it references
+		if m.Period != 0 || m.AutoPeriod {
+			return fmt.Errorf("Metric [%s/%d] in %v: Period is not supported for expressions, which use the shortest period of the metrics they reference", m.Name, metricIdx, parent)
+		}
+	}
+
+	// The series without datapoint of the resources still discovered are exported with MissingDataValue,
+	// those of the resources which are gone are dropped. An inherited NilToZero is replaced by TreatMissingData.
+	if m.TreatMissingData != "" && (m.DropNoData || aws.BoolValue(m.NilToZero)) {
+		return fmt.Errorf("Metric [%s/%d] in %v: TreatMissingData can not be set together with DropNoData or NilToZero", m.Name, metricIdx, parent)
+	}
+	return nil
+}
+
+// validateNilToZero checks that the NilToZero and AddCloudwatchTimestamp settings of a metric, once resolved,
+// aren't both enabled for a statistic: missing datapoints aren't exported with AddCloudwatchTimestamp
+func (m *Metric) validateNilToZero(statistics []string, metricIdx int, parent string) error {
+	for _, statistic := range statistics {
+		if !aws.BoolValue(m.NilToZeroFor(statistic)) || !aws.BoolValue(m.AddCloudwatchTimestampFor(statistic)) {
+			continue
+		}
+		if _, ok := m.StatisticSettings[statistic]; ok {
+			return fmt.Errorf("Metric [%s/%d] in %v: NilToZero can not be enabled together with AddCloudwatchTimestamp, which are both enabled for %s", m.Name, metricIdx, parent, statistic)
+		}
+		return fmt.Errorf("Metric [%s/%d] in %v: NilToZero can not be enabled together with AddCloudwatchTimestamp, set on the metric, its job or globally", m.Name, metricIdx, parent)
+	}
+	return nil
+}
+
+// validateSampleCountAsCount checks that a metric with SampleCountAsCount requests the SampleCount statistic,
+// and isn't exported as a summary, whose count would have the same name
+func (m *Metric) validateSampleCountAsCount(statistics []string, metricIdx int, parent string) error {
+	if !m.SampleCountAsCount {
+		return nil
+	}
+	if !containsAll(statistics, []string{"SampleCount"}) {
+		return fmt.Errorf("Metric [%s/%d] in %v: SampleCountAsCount requires the SampleCount statistic", m.Name, metricIdx, parent)
+	}
+	if m.PercentilesAsSummary {
+		return fmt.Errorf("Metric [%s/%d] in %v: SampleCountAsCount can not be enabled together with PercentilesAsSummary", m.Name, metricIdx, parent)
+	}
+	return nil
+}
+
+// validateType checks the Prometheus type of a metric, and the accumulation of the datapoints of counters
+func (m *Metric) validateType(statistics []string, metricIdx int, parent string) error {
+	switch m.Type {
+	case "", MetricTypeGauge:
+		if m.Accumulate {
+			return fmt.Errorf("Metric [%s/%d] in %v: Accumulate can only be enabled for the metrics with Type %s", m.Name, metricIdx, parent, MetricTypeCounter)
+		}
+		return nil
+	case MetricTypeCounter:
+	default:
+		return fmt.Errorf("Metric [%s/%d] in %v: Type %s is unknown, should be %s or %s", m.Name, metricIdx, parent, m.Type, MetricTypeGauge, MetricTypeCounter)
+	}
+
+	if m.PercentilesAsSummary || m.PercentilesAsLabels {
+		return fmt.Errorf("Metric [%s/%d] in %v: Type %s can not be used together with PercentilesAsSummary or PercentilesAsLabels", m.Name, metricIdx, parent, MetricTypeCounter)
+	}
+	if !m.Accumulate {
+		return nil
+	}
+	if m.ExportAllDataPoints || m.CarryForward > 0 || m.TreatMissingData != "" {
+		return fmt.Errorf("Metric [%s/%d] in %v: Accumulate can not be enabled together with ExportAllDataPoints, CarryForward or TreatMissingData", m.Name, metricIdx, parent)
+	}
+	// Adding up the datapoints of other statistics, e.g. averages, makes no sense. The statistics
+	// of expressions are unused, they are accumulated whatever they compute.
+	if m.Expression == "" {
+		for _, statistic := range statistics {
+			if statistic != "Sum" && statistic != "SampleCount" {
+				return fmt.Errorf("Metric [%s/%d] in %v: Accumulate only supports the Sum and SampleCount statistics, not %s", m.Name, metricIdx, parent, statistic)
+			}
+		}
+	}
+	return nil
+}
+
+// mergeDropDimensions returns the dimensions dropped by a job followed by those only dropped by its metric
+func mergeDropDimensions(job []string, metric []string) []string {
+	if len(job) == 0 {
+		return metric
+	}
+	merged := make([]string, 0, len(job)+len(metric))
+	seen := make(map[string]struct{}, len(job)+len(metric))
+	for _, dimension := range append(append([]string{}, job...), metric...) {
+		if _, ok := seen[dimension]; !ok {
+			seen[dimension] = struct{}{}
+			merged = append(merged, dimension)
+		}
+	}
+	return merged
+}
+
+// validateNameRegex checks the settings of a metric selected with a NameRegex, which only
+// discovery and custom namespace jobs support as they list the metrics of the namespace
+func (m *Metric) validateNameRegex(metricIdx int, parent string) error {
+	if m.Name != "" {
+		return fmt.Errorf("Metric [%s/%d] in %v: Name and NameRegex should not be both set", m.Name, metricIdx, parent)
+	}
+	if _, err := regexp.Compile(m.NameRegex); err != nil {
+		return fmt.Errorf("Metric [%s/%d] in %v: NameRegex %s is not a valid regular expression: %w", m.Name, metricIdx, parent, m.NameRegex, err)
+	}
+	if m.Id != "" || m.Expression != "" {
+		return fmt.Errorf("Metric [%s/%d] in %v: NameRegex can not be used together with Id or Expression", m.Name, metricIdx, parent)
+	}
+	if m.MaxNameRegexMatches < 0 {
+		return fmt.Errorf("Metric [%s/%d] in %v: MaxNameRegexMatches should not be negative", m.Name, metricIdx, parent)
+	}
+	return nil
+}
+
+// validateExportedNamespace checks that exportedNamespace, when set, is made of the characters of metric names
+func validateExportedNamespace(exportedNamespace string, parent string) error {
+	if exportedNamespace != "" && !labelNameRegexp.MatchString(exportedNamespace) {
+		return fmt.Errorf("%v: ExportedNamespace %s should only contain letters, digits and underscores, and not start with a digit", parent, exportedNamespace)
+	}
+	return nil
+}
+
+// validateMetricRenames checks that every renamed metric is a metric of the job
+// and is given a new name.
+// validateRecentlyActiveOnly checks that the listed metrics of a job with RecentlyActiveOnly publish a data point
+// at least every recentlyActivePeriod, otherwise they would only be listed, and scraped, from time to time.
+func validateRecentlyActiveOnly(metrics []*Metric, parent string) error {
+	for metricIdx, m := range metrics {
+		if m.Expression == "" && m.Period > recentlyActivePeriod {
+			return fmt.Errorf("Metric [%s/%d] in %v: Period should not be more than %d seconds with RecentlyActiveOnly", m.Name, metricIdx, parent, recentlyActivePeriod)
+		}
+	}
+	return nil
+}
+
+func validateMetricRenames(renames map[string]string, metrics []*Metric, parent string) error {
+	for name, renamed := range renames {
+		found := false
+		for _, m := range metrics {
+			if m.Name == name {
+				found = true
+				break
+			}
+			// Metrics selected by a NameRegex are renamed by their CloudWatch name
+			if m.NameRegex != "" && regexp.MustCompile(m.NameRegex).MatchString(name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%v: MetricRenames references unknown metric %s", parent, name)
+		}
+		if renamed == "" {
+			return fmt.Errorf("%v: MetricRenames of metric %s should not be empty", parent, name)
+		}
+	}
+	return nil
+}
+
+// validateExpressions checks that every metric id referenced by a metric math
+// expression belongs to a sibling metric with exactly one statistic, so that
+// the expression can be resolved to a single query per resource.
+func validateExpressions(metrics []*Metric, parent string) error {
+	byId := map[string]*Metric{}
+	for _, m := range metrics {
+		if m.Id != "" {
+			if _, ok := byId[m.Id]; ok {
+				return fmt.Errorf("Metric [%s] in %v: Id %s is not unique", m.Name, parent, m.Id)
+			}
+			byId[m.Id] = m
+		}
+	}
+
+	for metricIdx, m := range metrics {
+		if m.Expression == "" {
+			continue
+		}
+		ids := m.ExpressionIds()
+		if len(ids) == 0 {
+			return fmt.Errorf("Metric [%s/%d] in %v: Expression should reference at least one metric id", m.Name, metricIdx, parent)
+		}
+		for _, id := range ids {
+			ref, ok := byId[id]
+			if !ok {
+				return fmt.Errorf("Metric [%s/%d] in %v: Expression references unknown metric id %s", m.Name, metricIdx, parent, id)
+			}
+			if ref.Expression != "" {
+				return fmt.Errorf("Metric [%s/%d] in %v: Expression references metric id %s which is an expression itself", m.Name, metricIdx, parent, id)
+			}
+			if len(ref.Statistics) != 1 {
+				return fmt.Errorf("Metric [%s/%d] in %v: Expression references metric id %s which should have exactly one statistic", m.Name, metricIdx, parent, id)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (j *RDSEnhancedMonitoring) validateRDSEnhancedMonitoringJob(jobIdx int) error {
+	if j.Name == "" {
+		return fmt.Errorf("RDSEnhancedMonitoring job [%v]: Name should not be empty", jobIdx)
+	}
+	parent := fmt.Sprintf("RDSEnhancedMonitoring job [%s/%d]", j.Name, jobIdx)
+	for roleIdx, role := range j.Roles {
+		if err := role.ValidateRole(roleIdx, parent); err != nil {
+			return err
+		}
+	}
+	if len(j.Regions) == 0 {
+		return fmt.Errorf("%v: Regions should not be empty", parent)
+	}
+	for _, tag := range j.SearchTags {
+		if tag.Key == "" {
+			return fmt.Errorf("%v: SearchTags should not have an empty key", parent)
+		}
+	}
+	for _, tag := range j.ExportedTags {
+		if tag == "" {
+			return fmt.Errorf("%v: ExportedTags should not be empty strings", parent)
+		}
+	}
+	groups := make(map[string]struct{}, len(j.Groups))
+	for _, group := range j.Groups {
+		if !containsAll(RDSEnhancedMonitoringGroups, []string{group}) {
+			return fmt.Errorf("%v: Group %s is unknown, should be one of %s", parent, group, strings.Join(RDSEnhancedMonitoringGroups, ", "))
+		}
+		if _, ok := groups[group]; ok {
+			return fmt.Errorf("%v: Group %s is defined more than once", parent, group)
+		}
+		groups[group] = struct{}{}
+	}
+	if j.MaxAge < 0 {
+		return fmt.Errorf("%v: MaxAge should not be negative", parent)
+	}
+	if j.Timeout < 0 {
+		return fmt.Errorf("%v: Timeout should not be negative", parent)
+	}
+	return nil
+}
@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
 )
 
 func TestConfLoad(t *testing.T) {
@@ -15,6 +19,64 @@ func TestConfLoad(t *testing.T) {
 		{configFile: "sts_region.ok.yml"},
 		{configFile: "multiple_roles.ok.yml"},
 		{configFile: "custom_namespace.ok.yml"},
+		{configFile: "expression.ok.yml"},
+		{configFile: "list_metrics_cache_ttl.ok.yml"},
+		{configFile: "drop_no_data.ok.yml"},
+		{configFile: "role_chain.ok.yml"},
+		{configFile: "metric_renames.ok.yml"},
+		{configFile: "tag_exists.ok.yml"},
+		{configFile: "usage.ok.yml"},
+		{configFile: "align_to_period.ok.yml"},
+		{configFile: "partition.ok.yml"},
+		{configFile: "anomaly_detection.ok.yml"},
+		{configFile: "statistic_settings.ok.yml"},
+		{configFile: "config_discovery.ok.yml"},
+		{configFile: "max_series.ok.yml"},
+		{configFile: "sample_ratio.ok.yml"},
+		{configFile: "treat_missing_data.ok.yml"},
+		{configFile: "unit.ok.yml"},
+		{configFile: "role_account_id.ok.yml"},
+		{configFile: "name_regex.ok.yml"},
+		{configFile: "role_concurrency.ok.yml"},
+		{configFile: "label.ok.yml"},
+		{configFile: "incremental_discovery.ok.yml"},
+		{configFile: "alarms.ok.yml"},
+		{configFile: "dimension_value_requirements.ok.yml"},
+		{configFile: "static_dimensions.ok.yml"},
+		{configFile: "scan_by.ok.yml"},
+		{configFile: "account_alias.ok.yml"},
+		{configFile: "transform.ok.yml"},
+		{configFile: "related_tags.ok.yml"},
+		{configFile: "include_linked_accounts.ok.yml"},
+		{configFile: "dimension_name_requirements.ok.yml"},
+		{configFile: "logs_insights.ok.yml"},
+		{configFile: "rds_enhanced_monitoring.ok.yml"},
+		{configFile: "credential_refresh.ok.yml"},
+		{configFile: "exported_tags_mode.ok.yml"},
+		{configFile: "metric_series.ok.yml"},
+		{configFile: "exported_namespace.ok.yml"},
+		{configFile: "metric_aggregate.ok.yml"},
+		{configFile: "export_timestamp.ok.yml"},
+		{configFile: "period_auto.ok.yml"},
+		{configFile: "scrape_interval.ok.yml"},
+		{configFile: "max_datapoint_age.ok.yml"},
+		{configFile: "carry_forward.ok.yml"},
+		{configFile: "metric_type_counter.ok.yml"},
+		{configFile: "drop_dimensions.ok.yml"},
+		{configFile: "rate_limits.ok.yml"},
+		{configFile: "metric_help.ok.yml"},
+		{configFile: "endpoints.ok.yml"},
+		{configFile: "label_sanitization.ok.yml"},
+		{configFile: "metric_defaults.ok.yml"},
+		{configFile: "resource_up.ok.yml"},
+		{configFile: "cloud_formation_stack.ok.yml"},
+		{configFile: "recently_active_only.ok.yml"},
+		{configFile: "metric_delay.ok.yml"},
+		{configFile: "role_label.ok.yml"},
+		{configFile: "static_window.ok.yml"},
+		{configFile: "web_identity.ok.yml"},
+		{configFile: "sample_count_as_count.ok.yml"},
+		{configFile: "circuit_breaker.ok.yml"},
 	}
 	for _, tc := range testCases {
 		config := ScrapeConf{}
@@ -55,6 +117,242 @@ func TestBadConfigs(t *testing.T) {
 			configFile: "custom_namespace_without_region.bad.yml",
 			errorMsg:   "Regions should not be empty",
 		},
+		{
+			configFile: "export_all_datapoints_without_timestamp.bad.yml",
+			errorMsg:   "ExportAllDataPoints can only be enabled together with AddCloudwatchTimestamp",
+		},
+		{
+			configFile: "expression_unknown_id.bad.yml",
+			errorMsg:   "Expression references unknown metric id requests",
+		},
+		{
+			configFile: "percentiles_as_summary_with_all_datapoints.bad.yml",
+			errorMsg:   "PercentilesAsSummary can not be enabled together with ExportAllDataPoints",
+		},
+		{
+			configFile: "role_chain_without_rolearn.bad.yml",
+			errorMsg:   "RoleArn should not be empty in role chain hop [1]",
+		},
+		{
+			configFile: "web_identity_not_first_hop.bad.yml",
+			errorMsg:   "Role [0] in Discovery job [s3/0]: WebIdentityTokenFile can only be set on the first role of the chain, without SourceRole",
+		},
+		{
+			configFile: "web_identity_external_id.bad.yml",
+			errorMsg:   "Role [0] in Discovery job [s3/0]: ExternalID can not be set together with WebIdentityTokenFile",
+		},
+		{
+			configFile: "metric_renames_unknown_metric.bad.yml",
+			errorMsg:   "MetricRenames references unknown metric BucketSizeBytes",
+		},
+		{
+			configFile: "percentiles_as_labels_with_summary.bad.yml",
+			errorMsg:   "PercentilesAsLabels can not be enabled together with PercentilesAsSummary",
+		},
+		{
+			configFile: "dimension_filter_without_name.bad.yml",
+			errorMsg:   "Name should not be empty in dimension filter [0]",
+		},
+		{
+			configFile: "align_to_period_rounding_period.bad.yml",
+			errorMsg:   "RoundingPeriod 120 should be a multiple of the period 300 of metric NumberOfObjects with AlignToPeriod",
+		},
+		{
+			configFile: "unknown_partition.bad.yml",
+			errorMsg:   "Partition aws-gov is not a known AWS partition",
+		},
+		{
+			configFile: "anomaly_detection_negative_band.bad.yml",
+			errorMsg:   "AnomalyDetection band should not be negative",
+		},
+		{
+			configFile: "statistic_settings_unknown_statistic.bad.yml",
+			errorMsg:   "StatisticSettings of Sum which is not a statistic of the metric",
+		},
+		{
+			configFile: "config_discovery_unknown.bad.yml",
+			errorMsg:   "ResourceDiscovery cloudtrail is unknown, should be tagging or config",
+		},
+		{
+			configFile: "config_aggregator_without_region.bad.yml",
+			errorMsg:   "ConfigAggregator name and region should not be empty",
+		},
+		{
+			configFile: "max_series_on_limit_exceeded.bad.yml",
+			errorMsg:   "OnLimitExceeded drop is unknown, should be truncate or skip",
+		},
+		{
+			configFile: "sample_ratio_above_one.bad.yml",
+			errorMsg:   "Discovery job [s3/0]: SampleRatio should be between 0 and 1, got 1.5",
+		},
+		{
+			configFile: "treat_missing_data_custom_namespace.bad.yml",
+			errorMsg:   "TreatMissingData is only supported in discovery jobs",
+		},
+		{
+			configFile: "unit_unknown.bad.yml",
+			errorMsg:   "Unit Byte is not a CloudWatch unit",
+		},
+		{
+			configFile: "role_account_id_invalid.bad.yml",
+			errorMsg:   "AccountId should be a 12 digit AWS account id",
+		},
+		{
+			configFile: "name_regex_with_name.bad.yml",
+			errorMsg:   "Name and NameRegex should not be both set",
+		},
+		{
+			configFile: "role_concurrency_negative.bad.yml",
+			errorMsg:   "CloudwatchConcurrency and TagConcurrency should not be negative",
+		},
+		{
+			configFile: "label_as_reserved.bad.yml",
+			errorMsg:   "LabelAs name is already used by the exporter",
+		},
+		{
+			configFile: "incremental_discovery_negative.bad.yml",
+			errorMsg:   "IncrementalDiscovery fullRefreshInterval should not be negative",
+		},
+		{
+			configFile: "incremental_discovery_tagging.bad.yml",
+			errorMsg:   "IncrementalDiscovery requires ResourceDiscovery config",
+		},
+		{
+			configFile: "alarms_without_regions.bad.yml",
+			errorMsg:   "Alarms job [production/0]: Regions should not be empty",
+		},
+		{
+			configFile: "dimension_value_requirements_invalid_regex.bad.yml",
+			errorMsg:   "DimensionValueRequirement [StorageType/0] in Discovery job [s3/0]: ValueRegex is invalid",
+		},
+		{
+			configFile: "static_dimensions_duplicate.bad.yml",
+			errorMsg:   "StaticDimension [StorageType/1] in Discovery job [s3/0]: Name is defined more than once",
+		},
+		{
+			configFile: "scan_by_unknown.bad.yml",
+			errorMsg:   "ScanBy Random is unknown, should be TimestampDescending or TimestampAscending",
+		},
+		{
+			configFile: "transform_scale_and_conversion.bad.yml",
+			errorMsg:   "Metric [BucketSizeBytes/0] in Discovery job [s3/0]: Transform Scale can't be set together with Conversion",
+		},
+		{
+			configFile: "transform_unknown_conversion.bad.yml",
+			errorMsg:   "Metric [BucketSizeBytes/0] in Discovery job [s3/0], statistic Average: Transform Conversion bytesToPiB is unknown",
+		},
+		{
+			configFile: "label_sanitization_unknown_policy.bad.yml",
+			errorMsg:   "LabelSanitization: Policy escape is unknown, should be replace or drop",
+		},
+		{
+			configFile: "endpoints_invalid_url.bad.yml",
+			errorMsg:   "Endpoints in Discovery job [s3/0]: Cloudwatch endpoint monitoring.eu-west-1.vpce.amazonaws.com should be an http or https URL",
+		},
+		{
+			configFile: "carry_forward_negative.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Discovery job [s3/0]: CarryForward should not be negative",
+		},
+		{
+			configFile: "rate_limits_no_rate.bad.yml",
+			errorMsg:   "Discovery rate limit of ListMetrics: RequestsPerSecond should be positive",
+		},
+		{
+			configFile: "drop_dimensions_summary.bad.yml",
+			errorMsg:   "Metric [BucketSizeBytes/0] in Discovery job [s3/0]: DropDimensions can not be used together with PercentilesAsSummary",
+		},
+		{
+			configFile: "metric_type_unknown.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Discovery job [s3/0]: Type histogram is unknown, should be gauge or counter",
+		},
+		{
+			configFile: "metric_accumulate_gauge.bad.yml",
+			errorMsg:   "Metric [AllRequests/0] in Discovery job [s3/0]: Accumulate can only be enabled for the metrics with Type counter",
+		},
+		{
+			configFile: "metric_accumulate_average.bad.yml",
+			errorMsg:   "Metric [AllRequests/0] in Discovery job [s3/0]: Accumulate only supports the Sum and SampleCount statistics, not Average",
+		},
+		{
+			configFile: "static_period_not_multiple.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Static job [bucket/0]: Period should be 1, 5, 10, 30 or a multiple of 60",
+		},
+		{
+			configFile: "static_too_many_datapoints.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Static job [bucket/0]: Length should not be more than 1440 times Period",
+		},
+		{
+			configFile: "cloud_formation_stack_no_name.bad.yml",
+			errorMsg:   "CloudFormationStack in Discovery job [s3/0]: Name should not be empty",
+		},
+		{
+			configFile: "recently_active_only_long_period.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Discovery job [s3/0]: Period should not be more than 10800 seconds with RecentlyActiveOnly",
+		},
+		{
+			configFile: "dimension_name_requirements_empty.bad.yml",
+			errorMsg:   "DimensionNameRequirements anyOf [1] in Discovery job [s3/0]: should not be empty",
+		},
+		{
+			configFile: "related_tags_unknown_type.bad.yml",
+			errorMsg:   "RelatedTags [unknown/0] in Discovery job [s3/0]: Service is not in known list!: unknown",
+		},
+		{
+			configFile: "logs_insights_no_query.bad.yml",
+			errorMsg:   "LogsInsights job [api_errors/0]: Query should not be empty",
+		},
+		{
+			configFile: "logs_insights_reserved_label_field.bad.yml",
+			errorMsg:   "LogsInsights job [api_errors/0]: LabelField region is already used by the exporter",
+		},
+		{
+			configFile: "rds_enhanced_monitoring_unknown_group.bad.yml",
+			errorMsg:   "RDSEnhancedMonitoring job [databases/0]: Group disk is unknown, should be one of cpuUtilization, loadAverageMinute, memory, swap, tasks, diskIO, physicalDeviceIO, fileSys, network",
+		},
+		{
+			configFile: "credential_refresh_short_duration.bad.yml",
+			errorMsg:   "CredentialRefresh: Duration should be at least 15m0s",
+		},
+		{
+			configFile: "exported_tags_mode_unknown.bad.yml",
+			errorMsg:   "Discovery job [s3/0]: ExportedTagsMode annotations is unknown, should be labels or info",
+		},
+		{
+			configFile: "metric_series_without_dimensions.bad.yml",
+			errorMsg:   "Series [0] of Metric [NumberOfObjects/0] in Discovery job [s3/0]: Dimensions should not be empty",
+		},
+		{
+			configFile: "exported_namespace_invalid.bad.yml",
+			errorMsg:   "ExportedNamespace app.v2 should only contain letters, digits and underscores, and not start with a digit",
+		},
+		{
+			configFile: "metric_aggregate_unknown.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Discovery job [s3/0]: Aggregate MEDIAN is unknown, should be one of SUM, AVG, MIN, MAX",
+		},
+		{
+			configFile: "export_timestamp_all_datapoints.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Discovery job [s3/0]: ExportTimestamp can not be enabled together with ExportAllDataPoints or PercentilesAsSummary",
+		},
+		{
+			configFile: "period_auto_static.bad.yml",
+			errorMsg:   "Metric [NumberOfObjects/0] in Static job [bucket/0]: Period auto is not supported in static jobs",
+		},
+		{
+			configFile: "scrape_interval_negative.bad.yml",
+			errorMsg:   "Discovery job [s3/0]: ScrapeInterval should not be negative",
+		},
+		{
+			configFile: "max_datapoint_age_negative.bad.yml",
+			errorMsg:   "Discovery job [s3/0]: MaxDatapointAge should not be negative",
+		},
+		{
+			configFile: "sample_count_as_count_without_statistic.bad.yml",
+			errorMsg:   "Metric [TargetResponseTime/0] in Discovery job [alb/0]: SampleCountAsCount requires the SampleCount statistic",
+		},
+		{
+			configFile: "circuit_breaker_negative_cooldown.bad.yml",
+			errorMsg:   "Discovery circuit breaker: Cooldown should not be negative",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -72,6 +370,527 @@ func TestBadConfigs(t *testing.T) {
 	}
 }
 
+func TestRoleChain(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/role_chain.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	role := config.Discovery.Jobs[0].Roles[0]
+	chain := role.Chain()
+	if len(chain) != 2 || chain[0].RoleArn != "arn:aws:iam::111111111111:role/intermediate" || chain[1].RoleArn != "arn:aws:iam::222222222222:role/target" {
+		t.Errorf("unexpected role chain %+v", chain)
+	}
+	if chain[0].ExternalID != "intermediate-id" || chain[1].ExternalID != "target-id" {
+		t.Errorf("external ids not kept per hop %+v", chain)
+	}
+
+	// Identical chains are the same role, e.g. to share sessions
+	if role != config.Discovery.Jobs[1].Roles[0] {
+		t.Error("identical role chains should be equal")
+	}
+}
+
+func TestTransform(t *testing.T) {
+	scale := 1e-3
+	testCases := []struct {
+		name      string
+		transform Transform
+		value     float64
+		expected  float64
+	}{
+		{name: "no transform", transform: Transform{}, value: 42, expected: 42},
+		{name: "scale", transform: Transform{Scale: &scale}, value: 1500, expected: 1.5},
+		{name: "offset", transform: Transform{Offset: -1}, value: 42, expected: 41},
+		{name: "scale and offset", transform: Transform{Scale: &scale, Offset: 1}, value: 1500, expected: 2.5},
+		{name: "conversion", transform: Transform{Conversion: "bytesToMiB"}, value: 3 << 20, expected: 3},
+		{name: "conversion and offset", transform: Transform{Conversion: "percentToRatio", Offset: -1}, value: 50, expected: -0.5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.transform.Apply(tc.value); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDimensionNameRequirements(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/dimension_name_requirements.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+	flat := config.Discovery.Jobs[0].DimensionNameRequirements
+	nested := config.Discovery.Jobs[1].DimensionNameRequirements
+
+	testCases := []struct {
+		name         string
+		requirements DimensionNameRequirements
+		dimensions   []string
+		expected     bool
+	}{
+		{name: "no requirement", requirements: DimensionNameRequirements{}, dimensions: []string{"BucketName"}, expected: true},
+		{name: "flat list, exact dimensions", requirements: flat, dimensions: []string{"StorageType", "BucketName"}, expected: true},
+		{name: "flat list, missing dimension", requirements: flat, dimensions: []string{"BucketName"}, expected: false},
+		{name: "flat list, extra dimension", requirements: flat, dimensions: []string{"BucketName", "StorageType", "FilterId"}, expected: false},
+		{name: "first alternative", requirements: nested, dimensions: []string{"LoadBalancer"}, expected: true},
+		{name: "nested alternative", requirements: nested, dimensions: []string{"LoadBalancer", "TargetGroup"}, expected: true},
+		{name: "other nested alternative", requirements: nested, dimensions: []string{"AvailabilityZone", "LoadBalancer"}, expected: true},
+		{name: "nested alternative without the common dimension", requirements: nested, dimensions: []string{"TargetGroup"}, expected: false},
+		{name: "no alternative", requirements: nested, dimensions: []string{"LoadBalancer", "Rule"}, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.requirements.Matches(tc.dimensions); got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestTransformFor(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/transform.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	metric := config.Discovery.Jobs[0].Metrics[0]
+	if transform := metric.TransformFor("Average"); transform != metric.Transform {
+		t.Errorf("expected the metric transform for Average, got %+v", transform)
+	}
+	if transform := metric.TransformFor("Maximum"); transform != metric.StatisticSettings["Maximum"].Transform {
+		t.Errorf("expected the statistic transform for Maximum, got %+v", transform)
+	}
+	// SampleCount is a number of datapoints, not a value in the unit of the metric
+	if transform := metric.TransformFor("SampleCount"); transform != nil {
+		t.Errorf("expected no transform for SampleCount, got %+v", transform)
+	}
+}
+
+func TestRoleEndpoints(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/endpoints.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	// The discovery job has no endpoints of its own, its roles get the global ones
+	if endpoints := config.Discovery.Jobs[0].Roles[0].Endpoints; endpoints != *config.Endpoints {
+		t.Errorf("expected the global endpoints, got %+v", endpoints)
+	}
+	if endpoints := config.Static[0].Roles[0].Endpoints; endpoints != *config.Static[0].Endpoints {
+		t.Errorf("expected the endpoints of the static job, got %+v", endpoints)
+	}
+}
+
+func TestRoleCredentialRefresh(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/credential_refresh.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := CredentialRefresh{SessionCacheTTL: 30 * time.Minute, ExpiryWindow: 5 * time.Minute, Duration: time.Hour}
+	if refresh := config.Discovery.Jobs[0].Roles[0].CredentialRefresh; refresh != expected {
+		t.Errorf("expected the global credential refresh policy, got %+v", refresh)
+	}
+
+	config = ScrapeConf{}
+	configFile = "testdata/endpoints.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+	if refresh := config.Discovery.Jobs[0].Roles[0].CredentialRefresh; refresh != (CredentialRefresh{ExpiryWindow: model.DefaultCredentialExpiryWindow}) {
+		t.Errorf("expected the default expiry window, got %+v", refresh)
+	}
+}
+
+func TestMetricDefaultsPrecedence(t *testing.T) {
+	yes, no := true, false
+
+	testCases := []struct {
+		name     string
+		metric   *bool
+		job      *bool
+		global   *bool
+		expected bool
+	}{
+		{name: "built-in default", expected: false},
+		{name: "global", global: &yes, expected: true},
+		{name: "job over global", job: &no, global: &yes, expected: false},
+		{name: "job", job: &yes, expected: true},
+		{name: "metric over job", metric: &no, job: &yes, expected: false},
+		{name: "metric over job and global", metric: &yes, job: &no, global: &no, expected: true},
+		{name: "metric over global", metric: &no, global: &yes, expected: false},
+	}
+
+	// NilToZero can't be enabled together with AddCloudwatchTimestamp, the precedence of each is checked on its own
+	for _, setting := range []string{"NilToZero", "AddCloudwatchTimestamp"} {
+		for _, tc := range testCases {
+			t.Run(setting+" "+tc.name, func(t *testing.T) {
+				// defaults are the MetricDefaults setting only the setting to value
+				defaults := func(value *bool) MetricDefaults {
+					if setting == "NilToZero" {
+						return MetricDefaults{NilToZero: value}
+					}
+					return MetricDefaults{AddCloudwatchTimestamp: value}
+				}
+				newMetric := func() *Metric {
+					return &Metric{
+						Name:                   "NumberOfObjects",
+						Statistics:             []string{"Average"},
+						Period:                 86400,
+						Length:                 172800,
+						NilToZero:              defaults(tc.metric).NilToZero,
+						AddCloudwatchTimestamp: defaults(tc.metric).AddCloudwatchTimestamp,
+					}
+				}
+				job := defaults(tc.job)
+				config := ScrapeConf{
+					MetricDefaults: defaults(tc.global),
+					Discovery: Discovery{Jobs: []*Job{{
+						Type: "s3", Regions: []string{"eu-west-1"}, Metrics: []*Metric{newMetric()},
+						NilToZero: job.NilToZero, AddCloudwatchTimestamp: job.AddCloudwatchTimestamp,
+					}}},
+					CustomNamespace: []*CustomNamespace{{
+						Name: "custom", Namespace: "Custom", Regions: []string{"eu-west-1"}, Metrics: []*Metric{newMetric()},
+						NilToZero: job.NilToZero, AddCloudwatchTimestamp: job.AddCloudwatchTimestamp,
+					}},
+					Static: []*Static{{
+						Name: "static", Namespace: "AWS/S3", Regions: []string{"eu-west-1"}, Metrics: []*Metric{newMetric()},
+						NilToZero: job.NilToZero, AddCloudwatchTimestamp: job.AddCloudwatchTimestamp,
+					}},
+				}
+				if err := config.Validate(testServices); err != nil {
+					t.Fatal(err)
+				}
+
+				metrics := map[string]*Metric{
+					"discovery":        config.Discovery.Jobs[0].Metrics[0],
+					"custom namespace": config.CustomNamespace[0].Metrics[0],
+					"static":           config.Static[0].Metrics[0],
+				}
+				for job, metric := range metrics {
+					value := metric.NilToZero
+					if setting == "AddCloudwatchTimestamp" {
+						value = metric.AddCloudwatchTimestamp
+					}
+					if value == nil || *value != tc.expected {
+						t.Errorf("expected %s %v for the %s job, got %v", setting, tc.expected, job, value)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestConflictingSettings(t *testing.T) {
+	yes, no := true, false
+	// newConfig returns a configuration with a discovery job, whose metrics reference the Sum of Requests as r
+	newConfig := func(job *Job, metrics ...*Metric) ScrapeConf {
+		if job == nil {
+			job = &Job{}
+		}
+		job.Type, job.Regions = "s3", []string{"eu-west-1"}
+		job.Metrics = append([]*Metric{{Name: "Requests", Id: "r", Statistics: []string{"Sum"}}}, metrics...)
+		return ScrapeConf{Discovery: Discovery{Jobs: []*Job{job}}}
+	}
+
+	testCases := []struct {
+		name     string
+		config   ScrapeConf
+		expected string
+	}{
+		{
+			name:     "nilToZero and addCloudwatchTimestamp of a metric",
+			config:   newConfig(nil, &Metric{Name: "Errors", Statistics: []string{"Sum"}, NilToZero: &yes, AddCloudwatchTimestamp: &yes}),
+			expected: "Metric [Errors/1] in Discovery job [s3/0]: NilToZero can not be enabled together with AddCloudwatchTimestamp, set on the metric, its job or globally",
+		},
+		{
+			name: "nilToZero and addCloudwatchTimestamp of a statistic",
+			config: newConfig(nil, &Metric{
+				Name: "Errors", Statistics: []string{"Sum"}, NilToZero: &yes,
+				StatisticSettings: map[string]*StatisticSettings{"Sum": {AddCloudwatchTimestamp: &yes}},
+			}),
+			expected: "Metric [Errors/1] in Discovery job [s3/0]: NilToZero can not be enabled together with AddCloudwatchTimestamp, which are both enabled for Sum",
+		},
+		{
+			name:     "nilToZero and addCloudwatchTimestamp of a job",
+			config:   newConfig(&Job{NilToZero: &yes, AddCloudwatchTimestamp: &yes}),
+			expected: "Discovery job [s3/0]: NilToZero can not be enabled together with AddCloudwatchTimestamp",
+		},
+		{
+			name: "nilToZero and addCloudwatchTimestamp of the metric defaults",
+			config: ScrapeConf{
+				MetricDefaults: MetricDefaults{NilToZero: &yes, AddCloudwatchTimestamp: &yes},
+				Static:         []*Static{{Name: "static", Namespace: "AWS/S3", Regions: []string{"eu-west-1"}}},
+			},
+			expected: "Metric defaults: NilToZero can not be enabled together with AddCloudwatchTimestamp",
+		},
+		{
+			name: "nilToZero and addCloudwatchTimestamp of a static metric",
+			config: ScrapeConf{Static: []*Static{{
+				Name: "static", Namespace: "AWS/S3", Regions: []string{"eu-west-1"},
+				Metrics: []*Metric{{Name: "Errors", Statistics: []string{"Sum"}, NilToZero: &yes, AddCloudwatchTimestamp: &yes}},
+			}}},
+			expected: "Metric [Errors/0] in Static job [static/0]: NilToZero can not be enabled together with AddCloudwatchTimestamp, set on the metric, its job or globally",
+		},
+		{
+			name:     "addCloudwatchTimestamp of a metric and nilToZero of its job",
+			config:   newConfig(&Job{NilToZero: &yes}, &Metric{Name: "Errors", Statistics: []string{"Sum"}, AddCloudwatchTimestamp: &yes}),
+			expected: "Metric [Errors/1] in Discovery job [s3/0]: NilToZero can not be enabled together with AddCloudwatchTimestamp, set on the metric, its job or globally",
+		},
+		{
+			name:   "addCloudwatchTimestamp of a metric disabling the nilToZero of its job",
+			config: newConfig(&Job{NilToZero: &yes}, &Metric{Name: "Errors", Statistics: []string{"Sum"}, AddCloudwatchTimestamp: &yes, NilToZero: &no}),
+		},
+		{
+			name:     "expression with statistics",
+			config:   newConfig(nil, &Metric{Name: "Rate", Expression: "r / 60", Statistics: []string{"Sum"}}),
+			expected: "Metric [Rate/1] in Discovery job [s3/0]: Statistics, PercentilesAsSummary and PercentilesAsLabels are not supported for expressions",
+		},
+		{
+			name:     "expression with percentiles as labels",
+			config:   newConfig(nil, &Metric{Name: "Rate", Expression: "r / 60", PercentilesAsLabels: true}),
+			expected: "Metric [Rate/1] in Discovery job [s3/0]: Statistics, PercentilesAsSummary and PercentilesAsLabels are not supported for expressions",
+		},
+		{
+			name:     "expression with a period",
+			config:   newConfig(nil, &Metric{Name: "Rate", Expression: "r / 60", Period: 60}),
+			expected: "Metric [Rate/1] in Discovery job [s3/0]: Period is not supported for expressions, which use the shortest period of the metrics they reference",
+		},
+		{
+			name:     "treatMissingData with dropNoData",
+			config:   newConfig(nil, &Metric{Name: "Errors", Statistics: []string{"Sum"}, TreatMissingData: TreatMissingDataNotBreaching, DropNoData: true}),
+			expected: "Metric [Errors/1] in Discovery job [s3/0]: TreatMissingData can not be set together with DropNoData or NilToZero",
+		},
+		{
+			name:     "treatMissingData with nilToZero",
+			config:   newConfig(nil, &Metric{Name: "Errors", Statistics: []string{"Sum"}, TreatMissingData: TreatMissingDataNotBreaching, NilToZero: &yes}),
+			expected: "Metric [Errors/1] in Discovery job [s3/0]: TreatMissingData can not be set together with DropNoData or NilToZero",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Validate(testServices)
+			if tc.expected == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestStaticWindowDefaults(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/static_window.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		metric *Metric
+		period int64
+		length int64
+		delay  int64
+	}{
+		{metric: config.Static[0].Metrics[0], period: 86400, length: 172800, delay: 3600},
+		{metric: config.Static[0].Metrics[1], period: 86400, length: 259200, delay: 3600},
+	}
+	for _, tc := range testCases {
+		if tc.metric.Period != tc.period || tc.metric.Length != tc.length || tc.metric.Delay != tc.delay {
+			t.Errorf("expected period %d, length %d and delay %d for %s, got %d, %d and %d",
+				tc.period, tc.length, tc.delay, tc.metric.Name, tc.metric.Period, tc.metric.Length, tc.metric.Delay)
+		}
+	}
+
+	// Without length, a metric requests a single period
+	metric := &Metric{Name: "NumberOfObjects", Statistics: []string{"Average"}, Period: 300}
+	static := &Static{Name: "bucket", Namespace: "AWS/S3", Regions: []string{"eu-west-1"}, Metrics: []*Metric{metric}}
+	if err := static.validateStaticJob(0, MetricDefaults{}); err != nil {
+		t.Fatal(err)
+	}
+	if metric.Length != 300 {
+		t.Errorf("expected length 300 without length, got %d", metric.Length)
+	}
+}
+
+func TestDiscoveryMetricDelay(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/metric_delay.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	// A metric without delay uses the delay of its job
+	for i, expected := range []int64{600, 21600} {
+		metric := config.Discovery.Jobs[0].Metrics[i]
+		if metric.Delay != expected {
+			t.Errorf("expected delay %d for %s, got %d", expected, metric.Name, metric.Delay)
+		}
+	}
+}
+
+func TestPeriodAuto(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/period_auto.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	// A metric with period auto keeps its other settings and doesn't get the period of its job
+	metrics := []*Metric{config.Discovery.Jobs[0].Metrics[0], config.Discovery.Jobs[0].Metrics[1], config.CustomNamespace[0].Metrics[0]}
+	for i, expected := range []struct {
+		autoPeriod bool
+		period     int64
+		length     int64
+	}{{true, 0, 2592000}, {false, 86400, 172800}, {true, 0, 86400}} {
+		metric := metrics[i]
+		if metric.AutoPeriod != expected.autoPeriod || metric.Period != expected.period || metric.Length != expected.length {
+			t.Errorf("expected auto period %t, period %d and length %d for %s, got %t, %d and %d",
+				expected.autoPeriod, expected.period, expected.length, metric.Name, metric.AutoPeriod, metric.Period, metric.Length)
+		}
+	}
+	if statistics := metrics[2].Statistics; !reflect.DeepEqual(statistics, []string{"Average"}) || metrics[2].Delay != 1296000 {
+		t.Errorf("expected the statistics and delay of %s to be decoded with period auto, got %v and %d", metrics[2].Name, statistics, metrics[2].Delay)
+	}
+}
+
+func TestDropDimensions(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/drop_dimensions.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dimensions dropped by a metric are added to those of its job
+	for i, expected := range [][]string{{"StorageType"}, {"StorageType", "FilterId"}} {
+		metric := config.Discovery.Jobs[0].Metrics[i]
+		if !reflect.DeepEqual(metric.DropDimensions, expected) {
+			t.Errorf("expected dropped dimensions %v for %s, got %v", expected, metric.Name, metric.DropDimensions)
+		}
+	}
+}
+
+func TestLogsInsightsDefaults(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/logs_insights.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	configured, defaulted := config.LogsInsights[0], config.LogsInsights[1]
+	if configured.Length != 600 || configured.QueryTimeout != 20*time.Second {
+		t.Errorf("configured values overridden %+v", configured)
+	}
+	if defaulted.Length != model.DefaultLengthSeconds || defaulted.QueryTimeout != model.DefaultLogsInsightsQueryTimeout {
+		t.Errorf("defaults not applied %+v", defaulted)
+	}
+	if len(defaulted.Roles) != 1 {
+		t.Errorf("expected the current IAM role, got %+v", defaulted.Roles)
+	}
+}
+
+func TestRDSEnhancedMonitoringDefaults(t *testing.T) {
+	config := ScrapeConf{}
+	configFile := "testdata/rds_enhanced_monitoring.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+
+	configured, defaulted := config.RDSEnhancedMonitoring[0], config.RDSEnhancedMonitoring[1]
+	if configured.MaxAge != time.Minute || !reflect.DeepEqual(configured.Groups, []string{"cpuUtilization", "fileSys"}) {
+		t.Errorf("configured values overridden %+v", configured)
+	}
+	if defaulted.MaxAge != model.DefaultRDSEnhancedMonitoringMaxAge || !reflect.DeepEqual(defaulted.Groups, DefaultRDSEnhancedMonitoringGroups) {
+		t.Errorf("defaults not applied %+v", defaulted)
+	}
+	if len(defaulted.Roles) != 1 {
+		t.Errorf("expected the current IAM role, got %+v", defaulted.Roles)
+	}
+}
+
+// countingDefaultRegion returns a default region resolver returning region, and the number of its calls
+func countingDefaultRegion(region string) (func() string, *int) {
+	calls := 0
+	return func() string {
+		calls++
+		return region
+	}, &calls
+}
+
+func TestDefaultRegions(t *testing.T) {
+	defaultRegion, calls := countingDefaultRegion("eu-central-1")
+	config := ScrapeConf{DefaultRegion: defaultRegion}
+	configFile := "testdata/default_region.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Discovery.Jobs[0].Regions, []string{"eu-central-1"}) {
+		t.Errorf("expected the default region for the discovery job, got %v", config.Discovery.Jobs[0].Regions)
+	}
+	if !reflect.DeepEqual(config.Alarms[0].Regions, []string{"eu-central-1"}) {
+		t.Errorf("expected the default region for the alarms job, got %v", config.Alarms[0].Regions)
+	}
+	if !reflect.DeepEqual(config.Static[0].Regions, []string{"us-east-1"}) {
+		t.Errorf("expected the configured regions for the static job, got %v", config.Static[0].Regions)
+	}
+
+	// The default region is kept when the config is loaded again, e.g. on reload
+	config.Discovery.Jobs[0].Regions = nil
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 1 {
+		t.Errorf("expected the default region to be looked up once, got %d", *calls)
+	}
+
+	// The default region isn't looked up when every job has regions
+	defaultRegion, calls = countingDefaultRegion("eu-central-1")
+	configFile = "testdata/max_datapoint_age.ok.yml"
+	if err := (&ScrapeConf{DefaultRegion: defaultRegion}).Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 0 {
+		t.Errorf("expected the default region not to be looked up, got %d calls", *calls)
+	}
+
+	configFile = "testdata/default_region.ok.yml"
+	for _, config := range []*ScrapeConf{{}, {DefaultRegion: func() string { return "" }}} {
+		err := config.Load(&configFile, testServices)
+		if err == nil || err.Error() != "Discovery job [s3/0]: Regions should not be empty when no default region is set" {
+			t.Errorf("expected an error for the discovery job without regions, got %v", err)
+		}
+	}
+}
+
+func TestListMetricsCacheTTL(t *testing.T) {
+	// The ListMetrics responses aren't cached by default
+	if ttl := (&Discovery{}).GetListMetricsCacheTTL(); ttl != 0 {
+		t.Errorf("expected the cache to be disabled by default, got a TTL of %v", ttl)
+	}
+
+	config := ScrapeConf{}
+	configFile := "testdata/list_metrics_cache_ttl.ok.yml"
+	if err := config.Load(&configFile, testServices); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := config.Discovery.GetListMetricsCacheTTL(); ttl != 10*time.Minute {
+		t.Errorf("expected the configured TTL, got %v", ttl)
+	}
+}
+
 func testServices(s string) bool {
 	switch s {
 	case
@@ -18,6 +18,7 @@ import (
 var Metrics = []prometheus.Collector{
 	promutil.CloudwatchAPICounter,
 	promutil.CloudwatchAPIErrorCounter,
+	promutil.CloudwatchAPIThrottleCounter,
 	promutil.CloudwatchGetMetricDataAPICounter,
 	promutil.CloudwatchGetMetricStatisticsAPICounter,
 	promutil.ResourceGroupTaggingAPICounter,
@@ -27,6 +28,28 @@ var Metrics = []prometheus.Collector{
 	promutil.Ec2APICounter,
 	promutil.DmsAPICounter,
 	promutil.StoragegatewayAPICounter,
+	promutil.BedrockAPICounter,
+	promutil.ElastiCacheAPICounter,
+	promutil.ConfigServiceAPICounter,
+	promutil.S3APICounter,
+	promutil.CloudFormationAPICounter,
+	promutil.IAMAPICounter,
+	promutil.MetricDataPartialCounter,
+	promutil.StaleDatapointsDroppedCounter,
+	promutil.LogsInsightsQueryCounter,
+	promutil.ListMetricsCacheHitCounter,
+	promutil.ScrapeJobDurationHistogram,
+	promutil.ScrapeJobPhaseDurationHistogram,
+	promutil.SeriesLimitExceededGauge,
+	promutil.ResourceSampleRatioGauge,
+	promutil.ResourcesSampledGauge,
+	promutil.RateLimiterWaitGauge,
+	promutil.CircuitBreakerStateGauge,
+	promutil.STSFailuresCounter,
+	promutil.CredentialRefreshCounter,
+	promutil.TriggeredScrapesCounter,
+	promutil.LabelsSanitizedCounter,
+	promutil.LabelsDroppedCounter,
 }
 
 // UpdateMetrics can be used to scrape metrics from AWS on demand using the provided parameters. Scraped metrics will be added to the provided registry and
@@ -43,9 +66,32 @@ func UpdateMetrics(
 	observedMetricLabels map[string]model.LabelSet,
 	logger logger.Logger,
 ) {
-	tagsData, cloudwatchData := job.ScrapeAwsData(
+	metrics, _, err := ScrapeMetrics(ctx, config, metricsPerQuery, labelsSnakeCase, cloudwatchSemaphore, tagSemaphore, cache, observedMetricLabels, logger)
+	if err != nil {
+		logger.Error(err, "Error migrating cloudwatch metrics to prometheus metrics")
+		return
+	}
+
+	registry.MustRegister(promutil.NewPrometheusCollector(metrics))
+}
+
+// ScrapeMetrics scrapes metrics from AWS like UpdateMetrics, but returns them instead of registering them, e.g. to push them
+// to another backend than Prometheus, along with the errors of the jobs which failed. The failed jobs don't fail the scrape,
+// they are reported by the yace_scrape_job_success gauge.
+func ScrapeMetrics(
+	ctx context.Context,
+	config config.ScrapeConf,
+	metricsPerQuery int,
+	labelsSnakeCase bool,
+	cloudwatchSemaphore, tagSemaphore chan struct{},
+	cache session.SessionCache,
+	observedMetricLabels map[string]model.LabelSet,
+	logger logger.Logger,
+) ([]*promutil.PrometheusMetric, []*job.JobError, error) {
+	result := job.Scrape(
 		ctx,
 		config,
+		job.ScrapeOptions{},
 		metricsPerQuery,
 		cloudwatchSemaphore,
 		tagSemaphore,
@@ -53,14 +99,14 @@ func UpdateMetrics(
 		logger,
 	)
 
-	metrics, observedMetricLabels, err := job.MigrateCloudwatchToPrometheus(cloudwatchData, labelsSnakeCase, observedMetricLabels, logger)
+	metrics, observedMetricLabels, err := job.MigrateCloudwatchToPrometheus(result.CloudwatchData, labelsSnakeCase, config.LabelSanitization, observedMetricLabels, logger)
 	if err != nil {
-		logger.Error(err, "Error migrating cloudwatch metrics to prometheus metrics")
-		return
+		return nil, result.Errors, err
 	}
 	metrics = job.EnsureLabelConsistencyForMetrics(metrics, observedMetricLabels)
 
-	metrics = append(metrics, services.MigrateTagsToPrometheus(tagsData, labelsSnakeCase, logger)...)
+	metrics = append(metrics, services.MigrateTagsToPrometheus(result.Resources, labelsSnakeCase, config.LabelSanitization, logger)...)
+	metrics = append(metrics, result.JobMetrics...)
 
-	registry.MustRegister(promutil.NewPrometheusCollector(metrics))
+	return metrics, result.Errors, nil
 }
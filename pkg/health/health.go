@@ -0,0 +1,126 @@
+// Package health tracks the outcome of the scrapes to report the readiness and liveness of YACE.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/job"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// Tracker records the outcome of every scrape. YACE is ready once a scrape succeeded and alive
+// until maxConsecutiveFailures scrapes in a row failed.
+type Tracker struct {
+	mu                     sync.Mutex
+	maxConsecutiveFailures int
+	status                 Status
+}
+
+// Status is the summary of the last scrapes, exposed as JSON by the health endpoints
+type Status struct {
+	Ready                bool                `json:"ready"`
+	Live                 bool                `json:"live"`
+	LastScrape           *time.Time          `json:"lastScrape,omitempty"`
+	LastSuccessfulScrape *time.Time          `json:"lastSuccessfulScrape,omitempty"`
+	ConsecutiveFailures  int                 `json:"consecutiveFailures"`
+	Jobs                 int                 `json:"jobs"`
+	FailedJobs           []map[string]string `json:"failedJobs"`
+	Error                string              `json:"error,omitempty"`
+}
+
+// NewTracker creates a Tracker. With maxConsecutiveFailures of 0, failed scrapes never fail the liveness.
+func NewTracker(maxConsecutiveFailures int) *Tracker {
+	return &Tracker{
+		maxConsecutiveFailures: maxConsecutiveFailures,
+		status: Status{
+			Live:       true,
+			FailedJobs: []map[string]string{},
+		},
+	}
+}
+
+// Record records the outcome of a scrape finished at now, from the job success gauges in metrics, the errors
+// of the failed jobs and the error of the scrape. A scrape fails on error or when every job failed.
+// The failed jobs are reported by the labels of their gauge, with their error, when known, as an "error" label.
+func (t *Tracker) Record(now time.Time, metrics []*promutil.PrometheusMetric, jobErrs []*job.JobError, err error) {
+	errsByJob := make(map[[5]string]string, len(jobErrs))
+	for _, jobErr := range jobErrs {
+		errsByJob[[5]string{jobErr.JobType, jobErr.JobName, jobErr.Region, jobErr.Account, jobErr.RoleArn}] = jobErr.Err.Error()
+	}
+
+	jobs := 0
+	failedJobs := []map[string]string{}
+	for _, metric := range metrics {
+		if metric.Name == nil || *metric.Name != job.ScrapeJobSuccessMetric {
+			continue
+		}
+		jobs++
+		if metric.Value == nil || *metric.Value != 1 {
+			failedJob := make(map[string]string, len(metric.Labels)+1)
+			for name, value := range metric.Labels {
+				failedJob[name] = value
+			}
+			key := [5]string{metric.Labels["job_type"], metric.Labels["job_name"], metric.Labels["region"], metric.Labels["account"], metric.Labels["arn"]}
+			if jobErr, ok := errsByJob[key]; ok {
+				failedJob["error"] = jobErr
+			}
+			failedJobs = append(failedJobs, failedJob)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.LastScrape = &now
+	t.status.Jobs = jobs
+	t.status.FailedJobs = failedJobs
+	t.status.Error = ""
+	if err != nil {
+		t.status.Error = err.Error()
+	}
+
+	if err != nil || (jobs > 0 && len(failedJobs) == jobs) {
+		t.status.ConsecutiveFailures++
+	} else {
+		t.status.ConsecutiveFailures = 0
+		t.status.LastSuccessfulScrape = &now
+		t.status.Ready = true
+	}
+	t.status.Live = t.maxConsecutiveFailures <= 0 || t.status.ConsecutiveFailures < t.maxConsecutiveFailures
+}
+
+// Status returns the current status
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+// ReadinessHandler responds with the status, with 503 Service Unavailable until a scrape succeeded
+func (t *Tracker) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := t.Status()
+		writeStatus(w, status, status.Ready)
+	}
+}
+
+// LivenessHandler responds with the status, with 503 Service Unavailable once too many scrapes failed in a row
+func (t *Tracker) LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := t.Status()
+		writeStatus(w, status, status.Live)
+	}
+}
+
+func writeStatus(w http.ResponseWriter, status Status, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,148 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/job"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+func jobMetric(region string, success float64) *promutil.PrometheusMetric {
+	return &promutil.PrometheusMetric{
+		Name:   aws.String(job.ScrapeJobSuccessMetric),
+		Labels: map[string]string{"job_type": "ec2", "region": region},
+		Value:  aws.Float64(success),
+	}
+}
+
+func TestTracker(t *testing.T) {
+	otherMetric := &promutil.PrometheusMetric{Name: aws.String("aws_ec2_cpuutilization_average"), Value: aws.Float64(0)}
+	allFailed := []*promutil.PrometheusMetric{otherMetric, jobMetric("us-east-1", 0), jobMetric("eu-west-1", 0)}
+	someFailed := []*promutil.PrometheusMetric{otherMetric, jobMetric("us-east-1", 1), jobMetric("eu-west-1", 0)}
+
+	type scrape struct {
+		metrics []*promutil.PrometheusMetric
+		err     error
+	}
+	testCases := []struct {
+		name                        string
+		scrapes                     []scrape
+		expectedReady               bool
+		expectedLive                bool
+		expectedConsecutiveFailures int
+		expectedFailedJobs          int
+	}{
+		{
+			name:          "no scrape yet",
+			expectedReady: false,
+			expectedLive:  true,
+		},
+		{
+			name:               "partial failure is a success",
+			scrapes:            []scrape{{metrics: someFailed}},
+			expectedReady:      true,
+			expectedLive:       true,
+			expectedFailedJobs: 1,
+		},
+		{
+			name:                        "every job failed",
+			scrapes:                     []scrape{{metrics: allFailed}, {metrics: allFailed}},
+			expectedReady:               false,
+			expectedLive:                true,
+			expectedConsecutiveFailures: 2,
+			expectedFailedJobs:          2,
+		},
+		{
+			name:                        "too many consecutive failures",
+			scrapes:                     []scrape{{metrics: someFailed}, {metrics: allFailed}, {err: errors.New("failed")}, {metrics: allFailed}},
+			expectedReady:               true,
+			expectedLive:                false,
+			expectedConsecutiveFailures: 3,
+			expectedFailedJobs:          2,
+		},
+		{
+			name:                        "success resets the failures",
+			scrapes:                     []scrape{{metrics: allFailed}, {metrics: allFailed}, {metrics: someFailed}},
+			expectedReady:               true,
+			expectedLive:                true,
+			expectedConsecutiveFailures: 0,
+			expectedFailedJobs:          1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := NewTracker(3)
+			for _, s := range tc.scrapes {
+				tracker.Record(time.Now(), s.metrics, nil, s.err)
+			}
+
+			status := tracker.Status()
+			assert.Equal(t, tc.expectedReady, status.Ready)
+			assert.Equal(t, tc.expectedLive, status.Live)
+			assert.Equal(t, tc.expectedConsecutiveFailures, status.ConsecutiveFailures)
+			assert.Len(t, status.FailedJobs, tc.expectedFailedJobs)
+		})
+	}
+}
+
+func TestTrackerWithoutMaxConsecutiveFailures(t *testing.T) {
+	tracker := NewTracker(0)
+	for i := 0; i < 10; i++ {
+		tracker.Record(time.Now(), nil, nil, errors.New("failed"))
+	}
+	assert.True(t, tracker.Status().Live)
+}
+
+func TestHandlers(t *testing.T) {
+	tracker := NewTracker(1)
+
+	rec := httptest.NewRecorder()
+	tracker.ReadinessHandler()(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	now := time.Unix(1700000000, 0).UTC()
+	tracker.Record(now, []*promutil.PrometheusMetric{jobMetric("us-east-1", 1)}, nil, nil)
+	rec = httptest.NewRecorder()
+	tracker.ReadinessHandler()(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var status Status
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, now, *status.LastScrape)
+	assert.Equal(t, 1, status.Jobs)
+
+	tracker.Record(now, nil, nil, errors.New("failed to migrate metrics"))
+	rec = httptest.NewRecorder()
+	tracker.LivenessHandler()(rec, httptest.NewRequest(http.MethodGet, "/live", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.Equal(t, "failed to migrate metrics", status.Error)
+	assert.Equal(t, now, *status.LastSuccessfulScrape)
+}
+
+func TestTrackerFailedJobErrors(t *testing.T) {
+	tracker := NewTracker(0)
+	metrics := []*promutil.PrometheusMetric{jobMetric("us-east-1", 1), jobMetric("eu-west-1", 0), jobMetric("eu-central-1", 0)}
+	jobErrs := []*job.JobError{{JobType: "ec2", Region: "eu-west-1", Err: errors.New("access denied")}}
+
+	tracker.Record(time.Now(), metrics, jobErrs, nil)
+
+	assert.Equal(t, []map[string]string{
+		{"job_type": "ec2", "region": "eu-west-1", "error": "access denied"},
+		// The failed job without an error only has the labels of its gauge
+		{"job_type": "ec2", "region": "eu-central-1"},
+	}, tracker.Status().FailedJobs)
+	// The labels of the gauges are left untouched
+	assert.NotContains(t, metrics[1].Labels, "error")
+}
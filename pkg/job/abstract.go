@@ -2,20 +2,224 @@ package job
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
 	"math/rand"
 	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/session"
 )
 
+// ScrapeJobSuccessMetric is the name of the gauge reporting, for every job, region and role,
+// whether the last scrape succeeded
+const ScrapeJobSuccessMetric = "yace_scrape_job_success"
+
+// JobError is the error of the scrape of a job for one region and role
+type JobError struct {
+	JobType string
+	JobName string
+	Region  string
+	// Account is the account id of the role, empty when it's unknown
+	Account string
+	RoleArn string
+	Err     error
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("job %s/%s in region %s of account %q with role %q: %v", e.JobType, e.JobName, e.Region, e.Account, e.RoleArn, e.Err)
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// errJobNotScraped is the error of the failed jobs which didn't return an error of their own
+var errJobNotScraped = errors.New("the job was not scraped")
+
+// jobScrapeStatus tracks the outcome of scraping a single job for one region and role
+type jobScrapeStatus struct {
+	labels  map[string]string
+	start   time.Time
+	success bool
+	// err is why the job failed, sent to errCh when it finishes, unless errCh is nil
+	err   error
+	errCh chan<- *JobError
+}
+
+func newJobScrapeStatus(jobType string, jobName string, region string, role config.Role, errCh chan<- *JobError) *jobScrapeStatus {
+	return &jobScrapeStatus{
+		labels: map[string]string{
+			"job_type": jobType,
+			"job_name": jobName,
+			"region":   region,
+			"account":  "",
+			"arn":      role.RoleArn,
+		},
+		start: time.Now(),
+		errCh: errCh,
+	}
+}
+
+// setResult records the result of the job, successful when err is nil
+func (s *jobScrapeStatus) setResult(err error) {
+	s.success = err == nil
+	s.err = err
+}
+
+// finish records the duration of the job scrape, sends its error if it failed, and returns its success gauge
+func (s *jobScrapeStatus) finish() *promutil.PrometheusMetric {
+	promutil.ScrapeJobDurationHistogram.With(s.labels).Observe(time.Since(s.start).Seconds())
+	if !s.success && s.errCh != nil {
+		err := s.err
+		if err == nil {
+			err = errJobNotScraped
+		}
+		s.errCh <- &JobError{
+			JobType: s.labels["job_type"],
+			JobName: s.labels["job_name"],
+			Region:  s.labels["region"],
+			Account: s.labels["account"],
+			RoleArn: s.labels["arn"],
+			Err:     err,
+		}
+	}
+
+	name := ScrapeJobSuccessMetric
+	var value float64
+	if s.success {
+		value = 1
+	}
+	return &promutil.PrometheusMetric{
+		Name:   &name,
+		Labels: s.labels,
+		Value:  &value,
+	}
+}
+
+// DiscoveredResourcesMetric and DiscoveredResourcesWithMetricsMetric are the names of the gauges reporting, for every
+// discovery job, region and account, the number of resources discovered by the last scrape and how many of them had
+// at least one datapoint. Both are exported even when zero, e.g. after a change of the tags of the resources.
+const (
+	DiscoveredResourcesMetric            = "yace_discovered_resources"
+	DiscoveredResourcesWithMetricsMetric = "yace_discovered_resources_with_metrics"
+)
+
+// jobCloudwatchData forwards the cloudwatch data of a job, setting their role label when enabled, and records
+// the ids of the data with a datapoint, and the data themselves in scrape when the job has a ScrapeInterval
+type jobCloudwatchData struct {
+	ch        chan *cloudwatchData
+	done      chan struct{}
+	closeOnce sync.Once
+	ids       map[string]struct{}
+	scrape    *scheduledScrape
+}
+
+func newJobCloudwatchData(cwData chan<- *cloudwatchData, roleLabel *string, scrape *scheduledScrape) *jobCloudwatchData {
+	j := &jobCloudwatchData{
+		ch:     make(chan *cloudwatchData),
+		done:   make(chan struct{}),
+		ids:    map[string]struct{}{},
+		scrape: scrape,
+	}
+	go func() {
+		defer close(j.done)
+		for data := range j.ch {
+			if data.GetMetricDataPoint != nil || len(data.GetMetricDataPoints) > 0 {
+				j.ids[*data.ID] = struct{}{}
+			}
+			data.Role = roleLabel
+			j.scrape.record(data)
+			cwData <- data
+		}
+	}()
+	return j
+}
+
+// close stops forwarding the cloudwatch data once they are all forwarded
+func (j *jobCloudwatchData) close() {
+	j.closeOnce.Do(func() {
+		close(j.ch)
+		<-j.done
+	})
+}
+
+// discoveredResourcesMetrics stops forwarding the cloudwatch data and returns the gauges of the discovered resources
+func (j *jobCloudwatchData) discoveredResourcesMetrics(jobType string, region string, accountId string, resources []*services.TaggedResource) []*promutil.PrometheusMetric {
+	j.close()
+
+	withDatapoints := 0
+	for _, resource := range resources {
+		if _, ok := j.ids[resource.ARN]; ok {
+			withDatapoints++
+		}
+	}
+	newGauge := func(name string, value int) *promutil.PrometheusMetric {
+		v := float64(value)
+		labels := map[string]string{"job_type": jobType, "region": region, "account": accountId}
+		return &promutil.PrometheusMetric{Name: &name, Labels: labels, Value: &v}
+	}
+	return []*promutil.PrometheusMetric{
+		newGauge(DiscoveredResourcesMetric, len(resources)),
+		newGauge(DiscoveredResourcesWithMetricsMetric, withDatapoints),
+	}
+}
+
+// getRoleLabelIfEnabled returns the role label of the cloudwatch data of the jobs of role, its Alias or else its
+// RoleArn, when enabled, or nil
+func getRoleLabelIfEnabled(enabled bool, role config.Role) *string {
+	if !enabled {
+		return nil
+	}
+	if role.Alias != "" {
+		return aws.String(role.Alias)
+	}
+	return aws.String(role.RoleArn)
+}
+
+// getAccountId returns the account id of role from STS, see accountIds, or, when STS fails, e.g. because it is
+// blocked by an SCP, the AccountId configured for the role. It returns the error of STS when the account id is unknown.
+func getAccountId(ctx context.Context, accounts *accountIds, cache session.SessionCache, role config.Role, region string, logger logger.Logger) (*string, error) {
+	account, err := accounts.get(ctx, cache, role)
+	if err == nil {
+		return account, nil
+	}
+	promutil.STSFailuresCounter.WithLabelValues(region, role.RoleArn).Inc()
+	if role.AccountId == "" {
+		logger.Error(err, "Couldn't get account Id")
+		return nil, fmt.Errorf("couldn't get account id: %w", err)
+	}
+	logger.Warn("Couldn't get account Id, using the account id configured for the role", "err", err)
+	return aws.String(role.AccountId), nil
+}
+
+// Phases of the scrape of a job reported by yace_scrape_job_phase_duration_seconds
+const (
+	phaseTagging             = "tagging"
+	phaseListMetrics         = "list_metrics"
+	phaseGetMetricData       = "get_metric_data"
+	phaseGetMetricStatistics = "get_metric_statistics"
+)
+
+// observePhaseDuration records the time spent since start in a phase of the scrape of a job
+func observePhaseDuration(jobType string, region string, phase string, start time.Time) {
+	promutil.ScrapeJobPhaseDurationHistogram.WithLabelValues(jobType, region, phase).Observe(time.Since(start).Seconds())
+}
+
+// ScrapeAwsData scrapes all the jobs defined in cfg. Along with the discovered resources and
+// cloudwatch data it returns, for every job, region and role, a gauge reporting whether the scrape succeeded,
+// the discovered resources gauges of the discovery jobs, the alarm state gauges of the alarms jobs and the
+// result gauges of the logs insights jobs.
+// It is Scrape with the default ScrapeOptions, without the errors of the failed jobs.
 func ScrapeAwsData(
 	ctx context.Context,
 	cfg config.ScrapeConf,
@@ -24,135 +228,687 @@ func ScrapeAwsData(
 	tagSemaphore chan struct{},
 	cache session.SessionCache,
 	logger logger.Logger,
-) ([]*services.TaggedResource, []*cloudwatchData) {
-	mux := &sync.Mutex{}
+) ([]*services.TaggedResource, []*cloudwatchData, []*promutil.PrometheusMetric) {
+	result := Scrape(ctx, cfg, ScrapeOptions{}, metricsPerQuery, cloudwatchSemaphore, tagSemaphore, cache, logger)
+	return result.Resources, result.CloudwatchData, result.JobMetrics
+}
+
+// ResourceDiscovererFactory returns the discoverer of the resources of the discovery jobs scraped in region with
+// role, whose account is accountId, e.g. one supplying them from an inventory instead of the AWS APIs. It returns
+// nil to discover them with the AWS APIs, the default.
+type ResourceDiscovererFactory func(role config.Role, region string, accountId string) services.ResourceDiscoverer
+
+// ScrapeAwsDataWithDiscoverer works like ScrapeAwsData but the discovery jobs discover their resources with the
+// discoverers returned by newDiscoverer. The resources they return are still filtered by the ExcludeTags and
+// CloudFormationStack of the jobs, but the jobs with custom discoverers don't support IncrementalDiscovery.
+func ScrapeAwsDataWithDiscoverer(
+	ctx context.Context,
+	cfg config.ScrapeConf,
+	newDiscoverer ResourceDiscovererFactory,
+	metricsPerQuery int,
+	cloudwatchSemaphore,
+	tagSemaphore chan struct{},
+	cache session.SessionCache,
+	logger logger.Logger,
+) ([]*services.TaggedResource, []*cloudwatchData, []*promutil.PrometheusMetric) {
+	result := Scrape(ctx, cfg, ScrapeOptions{NewDiscoverer: newDiscoverer}, metricsPerQuery, cloudwatchSemaphore, tagSemaphore, cache, logger)
+	return result.Resources, result.CloudwatchData, result.JobMetrics
+}
+
+// CollectMetrics works like ScrapeAwsData but the discovery jobs query the metrics of the resources in discovered,
+// as returned by DiscoverResources, e.g. during a previous scrape, instead of discovering them. The discovery jobs
+// missing from discovered, whose discovery failed, are reported as failed. A nil discovered discovers the
+// resources during the scrape, which is what ScrapeAwsData does.
+func CollectMetrics(
+	ctx context.Context,
+	cfg config.ScrapeConf,
+	discovered DiscoveredResources,
+	metricsPerQuery int,
+	cloudwatchSemaphore,
+	tagSemaphore chan struct{},
+	cache session.SessionCache,
+	logger logger.Logger,
+) ([]*services.TaggedResource, []*cloudwatchData, []*promutil.PrometheusMetric) {
+	result := Scrape(ctx, cfg, ScrapeOptions{Discovered: discovered}, metricsPerQuery, cloudwatchSemaphore, tagSemaphore, cache, logger)
+	return result.Resources, result.CloudwatchData, result.JobMetrics
+}
+
+// ScrapeOptions change how the resources of the discovery jobs are discovered, the zero value discovers them
+// with the AWS APIs during the scrape
+type ScrapeOptions struct {
+	// Discovered are the resources of the discovery jobs, as returned by DiscoverResources, e.g. during a previous
+	// scrape, whose metrics are queried instead of discovering them. The discovery jobs missing from it, whose
+	// discovery failed, are reported as failed. Nil discovers the resources during the scrape.
+	Discovered DiscoveredResources
+	// NewDiscoverer returns the discoverers of the resources discovered during the scrape, e.g. from a CMDB. The
+	// resources they return are still filtered by the ExcludeTags and CloudFormationStack of the jobs, but the
+	// jobs with custom discoverers don't support IncrementalDiscovery. Nil discovers them with the AWS APIs.
+	NewDiscoverer ResourceDiscovererFactory
+}
+
+// ScrapeResult is the outcome of the scrape of all the jobs of a config
+type ScrapeResult struct {
+	Resources      []*services.TaggedResource
+	CloudwatchData []*cloudwatchData
+	// JobMetrics are the gauges returned by ScrapeAwsData along with the resources and cloudwatch data
+	JobMetrics []*promutil.PrometheusMetric
+	// Errors are the errors of the jobs which failed, one per job, region and role reported as failed by its
+	// yace_scrape_job_success gauge, e.g. because of STS, the discovery of its resources or its CloudWatch requests
+	Errors []*JobError
+}
+
+// Scrape scrapes all the jobs defined in cfg like ScrapeAwsData, discovering the resources of the discovery jobs
+// as set by opts, and also returns the errors of the jobs which failed
+func Scrape(
+	ctx context.Context,
+	cfg config.ScrapeConf,
+	opts ScrapeOptions,
+	metricsPerQuery int,
+	cloudwatchSemaphore,
+	tagSemaphore chan struct{},
+	cache session.SessionCache,
+	logger logger.Logger,
+) ScrapeResult {
+	result := ScrapeResult{
+		Resources:      make([]*services.TaggedResource, 0),
+		CloudwatchData: make([]*cloudwatchData, 0),
+		JobMetrics:     make([]*promutil.PrometheusMetric, 0),
+	}
+
+	resourceCh, cwDataCh, jobMetricCh, errCh := ScrapeAwsDataStream(ctx, cfg, opts, metricsPerQuery, cloudwatchSemaphore, tagSemaphore, cache, logger)
+	for resourceCh != nil || cwDataCh != nil || jobMetricCh != nil || errCh != nil {
+		select {
+		case resource, ok := <-resourceCh:
+			if !ok {
+				resourceCh = nil
+				continue
+			}
+			result.Resources = append(result.Resources, resource)
+		case data, ok := <-cwDataCh:
+			if !ok {
+				cwDataCh = nil
+				continue
+			}
+			result.CloudwatchData = append(result.CloudwatchData, data)
+		case jobMetric, ok := <-jobMetricCh:
+			if !ok {
+				jobMetricCh = nil
+				continue
+			}
+			result.JobMetrics = append(result.JobMetrics, jobMetric)
+		case err, ok := <-errCh:
+			if !ok {
+				errCh = nil
+				continue
+			}
+			result.Errors = append(result.Errors, err)
+		}
+	}
+
+	// The gauges of logs insights queries returning the same field may have different label fields
+	jobMetricLabels := make(map[string]model.LabelSet)
+	for _, jobMetric := range result.JobMetrics {
+		jobMetricLabels = recordLabelsForMetric(*jobMetric.Name, jobMetric.Labels, jobMetricLabels)
+	}
+	result.JobMetrics = EnsureLabelConsistencyForMetrics(result.JobMetrics, jobMetricLabels)
+
+	return result
+}
 
-	cwData := make([]*cloudwatchData, 0)
-	awsInfoData := make([]*services.TaggedResource, 0)
+// ScrapeAwsDataStream works like Scrape but, instead of buffering the whole scrape in memory,
+// it sends resources and cloudwatch data as soon as each GetMetricData partition completes,
+// and the error of every job as soon as it fails.
+// All the returned channels are closed once every job is done: callers must keep receiving
+// from all of them until then, otherwise the scrape blocks.
+func ScrapeAwsDataStream(
+	ctx context.Context,
+	cfg config.ScrapeConf,
+	opts ScrapeOptions,
+	metricsPerQuery int,
+	cloudwatchSemaphore,
+	tagSemaphore chan struct{},
+	cache session.SessionCache,
+	logger logger.Logger,
+) (<-chan *services.TaggedResource, <-chan *cloudwatchData, <-chan *promutil.PrometheusMetric, <-chan *JobError) {
+	resourceCh := make(chan *services.TaggedResource)
+	cwDataCh := make(chan *cloudwatchData)
+	jobMetricCh := make(chan *promutil.PrometheusMetric)
+	errCh := make(chan *JobError)
 	var wg sync.WaitGroup
+	// The jobs with a ScrapeInterval are due according to the start of the scrape, not of the job
+	scrapeStart := jobSchedules.clock.Now()
+
+	// regions have to be resolved before refreshing, as resolving them
+	// registers new clients in the cache
+	allRegions := resolveAllRegions(ctx, cfg, cache, logger)
 
 	// since we have called refresh, we have loaded all the credentials
-	// into the clients and it is now safe to call concurrently. Defer the
-	// clearing, so we always clear credentials before the next scrape
+	// into the clients and it is now safe to call concurrently. The
+	// clearing happens once all jobs are done, so we always clear
+	// credentials before the next scrape
 	cache.Refresh()
-	defer cache.Clear()
 
+	// AWS Config aggregators are queried once per scrape for all the regions of a job
+	configCache := services.NewConfigCache()
+	stackCache := services.NewStackCache()
+	accounts := newAccountIds()
+
+	var roles []config.Role
 	for _, discoveryJob := range cfg.Discovery.Jobs {
+		roles = append(roles, discoveryJob.Roles...)
+	}
+	for _, staticJob := range cfg.Static {
+		roles = append(roles, staticJob.Roles...)
+	}
+	for _, customNamespaceJob := range cfg.CustomNamespace {
+		roles = append(roles, customNamespaceJob.Roles...)
+	}
+	for _, alarmsJob := range cfg.Alarms {
+		roles = append(roles, alarmsJob.Roles...)
+	}
+	for _, logsInsightsJob := range cfg.LogsInsights {
+		roles = append(roles, logsInsightsJob.Roles...)
+	}
+	for _, rdsJob := range cfg.RDSEnhancedMonitoring {
+		roles = append(roles, rdsJob.Roles...)
+	}
+	semaphores := newRoleSemaphores(roles, cloudwatchSemaphore, tagSemaphore)
+
+	for jobIdx, discoveryJob := range cfg.Discovery.Jobs {
 		for _, role := range discoveryJob.Roles {
-			for _, region := range discoveryJob.Regions {
+			for _, region := range expandRegions(discoveryJob.Regions, allRegions[role]) {
 				wg.Add(1)
-				go func(discoveryJob *config.Job, region string, role config.Role) {
+				go func(jobIdx int, discoveryJob *config.Job, region string, role config.Role) {
 					defer wg.Done()
+					scrape, replayed := jobSchedules.start(scheduleKey{Kind: scheduleKindDiscovery, JobIndex: jobIdx, JobName: discoveryJob.Type, Region: region, Role: role}, discoveryJob.ScrapeInterval, scrapeStart, resourceCh, cwDataCh, jobMetricCh)
+					if replayed {
+						return
+					}
+					status := newJobScrapeStatus(discoveryJob.Type, "", region, role, errCh)
+					defer scrape.finish(status, jobMetricCh)
+
+					if !waitJitter(ctx, cfg.Discovery.Jitter) {
+						status.setResult(ctx.Err())
+						return
+					}
+
+					jobCtx, cancel := withJobTimeout(ctx, discoveryJob.Timeout)
+					defer cancel()
+
 					jobLogger := logger.With("job_type", discoveryJob.Type, "region", region, "arn", role.RoleArn)
-					result, err := cache.GetSTS(role).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
-					if err != nil || result.Account == nil {
-						jobLogger.Error(err, "Couldn't get account Id")
+					accountId, err := getAccountId(jobCtx, accounts, cache, role, region, jobLogger)
+					if err != nil {
+						status.setResult(err)
 						return
 					}
-					jobLogger = jobLogger.With("account", *result.Account)
+					jobLogger = jobLogger.With("account", *accountId)
+					status.labels["account"] = *accountId
+					accountAlias := getAccountAliasIfEnabled(jobCtx, cfg.Discovery.AccountAlias, cache, role, *accountId, jobLogger)
 
 					clientCloudwatch := cloudwatchInterface{
-						client: cache.GetCloudwatch(&region, role),
-						logger: jobLogger,
+						client:               cache.GetCloudwatch(&region, role),
+						region:               region,
+						retry:                cfg.Discovery.Retry,
+						listMetricsCache:     getListMetricsCache(*accountId, region, cfg.Discovery.GetListMetricsCacheTTL()),
+						logger:               jobLogger,
+						recentlyActiveOnly:   discoveryJob.RecentlyActiveOnly,
+						getMetricDataLimiter: getRateLimiter(*accountId, region, "GetMetricData", cfg.Discovery.RateLimits.GetMetricData),
+						listMetricsLimiter:   getRateLimiter(*accountId, region, "ListMetrics", cfg.Discovery.RateLimits.ListMetrics),
+						getMetricDataBreaker: getCircuitBreaker(*accountId, region, "GetMetricData", cfg.Discovery.CircuitBreaker),
+						listMetricsBreaker:   getCircuitBreaker(*accountId, region, "ListMetrics", cfg.Discovery.CircuitBreaker),
 					}
 
-					clientTag := services.TagsInterface{
-						Client:               cache.GetTagging(&region, role),
-						ApiGatewayClient:     cache.GetAPIGateway(&region, role),
-						AsgClient:            cache.GetASG(&region, role),
-						DmsClient:            cache.GetDMS(&region, role),
-						Ec2Client:            cache.GetEC2(&region, role),
-						StoragegatewayClient: cache.GetStorageGateway(&region, role),
-						PrometheusClient:     cache.GetPrometheus(&region, role),
-						Logger:               jobLogger,
+					clientTag := newTagsInterface(cache, discoveryJob, region, role, *accountId, configCache, stackCache, cfg.Discovery, jobLogger)
+
+					var jobResources []*services.TaggedResource
+					if opts.Discovered != nil {
+						var ok bool
+						jobResources, ok = opts.Discovered[DiscoveryJobKey{JobIndex: jobIdx, Type: discoveryJob.Type, Region: region, Role: role}]
+						if !ok {
+							jobLogger.Warn("The resources of the job weren't discovered, skipping it")
+							status.setResult(errors.New("the resources of the job weren't discovered"))
+							return
+						}
 					}
 
-					resources, metrics := scrapeDiscoveryJobUsingMetricData(ctx, discoveryJob, region, result.Account, cfg.Discovery.ExportedTagsOnMetrics, clientTag, clientCloudwatch, metricsPerQuery, discoveryJob.RoundingPeriod, tagSemaphore, jobLogger)
-					if len(resources) != 0 && len(metrics) != 0 {
-						mux.Lock()
-						awsInfoData = append(awsInfoData, resources...)
-						cwData = append(cwData, metrics...)
-						mux.Unlock()
+					jobCwData := newJobCloudwatchData(cwDataCh, getRoleLabelIfEnabled(cfg.Discovery.RoleLabel, role), scrape)
+					var resources []*services.TaggedResource
+					if opts.Discovered == nil {
+						var discoverer services.ResourceDiscoverer = clientTag
+						if opts.NewDiscoverer != nil {
+							if custom := opts.NewDiscoverer(role, region, *accountId); custom != nil {
+								discoverer = custom
+							}
+						}
+						resources, err = scrapeDiscoveryJobUsingMetricData(jobCtx, discoveryJob, region, accountId, accountAlias, cfg.Discovery.ExportedTagsOnMetrics, discoverer, clientTag, clientCloudwatch, metricsPerQuery, discoveryJob.RoundingPeriod, semaphores[role].cloudwatch, semaphores[role].tag, jobCwData.ch, jobLogger)
+					} else {
+						resources, err = scrapeDiscoveredResources(jobCtx, discoveryJob, region, accountId, accountAlias, cfg.Discovery.ExportedTagsOnMetrics, clientTag, clientCloudwatch, jobResources, metricsPerQuery, discoveryJob.RoundingPeriod, semaphores[role].cloudwatch, semaphores[role].tag, jobCwData.ch, jobLogger)
+					}
+					for _, metric := range jobCwData.discoveredResourcesMetrics(discoveryJob.Type, region, *accountId, resources) {
+						scrape.sendJobMetric(jobMetricCh, metric)
 					}
-				}(discoveryJob, region, role)
+					status.setResult(err)
+					logJobTimeout(ctx, jobCtx, discoveryJob.Timeout, jobLogger)
+					for _, resource := range resources {
+						if discoveryJob.ExportResourceUp {
+							// The resources can be shared with other jobs and scrapes
+							upResource := *resource
+							upResource.ExportUp = true
+							resource = &upResource
+						}
+						scrape.sendResource(resourceCh, resource)
+					}
+				}(jobIdx, discoveryJob, region, role)
 			}
 		}
 	}
 
-	for _, staticJob := range cfg.Static {
+	for jobIdx, staticJob := range cfg.Static {
 		for _, role := range staticJob.Roles {
-			for _, region := range staticJob.Regions {
+			for _, region := range expandRegions(staticJob.Regions, allRegions[role]) {
 				wg.Add(1)
-				go func(staticJob *config.Static, region string, role config.Role) {
+				go func(jobIdx int, staticJob *config.Static, region string, role config.Role) {
 					defer wg.Done()
+					scrape, replayed := jobSchedules.start(scheduleKey{Kind: scheduleKindStatic, JobIndex: jobIdx, JobName: staticJob.Name, Region: region, Role: role}, staticJob.ScrapeInterval, scrapeStart, resourceCh, cwDataCh, jobMetricCh)
+					if replayed {
+						return
+					}
+					status := newJobScrapeStatus(staticJob.Namespace, staticJob.Name, region, role, errCh)
+					defer scrape.finish(status, jobMetricCh)
+
+					if !waitJitter(ctx, cfg.Discovery.Jitter) {
+						status.setResult(ctx.Err())
+						return
+					}
+
+					jobCtx, cancel := withJobTimeout(ctx, staticJob.Timeout)
+					defer cancel()
+
 					jobLogger := logger.With("static_job_name", staticJob.Name, "region", region, "arn", role.RoleArn)
-					result, err := cache.GetSTS(role).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
-					if err != nil || result.Account == nil {
-						jobLogger.Error(err, "Couldn't get account Id")
+					accountId, err := getAccountId(jobCtx, accounts, cache, role, region, jobLogger)
+					if err != nil {
+						status.setResult(err)
 						return
 					}
-					jobLogger = jobLogger.With("account", *result.Account)
+					jobLogger = jobLogger.With("account", *accountId)
+					status.labels["account"] = *accountId
+					accountAlias := getAccountAliasIfEnabled(jobCtx, cfg.Discovery.AccountAlias, cache, role, *accountId, jobLogger)
 
 					clientCloudwatch := cloudwatchInterface{
 						client: cache.GetCloudwatch(&region, role),
+						region: region,
 						logger: jobLogger,
 					}
 
-					metrics := scrapeStaticJob(ctx, staticJob, region, result.Account, clientCloudwatch, cloudwatchSemaphore, jobLogger)
-
-					mux.Lock()
-					cwData = append(cwData, metrics...)
-					mux.Unlock()
-				}(staticJob, region, role)
+					jobCwData := newJobCloudwatchData(cwDataCh, getRoleLabelIfEnabled(cfg.Discovery.RoleLabel, role), scrape)
+					err = scrapeStaticJob(jobCtx, staticJob, region, accountId, accountAlias, clientCloudwatch, semaphores[role].cloudwatch, jobCwData.ch, jobLogger)
+					jobCwData.close()
+					status.setResult(err)
+					logJobTimeout(ctx, jobCtx, staticJob.Timeout, jobLogger)
+				}(jobIdx, staticJob, region, role)
 			}
 		}
 	}
 
-	for _, customNamespaceJob := range cfg.CustomNamespace {
+	for jobIdx, customNamespaceJob := range cfg.CustomNamespace {
 		for _, role := range customNamespaceJob.Roles {
-			for _, region := range customNamespaceJob.Regions {
+			for _, region := range expandRegions(customNamespaceJob.Regions, allRegions[role]) {
 				wg.Add(1)
-				go func(customNamespaceJob *config.CustomNamespace, region string, role config.Role) {
+				go func(jobIdx int, customNamespaceJob *config.CustomNamespace, region string, role config.Role) {
 					defer wg.Done()
+					scrape, replayed := jobSchedules.start(scheduleKey{Kind: scheduleKindCustomNamespace, JobIndex: jobIdx, JobName: customNamespaceJob.Name, Region: region, Role: role}, customNamespaceJob.ScrapeInterval, scrapeStart, resourceCh, cwDataCh, jobMetricCh)
+					if replayed {
+						return
+					}
+					status := newJobScrapeStatus(customNamespaceJob.Namespace, customNamespaceJob.Name, region, role, errCh)
+					defer scrape.finish(status, jobMetricCh)
+
+					if !waitJitter(ctx, cfg.Discovery.Jitter) {
+						status.setResult(ctx.Err())
+						return
+					}
+
+					jobCtx, cancel := withJobTimeout(ctx, customNamespaceJob.Timeout)
+					defer cancel()
+
 					jobLogger := logger.With("custom_metric_namespace", customNamespaceJob.Namespace, "region", region, "arn", role.RoleArn)
-					result, err := cache.GetSTS(role).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
-					if err != nil || result.Account == nil {
-						jobLogger.Error(err, "Couldn't get account Id")
+					accountId, err := getAccountId(jobCtx, accounts, cache, role, region, jobLogger)
+					if err != nil {
+						status.setResult(err)
 						return
 					}
-					jobLogger = jobLogger.With("account", *result.Account)
+					jobLogger = jobLogger.With("account", *accountId)
+					status.labels["account"] = *accountId
+					accountAlias := getAccountAliasIfEnabled(jobCtx, cfg.Discovery.AccountAlias, cache, role, *accountId, jobLogger)
 
 					clientCloudwatch := cloudwatchInterface{
-						client: cache.GetCloudwatch(&region, role),
-						logger: jobLogger,
+						client:                cache.GetCloudwatch(&region, role),
+						region:                region,
+						retry:                 cfg.Discovery.Retry,
+						listMetricsCache:      getListMetricsCache(*accountId, region, cfg.Discovery.GetListMetricsCacheTTL()),
+						logger:                jobLogger,
+						includeLinkedAccounts: customNamespaceJob.IncludeLinkedAccounts,
+						recentlyActiveOnly:    customNamespaceJob.RecentlyActiveOnly,
+						getMetricDataLimiter:  getRateLimiter(*accountId, region, "GetMetricData", cfg.Discovery.RateLimits.GetMetricData),
+						listMetricsLimiter:    getRateLimiter(*accountId, region, "ListMetrics", cfg.Discovery.RateLimits.ListMetrics),
+						getMetricDataBreaker:  getCircuitBreaker(*accountId, region, "GetMetricData", cfg.Discovery.CircuitBreaker),
+						listMetricsBreaker:    getCircuitBreaker(*accountId, region, "ListMetrics", cfg.Discovery.CircuitBreaker),
 					}
 
-					metrics := scrapeCustomNamespaceJobUsingMetricData(
-						ctx,
+					jobCwData := newJobCloudwatchData(cwDataCh, getRoleLabelIfEnabled(cfg.Discovery.RoleLabel, role), scrape)
+					err = scrapeCustomNamespaceJobUsingMetricData(
+						jobCtx,
 						customNamespaceJob,
 						region,
-						result.Account,
+						accountId,
+						accountAlias,
 						clientCloudwatch,
-						cloudwatchSemaphore,
-						tagSemaphore,
+						semaphores[role].cloudwatch,
+						semaphores[role].tag,
+						jobCwData.ch,
 						jobLogger,
 						metricsPerQuery,
 					)
+					jobCwData.close()
+					status.setResult(err)
+					logJobTimeout(ctx, jobCtx, customNamespaceJob.Timeout, jobLogger)
+				}(jobIdx, customNamespaceJob, region, role)
+			}
+		}
+	}
 
-					mux.Lock()
-					cwData = append(cwData, metrics...)
-					mux.Unlock()
-				}(customNamespaceJob, region, role)
+	for _, alarmsJob := range cfg.Alarms {
+		for _, role := range alarmsJob.Roles {
+			for _, region := range expandRegions(alarmsJob.Regions, allRegions[role]) {
+				wg.Add(1)
+				go func(alarmsJob *config.Alarms, region string, role config.Role) {
+					defer wg.Done()
+					status := newJobScrapeStatus(AlarmsJobType, alarmsJob.Name, region, role, errCh)
+					defer func() {
+						jobMetricCh <- status.finish()
+					}()
+
+					if !waitJitter(ctx, cfg.Discovery.Jitter) {
+						status.setResult(ctx.Err())
+						return
+					}
+
+					jobCtx, cancel := withJobTimeout(ctx, alarmsJob.Timeout)
+					defer cancel()
+
+					jobLogger := logger.With("alarms_job_name", alarmsJob.Name, "region", region, "arn", role.RoleArn)
+					accountId, err := getAccountId(jobCtx, accounts, cache, role, region, jobLogger)
+					if err != nil {
+						status.setResult(err)
+						return
+					}
+					jobLogger = jobLogger.With("account", *accountId)
+					status.labels["account"] = *accountId
+					accountAlias := getAccountAliasIfEnabled(jobCtx, cfg.Discovery.AccountAlias, cache, role, *accountId, jobLogger)
+
+					clientCloudwatch := cloudwatchInterface{
+						client: cache.GetCloudwatch(&region, role),
+						region: region,
+						retry:  cfg.Discovery.Retry,
+						logger: jobLogger,
+					}
+
+					alarmMetrics, err := scrapeAlarmsJob(jobCtx, alarmsJob, region, accountId, accountAlias, clientCloudwatch, semaphores[role].cloudwatch, jobLogger)
+					status.setResult(err)
+					logJobTimeout(ctx, jobCtx, alarmsJob.Timeout, jobLogger)
+					for _, metric := range alarmMetrics {
+						jobMetricCh <- metric
+					}
+				}(alarmsJob, region, role)
 			}
 		}
 	}
-	wg.Wait()
-	return awsInfoData, cwData
+
+	for _, logsInsightsJob := range cfg.LogsInsights {
+		for _, role := range logsInsightsJob.Roles {
+			for _, region := range expandRegions(logsInsightsJob.Regions, allRegions[role]) {
+				wg.Add(1)
+				go func(logsInsightsJob *config.LogsInsights, region string, role config.Role) {
+					defer wg.Done()
+					status := newJobScrapeStatus(LogsInsightsJobType, logsInsightsJob.Name, region, role, errCh)
+					defer func() {
+						jobMetricCh <- status.finish()
+					}()
+
+					if !waitJitter(ctx, cfg.Discovery.Jitter) {
+						status.setResult(ctx.Err())
+						return
+					}
+
+					jobCtx, cancel := withJobTimeout(ctx, logsInsightsJob.Timeout)
+					defer cancel()
+
+					jobLogger := logger.With("logs_insights_job_name", logsInsightsJob.Name, "region", region, "arn", role.RoleArn)
+					accountId, err := getAccountId(jobCtx, accounts, cache, role, region, jobLogger)
+					if err != nil {
+						status.setResult(err)
+						return
+					}
+					jobLogger = jobLogger.With("account", *accountId)
+					status.labels["account"] = *accountId
+					accountAlias := getAccountAliasIfEnabled(jobCtx, cfg.Discovery.AccountAlias, cache, role, *accountId, jobLogger)
+
+					clientLogs := logsInsightsInterface{
+						client: cache.GetCloudwatchLogs(&region, role),
+						region: region,
+						retry:  cfg.Discovery.Retry,
+						logger: jobLogger,
+					}
+
+					// Partial results are exported even though the job failed
+					queryMetrics, err := scrapeLogsInsightsJob(jobCtx, logsInsightsJob, region, accountId, accountAlias, clientLogs, semaphores[role].cloudwatch, jobLogger)
+					status.setResult(err)
+					logJobTimeout(ctx, jobCtx, logsInsightsJob.Timeout, jobLogger)
+					for _, metric := range queryMetrics {
+						jobMetricCh <- metric
+					}
+				}(logsInsightsJob, region, role)
+			}
+		}
+	}
+
+	for _, rdsJob := range cfg.RDSEnhancedMonitoring {
+		for _, role := range rdsJob.Roles {
+			for _, region := range expandRegions(rdsJob.Regions, allRegions[role]) {
+				wg.Add(1)
+				go func(rdsJob *config.RDSEnhancedMonitoring, region string, role config.Role) {
+					defer wg.Done()
+					status := newJobScrapeStatus(RDSEnhancedMonitoringJobType, rdsJob.Name, region, role, errCh)
+					defer func() {
+						jobMetricCh <- status.finish()
+					}()
+
+					if !waitJitter(ctx, cfg.Discovery.Jitter) {
+						status.setResult(ctx.Err())
+						return
+					}
+
+					jobCtx, cancel := withJobTimeout(ctx, rdsJob.Timeout)
+					defer cancel()
+
+					jobLogger := logger.With("rds_enhanced_monitoring_job_name", rdsJob.Name, "region", region, "arn", role.RoleArn)
+					accountId, err := getAccountId(jobCtx, accounts, cache, role, region, jobLogger)
+					if err != nil {
+						status.setResult(err)
+						return
+					}
+					jobLogger = jobLogger.With("account", *accountId)
+					status.labels["account"] = *accountId
+					accountAlias := getAccountAliasIfEnabled(jobCtx, cfg.Discovery.AccountAlias, cache, role, *accountId, jobLogger)
+
+					clientTag := services.TagsInterface{
+						Client:         cache.GetTagging(&region, role),
+						AccountId:      *accountId,
+						Logger:         jobLogger,
+						TaggingOptions: taggingOptions(*accountId, region, cfg.Discovery),
+					}
+					clientRDS := rdsEnhancedMonitoringInterface{
+						rds:    cache.GetRDS(&region, role),
+						logs:   cache.GetCloudwatchLogs(&region, role),
+						region: region,
+						retry:  cfg.Discovery.Retry,
+						logger: jobLogger,
+					}
+
+					osMetrics, err := scrapeRDSEnhancedMonitoringJob(jobCtx, rdsJob, region, accountId, accountAlias, clientTag, clientRDS, semaphores[role].cloudwatch, semaphores[role].tag, jobLogger)
+					status.setResult(err)
+					logJobTimeout(ctx, jobCtx, rdsJob.Timeout, jobLogger)
+					for _, metric := range osMetrics {
+						jobMetricCh <- metric
+					}
+				}(rdsJob, region, role)
+			}
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		cache.Clear()
+		close(resourceCh)
+		close(cwDataCh)
+		close(jobMetricCh)
+		close(errCh)
+	}()
+
+	return resourceCh, cwDataCh, jobMetricCh, errCh
+}
+
+// withJobTimeout returns a context for a single job which is cancelled after timeout.
+// Without timeout, the job only stops when ctx is done.
+func withJobTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// logJobTimeout logs jobs which were abandoned because they reached their own
+// timeout, as opposed to the whole scrape being cancelled.
+func logJobTimeout(ctx context.Context, jobCtx context.Context, timeout time.Duration, logger logger.Logger) {
+	if ctx.Err() == nil && errors.Is(jobCtx.Err(), context.DeadlineExceeded) {
+		logger.Warn("Job timed out, its results are incomplete", "timeout", timeout)
+	}
+}
+
+// acquire takes a slot of semaphore. It returns false without taking it if
+// ctx is done first, so that cancelled scrapes don't wait for a free slot.
+func acquire(ctx context.Context, sem chan struct{}) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	select {
+	case sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waitJitter sleeps for a random duration up to jitter. It returns false if ctx
+// is done before, in which case the job should not start.
+func waitJitter(ctx context.Context, jitter time.Duration) bool {
+	if jitter <= 0 {
+		return true
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+		return true
+	}
+}
+
+// resolveAllRegions returns, for every role of a job using the config.AllRegions
+// wildcard, the regions enabled for its account.
+func resolveAllRegions(ctx context.Context, cfg config.ScrapeConf, cache session.SessionCache, logger logger.Logger) map[config.Role][]string {
+	var roles []config.Role
+	for _, job := range cfg.Discovery.Jobs {
+		if containsAllRegions(job.Regions) {
+			roles = append(roles, job.Roles...)
+		}
+	}
+	for _, job := range cfg.Static {
+		if containsAllRegions(job.Regions) {
+			roles = append(roles, job.Roles...)
+		}
+	}
+	for _, job := range cfg.CustomNamespace {
+		if containsAllRegions(job.Regions) {
+			roles = append(roles, job.Roles...)
+		}
+	}
+	for _, job := range cfg.Alarms {
+		if containsAllRegions(job.Regions) {
+			roles = append(roles, job.Roles...)
+		}
+	}
+	for _, job := range cfg.LogsInsights {
+		if containsAllRegions(job.Regions) {
+			roles = append(roles, job.Roles...)
+		}
+	}
+	for _, job := range cfg.RDSEnhancedMonitoring {
+		if containsAllRegions(job.Regions) {
+			roles = append(roles, job.Roles...)
+		}
+	}
+
+	allRegions := make(map[config.Role][]string)
+	for _, role := range roles {
+		if _, ok := allRegions[role]; ok {
+			continue
+		}
+		regions, err := cache.GetRegions(ctx, role)
+		if err != nil {
+			logger.Error(err, "Couldn't describe regions", "arn", role.RoleArn)
+			continue
+		}
+		allRegions[role] = regions
+	}
+	return allRegions
+}
+
+func containsAllRegions(regions []string) bool {
+	for _, region := range regions {
+		if region == config.AllRegions {
+			return true
+		}
+	}
+	return false
 }
 
-func scrapeStaticJob(ctx context.Context, resource *config.Static, region string, accountId *string, clientCloudwatch cloudwatchInterface, cloudwatchSemaphore chan struct{}, logger logger.Logger) (cw []*cloudwatchData) {
+// expandRegions replaces the config.AllRegions wildcard in regions by allRegions,
+// skipping regions which are listed more than once.
+func expandRegions(regions []string, allRegions []string) []string {
+	var expanded []string
+	seen := make(map[string]struct{})
+	add := func(region string) {
+		if _, ok := seen[region]; !ok {
+			seen[region] = struct{}{}
+			expanded = append(expanded, region)
+		}
+	}
+	for _, region := range regions {
+		if region != config.AllRegions {
+			add(region)
+			continue
+		}
+		for _, r := range allRegions {
+			add(r)
+		}
+	}
+	return expanded
+}
+
+func scrapeStaticJob(ctx context.Context, resource *config.Static, region string, accountId *string, accountAlias *string, clientCloudwatch cloudwatchInterface, cloudwatchSemaphore semaphore, cwData chan<- *cloudwatchData, logger logger.Logger) (err error) {
 	mux := &sync.Mutex{}
 	var wg sync.WaitGroup
+	defer observePhaseDuration(resource.Namespace, region, phaseGetMetricStatistics, time.Now())
 
 	for j := range resource.Metrics {
 		metric := resource.Metrics[j]
@@ -160,9 +916,14 @@ func scrapeStaticJob(ctx context.Context, resource *config.Static, region string
 		go func() {
 			defer wg.Done()
 
-			cloudwatchSemaphore <- struct{}{}
+			if !cloudwatchSemaphore.acquire(ctx) {
+				mux.Lock()
+				err = ctx.Err()
+				mux.Unlock()
+				return
+			}
 			defer func() {
-				<-cloudwatchSemaphore
+				cloudwatchSemaphore.release()
 			}()
 
 			id := resource.Name
@@ -173,30 +934,57 @@ func scrapeStaticJob(ctx context.Context, resource *config.Static, region string
 				Statistics:             metric.Statistics,
 				NilToZero:              metric.NilToZero,
 				AddCloudwatchTimestamp: metric.AddCloudwatchTimestamp,
+				ExportTimestamp:        metric.ExportTimestamp,
+				PercentilesAsSummary:   metric.PercentilesAsSummary,
+				PercentilesAsLabels:    metric.PercentilesAsLabels,
+				SampleCountAsCount:     metric.SampleCountAsCount,
+				DropNoData:             metric.DropNoData,
+				Unit:                   metric.RequestedUnit(),
+				ExportUnit:             metric.ExportUnit,
+				Transforms:             metricTransforms(metric, metric.Statistics),
+				CarryForward:           metric.CarryForward,
+				Counter:                metric.IsCounter(),
+				Accumulate:             metric.Accumulate,
+				DropDimensions:         metric.DropDimensions,
+				Help:                   metric.Help,
+				MetricPrefix:           resource.MetricPrefix,
+				MetricRenames:          resource.MetricRenames,
+				ExportedNamespace:      resource.ExportedNamespace,
 				CustomTags:             resource.CustomTags,
 				Dimensions:             createStaticDimensions(resource.Dimensions),
 				Region:                 &region,
 				AccountId:              accountId,
+				AccountAlias:           accountAlias,
 			}
 
 			filter := createGetMetricStatisticsInput(
+				TimeClock{},
 				data.Dimensions,
 				&resource.Namespace,
 				metric,
 				logger,
 			)
 
-			data.Points = clientCloudwatch.get(ctx, filter)
-
-			if data.Points != nil {
+			points, getErr := clientCloudwatch.get(ctx, filter)
+			if getErr != nil {
+				logger.Error(getErr, "Failed to get metric statistics", "metric_name", metric.Name)
 				mux.Lock()
-				cw = append(cw, &data)
+				err = getErr
 				mux.Unlock()
+				return
+			}
+			data.Points = points
+			if data.Unit == nil {
+				data.Unit = datapointsUnit(points)
+			}
+
+			if data.Points != nil {
+				cwData <- &data
 			}
 		}()
 	}
 	wg.Wait()
-	return cw
+	return err
 }
 
 func getMetricDataInputLength(job *config.Job) int64 {
@@ -213,41 +1001,204 @@ func getMetricDataInputLength(job *config.Job) int64 {
 	return length
 }
 
+// getFullMetricsLists gets the full list of metrics of every metric of a job
+// concurrently, bounded by tagSemaphore. This includes, for each metric the
+// possible combinations of dimensions and value of dimensions with data.
+// The result is indexed like metrics, with a nil entry for the metrics that
+// were skipped: expressions, failed requests and cancelled scrapes.
+func getFullMetricsLists(
+	ctx context.Context,
+	namespace string,
+	metrics []*config.Metric,
+	dimensions []*cloudwatch.Dimension,
+	clientCloudwatch cloudwatchInterface,
+	tagSemaphore semaphore,
+	logger logger.Logger,
+) []*cloudwatch.ListMetricsOutput {
+	metricsLists := make([]*cloudwatch.ListMetricsOutput, len(metrics))
+
+	var wg sync.WaitGroup
+	for i, metric := range metrics {
+		// Expressions are not listed, they are computed from the other metrics, nor the metrics with their series
+		if metric.Expression != "" || len(metric.Series) > 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, metric *config.Metric) {
+			defer wg.Done()
+			if !tagSemaphore.acquire(ctx) {
+				// Only keep what was gathered before the scrape was cancelled
+				return
+			}
+			defer func() {
+				tagSemaphore.release()
+			}()
+
+			metricsList, err := getFullMetricsList(ctx, namespace, metric, dimensions, clientCloudwatch)
+			if err != nil {
+				logger.Error(err, "Failed to get full metric list", "metric_name", metric.Name, "namespace", namespace)
+				return
+			}
+			metricsLists[i] = metricsList
+		}(i, metric)
+	}
+	wg.Wait()
+
+	return metricsLists
+}
+
 func getMetricDataForQueries(
 	ctx context.Context,
 	discoveryJob *config.Job,
 	svc *services.ServiceFilter,
 	region string,
 	accountId *string,
+	accountAlias *string,
 	tagsOnMetrics config.ExportedTagsOnMetrics,
 	clientCloudwatch cloudwatchInterface,
 	resources []*services.TaggedResource,
-	tagSemaphore chan struct{},
+	configuredDimensions [][]*cloudwatch.Dimension,
+	relatedTags []relatedTagsIndex,
+	tagSemaphore semaphore,
 	logger logger.Logger,
 ) []cloudwatchData {
 	var getMetricDatas []cloudwatchData
+	ids := &metricIDs{}
 
-	// For every metric of the job
-	for _, metric := range discoveryJob.Metrics {
-		// Get the full list of metrics
-		// This includes, for this metric the possible combinations
-		// of dimensions and value of dimensions with data
-		tagSemaphore <- struct{}{}
-
-		metricsList, err := getFullMetricsList(ctx, svc.Namespace, metric, clientCloudwatch)
-		<-tagSemaphore
+	metrics, metricsLists := expandMetricNameRegexes(discoveryJob.Metrics, getFullMetricsLists(ctx, svc.Namespace, discoveryJob.Metrics, nil, clientCloudwatch, tagSemaphore, logger), logger)
 
-		if err != nil {
-			logger.Error(err, "Failed to get full metric list", "metric_name", metric.Name, "namespace", svc.Namespace)
+	// For every metric of the job
+	for i, metric := range metrics {
+		metricsList := metricsLists[i]
+		if len(metric.Series) > 0 {
+			metricsList = seriesMetricsList(svc.Namespace, metric)
+		} else if svc.IsConfiguredMetric(metric.Name) {
+			metricsList = configuredMetricsList(svc.Namespace, metric.Name, configuredDimensions)
+		}
+		if metricsList == nil {
 			continue
 		}
 
 		if len(resources) == 0 {
 			logger.Debug("No resources for metric", "metric_name", metric.Name, "namespace", svc.Namespace)
 		}
-		getMetricDatas = append(getMetricDatas, getFilteredMetricDatas(region, accountId, discoveryJob.Type, discoveryJob.CustomTags, tagsOnMetrics, svc.DimensionRegexps, resources, metricsList.Metrics, discoveryJob.DimensionNameRequirements, metric)...)
+		getMetricDatas = append(getMetricDatas, getFilteredMetricDatas(region, accountId, discoveryJob.Type, discoveryJob.CustomTags, tagsOnMetrics, svc.DimensionRegexps, svc.DimensionPriority, resources, metricsList.Metrics, discoveryJob.DimensionNameRequirements, discoveryJob.DimensionValueRequirements, metric, ids)...)
+	}
+	staticDimensions := createStaticDimensions(discoveryJob.StaticDimensions)
+	for i := range getMetricDatas {
+		getMetricDatas[i].AccountAlias = accountAlias
+		getMetricDatas[i].MetricPrefix = discoveryJob.MetricPrefix
+		getMetricDatas[i].MetricRenames = discoveryJob.MetricRenames
+		getMetricDatas[i].ExportedNamespace = discoveryJob.ExportedNamespace
+		getMetricDatas[i].Dimensions = mergeStaticDimensions(getMetricDatas[i].Dimensions, staticDimensions)
+		getMetricDatas[i].Tags = appendRelatedTags(getMetricDatas[i].Tags, getMetricDatas[i].Dimensions, relatedTags)
+	}
+	getMetricDatas = dedupGetMetricDatas(getMetricDatas, logger)
+	getMetricDatas = aggregateMetricDatas(metrics, getMetricDatas, ids, logger)
+	expressions := getExpressionMetricDatas(metrics, getMetricDatas, ids)
+	getMetricDatas = append(getMetricDatas, getAnomalyBandMetricDatas(metrics, getMetricDatas, ids)...)
+	return append(getMetricDatas, expressions...)
+}
+
+// jobHasConfiguredMetrics returns whether any metric of job is one of the configured metrics of svc
+func jobHasConfiguredMetrics(job *config.Job, svc *services.ServiceFilter) bool {
+	for _, metric := range job.Metrics {
+		if svc.IsConfiguredMetric(metric.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredMetricsList returns the metrics named metricName for every set of configuredDimensions. They replace
+// the listed metrics of the configured metrics, which ListMetrics only returns after recent data points.
+func configuredMetricsList(namespace string, metricName string, configuredDimensions [][]*cloudwatch.Dimension) *cloudwatch.ListMetricsOutput {
+	output := &cloudwatch.ListMetricsOutput{Metrics: make([]*cloudwatch.Metric, 0, len(configuredDimensions))}
+	for _, dimensions := range configuredDimensions {
+		output.Metrics = append(output.Metrics, &cloudwatch.Metric{
+			MetricName: aws.String(metricName),
+			Namespace:  aws.String(namespace),
+			Dimensions: dimensions,
+		})
+	}
+	return output
+}
+
+// seriesMetricsList returns the series of metric with their dimensions, in place of the listed metrics
+func seriesMetricsList(namespace string, metric *config.Metric) *cloudwatch.ListMetricsOutput {
+	output := &cloudwatch.ListMetricsOutput{Metrics: make([]*cloudwatch.Metric, 0, len(metric.Series))}
+	for _, series := range metric.Series {
+		dimensions := make([]*cloudwatch.Dimension, 0, len(series.Dimensions))
+		for _, dimension := range series.Dimensions {
+			dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String(dimension.Name), Value: aws.String(dimension.Value)})
+		}
+		output.Metrics = append(output.Metrics, &cloudwatch.Metric{
+			MetricName: aws.String(metric.Name),
+			Namespace:  aws.String(namespace),
+			Dimensions: dimensions,
+		})
+	}
+	return output
+}
+
+// jobHasSeries returns whether any metric of job has its series, which are queried even without discovered resources
+func jobHasSeries(job *config.Job) bool {
+	for _, metric := range job.Metrics {
+		if len(metric.Series) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// errSeriesLimitExceeded is returned by the jobs skipped because they exceed their series limit
+var errSeriesLimitExceeded = errors.New("series limit exceeded")
+
+func seriesLimitLabels(jobType string, jobName string, region string, accountId *string) prometheus.Labels {
+	return prometheus.Labels{
+		"job_type": jobType,
+		"job_name": jobName,
+		"region":   region,
+		"account":  aws.StringValue(accountId),
 	}
-	return getMetricDatas
+}
+
+// applySeriesLimit enforces the maxSeries limit of a job on the series of getMetricDatas. Jobs exceeding
+// it only query their first maxSeries series, or none with config.OnLimitExceededSkip, in which case
+// errSeriesLimitExceeded is returned. The outcome is reported by yace_series_limit_exceeded.
+func applySeriesLimit(getMetricDatas []cloudwatchData, maxSeries int, onLimitExceeded string, labels prometheus.Labels, logger logger.Logger) ([]cloudwatchData, error) {
+	if maxSeries <= 0 {
+		return getMetricDatas, nil
+	}
+	if len(getMetricDatas) <= maxSeries {
+		promutil.SeriesLimitExceededGauge.With(labels).Set(0)
+		return getMetricDatas, nil
+	}
+	promutil.SeriesLimitExceededGauge.With(labels).Set(1)
+
+	if onLimitExceeded == config.OnLimitExceededSkip {
+		logger.Error(errSeriesLimitExceeded, "Skipping job, it would query more series than its limit", "series", len(getMetricDatas), "max_series", maxSeries)
+		return nil, errSeriesLimitExceeded
+	}
+	logger.Warn("Truncating the series of the job, it would query more series than its limit", "series", len(getMetricDatas), "max_series", maxSeries)
+	return getMetricDatas[:maxSeries], nil
+}
+
+// excludeResources removes the resources matching any of excludeTags, before any metric is fetched for them
+func excludeResources(resources []*services.TaggedResource, excludeTags []model.Tag, logger logger.Logger) []*services.TaggedResource {
+	if len(excludeTags) == 0 {
+		return resources
+	}
+	filtered := make([]*services.TaggedResource, 0, len(resources))
+	for _, resource := range resources {
+		if resource.ExcludedByTags(excludeTags) {
+			logger.Debug("Skipping resource because exclude tags match", "arn", resource.ARN)
+			continue
+		}
+		filtered = append(filtered, resource)
+	}
+	return filtered
 }
 
 func scrapeDiscoveryJobUsingMetricData(
@@ -255,75 +1206,181 @@ func scrapeDiscoveryJobUsingMetricData(
 	job *config.Job,
 	region string,
 	accountId *string,
+	accountAlias *string,
 	tagsOnMetrics config.ExportedTagsOnMetrics,
+	discoverer services.ResourceDiscoverer,
 	clientTag services.TagsInterface,
 	clientCloudwatch cloudwatchInterface,
 	metricsPerQuery int,
 	roundingPeriod *int64,
-	tagSemaphore chan struct{},
+	cloudwatchSemaphore semaphore,
+	tagSemaphore semaphore,
+	cwData chan<- *cloudwatchData,
 	logger logger.Logger,
-) (resources []*services.TaggedResource, cw []*cloudwatchData) {
+) ([]*services.TaggedResource, error) {
+	resources, err := discoverJobResources(ctx, job, region, accountId, discoverer, clientTag, tagSemaphore, logger)
+	if err != nil {
+		return resources, err
+	}
+	return scrapeDiscoveredResources(ctx, job, region, accountId, accountAlias, tagsOnMetrics, clientTag, clientCloudwatch, resources, metricsPerQuery, roundingPeriod, cloudwatchSemaphore, tagSemaphore, cwData, logger)
+}
+
+// discoverJobResources returns the resources of job in region discovered by discoverer, without the excluded ones
+// and, when the job selects a CloudFormation stack, those which are not resources of the stack. Only the default
+// discoverer, clientTag, supports incremental discovery.
+func discoverJobResources(
+	ctx context.Context,
+	job *config.Job,
+	region string,
+	accountId *string,
+	discoverer services.ResourceDiscoverer,
+	clientTag services.TagsInterface,
+	tagSemaphore semaphore,
+	logger logger.Logger,
+) ([]*services.TaggedResource, error) {
 	// Add the info tags of all the resources
-	tagSemaphore <- struct{}{}
-	resources, err := clientTag.Get(ctx, job, region)
-	<-tagSemaphore
+	if !tagSemaphore.acquire(ctx) {
+		return nil, ctx.Err()
+	}
+	start := time.Now()
+	var resources []*services.TaggedResource
+	var err error
+	if tags, ok := discoverer.(services.TagsInterface); ok {
+		resources, err = getResources(ctx, tags, job, region, aws.StringValue(accountId), logger)
+	} else {
+		resources, err = discoverer.Get(ctx, job, region)
+	}
+	if err == nil && job.CloudFormationStack != nil {
+		resources, err = clientTag.FilterByStack(ctx, job, region, resources)
+	}
+	observePhaseDuration(job.Type, region, phaseTagging, start)
+	tagSemaphore.release()
 	if err != nil {
 		logger.Error(err, "Couldn't describe resources")
-		return
+		return resources, err
 	}
+	return excludeResources(resources, job.ExcludeTags, logger), nil
+}
 
-	if len(resources) == 0 {
+// scrapeDiscoveredResources sends the cloudwatch data of the metrics of the discovered resources of job to cwData.
+// It returns the resources, reported even without metrics for their info series.
+func scrapeDiscoveredResources(
+	ctx context.Context,
+	job *config.Job,
+	region string,
+	accountId *string,
+	accountAlias *string,
+	tagsOnMetrics config.ExportedTagsOnMetrics,
+	clientTag services.TagsInterface,
+	clientCloudwatch cloudwatchInterface,
+	resources []*services.TaggedResource,
+	metricsPerQuery int,
+	roundingPeriod *int64,
+	cloudwatchSemaphore semaphore,
+	tagSemaphore semaphore,
+	cwData chan<- *cloudwatchData,
+	logger logger.Logger,
+) (_ []*services.TaggedResource, err error) {
+	if len(resources) == 0 && !jobHasSeries(job) {
 		logger.Info("No tagged resources made it through filtering")
-		return
+		return resources, nil
 	}
+	// The metrics of the resources left out by the sampling aren't queried, they keep their info series
+	sampled := sampleResources(resources, job.SampleRatio, prometheus.Labels{"job_type": job.Type, "region": region, "account": aws.StringValue(accountId)})
 
 	svc := services.SupportedServices.GetService(job.Type)
-	getMetricDatas := getMetricDataForQueries(ctx, job, svc, region, accountId, tagsOnMetrics, clientCloudwatch, resources, tagSemaphore, logger)
-	metricDataLength := len(getMetricDatas)
-	if metricDataLength == 0 {
+	var configuredDimensions [][]*cloudwatch.Dimension
+	var start time.Time
+	if svc.ConfiguredMetricsFunc != nil && jobHasConfiguredMetrics(job, svc) {
+		if !tagSemaphore.acquire(ctx) {
+			return nil, ctx.Err()
+		}
+		start = time.Now()
+		configuredDimensions, err = svc.ConfiguredMetricsFunc(ctx, clientTag, sampled)
+		observePhaseDuration(job.Type, region, phaseTagging, start)
+		tagSemaphore.release()
+		if err != nil {
+			logger.Error(err, "Couldn't discover the configured metrics")
+			return nil, err
+		}
+	}
+
+	var relatedTags []relatedTagsIndex
+	if len(job.RelatedTags) > 0 {
+		if !tagSemaphore.acquire(ctx) {
+			return nil, ctx.Err()
+		}
+		start = time.Now()
+		relatedTags = getRelatedTagsIndexes(ctx, clientTag, job, region, logger)
+		observePhaseDuration(job.Type, region, phaseTagging, start)
+		tagSemaphore.release()
+	}
+
+	start = time.Now()
+	getMetricDatas := getMetricDataForQueries(ctx, job, svc, region, accountId, accountAlias, job.TagsOnMetrics(tagsOnMetrics), clientCloudwatch, sampled, configuredDimensions, relatedTags, tagSemaphore, logger)
+	observePhaseDuration(job.Type, region, phaseListMetrics, start)
+	getMetricDatas, err = applySeriesLimit(getMetricDatas, job.MaxSeries, job.OnLimitExceeded, seriesLimitLabels(job.Type, "", region, accountId), logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(getMetricDatas) == 0 {
+		// Resources are reported even without metrics, for their info series
 		logger.Debug("No metrics data found")
-		return
+		return resources, ctx.Err()
 	}
 
-	maxMetricCount := metricsPerQuery
 	length := getMetricDataInputLength(job)
-	partition := int(math.Ceil(float64(metricDataLength) / float64(maxMetricCount)))
+	partitions := partitionGetMetricDatas(getMetricDatas, metricsPerQuery, length, logger)
+	defer observePhaseDuration(job.Type, region, phaseGetMetricData, time.Now())
 
 	mux := &sync.Mutex{}
 	var wg sync.WaitGroup
-	wg.Add(partition)
+	wg.Add(len(partitions))
 
-	for i := 0; i < metricDataLength; i += maxMetricCount {
-		go func(i int) {
+	for i, input := range partitions {
+		go func(i int, input []cloudwatchData) {
 			defer wg.Done()
-			end := i + maxMetricCount
-			if end > metricDataLength {
-				end = metricDataLength
+
+			if !cloudwatchSemaphore.acquire(ctx) {
+				mux.Lock()
+				err = ctx.Err()
+				mux.Unlock()
+				return
 			}
-			input := getMetricDatas[i:end]
-			filter := createGetMetricDataInput(input, &svc.Namespace, length, job.Delay, roundingPeriod, logger)
-			data := clientCloudwatch.getMetricData(ctx, filter)
-			if data != nil {
+			defer func() {
+				cloudwatchSemaphore.release()
+			}()
+
+			filter := createGetMetricDataInput(TimeClock{}, input, &svc.Namespace, length, roundingPeriod, job.AlignToPeriod, job.ScanBy, logger)
+			data, getErr := clientCloudwatch.getMetricData(ctx, filter)
+			if getErr != nil {
+				logger.Error(getErr, "Failed to get metric data", "partition", i)
+				mux.Lock()
+				err = getErr
+				mux.Unlock()
+			} else {
 				output := make([]*cloudwatchData, 0)
+				index := indexGetMetricDatasById(input)
 				for _, MetricDataResult := range data.MetricDataResults {
-					getMetricData, err := findGetMetricDataById(input, *MetricDataResult.Id)
-					if err == nil {
-						if len(MetricDataResult.Values) != 0 {
-							getMetricData.GetMetricDataPoint = MetricDataResult.Values[0]
-							getMetricData.GetMetricDataTimestamps = MetricDataResult.Timestamps[0]
-						}
+					getMetricData, err := findGetMetricDataById(index, *MetricDataResult.Id)
+					// Series without data are not emitted at all for metrics with DropNoData
+					if err == nil && !(getMetricData.DropNoData && len(MetricDataResult.Values) == 0) {
+						setMetricDataResult(&getMetricData, MetricDataResult)
 						output = append(output, &getMetricData)
 					}
 				}
-				mux.Lock()
-				cw = append(cw, output...)
-				mux.Unlock()
+				output = dropStaleDatapoints(TimeClock{}, output, job.MaxDatapointAge, svc.Namespace, region)
+				output = fillMissingData(input, output, sampled, *filter.EndTime)
+				output = setAnomalyBandBounds(output, logger)
+				for _, data := range output {
+					cwData <- data
+				}
 			}
-		}(i)
+		}(i, input)
 	}
 
 	wg.Wait()
-	return resources, cw
+	return resources, err
 }
 
 func scrapeCustomNamespaceJobUsingMetricData(
@@ -331,64 +1388,76 @@ func scrapeCustomNamespaceJobUsingMetricData(
 	customNamespaceJob *config.CustomNamespace,
 	region string,
 	accountId *string,
+	accountAlias *string,
 	clientCloudwatch cloudwatchInterface,
-	cloudwatchSemaphore chan struct{},
-	tagSemaphore chan struct{},
+	cloudwatchSemaphore semaphore,
+	tagSemaphore semaphore,
+	cwData chan<- *cloudwatchData,
 	logger logger.Logger,
 	metricsPerQuery int,
-) (cw []*cloudwatchData) {
+) (err error) {
 	mux := &sync.Mutex{}
 	var wg sync.WaitGroup
 
-	getMetricDatas := getMetricDataForQueriesForCustomNamespace(ctx, customNamespaceJob, region, accountId, clientCloudwatch, tagSemaphore, logger)
-	metricDataLength := len(getMetricDatas)
-	if metricDataLength == 0 {
+	start := time.Now()
+	getMetricDatas := getMetricDataForQueriesForCustomNamespace(ctx, customNamespaceJob, region, accountId, accountAlias, clientCloudwatch, tagSemaphore, logger)
+	observePhaseDuration(customNamespaceJob.Namespace, region, phaseListMetrics, start)
+	getMetricDatas, err = applySeriesLimit(getMetricDatas, customNamespaceJob.MaxSeries, customNamespaceJob.OnLimitExceeded, seriesLimitLabels(customNamespaceJob.Namespace, customNamespaceJob.Name, region, accountId), logger)
+	if err != nil {
+		return err
+	}
+	if len(getMetricDatas) == 0 {
 		logger.Debug("No metrics data found")
-		return
+		return ctx.Err()
 	}
 
-	maxMetricCount := metricsPerQuery
-	partition := int(math.Ceil(float64(metricDataLength) / float64(maxMetricCount)))
+	partitions := partitionGetMetricDatas(getMetricDatas, metricsPerQuery, customNamespaceJob.Length, logger)
+	defer observePhaseDuration(customNamespaceJob.Namespace, region, phaseGetMetricData, time.Now())
+	wg.Add(len(partitions))
 
-	wg.Add(partition)
-
-	for i := 0; i < metricDataLength; i += maxMetricCount {
-		go func(i int) {
-			cloudwatchSemaphore <- struct{}{}
+	for i, input := range partitions {
+		go func(i int, input []cloudwatchData) {
+			defer wg.Done()
 
+			if !cloudwatchSemaphore.acquire(ctx) {
+				mux.Lock()
+				err = ctx.Err()
+				mux.Unlock()
+				return
+			}
 			defer func() {
-				defer wg.Done()
-				<-cloudwatchSemaphore
+				cloudwatchSemaphore.release()
 			}()
 
-			end := i + maxMetricCount
-			if end > metricDataLength {
-				end = metricDataLength
-			}
-			input := getMetricDatas[i:end]
-			filter := createGetMetricDataInput(input, &customNamespaceJob.Namespace, customNamespaceJob.Length, customNamespaceJob.Delay, customNamespaceJob.RoundingPeriod, logger)
-			data := clientCloudwatch.getMetricData(ctx, filter)
-			if data != nil {
+			filter := createGetMetricDataInput(TimeClock{}, input, &customNamespaceJob.Namespace, customNamespaceJob.Length, customNamespaceJob.RoundingPeriod, customNamespaceJob.AlignToPeriod, customNamespaceJob.ScanBy, logger)
+			data, getErr := clientCloudwatch.getMetricData(ctx, filter)
+			if getErr != nil {
+				logger.Error(getErr, "Failed to get metric data", "partition", i)
+				mux.Lock()
+				err = getErr
+				mux.Unlock()
+			} else {
 				output := make([]*cloudwatchData, 0)
+				index := indexGetMetricDatasById(input)
 				for _, MetricDataResult := range data.MetricDataResults {
-					getMetricData, err := findGetMetricDataById(input, *MetricDataResult.Id)
-					if err == nil {
-						if len(MetricDataResult.Values) != 0 {
-							getMetricData.GetMetricDataPoint = MetricDataResult.Values[0]
-							getMetricData.GetMetricDataTimestamps = MetricDataResult.Timestamps[0]
-						}
+					getMetricData, err := findGetMetricDataById(index, *MetricDataResult.Id)
+					// Series without data are not emitted at all for metrics with DropNoData
+					if err == nil && !(getMetricData.DropNoData && len(MetricDataResult.Values) == 0) {
+						setMetricDataResult(&getMetricData, MetricDataResult)
 						output = append(output, &getMetricData)
 					}
 				}
-				mux.Lock()
-				cw = append(cw, output...)
-				mux.Unlock()
+				output = dropStaleDatapoints(TimeClock{}, output, customNamespaceJob.MaxDatapointAge, customNamespaceJob.Namespace, region)
+				output = setAnomalyBandBounds(output, logger)
+				for _, data := range output {
+					cwData <- data
+				}
 			}
-		}(i)
+		}(i, input)
 	}
 
 	wg.Wait()
-	return cw
+	return err
 }
 
 func getMetricDataForQueriesForCustomNamespace(
@@ -396,50 +1465,94 @@ func getMetricDataForQueriesForCustomNamespace(
 	customNamespaceJob *config.CustomNamespace,
 	region string,
 	accountId *string,
+	accountAlias *string,
 	clientCloudwatch cloudwatchInterface,
-	tagSemaphore chan struct{},
+	tagSemaphore semaphore,
 	logger logger.Logger,
 ) []cloudwatchData {
 	var getMetricDatas []cloudwatchData
+	ids := &metricIDs{}
 
-	// For every metric of the job
-	for _, metric := range customNamespaceJob.Metrics {
-		// Get the full list of metrics
-		// This includes, for this metric the possible combinations
-		// of dimensions and value of dimensions with data
-		tagSemaphore <- struct{}{}
-
-		metricsList, err := getFullMetricsList(ctx, customNamespaceJob.Namespace, metric, clientCloudwatch)
-		<-tagSemaphore
+	// Filtering on dimensions when listing keeps namespaces with many dimension combinations, like AWS/Usage, from
+	// turning into as many GetMetricData queries
+	dimensionFilters := make([]*cloudwatch.Dimension, 0, len(customNamespaceJob.DimensionFilters))
+	for _, filter := range customNamespaceJob.DimensionFilters {
+		dimension := &cloudwatch.Dimension{Name: aws.String(filter.Name)}
+		if filter.Value != "" {
+			dimension.Value = aws.String(filter.Value)
+		}
+		dimensionFilters = append(dimensionFilters, dimension)
+	}
+	metrics, metricsLists := expandMetricNameRegexes(customNamespaceJob.Metrics, getFullMetricsLists(ctx, customNamespaceJob.Namespace, customNamespaceJob.Metrics, dimensionFilters, clientCloudwatch, tagSemaphore, logger), logger)
+	valueMatchers := newDimensionValueMatchers(customNamespaceJob.DimensionValueRequirements)
 
-		if err != nil {
-			logger.Error(err, "Failed to get full metric list", "metric_name", metric.Name, "namespace", customNamespaceJob.Namespace)
+	// For every metric of the job
+	for i, metric := range metrics {
+		metricsList := metricsLists[i]
+		if metricsList == nil {
 			continue
 		}
 
-		for _, cwMetric := range metricsList.Metrics {
-			if len(customNamespaceJob.DimensionNameRequirements) > 0 && !metricDimensionsMatchNames(cwMetric, customNamespaceJob.DimensionNameRequirements) {
+		for j, cwMetric := range metricsList.Metrics {
+			if !metricDimensionsMatchNames(cwMetric, customNamespaceJob.DimensionNameRequirements) {
+				continue
+			}
+			if !metricDimensionsMatchValues(cwMetric, valueMatchers) {
 				continue
 			}
 
+			// The metrics of the source accounts of a monitoring account are labeled with their account, whose
+			// alias isn't known without assuming a role in it
+			metricAccountId, metricAccountAlias, linkedAccount := accountId, accountAlias, false
+			if owningAccount := listedOwningAccount(metricsList, j); owningAccount != nil && aws.StringValue(owningAccount) != aws.StringValue(accountId) {
+				metricAccountId, metricAccountAlias, linkedAccount = owningAccount, nil, true
+			}
+
 			for _, stats := range metric.Statistics {
-				id := fmt.Sprintf("id_%d", rand.Int())
+				id := ids.next()
 				getMetricDatas = append(getMetricDatas, cloudwatchData{
 					ID:                     &customNamespaceJob.Name,
 					MetricID:               &id,
 					Metric:                 &metric.Name,
 					Namespace:              &customNamespaceJob.Namespace,
 					Statistics:             []string{stats},
-					NilToZero:              metric.NilToZero,
-					AddCloudwatchTimestamp: metric.AddCloudwatchTimestamp,
+					NilToZero:              metric.NilToZeroFor(stats),
+					AddCloudwatchTimestamp: metric.AddCloudwatchTimestampFor(stats),
+					ExportTimestamp:        metric.ExportTimestamp,
+					ExportAllDataPoints:    metric.ExportAllDataPoints,
+					PercentilesAsSummary:   metric.PercentilesAsSummary,
+					PercentilesAsLabels:    metric.PercentilesAsLabels,
+					SampleCountAsCount:     metric.SampleCountAsCount,
+					DropNoData:             metric.DropNoData,
+					Unit:                   metric.RequestedUnit(),
+					ExportUnit:             metric.ExportUnit,
+					Label:                  metric.LabelTemplate(),
+					LabelAs:                metric.LabelAs,
+					Transforms:             metricTransforms(metric, []string{stats}),
+					CarryForward:           metric.CarryForward,
+					Counter:                metric.IsCounter(),
+					Accumulate:             metric.Accumulate,
+					DropDimensions:         metric.DropDimensions,
+					Help:                   metric.Help,
+					MetricPrefix:           customNamespaceJob.MetricPrefix,
+					MetricRenames:          customNamespaceJob.MetricRenames,
+					ExportedNamespace:      customNamespaceJob.ExportedNamespace,
 					CustomTags:             customNamespaceJob.CustomTags,
 					Dimensions:             cwMetric.Dimensions,
 					Region:                 &region,
-					AccountId:              accountId,
+					AccountId:              metricAccountId,
+					AccountAlias:           metricAccountAlias,
+					LinkedAccount:          linkedAccount,
 					Period:                 metric.Period,
+					AutoPeriod:             metric.AutoPeriod,
+					Delay:                  metric.Delay,
 				})
 			}
 		}
 	}
-	return getMetricDatas
+	getMetricDatas = dedupGetMetricDatas(getMetricDatas, logger)
+	getMetricDatas = aggregateMetricDatas(metrics, getMetricDatas, ids, logger)
+	expressions := getExpressionMetricDatas(metrics, getMetricDatas, ids)
+	getMetricDatas = append(getMetricDatas, getAnomalyBandMetricDatas(metrics, getMetricDatas, ids)...)
+	return append(getMetricDatas, expressions...)
 }
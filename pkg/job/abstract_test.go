@@ -1,10 +1,39 @@
 package job
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/session"
 )
 
 func TestFilterThroughTags(t *testing.T) {
@@ -23,3 +52,1661 @@ func TestFilterThroughTags(t *testing.T) {
 		t.Fatalf("\nexpected: %t\nactual:  %t", expected, actual)
 	}
 }
+
+func TestJobScrapeStatusFinish(t *testing.T) {
+	errCh := make(chan *JobError, 2)
+	status := newJobScrapeStatus("ec2", "", "us-east-1", config.Role{RoleArn: "arn:aws:iam::123456789012:role/yace"}, errCh)
+	status.labels["account"] = "123456789012"
+
+	metric := status.finish()
+	assert.Equal(t, "yace_scrape_job_success", *metric.Name)
+	assert.Equal(t, float64(0), *metric.Value)
+	assert.Equal(t, map[string]string{
+		"job_type": "ec2",
+		"job_name": "",
+		"region":   "us-east-1",
+		"account":  "123456789012",
+		"arn":      "arn:aws:iam::123456789012:role/yace",
+	}, metric.Labels)
+
+	// The failed job didn't return an error of its own
+	require.Len(t, errCh, 1)
+	assert.ErrorIs(t, <-errCh, errJobNotScraped)
+
+	status.setResult(errors.New("access denied"))
+	status.finish()
+	require.Len(t, errCh, 1)
+	jobErr := <-errCh
+	assert.Equal(t, &JobError{
+		JobType: "ec2",
+		Region:  "us-east-1",
+		Account: "123456789012",
+		RoleArn: "arn:aws:iam::123456789012:role/yace",
+		Err:     errors.New("access denied"),
+	}, jobErr)
+	assert.Equal(t, `job ec2/ in region us-east-1 of account "123456789012" with role "arn:aws:iam::123456789012:role/yace": access denied`, jobErr.Error())
+
+	status.setResult(nil)
+	assert.Equal(t, float64(1), *status.finish().Value)
+	assert.Len(t, errCh, 0)
+}
+
+// failingGetMetricDataAPI lists a metric whose GetMetricData requests are throttled
+type failingGetMetricDataAPI struct {
+	cloudwatchiface.CloudWatchAPI
+}
+
+func (failingGetMetricDataAPI) ListMetricsPagesWithContext(_ aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	fn(&cloudwatch.ListMetricsOutput{Metrics: []*cloudwatch.Metric{{Namespace: input.Namespace, MetricName: input.MetricName}}}, true)
+	return nil
+}
+
+func (failingGetMetricDataAPI) GetMetricDataPagesWithContext(aws.Context, *cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool, ...request.Option) error {
+	return awserr.New("Throttling", "Rate exceeded", nil)
+}
+
+func TestScrapeErrors(t *testing.T) {
+	role := config.Role{RoleArn: "arn:aws:iam::123456789012:role/yace"}
+	static := &config.Static{Name: "static", Namespace: "AWS/EC2", Regions: []string{"eu-west-1"}, Roles: []config.Role{role}}
+	customNamespace := &config.CustomNamespace{
+		Name:      "custom",
+		Namespace: "CustomEC2Metrics",
+		Regions:   []string{"us-east-1"},
+		Roles:     []config.Role{role},
+		Metrics:   []*config.Metric{{Name: "cpu", Statistics: []string{"Average"}, Period: 60, Length: 60}},
+	}
+
+	testCases := []struct {
+		name           string
+		sts            stsiface.STSAPI
+		expectedErrors []*JobError
+	}{
+		{
+			name: "STS failure",
+			sts:  failingSTS{},
+			expectedErrors: []*JobError{
+				{JobType: "AWS/EC2", JobName: "static", Region: "eu-west-1", RoleArn: role.RoleArn},
+				{JobType: "CustomEC2Metrics", JobName: "custom", Region: "us-east-1", RoleArn: role.RoleArn},
+			},
+		},
+		{
+			name: "GetMetricData failure",
+			sts:  accountSTS{},
+			// The static job without metrics succeeds
+			expectedErrors: []*JobError{
+				{JobType: "CustomEC2Metrics", JobName: "custom", Region: "us-east-1", Account: "123456789012", RoleArn: role.RoleArn},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.ScrapeConf{Static: []*config.Static{static}, CustomNamespace: []*config.CustomNamespace{customNamespace}}
+			cache := &testSessionCache{sts: tc.sts, cloudwatch: map[string]cloudwatchiface.CloudWatchAPI{
+				"us-east-1": failingGetMetricDataAPI{},
+			}}
+
+			result := Scrape(context.Background(), cfg, ScrapeOptions{}, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, logger.NewLogrusLogger(log.StandardLogger()))
+			jobMetrics, errs := result.JobMetrics, result.Errors
+
+			require.Len(t, jobMetrics, 2)
+			require.Len(t, errs, len(tc.expectedErrors))
+			sort.Slice(errs, func(i, j int) bool { return errs[i].JobName > errs[j].JobName })
+			for i, expected := range tc.expectedErrors {
+				assert.Equal(t, expected.JobType, errs[i].JobType)
+				assert.Equal(t, expected.JobName, errs[i].JobName)
+				assert.Equal(t, expected.Region, errs[i].Region)
+				assert.Equal(t, expected.Account, errs[i].Account)
+				assert.Equal(t, expected.RoleArn, errs[i].RoleArn)
+				assert.Error(t, errs[i].Err)
+			}
+		})
+	}
+}
+
+// throttledOnceGetMetricDataAPI lists a metric whose first GetMetricData request is throttled
+type throttledOnceGetMetricDataAPI struct {
+	failingGetMetricDataAPI
+	calls int32
+}
+
+func (c *throttledOnceGetMetricDataAPI) GetMetricDataPagesWithContext(_ aws.Context, input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, _ ...request.Option) error {
+	if atomic.AddInt32(&c.calls, 1) == 1 {
+		return awserr.New("Throttling", "Rate exceeded", nil)
+	}
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, query := range input.MetricDataQueries {
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         query.Id,
+			Values:     []*float64{aws.Float64(1)},
+			Timestamps: []*time.Time{aws.Time(time.Now())},
+			StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+		})
+	}
+	fn(output, true)
+	return nil
+}
+
+func TestScrapeCustomNamespaceRetry(t *testing.T) {
+	testCases := []struct {
+		name           string
+		retry          config.Retry
+		expectedCalls  int32
+		expectedData   int
+		expectedErrors int
+	}{
+		{
+			name:          "throttled request retried",
+			retry:         config.Retry{MaxAttempts: 2, BaseDelay: time.Millisecond},
+			expectedCalls: 2,
+			expectedData:  1,
+		},
+		{
+			name:           "without retry",
+			retry:          config.Retry{MaxAttempts: 1},
+			expectedCalls:  1,
+			expectedErrors: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.ScrapeConf{
+				Discovery: config.Discovery{Retry: tc.retry},
+				CustomNamespace: []*config.CustomNamespace{{
+					Name:      "custom",
+					Namespace: "CustomEC2Metrics",
+					Regions:   []string{"us-east-1"},
+					Roles:     []config.Role{{}},
+					Metrics:   []*config.Metric{{Name: "cpu", Statistics: []string{"Average"}, Period: 60, Length: 60}},
+				}},
+			}
+			api := &throttledOnceGetMetricDataAPI{}
+			cache := &testSessionCache{sts: accountSTS{}, cloudwatch: map[string]cloudwatchiface.CloudWatchAPI{"us-east-1": api}}
+
+			result := Scrape(context.Background(), cfg, ScrapeOptions{}, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, logger.NewLogrusLogger(log.StandardLogger()))
+
+			assert.Equal(t, tc.expectedCalls, atomic.LoadInt32(&api.calls))
+			assert.Len(t, result.CloudwatchData, tc.expectedData)
+			assert.Len(t, result.Errors, tc.expectedErrors)
+		})
+	}
+}
+
+func TestDiscoveredResourcesMetrics(t *testing.T) {
+	resources := []*services.TaggedResource{
+		{ARN: "arn:aws:sqs:us-east-1:123456789012:orders"},
+		{ARN: "arn:aws:sqs:us-east-1:123456789012:payments"},
+		{ARN: "arn:aws:sqs:us-east-1:123456789012:refunds"},
+	}
+	testCases := []struct {
+		name                   string
+		resources              []*services.TaggedResource
+		data                   []*cloudwatchData
+		expectedResources      float64
+		expectedWithDatapoints float64
+	}{
+		{
+			name:      "resources with and without datapoints",
+			resources: resources,
+			data: []*cloudwatchData{
+				{ID: aws.String("arn:aws:sqs:us-east-1:123456789012:orders"), GetMetricDataPoint: aws.Float64(1)},
+				{ID: aws.String("arn:aws:sqs:us-east-1:123456789012:orders"), GetMetricDataPoint: aws.Float64(2)},
+				{ID: aws.String("arn:aws:sqs:us-east-1:123456789012:payments"), GetMetricDataPoints: []dataPoint{{Value: aws.Float64(1)}}},
+				{ID: aws.String("arn:aws:sqs:us-east-1:123456789012:refunds")},
+			},
+			expectedResources:      3,
+			expectedWithDatapoints: 2,
+		},
+		{
+			name:                   "no resources",
+			expectedResources:      0,
+			expectedWithDatapoints: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwData := make(chan *cloudwatchData, len(tc.data))
+			forwarder := newJobCloudwatchData(cwData, nil, nil)
+			for _, data := range tc.data {
+				forwarder.ch <- data
+			}
+			metrics := forwarder.discoveredResourcesMetrics("sqs", "us-east-1", "123456789012", tc.resources)
+
+			// The data are forwarded as is
+			assert.Len(t, cwData, len(tc.data))
+			require.Len(t, metrics, 2)
+			assert.Equal(t, DiscoveredResourcesMetric, *metrics[0].Name)
+			assert.Equal(t, tc.expectedResources, *metrics[0].Value)
+			assert.Equal(t, DiscoveredResourcesWithMetricsMetric, *metrics[1].Name)
+			assert.Equal(t, tc.expectedWithDatapoints, *metrics[1].Value)
+			for _, metric := range metrics {
+				assert.Equal(t, map[string]string{"job_type": "sqs", "region": "us-east-1", "account": "123456789012"}, metric.Labels)
+			}
+		})
+	}
+}
+
+type testSessionCache struct {
+	session.SessionCache
+	sts          stsiface.STSAPI
+	iam          iamiface.IAMAPI
+	cloudwatch   map[string]cloudwatchiface.CloudWatchAPI
+	regions      []string
+	regionsCalls int
+	cleared      bool
+}
+
+func (c *testSessionCache) GetSTS(config.Role) stsiface.STSAPI { return c.sts }
+func (c *testSessionCache) GetIAM(config.Role) iamiface.IAMAPI { return c.iam }
+func (c *testSessionCache) Refresh()                           {}
+func (c *testSessionCache) Clear()                             { c.cleared = true }
+
+func (c *testSessionCache) GetCloudwatch(region *string, _ config.Role) cloudwatchiface.CloudWatchAPI {
+	return c.cloudwatch[*region]
+}
+
+func (c *testSessionCache) GetRegions(context.Context, config.Role) ([]string, error) {
+	c.regionsCalls++
+	return c.regions, nil
+}
+
+type failingSTS struct {
+	stsiface.STSAPI
+}
+
+func (failingSTS) GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestScrapeAwsDataStream(t *testing.T) {
+	cfg := config.ScrapeConf{
+		Static: []*config.Static{
+			{
+				Name:      "static",
+				Namespace: "AWS/EC2",
+				Regions:   []string{"us-east-1", "eu-west-1"},
+				Roles:     []config.Role{{}},
+			},
+		},
+	}
+	cache := &testSessionCache{sts: failingSTS{}}
+
+	resources, cwData, jobMetrics, errs := ScrapeAwsDataStream(context.Background(), cfg, ScrapeOptions{}, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, logger.NewLogrusLogger(log.StandardLogger()))
+
+	var received []*promutil.PrometheusMetric
+	var receivedErrs []*JobError
+	for jobMetrics != nil || errs != nil {
+		select {
+		case jobMetric, ok := <-jobMetrics:
+			if !ok {
+				jobMetrics = nil
+				continue
+			}
+			received = append(received, jobMetric)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			receivedErrs = append(receivedErrs, err)
+		}
+	}
+	_, resourcesOpen := <-resources
+	_, cwDataOpen := <-cwData
+
+	require.Len(t, received, 2)
+	for _, jobMetric := range received {
+		assert.Equal(t, float64(0), *jobMetric.Value)
+	}
+	require.Len(t, receivedErrs, 2)
+	for _, err := range receivedErrs {
+		assert.Equal(t, "static", err.JobName)
+		assert.ErrorContains(t, err, "access denied")
+	}
+	assert.False(t, resourcesOpen)
+	assert.False(t, cwDataOpen)
+	assert.True(t, cache.cleared)
+}
+
+func TestScrapeAwsDataStream_AccountIdFallback(t *testing.T) {
+	role := config.Role{RoleArn: "arn:aws:iam::123456789012:role/yace-sts-blocked", AccountId: "123456789012"}
+	cfg := config.ScrapeConf{
+		Static: []*config.Static{
+			{
+				Name:      "static",
+				Namespace: "AWS/EC2",
+				Regions:   []string{"us-east-1"},
+				Roles:     []config.Role{role},
+			},
+		},
+	}
+	cache := &testSessionCache{sts: failingSTS{}}
+	failures := promutil.STSFailuresCounter.WithLabelValues("us-east-1", role.RoleArn)
+	before := testutil.ToFloat64(failures)
+
+	_, _, jobMetrics := ScrapeAwsData(context.Background(), cfg, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, jobMetrics, 1)
+	assert.Equal(t, float64(1), *jobMetrics[0].Value)
+	assert.Equal(t, "123456789012", jobMetrics[0].Labels["account"])
+	assert.Equal(t, before+1, testutil.ToFloat64(failures))
+}
+
+type testTaggingAPI struct {
+	resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+	arns []string
+	// tags are the tags of the resources by arn
+	tags map[string][]model.Tag
+}
+
+func (c testTaggingAPI) GetResourcesPagesWithContext(_ aws.Context, _ *resourcegroupstaggingapi.GetResourcesInput, fn func(*resourcegroupstaggingapi.GetResourcesOutput, bool) bool, _ ...request.Option) error {
+	page := &resourcegroupstaggingapi.GetResourcesOutput{}
+	for _, arn := range c.arns {
+		mapping := &resourcegroupstaggingapi.ResourceTagMapping{ResourceARN: aws.String(arn)}
+		for _, tag := range c.tags[arn] {
+			mapping.Tags = append(mapping.Tags, &resourcegroupstaggingapi.Tag{Key: aws.String(tag.Key), Value: aws.String(tag.Value)})
+		}
+		page.ResourceTagMappingList = append(page.ResourceTagMappingList, mapping)
+	}
+	fn(page, true)
+	return nil
+}
+
+// concurrencyCloudwatchAPI records the maximum number of concurrent GetMetricData calls
+type concurrencyCloudwatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	metrics []*cloudwatch.Metric
+
+	mux     sync.Mutex
+	current int
+	max     int
+}
+
+func (c *concurrencyCloudwatchAPI) ListMetricsPagesWithContext(_ aws.Context, _ *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	fn(&cloudwatch.ListMetricsOutput{Metrics: c.metrics}, true)
+	return nil
+}
+
+func (c *concurrencyCloudwatchAPI) GetMetricDataPagesWithContext(_ aws.Context, input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, _ ...request.Option) error {
+	c.mux.Lock()
+	c.current++
+	if c.current > c.max {
+		c.max = c.current
+	}
+	c.mux.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.mux.Lock()
+	c.current--
+	c.mux.Unlock()
+
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, query := range input.MetricDataQueries {
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         query.Id,
+			Values:     []*float64{aws.Float64(1)},
+			Timestamps: []*time.Time{aws.Time(time.Now())},
+		})
+	}
+	fn(output, true)
+	return nil
+}
+
+func TestScrapeDiscoveryJobUsingMetricDataConcurrency(t *testing.T) {
+	const queues = 10
+	var arns []string
+	var metrics []*cloudwatch.Metric
+	for i := 0; i < queues; i++ {
+		name := fmt.Sprintf("queue-%d", i)
+		arns = append(arns, "arn:aws:sqs:us-east-1:123456789012:"+name)
+		metrics = append(metrics, &cloudwatch.Metric{
+			MetricName: aws.String("NumberOfMessagesSent"),
+			Namespace:  aws.String("AWS/SQS"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("QueueName"), Value: aws.String(name)}},
+		})
+	}
+
+	job := &config.Job{
+		Type: "AWS/SQS",
+		Metrics: []*config.Metric{{
+			Name:       "NumberOfMessagesSent",
+			Statistics: []string{"Sum"},
+			Period:     300,
+			Length:     300,
+			NilToZero:  aws.Bool(false),
+		}},
+	}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	clientTag := services.TagsInterface{Client: testTaggingAPI{arns: arns}, Logger: l}
+
+	for _, semaphoreSize := range []int{1, 3} {
+		t.Run(fmt.Sprintf("semaphore size %d", semaphoreSize), func(t *testing.T) {
+			api := &concurrencyCloudwatchAPI{metrics: metrics}
+			clientCloudwatch := cloudwatchInterface{client: api, logger: l}
+			cwData := make(chan *cloudwatchData, queues)
+
+			resources, err := scrapeDiscoveryJobUsingMetricData(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, config.ExportedTagsOnMetrics{}, clientTag, clientTag, clientCloudwatch, 1, nil, semaphore{make(chan struct{}, semaphoreSize)}, semaphore{make(chan struct{}, 1)}, cwData, l)
+			close(cwData)
+
+			require.NoError(t, err)
+			assert.Len(t, resources, queues)
+			assert.Len(t, cwData, queues)
+			assert.LessOrEqual(t, api.max, semaphoreSize)
+		})
+	}
+}
+
+func TestScrapeDiscoveryJobUsingMetricDataPhaseDurations(t *testing.T) {
+	const region = "ap-southeast-4"
+	arn := "arn:aws:sqs:" + region + ":123456789012:queue"
+	metrics := []*cloudwatch.Metric{{
+		MetricName: aws.String("NumberOfMessagesSent"),
+		Namespace:  aws.String("AWS/SQS"),
+		Dimensions: []*cloudwatch.Dimension{{Name: aws.String("QueueName"), Value: aws.String("queue")}},
+	}}
+	job := &config.Job{
+		Type: "AWS/SQS",
+		Metrics: []*config.Metric{{
+			Name:       "NumberOfMessagesSent",
+			Statistics: []string{"Sum"},
+			Period:     300,
+			Length:     300,
+			NilToZero:  aws.Bool(false),
+		}},
+	}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	clientTag := services.TagsInterface{Client: testTaggingAPI{arns: []string{arn}}, Logger: l}
+	clientCloudwatch := cloudwatchInterface{client: &concurrencyCloudwatchAPI{metrics: metrics}, logger: l}
+	cwData := make(chan *cloudwatchData, 1)
+
+	_, err := scrapeDiscoveryJobUsingMetricData(context.Background(), job, region, aws.String("123456789012"), nil, config.ExportedTagsOnMetrics{}, clientTag, clientTag, clientCloudwatch, 500, nil, semaphore{make(chan struct{}, 1)}, semaphore{make(chan struct{}, 1)}, cwData, l)
+	require.NoError(t, err)
+
+	for _, phase := range []string{phaseTagging, phaseListMetrics, phaseGetMetricData} {
+		var out dto.Metric
+		require.NoError(t, promutil.ScrapeJobPhaseDurationHistogram.WithLabelValues("AWS/SQS", region, phase).(prometheus.Metric).Write(&out))
+		assert.Equal(t, uint64(1), out.GetHistogram().GetSampleCount(), phase)
+	}
+}
+
+func TestScrapeDiscoveryJobUsingMetricDataWithoutMetrics(t *testing.T) {
+	job := &config.Job{
+		Type: "AWS/SQS",
+		Metrics: []*config.Metric{{
+			Name:       "NumberOfMessagesSent",
+			Statistics: []string{"Sum"},
+			Period:     300,
+			Length:     300,
+			NilToZero:  aws.Bool(false),
+		}},
+	}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	clientTag := services.TagsInterface{Client: testTaggingAPI{arns: []string{"arn:aws:sqs:us-east-1:123456789012:idle-queue"}}, Logger: l}
+	// ListMetrics returns nothing, the queue has no metrics
+	clientCloudwatch := cloudwatchInterface{client: &concurrencyCloudwatchAPI{}, logger: l}
+	cwData := make(chan *cloudwatchData, 1)
+
+	resources, err := scrapeDiscoveryJobUsingMetricData(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, config.ExportedTagsOnMetrics{}, clientTag, clientTag, clientCloudwatch, 1, nil, semaphore{make(chan struct{}, 1)}, semaphore{make(chan struct{}, 1)}, cwData, l)
+	close(cwData)
+
+	require.NoError(t, err)
+	// The resource is still reported for its info series
+	require.Len(t, resources, 1)
+	assert.Equal(t, "arn:aws:sqs:us-east-1:123456789012:idle-queue", resources[0].ARN)
+	assert.Len(t, cwData, 0)
+}
+
+// inventoryDiscoverer is a custom discoverer supplying the resources of an inventory by region
+type inventoryDiscoverer struct {
+	resources map[string][]*services.TaggedResource
+	err       error
+}
+
+func (d inventoryDiscoverer) Get(_ context.Context, _ *config.Job, region string) ([]*services.TaggedResource, error) {
+	return d.resources[region], d.err
+}
+
+func TestScrapeDiscoveryJobUsingMetricDataCustomDiscoverer(t *testing.T) {
+	job := &config.Job{
+		Type:        "AWS/SQS",
+		ExcludeTags: []model.Tag{{Key: "env", Value: "test"}},
+		Metrics: []*config.Metric{{
+			Name:       "NumberOfMessagesSent",
+			Statistics: []string{"Sum"},
+			Period:     300,
+			Length:     300,
+			NilToZero:  aws.Bool(false),
+		}},
+	}
+	metrics := []*cloudwatch.Metric{{
+		MetricName: aws.String("NumberOfMessagesSent"),
+		Namespace:  aws.String("AWS/SQS"),
+		Dimensions: []*cloudwatch.Dimension{{Name: aws.String("QueueName"), Value: aws.String("orders")}},
+	}}
+	inventory := map[string][]*services.TaggedResource{
+		"us-east-1": {
+			{ARN: "arn:aws:sqs:us-east-1:123456789012:orders", Namespace: "AWS/SQS", Region: "us-east-1"},
+			{ARN: "arn:aws:sqs:us-east-1:123456789012:orders-test", Namespace: "AWS/SQS", Region: "us-east-1", Tags: []model.Tag{{Key: "env", Value: "test"}}},
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		discoverer        inventoryDiscoverer
+		expectedResources []string
+		expectedErr       bool
+	}{
+		{
+			name:              "resources of the inventory instead of the tagging API",
+			discoverer:        inventoryDiscoverer{resources: inventory},
+			expectedResources: []string{"arn:aws:sqs:us-east-1:123456789012:orders"},
+		},
+		{
+			name:        "failing inventory",
+			discoverer:  inventoryDiscoverer{err: errors.New("inventory unavailable")},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			clientTag := services.TagsInterface{Client: testTaggingAPI{arns: []string{"arn:aws:sqs:us-east-1:123456789012:tagged"}}, Logger: l}
+			clientCloudwatch := cloudwatchInterface{client: &concurrencyCloudwatchAPI{metrics: metrics}, logger: l}
+			cwData := make(chan *cloudwatchData, 1)
+
+			resources, err := scrapeDiscoveryJobUsingMetricData(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, config.ExportedTagsOnMetrics{}, tc.discoverer, clientTag, clientCloudwatch, 1, nil, semaphore{make(chan struct{}, 1)}, semaphore{make(chan struct{}, 1)}, cwData, l)
+			close(cwData)
+
+			if tc.expectedErr {
+				require.Error(t, err)
+				assert.Len(t, cwData, 0)
+				return
+			}
+			require.NoError(t, err)
+			arns := make([]string, 0, len(resources))
+			for _, resource := range resources {
+				arns = append(arns, resource.ARN)
+			}
+			assert.Equal(t, tc.expectedResources, arns)
+			// The metrics are associated with the resources of the inventory
+			require.Len(t, cwData, 1)
+			assert.Equal(t, "arn:aws:sqs:us-east-1:123456789012:orders", *(<-cwData).ID)
+		})
+	}
+}
+
+func TestScrapeDiscoveryJobExportedTagsMode(t *testing.T) {
+	arn := "arn:aws:sqs:us-east-1:123456789012:orders"
+	metrics := []*cloudwatch.Metric{{
+		MetricName: aws.String("NumberOfMessagesSent"),
+		Namespace:  aws.String("AWS/SQS"),
+		Dimensions: []*cloudwatch.Dimension{{Name: aws.String("QueueName"), Value: aws.String("orders")}},
+	}}
+	tagsOnMetrics := config.ExportedTagsOnMetrics{"AWS/SQS": {"team"}}
+	expectedInfo := map[string]string{"name": arn, "tag_team": "payments", "tag_env": "production"}
+
+	testCases := []struct {
+		name         string
+		mode         string
+		expectedTags []model.Tag
+	}{
+		{
+			name:         "tags as labels of the metrics by default",
+			mode:         "",
+			expectedTags: []model.Tag{{Key: "team", Value: "payments"}},
+		},
+		{
+			name:         "tags as labels of the metrics",
+			mode:         config.ExportedTagsModeLabels,
+			expectedTags: []model.Tag{{Key: "team", Value: "payments"}},
+		},
+		{
+			name:         "tags only on the info series",
+			mode:         config.ExportedTagsModeInfo,
+			expectedTags: []model.Tag{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			job := &config.Job{
+				Type:             "AWS/SQS",
+				ExportedTagsMode: tc.mode,
+				Metrics: []*config.Metric{{
+					Name:       "NumberOfMessagesSent",
+					Statistics: []string{"Sum"},
+					Period:     300,
+					Length:     300,
+					NilToZero:  aws.Bool(false),
+				}},
+			}
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			clientTag := services.TagsInterface{
+				Client: testTaggingAPI{arns: []string{arn}, tags: map[string][]model.Tag{arn: {{Key: "team", Value: "payments"}, {Key: "env", Value: "production"}}}},
+				Logger: l,
+			}
+			clientCloudwatch := cloudwatchInterface{client: &concurrencyCloudwatchAPI{metrics: metrics}, logger: l}
+			cwData := make(chan *cloudwatchData, 1)
+
+			resources, err := scrapeDiscoveryJobUsingMetricData(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, tagsOnMetrics, clientTag, clientTag, clientCloudwatch, 1, nil, semaphore{make(chan struct{}, 1)}, semaphore{make(chan struct{}, 1)}, cwData, l)
+			close(cwData)
+			require.NoError(t, err)
+
+			require.Len(t, cwData, 1)
+			data := <-cwData
+			assert.Equal(t, tc.expectedTags, data.Tags)
+			assert.Equal(t, arn, *data.ID)
+
+			// The info series has the tags in both modes, joined to the metrics by their name label
+			info := services.MigrateTagsToPrometheus(resources, false, config.LabelSanitization{}, l)
+			require.Len(t, info, 1)
+			assert.Equal(t, "aws_sqs_info", *info[0].Name)
+			assert.Equal(t, expectedInfo, info[0].Labels)
+		})
+	}
+}
+
+func TestScrapeDiscoveryJobSeries(t *testing.T) {
+	arn := "arn:aws:sqs:us-east-1:123456789012:orders"
+	job := &config.Job{
+		Type: "AWS/SQS",
+		Metrics: []*config.Metric{{
+			Name:       "NumberOfMessagesSent",
+			Statistics: []string{"Sum"},
+			Period:     300,
+			Length:     300,
+			NilToZero:  aws.Bool(false),
+			Series: []config.MetricSeries{
+				{Dimensions: []config.Dimension{{Name: "QueueName", Value: "orders"}}},
+				{Dimensions: []config.Dimension{{Name: "QueueName", Value: "untagged"}}},
+			},
+		}},
+	}
+	tagsOnMetrics := config.ExportedTagsOnMetrics{"AWS/SQS": {"team"}}
+
+	testCases := []struct {
+		name     string
+		arns     []string
+		expected map[string][]model.Tag
+	}{
+		{
+			name: "series of discovered resources have their tags",
+			arns: []string{arn},
+			expected: map[string][]model.Tag{
+				"orders":   {{Key: "team", Value: "payments"}},
+				"untagged": {{Key: "team", Value: ""}},
+			},
+		},
+		{
+			name: "series are queried without discovered resources",
+			expected: map[string][]model.Tag{
+				"orders":   {{Key: "team", Value: ""}},
+				"untagged": {{Key: "team", Value: ""}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			clientTag := services.TagsInterface{
+				Client: testTaggingAPI{arns: tc.arns, tags: map[string][]model.Tag{arn: {{Key: "team", Value: "payments"}}}},
+				Logger: l,
+			}
+			api := &listMetricsCountingAPI{CloudWatchAPI: &concurrencyCloudwatchAPI{}}
+			clientCloudwatch := cloudwatchInterface{client: api, logger: l}
+			cwData := make(chan *cloudwatchData, 2)
+
+			_, err := scrapeDiscoveryJobUsingMetricData(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, tagsOnMetrics, clientTag, clientTag, clientCloudwatch, 500, nil, semaphore{make(chan struct{}, 1)}, semaphore{make(chan struct{}, 1)}, cwData, l)
+			close(cwData)
+			require.NoError(t, err)
+
+			assert.Equal(t, 0, api.calls)
+			tags := make(map[string][]model.Tag)
+			for data := range cwData {
+				require.Len(t, data.Dimensions, 1)
+				tags[*data.Dimensions[0].Value] = data.Tags
+			}
+			assert.Equal(t, tc.expected, tags)
+		})
+	}
+}
+
+// slowListMetricsAPI answers ListMetrics after a delay, failing for the metrics in failing,
+// and records the maximum number of concurrent calls
+type slowListMetricsAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	delay   time.Duration
+	failing map[string]bool
+
+	mux     sync.Mutex
+	current int
+	max     int
+}
+
+func (c *slowListMetricsAPI) ListMetricsPagesWithContext(_ aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	c.mux.Lock()
+	c.current++
+	if c.current > c.max {
+		c.max = c.current
+	}
+	c.mux.Unlock()
+
+	time.Sleep(c.delay)
+
+	c.mux.Lock()
+	c.current--
+	c.mux.Unlock()
+
+	if c.failing[*input.MetricName] {
+		return errors.New("list metrics failed")
+	}
+	fn(&cloudwatch.ListMetricsOutput{Metrics: []*cloudwatch.Metric{{MetricName: input.MetricName, Namespace: input.Namespace}}}, true)
+	return nil
+}
+
+func TestGetFullMetricsLists(t *testing.T) {
+	metrics := []*config.Metric{
+		{Name: "CPUUtilization", Id: "cpu"},
+		{Name: "NetworkIn"},
+		{Name: "NetworkOut"},
+		{Name: "DiskReadOps"},
+		{Name: "cpu_percent", Expression: "cpu * 100"},
+	}
+	api := &slowListMetricsAPI{delay: 5 * time.Millisecond, failing: map[string]bool{"NetworkIn": true}}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	clientCloudwatch := cloudwatchInterface{client: api, logger: l}
+
+	metricsLists := getFullMetricsLists(context.Background(), "AWS/EC2", metrics, nil, clientCloudwatch, semaphore{make(chan struct{}, 2)}, l)
+
+	require.Len(t, metricsLists, len(metrics))
+	for i, metric := range metrics {
+		if metric.Name == "NetworkIn" || metric.Expression != "" {
+			assert.Nil(t, metricsLists[i], metric.Name)
+			continue
+		}
+		require.NotNil(t, metricsLists[i], metric.Name)
+		assert.Equal(t, metric.Name, *metricsLists[i].Metrics[0].MetricName)
+	}
+	assert.Equal(t, 2, api.max)
+}
+
+func BenchmarkGetFullMetricsLists(b *testing.B) {
+	var metrics []*config.Metric
+	for i := 0; i < 30; i++ {
+		metrics = append(metrics, &config.Metric{Name: fmt.Sprintf("metric-%d", i)})
+	}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	clientCloudwatch := cloudwatchInterface{client: &slowListMetricsAPI{delay: time.Millisecond}, logger: l}
+
+	// A semaphore of size 1 is equivalent to listing the metrics one after the other
+	for _, semaphoreSize := range []int{1, 5, 30} {
+		b.Run(fmt.Sprintf("semaphore size %d", semaphoreSize), func(b *testing.B) {
+			tagSemaphore := semaphore{make(chan struct{}, semaphoreSize)}
+			for i := 0; i < b.N; i++ {
+				getFullMetricsLists(context.Background(), "AWS/EC2", metrics, nil, clientCloudwatch, tagSemaphore, l)
+			}
+		})
+	}
+}
+
+// usageListMetricsAPI lists AWS/Usage metrics, filtered on dimensions like CloudWatch does
+type usageListMetricsAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	metrics []*cloudwatch.Metric
+	filters []*cloudwatch.DimensionFilter
+}
+
+func (c *usageListMetricsAPI) ListMetricsPagesWithContext(_ aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	c.filters = input.Dimensions
+	var metrics []*cloudwatch.Metric
+	for _, metric := range c.metrics {
+		if input.MetricName != nil && *input.MetricName != *metric.MetricName {
+			continue
+		}
+		if matchesDimensionFilters(metric, input.Dimensions) {
+			metrics = append(metrics, metric)
+		}
+	}
+	fn(&cloudwatch.ListMetricsOutput{Metrics: metrics}, true)
+	return nil
+}
+
+func matchesDimensionFilters(metric *cloudwatch.Metric, filters []*cloudwatch.DimensionFilter) bool {
+	for _, filter := range filters {
+		found := false
+		for _, dimension := range metric.Dimensions {
+			if *dimension.Name == *filter.Name && (filter.Value == nil || *dimension.Value == *filter.Value) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGetMetricDataForQueriesForCustomNamespaceDimensionFilters(t *testing.T) {
+	usageMetric := func(service, resource string) *cloudwatch.Metric {
+		return &cloudwatch.Metric{
+			MetricName: aws.String("ResourceCount"),
+			Namespace:  aws.String("AWS/Usage"),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("Service"), Value: aws.String(service)},
+				{Name: aws.String("Type"), Value: aws.String("Resource")},
+				{Name: aws.String("Resource"), Value: aws.String(resource)},
+				{Name: aws.String("Class"), Value: aws.String("None")},
+			},
+		}
+	}
+	usageMetrics := []*cloudwatch.Metric{
+		usageMetric("EC2", "vCPU"),
+		usageMetric("EC2", "ElasticIP"),
+		usageMetric("Lambda", "ConcurrentExecutions"),
+		usageMetric("DynamoDB", "TableCount"),
+	}
+
+	testCases := []struct {
+		name            string
+		filters         []config.Dimension
+		expectedFilters []*cloudwatch.DimensionFilter
+		expectedQueries int
+	}{
+		{
+			name:            "no filter",
+			expectedQueries: 4,
+		},
+		{
+			name:            "filter on service",
+			filters:         []config.Dimension{{Name: "Service", Value: "EC2"}},
+			expectedFilters: []*cloudwatch.DimensionFilter{{Name: aws.String("Service"), Value: aws.String("EC2")}},
+			expectedQueries: 2,
+		},
+		{
+			name:            "filter on dimension name only",
+			filters:         []config.Dimension{{Name: "Class"}},
+			expectedFilters: []*cloudwatch.DimensionFilter{{Name: aws.String("Class")}},
+			expectedQueries: 4,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &usageListMetricsAPI{metrics: usageMetrics}
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			job := &config.CustomNamespace{
+				Name:             "usage",
+				Namespace:        "AWS/Usage",
+				DimensionFilters: tc.filters,
+				Metrics: []*config.Metric{
+					{Name: "ResourceCount", Statistics: []string{"Maximum"}, Period: 60, Length: 300},
+				},
+			}
+
+			getMetricDatas := getMetricDataForQueriesForCustomNamespace(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, cloudwatchInterface{client: api, logger: l}, semaphore{make(chan struct{}, 1)}, l)
+
+			assert.Len(t, getMetricDatas, tc.expectedQueries)
+			assert.Equal(t, tc.expectedFilters, api.filters)
+		})
+	}
+}
+
+func TestGetMetricDataForQueriesForCustomNamespaceDimensionValueRequirements(t *testing.T) {
+	var metrics []*cloudwatch.Metric
+	for _, asg := range []string{"prod-web", "prod-worker", "staging-web"} {
+		metrics = append(metrics, &cloudwatch.Metric{
+			MetricName: aws.String("GroupInServiceInstances"),
+			Namespace:  aws.String("Custom/ASG"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("AutoScalingGroupName"), Value: aws.String(asg)}},
+		})
+	}
+	// Metrics without the dimension don't match
+	metrics = append(metrics, &cloudwatch.Metric{
+		MetricName: aws.String("GroupInServiceInstances"),
+		Namespace:  aws.String("Custom/ASG"),
+	})
+
+	testCases := []struct {
+		name         string
+		requirements []config.DimensionValueRequirement
+		expectedASGs []string
+	}{
+		{
+			name:         "no requirement",
+			expectedASGs: []string{"prod-web", "prod-worker", "staging-web", ""},
+		},
+		{
+			name:         "value regex",
+			requirements: []config.DimensionValueRequirement{{Name: "AutoScalingGroupName", ValueRegex: "^prod-"}},
+			expectedASGs: []string{"prod-web", "prod-worker"},
+		},
+		{
+			name: "all requirements must match",
+			requirements: []config.DimensionValueRequirement{
+				{Name: "AutoScalingGroupName", ValueRegex: "^prod-"},
+				{Name: "AutoScalingGroupName", ValueRegex: "-web$"},
+			},
+			expectedASGs: []string{"prod-web"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &usageListMetricsAPI{metrics: metrics}
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			job := &config.CustomNamespace{
+				Name:                       "asg",
+				Namespace:                  "Custom/ASG",
+				DimensionValueRequirements: tc.requirements,
+				Metrics: []*config.Metric{
+					{Name: "GroupInServiceInstances", Statistics: []string{"Average"}, Period: 60, Length: 300},
+				},
+			}
+
+			getMetricDatas := getMetricDataForQueriesForCustomNamespace(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, cloudwatchInterface{client: api, logger: l}, semaphore{make(chan struct{}, 1)}, l)
+
+			var asgs []string
+			for _, data := range getMetricDatas {
+				asg := ""
+				for _, dimension := range data.Dimensions {
+					asg = *dimension.Value
+				}
+				asgs = append(asgs, asg)
+			}
+			assert.ElementsMatch(t, tc.expectedASGs, asgs)
+		})
+	}
+}
+
+func TestGetMetricDataForQueriesForCustomNamespaceOverlappingMetrics(t *testing.T) {
+	api := &usageListMetricsAPI{metrics: []*cloudwatch.Metric{
+		{
+			MetricName: aws.String("ResourceCount"),
+			Namespace:  aws.String("AWS/Usage"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("Service"), Value: aws.String("EC2")}},
+		},
+		{
+			MetricName: aws.String("ResourceCount"),
+			Namespace:  aws.String("AWS/Usage"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("Service"), Value: aws.String("Lambda")}},
+		},
+	}}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	job := &config.CustomNamespace{
+		Name:      "usage",
+		Namespace: "AWS/Usage",
+		Metrics: []*config.Metric{
+			{Name: "ResourceCount", Statistics: []string{"Maximum", "Average"}, Period: 60, Length: 300},
+			// Overlaps the Maximum statistic of the first metric
+			{Name: "ResourceCount", Statistics: []string{"Maximum"}, Period: 60, Length: 300},
+			// A different period is a different query
+			{Name: "ResourceCount", Statistics: []string{"Maximum"}, Period: 300, Length: 300},
+		},
+	}
+
+	getMetricDatas := getMetricDataForQueriesForCustomNamespace(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, cloudwatchInterface{client: api, logger: l}, semaphore{make(chan struct{}, 1)}, l)
+
+	require.Len(t, getMetricDatas, 6)
+	seen := make(map[string]struct{})
+	for _, data := range getMetricDatas {
+		key := fmt.Sprintf("%s %s %d", dimensionsToKey(data.Dimensions), data.Statistics[0], data.Period)
+		assert.NotContains(t, seen, key)
+		seen[key] = struct{}{}
+	}
+}
+
+func TestGetMetricDataForQueriesForCustomNamespaceMetricIDs(t *testing.T) {
+	var metrics []*cloudwatch.Metric
+	for i := 0; i < 500; i++ {
+		for _, name := range []string{"CallCount", "ResourceCount"} {
+			metrics = append(metrics, &cloudwatch.Metric{
+				MetricName: aws.String(name),
+				Namespace:  aws.String("AWS/Usage"),
+				Dimensions: []*cloudwatch.Dimension{{Name: aws.String("Resource"), Value: aws.String(strconv.Itoa(i))}},
+			})
+		}
+	}
+	api := &usageListMetricsAPI{metrics: metrics}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	job := &config.CustomNamespace{
+		Name:      "usage",
+		Namespace: "AWS/Usage",
+		Metrics: []*config.Metric{
+			{Name: "CallCount", Statistics: []string{"Sum", "Average", "Maximum", "Minimum"}, Period: 60, Length: 300},
+			{Name: "ResourceCount", Statistics: []string{"Sum", "Average", "Maximum", "Minimum"}, Period: 60, Length: 300},
+		},
+	}
+
+	ids := func() []string {
+		getMetricDatas := getMetricDataForQueriesForCustomNamespace(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, cloudwatchInterface{client: api, logger: l}, semaphore{make(chan struct{}, 1)}, l)
+		ids := make([]string, 0, len(getMetricDatas))
+		for _, data := range getMetricDatas {
+			ids = append(ids, *data.MetricID)
+		}
+		return ids
+	}
+
+	first := ids()
+	require.Len(t, first, 4000)
+	seen := make(map[string]struct{}, len(first))
+	for _, id := range first {
+		assert.NotContains(t, seen, id)
+		seen[id] = struct{}{}
+	}
+	// The queries get the same ids on every scrape
+	assert.Equal(t, first, ids())
+}
+
+func TestGetMetricDataForQueriesForCustomNamespaceNameRegex(t *testing.T) {
+	var metrics []*cloudwatch.Metric
+	for _, name := range []string{"CallCount", "ResourceCount", "ThrottleCount", "Latency"} {
+		metrics = append(metrics, &cloudwatch.Metric{
+			MetricName: aws.String(name),
+			Namespace:  aws.String("AWS/Usage"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("Service"), Value: aws.String("EC2")}},
+		})
+	}
+	api := &usageListMetricsAPI{metrics: metrics}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	job := &config.CustomNamespace{
+		Name:      "usage",
+		Namespace: "AWS/Usage",
+		Metrics: []*config.Metric{
+			{Name: "CallCount", Statistics: []string{"Sum"}, Period: 60, Length: 300},
+			// Also matches the explicitly listed CallCount, which keeps its own settings
+			{NameRegex: "Count$", Statistics: []string{"Maximum"}, Period: 60, Length: 300},
+			// Only matches ResourceCount, already selected by the previous regex
+			{NameRegex: "^Resource", Statistics: []string{"Average"}, Period: 60, Length: 300},
+		},
+	}
+
+	getMetricDatas := getMetricDataForQueriesForCustomNamespace(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, cloudwatchInterface{client: api, logger: l}, semaphore{make(chan struct{}, 1)}, l)
+
+	statistics := make(map[string][]string)
+	for _, data := range getMetricDatas {
+		statistics[*data.Metric] = append(statistics[*data.Metric], data.Statistics...)
+	}
+	assert.Equal(t, map[string][]string{
+		"CallCount":     {"Sum"},
+		"ResourceCount": {"Maximum"},
+		"ThrottleCount": {"Maximum"},
+	}, statistics)
+}
+
+// linkedAccountsListMetricsAPI lists the metrics of a monitoring account, along with the ones of its linked source
+// accounts when they are included
+type linkedAccountsListMetricsAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	metrics        []*cloudwatch.Metric
+	owningAccounts []string
+}
+
+func (c *linkedAccountsListMetricsAPI) ListMetricsPagesWithContext(_ aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	output := &cloudwatch.ListMetricsOutput{}
+	for i, metric := range c.metrics {
+		if input.MetricName != nil && *input.MetricName != *metric.MetricName {
+			continue
+		}
+		if !aws.BoolValue(input.IncludeLinkedAccounts) {
+			if c.owningAccounts[i] == "123456789012" {
+				output.Metrics = append(output.Metrics, metric)
+			}
+			continue
+		}
+		output.Metrics = append(output.Metrics, metric)
+		output.OwningAccounts = append(output.OwningAccounts, aws.String(c.owningAccounts[i]))
+	}
+	fn(output, true)
+	return nil
+}
+
+func TestGetMetricDataForQueriesForCustomNamespaceLinkedAccounts(t *testing.T) {
+	api := &linkedAccountsListMetricsAPI{owningAccounts: []string{"123456789012", "111111111111", "222222222222"}}
+	for range api.owningAccounts {
+		api.metrics = append(api.metrics, &cloudwatch.Metric{
+			MetricName: aws.String("CallCount"),
+			Namespace:  aws.String("AWS/Usage"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("Service"), Value: aws.String("EC2")}},
+		})
+	}
+
+	testCases := []struct {
+		name                  string
+		includeLinkedAccounts bool
+		nameRegex             string
+		expected              map[string]bool
+	}{
+		{
+			name:     "monitoring account only",
+			expected: map[string]bool{"123456789012": false},
+		},
+		{
+			name:                  "linked accounts",
+			includeLinkedAccounts: true,
+			expected:              map[string]bool{"123456789012": false, "111111111111": true, "222222222222": true},
+		},
+		{
+			name:                  "linked accounts with a name regex",
+			includeLinkedAccounts: true,
+			nameRegex:             "Count$",
+			expected:              map[string]bool{"123456789012": false, "111111111111": true, "222222222222": true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			metric := &config.Metric{Name: "CallCount", Statistics: []string{"Sum"}, Period: 60, Length: 300}
+			if tc.nameRegex != "" {
+				metric = &config.Metric{NameRegex: tc.nameRegex, Statistics: []string{"Sum"}, Period: 60, Length: 300}
+			}
+			job := &config.CustomNamespace{
+				Name:                  "usage",
+				Namespace:             "AWS/Usage",
+				Metrics:               []*config.Metric{metric},
+				IncludeLinkedAccounts: tc.includeLinkedAccounts,
+			}
+			clientCloudwatch := cloudwatchInterface{client: api, logger: l, includeLinkedAccounts: tc.includeLinkedAccounts}
+
+			getMetricDatas := getMetricDataForQueriesForCustomNamespace(context.Background(), job, "us-east-1", aws.String("123456789012"), aws.String("monitoring"), clientCloudwatch, semaphore{make(chan struct{}, 1)}, l)
+
+			// The same series of different accounts aren't collapsed
+			linked := make(map[string]bool)
+			for _, data := range getMetricDatas {
+				linked[*data.AccountId] = data.LinkedAccount
+				if data.LinkedAccount {
+					assert.Nil(t, data.AccountAlias)
+				} else {
+					assert.Equal(t, "monitoring", *data.AccountAlias)
+				}
+			}
+			assert.Equal(t, tc.expected, linked)
+		})
+	}
+}
+
+func TestGetMetricDataForQueriesStaticDimensions(t *testing.T) {
+	api := &usageListMetricsAPI{metrics: []*cloudwatch.Metric{
+		{
+			MetricName: aws.String("CPUUtilization"),
+			Namespace:  aws.String("AWS/EC2"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String("i-1")}},
+		},
+		{
+			MetricName: aws.String("CPUUtilization"),
+			Namespace:  aws.String("AWS/EC2"),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("InstanceId"), Value: aws.String("i-2")},
+				{Name: aws.String("Cluster"), Value: aws.String("discovered")},
+			},
+		},
+	}}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	job := &config.Job{
+		Type: "ec2",
+		StaticDimensions: []config.Dimension{
+			{Name: "Cluster", Value: "static"},
+			{Name: "Environment", Value: "prod"},
+		},
+		Metrics: []*config.Metric{
+			{Name: "CPUUtilization", Statistics: []string{"Average"}, Period: 300, Length: 300},
+		},
+	}
+	resources := []*services.TaggedResource{
+		{ARN: "arn:aws:ec2:us-east-1:123456789012:instance/i-1", Namespace: "ec2", Region: "us-east-1"},
+		{ARN: "arn:aws:ec2:us-east-1:123456789012:instance/i-2", Namespace: "ec2", Region: "us-east-1"},
+	}
+
+	getMetricDatas := getMetricDataForQueries(context.Background(), job, services.SupportedServices.GetService("ec2"), "us-east-1", aws.String("123456789012"), nil, nil, cloudwatchInterface{client: api, logger: l}, resources, nil, nil, semaphore{make(chan struct{}, 1)}, l)
+	require.Len(t, getMetricDatas, 2)
+
+	labels := map[string]map[string]string{}
+	for i := range getMetricDatas {
+		labels[*getMetricDatas[i].ID] = createPrometheusLabels(&getMetricDatas[i], false, config.LabelSanitization{}, l)
+	}
+	// Static dimensions are added as labels, without overwriting the discovered ones
+	assert.Equal(t, "static", labels["arn:aws:ec2:us-east-1:123456789012:instance/i-1"]["dimension_Cluster"])
+	assert.Equal(t, "prod", labels["arn:aws:ec2:us-east-1:123456789012:instance/i-1"]["dimension_Environment"])
+	assert.Equal(t, "discovered", labels["arn:aws:ec2:us-east-1:123456789012:instance/i-2"]["dimension_Cluster"])
+	assert.Equal(t, "prod", labels["arn:aws:ec2:us-east-1:123456789012:instance/i-2"]["dimension_Environment"])
+
+	// The listed metrics are left untouched
+	assert.Len(t, api.metrics[0].Dimensions, 1)
+	assert.Len(t, api.metrics[1].Dimensions, 2)
+}
+
+func TestGetMetricDataForQueriesConfiguredMetrics(t *testing.T) {
+	bucketDimension := func(bucket string) *cloudwatch.Dimension {
+		return &cloudwatch.Dimension{Name: aws.String("BucketName"), Value: aws.String(bucket)}
+	}
+	api := &usageListMetricsAPI{metrics: []*cloudwatch.Metric{
+		{
+			MetricName: aws.String("BucketSizeBytes"),
+			Namespace:  aws.String("AWS/S3"),
+			Dimensions: []*cloudwatch.Dimension{bucketDimension("bucket-a"), {Name: aws.String("StorageType"), Value: aws.String("StandardStorage")}},
+		},
+		// Listed with a filter which was since deleted
+		{
+			MetricName: aws.String("AllRequests"),
+			Namespace:  aws.String("AWS/S3"),
+			Dimensions: []*cloudwatch.Dimension{bucketDimension("bucket-a"), {Name: aws.String("FilterId"), Value: aws.String("deleted")}},
+		},
+	}}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	job := &config.Job{
+		Type: "s3",
+		Metrics: []*config.Metric{
+			{Name: "BucketSizeBytes", Statistics: []string{"Average"}, Period: 86400, Length: 172800},
+			{Name: "AllRequests", Statistics: []string{"Sum"}, Period: 60, Length: 300},
+		},
+	}
+	resources := []*services.TaggedResource{
+		{ARN: "arn:aws:s3:::bucket-a", Namespace: "s3", Region: "us-east-1", Tags: []model.Tag{{Key: "team", Value: "storage"}}},
+		{ARN: "arn:aws:s3:::bucket-b", Namespace: "s3", Region: "us-east-1"},
+	}
+	configuredDimensions := [][]*cloudwatch.Dimension{
+		{bucketDimension("bucket-a"), {Name: aws.String("FilterId"), Value: aws.String("EntireBucket")}},
+		{bucketDimension("bucket-b"), {Name: aws.String("FilterId"), Value: aws.String("Documents")}},
+	}
+
+	getMetricDatas := getMetricDataForQueries(context.Background(), job, services.SupportedServices.GetService("s3"), "us-east-1", aws.String("123456789012"), nil, config.ExportedTagsOnMetrics{"s3": {"team"}}, cloudwatchInterface{client: api, logger: l}, resources, configuredDimensions, nil, semaphore{make(chan struct{}, 1)}, l)
+	require.Len(t, getMetricDatas, 3)
+
+	filters := map[string]string{}
+	for _, data := range getMetricDatas {
+		if *data.Metric != "AllRequests" {
+			assert.Equal(t, "BucketSizeBytes", *data.Metric)
+			continue
+		}
+		for _, dimension := range data.Dimensions {
+			if *dimension.Name == "FilterId" {
+				filters[*data.ID] = *dimension.Value
+			}
+		}
+		// The request metrics are associated to their bucket, and its tags
+		if *data.ID == "arn:aws:s3:::bucket-a" {
+			assert.Equal(t, []model.Tag{{Key: "team", Value: "storage"}}, data.Tags)
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"arn:aws:s3:::bucket-a": "EntireBucket",
+		"arn:aws:s3:::bucket-b": "Documents",
+	}, filters)
+}
+
+func TestDedupGetMetricDatas(t *testing.T) {
+	dimensions := []*cloudwatch.Dimension{
+		{Name: aws.String("InstanceId"), Value: aws.String("i-1")},
+		{Name: aws.String("AutoScalingGroupName"), Value: aws.String("asg")},
+	}
+	// Same dimensions in a different order
+	reversedDimensions := []*cloudwatch.Dimension{dimensions[1], dimensions[0]}
+	data := func(id string, dimensions []*cloudwatch.Dimension, statistic string, customTags []model.Tag) cloudwatchData {
+		return cloudwatchData{
+			MetricID:   aws.String(id),
+			Namespace:  aws.String("AWS/EC2"),
+			Metric:     aws.String("CPUUtilization"),
+			Dimensions: dimensions,
+			Statistics: []string{statistic},
+			Period:     300,
+			CustomTags: customTags,
+		}
+	}
+	jobTags := []model.Tag{{Key: "team", Value: "a"}}
+
+	output := dedupGetMetricDatas([]cloudwatchData{
+		data("id_1", dimensions, "Average", jobTags),
+		data("id_2", reversedDimensions, "Average", []model.Tag{{Key: "team", Value: "b"}, {Key: "env", Value: "prod"}}),
+		data("id_3", dimensions, "Maximum", jobTags),
+	}, logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, output, 2)
+	assert.Equal(t, "id_1", *output[0].MetricID)
+	assert.Equal(t, []model.Tag{{Key: "team", Value: "a"}, {Key: "env", Value: "prod"}}, output[0].CustomTags)
+	assert.Equal(t, "id_3", *output[1].MetricID)
+	assert.Equal(t, jobTags, output[1].CustomTags)
+	// The tags shared by the queries of the job are left untouched
+	assert.Equal(t, []model.Tag{{Key: "team", Value: "a"}}, jobTags)
+}
+
+func TestApplySeriesLimit(t *testing.T) {
+	getMetricDatas := make([]cloudwatchData, 5)
+	for i := range getMetricDatas {
+		getMetricDatas[i] = cloudwatchData{MetricID: aws.String(fmt.Sprintf("id_%d", i))}
+	}
+
+	testCases := []struct {
+		name            string
+		maxSeries       int
+		onLimitExceeded string
+		expectedSeries  int
+		expectedErr     error
+		expectedGauge   float64
+	}{
+		{
+			name:           "no limit",
+			maxSeries:      0,
+			expectedSeries: 5,
+		},
+		{
+			name:           "under the limit",
+			maxSeries:      5,
+			expectedSeries: 5,
+			expectedGauge:  0,
+		},
+		{
+			name:           "truncate by default",
+			maxSeries:      3,
+			expectedSeries: 3,
+			expectedGauge:  1,
+		},
+		{
+			name:            "truncate",
+			maxSeries:       2,
+			onLimitExceeded: config.OnLimitExceededTruncate,
+			expectedSeries:  2,
+			expectedGauge:   1,
+		},
+		{
+			name:            "skip",
+			maxSeries:       2,
+			onLimitExceeded: config.OnLimitExceededSkip,
+			expectedSeries:  0,
+			expectedErr:     errSeriesLimitExceeded,
+			expectedGauge:   1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			labels := seriesLimitLabels("ec2", "", "us-east-1", aws.String(tc.name))
+			output, err := applySeriesLimit(getMetricDatas, tc.maxSeries, tc.onLimitExceeded, labels, logger.NewLogrusLogger(log.StandardLogger()))
+
+			assert.Equal(t, tc.expectedErr, err)
+			require.Len(t, output, tc.expectedSeries)
+			for i := range output {
+				assert.Equal(t, *getMetricDatas[i].MetricID, *output[i].MetricID)
+			}
+			assert.Equal(t, tc.expectedGauge, testutil.ToFloat64(promutil.SeriesLimitExceededGauge.With(labels)))
+		})
+	}
+}
+
+func TestExpandRegions(t *testing.T) {
+	testCases := []struct {
+		name       string
+		regions    []string
+		allRegions []string
+		expected   []string
+	}{
+		{
+			name:       "no wildcard",
+			regions:    []string{"us-east-1", "eu-west-1"},
+			allRegions: []string{"ap-south-1"},
+			expected:   []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name:       "wildcard",
+			regions:    []string{"*"},
+			allRegions: []string{"us-east-1", "eu-west-1"},
+			expected:   []string{"us-east-1", "eu-west-1"},
+		},
+		{
+			name:       "wildcard and duplicated region",
+			regions:    []string{"eu-west-1", "*"},
+			allRegions: []string{"us-east-1", "eu-west-1"},
+			expected:   []string{"eu-west-1", "us-east-1"},
+		},
+		{
+			name:       "regions could not be resolved",
+			regions:    []string{"*"},
+			allRegions: nil,
+			expected:   nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, expandRegions(tc.regions, tc.allRegions))
+		})
+	}
+}
+
+func TestScrapeAwsDataStreamAllRegions(t *testing.T) {
+	role := config.Role{RoleArn: "arn:aws:iam::123456789012:role/yace"}
+	cfg := config.ScrapeConf{
+		Static: []*config.Static{
+			{Name: "first", Namespace: "AWS/EC2", Regions: []string{"*"}, Roles: []config.Role{role}},
+			{Name: "second", Namespace: "AWS/EC2", Regions: []string{"*"}, Roles: []config.Role{role}},
+		},
+	}
+	cache := &testSessionCache{sts: failingSTS{}, regions: []string{"us-east-1", "eu-west-1", "ap-south-1"}}
+
+	resources, cwData, jobMetrics, errs := ScrapeAwsDataStream(context.Background(), cfg, ScrapeOptions{}, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, logger.NewLogrusLogger(log.StandardLogger()))
+
+	// Every job fails on STS
+	go func() {
+		for range errs {
+		}
+	}()
+	regions := make(map[string]int)
+	for jobMetric := range jobMetrics {
+		regions[jobMetric.Labels["region"]]++
+	}
+	<-resources
+	<-cwData
+
+	assert.Equal(t, map[string]int{"us-east-1": 2, "eu-west-1": 2, "ap-south-1": 2}, regions)
+	// The regions are looked up once per role, not once per job
+	assert.Equal(t, 1, cache.regionsCalls)
+}
+
+type accountSTS struct {
+	stsiface.STSAPI
+}
+
+func (accountSTS) GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Account: aws.String("123456789012")}, nil
+}
+
+// statisticsCloudwatchAPI answers GetMetricStatistics with a datapoint, or blocks until the request is cancelled
+type statisticsCloudwatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	blocking bool
+}
+
+func (c statisticsCloudwatchAPI) GetMetricStatisticsWithContext(ctx aws.Context, _ *cloudwatch.GetMetricStatisticsInput, _ ...request.Option) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	if c.blocking {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &cloudwatch.GetMetricStatisticsOutput{Datapoints: []*cloudwatch.Datapoint{{Average: aws.Float64(1), Timestamp: aws.Time(time.Now())}}}, nil
+}
+
+func TestScrapeAwsDataRoleLabel(t *testing.T) {
+	roles := []config.Role{
+		{RoleArn: "arn:aws:iam::123456789012:role/yace"},
+		{RoleArn: "arn:aws:iam::123456789012:role/shared", Alias: "shared-services"},
+	}
+	testCases := []struct {
+		name          string
+		roleLabel     bool
+		expectedRoles map[string]bool
+	}{
+		{
+			name:          "disabled",
+			expectedRoles: map[string]bool{"": true},
+		},
+		{
+			name:          "enabled",
+			roleLabel:     true,
+			expectedRoles: map[string]bool{"arn:aws:iam::123456789012:role/yace": true, "shared-services": true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.ScrapeConf{
+				Discovery: config.Discovery{RoleLabel: tc.roleLabel},
+				Static: []*config.Static{
+					{
+						Name:      "static",
+						Namespace: "AWS/EC2",
+						Regions:   []string{"us-east-1"},
+						Roles:     roles,
+						Metrics:   []*config.Metric{{Name: "CPUUtilization", Statistics: []string{"Average"}, Period: 300, Length: 300}},
+					},
+				},
+			}
+			cache := &testSessionCache{
+				sts:        accountSTS{},
+				cloudwatch: map[string]cloudwatchiface.CloudWatchAPI{"us-east-1": statisticsCloudwatchAPI{}},
+			}
+
+			_, cwData, _ := ScrapeAwsData(context.Background(), cfg, 500, make(chan struct{}, 2), make(chan struct{}, 2), cache, logger.NewLogrusLogger(log.StandardLogger()))
+
+			require.Len(t, cwData, 2)
+			roleLabels := make(map[string]bool)
+			for _, data := range cwData {
+				roleLabels[aws.StringValue(data.Role)] = true
+				// The values are the same with or without the label
+				require.Len(t, data.Points, 1)
+				assert.Equal(t, 1.0, *data.Points[0].Average)
+			}
+			assert.Equal(t, tc.expectedRoles, roleLabels)
+		})
+	}
+}
+
+func TestScrapeAwsDataJobTimeout(t *testing.T) {
+	cfg := config.ScrapeConf{
+		Static: []*config.Static{
+			{
+				Name:      "static",
+				Namespace: "AWS/EC2",
+				Regions:   []string{"us-east-1", "eu-west-1"},
+				Roles:     []config.Role{{}},
+				Metrics:   []*config.Metric{{Name: "CPUUtilization", Statistics: []string{"Average"}, Period: 300, Length: 300}},
+				Timeout:   50 * time.Millisecond,
+			},
+		},
+	}
+	cache := &testSessionCache{
+		sts: accountSTS{},
+		cloudwatch: map[string]cloudwatchiface.CloudWatchAPI{
+			"us-east-1": statisticsCloudwatchAPI{},
+			// The region is stuck, e.g. behind a network blackhole
+			"eu-west-1": statisticsCloudwatchAPI{blocking: true},
+		},
+	}
+
+	done := make(chan struct{})
+	var cwData []*cloudwatchData
+	var jobMetrics []*promutil.PrometheusMetric
+	go func() {
+		_, cwData, jobMetrics = ScrapeAwsData(context.Background(), cfg, 500, make(chan struct{}, 2), make(chan struct{}, 2), cache, logger.NewLogrusLogger(log.StandardLogger()))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stuck region blocked the scrape")
+	}
+
+	require.Len(t, cwData, 1)
+	assert.Equal(t, "us-east-1", *cwData[0].Region)
+
+	success := make(map[string]float64)
+	for _, jobMetric := range jobMetrics {
+		success[jobMetric.Labels["region"]] = *jobMetric.Value
+	}
+	assert.Equal(t, map[string]float64{"us-east-1": 1, "eu-west-1": 0}, success)
+}
+
+func TestWithJobTimeout(t *testing.T) {
+	ctx, cancel := withJobTimeout(context.Background(), 0)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok, "no deadline without timeout")
+
+	ctx, cancel = withJobTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.True(t, ok)
+}
+
+func TestWaitJitter(t *testing.T) {
+	assert.True(t, waitJitter(context.Background(), 0))
+	assert.True(t, waitJitter(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	assert.False(t, waitJitter(ctx, time.Hour))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestScrapeJobsCancelledContext(t *testing.T) {
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Full semaphores would block forever if the cancellation was not honored
+	fullSemaphore := func() semaphore {
+		sem := make(chan struct{}, 1)
+		sem <- struct{}{}
+		return semaphore{sem}
+	}
+	clientCloudwatch := cloudwatchInterface{client: &concurrencyCloudwatchAPI{}, logger: l}
+	metrics := []*config.Metric{{Name: "CPUUtilization", Statistics: []string{"Average"}, Period: 300, Length: 300}}
+
+	testCases := []struct {
+		name   string
+		scrape func(cwData chan<- *cloudwatchData) error
+	}{
+		{
+			name: "static job",
+			scrape: func(cwData chan<- *cloudwatchData) error {
+				job := &config.Static{Name: "static", Namespace: "AWS/EC2", Metrics: metrics}
+				return scrapeStaticJob(ctx, job, "us-east-1", aws.String("123456789012"), nil, clientCloudwatch, fullSemaphore(), cwData, l)
+			},
+		},
+		{
+			name: "discovery job",
+			scrape: func(cwData chan<- *cloudwatchData) error {
+				job := &config.Job{Type: "AWS/EC2", Metrics: metrics}
+				_, err := scrapeDiscoveryJobUsingMetricData(ctx, job, "us-east-1", aws.String("123456789012"), nil, config.ExportedTagsOnMetrics{}, services.TagsInterface{Logger: l}, services.TagsInterface{Logger: l}, clientCloudwatch, 500, nil, fullSemaphore(), fullSemaphore(), cwData, l)
+				return err
+			},
+		},
+		{
+			name: "custom namespace job",
+			scrape: func(cwData chan<- *cloudwatchData) error {
+				job := &config.CustomNamespace{Name: "custom", Namespace: "CustomNamespace", Metrics: metrics}
+				return scrapeCustomNamespaceJobUsingMetricData(ctx, job, "us-east-1", aws.String("123456789012"), nil, clientCloudwatch, fullSemaphore(), fullSemaphore(), cwData, l, 500)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			done := make(chan error)
+			go func() {
+				done <- tc.scrape(make(chan *cloudwatchData, 10))
+			}()
+
+			select {
+			case err := <-done:
+				assert.ErrorIs(t, err, context.Canceled)
+			case <-time.After(time.Second):
+				t.Fatal("scrape did not return after the context was cancelled")
+			}
+		})
+	}
+}
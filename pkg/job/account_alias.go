@@ -0,0 +1,78 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/session"
+)
+
+// accountAliasCacheTTL is how long the alias of an account is reused before looking it up again
+const accountAliasCacheTTL = time.Hour
+
+// accountAlias is the cached alias of an account. Its mutex is held during the lookup, so that the
+// jobs of an account starting together only look it up once.
+type accountAlias struct {
+	mu     sync.Mutex
+	alias  string
+	expiry time.Time
+}
+
+var (
+	accountAliasesMu sync.Mutex
+	accountAliases   = map[string]*accountAlias{}
+)
+
+// getAccountAliasIfEnabled returns the alias of the account of role, see getAccountAlias, or nil when
+// the account_alias label is disabled.
+func getAccountAliasIfEnabled(ctx context.Context, enabled bool, cache session.SessionCache, role config.Role, accountId string, logger logger.Logger) *string {
+	if !enabled {
+		return nil
+	}
+	return aws.String(getAccountAlias(ctx, cache, role, accountId, logger))
+}
+
+// getAccountAlias returns the alias of accountId, looked up with IAM ListAccountAliases as role and cached
+// for accountAliasCacheTTL. It falls back to accountId when the account has no alias or the lookup fails,
+// e.g. because it is denied.
+func getAccountAlias(ctx context.Context, cache session.SessionCache, role config.Role, accountId string, logger logger.Logger) string {
+	accountAliasesMu.Lock()
+	entry, ok := accountAliases[accountId]
+	if !ok {
+		entry = &accountAlias{}
+		accountAliases[accountId] = entry
+	}
+	accountAliasesMu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if time.Now().Before(entry.expiry) {
+		return entry.alias
+	}
+
+	promutil.IAMAPICounter.Inc()
+	output, err := cache.GetIAM(role).ListAccountAliasesWithContext(ctx, &iam.ListAccountAliasesInput{})
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// The scrape was cancelled, the alias is looked up again by the next one
+		return accountId
+	case err != nil:
+		logger.Warn("Couldn't get the account alias, using the account id instead", "err", err)
+		entry.alias = accountId
+	case len(output.AccountAliases) == 0:
+		logger.Debug("Account has no alias, using the account id instead")
+		entry.alias = accountId
+	default:
+		// An account has at most one alias
+		entry.alias = aws.StringValue(output.AccountAliases[0])
+	}
+	entry.expiry = time.Now().Add(accountAliasCacheTTL)
+	return entry.alias
+}
@@ -0,0 +1,93 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+type listAccountAliasesAPI struct {
+	iamiface.IAMAPI
+	aliases []string
+	err     error
+	calls   int
+}
+
+func (c *listAccountAliasesAPI) ListAccountAliasesWithContext(aws.Context, *iam.ListAccountAliasesInput, ...request.Option) (*iam.ListAccountAliasesOutput, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &iam.ListAccountAliasesOutput{AccountAliases: aws.StringSlice(c.aliases)}, nil
+}
+
+func TestGetAccountAlias(t *testing.T) {
+	testCases := []struct {
+		name          string
+		accountId     string
+		api           *listAccountAliasesAPI
+		expectedAlias string
+	}{
+		{
+			name:          "account with an alias",
+			accountId:     "111111111111",
+			api:           &listAccountAliasesAPI{aliases: []string{"production"}},
+			expectedAlias: "production",
+		},
+		{
+			name:          "account without alias",
+			accountId:     "222222222222",
+			api:           &listAccountAliasesAPI{},
+			expectedAlias: "222222222222",
+		},
+		{
+			name:          "lookup denied",
+			accountId:     "333333333333",
+			api:           &listAccountAliasesAPI{err: errors.New("AccessDenied")},
+			expectedAlias: "333333333333",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := &testSessionCache{iam: tc.api}
+			l := logger.NewLogrusLogger(log.StandardLogger())
+
+			assert.Equal(t, tc.expectedAlias, getAccountAlias(context.Background(), cache, config.Role{}, tc.accountId, l))
+			// The alias, or the fallback to the account id, is cached
+			assert.Equal(t, tc.expectedAlias, getAccountAlias(context.Background(), cache, config.Role{}, tc.accountId, l))
+			assert.Equal(t, 1, tc.api.calls)
+		})
+	}
+}
+
+func TestGetAccountAliasIfEnabled(t *testing.T) {
+	api := &listAccountAliasesAPI{aliases: []string{"staging"}}
+	cache := &testSessionCache{iam: api}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+
+	assert.Nil(t, getAccountAliasIfEnabled(context.Background(), false, cache, config.Role{}, "444444444444", l))
+	assert.Equal(t, 0, api.calls)
+
+	alias := getAccountAliasIfEnabled(context.Background(), true, cache, config.Role{}, "444444444444", l)
+	assert.Equal(t, aws.String("staging"), alias)
+
+	labels := createPrometheusLabels(&cloudwatchData{
+		ID:           aws.String("arn:aws:ec2:us-east-1:444444444444:instance/i-1"),
+		Region:       aws.String("us-east-1"),
+		AccountId:    aws.String("444444444444"),
+		AccountAlias: alias,
+	}, false, config.LabelSanitization{}, l)
+	assert.Equal(t, "444444444444", labels["account_id"])
+	assert.Equal(t, "staging", labels["account_alias"])
+}
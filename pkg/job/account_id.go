@@ -0,0 +1,73 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/session"
+)
+
+// stsRetry retries the GetCallerIdentity calls which are throttled or fail on the server side
+var stsRetry = config.Retry{MaxAttempts: 3, BaseDelay: 200 * time.Millisecond}
+
+// accountId is the account id of a role. Its mutex is held during the lookup, so that the jobs of
+// the role starting together only look it up once.
+type accountId struct {
+	mu      sync.Mutex
+	done    bool
+	account *string
+	err     error
+}
+
+// accountIds are the account ids of the roles looked up during a scrape. The account of a role is the
+// same in all the regions, STS is called once per role instead of once per job and region.
+type accountIds struct {
+	mu      sync.Mutex
+	entries map[config.Role]*accountId
+}
+
+func newAccountIds() *accountIds {
+	return &accountIds{entries: map[config.Role]*accountId{}}
+}
+
+// get returns the account id of role from STS GetCallerIdentity, or the error of the lookup
+func (a *accountIds) get(ctx context.Context, cache session.SessionCache, role config.Role) (*string, error) {
+	a.mu.Lock()
+	entry, ok := a.entries[role]
+	if !ok {
+		entry = &accountId{}
+		a.entries[role] = entry
+	}
+	a.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.done {
+		return entry.account, entry.err
+	}
+
+	var result *sts.GetCallerIdentityOutput
+	err := withRetry(ctx, stsRetry, func() error {
+		var err error
+		result, err = cache.GetSTS(role).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+		return err
+	})
+	var account *string
+	if err == nil {
+		account = result.Account
+		if account == nil {
+			err = errors.New("GetCallerIdentity returned no account")
+		}
+	}
+
+	// A lookup cut short by the context of its job is done again by the next job of the role
+	if ctx.Err() == nil {
+		entry.done, entry.account, entry.err = true, account, err
+	}
+	return account, err
+}
@@ -0,0 +1,139 @@
+package job
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+// countingSTS returns the account of GetCallerIdentity after failing with errs, one per call
+type countingSTS struct {
+	stsiface.STSAPI
+	mu    sync.Mutex
+	errs  []error
+	calls int
+}
+
+func (c *countingSTS) GetCallerIdentityWithContext(aws.Context, *sts.GetCallerIdentityInput, ...request.Option) (*sts.GetCallerIdentityOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return nil, err
+	}
+	return &sts.GetCallerIdentityOutput{Account: aws.String("123456789012")}, nil
+}
+
+func TestAccountIds(t *testing.T) {
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+	denied := awserr.New("AccessDenied", "not authorized to perform sts:GetCallerIdentity", nil)
+
+	testCases := []struct {
+		name            string
+		errs            []error
+		expectedAccount *string
+		expectedErr     error
+		expectedCalls   int
+	}{
+		{
+			name:            "looked up once",
+			expectedAccount: aws.String("123456789012"),
+			expectedCalls:   1,
+		},
+		{
+			name:            "throttled calls retried",
+			errs:            []error{throttled},
+			expectedAccount: aws.String("123456789012"),
+			expectedCalls:   2,
+		},
+		{
+			name:          "throttled calls retried up to the max attempts",
+			errs:          []error{throttled, throttled, throttled},
+			expectedErr:   throttled,
+			expectedCalls: 3,
+		},
+		{
+			name:          "failures not retried",
+			errs:          []error{denied},
+			expectedErr:   denied,
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &countingSTS{errs: tc.errs}
+			cache := &testSessionCache{sts: api}
+			accounts := newAccountIds()
+
+			// The result of the lookup is reused by the other jobs of the role
+			for i := 0; i < 2; i++ {
+				account, err := accounts.get(context.Background(), cache, config.Role{})
+				assert.Equal(t, tc.expectedAccount, account)
+				assert.Equal(t, tc.expectedErr, err)
+			}
+			assert.Equal(t, tc.expectedCalls, api.calls)
+		})
+	}
+}
+
+func TestAccountIdsCanceled(t *testing.T) {
+	api := &countingSTS{errs: []error{awserr.New("Throttling", "Rate exceeded", nil)}}
+	cache := &testSessionCache{sts: api}
+	accounts := newAccountIds()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := accounts.get(ctx, cache, config.Role{})
+	require.Error(t, err)
+
+	// The lookup cut short is done again
+	account, err := accounts.get(context.Background(), cache, config.Role{})
+	require.NoError(t, err)
+	assert.Equal(t, "123456789012", *account)
+	assert.Equal(t, 2, api.calls)
+}
+
+func TestScrapeAwsDataAccountIdPerRole(t *testing.T) {
+	roles := []config.Role{{RoleArn: "arn:aws:iam::123456789012:role/a"}, {RoleArn: "arn:aws:iam::123456789012:role/b"}}
+	cfg := config.ScrapeConf{
+		Static: []*config.Static{
+			{
+				Name:      "static",
+				Namespace: "AWS/EC2",
+				Regions:   []string{"us-east-1", "eu-west-1", "ap-southeast-2"},
+				Roles:     roles,
+			},
+		},
+		CustomNamespace: []*config.CustomNamespace{
+			{
+				Name:      "custom",
+				Namespace: "CustomEC2Metrics",
+				Regions:   []string{"us-east-1", "eu-west-1"},
+				Roles:     roles[:1],
+			},
+		},
+	}
+	api := &countingSTS{}
+	cache := &testSessionCache{sts: api}
+
+	_, _, jobMetrics := ScrapeAwsData(context.Background(), cfg, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, jobMetrics, 8)
+	// STS is called once per role instead of once per job and region
+	assert.Equal(t, 2, api.calls)
+}
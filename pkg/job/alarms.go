@@ -0,0 +1,159 @@
+package job
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// AlarmStateMetric is the name of the gauge reporting the state of the CloudWatch alarms selected by the alarms jobs.
+// Every alarm has one series per state, which is 1 for the current state of the alarm and 0 for the others.
+const AlarmStateMetric = "yace_cloudwatch_alarm_state"
+
+// AlarmsJobType is the job_type label of the alarms jobs in the metrics about the scrape
+const AlarmsJobType = "alarms"
+
+const (
+	alarmTypeMetric    = "metric"
+	alarmTypeComposite = "composite"
+)
+
+var alarmStates = []string{
+	cloudwatch.StateValueOk,
+	cloudwatch.StateValueAlarm,
+	cloudwatch.StateValueInsufficientData,
+}
+
+func scrapeAlarmsJob(ctx context.Context, job *config.Alarms, region string, accountId *string, accountAlias *string, clientCloudwatch cloudwatchInterface, cloudwatchSemaphore semaphore, logger logger.Logger) ([]*promutil.PrometheusMetric, error) {
+	if !cloudwatchSemaphore.acquire(ctx) {
+		return nil, ctx.Err()
+	}
+	metricAlarms, compositeAlarms, err := clientCloudwatch.describeAlarms(ctx, job.NamePrefix)
+	cloudwatchSemaphore.release()
+	if err != nil {
+		logger.Error(err, "Couldn't describe alarms")
+		return nil, err
+	}
+
+	var metrics []*promutil.PrometheusMetric
+	for _, alarm := range metricAlarms {
+		namespace := metricAlarmNamespace(alarm)
+		if !alarmNamespaceSelected(namespace, job.Namespaces) {
+			continue
+		}
+		labels := map[string]string{
+			"alarm_name":  aws.StringValue(alarm.AlarmName),
+			"alarm_type":  alarmTypeMetric,
+			"namespace":   namespace,
+			"metric_name": aws.StringValue(alarm.MetricName),
+			"region":      region,
+			"account_id":  aws.StringValue(accountId),
+		}
+		if accountAlias != nil {
+			labels["account_alias"] = *accountAlias
+		}
+		metrics = append(metrics, alarmStateMetrics(aws.StringValue(alarm.StateValue), labels)...)
+	}
+	for _, alarm := range compositeAlarms {
+		labels := map[string]string{
+			"alarm_name":  aws.StringValue(alarm.AlarmName),
+			"alarm_type":  alarmTypeComposite,
+			"namespace":   "",
+			"metric_name": "",
+			"region":      region,
+			"account_id":  aws.StringValue(accountId),
+		}
+		if accountAlias != nil {
+			labels["account_alias"] = *accountAlias
+		}
+		metrics = append(metrics, alarmStateMetrics(aws.StringValue(alarm.StateValue), labels)...)
+	}
+	logger.Debug("Described alarms", "metric_alarms", len(metricAlarms), "composite_alarms", len(compositeAlarms), "series", len(metrics))
+	return metrics, nil
+}
+
+// metricAlarmNamespace returns the namespace of the metric of alarm. Alarms on a metric math expression
+// have no metric of their own, the namespace of the first metric of the expression is used instead.
+func metricAlarmNamespace(alarm *cloudwatch.MetricAlarm) string {
+	if alarm.Namespace != nil {
+		return *alarm.Namespace
+	}
+	for _, query := range alarm.Metrics {
+		if query.MetricStat != nil && query.MetricStat.Metric != nil && query.MetricStat.Metric.Namespace != nil {
+			return *query.MetricStat.Metric.Namespace
+		}
+	}
+	return ""
+}
+
+// alarmNamespaceSelected returns whether an alarm on a metric of namespace is selected by namespaces, all the alarms when empty
+func alarmNamespaceSelected(namespace string, namespaces []string) bool {
+	if len(namespaces) == 0 {
+		return true
+	}
+	for _, n := range namespaces {
+		if strings.EqualFold(n, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// alarmStateMetrics returns a series per alarm state, 1 for state and 0 for the others
+func alarmStateMetrics(state string, labels map[string]string) []*promutil.PrometheusMetric {
+	metrics := make([]*promutil.PrometheusMetric, 0, len(alarmStates))
+	for _, s := range alarmStates {
+		stateLabels := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			stateLabels[k] = v
+		}
+		stateLabels["state"] = s
+
+		var value float64
+		if s == state {
+			value = 1
+		}
+		metrics = append(metrics, &promutil.PrometheusMetric{
+			Name:   aws.String(AlarmStateMetric),
+			Labels: stateLabels,
+			Value:  &value,
+		})
+	}
+	return metrics
+}
+
+func (iface cloudwatchInterface) describeAlarms(ctx context.Context, namePrefix string) ([]*cloudwatch.MetricAlarm, []*cloudwatch.CompositeAlarm, error) {
+	input := &cloudwatch.DescribeAlarmsInput{
+		AlarmTypes: aws.StringSlice([]string{cloudwatch.AlarmTypeMetricAlarm, cloudwatch.AlarmTypeCompositeAlarm}),
+	}
+	if namePrefix != "" {
+		input.AlarmNamePrefix = aws.String(namePrefix)
+	}
+
+	var metricAlarms []*cloudwatch.MetricAlarm
+	var compositeAlarms []*cloudwatch.CompositeAlarm
+	err := withRetry(ctx, iface.retry, func() error {
+		metricAlarms, compositeAlarms = nil, nil
+		err := iface.client.DescribeAlarmsPagesWithContext(ctx, input,
+			func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+				promutil.CloudwatchAPICounter.WithLabelValues("DescribeAlarms", iface.region).Inc()
+				metricAlarms = append(metricAlarms, page.MetricAlarms...)
+				compositeAlarms = append(compositeAlarms, page.CompositeAlarms...)
+				return !lastPage
+			})
+		if err != nil {
+			iface.countError("DescribeAlarms", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return metricAlarms, compositeAlarms, nil
+}
@@ -0,0 +1,106 @@
+package job
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+type describeAlarmsAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	input *cloudwatch.DescribeAlarmsInput
+}
+
+func (c *describeAlarmsAPI) DescribeAlarmsPagesWithContext(_ aws.Context, input *cloudwatch.DescribeAlarmsInput, fn func(*cloudwatch.DescribeAlarmsOutput, bool) bool, _ ...request.Option) error {
+	c.input = input
+	fn(&cloudwatch.DescribeAlarmsOutput{
+		MetricAlarms: []*cloudwatch.MetricAlarm{
+			{AlarmName: aws.String("prod-cpu"), Namespace: aws.String("AWS/EC2"), MetricName: aws.String("CPUUtilization"), StateValue: aws.String(cloudwatch.StateValueAlarm)},
+		},
+	}, false)
+	fn(&cloudwatch.DescribeAlarmsOutput{
+		MetricAlarms: []*cloudwatch.MetricAlarm{
+			{
+				AlarmName:  aws.String("prod-queue-age"),
+				StateValue: aws.String(cloudwatch.StateValueInsufficientData),
+				Metrics: []*cloudwatch.MetricDataQuery{
+					{Id: aws.String("e1"), Expression: aws.String("m1 * 2")},
+					{Id: aws.String("m1"), MetricStat: &cloudwatch.MetricStat{Metric: &cloudwatch.Metric{Namespace: aws.String("AWS/SQS"), MetricName: aws.String("ApproximateAgeOfOldestMessage")}}},
+				},
+			},
+		},
+		CompositeAlarms: []*cloudwatch.CompositeAlarm{
+			{AlarmName: aws.String("prod-service"), StateValue: aws.String(cloudwatch.StateValueOk)},
+		},
+	}, true)
+	return nil
+}
+
+func TestScrapeAlarmsJob(t *testing.T) {
+	testCases := []struct {
+		name           string
+		job            *config.Alarms
+		expectedPrefix *string
+		expectedStates map[string]string
+	}{
+		{
+			name: "all alarms",
+			job:  &config.Alarms{Name: "alarms"},
+			expectedStates: map[string]string{
+				"prod-cpu":       cloudwatch.StateValueAlarm,
+				"prod-queue-age": cloudwatch.StateValueInsufficientData,
+				"prod-service":   cloudwatch.StateValueOk,
+			},
+		},
+		{
+			name:           "alarms filtered by name prefix and namespace",
+			job:            &config.Alarms{Name: "alarms", NamePrefix: "prod-", Namespaces: []string{"AWS/SQS"}},
+			expectedPrefix: aws.String("prod-"),
+			expectedStates: map[string]string{
+				"prod-queue-age": cloudwatch.StateValueInsufficientData,
+				"prod-service":   cloudwatch.StateValueOk,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &describeAlarmsAPI{}
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			clientCloudwatch := cloudwatchInterface{client: api, region: "us-east-1", logger: l}
+
+			metrics, err := scrapeAlarmsJob(context.Background(), tc.job, "us-east-1", aws.String("123456789012"), nil, clientCloudwatch, semaphore{make(chan struct{}, 1)}, l)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedPrefix, api.input.AlarmNamePrefix)
+			require.Len(t, metrics, len(tc.expectedStates)*len(alarmStates))
+
+			states := map[string]string{}
+			for _, metric := range metrics {
+				assert.Equal(t, AlarmStateMetric, *metric.Name)
+				assert.Equal(t, "123456789012", metric.Labels["account_id"])
+				if *metric.Value == 1 {
+					states[metric.Labels["alarm_name"]] = metric.Labels["state"]
+				}
+				switch metric.Labels["alarm_name"] {
+				case "prod-queue-age":
+					assert.Equal(t, "AWS/SQS", metric.Labels["namespace"])
+					assert.Equal(t, alarmTypeMetric, metric.Labels["alarm_type"])
+				case "prod-service":
+					assert.Equal(t, "", metric.Labels["namespace"])
+					assert.Equal(t, alarmTypeComposite, metric.Labels["alarm_type"])
+				}
+			}
+			assert.Equal(t, tc.expectedStates, states)
+		})
+	}
+}
@@ -4,14 +4,16 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"math/rand"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 
@@ -26,9 +28,34 @@ var percentile = regexp.MustCompile(`^p(\d{1,2}(\.\d{0,2})?|100)$`)
 
 const timeFormat = "2006-01-02T15:04:05.999999-07:00"
 
+// expressionStatistic is the statistic of the cloudwatchData created for a metric math expression
+const expressionStatistic = "Expression"
+
+// Bounds of an anomaly detection band, used as suffix of their metric name
+const (
+	anomalyBandUpper = "upper"
+	anomalyBandLower = "lower"
+)
+
 type cloudwatchInterface struct {
 	client cloudwatchiface.CloudWatchAPI
-	logger logger.Logger
+	region string
+	retry  config.Retry
+	// listMetricsCache is nil when ListMetrics responses are not cached
+	listMetricsCache *listMetricsCache
+	logger           logger.Logger
+	// includeLinkedAccounts lists the metrics of the source accounts linked to a monitoring account too
+	includeLinkedAccounts bool
+	// recentlyActiveOnly only lists the metrics with data points in the last 3 hours
+	recentlyActiveOnly bool
+	// getMetricDataLimiter and listMetricsLimiter pace the requests to GetMetricData and ListMetrics,
+	// they are nil when the requests aren't rate limited
+	getMetricDataLimiter *rateLimiter
+	listMetricsLimiter   *rateLimiter
+	// getMetricDataBreaker and listMetricsBreaker stop the requests to GetMetricData and ListMetrics while
+	// they keep failing, they are nil without circuit breaker
+	getMetricDataBreaker *circuitBreaker
+	listMetricsBreaker   *circuitBreaker
 }
 
 type cloudwatchData struct {
@@ -40,22 +67,112 @@ type cloudwatchData struct {
 	Points                  []*cloudwatch.Datapoint
 	GetMetricDataPoint      *float64
 	GetMetricDataTimestamps *time.Time
+	GetMetricDataPoints     []dataPoint
 	NilToZero               *bool
 	AddCloudwatchTimestamp  *bool
+	ExportAllDataPoints     bool
+	PercentilesAsSummary    bool
+	PercentilesAsLabels     bool
+	SampleCountAsCount      bool
+	DropNoData              bool
 	CustomTags              []model.Tag
 	Tags                    []model.Tag
 	Dimensions              []*cloudwatch.Dimension
 	Region                  *string
 	AccountId               *string
+	AccountAlias            *string
 	Period                  int64
+	// AutoPeriod picks the period of the queries of the metric from the age of the GetMetricData window, see autoPeriod
+	AutoPeriod bool
+	// Delay is how long before the scrape the GetMetricData window of the metric ends, in seconds
+	Delay int64
+	// Role is the alias or ARN of the role the metric was scraped with, exported as the role label when set
+	Role *string
+	// MetricPrefix, MetricRenames and ExportedNamespace are the job settings applied to the exported metric name
+	MetricPrefix      string
+	MetricRenames     map[string]string
+	ExportedNamespace string
+	// Expression is set for metric math expressions, with the referenced
+	// metric ids replaced by the MetricID of the matching ExpressionInputs
+	Expression       *string
+	ExpressionInputs []cloudwatchData
+	// AnomalyBand is set for the ANOMALY_DETECTION_BAND expression of a metric. It returns two
+	// results with its MetricID, AnomalyBandBound tells them apart once they are known.
+	AnomalyBand      bool
+	AnomalyBandBound string
+	// Aggregated is set for the expression aggregating the series of a metric with Aggregate set
+	Aggregated bool
+	// ExportTimestamp exports the timestamp of the exported datapoint of each statistic as a _timestamp_seconds gauge
+	ExportTimestamp bool
+	// MissingDataValue is set for the metrics treating missing data as not breaching. It is
+	// exported for the series without datapoint whose resource is still discovered.
+	MissingDataValue *float64
+	// Unit is the unit the datapoints are restricted to, or for static jobs the unit of the
+	// returned datapoint when none was requested. ExportUnit exports it as a unit label.
+	Unit       *string
+	ExportUnit bool
+	// Label is the label template of the query. With LabelAs, the label returned by
+	// CloudWatch, ResultLabel, is exported as the LabelAs label.
+	Label       *string
+	LabelAs     string
+	ResultLabel string
+	// Transforms are the transforms of the exported datapoints by statistic, nil when there are none
+	Transforms map[string]*config.Transform
+	// LinkedAccount is set for the metrics of a source account listed by a monitoring account. They are
+	// queried from the monitoring account with their AccountId instead of assuming a role in their account.
+	LinkedAccount bool
+	// CarryForward is the maximum age of the last datapoint of a series exported instead of a missing datapoint
+	CarryForward time.Duration
+	// Counter exports the metric as a Prometheus counter with a _total suffix. With Accumulate, the counter
+	// adds up the datapoints of the series across scrapes instead of exporting the latest one.
+	Counter    bool
+	Accumulate bool
+	// DropDimensions are the dimensions not exported as labels. The series which only differ by them are merged.
+	DropDimensions []string
+	// Help is the configured description of the metric, the built-in one of the metric is exported when empty
+	Help string
+}
+
+// metricTransforms returns the transforms of the statistics of metric, nil when there are none
+func metricTransforms(metric *config.Metric, statistics []string) map[string]*config.Transform {
+	var transforms map[string]*config.Transform
+	for _, statistic := range statistics {
+		if transform := metric.TransformFor(statistic); transform != nil {
+			if transforms == nil {
+				transforms = make(map[string]*config.Transform, len(statistics))
+			}
+			transforms[statistic] = transform
+		}
+	}
+	return transforms
 }
 
-func createGetMetricStatisticsInput(dimensions []*cloudwatch.Dimension, namespace *string, metric *config.Metric, logger logger.Logger) (output *cloudwatch.GetMetricStatisticsInput) {
+// transformDatapoint returns a copy of value transformed by the transform of the statistic of c, if any.
+// value is shared with the other results of the query, it's never modified.
+func transformDatapoint(c *cloudwatchData, statistic string, value *float64) *float64 {
+	transform, ok := c.Transforms[statistic]
+	if !ok || value == nil {
+		return value
+	}
+	transformed := transform.Apply(*value)
+	return &transformed
+}
+
+// dataPoint is a single value returned by GetMetricData together with its timestamp
+type dataPoint struct {
+	Value     *float64
+	Timestamp time.Time
+}
+
+// createGetMetricStatisticsInput returns the GetMetricStatistics input of metric, requesting its
+// Period over the Length seconds ending Delay seconds before the time of clock
+func createGetMetricStatisticsInput(clock Clock, dimensions []*cloudwatch.Dimension, namespace *string, metric *config.Metric, logger logger.Logger) (output *cloudwatch.GetMetricStatisticsInput) {
 	period := metric.Period
 	length := metric.Length
 	delay := metric.Delay
-	endTime := time.Now().Add(-time.Duration(delay) * time.Second)
-	startTime := time.Now().Add(-(time.Duration(length) + time.Duration(delay)) * time.Second)
+	now := clock.Now()
+	endTime := now.Add(-time.Duration(delay) * time.Second)
+	startTime := now.Add(-(time.Duration(length) + time.Duration(delay)) * time.Second)
 
 	var statistics []*string
 	var extendedStatistics []*string
@@ -76,6 +193,7 @@ func createGetMetricStatisticsInput(dimensions []*cloudwatch.Dimension, namespac
 		MetricName:         &metric.Name,
 		Statistics:         statistics,
 		ExtendedStatistics: extendedStatistics,
+		Unit:               metric.RequestedUnit(),
 	}
 
 	logger.Debug("CLI helper - " +
@@ -92,62 +210,231 @@ func createGetMetricStatisticsInput(dimensions []*cloudwatch.Dimension, namespac
 	return output
 }
 
-func findGetMetricDataById(getMetricDatas []cloudwatchData, value string) (cloudwatchData, error) {
-	var g cloudwatchData
-	for _, getMetricData := range getMetricDatas {
-		if *getMetricData.MetricID == value {
-			return getMetricData, nil
+// datapointsUnit returns the unit of the most recent of points, nil when there is none
+func datapointsUnit(points []*cloudwatch.Datapoint) *string {
+	for _, datapoint := range sortByTimestamp(points) {
+		if datapoint.Unit != nil {
+			return datapoint.Unit
+		}
+	}
+	return nil
+}
+
+// indexGetMetricDatasById indexes the queries of a partition by MetricID, for the GetMetricData results
+// to be matched with their query in constant time. The first query with an id wins.
+func indexGetMetricDatasById(getMetricDatas []cloudwatchData) map[string]*cloudwatchData {
+	index := make(map[string]*cloudwatchData, len(getMetricDatas))
+	for i := range getMetricDatas {
+		if _, ok := index[*getMetricDatas[i].MetricID]; !ok {
+			index[*getMetricDatas[i].MetricID] = &getMetricDatas[i]
+		}
+	}
+	return index
+}
+
+// findGetMetricDataById returns a copy of the query with id value, as several results can have
+// the same id, e.g. the bounds of an anomaly band
+func findGetMetricDataById(index map[string]*cloudwatchData, value string) (cloudwatchData, error) {
+	getMetricData, ok := index[value]
+	if !ok {
+		return cloudwatchData{}, fmt.Errorf("metric with id %s not found", value)
+	}
+	return *getMetricData, nil
+}
+
+// setMetricDataResult copies the values of a GetMetricData result into getMetricData. Only the first
+// datapoint is kept, the most recent one with the default ScanBy, or all of them when ExportAllDataPoints
+// or Accumulate is enabled for the metric.
+func setMetricDataResult(getMetricData *cloudwatchData, result *cloudwatch.MetricDataResult) {
+	if getMetricData.LabelAs != "" {
+		getMetricData.ResultLabel = aws.StringValue(result.Label)
+	}
+	if len(result.Values) == 0 {
+		return
+	}
+	getMetricData.GetMetricDataPoint = result.Values[0]
+	getMetricData.GetMetricDataTimestamps = result.Timestamps[0]
+
+	if getMetricData.ExportAllDataPoints || getMetricData.Accumulate {
+		getMetricData.GetMetricDataPoints = make([]dataPoint, 0, len(result.Values))
+		for i, value := range result.Values {
+			getMetricData.GetMetricDataPoints = append(getMetricData.GetMetricDataPoints, dataPoint{
+				Value:     value,
+				Timestamp: *result.Timestamps[i],
+			})
+		}
+	}
+}
+
+// dropStaleDatapoints removes the datapoints of output older than maxAge at the time of clock, counting them
+// with the namespace and region. The results left without datapoint are removed, to be handled like missing
+// datapoints, the others export their first datapoint still fresh. Nothing is removed when maxAge is zero.
+func dropStaleDatapoints(clock Clock, output []*cloudwatchData, maxAge time.Duration, namespace string, region string) []*cloudwatchData {
+	if maxAge <= 0 {
+		return output
+	}
+	oldest := clock.Now().Add(-maxAge)
+
+	dropped := 0
+	fresh := make([]*cloudwatchData, 0, len(output))
+	for _, data := range output {
+		if data.GetMetricDataPoints == nil {
+			if data.GetMetricDataPoint != nil && data.GetMetricDataTimestamps.Before(oldest) {
+				dropped++
+				continue
+			}
+			fresh = append(fresh, data)
+			continue
+		}
+
+		points := make([]dataPoint, 0, len(data.GetMetricDataPoints))
+		for _, point := range data.GetMetricDataPoints {
+			if !point.Timestamp.Before(oldest) {
+				points = append(points, point)
+			}
+		}
+		dropped += len(data.GetMetricDataPoints) - len(points)
+		if len(points) == 0 {
+			continue
+		}
+		data.GetMetricDataPoints = points
+		data.GetMetricDataPoint = points[0].Value
+		data.GetMetricDataTimestamps = &points[0].Timestamp
+		fresh = append(fresh, data)
+	}
+
+	if dropped > 0 {
+		promutil.StaleDatapointsDroppedCounter.WithLabelValues(namespace, region).Add(float64(dropped))
+	}
+	return fresh
+}
+
+// fillMissingData exports the MissingDataValue of the series of input which returned no datapoint,
+// either as an empty result or no result at all, when their resource is still part of resources.
+// Those of resources which are gone are dropped. Series without MissingDataValue are left as is.
+func fillMissingData(input []cloudwatchData, output []*cloudwatchData, resources []*services.TaggedResource, timestamp time.Time) []*cloudwatchData {
+	existing := make(map[string]struct{}, len(resources))
+	for _, resource := range resources {
+		existing[resource.ARN] = struct{}{}
+	}
+	fill := func(data *cloudwatchData) bool {
+		if _, ok := existing[*data.ID]; !ok {
+			return false
+		}
+		value := *data.MissingDataValue
+		data.GetMetricDataPoint = &value
+		data.GetMetricDataTimestamps = &timestamp
+		// The configured value is exported as is
+		data.Transforms = nil
+		return true
+	}
+
+	filled := make([]*cloudwatchData, 0, len(output))
+	returned := make(map[string]struct{}, len(output))
+	for _, data := range output {
+		returned[*data.MetricID] = struct{}{}
+		if data.MissingDataValue != nil && data.GetMetricDataPoint == nil && !fill(data) {
+			continue
 		}
+		filled = append(filled, data)
 	}
-	return g, fmt.Errorf("metric with id %s not found", value)
+	for i := range input {
+		if input[i].MissingDataValue == nil {
+			continue
+		}
+		if _, ok := returned[*input[i].MetricID]; ok {
+			continue
+		}
+		data := input[i]
+		if fill(&data) {
+			filled = append(filled, &data)
+		}
+	}
+	return filled
 }
 
-func createGetMetricDataInput(getMetricData []cloudwatchData, namespace *string, length int64, delay int64, configuredRoundingPeriod *int64, logger logger.Logger) (output *cloudwatch.GetMetricDataInput) {
+// createGetMetricDataInput returns the GetMetricData query of getMetricData, whose window ends the Delay of
+// the metrics before the time of clock, all the metrics of a partition sharing it. The datapoints of every
+// result are ordered by scanBy, config.ScanByTimestampDescending when empty, and only the first one is
+// exported unless ExportAllDataPoints is enabled, i.e. the most recent datapoint of the window by default.
+// The metrics with AutoPeriod are queried with the period picked for the age of the start of the window.
+func createGetMetricDataInput(clock Clock, getMetricData []cloudwatchData, namespace *string, length int64, configuredRoundingPeriod *int64, alignToPeriod bool, scanBy string, logger logger.Logger) (output *cloudwatch.GetMetricDataInput) {
 	var metricsDataQuery []*cloudwatch.MetricDataQuery
-	roundingPeriod := model.DefaultPeriodSeconds
+	var shortestPeriod int64
+	var delay int64
+	if len(getMetricData) > 0 {
+		delay = getMetricData[0].Delay
+	}
+	// The window is rounded before the automatic periods are picked, with their estimate for an unrounded window
 	for _, data := range getMetricData {
-		if data.Period < roundingPeriod {
-			roundingPeriod = data.Period
+		queries := data.ExpressionInputs
+		if data.Expression == nil {
+			queries = []cloudwatchData{data}
 		}
-		metricStat := &cloudwatch.MetricStat{
-			Metric: &cloudwatch.Metric{
-				Dimensions: data.Dimensions,
-				MetricName: data.Metric,
-				Namespace:  namespace,
-			},
-			Period: &data.Period,
-			Stat:   &data.Statistics[0],
+		for _, query := range queries {
+			period := query.queryPeriod(length + delay)
+			if shortestPeriod == 0 || period < shortestPeriod {
+				shortestPeriod = period
+			}
 		}
-		ReturnData := true
-		metricsDataQuery = append(metricsDataQuery, &cloudwatch.MetricDataQuery{
-			Id:         data.MetricID,
-			MetricStat: metricStat,
-			ReturnData: &ReturnData,
-		})
 	}
 
-	if configuredRoundingPeriod != nil {
-		roundingPeriod = *configuredRoundingPeriod
-	}
+	roundingPeriod := getMetricDataRoundingPeriod(shortestPeriod, configuredRoundingPeriod, alignToPeriod)
 
 	startTime, endTime := determineGetMetricDataWindow(
-		TimeClock{},
+		clock,
 		time.Duration(roundingPeriod)*time.Second,
 		time.Duration(length)*time.Second,
-		time.Duration(delay)*time.Second)
+		time.Duration(delay)*time.Second,
+		alignToPeriod)
 	logger.Debug("GetMetricData Window", "start_time", startTime.Format(timeFormat), "end_time", endTime.Format(timeFormat))
 
-	dataPointOrder := "TimestampDescending"
+	age := int64(clock.Now().Sub(startTime) / time.Second)
+	for _, data := range getMetricData {
+		if data.Expression != nil {
+			// The metrics referenced by the expression are queried alongside it
+			// but only the result of the expression is returned
+			for _, input := range data.ExpressionInputs {
+				metricsDataQuery = append(metricsDataQuery, createMetricStatQuery(input, input.queryPeriod(age), namespace, false))
+			}
+			ReturnData := true
+			metricsDataQuery = append(metricsDataQuery, &cloudwatch.MetricDataQuery{
+				Id:         data.MetricID,
+				Expression: data.Expression,
+				Label:      labelOrDefault(data.Label, data.Metric),
+				ReturnData: &ReturnData,
+			})
+			continue
+		}
+		metricsDataQuery = append(metricsDataQuery, createMetricStatQuery(data, data.queryPeriod(age), namespace, true))
+	}
+
+	if scanBy == "" {
+		scanBy = config.ScanByTimestampDescending
+	}
 	output = &cloudwatch.GetMetricDataInput{
 		EndTime:           &endTime,
 		StartTime:         &startTime,
 		MetricDataQueries: metricsDataQuery,
-		ScanBy:            &dataPointOrder,
+		ScanBy:            aws.String(scanBy),
 	}
 
 	return output
 }
 
+// getMetricDataRoundingPeriod returns the period the GetMetricData window is rounded to: the configured
+// rounding period if any, else the shortest period of the queries, at most 5 minutes unless the window
+// is aligned to the period.
+func getMetricDataRoundingPeriod(shortestPeriod int64, configuredRoundingPeriod *int64, alignToPeriod bool) int64 {
+	if configuredRoundingPeriod != nil {
+		return *configuredRoundingPeriod
+	}
+	if shortestPeriod <= 0 || (!alignToPeriod && shortestPeriod > model.DefaultPeriodSeconds) {
+		return model.DefaultPeriodSeconds
+	}
+	return shortestPeriod
+}
+
 // Clock small interface which allows for stubbing the time.Now() function for unit testing
 type Clock interface {
 	Now() time.Time
@@ -156,6 +443,532 @@ type Clock interface {
 // TimeClock implementation of Clock interface which delegates to Go's Time package
 type TimeClock struct{}
 
+// createMetricStatQuery returns the query of the metric of data over period. Each query gets its own copy
+// of the period: metrics of the same partition can use different periods when they are overridden at metric level.
+func createMetricStatQuery(data cloudwatchData, period int64, namespace *string, returnData bool) *cloudwatch.MetricDataQuery {
+	var label *string
+	if returnData {
+		label = data.Label
+	}
+	var accountId *string
+	if data.LinkedAccount {
+		accountId = data.AccountId
+	}
+	return &cloudwatch.MetricDataQuery{
+		Id:        data.MetricID,
+		AccountId: accountId,
+		Label:     label,
+		MetricStat: &cloudwatch.MetricStat{
+			Metric: &cloudwatch.Metric{
+				Dimensions: data.Dimensions,
+				MetricName: data.Metric,
+				Namespace:  namespace,
+			},
+			Period: &period,
+			Stat:   &data.Statistics[0],
+			Unit:   data.Unit,
+		},
+		ReturnData: &returnData,
+	}
+}
+
+// labelOrDefault returns the label template of a query if any, else defaultLabel
+func labelOrDefault(label *string, defaultLabel *string) *string {
+	if label != nil {
+		return label
+	}
+	return defaultLabel
+}
+
+// resolvedLabel returns the label returned by CloudWatch for a query with a label template, or false
+// when CloudWatch couldn't resolve the template, e.g. for a property the metric doesn't have
+func resolvedLabel(label string) (string, bool) {
+	if label == "" || strings.Contains(label, "${") {
+		return "", false
+	}
+	return label, true
+}
+
+// queryCount returns the number of GetMetricData queries needed for data
+func (data cloudwatchData) queryCount() int {
+	return 1 + len(data.ExpressionInputs)
+}
+
+// MaxMetricsPerQuery is the maximum number of queries of a GetMetricData request
+const MaxMetricsPerQuery = 500
+
+// maxGetMetricDataDatapoints is the maximum number of datapoints a GetMetricData request can return
+const maxGetMetricDataDatapoints = 100800
+
+// datapointCount returns the number of datapoints the queries of data return at most over a window of length
+// seconds. A window which doesn't start on a period boundary covers one more period.
+func (data cloudwatchData) datapointCount(length int64) int {
+	count := func(period int64) int {
+		if period <= 0 || length <= 0 {
+			return 1
+		}
+		return int(length/period) + 1
+	}
+	datapoints := count(data.queryPeriod(length + data.Delay))
+	for _, input := range data.ExpressionInputs {
+		datapoints += count(input.queryPeriod(length + input.Delay))
+	}
+	return datapoints
+}
+
+// queryPeriod returns the period of the query of data over a window starting age seconds before the scrape:
+// its Period, or with AutoPeriod the one picked by autoPeriod
+func (data cloudwatchData) queryPeriod(age int64) int64 {
+	if data.AutoPeriod {
+		return autoPeriod(age)
+	}
+	return data.Period
+}
+
+// CloudWatch retains the datapoints of standard resolution metrics with a period of 60 seconds for 15 days,
+// of 5 minutes for 63 days and of 1 hour for 455 days
+const (
+	minuteRetentionSeconds     = 15 * 24 * 60 * 60
+	fiveMinuteRetentionSeconds = 63 * 24 * 60 * 60
+)
+
+// autoPeriod returns the finest period CloudWatch still retains the datapoints of a window starting age seconds
+// before the scrape at. High resolution periods, under 60 seconds, are never picked as they are only retained
+// for 3 hours and only by the high resolution metrics. Even at the finest period, a query over the whole
+// retention returns at most 21600 datapoints, well within the datapoints of a GetMetricData request.
+func autoPeriod(age int64) int64 {
+	switch {
+	case age <= minuteRetentionSeconds:
+		return 60
+	case age <= fiveMinuteRetentionSeconds:
+		return 5 * 60
+	default:
+		return 60 * 60
+	}
+}
+
+// ClampMetricsPerQuery returns metricsPerQuery, at most MaxMetricsPerQuery with a warning when it's higher.
+// Every statistic of a metric and every metric referenced by an expression is a query of its own.
+func ClampMetricsPerQuery(metricsPerQuery int, logger logger.Logger) (int, error) {
+	if metricsPerQuery < 1 {
+		return 0, fmt.Errorf("metrics per query should be a positive integer, got %d", metricsPerQuery)
+	}
+	if metricsPerQuery > MaxMetricsPerQuery {
+		logger.Warn("Metrics per query is higher than the GetMetricData limit, using the limit instead", "metrics_per_query", metricsPerQuery, "limit", MaxMetricsPerQuery)
+		return MaxMetricsPerQuery, nil
+	}
+	return metricsPerQuery, nil
+}
+
+// partitionGetMetricDatas splits getMetricDatas in batches of at most maxQueries GetMetricData
+// queries, MaxMetricsPerQuery at most, which return at most maxGetMetricDataDatapoints over a window
+// of length seconds. An expression is never split from the metrics it references. The queries of a
+// batch share its window, so metrics with different delays are batched separately. Both the batches
+// split because of the datapoints and because of the delays are logged.
+func partitionGetMetricDatas(getMetricDatas []cloudwatchData, maxQueries int, length int64, logger logger.Logger) [][]cloudwatchData {
+	if maxQueries > MaxMetricsPerQuery {
+		maxQueries = MaxMetricsPerQuery
+	}
+
+	var delays []int64
+	byDelay := make(map[int64][]cloudwatchData)
+	for _, data := range getMetricDatas {
+		if _, ok := byDelay[data.Delay]; !ok {
+			delays = append(delays, data.Delay)
+		}
+		byDelay[data.Delay] = append(byDelay[data.Delay], data)
+	}
+
+	var partitions [][]cloudwatchData
+	datapointSplits := 0
+	if len(delays) <= 1 {
+		partitions, datapointSplits = partitionQueries(getMetricDatas, maxQueries, length)
+	} else {
+		for _, delay := range delays {
+			delayPartitions, splits := partitionQueries(byDelay[delay], maxQueries, length)
+			partitions = append(partitions, delayPartitions...)
+			datapointSplits += splits
+		}
+		if ungrouped, _ := partitionQueries(getMetricDatas, maxQueries, length); len(partitions) > len(ungrouped) {
+			logger.Warn("Metrics with different delays are queried in separate GetMetricData requests", "delays", len(delays), "extra_requests", len(partitions)-len(ungrouped))
+		}
+	}
+	if datapointSplits > 0 {
+		logger.Info("Split GetMetricData requests to stay under the datapoints limit", "length", length, "limit", maxGetMetricDataDatapoints, "extra_requests", datapointSplits)
+	}
+	return partitions
+}
+
+// partitionQueries splits getMetricDatas in order into partitions of at most maxQueries queries and
+// maxGetMetricDataDatapoints datapoints. It also returns how many partitions were split because of the
+// datapoints only.
+func partitionQueries(getMetricDatas []cloudwatchData, maxQueries int, length int64) ([][]cloudwatchData, int) {
+	var partitions [][]cloudwatchData
+	start, queries, datapoints, datapointSplits := 0, 0, 0, 0
+	for i, data := range getMetricDatas {
+		tooManyQueries := queries+data.queryCount() > maxQueries
+		tooManyDatapoints := datapoints+data.datapointCount(length) > maxGetMetricDataDatapoints
+		if queries > 0 && (tooManyQueries || tooManyDatapoints) {
+			if !tooManyQueries {
+				datapointSplits++
+			}
+			partitions = append(partitions, getMetricDatas[start:i])
+			start, queries, datapoints = i, 0, 0
+		}
+		queries += data.queryCount()
+		datapoints += data.datapointCount(length)
+	}
+	if start < len(getMetricDatas) {
+		partitions = append(partitions, getMetricDatas[start:])
+	}
+	return partitions, datapointSplits
+}
+
+// dedupGetMetricDatas removes the queries of getMetricDatas for a series and statistic
+// already queried, which happens when metrics of a job overlap, keeping the first one found.
+// The CustomTags of the removed queries are merged into the kept one.
+func dedupGetMetricDatas(getMetricDatas []cloudwatchData, logger logger.Logger) []cloudwatchData {
+	output := make([]cloudwatchData, 0, len(getMetricDatas))
+	indexByKey := make(map[string]int, len(getMetricDatas))
+
+	for _, data := range getMetricDatas {
+		key := strings.Join([]string{
+			aws.StringValue(data.AccountId),
+			aws.StringValue(data.Namespace),
+			aws.StringValue(data.Metric),
+			dimensionsToKey(data.Dimensions),
+			strings.Join(data.Statistics, ","),
+			strconv.FormatInt(data.Period, 10),
+			aws.StringValue(data.Unit),
+			aws.StringValue(data.Label),
+		}, " ")
+
+		i, ok := indexByKey[key]
+		if !ok {
+			indexByKey[key] = len(output)
+			output = append(output, data)
+			continue
+		}
+		output[i].CustomTags = mergeTags(output[i].CustomTags, data.CustomTags)
+	}
+
+	if collapsed := len(getMetricDatas) - len(output); collapsed > 0 {
+		logger.Debug("Collapsed duplicate GetMetricData queries", "collapsed", collapsed, "queries", len(output))
+	}
+	return output
+}
+
+// mergeTags returns tags followed by the tags of others with a key not in tags.
+// tags is never modified, it is shared by all the queries of a job.
+func mergeTags(tags []model.Tag, others []model.Tag) []model.Tag {
+	keys := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		keys[tag.Key] = struct{}{}
+	}
+
+	var merged []model.Tag
+	for _, tag := range others {
+		if _, ok := keys[tag.Key]; ok {
+			continue
+		}
+		if merged == nil {
+			merged = append(merged, tags...)
+		}
+		keys[tag.Key] = struct{}{}
+		merged = append(merged, tag)
+	}
+	if merged == nil {
+		return tags
+	}
+	return merged
+}
+
+// maxAggregatedSeries is the maximum number of series aggregated by an expression, which is
+// queried alongside them in a single GetMetricData request
+const maxAggregatedSeries = MaxMetricsPerQuery - 1
+
+// aggregateMetricDatas replaces the series of getMetricDatas whose metric has Aggregate set
+// with a single expression per statistic, period and account, applying the metric math function
+// to all of them. The aggregate only keeps the dimensions and resource the series have in common.
+func aggregateMetricDatas(metrics []*config.Metric, getMetricDatas []cloudwatchData, ids *metricIDs, logger logger.Logger) []cloudwatchData {
+	functionByName := make(map[string]string)
+	for _, metric := range metrics {
+		if metric.Aggregate != "" {
+			functionByName[metric.Name] = metric.Aggregate
+		}
+	}
+	if len(functionByName) == 0 {
+		return getMetricDatas
+	}
+
+	// Group the series by aggregate, the aggregate replacing the first series of its group
+	output := make([]cloudwatchData, 0, len(getMetricDatas))
+	indexByKey := make(map[string]int)
+	for _, data := range getMetricDatas {
+		function, ok := functionByName[*data.Metric]
+		if !ok || data.Expression != nil {
+			output = append(output, data)
+			continue
+		}
+		key := strings.Join([]string{
+			aws.StringValue(data.AccountId),
+			aws.StringValue(data.Metric),
+			strings.Join(data.Statistics, ","),
+			strconv.FormatInt(data.Period, 10),
+			aws.StringValue(data.Unit),
+		}, " ")
+
+		input := data
+		queryId := ids.next()
+		input.MetricID = &queryId
+
+		i, ok := indexByKey[key]
+		if !ok {
+			id := ids.next()
+			aggregate := data
+			aggregate.MetricID = &id
+			aggregate.Expression = &function
+			aggregate.ExpressionInputs = []cloudwatchData{input}
+			aggregate.Aggregated = true
+			aggregate.PercentilesAsSummary = false
+			aggregate.Label = nil
+			aggregate.LabelAs = ""
+			aggregate.DropDimensions = nil
+			indexByKey[key] = len(output)
+			output = append(output, aggregate)
+			continue
+		}
+		aggregate := &output[i]
+		if len(aggregate.ExpressionInputs) == maxAggregatedSeries {
+			logger.Warn("Too many series to aggregate, dropping the next ones", "metric_name", *data.Metric, "limit", maxAggregatedSeries)
+			continue
+		}
+		aggregate.ExpressionInputs = append(aggregate.ExpressionInputs, input)
+		if aws.StringValue(aggregate.ID) != aws.StringValue(data.ID) {
+			aggregate.ID = aws.String("global")
+			aggregate.Tags = nil
+		}
+		aggregate.Dimensions = commonDimensions(aggregate.Dimensions, data.Dimensions)
+	}
+
+	for i := range output {
+		if !output[i].Aggregated {
+			continue
+		}
+		ids := make([]string, 0, len(output[i].ExpressionInputs))
+		for _, input := range output[i].ExpressionInputs {
+			ids = append(ids, *input.MetricID)
+		}
+		expression := fmt.Sprintf("%s([%s])", *output[i].Expression, strings.Join(ids, ", "))
+		output[i].Expression = &expression
+	}
+	return output
+}
+
+// commonDimensions returns the dimensions of dimensions with the same value in others
+func commonDimensions(dimensions []*cloudwatch.Dimension, others []*cloudwatch.Dimension) []*cloudwatch.Dimension {
+	values := make(map[string]string, len(others))
+	for _, dimension := range others {
+		values[*dimension.Name] = *dimension.Value
+	}
+	var common []*cloudwatch.Dimension
+	for _, dimension := range dimensions {
+		if value, ok := values[*dimension.Name]; ok && value == *dimension.Value {
+			common = append(common, dimension)
+		}
+	}
+	return common
+}
+
+// getAnomalyBandMetricDatas creates the cloudwatchData of the anomaly detection bands of
+// metrics, one ANOMALY_DETECTION_BAND expression per series of getMetricDatas whose metric
+// has AnomalyDetection set.
+func getAnomalyBandMetricDatas(metrics []*config.Metric, getMetricDatas []cloudwatchData, ids *metricIDs) []cloudwatchData {
+	var output []cloudwatchData
+
+	bandByName := make(map[string]float64)
+	for _, metric := range metrics {
+		if metric.AnomalyDetection != nil && metric.Expression == "" {
+			bandByName[metric.Name] = metric.AnomalyDetection.Band
+		}
+	}
+	if len(bandByName) == 0 {
+		return nil
+	}
+
+	for _, data := range getMetricDatas {
+		band, ok := bandByName[*data.Metric]
+		if !ok || data.Expression != nil {
+			continue
+		}
+
+		input := data
+		queryId := ids.next()
+		input.MetricID = &queryId
+
+		id := ids.next()
+		expression := fmt.Sprintf("ANOMALY_DETECTION_BAND(%s, %s)", queryId, strconv.FormatFloat(band, 'f', -1, 64))
+
+		bandData := data
+		bandData.MetricID = &id
+		// The bounds are exported as plain series, never as part of a summary
+		bandData.PercentilesAsSummary = false
+		bandData.Counter = false
+		bandData.Accumulate = false
+		bandData.MissingDataValue = nil
+		bandData.Label = nil
+		bandData.LabelAs = ""
+		bandData.Expression = &expression
+		bandData.ExpressionInputs = []cloudwatchData{input}
+		bandData.AnomalyBand = true
+		output = append(output, bandData)
+	}
+	return output
+}
+
+// setAnomalyBandBounds sets the bound of the results of the anomaly detection bands of output.
+// Both bounds are returned with the MetricID of the band, the upper one having the highest
+// values. Results which can't be told apart are dropped.
+func setAnomalyBandBounds(output []*cloudwatchData, logger logger.Logger) []*cloudwatchData {
+	bands := make(map[string][]*cloudwatchData)
+	for _, data := range output {
+		if data.AnomalyBand {
+			bands[*data.MetricID] = append(bands[*data.MetricID], data)
+		}
+	}
+	if len(bands) == 0 {
+		return output
+	}
+
+	for id, results := range bands {
+		if len(results) != 2 {
+			logger.Warn("Unexpected number of anomaly detection band results", "metric_name", *results[0].Metric, "id", id, "results", len(results))
+			continue
+		}
+		upper, lower := results[0], results[1]
+		if upper.GetMetricDataPoint != nil && lower.GetMetricDataPoint != nil && *upper.GetMetricDataPoint < *lower.GetMetricDataPoint {
+			upper, lower = lower, upper
+		}
+		upper.AnomalyBandBound = anomalyBandUpper
+		lower.AnomalyBandBound = anomalyBandLower
+	}
+
+	filtered := make([]*cloudwatchData, 0, len(output))
+	for _, data := range output {
+		if data.AnomalyBand && data.AnomalyBandBound == "" {
+			continue
+		}
+		filtered = append(filtered, data)
+	}
+	return filtered
+}
+
+// getExpressionMetricDatas creates the cloudwatchData of the metric math expressions
+// of metrics. An expression is evaluated once per resource and set of dimensions for
+// which all the metrics it references were found in getMetricDatas.
+func getExpressionMetricDatas(metrics []*config.Metric, getMetricDatas []cloudwatchData, ids *metricIDs) []cloudwatchData {
+	var output []cloudwatchData
+
+	metricNameById := make(map[string]string)
+	for _, metric := range metrics {
+		if metric.Id != "" {
+			metricNameById[metric.Id] = metric.Name
+		}
+	}
+
+	// Group the metrics by resource and dimensions, keeping the order they were found in
+	var keys []string
+	series := make(map[string]map[string]cloudwatchData)
+	for _, data := range getMetricDatas {
+		key := *data.ID + " " + aws.StringValue(data.AccountId) + " " + dimensionsToKey(data.Dimensions)
+		if _, ok := series[key]; !ok {
+			keys = append(keys, key)
+			series[key] = make(map[string]cloudwatchData)
+		}
+		if _, ok := series[key][*data.Metric]; !ok {
+			series[key][*data.Metric] = data
+		}
+	}
+
+	for _, metric := range metrics {
+		if metric.Expression == "" {
+			continue
+		}
+		metricIds := metric.ExpressionIds()
+	SERIES:
+		for _, key := range keys {
+			var inputs []cloudwatchData
+			queryIds := make(map[string]string, len(metricIds))
+			for _, metricId := range metricIds {
+				input, ok := series[key][metricNameById[metricId]]
+				if !ok {
+					continue SERIES
+				}
+				queryId := ids.next()
+				input.MetricID = &queryId
+				queryIds[metricId] = queryId
+				inputs = append(inputs, input)
+			}
+
+			id := ids.next()
+			expression := metric.ReplaceExpressionIds(queryIds)
+			period := inputs[0].Period
+			for _, input := range inputs {
+				if input.Period < period {
+					period = input.Period
+				}
+			}
+			output = append(output, cloudwatchData{
+				ID:                     inputs[0].ID,
+				MetricID:               &id,
+				Metric:                 &metric.Name,
+				Namespace:              inputs[0].Namespace,
+				Statistics:             []string{expressionStatistic},
+				NilToZero:              metric.NilToZero,
+				AddCloudwatchTimestamp: metric.AddCloudwatchTimestamp,
+				ExportTimestamp:        metric.ExportTimestamp,
+				ExportAllDataPoints:    metric.ExportAllDataPoints,
+				DropNoData:             metric.DropNoData,
+				MissingDataValue:       metric.NotBreachingValue(),
+				Label:                  metric.LabelTemplate(),
+				LabelAs:                metric.LabelAs,
+				Transforms:             metricTransforms(metric, []string{expressionStatistic}),
+				CarryForward:           metric.CarryForward,
+				Counter:                metric.IsCounter(),
+				Accumulate:             metric.Accumulate,
+				DropDimensions:         metric.DropDimensions,
+				Help:                   metric.Help,
+				MetricPrefix:           inputs[0].MetricPrefix,
+				MetricRenames:          inputs[0].MetricRenames,
+				ExportedNamespace:      inputs[0].ExportedNamespace,
+				Tags:                   inputs[0].Tags,
+				CustomTags:             inputs[0].CustomTags,
+				Dimensions:             inputs[0].Dimensions,
+				Region:                 inputs[0].Region,
+				AccountId:              inputs[0].AccountId,
+				AccountAlias:           inputs[0].AccountAlias,
+				LinkedAccount:          inputs[0].LinkedAccount,
+				Period:                 period,
+				AutoPeriod:             inputs[0].AutoPeriod,
+				Delay:                  metric.Delay,
+				Expression:             &expression,
+				ExpressionInputs:       inputs,
+			})
+		}
+	}
+	return output
+}
+
+// dimensionsToKey returns a representation of dimensions which doesn't depend on their order
+func dimensionsToKey(dimensions []*cloudwatch.Dimension) string {
+	pairs := make([]string, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		pairs = append(pairs, *dimension.Name+"="+*dimension.Value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
 func (tc TimeClock) Now() time.Time {
 	return time.Now()
 }
@@ -163,8 +976,18 @@ func (tc TimeClock) Now() time.Time {
 // determineGetMetricDataWindow computes the start and end time for the GetMetricData request to AWS
 // Always uses the wall clock time as starting point for calculations to ensure that
 // a variety of exporter configurations will work reliably.
-func determineGetMetricDataWindow(clock Clock, roundingPeriod time.Duration, length time.Duration, delay time.Duration) (time.Time, time.Time) {
+//
+// By default the current time is rounded down to roundingPeriod, then the window ends delay before it
+// and starts length before its end. With alignToPeriod, the end time (current time - delay) is rounded
+// down to roundingPeriod and length is rounded up to it instead, so both ends fall on its boundaries:
+// every scrape until the next boundary sends the same window, which CloudWatch can answer from its cache.
+func determineGetMetricDataWindow(clock Clock, roundingPeriod time.Duration, length time.Duration, delay time.Duration, alignToPeriod bool) (time.Time, time.Time) {
 	now := clock.Now()
+	if alignToPeriod && roundingPeriod > 0 {
+		endTime := now.Add(-delay).Truncate(roundingPeriod)
+		length = (length + roundingPeriod - 1) / roundingPeriod * roundingPeriod
+		return endTime.Add(-length), endTime
+	}
 	if roundingPeriod > 0 {
 		// Round down the time to a factor of the period - rounding is recommended by AWS:
 		// https://docs.aws.amazon.com/AmazonCloudWatch/latest/APIReference/API_GetMetricData.html#API_GetMetricData_RequestParameters
@@ -176,13 +999,13 @@ func determineGetMetricDataWindow(clock Clock, roundingPeriod time.Duration, len
 	return startTime, endTime
 }
 
+// createListMetricsInput filters the listed metrics on dimensions, a dimension without value only requires
+// the metrics to have a dimension with that name
 func createListMetricsInput(dimensions []*cloudwatch.Dimension, namespace *string, metricsName *string) (output *cloudwatch.ListMetricsInput) {
 	var dimensionsFilter []*cloudwatch.DimensionFilter
 
 	for _, dim := range dimensions {
-		if dim.Value != nil {
-			dimensionsFilter = append(dimensionsFilter, &cloudwatch.DimensionFilter{Name: dim.Name, Value: dim.Value})
-		}
+		dimensionsFilter = append(dimensionsFilter, &cloudwatch.DimensionFilter{Name: dim.Name, Value: dim.Value})
 	}
 	output = &cloudwatch.ListMetricsInput{
 		MetricName: metricsName,
@@ -200,7 +1023,7 @@ func dimensionsToCliString(dimensions []*cloudwatch.Dimension) (output string) {
 	return output
 }
 
-func (iface cloudwatchInterface) get(ctx context.Context, filter *cloudwatch.GetMetricStatisticsInput) []*cloudwatch.Datapoint {
+func (iface cloudwatchInterface) get(ctx context.Context, filter *cloudwatch.GetMetricStatisticsInput) ([]*cloudwatch.Datapoint, error) {
 	c := iface.client
 
 	iface.logger.Debug("GetMetricStatistics", "input", filter)
@@ -209,18 +1032,132 @@ func (iface cloudwatchInterface) get(ctx context.Context, filter *cloudwatch.Get
 
 	iface.logger.Debug("GetMetricStatistics", "output", resp)
 
-	promutil.CloudwatchAPICounter.Inc()
+	promutil.CloudwatchAPICounter.WithLabelValues("GetMetricStatistics", iface.region).Inc()
 	promutil.CloudwatchGetMetricStatisticsAPICounter.Inc()
 
 	if err != nil {
-		iface.logger.Error(err, "Failed to get metric statistics")
-		return nil
+		iface.countError("GetMetricStatistics", err)
+		return nil, err
+	}
+
+	return resp.Datapoints, nil
+}
+
+// getMetricData queries all the pages of filter. The results which aren't complete after their last page are
+// logged and counted, and the PartialData ones are queried once more when enabled by the retry settings.
+func (iface cloudwatchInterface) getMetricData(ctx context.Context, filter *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
+	resp, err := iface.queryMetricData(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	partialIds := iface.checkMetricDataResults(resp.MetricDataResults)
+	if len(partialIds) == 0 || !iface.retry.PartialData {
+		return resp, nil
+	}
+	retried, err := iface.queryMetricData(ctx, partialDataRetryInput(filter, partialIds))
+	if err != nil {
+		iface.logger.Warn("Failed to query the partial GetMetricData results again, keeping them as is", "err", err)
+		return resp, nil
+	}
+	iface.logger.Debug("Queried the partial GetMetricData results again", "results", len(partialIds))
+	resp.MetricDataResults = replaceMetricDataResults(resp.MetricDataResults, retried.MetricDataResults, partialIds)
+	return resp, nil
+}
+
+// checkMetricDataResults logs and counts the results which aren't Complete after their last page, as
+// the results of a query can be split across pages. It returns the ids of the PartialData ones.
+func (iface cloudwatchInterface) checkMetricDataResults(results []*cloudwatch.MetricDataResult) map[string]struct{} {
+	last := make(map[string]*cloudwatch.MetricDataResult, len(results))
+	for _, result := range results {
+		last[aws.StringValue(result.Id)] = result
 	}
 
-	return resp.Datapoints
+	partialIds := map[string]struct{}{}
+	for id, result := range last {
+		statusCode := aws.StringValue(result.StatusCode)
+		if statusCode == "" || statusCode == cloudwatch.StatusCodeComplete {
+			continue
+		}
+		messages := make([]string, 0, len(result.Messages))
+		for _, message := range result.Messages {
+			messages = append(messages, fmt.Sprintf("%s: %s", aws.StringValue(message.Code), aws.StringValue(message.Value)))
+		}
+		iface.logger.Warn("GetMetricData result is incomplete", "metric_id", id, "status_code", statusCode, "messages", strings.Join(messages, "; "))
+		promutil.MetricDataPartialCounter.WithLabelValues(statusCode, iface.region).Inc()
+		if statusCode == cloudwatch.StatusCodePartialData {
+			partialIds[id] = struct{}{}
+		}
+	}
+	return partialIds
 }
 
-func (iface cloudwatchInterface) getMetricData(ctx context.Context, filter *cloudwatch.GetMetricDataInput) *cloudwatch.GetMetricDataOutput {
+// partialDataRetryInput returns a copy of filter only querying partialIds, along with all the queries
+// whose data isn't returned, as the expressions among partialIds may reference them
+func partialDataRetryInput(filter *cloudwatch.GetMetricDataInput, partialIds map[string]struct{}) *cloudwatch.GetMetricDataInput {
+	retry := *filter
+	retry.NextToken = nil
+	retry.MetricDataQueries = nil
+	for _, query := range filter.MetricDataQueries {
+		_, partial := partialIds[aws.StringValue(query.Id)]
+		if partial || (query.ReturnData != nil && !*query.ReturnData) {
+			retry.MetricDataQueries = append(retry.MetricDataQueries, query)
+		}
+	}
+	return &retry
+}
+
+// replaceMetricDataResults replaces the results of partialIds in results by the ones of retried
+func replaceMetricDataResults(results []*cloudwatch.MetricDataResult, retried []*cloudwatch.MetricDataResult, partialIds map[string]struct{}) []*cloudwatch.MetricDataResult {
+	output := make([]*cloudwatch.MetricDataResult, 0, len(results))
+	for _, result := range results {
+		if _, partial := partialIds[aws.StringValue(result.Id)]; !partial {
+			output = append(output, result)
+		}
+	}
+	for _, result := range retried {
+		if _, partial := partialIds[aws.StringValue(result.Id)]; partial {
+			output = append(output, result)
+		}
+	}
+	return output
+}
+
+// mergeMetricDataResults merges the results sharing an Id, which CloudWatch splits across pages when a query
+// returns too many datapoints for a single page, appending their values and timestamps. The merged result keeps the
+// position of the first one, and the status code of the last one. The results of the pages aren't modified.
+func mergeMetricDataResults(results []*cloudwatch.MetricDataResult) []*cloudwatch.MetricDataResult {
+	output := make([]*cloudwatch.MetricDataResult, 0, len(results))
+	indexes := make(map[string]int, len(results))
+	merged := make(map[string]struct{})
+	for _, result := range results {
+		id := aws.StringValue(result.Id)
+		i, ok := indexes[id]
+		if !ok {
+			indexes[id] = len(output)
+			output = append(output, result)
+			continue
+		}
+		if _, ok := merged[id]; !ok {
+			// The first result is copied before appending to it
+			first := *output[i]
+			first.Values = append([]*float64(nil), first.Values...)
+			first.Timestamps = append([]*time.Time(nil), first.Timestamps...)
+			first.Messages = append([]*cloudwatch.MessageData(nil), first.Messages...)
+			output[i] = &first
+			merged[id] = struct{}{}
+		}
+		output[i].Values = append(output[i].Values, result.Values...)
+		output[i].Timestamps = append(output[i].Timestamps, result.Timestamps...)
+		output[i].Messages = append(output[i].Messages, result.Messages...)
+		output[i].StatusCode = result.StatusCode
+	}
+	return output
+}
+
+// queryMetricData queries all the pages of filter, retrying according to the retry settings. The results of a
+// query split across pages are merged.
+func (iface cloudwatchInterface) queryMetricData(ctx context.Context, filter *cloudwatch.GetMetricDataInput) (*cloudwatch.GetMetricDataOutput, error) {
 	c := iface.client
 
 	var resp cloudwatch.GetMetricDataOutput
@@ -229,24 +1166,39 @@ func (iface cloudwatchInterface) getMetricData(ctx context.Context, filter *clou
 		iface.logger.Debug("GetMetricData", "input", filter)
 	}
 
-	// Using the paged version of the function
-	err := c.GetMetricDataPagesWithContext(ctx, filter,
-		func(page *cloudwatch.GetMetricDataOutput, lastPage bool) bool {
-			promutil.CloudwatchAPICounter.Inc()
-			promutil.CloudwatchGetMetricDataAPICounter.Inc()
-			resp.MetricDataResults = append(resp.MetricDataResults, page.MetricDataResults...)
-			return !lastPage
-		})
+	err := withRetry(ctx, iface.retry, func() error {
+		resp.MetricDataResults = nil
+		// Using the paged version of the function
+		err := c.GetMetricDataPagesWithContext(ctx, filter,
+			func(page *cloudwatch.GetMetricDataOutput, lastPage bool) bool {
+				promutil.CloudwatchAPICounter.WithLabelValues("GetMetricData", iface.region).Inc()
+				promutil.CloudwatchGetMetricDataAPICounter.Inc()
+				resp.MetricDataResults = append(resp.MetricDataResults, page.MetricDataResults...)
+				return !lastPage
+			}, append(iface.getMetricDataLimiter.requestOptions(), iface.getMetricDataBreaker.requestOptions()...)...)
+		if err != nil {
+			iface.countError("GetMetricData", err)
+		}
+		return err
+	})
 
 	if iface.logger.IsDebugEnabled() {
 		iface.logger.Debug("GetMetricData", "output", resp)
 	}
 
 	if err != nil {
-		iface.logger.Error(err, "Failed to get metric data")
-		return nil
+		return nil, err
+	}
+	resp.MetricDataResults = mergeMetricDataResults(resp.MetricDataResults)
+	return &resp, nil
+}
+
+// countError counts a failed call to api, throttling errors are counted separately as well
+func (iface cloudwatchInterface) countError(api string, err error) {
+	promutil.CloudwatchAPIErrorCounter.Inc()
+	if request.IsErrorThrottle(err) {
+		promutil.CloudwatchAPIThrottleCounter.WithLabelValues(api, iface.region).Inc()
 	}
-	return &resp
 }
 
 func createStaticDimensions(dimensions []config.Dimension) (output []*cloudwatch.Dimension) {
@@ -261,153 +1213,458 @@ func createStaticDimensions(dimensions []config.Dimension) (output []*cloudwatch
 	return output
 }
 
-func getFullMetricsList(ctx context.Context, namespace string, metric *config.Metric, clientCloudwatch cloudwatchInterface) (resp *cloudwatch.ListMetricsOutput, err error) {
+// mergeStaticDimensions returns dimensions with the static dimensions it doesn't have yet. On a name collision
+// the discovered dimension is kept. dimensions isn't modified, it may be shared with the cached ListMetrics responses.
+func mergeStaticDimensions(dimensions []*cloudwatch.Dimension, static []*cloudwatch.Dimension) []*cloudwatch.Dimension {
+	if len(static) == 0 {
+		return dimensions
+	}
+	merged := make([]*cloudwatch.Dimension, len(dimensions), len(dimensions)+len(static))
+	copy(merged, dimensions)
+	for _, s := range static {
+		found := false
+		for _, d := range dimensions {
+			if aws.StringValue(d.Name) == aws.StringValue(s.Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, s)
+		}
+	}
+	return merged
+}
+
+func getFullMetricsList(ctx context.Context, namespace string, metric *config.Metric, dimensions []*cloudwatch.Dimension, clientCloudwatch cloudwatchInterface) (resp *cloudwatch.ListMetricsOutput, err error) {
 	c := clientCloudwatch.client
-	filter := createListMetricsInput(nil, &namespace, &metric.Name)
+	// Metrics selected with a NameRegex are matched against every metric of the namespace
+	metricName := &metric.Name
+	if metric.NameRegex != "" {
+		metricName = nil
+	}
+	filter := createListMetricsInput(dimensions, &namespace, metricName)
+	if clientCloudwatch.includeLinkedAccounts {
+		filter.IncludeLinkedAccounts = aws.Bool(true)
+	}
+	if clientCloudwatch.recentlyActiveOnly {
+		filter.RecentlyActive = aws.String(cloudwatch.RecentlyActivePt3h)
+	}
+	if clientCloudwatch.listMetricsCache != nil {
+		if cached, ok := clientCloudwatch.listMetricsCache.get(filter); ok {
+			promutil.ListMetricsCacheHitCounter.Inc()
+			return cached, nil
+		}
+	}
 	var res cloudwatch.ListMetricsOutput
-	err = c.ListMetricsPagesWithContext(ctx, filter,
-		func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
-			res.Metrics = append(res.Metrics, page.Metrics...)
-			return !lastPage
-		})
+	err = withRetry(ctx, clientCloudwatch.retry, func() error {
+		res.Metrics, res.OwningAccounts = nil, nil
+		err := c.ListMetricsPagesWithContext(ctx, filter,
+			func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+				promutil.CloudwatchAPICounter.WithLabelValues("ListMetrics", clientCloudwatch.region).Inc()
+				res.Metrics = append(res.Metrics, page.Metrics...)
+				// OwningAccounts is only returned along with the metrics of linked accounts
+				res.OwningAccounts = append(res.OwningAccounts, page.OwningAccounts...)
+				return !lastPage
+			}, append(clientCloudwatch.listMetricsLimiter.requestOptions(), clientCloudwatch.listMetricsBreaker.requestOptions()...)...)
+		if err != nil {
+			clientCloudwatch.countError("ListMetrics", err)
+		}
+		return err
+	})
 	if err != nil {
-		promutil.CloudwatchAPIErrorCounter.Inc()
 		return nil, err
 	}
-	promutil.CloudwatchAPICounter.Inc()
+	if clientCloudwatch.listMetricsCache != nil {
+		clientCloudwatch.listMetricsCache.set(filter, &res)
+	}
 	return &res, nil
 }
 
-func getFilteredMetricDatas(region string, accountId *string, namespace string, customTags []model.Tag, tagsOnMetrics config.ExportedTagsOnMetrics, dimensionRegexps []*string, resources []*services.TaggedResource, metricsList []*cloudwatch.Metric, dimensionNameList []string, m *config.Metric) (getMetricsData []cloudwatchData) {
-	type filterValues map[string]*services.TaggedResource
+// expandMetricNameRegexes replaces the metrics selected with a NameRegex by a copy of them for each matching
+// metric name found in their metrics list, along with the part of the list of that name. Names of metrics
+// listed explicitly, or already matched by a previous NameRegex, are skipped so that they are scraped once.
+// At most GetMaxNameRegexMatches names are kept per NameRegex, in alphabetical order.
+func expandMetricNameRegexes(metrics []*config.Metric, metricsLists []*cloudwatch.ListMetricsOutput, logger logger.Logger) ([]*config.Metric, []*cloudwatch.ListMetricsOutput) {
+	seen := make(map[string]struct{})
+	for _, metric := range metrics {
+		if metric.NameRegex == "" {
+			seen[metric.Name] = struct{}{}
+		}
+	}
+
+	var expandedMetrics []*config.Metric
+	var expandedLists []*cloudwatch.ListMetricsOutput
+	for i, metric := range metrics {
+		if metric.NameRegex == "" {
+			expandedMetrics = append(expandedMetrics, metric)
+			expandedLists = append(expandedLists, metricsLists[i])
+			continue
+		}
+		if metricsLists[i] == nil {
+			continue
+		}
+
+		nameRegex := regexp.MustCompile(metric.NameRegex)
+		byName := make(map[string]*cloudwatch.ListMetricsOutput)
+		for j, cwMetric := range metricsLists[i].Metrics {
+			name := aws.StringValue(cwMetric.MetricName)
+			if _, ok := seen[name]; ok || !nameRegex.MatchString(name) {
+				continue
+			}
+			list, ok := byName[name]
+			if !ok {
+				list = &cloudwatch.ListMetricsOutput{}
+				byName[name] = list
+			}
+			list.Metrics = append(list.Metrics, cwMetric)
+			if owningAccount := listedOwningAccount(metricsLists[i], j); owningAccount != nil {
+				list.OwningAccounts = append(list.OwningAccounts, owningAccount)
+			}
+		}
+
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if maxMatches := metric.GetMaxNameRegexMatches(); len(names) > maxMatches {
+			logger.Warn("Too many metrics match the name regex, only scraping the first ones", "name_regex", metric.NameRegex, "matches", len(names), "max", maxMatches)
+			names = names[:maxMatches]
+		}
+
+		for _, name := range names {
+			seen[name] = struct{}{}
+			expanded := *metric
+			expanded.Name = name
+			expanded.NameRegex = ""
+			expandedMetrics = append(expandedMetrics, &expanded)
+			expandedLists = append(expandedLists, byName[name])
+		}
+	}
+	return expandedMetrics, expandedLists
+}
+
+// listedOwningAccount returns the account owning the i-th metric of list, nil unless the metrics of linked
+// accounts were listed
+func listedOwningAccount(list *cloudwatch.ListMetricsOutput, i int) *string {
+	if len(list.OwningAccounts) != len(list.Metrics) {
+		return nil
+	}
+	return list.OwningAccounts[i]
+}
+
+// dimensionRegexp is a compiled dimension regexp of a service with the dimension names of its groups,
+// the group names with "_" replaced by " ". The group of index 0, the whole match, has no dimension.
+type dimensionRegexp struct {
+	regexp     *regexp.Regexp
+	dimensions []string
+}
+
+// dimensionRegexps caches the compiled dimension regexps of the services, which never change, by expression
+var dimensionRegexps sync.Map
+
+// compileDimensionRegexp returns the compiled dimension regexp of expr, compiling it on first use only
+func compileDimensionRegexp(expr string) *dimensionRegexp {
+	if cached, ok := dimensionRegexps.Load(expr); ok {
+		return cached.(*dimensionRegexp)
+	}
+	compiled := regexp.MustCompile(expr)
+	dimensions := make([]string, 0, compiled.NumSubexp()+1)
+	for _, name := range compiled.SubexpNames() {
+		dimensions = append(dimensions, strings.ReplaceAll(name, "_", " "))
+	}
+	cached, _ := dimensionRegexps.LoadOrStore(expr, &dimensionRegexp{regexp: compiled, dimensions: dimensions})
+	return cached.(*dimensionRegexp)
+}
+
+// metricIDs generates the ids of the GetMetricData queries of a job: id_1, id_2 and so on, in the order the queries
+// are created. They are unique within the job, whose queries are sent in requests of their own, and the same from
+// one scrape to the next.
+type metricIDs struct {
+	last uint64
+}
+
+// next returns the id of the next query
+func (g *metricIDs) next() string {
+	return "id_" + strconv.FormatUint(atomic.AddUint64(&g.last, 1), 10)
+}
+
+// filterValues are the discovered resources by value of a dimension
+type filterValues map[string]*services.TaggedResource
+
+// priorityResource returns the resource of the first dimension of priority of metric with a discovered
+// resource in dimensionsFilter, global when there is none. It also returns whether to skip the metric,
+// when it has dimensions identifying resources but none of them is discovered.
+func priorityResource(metric *cloudwatch.Metric, dimensionsFilter map[string]filterValues, priority []string, global *services.TaggedResource) (*services.TaggedResource, bool) {
+	identified := false
+	for _, name := range priority {
+		values, ok := dimensionsFilter[name]
+		if !ok {
+			continue
+		}
+		for _, dimension := range metric.Dimensions {
+			if *dimension.Name != name {
+				continue
+			}
+			if r, ok := values[*dimension.Value]; ok {
+				return r, false
+			}
+			identified = true
+		}
+	}
+	return global, identified
+}
+
+// getFilteredMetricDatas returns the queries of the statistics of m for the series of metricsList meeting the
+// dimension requirements, each with the resource of resources its dimensions match with the dimension
+// regexps of the service, by dimensionPriority when set. The settings of m, the same for all the series,
+// are shared by the queries.
+func getFilteredMetricDatas(region string, accountId *string, namespace string, customTags []model.Tag, tagsOnMetrics config.ExportedTagsOnMetrics, dimensionRegexps []*string, dimensionPriority []string, resources []*services.TaggedResource, metricsList []*cloudwatch.Metric, dimensionNameRequirements config.DimensionNameRequirements, dimensionValueRequirements []config.DimensionValueRequirement, m *config.Metric, ids *metricIDs) []cloudwatchData {
+	valueMatchers := newDimensionValueMatchers(dimensionValueRequirements)
 	dimensionsFilter := make(map[string]filterValues)
 	for _, dr := range dimensionRegexps {
-		dimensionRegexp := regexp.MustCompile(*dr)
-		names := dimensionRegexp.SubexpNames()
-		for i, dimensionName := range names {
-			if i != 0 {
-				names[i] = strings.ReplaceAll(dimensionName, "_", " ")
-				if _, ok := dimensionsFilter[names[i]]; !ok {
-					dimensionsFilter[names[i]] = make(filterValues)
-				}
+		compiled := compileDimensionRegexp(*dr)
+		for _, dimensionName := range compiled.dimensions[1:] {
+			if _, ok := dimensionsFilter[dimensionName]; !ok {
+				dimensionsFilter[dimensionName] = make(filterValues, len(resources))
 			}
 		}
 		for _, r := range resources {
-			if dimensionRegexp.Match([]byte(r.ARN)) {
-				dimensionMatch := dimensionRegexp.FindStringSubmatch(r.ARN)
-				for i, value := range dimensionMatch {
-					if i != 0 {
-						dimensionsFilter[names[i]][value] = r
-					}
-				}
+			dimensionMatch := compiled.regexp.FindStringSubmatch(r.ARN)
+			for i := 1; i < len(dimensionMatch); i++ {
+				dimensionsFilter[compiled.dimensions[i]][dimensionMatch[i]] = r
 			}
 		}
 	}
+
+	// The settings of the statistics are computed once for all the series
+	statistics := make([][]string, len(m.Statistics))
+	transforms := make([]map[string]*config.Transform, len(m.Statistics))
+	for i, stats := range m.Statistics {
+		statistics[i] = []string{stats}
+		transforms[i] = metricTransforms(m, statistics[i])
+	}
+	missingDataValue := m.NotBreachingValue()
+	unit := m.RequestedUnit()
+	label := m.LabelTemplate()
+	isCounter := m.IsCounter()
+
+	global := &services.TaggedResource{
+		ARN:       "global",
+		Namespace: namespace,
+	}
+	tagsByResource := make(map[*services.TaggedResource][]model.Tag)
+
+	getMetricsData := make([]cloudwatchData, 0, len(metricsList)*len(m.Statistics))
 	for _, cwMetric := range metricsList {
 		skip := false
 		alreadyFound := false
-		r := &services.TaggedResource{
-			ARN:       "global",
-			Namespace: namespace,
+		r := global
+		if !metricDimensionsMatchNames(cwMetric, dimensionNameRequirements) {
+			continue
 		}
-		if len(dimensionNameList) > 0 && !metricDimensionsMatchNames(cwMetric, dimensionNameList) {
+		if !metricDimensionsMatchValues(cwMetric, valueMatchers) {
 			continue
 		}
 
-		for _, dimension := range cwMetric.Dimensions {
-			if dimensionFilterValues, ok := dimensionsFilter[*dimension.Name]; ok {
-				if d, ok := dimensionFilterValues[*dimension.Value]; !ok {
-					if !alreadyFound {
-						skip = true
+		if len(dimensionPriority) > 0 {
+			r, skip = priorityResource(cwMetric, dimensionsFilter, dimensionPriority, global)
+		} else {
+			for _, dimension := range cwMetric.Dimensions {
+				if dimensionFilterValues, ok := dimensionsFilter[*dimension.Name]; ok {
+					if d, ok := dimensionFilterValues[*dimension.Value]; !ok {
+						if !alreadyFound {
+							skip = true
+						}
+						break
+					} else {
+						alreadyFound = true
+						r = d
 					}
-					break
-				} else {
-					alreadyFound = true
-					r = d
 				}
 			}
 		}
 
-		if !skip {
-			for _, stats := range m.Statistics {
-				id := fmt.Sprintf("id_%d", rand.Int())
-				metricTags := r.MetricTags(tagsOnMetrics)
-				getMetricsData = append(getMetricsData, cloudwatchData{
-					ID:                     &r.ARN,
-					MetricID:               &id,
-					Metric:                 &m.Name,
-					Namespace:              &namespace,
-					Statistics:             []string{stats},
-					NilToZero:              m.NilToZero,
-					AddCloudwatchTimestamp: m.AddCloudwatchTimestamp,
-					Tags:                   metricTags,
-					CustomTags:             customTags,
-					Dimensions:             cwMetric.Dimensions,
-					Region:                 &region,
-					AccountId:              accountId,
-					Period:                 int64(m.Period),
-				})
-			}
+		// The series of the metric are queried even when they don't match any resource
+		if skip && len(m.Series) == 0 {
+			continue
+		}
+		metricTags, ok := tagsByResource[r]
+		if !ok {
+			metricTags = r.MetricTags(tagsOnMetrics)
+			tagsByResource[r] = metricTags
+		}
+		for i, stats := range m.Statistics {
+			getMetricsData = append(getMetricsData, cloudwatchData{
+				ID:                     &r.ARN,
+				MetricID:               aws.String(ids.next()),
+				Metric:                 &m.Name,
+				Namespace:              &namespace,
+				Statistics:             statistics[i],
+				NilToZero:              m.NilToZeroFor(stats),
+				AddCloudwatchTimestamp: m.AddCloudwatchTimestampFor(stats),
+				ExportTimestamp:        m.ExportTimestamp,
+				ExportAllDataPoints:    m.ExportAllDataPoints,
+				PercentilesAsSummary:   m.PercentilesAsSummary,
+				PercentilesAsLabels:    m.PercentilesAsLabels,
+				SampleCountAsCount:     m.SampleCountAsCount,
+				DropNoData:             m.DropNoData,
+				MissingDataValue:       missingDataValue,
+				Unit:                   unit,
+				ExportUnit:             m.ExportUnit,
+				Label:                  label,
+				LabelAs:                m.LabelAs,
+				Transforms:             transforms[i],
+				CarryForward:           m.CarryForward,
+				Counter:                isCounter,
+				Accumulate:             m.Accumulate,
+				DropDimensions:         m.DropDimensions,
+				Help:                   m.Help,
+				Tags:                   metricTags,
+				CustomTags:             customTags,
+				Dimensions:             cwMetric.Dimensions,
+				Region:                 &region,
+				AccountId:              accountId,
+				Period:                 int64(m.Period),
+				AutoPeriod:             m.AutoPeriod,
+				Delay:                  m.Delay,
+			})
 		}
 	}
 	return getMetricsData
 }
 
-func metricDimensionsMatchNames(metric *cloudwatch.Metric, dimensionNameRequirements []string) bool {
-	if len(dimensionNameRequirements) != len(metric.Dimensions) {
-		return false
+// metricDimensionsMatchNames returns whether the names of the dimensions of metric meet dimensionNameRequirements
+func metricDimensionsMatchNames(metric *cloudwatch.Metric, dimensionNameRequirements config.DimensionNameRequirements) bool {
+	if dimensionNameRequirements.IsEmpty() {
+		return true
 	}
+	names := make([]string, 0, len(metric.Dimensions))
 	for _, dimension := range metric.Dimensions {
-		foundMatch := false
-		for _, dimensionName := range dimensionNameRequirements {
-			if *dimension.Name == dimensionName {
-				foundMatch = true
+		names = append(names, *dimension.Name)
+	}
+	return dimensionNameRequirements.Matches(names)
+}
+
+// dimensionValueMatcher is a compiled config.DimensionValueRequirement
+type dimensionValueMatcher struct {
+	name       string
+	valueRegex *regexp.Regexp
+}
+
+func newDimensionValueMatchers(requirements []config.DimensionValueRequirement) []dimensionValueMatcher {
+	matchers := make([]dimensionValueMatcher, 0, len(requirements))
+	for _, requirement := range requirements {
+		matchers = append(matchers, dimensionValueMatcher{
+			name:       requirement.Name,
+			valueRegex: regexp.MustCompile(requirement.ValueRegex),
+		})
+	}
+	return matchers
+}
+
+// metricDimensionsMatchValues returns whether metric has, for every matcher, the dimension of the
+// matcher with a matching value. Metrics without the dimension don't match.
+func metricDimensionsMatchValues(metric *cloudwatch.Metric, matchers []dimensionValueMatcher) bool {
+	for _, matcher := range matchers {
+		found := false
+		for _, dimension := range metric.Dimensions {
+			if aws.StringValue(dimension.Name) == matcher.name {
+				found = matcher.valueRegex.MatchString(aws.StringValue(dimension.Value))
 				break
 			}
 		}
-		if !foundMatch {
+		if !found {
 			return false
 		}
 	}
 	return true
 }
 
-func createPrometheusLabels(cwd *cloudwatchData, labelsSnakeCase bool, logger logger.Logger) map[string]string {
+// sanitizeLabelValue returns the value of a label sanitized according to the policy, false when the label is dropped
+func sanitizeLabelValue(value string, labelSanitization config.LabelSanitization) (string, bool) {
+	return promutil.SanitizeLabelValue(value, labelSanitization.MaxLength, labelSanitization.Policy == config.LabelSanitizationDrop)
+}
+
+func createPrometheusLabels(cwd *cloudwatchData, labelsSnakeCase bool, labelSanitization config.LabelSanitization, logger logger.Logger) map[string]string {
 	labels := make(map[string]string)
 	labels["name"] = *cwd.ID
 	labels["region"] = *cwd.Region
 	labels["account_id"] = *cwd.AccountId
+	if cwd.AccountAlias != nil {
+		labels["account_alias"] = *cwd.AccountAlias
+	}
+	if cwd.Role != nil {
+		labels["role"] = *cwd.Role
+	}
 
 	// Inject the sfn name back as a label
 	for _, dimension := range cwd.Dimensions {
+		if isDroppedDimension(cwd, *dimension.Name) {
+			continue
+		}
 		ok, promTag := promutil.PromStringTag(*dimension.Name, labelsSnakeCase)
 		if !ok {
 			logger.Warn("dimension name is an invalid prometheus label name", "dimension", *dimension.Name)
+			promutil.LabelsDroppedCounter.WithLabelValues(promutil.LabelReasonInvalidName).Inc()
 			continue
 		}
-		labels["dimension_"+promTag] = *dimension.Value
+		if value, ok := sanitizeLabelValue(*dimension.Value, labelSanitization); ok {
+			labels["dimension_"+promTag] = value
+		}
+	}
+
+	// Series of a metric without a known unit get an empty unit label, like any other missing label
+	if cwd.ExportUnit && cwd.Unit != nil && *cwd.Unit != "" {
+		labels["unit"] = *cwd.Unit
+	}
+	if cwd.LabelAs != "" {
+		if label, ok := resolvedLabel(cwd.ResultLabel); ok {
+			if value, ok := sanitizeLabelValue(label, labelSanitization); ok {
+				labels[cwd.LabelAs] = value
+			}
+		} else {
+			logger.Debug("Label of the result doesn't match its template, not exported", "metric", *cwd.Metric, "label", cwd.ResultLabel)
+		}
 	}
 
 	for _, label := range cwd.CustomTags {
 		ok, promTag := promutil.PromStringTag(label.Key, labelsSnakeCase)
 		if !ok {
 			logger.Warn("custom tag name is an invalid prometheus label name", "tag", label.Key)
+			promutil.LabelsDroppedCounter.WithLabelValues(promutil.LabelReasonInvalidName).Inc()
 			continue
 		}
-		labels["custom_tag_"+promTag] = label.Value
+		if value, ok := sanitizeLabelValue(label.Value, labelSanitization); ok {
+			labels["custom_tag_"+promTag] = value
+		}
 	}
 	for _, tag := range cwd.Tags {
 		ok, promTag := promutil.PromStringTag(tag.Key, labelsSnakeCase)
 		if !ok {
 			logger.Warn("metric tag name is an invalid prometheus label name", "tag", tag.Key)
+			promutil.LabelsDroppedCounter.WithLabelValues(promutil.LabelReasonInvalidName).Inc()
 			continue
 		}
-		labels["tag_"+promTag] = tag.Value
+		if value, ok := sanitizeLabelValue(tag.Value, labelSanitization); ok {
+			labels["tag_"+promTag] = value
+		}
 	}
 
 	return labels
 }
 
+// isDroppedDimension returns whether the dimension name of c is not exported as a label
+func isDroppedDimension(c *cloudwatchData, name string) bool {
+	for _, dropped := range c.DropDimensions {
+		if dropped == name {
+			return true
+		}
+	}
+	return false
+}
+
 // recordLabelsForMetric adds any missing labels from promLabels in to the LabelSet for the metric name and returns
 // the updated observedMetricLabels
 func recordLabelsForMetric(metricName string, promLabels map[string]string, observedMetricLabels map[string]model.LabelSet) map[string]model.LabelSet {
@@ -499,19 +1756,289 @@ func getDatapoint(cwd *cloudwatchData, statistic string) (*float64, time.Time, e
 	return nil, time.Time{}, nil
 }
 
-func MigrateCloudwatchToPrometheus(cwd []*cloudwatchData, labelsSnakeCase bool, observedMetricLabels map[string]model.LabelSet, logger logger.Logger) ([]*promutil.PrometheusMetric, map[string]model.LabelSet, error) {
+// percentileQuantile returns the quantile of a percentile statistic, e.g. 0.99 for p99
+func percentileQuantile(statistic string) float64 {
+	value, _ := strconv.ParseFloat(strings.TrimPrefix(statistic, "p"), 64)
+	// Percentiles have at most two decimals, rounding avoids e.g. 0.9990000000000001 for p99.9
+	return math.Round(value*100) / 10000
+}
+
+// summaryKey identifies the series a summary is built for
+func summaryKey(name string, labels map[string]string) string {
+	return name + fmt.Sprint(labels)
+}
+
+// seriesKey returns the key of the series name of c with labels, including the dimensions dropped by
+// DropDimensions, to keep the state of each of the series merged by them across scrapes
+func seriesKey(c *cloudwatchData, name string, labels map[string]string) string {
+	key := summaryKey(name, labels)
+	for _, dimension := range c.Dimensions {
+		if isDroppedDimension(c, *dimension.Name) {
+			key += "/" + *dimension.Name + "=" + *dimension.Value
+		}
+	}
+	return key
+}
+
+// appendSample appends p, a sample of the statistic of c, to output, merged with the samples of the other series
+// which only differ by the dimensions dropped by DropDimensions
+func appendSample(merged map[string]*mergedSample, output []*promutil.PrometheusMetric, c *cloudwatchData, p *promutil.PrometheusMetric, statistic string) []*promutil.PrometheusMetric {
+	if len(c.DropDimensions) == 0 {
+		return append(output, p)
+	}
+	// Statistics are irrelevant to expressions, their results are averaged
+	if c.Expression != nil {
+		statistic = ""
+	}
+	return mergeSample(merged, output, p, statistic)
+}
+
+// mergedSample is the sample of the series merging those which only differ by the dimensions dropped by DropDimensions
+type mergedSample struct {
+	metric *promutil.PrometheusMetric
+	// count is the number of merged samples which aren't NaN
+	count int
+}
+
+// mergeSample appends p to output, unless output already has a sample of its series, which p is merged into then.
+// The Sum and SampleCount statistics are added up, the highest Maximum and lowest Minimum are kept, and the mean of
+// any other statistic, expression or anomaly band is exported. NaN samples are ignored, unless all of them are NaN.
+func mergeSample(merged map[string]*mergedSample, output []*promutil.PrometheusMetric, p *promutil.PrometheusMetric, statistic string) []*promutil.PrometheusMetric {
+	key := summaryKey(*p.Name, p.Labels)
+	if p.IncludeTimestamp {
+		key += p.Timestamp.String()
+	}
+	value := *p.Value
+
+	sample, ok := merged[key]
+	if !ok {
+		// The value of p is shared with the results, it's copied before being merged into
+		p.Value = &value
+		sample = &mergedSample{metric: p}
+		if !math.IsNaN(value) {
+			sample.count = 1
+		}
+		merged[key] = sample
+		return append(output, p)
+	}
+
+	if math.IsNaN(value) {
+		return output
+	}
+	current := sample.metric.Value
+	switch {
+	case sample.count == 0:
+		*current = value
+	case statistic == "Sum" || statistic == "SampleCount":
+		*current += value
+	case statistic == "Maximum":
+		*current = math.Max(*current, value)
+	case statistic == "Minimum":
+		*current = math.Min(*current, value)
+	default:
+		*current += (value - *current) / float64(sample.count+1)
+	}
+	sample.count++
+	if p.Timestamp.After(sample.metric.Timestamp) {
+		sample.metric.Timestamp = p.Timestamp
+	}
+	return output
+}
+
+// metricBaseName returns the name of the metric of c without the statistic suffix, e.g.
+// aws_ec2_cpuutilization. The part derived from the namespace is replaced by ExportedNamespace
+// when set, the CloudWatch metric name when it is renamed by MetricRenames, and MetricPrefix
+// is prepended to the name.
+func metricBaseName(c *cloudwatchData) string {
+	promNs := c.ExportedNamespace
+	if promNs == "" {
+		promNs = promutil.NamespaceMetricPrefix(*c.Namespace)
+	}
+	metricName := *c.Metric
+	if renamed, ok := c.MetricRenames[metricName]; ok {
+		metricName = renamed
+	}
+	name := promNs + "_" + strings.ToLower(promutil.PromString(metricName))
+	if c.MetricPrefix != "" {
+		name = promutil.PromString(c.MetricPrefix) + "_" + name
+	}
+	return name
+}
+
+// statisticSuffix returns the suffix of the exported name of the statistic of c, count for the SampleCount
+// of the metrics with SampleCountAsCount, so that it pairs with the sum of the Sum statistic
+func statisticSuffix(c *cloudwatchData, statistic string) string {
+	if c.SampleCountAsCount && statistic == "SampleCount" {
+		return "count"
+	}
+	return strings.ToLower(promutil.PromString(statistic))
+}
+
+// metricHelp returns the description of the metric of c, the configured one or else the built-in one
+func metricHelp(c *cloudwatchData) string {
+	help := c.Help
+	if help == "" {
+		help = promutil.MetricHelp(*c.Namespace, *c.Metric)
+	}
+	if help != "" && c.AnomalyBand {
+		help += " This series is the " + c.AnomalyBandBound + " bound of its anomaly detection band."
+	}
+	return help
+}
+
+// accumulatedDatapoints returns the transformed datapoints of the statistic of c added to its counter, those
+// of the GetMetricData window, or of the GetMetricStatistics response for static jobs
+func accumulatedDatapoints(c *cloudwatchData, statistic string) []dataPoint {
+	var datapoints []dataPoint
+	switch {
+	case len(c.GetMetricDataPoints) > 0:
+		datapoints = append(datapoints, c.GetMetricDataPoints...)
+	case len(c.Points) > 0:
+		for _, point := range c.Points {
+			value := point.Sum
+			if statistic == "SampleCount" {
+				value = point.SampleCount
+			}
+			if value != nil && point.Timestamp != nil {
+				datapoints = append(datapoints, dataPoint{Value: value, Timestamp: *point.Timestamp})
+			}
+		}
+	case c.GetMetricDataPoint != nil:
+		datapoints = append(datapoints, dataPoint{Value: c.GetMetricDataPoint, Timestamp: *c.GetMetricDataTimestamps})
+	}
+	for i := range datapoints {
+		datapoints[i].Value = transformDatapoint(c, statistic, datapoints[i].Value)
+	}
+	return datapoints
+}
+
+func MigrateCloudwatchToPrometheus(cwd []*cloudwatchData, labelsSnakeCase bool, labelSanitization config.LabelSanitization, observedMetricLabels map[string]model.LabelSet, logger logger.Logger) ([]*promutil.PrometheusMetric, map[string]model.LabelSet, error) {
 	output := make([]*promutil.PrometheusMetric, 0)
 
+	// Percentiles of metrics with PercentilesAsSummary are grouped in a summary per series.
+	// The Sum and SampleCount statistics of the series, if requested, are used as the
+	// summary sum and count instead of being exported on their own.
+	var summaries []*promutil.PrometheusMetric
+	summaryByKey := make(map[string]*promutil.PrometheusMetric)
+	// Samples of the series which only differ by the dimensions dropped by DropDimensions are merged
+	merged := make(map[string]*mergedSample)
+
+	carriedDatapoints.purge()
+	accumulatedCounters.purge()
+
 	for _, c := range cwd {
+		help := metricHelp(c)
 		for _, statistic := range c.Statistics {
 			var includeTimestamp bool
 			if c.AddCloudwatchTimestamp != nil {
 				includeTimestamp = *c.AddCloudwatchTimestamp
 			}
+			// Percentiles of metrics with PercentilesAsLabels are exported with a quantile
+			// label instead of the statistic suffix, e.g. {quantile="0.999"} for p99.9
+			var quantile string
+			if c.PercentilesAsLabels && percentile.MatchString(statistic) {
+				quantile = strconv.FormatFloat(percentileQuantile(statistic), 'f', -1, 64)
+			}
+
+			baseName := metricBaseName(c)
+			name := baseName
+			// Expressions are named after the user supplied metric name only, anomaly
+			// bands after their metric and statistic followed by their bound and
+			// aggregates after their metric and statistic
+			if (c.Expression == nil || c.AnomalyBand || c.Aggregated) && quantile == "" {
+				name += "_" + statisticSuffix(c, statistic)
+			}
+			if c.AnomalyBand {
+				name += "_anomaly_band_" + c.AnomalyBandBound
+			}
+			timestampName := name + "_timestamp_seconds"
+			if c.Counter {
+				name += "_total"
+			}
+
+			// Export one sample per datapoint in the requested window
+			if c.ExportAllDataPoints && len(c.GetMetricDataPoints) > 0 {
+				promLabels := createPrometheusLabels(c, labelsSnakeCase, labelSanitization, logger)
+				if quantile != "" {
+					promLabels["quantile"] = quantile
+				}
+				observedMetricLabels = recordLabelsForMetric(name, promLabels, observedMetricLabels)
+				for _, point := range c.GetMetricDataPoints {
+					p := promutil.PrometheusMetric{
+						Name:             &name,
+						Labels:           promLabels,
+						Value:            transformDatapoint(c, statistic, point.Value),
+						Timestamp:        point.Timestamp,
+						IncludeTimestamp: includeTimestamp,
+						Counter:          c.Counter,
+						Help:             help,
+					}
+					output = appendSample(merged, output, c, &p, statistic)
+				}
+				continue
+			}
+
 			exportedDatapoint, timestamp, err := getDatapoint(c, statistic)
 			if err != nil {
 				return nil, nil, err
 			}
+			// The last datapoint of the series is exported instead of a missing one
+			// until it's older than CarryForward. It's carried before the transform.
+			if c.CarryForward > 0 {
+				promLabels := createPrometheusLabels(c, labelsSnakeCase, labelSanitization, logger)
+				exportedDatapoint, timestamp = carriedDatapoints.carry(seriesKey(c, name+"/"+statistic, promLabels), c.CarryForward, exportedDatapoint, timestamp)
+			}
+			// Only datapoints are transformed, NaN and the NilToZero zero below are not
+			exportedDatapoint = transformDatapoint(c, statistic, exportedDatapoint)
+			// The counters of the metrics with Accumulate add up the transformed datapoints
+			// of their series newer than those of the previous scrapes
+			if c.Accumulate {
+				promLabels := createPrometheusLabels(c, labelsSnakeCase, labelSanitization, logger)
+				exportedDatapoint, timestamp = accumulatedCounters.add(seriesKey(c, name, promLabels), accumulatedDatapoints(c, statistic))
+			}
+
+			if c.PercentilesAsSummary && (percentile.MatchString(statistic) || statistic == "Sum" || statistic == "SampleCount") {
+				// The percentiles, sum and count are only exported in the summary: a gauge named after the Sum
+				// statistic would collide with the _sum series of the summary. Those without a datapoint are left out.
+				if exportedDatapoint == nil {
+					continue
+				}
+				summaryName := baseName
+				promLabels := createPrometheusLabels(c, labelsSnakeCase, labelSanitization, logger)
+				key := summaryKey(summaryName, promLabels)
+				summary, ok := summaryByKey[key]
+				if !ok {
+					summary = &promutil.PrometheusMetric{
+						Name:             &summaryName,
+						Labels:           promLabels,
+						IncludeTimestamp: includeTimestamp,
+						Summary:          &promutil.Summary{Quantiles: make(map[float64]float64)},
+						Help:             help,
+					}
+					summaryByKey[key] = summary
+					summaries = append(summaries, summary)
+				}
+				switch statistic {
+				case "Sum":
+					summary.Summary.Sum = aws.Float64(*exportedDatapoint)
+				case "SampleCount":
+					summary.Summary.SampleCount = aws.Uint64(uint64(*exportedDatapoint))
+				default:
+					summary.Summary.Quantiles[percentileQuantile(statistic)] = *exportedDatapoint
+				}
+				if timestamp.After(summary.Timestamp) {
+					summary.Timestamp = timestamp
+				}
+				continue
+			}
+
+			// DropNoData takes precedence over AddCloudwatchTimestamp and NilToZero:
+			// a missing datapoint is never exported as NaN or zero
+			if exportedDatapoint == nil && c.DropNoData {
+				continue
+			}
+			// Neither NaN nor the NilToZero zero have a timestamp to export
+			hasTimestamp := exportedDatapoint != nil && !timestamp.IsZero()
 			if exportedDatapoint == nil && (c.AddCloudwatchTimestamp == nil || !*c.AddCloudwatchTimestamp) {
 				var nan float64 = math.NaN()
 				exportedDatapoint = &nan
@@ -521,13 +2048,11 @@ func MigrateCloudwatchToPrometheus(cwd []*cloudwatchData, labelsSnakeCase bool,
 					exportedDatapoint = &zero
 				}
 			}
-			promNs := strings.ToLower(*c.Namespace)
-			if !strings.HasPrefix(promNs, "aws") {
-				promNs = "aws_" + promNs
-			}
-			name := promutil.PromString(promNs) + "_" + strings.ToLower(promutil.PromString(*c.Metric)) + "_" + strings.ToLower(promutil.PromString(statistic))
 			if exportedDatapoint != nil {
-				promLabels := createPrometheusLabels(c, labelsSnakeCase, logger)
+				promLabels := createPrometheusLabels(c, labelsSnakeCase, labelSanitization, logger)
+				if quantile != "" {
+					promLabels["quantile"] = quantile
+				}
 				observedMetricLabels = recordLabelsForMetric(name, promLabels, observedMetricLabels)
 				p := promutil.PrometheusMetric{
 					Name:             &name,
@@ -535,11 +2060,40 @@ func MigrateCloudwatchToPrometheus(cwd []*cloudwatchData, labelsSnakeCase bool,
 					Value:            exportedDatapoint,
 					Timestamp:        timestamp,
 					IncludeTimestamp: includeTimestamp,
+					Counter:          c.Counter,
+					Help:             help,
+				}
+				output = appendSample(merged, output, c, &p, statistic)
+
+				// The timestamp is sampled at scrape time, so that it's exported whatever AddCloudwatchTimestamp
+				if c.ExportTimestamp && hasTimestamp {
+					seconds := float64(timestamp.UnixNano()) / float64(time.Second)
+					observedMetricLabels = recordLabelsForMetric(timestampName, promLabels, observedMetricLabels)
+					ts := promutil.PrometheusMetric{
+						Name:   &timestampName,
+						Labels: promLabels,
+						Value:  &seconds,
+						Help:   fmt.Sprintf("CloudWatch timestamp of the exported datapoint of %s, in seconds since the epoch.", name),
+					}
+					// The latest timestamp of the merged series is exported
+					if len(c.DropDimensions) == 0 {
+						output = append(output, &ts)
+					} else {
+						output = mergeSample(merged, output, &ts, "Maximum")
+					}
 				}
-				output = append(output, &p)
 			}
 		}
 	}
 
+	// A summary without quantiles only has a sum and count, it's left out rather than exported empty
+	for _, summary := range summaries {
+		if len(summary.Summary.Quantiles) == 0 {
+			continue
+		}
+		observedMetricLabels = recordLabelsForMetric(*summary.Name, summary.Labels, observedMetricLabels)
+		output = append(output, summary)
+	}
+
 	return output, observedMetricLabels, nil
 }
@@ -1,16 +1,27 @@
 package job
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
@@ -68,16 +79,18 @@ func TestSortyByTimeStamp(t *testing.T) {
 
 func Test_getFilteredMetricDatas(t *testing.T) {
 	type args struct {
-		region                    string
-		accountId                 *string
-		namespace                 string
-		customTags                []model.Tag
-		tagsOnMetrics             config.ExportedTagsOnMetrics
-		dimensionRegexps          []*string
-		dimensionNameRequirements []string
-		resources                 []*services.TaggedResource
-		metricsList               []*cloudwatch.Metric
-		m                         *config.Metric
+		region                     string
+		accountId                  *string
+		namespace                  string
+		customTags                 []model.Tag
+		tagsOnMetrics              config.ExportedTagsOnMetrics
+		dimensionRegexps           []*string
+		dimensionPriority          []string
+		dimensionNameRequirements  config.DimensionNameRequirements
+		dimensionValueRequirements []config.DimensionValueRequirement
+		resources                  []*services.TaggedResource
+		metricsList                []*cloudwatch.Metric
+		m                          *config.Metric
 	}
 	tests := []struct {
 		name               string
@@ -175,6 +188,92 @@ func Test_getFilteredMetricDatas(t *testing.T) {
 				},
 			},
 		},
+		{
+			"statistic settings",
+			args{
+				region:           "us-east-1",
+				accountId:        aws.String("123123123123"),
+				namespace:        "efs",
+				dimensionRegexps: services.SupportedServices.GetService("efs").DimensionRegexps,
+				resources: []*services.TaggedResource{
+					{
+						ARN:       "arn:aws:elasticfilesystem:us-east-1:123123123123:file-system/fs-abc123",
+						Namespace: "efs",
+						Region:    "us-east-1",
+					},
+				},
+				metricsList: []*cloudwatch.Metric{
+					{
+						MetricName: aws.String("StorageBytes"),
+						Dimensions: []*cloudwatch.Dimension{
+							{
+								Name:  aws.String("FileSystemId"),
+								Value: aws.String("fs-abc123"),
+							},
+						},
+						Namespace: aws.String("AWS/EFS"),
+					},
+				},
+				m: &config.Metric{
+					Name: "StorageBytes",
+					Statistics: []string{
+						"Sum",
+						"Average",
+					},
+					Period:                 60,
+					Length:                 600,
+					Delay:                  120,
+					NilToZero:              aws.Bool(false),
+					AddCloudwatchTimestamp: aws.Bool(false),
+					StatisticSettings: map[string]*config.StatisticSettings{
+						"Sum":     {NilToZero: aws.Bool(true)},
+						"Average": {AddCloudwatchTimestamp: aws.Bool(true)},
+					},
+				},
+			},
+			[]cloudwatchData{
+				{
+					AccountId:              aws.String("123123123123"),
+					AddCloudwatchTimestamp: aws.Bool(false),
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("FileSystemId"),
+							Value: aws.String("fs-abc123"),
+						},
+					},
+					ID:        aws.String("arn:aws:elasticfilesystem:us-east-1:123123123123:file-system/fs-abc123"),
+					Metric:    aws.String("StorageBytes"),
+					Namespace: aws.String("efs"),
+					NilToZero: aws.Bool(true),
+					Period:    60,
+					Region:    aws.String("us-east-1"),
+					Statistics: []string{
+						"Sum",
+					},
+					Tags: []model.Tag{},
+				},
+				{
+					AccountId:              aws.String("123123123123"),
+					AddCloudwatchTimestamp: aws.Bool(true),
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("FileSystemId"),
+							Value: aws.String("fs-abc123"),
+						},
+					},
+					ID:        aws.String("arn:aws:elasticfilesystem:us-east-1:123123123123:file-system/fs-abc123"),
+					Metric:    aws.String("StorageBytes"),
+					Namespace: aws.String("efs"),
+					NilToZero: aws.Bool(false),
+					Period:    60,
+					Region:    aws.String("us-east-1"),
+					Statistics: []string{
+						"Average",
+					},
+					Tags: []model.Tag{},
+				},
+			},
+		},
 		{
 			"ec2",
 			args{
@@ -350,7 +449,8 @@ func Test_getFilteredMetricDatas(t *testing.T) {
 				customTags:                nil,
 				tagsOnMetrics:             nil,
 				dimensionRegexps:          services.SupportedServices.GetService("alb").DimensionRegexps,
-				dimensionNameRequirements: []string{"LoadBalancer", "TargetGroup"},
+				dimensionPriority:         services.SupportedServices.GetService("alb").DimensionPriority,
+				dimensionNameRequirements: config.DimensionNameRequirements{Names: []string{"LoadBalancer", "TargetGroup"}},
 				resources: []*services.TaggedResource{
 					{
 						ARN: "arn:aws:elasticloadbalancing:us-east-1:123123123123:loadbalancer/app/some-ALB/0123456789012345",
@@ -461,174 +561,3031 @@ func Test_getFilteredMetricDatas(t *testing.T) {
 				},
 			},
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			metricDatas := getFilteredMetricDatas(tt.args.region, tt.args.accountId, tt.args.namespace, tt.args.customTags, tt.args.tagsOnMetrics, tt.args.dimensionRegexps, tt.args.resources, tt.args.metricsList, tt.args.dimensionNameRequirements, tt.args.m)
-			if len(metricDatas) != len(tt.wantGetMetricsData) {
-				t.Errorf("len(getFilteredMetricDatas()) = %v, want %v", len(metricDatas), len(tt.wantGetMetricsData))
-			}
-			for i, got := range metricDatas {
-				if *got.AccountId != *tt.wantGetMetricsData[i].AccountId {
-					t.Errorf("getFilteredMetricDatas().AccountId = %v, want %v", *got.AccountId, *tt.wantGetMetricsData[i].AccountId)
-				}
-				if *got.ID != *tt.wantGetMetricsData[i].ID {
-					t.Errorf("getFilteredMetricDatas().ID = %v, want %v", *got.ID, *tt.wantGetMetricsData[i].ID)
-				}
-				if !reflect.DeepEqual(got.Dimensions, tt.wantGetMetricsData[i].Dimensions) {
-					t.Errorf("getFilteredMetricDatas().Dimensions = %+v, want %+v", got.Dimensions, tt.wantGetMetricsData[i].Dimensions)
-				}
-				if *got.Metric != *tt.wantGetMetricsData[i].Metric {
-					t.Errorf("getFilteredMetricDatas().Metric = %v, want %v", *got.Metric, *tt.wantGetMetricsData[i].Metric)
-				}
-				if *got.Namespace != *tt.wantGetMetricsData[i].Namespace {
-					t.Errorf("getFilteredMetricDatas().Namespace = %v, want %v", *got.Namespace, *tt.wantGetMetricsData[i].Namespace)
-				}
-				if *got.AddCloudwatchTimestamp != *tt.wantGetMetricsData[i].AddCloudwatchTimestamp {
-					t.Errorf("getFilteredMetricDatas().AddCloudwatchTimestamp = %v, want %v", *got.AddCloudwatchTimestamp, *tt.wantGetMetricsData[i].AddCloudwatchTimestamp)
-				}
-				if *got.NilToZero != *tt.wantGetMetricsData[i].NilToZero {
-					t.Errorf("getFilteredMetricDatas().NilToZero = %v, want %v", *got.NilToZero, *tt.wantGetMetricsData[i].NilToZero)
-				}
-				if got.Period != tt.wantGetMetricsData[i].Period {
-					t.Errorf("getFilteredMetricDatas().Period = %v, want %v", got.Period, tt.wantGetMetricsData[i].Period)
-				}
-				if !reflect.DeepEqual(got.Statistics, tt.wantGetMetricsData[i].Statistics) {
-					t.Errorf("getFilteredMetricDatas().Statistics = %+v, want %+v", got.Statistics, tt.wantGetMetricsData[i].Statistics)
-				}
-				if *got.Region != *tt.wantGetMetricsData[i].Region {
-					t.Errorf("getFilteredMetricDatas().Region = %v, want %v", *got.Region, *tt.wantGetMetricsData[i].Region)
-				}
-				if !reflect.DeepEqual(got.Tags, tt.wantGetMetricsData[i].Tags) {
-					t.Errorf("getFilteredMetricDatas().Tags = %+v, want %+v", got.Tags, tt.wantGetMetricsData[i].Tags)
-				}
-			}
-		})
-	}
-}
-
-func Test_ensureLabelConsistencyForMetrics(t *testing.T) {
-	value1 := 1.0
-	metric1 := promutil.PrometheusMetric{
-		Name:   aws.String("metric1"),
-		Labels: map[string]string{"label1": "value1"},
-		Value:  &value1,
-	}
-
-	value2 := 2.0
-	metric2 := promutil.PrometheusMetric{
-		Name:   aws.String("metric1"),
-		Labels: map[string]string{"label2": "value2"},
-		Value:  &value2,
-	}
-
-	value3 := 2.0
-	metric3 := promutil.PrometheusMetric{
-		Name:   aws.String("metric1"),
-		Labels: map[string]string{},
-		Value:  &value3,
-	}
-
-	metrics := []*promutil.PrometheusMetric{&metric1, &metric2, &metric3}
-	result := EnsureLabelConsistencyForMetrics(metrics, map[string]model.LabelSet{"metric1": {"label1": struct{}{}, "label2": struct{}{}, "label3": struct{}{}}})
-
-	expected := []string{"label1", "label2", "label3"}
-	for _, metric := range result {
-		assert.Equal(t, len(expected), len(metric.Labels))
-		labels := []string{}
-		for labelName := range metric.Labels {
-			labels = append(labels, labelName)
-		}
-
-		assert.ElementsMatch(t, expected, labels)
-	}
-}
-
-// StubClock stub implementation of Clock interface that allows tests
-// to control time.Now()
-type StubClock struct {
-	currentTime time.Time
-}
-
-func (mt StubClock) Now() time.Time {
-	return mt.currentTime
-}
-
-func Test_MetricWindow(t *testing.T) {
-	type data struct {
-		roundingPeriod    time.Duration
-		length            time.Duration
-		delay             time.Duration
-		clock             StubClock
-		expectedStartTime time.Time
-		expectedEndTime   time.Time
-	}
-
-	testCases := []struct {
-		testName string
-		data     data
-	}{
-		{
-			testName: "Go back four minutes and round to the nearest two minutes with two minute delay",
-			data: data{
-				roundingPeriod: 120 * time.Second,
-				length:         120 * time.Second,
-				delay:          120 * time.Second,
-				clock: StubClock{
-					currentTime: time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC),
-				},
-				expectedStartTime: time.Date(2021, 11, 19, 23, 56, 0, 0, time.UTC),
-				expectedEndTime:   time.Date(2021, 11, 19, 23, 58, 0, 0, time.UTC),
-			},
-		},
-		{
-			testName: "Go back four minutes with two minute delay nad no rounding",
-			data: data{
-				roundingPeriod: 0,
-				length:         120 * time.Second,
-				delay:          120 * time.Second,
-				clock: StubClock{
-					currentTime: time.Date(2021, 1, 1, 0, 0o2, 22, 33, time.UTC),
-				},
-				expectedStartTime: time.Date(2020, 12, 31, 23, 58, 22, 33, time.UTC),
-				expectedEndTime:   time.Date(2021, 1, 1, 0, 0, 22, 33, time.UTC),
-			},
-		},
 		{
-			testName: "Go back two days and round to the nearest day (midnight) with zero delay",
-			data: data{
-				roundingPeriod: 86400 * time.Second,  // 1 day
-				length:         172800 * time.Second, // 2 days
-				delay:          0,
-				clock: StubClock{
-					currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC),
+			"dynamodb global secondary indexes",
+			args{
+				region:     "us-east-1",
+				accountId:  aws.String("123123123123"),
+				namespace:  "dynamodb",
+				customTags: nil,
+				tagsOnMetrics: map[string][]string{
+					"dynamodb": {
+						"Team",
+					},
 				},
-				expectedStartTime: time.Date(2021, 11, 18, 0, 0, 0, 0, time.UTC),
-				expectedEndTime:   time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC),
-			},
-		},
-		{
-			testName: "Go back two days and round to the nearest 5 minutes with zero delay",
-			data: data{
-				roundingPeriod: 300 * time.Second,    // 5 min
-				length:         172800 * time.Second, // 2 days
-				delay:          0,
-				clock: StubClock{
-					currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC),
+				dimensionRegexps: services.SupportedServices.GetService("dynamodb").DimensionRegexps,
+				resources: []*services.TaggedResource{
+					{
+						ARN: "arn:aws:dynamodb:us-east-1:123123123123:table/orders",
+						Tags: []model.Tag{
+							{
+								Key:   "Team",
+								Value: "orders",
+							},
+						},
+						Namespace: "dynamodb",
+						Region:    "us-east-1",
+					},
+					{
+						ARN:       "arn:aws:dynamodb:us-east-1:123123123123:table/sessions",
+						Namespace: "dynamodb",
+						Region:    "us-east-1",
+					},
 				},
-				expectedStartTime: time.Date(2021, 11, 18, 8, 30, 0, 0, time.UTC),
-				expectedEndTime:   time.Date(2021, 11, 20, 8, 30, 0, 0, time.UTC),
-			},
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.testName, func(t *testing.T) {
-			startTime, endTime := determineGetMetricDataWindow(tc.data.clock, tc.data.roundingPeriod, tc.data.length, tc.data.delay)
-			if !startTime.Equal(tc.data.expectedStartTime) {
-				t.Errorf("start time incorrect. Expected: %s, Actual: %s", tc.data.expectedStartTime.Format(timeFormat), startTime.Format(timeFormat))
-				t.Errorf("end time incorrect. Expected: %s, Actual: %s", tc.data.expectedEndTime.Format(timeFormat), endTime.Format(timeFormat))
-			}
+				metricsList: []*cloudwatch.Metric{
+					{
+						MetricName: aws.String("ConsumedReadCapacityUnits"),
+						Dimensions: []*cloudwatch.Dimension{
+							{
+								Name:  aws.String("TableName"),
+								Value: aws.String("orders"),
+							},
+						},
+						Namespace: aws.String("AWS/DynamoDB"),
+					},
+					{
+						MetricName: aws.String("ConsumedReadCapacityUnits"),
+						Dimensions: []*cloudwatch.Dimension{
+							{
+								Name:  aws.String("TableName"),
+								Value: aws.String("orders"),
+							},
+							{
+								Name:  aws.String("GlobalSecondaryIndexName"),
+								Value: aws.String("by-customer"),
+							},
+						},
+						Namespace: aws.String("AWS/DynamoDB"),
+					},
+					{
+						MetricName: aws.String("ConsumedReadCapacityUnits"),
+						Dimensions: []*cloudwatch.Dimension{
+							{
+								Name:  aws.String("GlobalSecondaryIndexName"),
+								Value: aws.String("by-date"),
+							},
+							{
+								Name:  aws.String("TableName"),
+								Value: aws.String("orders"),
+							},
+						},
+						Namespace: aws.String("AWS/DynamoDB"),
+					},
+					{
+						MetricName: aws.String("ConsumedReadCapacityUnits"),
+						Dimensions: []*cloudwatch.Dimension{
+							{
+								Name:  aws.String("TableName"),
+								Value: aws.String("sessions"),
+							},
+						},
+						Namespace: aws.String("AWS/DynamoDB"),
+					},
+					{
+						MetricName: aws.String("ConsumedReadCapacityUnits"),
+						Dimensions: []*cloudwatch.Dimension{
+							{
+								Name:  aws.String("TableName"),
+								Value: aws.String("untagged"),
+							},
+							{
+								Name:  aws.String("GlobalSecondaryIndexName"),
+								Value: aws.String("by-customer"),
+							},
+						},
+						Namespace: aws.String("AWS/DynamoDB"),
+					},
+				},
+				m: &config.Metric{
+					Name: "ConsumedReadCapacityUnits",
+					Statistics: []string{
+						"Sum",
+					},
+					Period:                 60,
+					Length:                 600,
+					Delay:                  120,
+					NilToZero:              aws.Bool(false),
+					AddCloudwatchTimestamp: aws.Bool(false),
+				},
+			},
+			[]cloudwatchData{
+				{
+					AccountId:              aws.String("123123123123"),
+					AddCloudwatchTimestamp: aws.Bool(false),
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("TableName"),
+							Value: aws.String("orders"),
+						},
+					},
+					ID:        aws.String("arn:aws:dynamodb:us-east-1:123123123123:table/orders"),
+					Metric:    aws.String("ConsumedReadCapacityUnits"),
+					Namespace: aws.String("dynamodb"),
+					NilToZero: aws.Bool(false),
+					Period:    60,
+					Region:    aws.String("us-east-1"),
+					Statistics: []string{
+						"Sum",
+					},
+					Tags: []model.Tag{
+						{
+							Key:   "Team",
+							Value: "orders",
+						},
+					},
+				},
+				{
+					AccountId:              aws.String("123123123123"),
+					AddCloudwatchTimestamp: aws.Bool(false),
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("TableName"),
+							Value: aws.String("orders"),
+						},
+						{
+							Name:  aws.String("GlobalSecondaryIndexName"),
+							Value: aws.String("by-customer"),
+						},
+					},
+					ID:        aws.String("arn:aws:dynamodb:us-east-1:123123123123:table/orders"),
+					Metric:    aws.String("ConsumedReadCapacityUnits"),
+					Namespace: aws.String("dynamodb"),
+					NilToZero: aws.Bool(false),
+					Period:    60,
+					Region:    aws.String("us-east-1"),
+					Statistics: []string{
+						"Sum",
+					},
+					Tags: []model.Tag{
+						{
+							Key:   "Team",
+							Value: "orders",
+						},
+					},
+				},
+				{
+					AccountId:              aws.String("123123123123"),
+					AddCloudwatchTimestamp: aws.Bool(false),
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("GlobalSecondaryIndexName"),
+							Value: aws.String("by-date"),
+						},
+						{
+							Name:  aws.String("TableName"),
+							Value: aws.String("orders"),
+						},
+					},
+					ID:        aws.String("arn:aws:dynamodb:us-east-1:123123123123:table/orders"),
+					Metric:    aws.String("ConsumedReadCapacityUnits"),
+					Namespace: aws.String("dynamodb"),
+					NilToZero: aws.Bool(false),
+					Period:    60,
+					Region:    aws.String("us-east-1"),
+					Statistics: []string{
+						"Sum",
+					},
+					Tags: []model.Tag{
+						{
+							Key:   "Team",
+							Value: "orders",
+						},
+					},
+				},
+				{
+					AccountId:              aws.String("123123123123"),
+					AddCloudwatchTimestamp: aws.Bool(false),
+					Dimensions: []*cloudwatch.Dimension{
+						{
+							Name:  aws.String("TableName"),
+							Value: aws.String("sessions"),
+						},
+					},
+					ID:        aws.String("arn:aws:dynamodb:us-east-1:123123123123:table/sessions"),
+					Metric:    aws.String("ConsumedReadCapacityUnits"),
+					Namespace: aws.String("dynamodb"),
+					NilToZero: aws.Bool(false),
+					Period:    60,
+					Region:    aws.String("us-east-1"),
+					Statistics: []string{
+						"Sum",
+					},
+					Tags: []model.Tag{
+						{
+							Key:   "Team",
+							Value: "",
+						},
+					},
+				},
+			},
+		},
+		{
+			"dimension value requirements",
+			args{
+				region:           "us-east-1",
+				accountId:        aws.String("123123123123"),
+				namespace:        "ec2",
+				dimensionRegexps: services.SupportedServices.GetService("ec2").DimensionRegexps,
+				dimensionValueRequirements: []config.DimensionValueRequirement{
+					{Name: "AutoScalingGroupName", ValueRegex: "^prod-"},
+				},
+				resources: []*services.TaggedResource{
+					{
+						ARN:       "arn:aws:ec2:us-east-1:123123123123:instance/i-1",
+						Namespace: "ec2",
+						Region:    "us-east-1",
+					},
+				},
+				metricsList: []*cloudwatch.Metric{
+					{
+						MetricName: aws.String("CPUUtilization"),
+						Dimensions: []*cloudwatch.Dimension{
+							{Name: aws.String("AutoScalingGroupName"), Value: aws.String("prod-web")},
+						},
+						Namespace: aws.String("AWS/EC2"),
+					},
+					{
+						MetricName: aws.String("CPUUtilization"),
+						Dimensions: []*cloudwatch.Dimension{
+							{Name: aws.String("AutoScalingGroupName"), Value: aws.String("staging-web")},
+						},
+						Namespace: aws.String("AWS/EC2"),
+					},
+					{
+						MetricName: aws.String("CPUUtilization"),
+						Dimensions: []*cloudwatch.Dimension{
+							{Name: aws.String("InstanceId"), Value: aws.String("i-1")},
+						},
+						Namespace: aws.String("AWS/EC2"),
+					},
+				},
+				m: &config.Metric{
+					Name:                   "CPUUtilization",
+					Statistics:             []string{"Average"},
+					Period:                 60,
+					Length:                 600,
+					NilToZero:              aws.Bool(false),
+					AddCloudwatchTimestamp: aws.Bool(false),
+				},
+			},
+			[]cloudwatchData{
+				{
+					AccountId:              aws.String("123123123123"),
+					AddCloudwatchTimestamp: aws.Bool(false),
+					Dimensions: []*cloudwatch.Dimension{
+						{Name: aws.String("AutoScalingGroupName"), Value: aws.String("prod-web")},
+					},
+					ID:         aws.String("global"),
+					Metric:     aws.String("CPUUtilization"),
+					Namespace:  aws.String("ec2"),
+					NilToZero:  aws.Bool(false),
+					Period:     60,
+					Region:     aws.String("us-east-1"),
+					Statistics: []string{"Average"},
+					Tags:       []model.Tag{},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricDatas := getFilteredMetricDatas(tt.args.region, tt.args.accountId, tt.args.namespace, tt.args.customTags, tt.args.tagsOnMetrics, tt.args.dimensionRegexps, tt.args.dimensionPriority, tt.args.resources, tt.args.metricsList, tt.args.dimensionNameRequirements, tt.args.dimensionValueRequirements, tt.args.m, &metricIDs{})
+			if len(metricDatas) != len(tt.wantGetMetricsData) {
+				t.Errorf("len(getFilteredMetricDatas()) = %v, want %v", len(metricDatas), len(tt.wantGetMetricsData))
+			}
+			for i, got := range metricDatas {
+				if *got.AccountId != *tt.wantGetMetricsData[i].AccountId {
+					t.Errorf("getFilteredMetricDatas().AccountId = %v, want %v", *got.AccountId, *tt.wantGetMetricsData[i].AccountId)
+				}
+				if *got.ID != *tt.wantGetMetricsData[i].ID {
+					t.Errorf("getFilteredMetricDatas().ID = %v, want %v", *got.ID, *tt.wantGetMetricsData[i].ID)
+				}
+				if !reflect.DeepEqual(got.Dimensions, tt.wantGetMetricsData[i].Dimensions) {
+					t.Errorf("getFilteredMetricDatas().Dimensions = %+v, want %+v", got.Dimensions, tt.wantGetMetricsData[i].Dimensions)
+				}
+				if *got.Metric != *tt.wantGetMetricsData[i].Metric {
+					t.Errorf("getFilteredMetricDatas().Metric = %v, want %v", *got.Metric, *tt.wantGetMetricsData[i].Metric)
+				}
+				if *got.Namespace != *tt.wantGetMetricsData[i].Namespace {
+					t.Errorf("getFilteredMetricDatas().Namespace = %v, want %v", *got.Namespace, *tt.wantGetMetricsData[i].Namespace)
+				}
+				if *got.AddCloudwatchTimestamp != *tt.wantGetMetricsData[i].AddCloudwatchTimestamp {
+					t.Errorf("getFilteredMetricDatas().AddCloudwatchTimestamp = %v, want %v", *got.AddCloudwatchTimestamp, *tt.wantGetMetricsData[i].AddCloudwatchTimestamp)
+				}
+				if *got.NilToZero != *tt.wantGetMetricsData[i].NilToZero {
+					t.Errorf("getFilteredMetricDatas().NilToZero = %v, want %v", *got.NilToZero, *tt.wantGetMetricsData[i].NilToZero)
+				}
+				if got.Period != tt.wantGetMetricsData[i].Period {
+					t.Errorf("getFilteredMetricDatas().Period = %v, want %v", got.Period, tt.wantGetMetricsData[i].Period)
+				}
+				if !reflect.DeepEqual(got.Statistics, tt.wantGetMetricsData[i].Statistics) {
+					t.Errorf("getFilteredMetricDatas().Statistics = %+v, want %+v", got.Statistics, tt.wantGetMetricsData[i].Statistics)
+				}
+				if *got.Region != *tt.wantGetMetricsData[i].Region {
+					t.Errorf("getFilteredMetricDatas().Region = %v, want %v", *got.Region, *tt.wantGetMetricsData[i].Region)
+				}
+				if !reflect.DeepEqual(got.Tags, tt.wantGetMetricsData[i].Tags) {
+					t.Errorf("getFilteredMetricDatas().Tags = %+v, want %+v", got.Tags, tt.wantGetMetricsData[i].Tags)
+				}
+			}
+		})
+	}
+}
+
+func Test_getFilteredMetricDatas_ALBTargetGroups(t *testing.T) {
+	const arnPrefix = "arn:aws:elasticloadbalancing:us-east-1:123456789012:"
+	resource := func(name string, team string) *services.TaggedResource {
+		return &services.TaggedResource{ARN: arnPrefix + name, Namespace: "alb", Region: "us-east-1", Tags: []model.Tag{{Key: "team", Value: team}}}
+	}
+	dimensions := func(pairs ...string) []*cloudwatch.Dimension {
+		var dimensions []*cloudwatch.Dimension
+		for i := 0; i < len(pairs); i += 2 {
+			dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String(pairs[i]), Value: aws.String(pairs[i+1])})
+		}
+		return dimensions
+	}
+
+	// The shared target group is registered with both load balancers, the orphan one isn't discovered
+	resources := []*services.TaggedResource{
+		resource("loadbalancer/app/public/50dc6c495c0c9188", "web"),
+		resource("loadbalancer/app/internal/73e2d6bc24d8a067", "backend"),
+		resource("targetgroup/shared/9999666677773333", "payments"),
+	}
+	metricsList := []*cloudwatch.Metric{
+		{MetricName: aws.String("RequestCount"), Dimensions: dimensions("LoadBalancer", "app/public/50dc6c495c0c9188")},
+		{MetricName: aws.String("RequestCount"), Dimensions: dimensions("LoadBalancer", "app/public/50dc6c495c0c9188", "TargetGroup", "targetgroup/shared/9999666677773333")},
+		// The dimensions aren't always listed in the same order
+		{MetricName: aws.String("RequestCount"), Dimensions: dimensions("TargetGroup", "targetgroup/shared/9999666677773333", "LoadBalancer", "app/internal/73e2d6bc24d8a067")},
+		{MetricName: aws.String("RequestCount"), Dimensions: dimensions("TargetGroup", "targetgroup/orphan/1111222233334444", "LoadBalancer", "app/internal/73e2d6bc24d8a067")},
+		{MetricName: aws.String("RequestCount"), Dimensions: dimensions("LoadBalancer", "app/deleted/0123456789012345", "TargetGroup", "targetgroup/deleted/0123456789012345")},
+	}
+	svc := services.SupportedServices.GetService("alb")
+	m := &config.Metric{Name: "RequestCount", Statistics: []string{"Sum"}, Period: 60}
+
+	getMetricDatas := getFilteredMetricDatas("us-east-1", aws.String("123456789012"), "alb", nil, config.ExportedTagsOnMetrics{"alb": {"team"}}, svc.DimensionRegexps, svc.DimensionPriority, resources, metricsList, config.DimensionNameRequirements{}, nil, m, &metricIDs{})
+
+	require.Len(t, getMetricDatas, 4)
+	expected := []struct {
+		arn  string
+		team string
+	}{
+		{arn: arnPrefix + "loadbalancer/app/public/50dc6c495c0c9188", team: "web"},
+		{arn: arnPrefix + "targetgroup/shared/9999666677773333", team: "payments"},
+		{arn: arnPrefix + "targetgroup/shared/9999666677773333", team: "payments"},
+		// Without its target group, a target group metric belongs to its load balancer
+		{arn: arnPrefix + "loadbalancer/app/internal/73e2d6bc24d8a067", team: "backend"},
+	}
+	for i, data := range getMetricDatas {
+		assert.Equal(t, expected[i].arn, *data.ID)
+		assert.Equal(t, []model.Tag{{Key: "team", Value: expected[i].team}}, data.Tags)
+		assert.Equal(t, metricsList[i].Dimensions, data.Dimensions)
+	}
+}
+
+func Test_getFilteredMetricDatas_ElastiCacheNodes(t *testing.T) {
+	const arnPrefix = "arn:aws:elasticache:us-east-1:123456789012:cluster:"
+	resource := func(id string, team string) *services.TaggedResource {
+		return &services.TaggedResource{ARN: arnPrefix + id, Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "team", Value: team}}}
+	}
+	dimensions := func(cacheClusterId string, cacheNodeId string) []*cloudwatch.Dimension {
+		return []*cloudwatch.Dimension{
+			{Name: aws.String("CacheClusterId"), Value: aws.String(cacheClusterId)},
+			{Name: aws.String("CacheNodeId"), Value: aws.String(cacheNodeId)},
+		}
+	}
+
+	// The member clusters of the orders replication group carry its tags, see the FilterFunc of the service
+	resources := []*services.TaggedResource{
+		resource("sessions", "web"),
+		resource("orders-0001-001", "payments"),
+		resource("orders-0002-001", "payments"),
+	}
+	metricsList := []*cloudwatch.Metric{
+		// The nodes of a Memcached cluster only differ by their CacheNodeId
+		{MetricName: aws.String("CPUUtilization"), Dimensions: dimensions("sessions", "0001")},
+		{MetricName: aws.String("CPUUtilization"), Dimensions: dimensions("sessions", "0002")},
+		// The shards of a Redis replication group in cluster mode are clusters with a single node
+		{MetricName: aws.String("CPUUtilization"), Dimensions: dimensions("orders-0001-001", "0001")},
+		{MetricName: aws.String("CPUUtilization"), Dimensions: dimensions("orders-0002-001", "0001")},
+		{MetricName: aws.String("CPUUtilization"), Dimensions: dimensions("deleted", "0001")},
+	}
+	svc := services.SupportedServices.GetService("ec")
+	m := &config.Metric{Name: "CPUUtilization", Statistics: []string{"Average"}, Period: 60}
+
+	getMetricDatas := getFilteredMetricDatas("us-east-1", aws.String("123456789012"), "ec", nil, config.ExportedTagsOnMetrics{"ec": {"team"}}, svc.DimensionRegexps, svc.DimensionPriority, resources, metricsList, config.DimensionNameRequirements{}, nil, m, &metricIDs{})
+
+	require.Len(t, getMetricDatas, 4)
+	expected := []struct {
+		arn  string
+		team string
+	}{
+		{arn: arnPrefix + "sessions", team: "web"},
+		{arn: arnPrefix + "sessions", team: "web"},
+		{arn: arnPrefix + "orders-0001-001", team: "payments"},
+		{arn: arnPrefix + "orders-0002-001", team: "payments"},
+	}
+	for i, data := range getMetricDatas {
+		assert.Equal(t, expected[i].arn, *data.ID)
+		assert.Equal(t, []model.Tag{{Key: "team", Value: expected[i].team}}, data.Tags)
+		assert.Equal(t, metricsList[i].Dimensions, data.Dimensions)
+	}
+}
+
+func Test_ensureLabelConsistencyForMetrics(t *testing.T) {
+	value1 := 1.0
+	metric1 := promutil.PrometheusMetric{
+		Name:   aws.String("metric1"),
+		Labels: map[string]string{"label1": "value1"},
+		Value:  &value1,
+	}
+
+	value2 := 2.0
+	metric2 := promutil.PrometheusMetric{
+		Name:   aws.String("metric1"),
+		Labels: map[string]string{"label2": "value2"},
+		Value:  &value2,
+	}
+
+	value3 := 2.0
+	metric3 := promutil.PrometheusMetric{
+		Name:   aws.String("metric1"),
+		Labels: map[string]string{},
+		Value:  &value3,
+	}
+
+	metrics := []*promutil.PrometheusMetric{&metric1, &metric2, &metric3}
+	result := EnsureLabelConsistencyForMetrics(metrics, map[string]model.LabelSet{"metric1": {"label1": struct{}{}, "label2": struct{}{}, "label3": struct{}{}}})
+
+	expected := []string{"label1", "label2", "label3"}
+	for _, metric := range result {
+		assert.Equal(t, len(expected), len(metric.Labels))
+		labels := []string{}
+		for labelName := range metric.Labels {
+			labels = append(labels, labelName)
+		}
+
+		assert.ElementsMatch(t, expected, labels)
+	}
+}
+
+// StubClock stub implementation of Clock interface that allows tests
+// to control time.Now()
+type StubClock struct {
+	currentTime time.Time
+}
+
+func (mt StubClock) Now() time.Time {
+	return mt.currentTime
+}
+
+func Test_MetricWindow(t *testing.T) {
+	type data struct {
+		roundingPeriod    time.Duration
+		length            time.Duration
+		delay             time.Duration
+		alignToPeriod     bool
+		clock             StubClock
+		expectedStartTime time.Time
+		expectedEndTime   time.Time
+	}
+
+	testCases := []struct {
+		testName string
+		data     data
+	}{
+		{
+			testName: "Go back four minutes and round to the nearest two minutes with two minute delay",
+			data: data{
+				roundingPeriod: 120 * time.Second,
+				length:         120 * time.Second,
+				delay:          120 * time.Second,
+				clock: StubClock{
+					currentTime: time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC),
+				},
+				expectedStartTime: time.Date(2021, 11, 19, 23, 56, 0, 0, time.UTC),
+				expectedEndTime:   time.Date(2021, 11, 19, 23, 58, 0, 0, time.UTC),
+			},
+		},
+		{
+			testName: "Go back four minutes with two minute delay nad no rounding",
+			data: data{
+				roundingPeriod: 0,
+				length:         120 * time.Second,
+				delay:          120 * time.Second,
+				clock: StubClock{
+					currentTime: time.Date(2021, 1, 1, 0, 0o2, 22, 33, time.UTC),
+				},
+				expectedStartTime: time.Date(2020, 12, 31, 23, 58, 22, 33, time.UTC),
+				expectedEndTime:   time.Date(2021, 1, 1, 0, 0, 22, 33, time.UTC),
+			},
+		},
+		{
+			testName: "Go back two days and round to the nearest day (midnight) with zero delay",
+			data: data{
+				roundingPeriod: 86400 * time.Second,  // 1 day
+				length:         172800 * time.Second, // 2 days
+				delay:          0,
+				clock: StubClock{
+					currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC),
+				},
+				expectedStartTime: time.Date(2021, 11, 18, 0, 0, 0, 0, time.UTC),
+				expectedEndTime:   time.Date(2021, 11, 20, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			testName: "Go back two days and round to the nearest 5 minutes with zero delay",
+			data: data{
+				roundingPeriod: 300 * time.Second,    // 5 min
+				length:         172800 * time.Second, // 2 days
+				delay:          0,
+				clock: StubClock{
+					currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC),
+				},
+				expectedStartTime: time.Date(2021, 11, 18, 8, 30, 0, 0, time.UTC),
+				expectedEndTime:   time.Date(2021, 11, 20, 8, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			testName: "Delay which is not a multiple of the rounding period shifts the window off the boundaries",
+			data: data{
+				roundingPeriod: 300 * time.Second,
+				length:         600 * time.Second,
+				delay:          90 * time.Second,
+				clock: StubClock{
+					currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC),
+				},
+				expectedStartTime: time.Date(2021, 11, 20, 8, 18, 30, 0, time.UTC),
+				expectedEndTime:   time.Date(2021, 11, 20, 8, 28, 30, 0, time.UTC),
+			},
+		},
+		{
+			testName: "Align to period boundaries after applying the delay",
+			data: data{
+				roundingPeriod: 300 * time.Second,
+				length:         600 * time.Second,
+				delay:          90 * time.Second,
+				alignToPeriod:  true,
+				clock: StubClock{
+					currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC),
+				},
+				expectedStartTime: time.Date(2021, 11, 20, 8, 20, 0, 0, time.UTC),
+				expectedEndTime:   time.Date(2021, 11, 20, 8, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			testName: "Align to period boundaries rounds the length up to the rounding period",
+			data: data{
+				roundingPeriod: 300 * time.Second,
+				length:         400 * time.Second,
+				delay:          0,
+				alignToPeriod:  true,
+				clock: StubClock{
+					currentTime: time.Date(2021, 11, 20, 8, 35, 0, 0, time.UTC),
+				},
+				expectedStartTime: time.Date(2021, 11, 20, 8, 25, 0, 0, time.UTC),
+				expectedEndTime:   time.Date(2021, 11, 20, 8, 35, 0, 0, time.UTC),
+			},
+		},
+		{
+			testName: "Align to period boundaries is stable until the next boundary",
+			data: data{
+				roundingPeriod: 60 * time.Second,
+				length:         300 * time.Second,
+				delay:          120 * time.Second,
+				alignToPeriod:  true,
+				clock: StubClock{
+					currentTime: time.Date(2021, 11, 20, 8, 35, 59, 999, time.UTC),
+				},
+				expectedStartTime: time.Date(2021, 11, 20, 8, 28, 0, 0, time.UTC),
+				expectedEndTime:   time.Date(2021, 11, 20, 8, 33, 0, 0, time.UTC),
+			},
+		},
+		{
+			testName: "Align to period boundaries without rounding period",
+			data: data{
+				roundingPeriod: 0,
+				length:         120 * time.Second,
+				delay:          120 * time.Second,
+				alignToPeriod:  true,
+				clock: StubClock{
+					currentTime: time.Date(2021, 1, 1, 0, 0o2, 22, 33, time.UTC),
+				},
+				expectedStartTime: time.Date(2020, 12, 31, 23, 58, 22, 33, time.UTC),
+				expectedEndTime:   time.Date(2021, 1, 1, 0, 0, 22, 33, time.UTC),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			startTime, endTime := determineGetMetricDataWindow(tc.data.clock, tc.data.roundingPeriod, tc.data.length, tc.data.delay, tc.data.alignToPeriod)
+			if !startTime.Equal(tc.data.expectedStartTime) {
+				t.Errorf("start time incorrect. Expected: %s, Actual: %s", tc.data.expectedStartTime.Format(timeFormat), startTime.Format(timeFormat))
+			}
+			if !endTime.Equal(tc.data.expectedEndTime) {
+				t.Errorf("end time incorrect. Expected: %s, Actual: %s", tc.data.expectedEndTime.Format(timeFormat), endTime.Format(timeFormat))
+			}
+		})
+	}
+}
+
+func Test_createGetMetricStatisticsInput(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name              string
+		metric            *config.Metric
+		expectedStartTime time.Time
+		expectedEndTime   time.Time
+		expectedPeriod    int64
+	}{
+		{
+			name:              "single period",
+			metric:            &config.Metric{Name: "NumberOfObjects", Statistics: []string{"Average"}, Period: 300, Length: 300},
+			expectedStartTime: now.Add(-5 * time.Minute),
+			expectedEndTime:   now,
+			expectedPeriod:    300,
+		},
+		{
+			name:              "several periods",
+			metric:            &config.Metric{Name: "NumberOfObjects", Statistics: []string{"Average"}, Period: 86400, Length: 172800},
+			expectedStartTime: now.Add(-48 * time.Hour),
+			expectedEndTime:   now,
+			expectedPeriod:    86400,
+		},
+		{
+			name:              "delay",
+			metric:            &config.Metric{Name: "NumberOfObjects", Statistics: []string{"Average"}, Period: 60, Length: 600, Delay: 120},
+			expectedStartTime: now.Add(-12 * time.Minute),
+			expectedEndTime:   now.Add(-2 * time.Minute),
+			expectedPeriod:    60,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := createGetMetricStatisticsInput(StubClock{currentTime: now}, nil, aws.String("AWS/S3"), tc.metric, logger.NewLogrusLogger(log.StandardLogger()))
+
+			assert.Equal(t, tc.expectedStartTime, *input.StartTime)
+			assert.Equal(t, tc.expectedEndTime, *input.EndTime)
+			assert.Equal(t, tc.expectedPeriod, *input.Period)
+		})
+	}
+}
+
+func Test_getMetricDataRoundingPeriod(t *testing.T) {
+	testCases := []struct {
+		testName                 string
+		shortestPeriod           int64
+		configuredRoundingPeriod *int64
+		alignToPeriod            bool
+		expected                 int64
+	}{
+		{
+			testName:       "shortest period",
+			shortestPeriod: 60,
+			expected:       60,
+		},
+		{
+			testName:       "shortest period is capped to 5 minutes",
+			shortestPeriod: 3600,
+			expected:       300,
+		},
+		{
+			testName:       "shortest period is not capped when aligned to the period",
+			shortestPeriod: 3600,
+			alignToPeriod:  true,
+			expected:       3600,
+		},
+		{
+			testName:                 "configured rounding period",
+			shortestPeriod:           60,
+			configuredRoundingPeriod: aws.Int64(600),
+			alignToPeriod:            true,
+			expected:                 600,
+		},
+		{
+			testName:                 "rounding disabled",
+			shortestPeriod:           60,
+			configuredRoundingPeriod: aws.Int64(0),
+			expected:                 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			require.Equal(t, tc.expected, getMetricDataRoundingPeriod(tc.shortestPeriod, tc.configuredRoundingPeriod, tc.alignToPeriod))
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_ExportAllDataPoints(t *testing.T) {
+	newest := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	oldest := time.Date(2023, 1, 1, 0, 5, 0, 0, time.UTC)
+
+	cwd := &cloudwatchData{
+		ID:                     aws.String("arn:aws:sqs:us-east-1:123456789012:queue"),
+		MetricID:               aws.String("id_1"),
+		Metric:                 aws.String("NumberOfMessagesSent"),
+		Namespace:              aws.String("AWS/SQS"),
+		Statistics:             []string{"Sum"},
+		NilToZero:              aws.Bool(false),
+		AddCloudwatchTimestamp: aws.Bool(true),
+		ExportAllDataPoints:    true,
+		Region:                 aws.String("us-east-1"),
+		AccountId:              aws.String("123456789012"),
+	}
+	setMetricDataResult(cwd, &cloudwatch.MetricDataResult{
+		Id:         aws.String("id_1"),
+		Values:     []*float64{aws.Float64(2), aws.Float64(1)},
+		Timestamps: []*time.Time{&newest, &oldest},
+	})
+
+	metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	for i, expected := range []struct {
+		value     float64
+		timestamp time.Time
+	}{{2, newest}, {1, oldest}} {
+		assert.Equal(t, "aws_sqs_number_of_messages_sent_sum", *metrics[i].Name)
+		assert.Equal(t, expected.value, *metrics[i].Value)
+		assert.Equal(t, expected.timestamp, metrics[i].Timestamp)
+		assert.True(t, metrics[i].IncludeTimestamp)
+	}
+}
+
+func Test_getFilteredMetricDatas_SQSQueueName(t *testing.T) {
+	queueMetric := func(queueName string) *cloudwatch.Metric {
+		return &cloudwatch.Metric{
+			MetricName: aws.String("ApproximateNumberOfMessagesVisible"),
+			Namespace:  aws.String("AWS/SQS"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("QueueName"), Value: aws.String(queueName)}},
+		}
+	}
+	resources := []*services.TaggedResource{
+		{ARN: "arn:aws:sqs:us-east-1:123456789012:orders", Namespace: "sqs", Region: "us-east-1", Tags: []model.Tag{{Key: "type", Value: "standard"}}},
+		{ARN: "arn:aws:sqs:us-east-1:123456789012:orders.fifo", Namespace: "sqs", Region: "us-east-1", Tags: []model.Tag{{Key: "type", Value: "fifo"}}},
+	}
+	metricsList := []*cloudwatch.Metric{queueMetric("orders"), queueMetric("orders.fifo"), queueMetric("untagged")}
+	m := &config.Metric{Name: "ApproximateNumberOfMessagesVisible", Statistics: []string{"Maximum"}, Period: 60, Length: 300}
+
+	getMetricDatas := getFilteredMetricDatas("us-east-1", aws.String("123456789012"), "sqs", nil, config.ExportedTagsOnMetrics{"sqs": {"type"}}, services.SupportedServices.GetService("sqs").DimensionRegexps, nil, resources, metricsList, config.DimensionNameRequirements{}, nil, m, &metricIDs{})
+
+	tags := map[string][]model.Tag{}
+	for _, data := range getMetricDatas {
+		tags[*data.ID] = data.Tags
+	}
+	// The metrics of the untagged queue, which wasn't discovered, are skipped
+	assert.Equal(t, map[string][]model.Tag{
+		"arn:aws:sqs:us-east-1:123456789012:orders":      {{Key: "type", Value: "standard"}},
+		"arn:aws:sqs:us-east-1:123456789012:orders.fifo": {{Key: "type", Value: "fifo"}},
+	}, tags)
+}
+
+func Test_metricDimensionsMatchNames(t *testing.T) {
+	metric := func(names ...string) *cloudwatch.Metric {
+		m := &cloudwatch.Metric{}
+		for _, name := range names {
+			m.Dimensions = append(m.Dimensions, &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String("value")})
+		}
+		return m
+	}
+	or := config.DimensionNameRequirements{AnyOf: []config.DimensionNameRequirements{
+		{Names: []string{"LoadBalancer"}},
+		{Names: []string{"LoadBalancer", "TargetGroup"}},
+	}}
+
+	testCases := []struct {
+		name         string
+		requirements config.DimensionNameRequirements
+		metric       *cloudwatch.Metric
+		expected     bool
+	}{
+		{name: "no requirement", metric: metric("LoadBalancer", "AvailabilityZone"), expected: true},
+		{name: "and", requirements: config.DimensionNameRequirements{Names: []string{"TargetGroup", "LoadBalancer"}}, metric: metric("LoadBalancer", "TargetGroup"), expected: true},
+		{name: "and, other dimensions", requirements: config.DimensionNameRequirements{Names: []string{"TargetGroup", "LoadBalancer"}}, metric: metric("LoadBalancer"), expected: false},
+		{name: "or, first group", requirements: or, metric: metric("LoadBalancer"), expected: true},
+		{name: "or, second group", requirements: or, metric: metric("LoadBalancer", "TargetGroup"), expected: true},
+		{name: "or, no group", requirements: or, metric: metric("LoadBalancer", "AvailabilityZone"), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, metricDimensionsMatchNames(tc.metric, tc.requirements))
+		})
+	}
+}
+
+func Test_createGetMetricDataInput_PeriodPerQuery(t *testing.T) {
+	getMetricDatas := []cloudwatchData{
+		{
+			MetricID:   aws.String("id_1"),
+			Metric:     aws.String("CPUUtilization"),
+			Statistics: []string{"Average"},
+			Period:     60,
+		},
+		{
+			MetricID:   aws.String("id_2"),
+			Metric:     aws.String("NetworkIn"),
+			Statistics: []string{"Sum"},
+			Period:     300,
+			Unit:       aws.String("Bytes"),
+			Label:      aws.String("${PROP('Dim.InstanceId')}"),
+		},
+	}
+
+	input := createGetMetricDataInput(TimeClock{}, getMetricDatas, aws.String("AWS/EC2"), 600, nil, false, "", logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, input.MetricDataQueries, 2)
+	assert.Equal(t, int64(60), *input.MetricDataQueries[0].MetricStat.Period)
+	assert.Equal(t, int64(300), *input.MetricDataQueries[1].MetricStat.Period)
+	assert.Nil(t, input.MetricDataQueries[0].MetricStat.Unit)
+	assert.Equal(t, "Bytes", *input.MetricDataQueries[1].MetricStat.Unit)
+	assert.Nil(t, input.MetricDataQueries[0].Label)
+	assert.Equal(t, "${PROP('Dim.InstanceId')}", *input.MetricDataQueries[1].Label)
+}
+
+func Test_createGetMetricDataInput_LinkedAccount(t *testing.T) {
+	getMetricDatas := []cloudwatchData{
+		{
+			MetricID:   aws.String("id_1"),
+			Metric:     aws.String("CallCount"),
+			Statistics: []string{"Sum"},
+			Period:     60,
+			AccountId:  aws.String("123456789012"),
+		},
+		{
+			MetricID:      aws.String("id_2"),
+			Metric:        aws.String("CallCount"),
+			Statistics:    []string{"Sum"},
+			Period:        60,
+			AccountId:     aws.String("111111111111"),
+			LinkedAccount: true,
+		},
+	}
+
+	input := createGetMetricDataInput(TimeClock{}, getMetricDatas, aws.String("AWS/Usage"), 600, nil, false, "", logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, input.MetricDataQueries, 2)
+	// The metrics of the monitoring account itself are queried without account
+	assert.Nil(t, input.MetricDataQueries[0].AccountId)
+	assert.Equal(t, "111111111111", *input.MetricDataQueries[1].AccountId)
+}
+
+func Test_createGetMetricDataInput_ScanBy(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	// Datapoints of the window from the oldest to the most recent
+	timestamps := []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Minute), now}
+	values := []float64{1, 2, 3}
+
+	testCases := []struct {
+		name              string
+		scanBy            string
+		expectedScanBy    string
+		expectedValue     float64
+		expectedTimestamp time.Time
+	}{
+		{
+			name:              "latest datapoint by default",
+			expectedScanBy:    "TimestampDescending",
+			expectedValue:     3,
+			expectedTimestamp: now,
+		},
+		{
+			name:              "oldest datapoint when scanning by ascending timestamp",
+			scanBy:            "TimestampAscending",
+			expectedScanBy:    "TimestampAscending",
+			expectedValue:     1,
+			expectedTimestamp: now.Add(-2 * time.Minute),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			getMetricData := cloudwatchData{
+				MetricID:   aws.String("id_1"),
+				Metric:     aws.String("CPUUtilization"),
+				Statistics: []string{"Average"},
+				Period:     60,
+			}
+			input := createGetMetricDataInput(TimeClock{}, []cloudwatchData{getMetricData}, aws.String("AWS/EC2"), 180, nil, false, tc.scanBy, logger.NewLogrusLogger(log.StandardLogger()))
+			require.Equal(t, tc.expectedScanBy, *input.ScanBy)
+
+			// GetMetricData returns the datapoints in the order of ScanBy
+			result := &cloudwatch.MetricDataResult{Id: getMetricData.MetricID}
+			for i := range values {
+				j := i
+				if *input.ScanBy == "TimestampDescending" {
+					j = len(values) - 1 - i
+				}
+				result.Values = append(result.Values, aws.Float64(values[j]))
+				result.Timestamps = append(result.Timestamps, aws.Time(timestamps[j]))
+			}
+
+			setMetricDataResult(&getMetricData, result)
+			assert.Equal(t, tc.expectedValue, *getMetricData.GetMetricDataPoint)
+			assert.Equal(t, tc.expectedTimestamp, *getMetricData.GetMetricDataTimestamps)
+		})
+	}
+}
+
+func Test_createGetMetricDataInput_Delay(t *testing.T) {
+	clock := StubClock{currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC)}
+	testCases := []struct {
+		name              string
+		delay             int64
+		alignToPeriod     bool
+		expectedStartTime time.Time
+		expectedEndTime   time.Time
+	}{
+		{
+			name:              "without delay",
+			expectedStartTime: time.Date(2021, 11, 20, 8, 20, 0, 0, time.UTC),
+			expectedEndTime:   time.Date(2021, 11, 20, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			name:              "metric delay",
+			delay:             3600,
+			expectedStartTime: time.Date(2021, 11, 20, 7, 20, 0, 0, time.UTC),
+			expectedEndTime:   time.Date(2021, 11, 20, 7, 30, 0, 0, time.UTC),
+		},
+		{
+			name:              "metric delay not a multiple of the period",
+			delay:             90,
+			expectedStartTime: time.Date(2021, 11, 20, 8, 18, 30, 0, time.UTC),
+			expectedEndTime:   time.Date(2021, 11, 20, 8, 28, 30, 0, time.UTC),
+		},
+		{
+			name:              "metric delay aligned to the period",
+			delay:             90,
+			alignToPeriod:     true,
+			expectedStartTime: time.Date(2021, 11, 20, 8, 20, 0, 0, time.UTC),
+			expectedEndTime:   time.Date(2021, 11, 20, 8, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			getMetricDatas := []cloudwatchData{{
+				MetricID:   aws.String("id_1"),
+				Metric:     aws.String("EstimatedCharges"),
+				Statistics: []string{"Maximum"},
+				Period:     300,
+				Delay:      tc.delay,
+			}}
+
+			input := createGetMetricDataInput(clock, getMetricDatas, aws.String("AWS/Billing"), 600, nil, tc.alignToPeriod, "", logger.NewLogrusLogger(log.StandardLogger()))
+
+			assert.Equal(t, tc.expectedStartTime, *input.StartTime)
+			assert.Equal(t, tc.expectedEndTime, *input.EndTime)
+		})
+	}
+}
+
+func Test_createGetMetricDataInput_AutoPeriod(t *testing.T) {
+	const day = 24 * 60 * 60
+	clock := StubClock{currentTime: time.Date(2021, 11, 20, 8, 33, 44, 0, time.UTC)}
+	testCases := []struct {
+		name           string
+		length         int64
+		delay          int64
+		expectedPeriod int64
+	}{
+		{
+			name:           "window within 15 days",
+			length:         14 * day,
+			expectedPeriod: 60,
+		},
+		{
+			name:           "window crossing 15 days because of its delay",
+			length:         day,
+			delay:          14*day + 3600,
+			expectedPeriod: 300,
+		},
+		{
+			name:           "15 days window rounded past 15 days",
+			length:         15 * day,
+			expectedPeriod: 300,
+		},
+		{
+			name:           "window within 63 days",
+			length:         60 * day,
+			expectedPeriod: 300,
+		},
+		{
+			name:           "window crossing 63 days",
+			length:         day,
+			delay:          63 * day,
+			expectedPeriod: 3600,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			getMetricDatas := []cloudwatchData{
+				{
+					MetricID:   aws.String("id_1"),
+					Metric:     aws.String("CPUUtilization"),
+					Statistics: []string{"Average"},
+					AutoPeriod: true,
+					Delay:      tc.delay,
+				},
+				{
+					MetricID:   aws.String("id_2"),
+					Metric:     aws.String("NetworkIn"),
+					Statistics: []string{"Sum"},
+					Period:     86400,
+					Delay:      tc.delay,
+				},
+			}
+
+			input := createGetMetricDataInput(clock, getMetricDatas, aws.String("AWS/EC2"), tc.length, nil, false, "", logger.NewLogrusLogger(log.StandardLogger()))
+
+			require.Len(t, input.MetricDataQueries, 2)
+			assert.Equal(t, tc.expectedPeriod, *input.MetricDataQueries[0].MetricStat.Period)
+			// The period of the other metrics is kept
+			assert.Equal(t, int64(86400), *input.MetricDataQueries[1].MetricStat.Period)
+		})
+	}
+}
+
+func Test_autoPeriod(t *testing.T) {
+	const day = 24 * 60 * 60
+	for age, expected := range map[int64]int64{
+		0:             60,
+		15 * day:      60,
+		15*day + 1:    300,
+		63 * day:      300,
+		63*day + 1:    3600,
+		455 * day:     3600,
+		2 * 365 * day: 3600,
+	} {
+		assert.Equal(t, expected, autoPeriod(age), "age %d", age)
+	}
+
+	// The datapoints of a metric with an automatic period are counted at the period of its window
+	data := cloudwatchData{AutoPeriod: true, Delay: 14 * day}
+	assert.Equal(t, day/60+1, data.datapointCount(day))
+	assert.Equal(t, 2*day/300+1, data.datapointCount(2*day))
+}
+
+func Test_getExpressionMetricDatas(t *testing.T) {
+	metrics := []*config.Metric{
+		{Name: "Errors", Id: "errors", Statistics: []string{"Sum"}, NilToZero: aws.Bool(false)},
+		{Name: "Invocations", Id: "invocations", Statistics: []string{"Sum"}, NilToZero: aws.Bool(false)},
+		{Name: "ErrorRate", Expression: "100 * errors / invocations", NilToZero: aws.Bool(true)},
+	}
+	dimensions := func(name string) []*cloudwatch.Dimension {
+		return []*cloudwatch.Dimension{{Name: aws.String("FunctionName"), Value: aws.String(name)}}
+	}
+	getMetricDatas := []cloudwatchData{
+		{ID: aws.String("arn:a"), MetricID: aws.String("id_1"), Region: aws.String("us-east-1"), AccountId: aws.String("123456789012"), Metric: aws.String("Errors"), Namespace: aws.String("AWS/Lambda"), Statistics: []string{"Sum"}, Dimensions: dimensions("a"), Period: 300},
+		{ID: aws.String("arn:b"), MetricID: aws.String("id_2"), Region: aws.String("us-east-1"), AccountId: aws.String("123456789012"), Metric: aws.String("Errors"), Namespace: aws.String("AWS/Lambda"), Statistics: []string{"Sum"}, Dimensions: dimensions("b"), Period: 300},
+		{ID: aws.String("arn:a"), MetricID: aws.String("id_3"), Region: aws.String("us-east-1"), AccountId: aws.String("123456789012"), Metric: aws.String("Invocations"), Namespace: aws.String("AWS/Lambda"), Statistics: []string{"Sum"}, Dimensions: dimensions("a"), Period: 60},
+	}
+
+	expressions := getExpressionMetricDatas(metrics, getMetricDatas, &metricIDs{last: 3})
+
+	// Function b has no invocations, so the expression can only be evaluated for function a
+	require.Len(t, expressions, 1)
+	expression := expressions[0]
+	assert.Equal(t, "ErrorRate", *expression.Metric)
+	assert.Equal(t, "arn:a", *expression.ID)
+	assert.Equal(t, dimensions("a"), expression.Dimensions)
+	assert.Equal(t, int64(60), expression.Period)
+	assert.True(t, *expression.NilToZero)
+	require.Len(t, expression.ExpressionInputs, 2)
+	assert.Equal(t, "Errors", *expression.ExpressionInputs[0].Metric)
+	assert.Equal(t, "Invocations", *expression.ExpressionInputs[1].Metric)
+	assert.Equal(t, fmt.Sprintf("100 * %s / %s", *expression.ExpressionInputs[0].MetricID, *expression.ExpressionInputs[1].MetricID), *expression.Expression)
+
+	input := createGetMetricDataInput(TimeClock{}, expressions, aws.String("AWS/Lambda"), 600, nil, false, "", logger.NewLogrusLogger(log.StandardLogger()))
+	require.Len(t, input.MetricDataQueries, 3)
+	assert.False(t, *input.MetricDataQueries[0].ReturnData)
+	assert.False(t, *input.MetricDataQueries[1].ReturnData)
+	assert.Equal(t, expression.MetricID, input.MetricDataQueries[2].Id)
+	assert.Equal(t, expression.Expression, input.MetricDataQueries[2].Expression)
+	assert.Nil(t, input.MetricDataQueries[2].MetricStat)
+	assert.True(t, *input.MetricDataQueries[2].ReturnData)
+
+	metricData, err := findGetMetricDataById(indexGetMetricDatasById(expressions), *expression.MetricID)
+	require.NoError(t, err)
+	setMetricDataResult(&metricData, &cloudwatch.MetricDataResult{
+		Id:         expression.MetricID,
+		Values:     []*float64{aws.Float64(12.5)},
+		Timestamps: []*time.Time{aws.Time(time.Now())},
+	})
+	promMetrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{&metricData}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	require.Len(t, promMetrics, 1)
+	assert.Equal(t, "aws_lambda_error_rate", *promMetrics[0].Name)
+	assert.Equal(t, 12.5, *promMetrics[0].Value)
+}
+
+func Test_getAnomalyBandMetricDatas(t *testing.T) {
+	metrics := []*config.Metric{
+		{Name: "CPUUtilization", Statistics: []string{"Average"}, AnomalyDetection: &config.AnomalyDetection{Band: 3}},
+		{Name: "NetworkIn", Statistics: []string{"Sum"}},
+	}
+	dimensions := []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String("i-1")}}
+	tags := []model.Tag{{Key: "Name", Value: "web"}}
+	getMetricDatas := []cloudwatchData{
+		{ID: aws.String("arn:i-1"), MetricID: aws.String("id_1"), Region: aws.String("us-east-1"), AccountId: aws.String("123456789012"), Metric: aws.String("CPUUtilization"), Namespace: aws.String("AWS/EC2"), Statistics: []string{"Average"}, Dimensions: dimensions, Tags: tags, NilToZero: aws.Bool(false), AddCloudwatchTimestamp: aws.Bool(false), Period: 300},
+		{ID: aws.String("arn:i-1"), MetricID: aws.String("id_2"), Region: aws.String("us-east-1"), AccountId: aws.String("123456789012"), Metric: aws.String("NetworkIn"), Namespace: aws.String("AWS/EC2"), Statistics: []string{"Sum"}, Dimensions: dimensions, Tags: tags, NilToZero: aws.Bool(false), AddCloudwatchTimestamp: aws.Bool(false), Period: 300},
+	}
+
+	bands := getAnomalyBandMetricDatas(metrics, getMetricDatas, &metricIDs{last: 2})
+
+	require.Len(t, bands, 1)
+	band := bands[0]
+	assert.True(t, band.AnomalyBand)
+	assert.Equal(t, "CPUUtilization", *band.Metric)
+	assert.Equal(t, dimensions, band.Dimensions)
+	assert.Equal(t, tags, band.Tags)
+	require.Len(t, band.ExpressionInputs, 1)
+	assert.NotEqual(t, "id_1", *band.ExpressionInputs[0].MetricID)
+	assert.Equal(t, fmt.Sprintf("ANOMALY_DETECTION_BAND(%s, 3)", *band.ExpressionInputs[0].MetricID), *band.Expression)
+
+	input := createGetMetricDataInput(TimeClock{}, bands, aws.String("AWS/EC2"), 600, nil, false, "", logger.NewLogrusLogger(log.StandardLogger()))
+	require.Len(t, input.MetricDataQueries, 2)
+	assert.False(t, *input.MetricDataQueries[0].ReturnData)
+	assert.Equal(t, "Average", *input.MetricDataQueries[0].MetricStat.Stat)
+	assert.Equal(t, band.Expression, input.MetricDataQueries[1].Expression)
+
+	// Both bounds are returned with the id of the band, in no particular order
+	now := time.Now()
+	var output []*cloudwatchData
+	for _, value := range []float64{20, 80} {
+		data, err := findGetMetricDataById(indexGetMetricDatasById(bands), *band.MetricID)
+		require.NoError(t, err)
+		setMetricDataResult(&data, &cloudwatch.MetricDataResult{
+			Id:         band.MetricID,
+			Values:     []*float64{aws.Float64(value)},
+			Timestamps: []*time.Time{&now},
+		})
+		output = append(output, &data)
+	}
+	output = setAnomalyBandBounds(output, logger.NewLogrusLogger(log.StandardLogger()))
+
+	promMetrics, _, err := MigrateCloudwatchToPrometheus(output, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	require.Len(t, promMetrics, 2)
+	assert.Equal(t, "aws_ec2_cpuutilization_average_anomaly_band_lower", *promMetrics[0].Name)
+	assert.Equal(t, float64(20), *promMetrics[0].Value)
+	assert.Equal(t, "aws_ec2_cpuutilization_average_anomaly_band_upper", *promMetrics[1].Name)
+	assert.Equal(t, float64(80), *promMetrics[1].Value)
+	for _, metric := range promMetrics {
+		assert.Equal(t, "i-1", metric.Labels["dimension_InstanceId"])
+		assert.Equal(t, "web", metric.Labels["tag_Name"])
+	}
+}
+
+func Test_aggregateMetricDatas(t *testing.T) {
+	metric := &config.Metric{Name: "NumberOfObjects", Statistics: []string{"Average"}, Period: 86400, Aggregate: "SUM"}
+	bucket := func(name string, storageType string) *cloudwatch.Metric {
+		return &cloudwatch.Metric{
+			MetricName: aws.String("NumberOfObjects"),
+			Namespace:  aws.String("AWS/S3"),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("BucketName"), Value: aws.String(name)},
+				{Name: aws.String("StorageType"), Value: aws.String(storageType)},
+			},
+		}
+	}
+	metricsList := []*cloudwatch.Metric{
+		bucket("orders", "AllStorageTypes"),
+		bucket("invoices", "AllStorageTypes"),
+		bucket("logs", "AllStorageTypes"),
+		bucket("tmp-1", "AllStorageTypes"),
+	}
+	// The series not meeting the dimension requirements aren't aggregated
+	requirements := []config.DimensionValueRequirement{{Name: "BucketName", ValueRegex: "^[a-z]+$"}}
+	queryIDs := &metricIDs{}
+	getMetricDatas := getFilteredMetricDatas("us-east-1", aws.String("123456789012"), "AWS/S3", nil, nil, nil, nil, nil, metricsList, config.DimensionNameRequirements{}, requirements, metric, queryIDs)
+	require.Len(t, getMetricDatas, 3)
+
+	aggregates := aggregateMetricDatas([]*config.Metric{metric}, getMetricDatas, queryIDs, logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, aggregates, 1)
+	aggregate := aggregates[0]
+	assert.True(t, aggregate.Aggregated)
+	assert.Equal(t, []*cloudwatch.Dimension{{Name: aws.String("StorageType"), Value: aws.String("AllStorageTypes")}}, aggregate.Dimensions)
+	require.Len(t, aggregate.ExpressionInputs, 3)
+	ids := make([]string, 0, len(aggregate.ExpressionInputs))
+	for _, input := range aggregate.ExpressionInputs {
+		ids = append(ids, *input.MetricID)
+	}
+	assert.Equal(t, fmt.Sprintf("SUM([%s])", strings.Join(ids, ", ")), *aggregate.Expression)
+
+	input := createGetMetricDataInput(TimeClock{}, aggregates, aws.String("AWS/S3"), 172800, nil, false, "", logger.NewLogrusLogger(log.StandardLogger()))
+	require.Len(t, input.MetricDataQueries, 4)
+	assert.Equal(t, aggregate.Expression, input.MetricDataQueries[3].Expression)
+	assert.True(t, *input.MetricDataQueries[3].ReturnData)
+
+	now := time.Now()
+	setMetricDataResult(&aggregate, &cloudwatch.MetricDataResult{
+		Id:         aggregate.MetricID,
+		Values:     []*float64{aws.Float64(1200)},
+		Timestamps: []*time.Time{&now},
+	})
+	promMetrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{&aggregate}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	require.Len(t, promMetrics, 1)
+	assert.Equal(t, "aws_s3_number_of_objects_average", *promMetrics[0].Name)
+	assert.Equal(t, float64(1200), *promMetrics[0].Value)
+	assert.Equal(t, "AllStorageTypes", promMetrics[0].Labels["dimension_StorageType"])
+	assert.NotContains(t, promMetrics[0].Labels, "dimension_BucketName")
+}
+
+func Test_setAnomalyBandBounds_IncompleteBand(t *testing.T) {
+	output := []*cloudwatchData{
+		{MetricID: aws.String("id_1"), Metric: aws.String("CPUUtilization")},
+		{MetricID: aws.String("id_2"), Metric: aws.String("CPUUtilization"), AnomalyBand: true},
+	}
+
+	filtered := setAnomalyBandBounds(output, logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, filtered, 1)
+	assert.Equal(t, "id_1", *filtered[0].MetricID)
+}
+
+func Test_dropStaleDatapoints(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := StubClock{currentTime: now}
+	point := func(age time.Duration, value float64) dataPoint {
+		return dataPoint{Value: aws.Float64(value), Timestamp: now.Add(-age)}
+	}
+	result := func(id string, points ...dataPoint) *cloudwatchData {
+		data := &cloudwatchData{MetricID: aws.String(id)}
+		if len(points) > 0 {
+			data.GetMetricDataPoint = points[0].Value
+			data.GetMetricDataTimestamps = &points[0].Timestamp
+		}
+		return data
+	}
+	withPoints := func(data *cloudwatchData, points ...dataPoint) *cloudwatchData {
+		data.GetMetricDataPoints = points
+		return data
+	}
+
+	testCases := []struct {
+		name            string
+		maxAge          time.Duration
+		output          []*cloudwatchData
+		expectedIds     []string
+		expectedValues  []float64
+		expectedDropped float64
+	}{
+		{
+			name:            "datapoints inside the window",
+			maxAge:          10 * time.Minute,
+			output:          []*cloudwatchData{result("id_1", point(5*time.Minute, 1)), result("id_2", point(10*time.Minute, 2))},
+			expectedIds:     []string{"id_1", "id_2"},
+			expectedValues:  []float64{1, 2},
+			expectedDropped: 0,
+		},
+		{
+			name:            "datapoints outside the window",
+			maxAge:          10 * time.Minute,
+			output:          []*cloudwatchData{result("id_1", point(5*time.Minute, 1)), result("id_2", point(time.Hour, 2))},
+			expectedIds:     []string{"id_1"},
+			expectedValues:  []float64{1},
+			expectedDropped: 1,
+		},
+		{
+			name:            "results without datapoint",
+			maxAge:          10 * time.Minute,
+			output:          []*cloudwatchData{result("id_1")},
+			expectedIds:     []string{"id_1"},
+			expectedValues:  []float64{},
+			expectedDropped: 0,
+		},
+		{
+			name:   "all the datapoints of the window",
+			maxAge: 10 * time.Minute,
+			output: []*cloudwatchData{
+				withPoints(result("id_1", point(time.Hour, 1)), point(time.Hour, 1), point(5*time.Minute, 2), point(time.Minute, 3)),
+				withPoints(result("id_2", point(time.Hour, 4)), point(time.Hour, 4), point(30*time.Minute, 5)),
+			},
+			expectedIds:     []string{"id_1"},
+			expectedValues:  []float64{2},
+			expectedDropped: 3,
+		},
+		{
+			name:            "disabled",
+			output:          []*cloudwatchData{result("id_1", point(24*time.Hour, 1))},
+			expectedIds:     []string{"id_1"},
+			expectedValues:  []float64{1},
+			expectedDropped: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			counter := promutil.StaleDatapointsDroppedCounter.WithLabelValues("AWS/EC2", tc.name)
+
+			fresh := dropStaleDatapoints(clock, tc.output, tc.maxAge, "AWS/EC2", tc.name)
+
+			ids := make([]string, 0, len(fresh))
+			values := make([]float64, 0, len(fresh))
+			for _, data := range fresh {
+				ids = append(ids, *data.MetricID)
+				if data.GetMetricDataPoint != nil {
+					values = append(values, *data.GetMetricDataPoint)
+				}
+				for _, point := range data.GetMetricDataPoints {
+					assert.False(t, point.Timestamp.Before(now.Add(-tc.maxAge)))
+				}
+			}
+			assert.Equal(t, tc.expectedIds, ids)
+			assert.Equal(t, tc.expectedValues, values)
+			assert.Equal(t, tc.expectedDropped, testutil.ToFloat64(counter))
+		})
+	}
+}
+
+func Test_fillMissingData(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	existing := "arn:aws:ec2:us-east-1:123456789012:instance/i-1"
+	gone := "arn:aws:ec2:us-east-1:123456789012:instance/i-2"
+	resources := []*services.TaggedResource{{ARN: existing}}
+
+	input := []cloudwatchData{
+		{ID: aws.String(existing), MetricID: aws.String("id_with_data"), MissingDataValue: aws.Float64(0)},
+		{ID: aws.String(existing), MetricID: aws.String("id_empty"), MissingDataValue: aws.Float64(-1)},
+		{ID: aws.String(existing), MetricID: aws.String("id_not_returned"), MissingDataValue: aws.Float64(0)},
+		{ID: aws.String(gone), MetricID: aws.String("id_gone"), MissingDataValue: aws.Float64(0)},
+		{ID: aws.String(existing), MetricID: aws.String("id_nil_to_zero")},
+		{ID: aws.String(existing), MetricID: aws.String("id_nil_to_zero_not_returned")},
+	}
+	output := []*cloudwatchData{
+		{ID: aws.String(existing), MetricID: aws.String("id_with_data"), MissingDataValue: aws.Float64(0), GetMetricDataPoint: aws.Float64(42), GetMetricDataTimestamps: &now},
+		{ID: aws.String(existing), MetricID: aws.String("id_empty"), MissingDataValue: aws.Float64(-1)},
+		{ID: aws.String(gone), MetricID: aws.String("id_gone"), MissingDataValue: aws.Float64(0)},
+		{ID: aws.String(existing), MetricID: aws.String("id_nil_to_zero")},
+	}
+
+	filled := fillMissingData(input, output, resources, now)
+
+	values := make(map[string]*float64, len(filled))
+	for _, data := range filled {
+		values[*data.MetricID] = data.GetMetricDataPoint
+		if data.GetMetricDataPoint != nil {
+			assert.Equal(t, now, *data.GetMetricDataTimestamps)
+		}
+	}
+	assert.Equal(t, map[string]*float64{
+		"id_with_data":    aws.Float64(42),
+		"id_empty":        aws.Float64(-1),
+		"id_not_returned": aws.Float64(0),
+		"id_nil_to_zero":  nil,
+	}, values)
+	// The input is not modified
+	assert.Nil(t, input[2].GetMetricDataPoint)
+}
+
+func Test_expandMetricNameRegexes(t *testing.T) {
+	listOf := func(names ...string) *cloudwatch.ListMetricsOutput {
+		output := &cloudwatch.ListMetricsOutput{}
+		for _, name := range names {
+			output.Metrics = append(output.Metrics, &cloudwatch.Metric{MetricName: aws.String(name)})
+		}
+		return output
+	}
+	explicit := &config.Metric{Name: "BytesIn", Statistics: []string{"Sum"}}
+	regex := &config.Metric{NameRegex: "^Bytes", MaxNameRegexMatches: 2, Statistics: []string{"Average"}}
+
+	metrics, metricsLists := expandMetricNameRegexes(
+		[]*config.Metric{explicit, regex},
+		[]*cloudwatch.ListMetricsOutput{listOf("BytesIn"), listOf("BytesIn", "BytesOut", "BytesDropped", "BytesOut", "Errors")},
+		logger.NewLogrusLogger(log.StandardLogger()),
+	)
+
+	require.Len(t, metrics, 3)
+	require.Len(t, metricsLists, 3)
+	assert.Same(t, explicit, metrics[0])
+	// Sorted by name and limited to MaxNameRegexMatches, BytesIn being listed explicitly
+	assert.Equal(t, "BytesDropped", metrics[1].Name)
+	assert.Equal(t, "BytesOut", metrics[2].Name)
+	assert.Len(t, metricsLists[2].Metrics, 2)
+	for _, metric := range metrics[1:] {
+		assert.Empty(t, metric.NameRegex)
+		assert.Equal(t, []string{"Average"}, metric.Statistics)
+	}
+	// The configured metric is not modified
+	assert.Equal(t, "^Bytes", regex.NameRegex)
+}
+
+func Test_findGetMetricDataById(t *testing.T) {
+	getMetricDatas := []cloudwatchData{
+		{MetricID: aws.String("id_1"), Metric: aws.String("CPUUtilization")},
+		{MetricID: aws.String("id_2"), Metric: aws.String("NetworkIn")},
+		{MetricID: aws.String("id_1"), Metric: aws.String("NetworkOut")},
+	}
+	index := indexGetMetricDatasById(getMetricDatas)
+
+	found, err := findGetMetricDataById(index, "id_2")
+	require.NoError(t, err)
+	assert.Equal(t, "NetworkIn", *found.Metric)
+
+	// Like the linear scan it replaces, the first query with an id wins
+	found, err = findGetMetricDataById(index, "id_1")
+	require.NoError(t, err)
+	assert.Equal(t, "CPUUtilization", *found.Metric)
+
+	// A copy is returned, results don't overwrite each other nor the queries
+	found.GetMetricDataPoint = aws.Float64(1)
+	again, err := findGetMetricDataById(index, "id_1")
+	require.NoError(t, err)
+	assert.Nil(t, again.GetMetricDataPoint)
+	assert.Nil(t, getMetricDatas[0].GetMetricDataPoint)
+
+	_, err = findGetMetricDataById(index, "id_3")
+	assert.Error(t, err)
+}
+
+func Test_compileDimensionRegexp(t *testing.T) {
+	compiled := compileDimensionRegexp(":loadbalancer/(?P<Load_Balancer>.+)$")
+	assert.Equal(t, []string{"", "Load Balancer"}, compiled.dimensions)
+	// The group names of the shared regexp are left as is
+	assert.Equal(t, []string{"", "Load_Balancer"}, compiled.regexp.SubexpNames())
+	assert.Same(t, compiled, compileDimensionRegexp(":loadbalancer/(?P<Load_Balancer>.+)$"))
+}
+
+func BenchmarkGetFilteredMetricDatas(b *testing.B) {
+	// A scrape of a metric of 500 EC2 instances with 2 statistics and 2 exported tags,
+	// ListMetrics also returning the series of 100 terminated instances.
+	//
+	// Compiling the dimension regexps on every call, growing the result and computing the
+	// settings of the metric and the tags of the resource for every query:
+	//   BenchmarkGetFilteredMetricDatas   1800000 ns/op   1928000 B/op   7700 allocs/op
+	// With the cached regexps, the preallocated result and the shared settings and tags:
+	//   BenchmarkGetFilteredMetricDatas    950000 ns/op    834000 B/op   3560 allocs/op
+	const instances = 500
+	svc := services.SupportedServices.GetService("ec2")
+	tagsOnMetrics := config.ExportedTagsOnMetrics{"ec2": {"Name", "team"}}
+	metric := &config.Metric{Name: "CPUUtilization", Statistics: []string{"Average", "Maximum"}, Period: 300}
+	requirements := []config.DimensionValueRequirement{{Name: "InstanceId", ValueRegex: "^i-"}}
+
+	resources := make([]*services.TaggedResource, 0, instances)
+	metricsList := make([]*cloudwatch.Metric, 0, instances+100)
+	for i := 0; i < instances+100; i++ {
+		instanceId := fmt.Sprintf("i-%017d", i)
+		if i < instances {
+			resources = append(resources, &services.TaggedResource{
+				ARN:       "arn:aws:ec2:us-east-1:123456789012:instance/" + instanceId,
+				Namespace: "ec2",
+				Region:    "us-east-1",
+				Tags:      []model.Tag{{Key: "Name", Value: fmt.Sprintf("web-%d", i)}, {Key: "team", Value: "payments"}},
+			})
+		}
+		metricsList = append(metricsList, &cloudwatch.Metric{
+			MetricName: aws.String("CPUUtilization"),
+			Namespace:  aws.String("AWS/EC2"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceId)}},
+		})
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		getFilteredMetricDatas("us-east-1", aws.String("123456789012"), "ec2", nil, tagsOnMetrics, svc.DimensionRegexps, svc.DimensionPriority, resources, metricsList, config.DimensionNameRequirements{}, requirements, metric, &metricIDs{})
+	}
+}
+
+func BenchmarkFindGetMetricDataById(b *testing.B) {
+	// A full partition with the default metricsPerQuery, each result being looked up once
+	const metricsPerQuery = 500
+	getMetricDatas := make([]cloudwatchData, 0, metricsPerQuery)
+	for i := 0; i < metricsPerQuery; i++ {
+		getMetricDatas = append(getMetricDatas, cloudwatchData{MetricID: aws.String(fmt.Sprintf("id_%d", i))})
+	}
+
+	b.Run("linear scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, result := range getMetricDatas {
+				for _, getMetricData := range getMetricDatas {
+					if *getMetricData.MetricID == *result.MetricID {
+						break
+					}
+				}
+			}
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			index := indexGetMetricDatasById(getMetricDatas)
+			for _, result := range getMetricDatas {
+				_, _ = findGetMetricDataById(index, *result.MetricID)
+			}
+		}
+	})
+}
+
+func Test_mergeStaticDimensions(t *testing.T) {
+	dimension := func(name, value string) *cloudwatch.Dimension {
+		return &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)}
+	}
+
+	testCases := []struct {
+		name       string
+		dimensions []*cloudwatch.Dimension
+		static     []*cloudwatch.Dimension
+		expected   []*cloudwatch.Dimension
+	}{
+		{
+			name:       "no static dimensions",
+			dimensions: []*cloudwatch.Dimension{dimension("InstanceId", "i-1")},
+			expected:   []*cloudwatch.Dimension{dimension("InstanceId", "i-1")},
+		},
+		{
+			name:       "static dimensions are appended",
+			dimensions: []*cloudwatch.Dimension{dimension("InstanceId", "i-1")},
+			static:     []*cloudwatch.Dimension{dimension("Cluster", "prod")},
+			expected:   []*cloudwatch.Dimension{dimension("InstanceId", "i-1"), dimension("Cluster", "prod")},
+		},
+		{
+			name:       "discovered dimensions win on collision",
+			dimensions: []*cloudwatch.Dimension{dimension("InstanceId", "i-1"), dimension("Cluster", "staging")},
+			static:     []*cloudwatch.Dimension{dimension("Cluster", "prod"), dimension("Team", "a")},
+			expected:   []*cloudwatch.Dimension{dimension("InstanceId", "i-1"), dimension("Cluster", "staging"), dimension("Team", "a")},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			original := append([]*cloudwatch.Dimension{}, tc.dimensions...)
+			assert.Equal(t, tc.expected, mergeStaticDimensions(tc.dimensions, tc.static))
+			assert.Equal(t, original, tc.dimensions)
+		})
+	}
+}
+
+func Test_partitionGetMetricDatas(t *testing.T) {
+	expression := cloudwatchData{Expression: aws.String("m1 / m2"), ExpressionInputs: make([]cloudwatchData, 2)}
+	getMetricDatas := []cloudwatchData{{}, {}, expression, {}}
+
+	partitions := partitionGetMetricDatas(getMetricDatas, 4, 300, logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, partitions, 2)
+	assert.Len(t, partitions[0], 2)
+	assert.Len(t, partitions[1], 2)
+	assert.NotNil(t, partitions[1][0].Expression)
+}
+
+func Test_partitionGetMetricDatas_Delays(t *testing.T) {
+	newData := func(id string, delay int64) cloudwatchData {
+		return cloudwatchData{MetricID: aws.String(id), Delay: delay}
+	}
+	testCases := []struct {
+		name        string
+		input       []cloudwatchData
+		maxQueries  int
+		expectedIds [][]string
+	}{
+		{
+			name:        "single delay",
+			input:       []cloudwatchData{newData("id_1", 300), newData("id_2", 300), newData("id_3", 300)},
+			maxQueries:  2,
+			expectedIds: [][]string{{"id_1", "id_2"}, {"id_3"}},
+		},
+		{
+			name:        "metrics grouped by delay in order",
+			input:       []cloudwatchData{newData("id_1", 0), newData("id_2", 3600), newData("id_3", 0), newData("id_4", 3600)},
+			maxQueries:  4,
+			expectedIds: [][]string{{"id_1", "id_3"}, {"id_2", "id_4"}},
+		},
+		{
+			name:        "delay groups split by the query limit",
+			input:       []cloudwatchData{newData("id_1", 0), newData("id_2", 0), newData("id_3", 0), newData("id_4", 3600)},
+			maxQueries:  2,
+			expectedIds: [][]string{{"id_1", "id_2"}, {"id_3"}, {"id_4"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			partitions := partitionGetMetricDatas(tc.input, tc.maxQueries, 300, logger.NewLogrusLogger(log.StandardLogger()))
+
+			ids := make([][]string, 0, len(partitions))
+			for _, partition := range partitions {
+				var partitionIds []string
+				for _, data := range partition {
+					assert.Equal(t, partition[0].Delay, data.Delay)
+					partitionIds = append(partitionIds, *data.MetricID)
+				}
+				ids = append(ids, partitionIds)
+			}
+			assert.Equal(t, tc.expectedIds, ids)
+		})
+	}
+}
+
+func Test_partitionGetMetricDatas_Datapoints(t *testing.T) {
+	newData := func(period int64) cloudwatchData {
+		return cloudwatchData{MetricID: aws.String("id"), Period: period}
+	}
+	expression := cloudwatchData{MetricID: aws.String("id"), Period: 60, Expression: aws.String("m1 / m2"), ExpressionInputs: []cloudwatchData{newData(60), newData(60)}}
+	testCases := []struct {
+		name            string
+		input           []cloudwatchData
+		length          int64
+		expectedSizes   []int
+		expectedQueries []int
+	}{
+		{
+			name:            "short window",
+			input:           repeatData(newData(60), 20),
+			length:          3600,
+			expectedSizes:   []int{20},
+			expectedQueries: []int{20},
+		},
+		{
+			// Every query returns 10081 datapoints over a week, so a request can hold 9 of them
+			name:            "long window with a short period",
+			input:           repeatData(newData(60), 20),
+			length:          7 * 24 * 3600,
+			expectedSizes:   []int{9, 9, 2},
+			expectedQueries: []int{9, 9, 2},
+		},
+		{
+			name:            "long window with a long period",
+			input:           repeatData(newData(3600), 20),
+			length:          7 * 24 * 3600,
+			expectedSizes:   []int{20},
+			expectedQueries: []int{20},
+		},
+		{
+			name:            "expressions aren't split from their metrics",
+			input:           repeatData(expression, 4),
+			length:          7 * 24 * 3600,
+			expectedSizes:   []int{3, 1},
+			expectedQueries: []int{9, 3},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			partitions := partitionGetMetricDatas(tc.input, 500, tc.length, logger.NewLogrusLogger(log.StandardLogger()))
+
+			sizes := make([]int, 0, len(partitions))
+			queries := make([]int, 0, len(partitions))
+			for _, partition := range partitions {
+				sizes = append(sizes, len(partition))
+				count, datapoints := 0, 0
+				for _, data := range partition {
+					count += data.queryCount()
+					datapoints += data.datapointCount(tc.length)
+				}
+				queries = append(queries, count)
+				assert.LessOrEqual(t, datapoints, maxGetMetricDataDatapoints)
+			}
+			assert.Equal(t, tc.expectedSizes, sizes)
+			assert.Equal(t, tc.expectedQueries, queries)
+		})
+	}
+}
+
+func repeatData(data cloudwatchData, n int) []cloudwatchData {
+	output := make([]cloudwatchData, n)
+	for i := range output {
+		output[i] = data
+	}
+	return output
+}
+
+func Test_partitionGetMetricDatas_StatisticExpansion(t *testing.T) {
+	resources := make([]*services.TaggedResource, 0, 150)
+	metricsList := make([]*cloudwatch.Metric, 0, 150)
+	for i := 0; i < 150; i++ {
+		id := fmt.Sprintf("fs-%d", i)
+		resources = append(resources, &services.TaggedResource{ARN: "arn:aws:elasticfilesystem:us-east-1:123123123123:file-system/" + id, Namespace: "efs", Region: "us-east-1"})
+		metricsList = append(metricsList, &cloudwatch.Metric{
+			MetricName: aws.String("StorageBytes"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("FileSystemId"), Value: aws.String(id)}},
+			Namespace:  aws.String("AWS/EFS"),
+		})
+	}
+	metric := &config.Metric{Name: "StorageBytes", Statistics: []string{"Average", "Minimum", "Maximum", "Sum", "SampleCount"}, Period: 60, Length: 60}
+
+	getMetricDatas := getFilteredMetricDatas("us-east-1", aws.String("123123123123"), "efs", nil, nil, services.SupportedServices.GetService("efs").DimensionRegexps, nil, resources, metricsList, config.DimensionNameRequirements{}, nil, metric, &metricIDs{})
+	require.Len(t, getMetricDatas, 750)
+
+	testCases := []struct {
+		name            string
+		maxQueries      int
+		expectedQueries []int
+	}{
+		{name: "every statistic is a query", maxQueries: 500, expectedQueries: []int{500, 250}},
+		{name: "partitions above the limit are capped", maxQueries: 1000, expectedQueries: []int{500, 250}},
+		{name: "small partitions", maxQueries: 300, expectedQueries: []int{300, 300, 150}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			partitions := partitionGetMetricDatas(getMetricDatas, tc.maxQueries, 60, logger.NewLogrusLogger(log.StandardLogger()))
+
+			queries := make([]int, 0, len(partitions))
+			for _, partition := range partitions {
+				count := 0
+				for _, data := range partition {
+					count += data.queryCount()
+				}
+				queries = append(queries, count)
+			}
+			assert.Equal(t, tc.expectedQueries, queries)
+		})
+	}
+}
+
+func TestClampMetricsPerQuery(t *testing.T) {
+	testCases := []struct {
+		name            string
+		metricsPerQuery int
+		expected        int
+		expectedErr     bool
+	}{
+		{name: "below the limit", metricsPerQuery: 100, expected: 100},
+		{name: "limit", metricsPerQuery: 500, expected: 500},
+		{name: "above the limit", metricsPerQuery: 2000, expected: 500},
+		{name: "zero", metricsPerQuery: 0, expectedErr: true},
+		{name: "negative", metricsPerQuery: -1, expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metricsPerQuery, err := ClampMetricsPerQuery(tc.metricsPerQuery, logger.NewLogrusLogger(log.StandardLogger()))
+			if tc.expectedErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, metricsPerQuery)
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_PercentilesAsSummary(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	newCloudwatchData := func(statistic string, value *float64) *cloudwatchData {
+		cwd := &cloudwatchData{
+			ID:                     aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/lb/1"),
+			Metric:                 aws.String("TargetResponseTime"),
+			Namespace:              aws.String("AWS/ApplicationELB"),
+			Statistics:             []string{statistic},
+			NilToZero:              aws.Bool(false),
+			AddCloudwatchTimestamp: aws.Bool(false),
+			PercentilesAsSummary:   true,
+			Region:                 aws.String("us-east-1"),
+			AccountId:              aws.String("123456789012"),
+		}
+		if value != nil {
+			cwd.GetMetricDataPoint = value
+			cwd.GetMetricDataTimestamps = &now
+		}
+		return cwd
+	}
+
+	cwd := []*cloudwatchData{
+		newCloudwatchData("p50", aws.Float64(0.1)),
+		newCloudwatchData("p99.9", aws.Float64(0.4)),
+		// Percentiles without datapoint are left out of the summary
+		newCloudwatchData("p99", nil),
+		newCloudwatchData("SampleCount", aws.Float64(42)),
+		newCloudwatchData("Sum", aws.Float64(8.4)),
+		newCloudwatchData("Average", aws.Float64(0.2)),
+	}
+
+	metrics, _, err := MigrateCloudwatchToPrometheus(cwd, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	// The sum and count are only exported in the summary, the other statistics on their own
+	assert.Equal(t, "aws_applicationelb_target_response_time_average", *metrics[0].Name)
+	assert.Equal(t, 0.2, *metrics[0].Value)
+
+	summary := metrics[1]
+	assert.Equal(t, "aws_applicationelb_target_response_time", *summary.Name)
+	require.NotNil(t, summary.Summary)
+	assert.Equal(t, map[float64]float64{0.5: 0.1, 0.999: 0.4}, summary.Summary.Quantiles)
+	assert.Equal(t, aws.Uint64(42), summary.Summary.SampleCount)
+	assert.Equal(t, aws.Float64(8.4), summary.Summary.Sum)
+
+	// The summary doesn't collide with the other series of the metric
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(promutil.NewPrometheusCollector(metrics)))
+	_, err = registry.Gather()
+	require.NoError(t, err)
+}
+
+func Test_MigrateCloudwatchToPrometheus_PartialSummary(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	newCloudwatchData := func(statistic string, value *float64) *cloudwatchData {
+		cwd := &cloudwatchData{
+			ID:                     aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/lb/1"),
+			Metric:                 aws.String("TargetResponseTime"),
+			Namespace:              aws.String("AWS/ApplicationELB"),
+			Statistics:             []string{statistic},
+			NilToZero:              aws.Bool(false),
+			AddCloudwatchTimestamp: aws.Bool(false),
+			PercentilesAsSummary:   true,
+			Region:                 aws.String("us-east-1"),
+			AccountId:              aws.String("123456789012"),
+		}
+		if value != nil {
+			cwd.GetMetricDataPoint = value
+			cwd.GetMetricDataTimestamps = &now
+		}
+		return cwd
+	}
+
+	testCases := []struct {
+		name            string
+		cwd             []*cloudwatchData
+		expectedSummary *promutil.Summary
+	}{
+		{
+			name: "sum and count without percentiles",
+			cwd: []*cloudwatchData{
+				newCloudwatchData("p99", nil),
+				newCloudwatchData("SampleCount", aws.Float64(42)),
+				newCloudwatchData("Sum", aws.Float64(8.4)),
+			},
+		},
+		{
+			name: "percentiles without sum and count",
+			cwd: []*cloudwatchData{
+				newCloudwatchData("p99", aws.Float64(0.7)),
+				newCloudwatchData("SampleCount", nil),
+				newCloudwatchData("Sum", nil),
+			},
+			expectedSummary: &promutil.Summary{Quantiles: map[float64]float64{0.99: 0.7}},
+		},
+		{
+			name: "percentiles without sum",
+			cwd: []*cloudwatchData{
+				newCloudwatchData("p99", aws.Float64(0.7)),
+				newCloudwatchData("SampleCount", aws.Float64(42)),
+			},
+			expectedSummary: &promutil.Summary{Quantiles: map[float64]float64{0.99: 0.7}, SampleCount: aws.Uint64(42)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics, observedMetricLabels, err := MigrateCloudwatchToPrometheus(tc.cwd, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			if tc.expectedSummary == nil {
+				assert.Empty(t, metrics)
+				assert.Empty(t, observedMetricLabels)
+				return
+			}
+			require.Len(t, metrics, 1)
+			assert.Equal(t, tc.expectedSummary, metrics[0].Summary)
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_DropNoData(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	value := aws.Float64(3)
+
+	testCases := []struct {
+		name                   string
+		dropNoData             bool
+		nilToZero              bool
+		addCloudwatchTimestamp bool
+		datapoint              *float64
+		expectedValue          *float64 // nil when no series is exported
+		expectNaN              bool
+	}{
+		{name: "no data is exported as NaN", expectNaN: true},
+		{name: "no data with NilToZero is exported as zero", nilToZero: true, expectedValue: aws.Float64(0)},
+		{name: "no data with AddCloudwatchTimestamp is not exported", addCloudwatchTimestamp: true},
+		{name: "no data with AddCloudwatchTimestamp takes precedence over NilToZero", addCloudwatchTimestamp: true, nilToZero: true},
+		{name: "no data with DropNoData is not exported", dropNoData: true},
+		{name: "DropNoData takes precedence over NilToZero", dropNoData: true, nilToZero: true},
+		{name: "DropNoData with AddCloudwatchTimestamp is not exported", dropNoData: true, addCloudwatchTimestamp: true},
+		{name: "DropNoData takes precedence over all", dropNoData: true, nilToZero: true, addCloudwatchTimestamp: true},
+		{name: "data is exported", datapoint: value, expectedValue: value},
+		{name: "data with DropNoData is exported", dropNoData: true, datapoint: value, expectedValue: value},
+		{name: "data with DropNoData and NilToZero is exported", dropNoData: true, nilToZero: true, datapoint: value, expectedValue: value},
+		{name: "data with all options is exported", dropNoData: true, nilToZero: true, addCloudwatchTimestamp: true, datapoint: value, expectedValue: value},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwd := &cloudwatchData{
+				ID:                     aws.String("arn:aws:sqs:us-east-1:123456789012:queue"),
+				Metric:                 aws.String("NumberOfMessagesSent"),
+				Namespace:              aws.String("AWS/SQS"),
+				Statistics:             []string{"Sum"},
+				NilToZero:              aws.Bool(tc.nilToZero),
+				AddCloudwatchTimestamp: aws.Bool(tc.addCloudwatchTimestamp),
+				DropNoData:             tc.dropNoData,
+				Region:                 aws.String("us-east-1"),
+				AccountId:              aws.String("123456789012"),
+			}
+			if tc.datapoint != nil {
+				cwd.GetMetricDataPoint = tc.datapoint
+				cwd.GetMetricDataTimestamps = &now
+			}
+
+			metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+
+			switch {
+			case tc.expectNaN:
+				require.Len(t, metrics, 1)
+				assert.True(t, math.IsNaN(*metrics[0].Value))
+			case tc.expectedValue != nil:
+				require.Len(t, metrics, 1)
+				assert.Equal(t, *tc.expectedValue, *metrics[0].Value)
+			default:
+				assert.Empty(t, metrics)
+			}
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_ExportTimestamp(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	seconds := float64(now.Unix())
+
+	testCases := []struct {
+		name                   string
+		exportTimestamp        bool
+		addCloudwatchTimestamp bool
+		nilToZero              bool
+		counter                bool
+		datapoint              *float64
+		expected               map[string]float64
+		expectedTimestamped    []string
+	}{
+		{
+			name:      "disabled",
+			datapoint: aws.Float64(3),
+			expected:  map[string]float64{"aws_sqs_number_of_messages_sent_sum": 3},
+		},
+		{
+			name:            "timestamp exported",
+			exportTimestamp: true,
+			datapoint:       aws.Float64(3),
+			expected: map[string]float64{
+				"aws_sqs_number_of_messages_sent_sum":                   3,
+				"aws_sqs_number_of_messages_sent_sum_timestamp_seconds": seconds,
+			},
+		},
+		{
+			name:                   "timestamp exported with AddCloudwatchTimestamp",
+			exportTimestamp:        true,
+			addCloudwatchTimestamp: true,
+			datapoint:              aws.Float64(3),
+			expected: map[string]float64{
+				"aws_sqs_number_of_messages_sent_sum":                   3,
+				"aws_sqs_number_of_messages_sent_sum_timestamp_seconds": seconds,
+			},
+			expectedTimestamped: []string{"aws_sqs_number_of_messages_sent_sum"},
+		},
+		{
+			name:            "timestamp of counters not suffixed by _total",
+			exportTimestamp: true,
+			counter:         true,
+			datapoint:       aws.Float64(3),
+			expected: map[string]float64{
+				"aws_sqs_number_of_messages_sent_sum_total":             3,
+				"aws_sqs_number_of_messages_sent_sum_timestamp_seconds": seconds,
+			},
+		},
+		{
+			name:            "no timestamp without datapoint",
+			exportTimestamp: true,
+			nilToZero:       true,
+			expected:        map[string]float64{"aws_sqs_number_of_messages_sent_sum": 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwd := &cloudwatchData{
+				ID:                     aws.String("arn:aws:sqs:us-east-1:123456789012:queue"),
+				Metric:                 aws.String("NumberOfMessagesSent"),
+				Namespace:              aws.String("AWS/SQS"),
+				Statistics:             []string{"Sum"},
+				NilToZero:              aws.Bool(tc.nilToZero),
+				AddCloudwatchTimestamp: aws.Bool(tc.addCloudwatchTimestamp),
+				ExportTimestamp:        tc.exportTimestamp,
+				Counter:                tc.counter,
+				Region:                 aws.String("us-east-1"),
+				AccountId:              aws.String("123456789012"),
+			}
+			if tc.datapoint != nil {
+				cwd.GetMetricDataPoint = tc.datapoint
+				cwd.GetMetricDataTimestamps = &now
+			}
+
+			metrics, observedMetricLabels, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+
+			values := make(map[string]float64, len(metrics))
+			var timestamped []string
+			for _, metric := range metrics {
+				values[*metric.Name] = *metric.Value
+				assert.Equal(t, "arn:aws:sqs:us-east-1:123456789012:queue", metric.Labels["name"])
+				assert.Contains(t, observedMetricLabels, *metric.Name)
+				if metric.IncludeTimestamp {
+					timestamped = append(timestamped, *metric.Name)
+				}
+			}
+			assert.Equal(t, tc.expected, values)
+			assert.Equal(t, tc.expectedTimestamped, timestamped)
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_MetricPrefixAndRenames(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name              string
+		namespace         string
+		metricPrefix      string
+		metricRenames     map[string]string
+		exportedNamespace string
+		expectedName      string
+	}{
+		{
+			name:         "default name",
+			expectedName: "aws_ec2_cpuutilization_average",
+		},
+		{
+			name:         "namespace with slashes and dots",
+			namespace:    "Custom/App.v2",
+			expectedName: "aws_custom_app_v2_cpuutilization_average",
+		},
+		{
+			name:              "exported namespace",
+			namespace:         "Custom/App.v2",
+			exportedNamespace: "app",
+			expectedName:      "app_cpuutilization_average",
+		},
+		{
+			name:              "prefix, rename and exported namespace",
+			namespace:         "Custom/App.v2",
+			metricPrefix:      "team_a",
+			metricRenames:     map[string]string{"CPUUtilization": "cpu_usage"},
+			exportedNamespace: "app",
+			expectedName:      "team_a_app_cpu_usage_average",
+		},
+		{
+			name:         "prefix",
+			metricPrefix: "team_a",
+			expectedName: "team_a_aws_ec2_cpuutilization_average",
+		},
+		{
+			name:          "rename",
+			metricRenames: map[string]string{"CPUUtilization": "CpuUsage"},
+			expectedName:  "aws_ec2_cpu_usage_average",
+		},
+		{
+			name:          "rename of another metric",
+			metricRenames: map[string]string{"NetworkIn": "network_received"},
+			expectedName:  "aws_ec2_cpuutilization_average",
+		},
+		{
+			name:          "prefix and rename",
+			metricPrefix:  "team-a",
+			metricRenames: map[string]string{"CPUUtilization": "cpu_usage"},
+			expectedName:  "team_a_aws_ec2_cpu_usage_average",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			namespace := "AWS/EC2"
+			if tc.namespace != "" {
+				namespace = tc.namespace
+			}
+			cwd := &cloudwatchData{
+				ID:                      aws.String("arn:aws:ec2:us-east-1:123456789012:instance/i-1"),
+				Metric:                  aws.String("CPUUtilization"),
+				Namespace:               aws.String(namespace),
+				Statistics:              []string{"Average"},
+				NilToZero:               aws.Bool(false),
+				AddCloudwatchTimestamp:  aws.Bool(false),
+				MetricPrefix:            tc.metricPrefix,
+				MetricRenames:           tc.metricRenames,
+				ExportedNamespace:       tc.exportedNamespace,
+				Dimensions:              []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String("i-1")}},
+				Region:                  aws.String("us-east-1"),
+				AccountId:               aws.String("123456789012"),
+				GetMetricDataPoint:      aws.Float64(42),
+				GetMetricDataTimestamps: &now,
+			}
+
+			metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			assert.Equal(t, tc.expectedName, *metrics[0].Name)
+			// Labels are built from the dimensions, regardless of the metric name
+			assert.Equal(t, "i-1", metrics[0].Labels["dimension_InstanceId"])
+		})
+	}
+}
+
+// countingCloudwatchAPI returns two pages of GetMetricData results, or throttling errors when throttled is set
+type countingCloudwatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	throttled bool
+}
+
+func (c countingCloudwatchAPI) GetMetricDataPagesWithContext(_ aws.Context, _ *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, _ ...request.Option) error {
+	if c.throttled {
+		return awserr.New("Throttling", "Rate exceeded", nil)
+	}
+	if fn(&cloudwatch.GetMetricDataOutput{}, false) {
+		fn(&cloudwatch.GetMetricDataOutput{}, true)
+	}
+	return nil
+}
+
+func (c countingCloudwatchAPI) ListMetricsPagesWithContext(_ aws.Context, _ *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	if c.throttled {
+		return awserr.New("Throttling", "Rate exceeded", nil)
+	}
+	fn(&cloudwatch.ListMetricsOutput{}, true)
+	return nil
+}
+
+func Test_cloudwatchInterface_APICounters(t *testing.T) {
+	const region = "ap-southeast-7"
+	l := logger.NewLogrusLogger(log.StandardLogger())
+	requests := func(api string) float64 {
+		return testutil.ToFloat64(promutil.CloudwatchAPICounter.WithLabelValues(api, region))
+	}
+	throttles := func(api string) float64 {
+		return testutil.ToFloat64(promutil.CloudwatchAPIThrottleCounter.WithLabelValues(api, region))
+	}
+
+	iface := cloudwatchInterface{client: countingCloudwatchAPI{}, region: region, logger: l}
+	_, err := iface.getMetricData(context.Background(), &cloudwatch.GetMetricDataInput{})
+	require.NoError(t, err)
+	_, err = getFullMetricsList(context.Background(), "AWS/EC2", &config.Metric{Name: "CPUUtilization"}, nil, iface)
+	require.NoError(t, err)
+
+	// Every page is a request
+	assert.Equal(t, float64(2), requests("GetMetricData"))
+	assert.Equal(t, float64(1), requests("ListMetrics"))
+	assert.Equal(t, float64(0), throttles("GetMetricData"))
+
+	iface.client = countingCloudwatchAPI{throttled: true}
+	_, err = iface.getMetricData(context.Background(), &cloudwatch.GetMetricDataInput{})
+	require.Error(t, err)
+	_, err = getFullMetricsList(context.Background(), "AWS/EC2", &config.Metric{Name: "CPUUtilization"}, nil, iface)
+	require.Error(t, err)
+
+	assert.Equal(t, float64(2), requests("GetMetricData"))
+	assert.Equal(t, float64(1), throttles("GetMetricData"))
+	assert.Equal(t, float64(1), throttles("ListMetrics"))
+}
+
+// pagedCloudwatchAPI returns the GetMetricData results in pages, following NextToken like the SDK does
+type pagedCloudwatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	pages []*cloudwatch.GetMetricDataOutput
+}
+
+func (c pagedCloudwatchAPI) GetMetricDataPagesWithContext(_ aws.Context, _ *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, _ ...request.Option) error {
+	for i, page := range c.pages {
+		if !fn(page, i == len(c.pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func Test_getMetricData_Pagination(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Minute)
+	newResult := func(id string, value float64, timestamp time.Time, statusCode string) *cloudwatch.MetricDataResult {
+		return &cloudwatch.MetricDataResult{Id: aws.String(id), Values: []*float64{aws.Float64(value)}, Timestamps: []*time.Time{aws.Time(timestamp)}, StatusCode: aws.String(statusCode)}
+	}
+
+	testCases := []struct {
+		name     string
+		pages    []*cloudwatch.GetMetricDataOutput
+		expected map[string][]float64
+	}{
+		{
+			name: "ids on different pages",
+			pages: []*cloudwatch.GetMetricDataOutput{
+				{MetricDataResults: []*cloudwatch.MetricDataResult{newResult("id_1", 1, now, "Complete"), newResult("id_2", 2, now, "Complete")}, NextToken: aws.String("page-2")},
+				{MetricDataResults: []*cloudwatch.MetricDataResult{newResult("id_3", 3, now, "Complete")}},
+			},
+			expected: map[string][]float64{"id_1": {1}, "id_2": {2}, "id_3": {3}},
+		},
+		{
+			name: "id split across two pages",
+			pages: []*cloudwatch.GetMetricDataOutput{
+				{MetricDataResults: []*cloudwatch.MetricDataResult{newResult("id_1", 1, now, "Complete"), newResult("id_2", 2, now, "PartialData")}, NextToken: aws.String("page-2")},
+				{MetricDataResults: []*cloudwatch.MetricDataResult{newResult("id_2", 3, before, "Complete")}},
+			},
+			expected: map[string][]float64{"id_1": {1}, "id_2": {2, 3}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			iface := cloudwatchInterface{client: pagedCloudwatchAPI{pages: tc.pages}, logger: logger.NewLogrusLogger(log.StandardLogger())}
+
+			output, err := iface.getMetricData(context.Background(), &cloudwatch.GetMetricDataInput{})
+			require.NoError(t, err)
+
+			values := make(map[string][]float64)
+			for _, result := range output.MetricDataResults {
+				require.NotContains(t, values, *result.Id, "duplicate result")
+				require.Len(t, result.Timestamps, len(result.Values))
+				// The merged results aren't partial
+				assert.Equal(t, "Complete", *result.StatusCode)
+				for _, value := range result.Values {
+					values[*result.Id] = append(values[*result.Id], *value)
+				}
+			}
+			assert.Equal(t, tc.expected, values)
+			// The results of the pages are left untouched
+			assert.Len(t, tc.pages[0].MetricDataResults[1].Values, 1)
+		})
+	}
+}
+
+// pagedListMetricsAPI returns the listed metrics in pages, following NextToken like the SDK does
+type pagedListMetricsAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	pages  []*cloudwatch.ListMetricsOutput
+	inputs []*cloudwatch.ListMetricsInput
+}
+
+func (c *pagedListMetricsAPI) ListMetricsPagesWithContext(_ aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	c.inputs = append(c.inputs, input)
+	for i, page := range c.pages {
+		if !fn(page, i == len(c.pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func Test_getFullMetricsList_Pagination(t *testing.T) {
+	newMetric := func(instanceId string) *cloudwatch.Metric {
+		return &cloudwatch.Metric{
+			Namespace:  aws.String("AWS/EC2"),
+			MetricName: aws.String("CPUUtilization"),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instanceId)}},
+		}
+	}
+	testCases := []struct {
+		name                   string
+		recentlyActiveOnly     bool
+		expectedRecentlyActive *string
+	}{
+		{name: "all metrics"},
+		{name: "recently active metrics", recentlyActiveOnly: true, expectedRecentlyActive: aws.String("PT3H")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &pagedListMetricsAPI{pages: []*cloudwatch.ListMetricsOutput{
+				{Metrics: []*cloudwatch.Metric{newMetric("i-1"), newMetric("i-2")}, NextToken: aws.String("page-2")},
+				{Metrics: []*cloudwatch.Metric{newMetric("i-3")}, NextToken: aws.String("page-3")},
+				{Metrics: []*cloudwatch.Metric{newMetric("i-4")}},
+			}}
+			iface := cloudwatchInterface{client: api, logger: logger.NewLogrusLogger(log.StandardLogger()), recentlyActiveOnly: tc.recentlyActiveOnly}
+
+			output, err := getFullMetricsList(context.Background(), "AWS/EC2", &config.Metric{Name: "CPUUtilization"}, nil, iface)
+			require.NoError(t, err)
+
+			var instanceIds []string
+			for _, metric := range output.Metrics {
+				instanceIds = append(instanceIds, *metric.Dimensions[0].Value)
+			}
+			assert.Equal(t, []string{"i-1", "i-2", "i-3", "i-4"}, instanceIds)
+			require.Len(t, api.inputs, 1)
+			assert.Equal(t, tc.expectedRecentlyActive, api.inputs[0].RecentlyActive)
+		})
+	}
+}
+
+// partialDataCloudwatchAPI returns id_2 as PartialData on the first call, and Complete on the next ones
+type partialDataCloudwatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	inputs []*cloudwatch.GetMetricDataInput
+}
+
+func (c *partialDataCloudwatchAPI) GetMetricDataPagesWithContext(_ aws.Context, input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, _ ...request.Option) error {
+	c.inputs = append(c.inputs, input)
+	now := time.Now()
+	var results []*cloudwatch.MetricDataResult
+	for _, query := range input.MetricDataQueries {
+		result := &cloudwatch.MetricDataResult{
+			Id:         query.Id,
+			StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+			Values:     []*float64{aws.Float64(float64(len(c.inputs)))},
+			Timestamps: []*time.Time{&now},
+		}
+		if *query.Id == "id_2" && len(c.inputs) == 1 {
+			result.StatusCode = aws.String(cloudwatch.StatusCodePartialData)
+			result.Messages = []*cloudwatch.MessageData{{Code: aws.String("MaxMetricsExceeded"), Value: aws.String("Maximum number of allowed metrics exceeded")}}
+		}
+		results = append(results, result)
+	}
+	fn(&cloudwatch.GetMetricDataOutput{MetricDataResults: results}, true)
+	return nil
+}
+
+func Test_getMetricData_PartialData(t *testing.T) {
+	testCases := []struct {
+		name           string
+		region         string
+		retry          config.Retry
+		expectedCalls  int
+		expectedStatus string
+		expectedValue  float64
+	}{
+		{
+			name:           "partial data is kept by default",
+			region:         "partial-data-1",
+			expectedCalls:  1,
+			expectedStatus: cloudwatch.StatusCodePartialData,
+			expectedValue:  1,
+		},
+		{
+			name:           "partial data is queried again",
+			region:         "partial-data-2",
+			retry:          config.Retry{PartialData: true},
+			expectedCalls:  2,
+			expectedStatus: cloudwatch.StatusCodeComplete,
+			expectedValue:  2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &partialDataCloudwatchAPI{}
+			iface := cloudwatchInterface{client: api, region: tc.region, retry: tc.retry, logger: logger.NewLogrusLogger(log.StandardLogger())}
+			filter := &cloudwatch.GetMetricDataInput{MetricDataQueries: []*cloudwatch.MetricDataQuery{
+				{Id: aws.String("id_1"), ReturnData: aws.Bool(true)},
+				{Id: aws.String("id_2"), ReturnData: aws.Bool(true)},
+			}}
+
+			output, err := iface.getMetricData(context.Background(), filter)
+			require.NoError(t, err)
+			require.Len(t, api.inputs, tc.expectedCalls)
+			assert.Equal(t, float64(1), testutil.ToFloat64(promutil.MetricDataPartialCounter.WithLabelValues(cloudwatch.StatusCodePartialData, tc.region)))
+
+			results := map[string]*cloudwatch.MetricDataResult{}
+			for _, result := range output.MetricDataResults {
+				results[*result.Id] = result
+			}
+			require.Len(t, results, 2)
+			assert.Equal(t, float64(1), *results["id_1"].Values[0])
+			assert.Equal(t, tc.expectedStatus, *results["id_2"].StatusCode)
+			assert.Equal(t, tc.expectedValue, *results["id_2"].Values[0])
+			if tc.expectedCalls > 1 {
+				// Only the partial result is queried again
+				require.Len(t, api.inputs[1].MetricDataQueries, 1)
+				assert.Equal(t, "id_2", *api.inputs[1].MetricDataQueries[0].Id)
+			}
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_PercentilesAsLabels(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	newCloudwatchData := func(statistic string, value float64, percentilesAsLabels bool) *cloudwatchData {
+		return &cloudwatchData{
+			ID:                      aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/lb/1"),
+			Metric:                  aws.String("TargetResponseTime"),
+			Namespace:               aws.String("AWS/ApplicationELB"),
+			Statistics:              []string{statistic},
+			NilToZero:               aws.Bool(false),
+			AddCloudwatchTimestamp:  aws.Bool(false),
+			PercentilesAsLabels:     percentilesAsLabels,
+			Region:                  aws.String("us-east-1"),
+			AccountId:               aws.String("123456789012"),
+			GetMetricDataPoint:      aws.Float64(value),
+			GetMetricDataTimestamps: &now,
+		}
+	}
+
+	testCases := []struct {
+		name                string
+		statistic           string
+		percentilesAsLabels bool
+		expectedName        string
+		expectedQuantile    string
+	}{
+		{
+			name:         "percentile as suffix by default",
+			statistic:    "p99.9",
+			expectedName: "aws_applicationelb_target_response_time_p99_9",
+		},
+		{
+			name:                "percentile as label",
+			statistic:           "p99.9",
+			percentilesAsLabels: true,
+			expectedName:        "aws_applicationelb_target_response_time",
+			expectedQuantile:    "0.999",
+		},
+		{
+			name:                "integer percentile as label",
+			statistic:           "p50",
+			percentilesAsLabels: true,
+			expectedName:        "aws_applicationelb_target_response_time",
+			expectedQuantile:    "0.5",
+		},
+		{
+			name:                "standard statistic is unaffected",
+			statistic:           "Average",
+			percentilesAsLabels: true,
+			expectedName:        "aws_applicationelb_target_response_time_average",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwd := []*cloudwatchData{newCloudwatchData(tc.statistic, 0.2, tc.percentilesAsLabels)}
+
+			metrics, _, err := MigrateCloudwatchToPrometheus(cwd, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+
+			assert.Equal(t, tc.expectedName, *metrics[0].Name)
+			quantile, ok := metrics[0].Labels["quantile"]
+			assert.Equal(t, tc.expectedQuantile != "", ok)
+			assert.Equal(t, tc.expectedQuantile, quantile)
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_SampleCountAsCount(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	newCloudwatchData := func(statistic string, value float64, sampleCountAsCount bool) *cloudwatchData {
+		return &cloudwatchData{
+			ID:                      aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/lb/1"),
+			Metric:                  aws.String("TargetResponseTime"),
+			Namespace:               aws.String("AWS/ApplicationELB"),
+			Statistics:              []string{statistic},
+			NilToZero:               aws.Bool(false),
+			AddCloudwatchTimestamp:  aws.Bool(false),
+			SampleCountAsCount:      sampleCountAsCount,
+			Region:                  aws.String("us-east-1"),
+			AccountId:               aws.String("123456789012"),
+			GetMetricDataPoint:      aws.Float64(value),
+			GetMetricDataTimestamps: &now,
+		}
+	}
+
+	testCases := []struct {
+		name               string
+		sampleCountAsCount bool
+		expected           map[string]float64
+	}{
+		{
+			name: "sample_count suffix by default",
+			expected: map[string]float64{
+				"aws_applicationelb_target_response_time_sum":          12.5,
+				"aws_applicationelb_target_response_time_sample_count": 50,
+			},
+		},
+		{
+			name:               "count suffix paired with the sum",
+			sampleCountAsCount: true,
+			expected: map[string]float64{
+				"aws_applicationelb_target_response_time_sum":   12.5,
+				"aws_applicationelb_target_response_time_count": 50,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwd := []*cloudwatchData{
+				newCloudwatchData("Sum", 12.5, tc.sampleCountAsCount),
+				newCloudwatchData("SampleCount", 50, tc.sampleCountAsCount),
+			}
+
+			metrics, _, err := MigrateCloudwatchToPrometheus(cwd, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+
+			values := make(map[string]float64, len(metrics))
+			for _, metric := range metrics {
+				values[*metric.Name] = *metric.Value
+			}
+			assert.Equal(t, tc.expected, values)
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_ExportUnit(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name         string
+		unit         *string
+		points       []*cloudwatch.Datapoint
+		exportUnit   bool
+		expectedUnit string
+	}{
+		{
+			name:         "requested unit",
+			unit:         aws.String("Bytes"),
+			exportUnit:   true,
+			expectedUnit: "Bytes",
+		},
+		{
+			name:       "unit is not exported by default",
+			unit:       aws.String("Bytes"),
+			exportUnit: false,
+		},
+		{
+			name:       "metric without unit",
+			exportUnit: true,
+		},
+		{
+			name: "unit of the most recent datapoint",
+			points: []*cloudwatch.Datapoint{
+				{Timestamp: aws.Time(now.Add(-time.Minute)), Average: aws.Float64(1), Unit: aws.String("Bytes")},
+				{Timestamp: aws.Time(now), Average: aws.Float64(2), Unit: aws.String("Percent")},
+			},
+			exportUnit:   true,
+			expectedUnit: "Percent",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwd := &cloudwatchData{
+				ID:                     aws.String("arn:aws:ec2:us-east-1:123456789012:instance/i-1"),
+				Metric:                 aws.String("CPUUtilization"),
+				Namespace:              aws.String("AWS/EC2"),
+				Statistics:             []string{"Average"},
+				NilToZero:              aws.Bool(false),
+				AddCloudwatchTimestamp: aws.Bool(false),
+				Unit:                   tc.unit,
+				ExportUnit:             tc.exportUnit,
+				Region:                 aws.String("us-east-1"),
+				AccountId:              aws.String("123456789012"),
+			}
+			if tc.points != nil {
+				cwd.Points = tc.points
+				cwd.Unit = datapointsUnit(tc.points)
+			} else {
+				cwd.GetMetricDataPoint = aws.Float64(1)
+				cwd.GetMetricDataTimestamps = &now
+			}
+
+			metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+
+			unit, ok := metrics[0].Labels["unit"]
+			assert.Equal(t, tc.expectedUnit != "", ok)
+			assert.Equal(t, tc.expectedUnit, unit)
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_Role(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	newData := func(role *string) *cloudwatchData {
+		return &cloudwatchData{
+			ID:                      aws.String("arn:aws:ec2:us-east-1:123456789012:instance/i-1"),
+			Metric:                  aws.String("CPUUtilization"),
+			Namespace:               aws.String("AWS/EC2"),
+			Statistics:              []string{"Average"},
+			NilToZero:               aws.Bool(false),
+			AddCloudwatchTimestamp:  aws.Bool(false),
+			GetMetricDataPoint:      aws.Float64(42),
+			GetMetricDataTimestamps: &now,
+			Region:                  aws.String("us-east-1"),
+			AccountId:               aws.String("123456789012"),
+			Role:                    role,
+		}
+	}
+
+	withoutRole, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{newData(nil)}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	withRole, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{newData(aws.String("shared-services"))}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	require.Len(t, withoutRole, 1)
+	require.Len(t, withRole, 1)
+
+	assert.NotContains(t, withoutRole[0].Labels, "role")
+	assert.Equal(t, "shared-services", withRole[0].Labels["role"])
+	// Only the role label is added
+	delete(withRole[0].Labels, "role")
+	assert.Equal(t, withoutRole[0].Labels, withRole[0].Labels)
+	assert.Equal(t, *withoutRole[0].Name, *withRole[0].Name)
+	assert.Equal(t, *withoutRole[0].Value, *withRole[0].Value)
+}
+
+func Test_MigrateCloudwatchToPrometheus_LabelAs(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		label         *string
+		expectedLabel string
+	}{
+		{
+			name:          "resolved label",
+			label:         aws.String("i-1 c5.large"),
+			expectedLabel: "i-1 c5.large",
+		},
+		{
+			name:  "unresolved placeholder",
+			label: aws.String("i-1 ${PROP('Dim.InstanceType')}"),
+		},
+		{
+			name:  "empty label",
+			label: aws.String(""),
+		},
+		{
+			name: "no label returned",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwd := &cloudwatchData{
+				ID:                     aws.String("arn:aws:ec2:us-east-1:123456789012:instance/i-1"),
+				MetricID:               aws.String("id_1"),
+				Metric:                 aws.String("CPUUtilization"),
+				Namespace:              aws.String("AWS/EC2"),
+				Statistics:             []string{"Average"},
+				NilToZero:              aws.Bool(false),
+				AddCloudwatchTimestamp: aws.Bool(false),
+				Label:                  aws.String("${PROP('Dim.InstanceId')} ${PROP('Dim.InstanceType')}"),
+				LabelAs:                "instance",
+				Region:                 aws.String("us-east-1"),
+				AccountId:              aws.String("123456789012"),
+			}
+			setMetricDataResult(cwd, &cloudwatch.MetricDataResult{
+				Id:         aws.String("id_1"),
+				Label:      tc.label,
+				Values:     []*float64{aws.Float64(1)},
+				Timestamps: []*time.Time{&now},
+			})
+
+			metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+
+			label, ok := metrics[0].Labels["instance"]
+			assert.Equal(t, tc.expectedLabel != "", ok)
+			assert.Equal(t, tc.expectedLabel, label)
+		})
+	}
+}
+
+func Test_createPrometheusLabels_LabelSanitization(t *testing.T) {
+	longValue := strings.Repeat("x", 20)
+
+	testCases := []struct {
+		name              string
+		labelSanitization config.LabelSanitization
+		expected          map[string]string
+	}{
+		{
+			name: "default policy replaces invalid characters",
+			expected: map[string]string{
+				"dimension_BucketName": "bucket_1",
+				"tag_Team":             "équipe",
+				"custom_tag_Owner":     longValue,
+			},
+		},
+		{
+			name:              "replace policy truncates long values",
+			labelSanitization: config.LabelSanitization{Policy: config.LabelSanitizationReplace, MaxLength: 10},
+			expected: map[string]string{
+				"dimension_BucketName": "bucket_1",
+				"tag_Team":             "équipe",
+				"custom_tag_Owner":     longValue[:10],
+			},
+		},
+		{
+			name:              "drop policy drops the labels",
+			labelSanitization: config.LabelSanitization{Policy: config.LabelSanitizationDrop, MaxLength: 10},
+			expected: map[string]string{
+				"tag_Team": "équipe",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			labels := createPrometheusLabels(&cloudwatchData{
+				ID:         aws.String("arn:aws:s3:::bucket"),
+				Region:     aws.String("us-east-1"),
+				AccountId:  aws.String("123456789012"),
+				Dimensions: []*cloudwatch.Dimension{{Name: aws.String("BucketName"), Value: aws.String("bucket\n1")}},
+				Tags:       []model.Tag{{Key: "Team", Value: "équipe"}},
+				CustomTags: []model.Tag{{Key: "Owner", Value: longValue}},
+			}, false, tc.labelSanitization, logger.NewLogrusLogger(log.StandardLogger()))
+
+			delete(labels, "name")
+			delete(labels, "region")
+			delete(labels, "account_id")
+			assert.Equal(t, tc.expected, labels)
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_Transform(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	scale := 0.5
+	milli := 1e-3
+
+	testCases := []struct {
+		name          string
+		metric        config.Metric
+		statistic     string
+		nilToZero     bool
+		datapoint     *float64
+		expectedValue float64
+		expectNaN     bool
+	}{
+		{
+			name:          "no transform",
+			metric:        config.Metric{},
+			statistic:     "Average",
+			datapoint:     aws.Float64(10),
+			expectedValue: 10,
+		},
+		{
+			name:          "scale",
+			metric:        config.Metric{Transform: &config.Transform{Scale: &scale}},
+			statistic:     "Average",
+			datapoint:     aws.Float64(10),
+			expectedValue: 5,
+		},
+		{
+			name:          "offset",
+			metric:        config.Metric{Transform: &config.Transform{Offset: 3}},
+			statistic:     "Average",
+			datapoint:     aws.Float64(10),
+			expectedValue: 13,
+		},
+		{
+			name:          "scale and offset",
+			metric:        config.Metric{Transform: &config.Transform{Scale: &scale, Offset: 3}},
+			statistic:     "Average",
+			datapoint:     aws.Float64(10),
+			expectedValue: 8,
+		},
+		{
+			name:          "conversion",
+			metric:        config.Metric{Transform: &config.Transform{Conversion: "bytesToKiB"}},
+			statistic:     "Sum",
+			datapoint:     aws.Float64(2048),
+			expectedValue: 2,
+		},
+		{
+			name:          "SampleCount is not transformed",
+			metric:        config.Metric{Transform: &config.Transform{Scale: &scale}},
+			statistic:     "SampleCount",
+			datapoint:     aws.Float64(10),
+			expectedValue: 10,
+		},
+		{
+			name: "statistic transform",
+			metric: config.Metric{
+				Transform:         &config.Transform{Scale: &scale},
+				StatisticSettings: map[string]*config.StatisticSettings{"p99": {Transform: &config.Transform{Scale: &milli}}},
+			},
+			statistic:     "p99",
+			datapoint:     aws.Float64(1500),
+			expectedValue: 1.5,
+		},
+		{
+			name:          "NilToZero zero is not transformed",
+			metric:        config.Metric{Transform: &config.Transform{Offset: 3}},
+			statistic:     "Average",
+			nilToZero:     true,
+			expectedValue: 0,
+		},
+		{
+			name:      "NaN is not transformed",
+			metric:    config.Metric{Transform: &config.Transform{Offset: 3}},
+			statistic: "Average",
+			expectNaN: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cwd := &cloudwatchData{
+				ID:                     aws.String("arn:aws:ec2:us-east-1:123456789012:instance/i-1"),
+				Metric:                 aws.String("CPUUtilization"),
+				Namespace:              aws.String("AWS/EC2"),
+				Statistics:             []string{tc.statistic},
+				NilToZero:              aws.Bool(tc.nilToZero),
+				AddCloudwatchTimestamp: aws.Bool(false),
+				Transforms:             metricTransforms(&tc.metric, []string{tc.statistic}),
+				Region:                 aws.String("us-east-1"),
+				AccountId:              aws.String("123456789012"),
+			}
+			if tc.datapoint != nil {
+				cwd.GetMetricDataPoint = tc.datapoint
+				cwd.GetMetricDataTimestamps = &now
+			}
+			datapoint := aws.Float64Value(tc.datapoint)
+
+			metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			if tc.expectNaN {
+				assert.True(t, math.IsNaN(*metrics[0].Value))
+			} else {
+				assert.Equal(t, tc.expectedValue, *metrics[0].Value)
+			}
+			// The datapoint of the query is not modified
+			assert.Equal(t, datapoint, aws.Float64Value(tc.datapoint))
+		})
+	}
+}
+
+func Test_MigrateCloudwatchToPrometheus_TransformAllDataPoints(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	cwd := &cloudwatchData{
+		ID:                     aws.String("arn:aws:ec2:us-east-1:123456789012:instance/i-1"),
+		Metric:                 aws.String("NetworkIn"),
+		Namespace:              aws.String("AWS/EC2"),
+		Statistics:             []string{"Sum"},
+		NilToZero:              aws.Bool(false),
+		AddCloudwatchTimestamp: aws.Bool(true),
+		ExportAllDataPoints:    true,
+		Transforms:             map[string]*config.Transform{"Sum": {Conversion: "bitsToBytes"}},
+		GetMetricDataPoints: []dataPoint{
+			{Value: aws.Float64(16), Timestamp: now},
+			{Value: aws.Float64(8), Timestamp: now.Add(-time.Minute)},
+		},
+		Region:    aws.String("us-east-1"),
+		AccountId: aws.String("123456789012"),
+	}
+
+	metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{cwd}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, 2.0, *metrics[0].Value)
+	assert.Equal(t, 1.0, *metrics[1].Value)
+	assert.Equal(t, 16.0, *cwd.GetMetricDataPoints[0].Value)
+}
+
+func Test_MigrateCloudwatchToPrometheus_DropDimensions(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	newData := func(statistic string, requestID string, value *float64, timestamp time.Time) *cloudwatchData {
+		return &cloudwatchData{
+			ID:         aws.String("arn:aws:apigateway:us-east-1::/restapis/api"),
+			Metric:     aws.String("Latency"),
+			Namespace:  aws.String("AWS/ApiGateway"),
+			Statistics: []string{statistic},
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("ApiName"), Value: aws.String("api")},
+				{Name: aws.String("RequestId"), Value: aws.String(requestID)},
+			},
+			NilToZero:               aws.Bool(false),
+			AddCloudwatchTimestamp:  aws.Bool(false),
+			GetMetricDataPoint:      value,
+			GetMetricDataTimestamps: aws.Time(timestamp),
+			Region:                  aws.String("us-east-1"),
+			AccountId:               aws.String("123456789012"),
+			DropDimensions:          []string{"RequestId"},
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		statistic string
+		values    []*float64
+		expected  float64
+	}{
+		{name: "sum added up", statistic: "Sum", values: []*float64{aws.Float64(1), aws.Float64(2), aws.Float64(4)}, expected: 7},
+		{name: "sample count added up", statistic: "SampleCount", values: []*float64{aws.Float64(3), aws.Float64(5)}, expected: 8},
+		{name: "highest maximum", statistic: "Maximum", values: []*float64{aws.Float64(3), aws.Float64(9), aws.Float64(5)}, expected: 9},
+		{name: "lowest minimum", statistic: "Minimum", values: []*float64{aws.Float64(3), aws.Float64(1), aws.Float64(5)}, expected: 1},
+		{name: "mean of averages", statistic: "Average", values: []*float64{aws.Float64(1), aws.Float64(2), aws.Float64(6)}, expected: 3},
+		{name: "mean of percentiles", statistic: "p99", values: []*float64{aws.Float64(10), aws.Float64(20)}, expected: 15},
+		{name: "missing datapoints ignored", statistic: "Sum", values: []*float64{nil, aws.Float64(2), nil, aws.Float64(3)}, expected: 5},
+		{name: "single series", statistic: "Average", values: []*float64{aws.Float64(42)}, expected: 42},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var cwd []*cloudwatchData
+			for i, value := range tc.values {
+				cwd = append(cwd, newData(tc.statistic, fmt.Sprintf("request-%d", i), value, now.Add(time.Duration(i)*time.Minute)))
+			}
+
+			metrics, _, err := MigrateCloudwatchToPrometheus(cwd, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			assert.Equal(t, tc.expected, *metrics[0].Value)
+			assert.Equal(t, "api", metrics[0].Labels["dimension_ApiName"])
+			assert.NotContains(t, metrics[0].Labels, "dimension_RequestId")
+			// The values of the results aren't modified by the merge
+			for i, value := range tc.values {
+				assert.Equal(t, value, cwd[i].GetMetricDataPoint)
+			}
+		})
+	}
+
+	t.Run("all datapoints missing", func(t *testing.T) {
+		cwd := []*cloudwatchData{newData("Sum", "request-0", nil, now), newData("Sum", "request-1", nil, now)}
+		metrics, _, err := MigrateCloudwatchToPrometheus(cwd, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		assert.True(t, math.IsNaN(*metrics[0].Value))
+	})
+
+	t.Run("latest timestamp", func(t *testing.T) {
+		cwd := []*cloudwatchData{newData("Sum", "request-0", aws.Float64(1), now), newData("Sum", "request-1", aws.Float64(1), now.Add(time.Minute))}
+		metrics, _, err := MigrateCloudwatchToPrometheus(cwd, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+		require.NoError(t, err)
+		require.Len(t, metrics, 1)
+		assert.Equal(t, now.Add(time.Minute), metrics[0].Timestamp)
+	})
+}
+
+func Test_MigrateCloudwatchToPrometheus_Help(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name         string
+		namespace    string
+		metric       string
+		help         string
+		anomalyBand  bool
+		expectedHelp string
+	}{
+		{
+			name:         "built-in description",
+			namespace:    "AWS/EC2",
+			metric:       "CPUUtilization",
+			expectedHelp: "Percentage of the allocated compute units in use on the instance.",
+		},
+		{
+			name:         "configured description overriding the built-in one",
+			namespace:    "AWS/EC2",
+			metric:       "CPUUtilization",
+			help:         "CPU usage of the instance.",
+			expectedHelp: "CPU usage of the instance.",
+		},
+		{
+			name:         "configured description of a custom namespace",
+			namespace:    "MyApp",
+			metric:       "Latency",
+			help:         "Latency of the requests, in milliseconds.",
+			expectedHelp: "Latency of the requests, in milliseconds.",
+		},
+		{
+			name:      "without description",
+			namespace: "MyApp",
+			metric:    "Latency",
+		},
+		{
+			name:         "anomaly band",
+			namespace:    "AWS/EC2",
+			metric:       "CPUUtilization",
+			anomalyBand:  true,
+			expectedHelp: "Percentage of the allocated compute units in use on the instance. This series is the upper bound of its anomaly detection band.",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := &cloudwatchData{
+				ID:                      aws.String("i-1"),
+				Metric:                  aws.String(tc.metric),
+				Namespace:               aws.String(tc.namespace),
+				Statistics:              []string{"Average"},
+				NilToZero:               aws.Bool(false),
+				AddCloudwatchTimestamp:  aws.Bool(false),
+				GetMetricDataPoint:      aws.Float64(42),
+				GetMetricDataTimestamps: &now,
+				Region:                  aws.String("us-east-1"),
+				AccountId:               aws.String("123456789012"),
+				Help:                    tc.help,
+			}
+			if tc.anomalyBand {
+				data.AnomalyBand = true
+				data.AnomalyBandBound = anomalyBandUpper
+				data.Expression = aws.String("ANOMALY_DETECTION_BAND(id_1, 2)")
+			}
+
+			metrics, _, err := MigrateCloudwatchToPrometheus([]*cloudwatchData{data}, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			assert.Equal(t, tc.expectedHelp, metrics[0].Help)
 		})
 	}
 }
@@ -0,0 +1,84 @@
+package job
+
+import (
+	"sync"
+	"time"
+)
+
+// maxCarriedDatapoints bounds the number of series whose last datapoint is remembered.
+// Datapoints of new series are not remembered once it's reached, until others expire.
+const maxCarriedDatapoints = 100000
+
+// carriedDatapoints remembers the last datapoint of the series of the metrics with
+// CarryForward across scrapes. It's shared by all the scrapes.
+var carriedDatapoints = newCarryForwardCache(TimeClock{})
+
+// carryForwardCache holds the last datapoint of each series until it's older than the
+// CarryForward of its metric, when it expires.
+type carryForwardCache struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[string]carriedDatapoint
+}
+
+type carriedDatapoint struct {
+	value     float64
+	timestamp time.Time
+	expiry    time.Time
+}
+
+func newCarryForwardCache(clock Clock) *carryForwardCache {
+	return &carryForwardCache{clock: clock, entries: map[string]carriedDatapoint{}}
+}
+
+// carry returns the datapoint of the series key to export. A datapoint is remembered for
+// maxStaleness from its timestamp, and returned instead of a missing datapoint until then.
+func (c *carryForwardCache) carry(key string, maxStaleness time.Duration, value *float64, timestamp time.Time) (*float64, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if value != nil {
+		expiry := timestamp.Add(maxStaleness)
+		if !expiry.After(now) {
+			delete(c.entries, key)
+			return value, timestamp
+		}
+		if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCarriedDatapoints {
+			c.expire(now)
+			if len(c.entries) >= maxCarriedDatapoints {
+				return value, timestamp
+			}
+		}
+		c.entries[key] = carriedDatapoint{value: *value, timestamp: timestamp, expiry: expiry}
+		return value, timestamp
+	}
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, timestamp
+	}
+	if !entry.expiry.After(now) {
+		delete(c.entries, key)
+		return nil, timestamp
+	}
+	carried := entry.value
+	return &carried, entry.timestamp
+}
+
+// expire removes the expired datapoints, c.mu must be held
+func (c *carryForwardCache) expire(now time.Time) {
+	for key, entry := range c.entries {
+		if !entry.expiry.After(now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// purge removes the expired datapoints of the series which are no longer scraped
+func (c *carryForwardCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(c.clock.Now())
+}
@@ -0,0 +1,147 @@
+package job
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+)
+
+func TestCarryForwardCache(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	type scrape struct {
+		value     *float64
+		timestamp time.Time
+		now       time.Time
+	}
+	testCases := []struct {
+		name              string
+		scrapes           []scrape
+		expectedValue     *float64
+		expectedTimestamp time.Time
+	}{
+		{
+			name:              "datapoint",
+			scrapes:           []scrape{{value: aws.Float64(1), timestamp: now, now: now}},
+			expectedValue:     aws.Float64(1),
+			expectedTimestamp: now,
+		},
+		{
+			name:    "missing datapoint without previous one",
+			scrapes: []scrape{{now: now}},
+		},
+		{
+			name: "missing datapoint carried forward",
+			scrapes: []scrape{
+				{value: aws.Float64(1), timestamp: now.Add(-time.Minute), now: now},
+				{value: aws.Float64(2), timestamp: now, now: now},
+				{now: now.Add(30 * time.Minute)},
+			},
+			expectedValue:     aws.Float64(2),
+			expectedTimestamp: now,
+		},
+		{
+			name: "missing datapoint older than the max staleness",
+			scrapes: []scrape{
+				{value: aws.Float64(1), timestamp: now, now: now},
+				{now: now.Add(time.Hour)},
+			},
+		},
+		{
+			name: "datapoint older than the max staleness not remembered",
+			scrapes: []scrape{
+				{value: aws.Float64(1), timestamp: now.Add(-2 * time.Hour), now: now},
+				{now: now},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := &StubClock{}
+			cache := newCarryForwardCache(clock)
+			var value *float64
+			var timestamp time.Time
+			for _, s := range tc.scrapes {
+				clock.currentTime = s.now
+				value, timestamp = cache.carry("series", time.Hour, s.value, s.timestamp)
+			}
+			assert.Equal(t, tc.expectedValue, value)
+			if tc.expectedValue != nil {
+				assert.Equal(t, tc.expectedTimestamp, timestamp)
+			}
+		})
+	}
+}
+
+func TestCarryForwardCachePurge(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &StubClock{currentTime: now}
+	cache := newCarryForwardCache(clock)
+
+	cache.carry("expiring", time.Hour, aws.Float64(1), now)
+	cache.carry("remaining", 2*time.Hour, aws.Float64(1), now)
+	require.Len(t, cache.entries, 2)
+
+	clock.currentTime = now.Add(90 * time.Minute)
+	cache.purge()
+	assert.Len(t, cache.entries, 1)
+	assert.Contains(t, cache.entries, "remaining")
+}
+
+func TestMigrateCloudwatchToPrometheusCarryForward(t *testing.T) {
+	now := time.Now()
+	previous := carriedDatapoints
+	carriedDatapoints = newCarryForwardCache(TimeClock{})
+	defer func() { carriedDatapoints = previous }()
+
+	newData := func(datapoint *float64, carryForward time.Duration) []*cloudwatchData {
+		return []*cloudwatchData{{
+			ID:                      aws.String("bucket"),
+			Metric:                  aws.String("NumberOfObjects"),
+			Namespace:               aws.String("AWS/S3"),
+			Statistics:              []string{"Average"},
+			GetMetricDataPoint:      datapoint,
+			GetMetricDataTimestamps: aws.Time(now),
+			NilToZero:               aws.Bool(false),
+			AddCloudwatchTimestamp:  aws.Bool(false),
+			Region:                  aws.String("us-east-1"),
+			AccountId:               aws.String("123456789012"),
+			CarryForward:            carryForward,
+		}}
+	}
+
+	testCases := []struct {
+		name         string
+		carryForward time.Duration
+		expectNaN    bool
+	}{
+		{name: "carried forward", carryForward: time.Hour},
+		{name: "without carry forward", expectNaN: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := MigrateCloudwatchToPrometheus(newData(aws.Float64(42), tc.carryForward), false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+
+			metrics, _, err := MigrateCloudwatchToPrometheus(newData(nil, tc.carryForward), false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+			require.NoError(t, err)
+			require.Len(t, metrics, 1)
+			if tc.expectNaN {
+				assert.True(t, math.IsNaN(*metrics[0].Value))
+			} else {
+				assert.Equal(t, 42.0, *metrics[0].Value)
+			}
+		})
+	}
+}
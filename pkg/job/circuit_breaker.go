@@ -0,0 +1,156 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// States of a circuit breaker, as exported by promutil.CircuitBreakerStateGauge
+const (
+	circuitClosed   = 0
+	circuitOpen     = 1
+	circuitHalfOpen = 2
+)
+
+// ErrCircuitOpen is the error of the requests failed without being sent by an open circuit breaker
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreaker stops sending the requests to an API of an account and region which keeps failing. It opens
+// after Failures failed requests in a row and fails the requests without sending them for Cooldown. It then
+// half-opens: a single request is sent, which closes the breaker when it succeeds, or opens it again.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	clock    Clock
+	settings config.CircuitBreaker
+	state    int
+	failures int
+	openedAt time.Time
+	// probing is set while the request testing a half-open breaker is in flight
+	probing bool
+
+	api       string
+	region    string
+	accountId string
+}
+
+func newCircuitBreaker(clock Clock, settings config.CircuitBreaker, api string, region string, accountId string) *circuitBreaker {
+	b := &circuitBreaker{clock: clock, settings: settings, api: api, region: region, accountId: accountId}
+	b.setState(circuitClosed)
+	return b
+}
+
+// setState changes the state of b, which must be locked unless it's being created
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	promutil.CircuitBreakerStateGauge.WithLabelValues(b.api, b.region, b.accountId).Set(float64(state))
+}
+
+// allow returns whether a request can be sent. The result of the requests it allows must be recorded.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.settings.GetCooldown() {
+			return false
+		}
+		b.setState(circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record records the result of a request allowed by allow
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	// A canceled request doesn't tell whether the API works, a half-open breaker sends the next one instead
+	if isCanceledError(err) {
+		return
+	}
+	if err == nil {
+		b.failures = 0
+		b.setState(circuitClosed)
+		return
+	}
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= b.settings.GetFailures() {
+		b.openedAt = b.clock.Now()
+		b.setState(circuitOpen)
+	}
+}
+
+// requestOptions returns the options of an AWS SDK call failing its requests, every page, with ErrCircuitOpen
+// without sending them while b is open, and recording their result otherwise, after their retries. A nil
+// breaker lets them all through.
+func (b *circuitBreaker) requestOptions() []request.Option {
+	if b == nil {
+		return nil
+	}
+	return []request.Option{func(r *request.Request) {
+		allowed := false
+		r.Handlers.Build.PushFront(func(r *request.Request) {
+			if !b.allow() {
+				r.Error = fmt.Errorf("%w for the %s requests in region %s of account %s", ErrCircuitOpen, b.api, b.region, b.accountId)
+				return
+			}
+			allowed = true
+		})
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if allowed {
+				b.record(r.Error)
+			}
+		})
+	}}
+}
+
+// isCanceledError returns true for the errors of requests canceled by their context
+func isCanceledError(err error) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == request.CanceledErrorCode {
+		return true
+	}
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+var (
+	circuitBreakersMu sync.Mutex
+	circuitBreakers   = map[string]*circuitBreaker{}
+)
+
+// getCircuitBreaker returns the circuit breaker of the requests to api of an account and region, which is shared
+// by all the scrapes. It returns nil when settings is nil, letting all the requests through. The breaker is
+// replaced when its settings change, e.g. on a configuration reload.
+func getCircuitBreaker(accountId string, region string, api string, settings *config.CircuitBreaker) *circuitBreaker {
+	if settings == nil {
+		return nil
+	}
+
+	circuitBreakersMu.Lock()
+	defer circuitBreakersMu.Unlock()
+
+	key := accountId + "/" + region + "/" + api
+	breaker, ok := circuitBreakers[key]
+	if !ok || breaker.settings != *settings {
+		breaker = newCircuitBreaker(TimeClock{}, *settings, api, region, accountId)
+		circuitBreakers[key] = breaker
+	}
+	return breaker
+}
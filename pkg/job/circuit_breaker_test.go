@@ -0,0 +1,139 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &StubClock{currentTime: now}
+	breaker := newCircuitBreaker(clock, config.CircuitBreaker{Failures: 3, Cooldown: time.Minute}, "GetMetricData", "eu-north-1", "123456789012")
+	gauge := promutil.CircuitBreakerStateGauge.WithLabelValues("GetMetricData", "eu-north-1", "123456789012")
+	accessDenied := awserr.New("AccessDeniedException", "not authorized", nil)
+
+	// Closed: the requests are sent until 3 of them fail in a row
+	for i := 0; i < 3; i++ {
+		require.True(t, breaker.allow(), "request %d", i)
+		assert.Equal(t, float64(circuitClosed), testutil.ToFloat64(gauge))
+		breaker.record(accessDenied)
+	}
+
+	// Open: the requests are skipped until the end of the cooldown
+	assert.Equal(t, float64(circuitOpen), testutil.ToFloat64(gauge))
+	assert.False(t, breaker.allow())
+	clock.currentTime = now.Add(59 * time.Second)
+	assert.False(t, breaker.allow())
+
+	// Half-open: a single request tests the API, which fails again
+	clock.currentTime = now.Add(time.Minute)
+	require.True(t, breaker.allow())
+	assert.Equal(t, float64(circuitHalfOpen), testutil.ToFloat64(gauge))
+	assert.False(t, breaker.allow())
+	breaker.record(accessDenied)
+	assert.Equal(t, float64(circuitOpen), testutil.ToFloat64(gauge))
+	assert.False(t, breaker.allow())
+
+	// The next test request succeeds and closes the breaker
+	clock.currentTime = now.Add(2 * time.Minute)
+	require.True(t, breaker.allow())
+	assert.Equal(t, float64(circuitHalfOpen), testutil.ToFloat64(gauge))
+	breaker.record(nil)
+	assert.Equal(t, float64(circuitClosed), testutil.ToFloat64(gauge))
+
+	// The failures are counted from 0 again
+	for i := 0; i < 2; i++ {
+		require.True(t, breaker.allow())
+		breaker.record(accessDenied)
+	}
+	assert.True(t, breaker.allow())
+	assert.Equal(t, float64(circuitClosed), testutil.ToFloat64(gauge))
+}
+
+func TestCircuitBreakerCanceledRequest(t *testing.T) {
+	clock := &StubClock{currentTime: time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)}
+	breaker := newCircuitBreaker(clock, config.CircuitBreaker{Failures: 1, Cooldown: time.Minute}, "ListMetrics", "eu-north-1", "123456789012")
+
+	require.True(t, breaker.allow())
+	breaker.record(errors.New("service unavailable"))
+	clock.currentTime = clock.currentTime.Add(time.Minute)
+
+	// The canceled test request neither closes nor opens the breaker, the next one tests the API
+	require.True(t, breaker.allow())
+	breaker.record(awserr.New(request.CanceledErrorCode, "request context canceled", context.Canceled))
+	require.True(t, breaker.allow())
+	breaker.record(nil)
+	assert.Equal(t, circuitClosed, breaker.state)
+}
+
+func TestCircuitBreakerSkipsRequests(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "text/xml")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not authorized</Message></Error></ErrorResponse>`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("eu-north-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	iface := cloudwatchInterface{
+		client:               cloudwatch.New(sess),
+		region:               "eu-north-1",
+		logger:               logger.NewLogrusLogger(log.StandardLogger()),
+		getMetricDataBreaker: newCircuitBreaker(TimeClock{}, config.CircuitBreaker{Failures: 2, Cooldown: time.Hour}, "GetMetricData", "eu-north-1", "210987654321"),
+	}
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		_, err := iface.queryMetricData(context.Background(), &cloudwatch.GetMetricDataInput{
+			StartTime: aws.Time(now.Add(-time.Hour)),
+			EndTime:   aws.Time(now),
+			MetricDataQueries: []*cloudwatch.MetricDataQuery{{
+				Id:         aws.String("id_1"),
+				Expression: aws.String("SEARCH('{AWS/EC2,InstanceId} MetricName=\"CPUUtilization\"', 'Average', 300)"),
+			}},
+		})
+		require.Error(t, err)
+		// The requests after the second failure aren't sent
+		assert.Equal(t, i >= 2, errors.Is(err, ErrCircuitOpen), "request %d: %v", i, err)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestGetCircuitBreaker(t *testing.T) {
+	assert.Nil(t, getCircuitBreaker("123456789012", "us-east-1", "GetMetricData", nil))
+	assert.Nil(t, getCircuitBreaker("123456789012", "us-east-1", "GetMetricData", nil).requestOptions())
+
+	settings := &config.CircuitBreaker{Failures: 3}
+	breaker := getCircuitBreaker("123456789012", "us-east-1", "GetMetricData", settings)
+	assert.Same(t, breaker, getCircuitBreaker("123456789012", "us-east-1", "GetMetricData", settings))
+	assert.NotSame(t, breaker, getCircuitBreaker("123456789012", "eu-west-1", "GetMetricData", settings))
+	assert.NotSame(t, breaker, getCircuitBreaker("123456789012", "us-east-1", "ListMetrics", settings))
+	// Changed settings replace the breaker
+	assert.NotSame(t, breaker, getCircuitBreaker("123456789012", "us-east-1", "GetMetricData", &config.CircuitBreaker{Failures: 5}))
+}
@@ -0,0 +1,101 @@
+package job
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxAccumulatedCounters bounds the number of series whose counter is kept. Series
+// which are new once it's reached are exported without a value until others expire.
+const maxAccumulatedCounters = 100000
+
+// accumulatedCounterExpiry is how long the counter of a series is kept once it's no longer scraped.
+// A series scraped again after that starts over, which Prometheus handles as a counter reset.
+const accumulatedCounterExpiry = 6 * time.Hour
+
+// accumulatedCounters holds the counters of the series of the metrics with Accumulate
+// across scrapes. It's shared by all the scrapes.
+var accumulatedCounters = newCounterCache(TimeClock{})
+
+// counterCache adds up the datapoints of each series in a counter, until the series
+// isn't scraped for accumulatedCounterExpiry.
+type counterCache struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[string]accumulatedCounter
+}
+
+type accumulatedCounter struct {
+	value float64
+	// timestamp is the one of the last datapoint added to the counter
+	timestamp time.Time
+	lastSeen  time.Time
+}
+
+func newCounterCache(clock Clock) *counterCache {
+	return &counterCache{clock: clock, entries: map[string]accumulatedCounter{}}
+}
+
+// add adds the datapoints of the series key newer than the last one added to its counter, and returns
+// the counter with the timestamp of its last datapoint. The counter of a new series starts at its latest
+// datapoint, nil is returned until the series has one. The datapoints which would decrease the counter,
+// negative or NaN, are skipped.
+func (c *counterCache) add(key string, datapoints []dataPoint) (*float64, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	points := make([]dataPoint, 0, len(datapoints))
+	for _, point := range datapoints {
+		if point.Value != nil && *point.Value >= 0 && !math.IsNaN(*point.Value) {
+			points = append(points, point)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp.Before(points[j].Timestamp) })
+
+	entry, ok := c.entries[key]
+	if !ok {
+		if len(points) == 0 {
+			return nil, time.Time{}
+		}
+		if len(c.entries) >= maxAccumulatedCounters {
+			c.expire(now)
+			if len(c.entries) >= maxAccumulatedCounters {
+				return nil, time.Time{}
+			}
+		}
+		latest := points[len(points)-1]
+		entry = accumulatedCounter{value: *latest.Value, timestamp: latest.Timestamp}
+	} else {
+		for _, point := range points {
+			if point.Timestamp.After(entry.timestamp) {
+				entry.value += *point.Value
+				entry.timestamp = point.Timestamp
+			}
+		}
+	}
+	entry.lastSeen = now
+	c.entries[key] = entry
+
+	value := entry.value
+	return &value, entry.timestamp
+}
+
+// expire removes the counters of the series which are no longer scraped, c.mu must be held
+func (c *counterCache) expire(now time.Time) {
+	for key, entry := range c.entries {
+		if !entry.lastSeen.Add(accumulatedCounterExpiry).After(now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// purge removes the counters of the series which are no longer scraped
+func (c *counterCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expire(c.clock.Now())
+}
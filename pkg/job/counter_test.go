@@ -0,0 +1,196 @@
+package job
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+)
+
+func TestCounterCache(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	point := func(value float64, minutesAgo int) dataPoint {
+		return dataPoint{Value: aws.Float64(value), Timestamp: now.Add(-time.Duration(minutesAgo) * time.Minute)}
+	}
+
+	testCases := []struct {
+		name              string
+		scrapes           [][]dataPoint
+		expectedValue     *float64
+		expectedTimestamp time.Time
+	}{
+		{
+			name:    "no datapoint",
+			scrapes: [][]dataPoint{nil},
+		},
+		{
+			name:              "starts at the latest datapoint",
+			scrapes:           [][]dataPoint{{point(3, 10), point(5, 5)}},
+			expectedValue:     aws.Float64(5),
+			expectedTimestamp: now.Add(-5 * time.Minute),
+		},
+		{
+			name: "adds the new datapoints only",
+			scrapes: [][]dataPoint{
+				{point(3, 10), point(5, 5)},
+				{point(5, 5), point(2, 0)},
+			},
+			expectedValue:     aws.Float64(7),
+			expectedTimestamp: now,
+		},
+		{
+			name: "adds all the datapoints since the previous scrape",
+			scrapes: [][]dataPoint{
+				{point(5, 15)},
+				{point(1, 10), point(2, 5), point(3, 0)},
+			},
+			expectedValue:     aws.Float64(11),
+			expectedTimestamp: now,
+		},
+		{
+			name: "keeps the counter without new datapoint",
+			scrapes: [][]dataPoint{
+				{point(5, 5)},
+				nil,
+			},
+			expectedValue:     aws.Float64(5),
+			expectedTimestamp: now.Add(-5 * time.Minute),
+		},
+		{
+			name: "skips the datapoints decreasing the counter",
+			scrapes: [][]dataPoint{
+				{point(5, 10)},
+				{point(-2, 5), point(math.NaN(), 0)},
+			},
+			expectedValue:     aws.Float64(5),
+			expectedTimestamp: now.Add(-10 * time.Minute),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := newCounterCache(&StubClock{currentTime: now})
+			var value *float64
+			var timestamp time.Time
+			for _, datapoints := range tc.scrapes {
+				value, timestamp = cache.add("series", datapoints)
+			}
+			assert.Equal(t, tc.expectedValue, value)
+			if tc.expectedValue != nil {
+				assert.Equal(t, tc.expectedTimestamp, timestamp)
+			}
+		})
+	}
+}
+
+func TestCounterCachePurge(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &StubClock{currentTime: now}
+	cache := newCounterCache(clock)
+
+	cache.add("expiring", []dataPoint{{Value: aws.Float64(1), Timestamp: now}})
+	clock.currentTime = now.Add(time.Hour)
+	cache.add("remaining", []dataPoint{{Value: aws.Float64(1), Timestamp: now}})
+	require.Len(t, cache.entries, 2)
+
+	clock.currentTime = now.Add(accumulatedCounterExpiry)
+	cache.purge()
+	assert.Len(t, cache.entries, 1)
+	assert.Contains(t, cache.entries, "remaining")
+}
+
+func TestMigrateCloudwatchToPrometheusCounter(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	previous := accumulatedCounters
+	accumulatedCounters = newCounterCache(TimeClock{})
+	defer func() { accumulatedCounters = previous }()
+
+	newData := func(accumulate bool, datapoints ...dataPoint) []*cloudwatchData {
+		data := &cloudwatchData{
+			ID:                     aws.String("lb"),
+			Metric:                 aws.String("RequestCount"),
+			Namespace:              aws.String("AWS/ApplicationELB"),
+			Statistics:             []string{"Sum"},
+			NilToZero:              aws.Bool(false),
+			AddCloudwatchTimestamp: aws.Bool(false),
+			Region:                 aws.String("us-east-1"),
+			AccountId:              aws.String("123456789012"),
+			Counter:                true,
+			Accumulate:             accumulate,
+		}
+		result := &cloudwatch.MetricDataResult{}
+		for _, point := range datapoints {
+			result.Values = append(result.Values, point.Value)
+			result.Timestamps = append(result.Timestamps, aws.Time(point.Timestamp))
+		}
+		setMetricDataResult(data, result)
+		return []*cloudwatchData{data}
+	}
+
+	testCases := []struct {
+		name     string
+		scrapes  [][]*cloudwatchData
+		expected float64
+	}{
+		{
+			name: "latest datapoint",
+			scrapes: [][]*cloudwatchData{
+				newData(false, dataPoint{Value: aws.Float64(10), Timestamp: now.Add(-time.Minute)}),
+				newData(false, dataPoint{Value: aws.Float64(4), Timestamp: now}, dataPoint{Value: aws.Float64(10), Timestamp: now.Add(-time.Minute)}),
+			},
+			expected: 4,
+		},
+		{
+			name: "accumulated datapoints",
+			scrapes: [][]*cloudwatchData{
+				newData(true, dataPoint{Value: aws.Float64(10), Timestamp: now.Add(-time.Minute)}),
+				newData(true, dataPoint{Value: aws.Float64(4), Timestamp: now}, dataPoint{Value: aws.Float64(10), Timestamp: now.Add(-time.Minute)}),
+			},
+			expected: 14,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var value float64
+			for _, scrape := range tc.scrapes {
+				metrics, _, err := MigrateCloudwatchToPrometheus(scrape, false, config.LabelSanitization{}, map[string]model.LabelSet{}, logger.NewLogrusLogger(log.StandardLogger()))
+				require.NoError(t, err)
+				require.Len(t, metrics, 1)
+				assert.Equal(t, "aws_applicationelb_request_count_sum_total", *metrics[0].Name)
+				assert.True(t, metrics[0].Counter)
+				value = *metrics[0].Value
+			}
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+}
+
+func TestAccumulatedDatapointsStatic(t *testing.T) {
+	now := time.Now()
+	data := &cloudwatchData{
+		Points: []*cloudwatch.Datapoint{
+			{Sum: aws.Float64(10), SampleCount: aws.Float64(2), Timestamp: aws.Time(now)},
+			{Sum: aws.Float64(20), SampleCount: aws.Float64(4), Timestamp: aws.Time(now.Add(-time.Minute))},
+		},
+		Transforms: map[string]*config.Transform{"Sum": {Scale: aws.Float64(2)}},
+	}
+
+	assert.Equal(t, []dataPoint{
+		{Value: aws.Float64(20), Timestamp: now},
+		{Value: aws.Float64(40), Timestamp: now.Add(-time.Minute)},
+	}, accumulatedDatapoints(data, "Sum"))
+	assert.Equal(t, []dataPoint{
+		{Value: aws.Float64(2), Timestamp: now},
+		{Value: aws.Float64(4), Timestamp: now.Add(-time.Minute)},
+	}, accumulatedDatapoints(data, "SampleCount"))
+}
@@ -0,0 +1,132 @@
+package job
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/session"
+)
+
+// DiscoveryJobKey identifies the scrape of a discovery job in a region with a role
+type DiscoveryJobKey struct {
+	// JobIndex is the index of the job in the discovery jobs of the config
+	JobIndex int
+	Type     string
+	Region   string
+	Role     config.Role
+}
+
+// DiscoveredResources are the resources of the discovery jobs, by job, region and role
+type DiscoveredResources map[DiscoveryJobKey][]*services.TaggedResource
+
+// DiscoverResources discovers the resources of the discovery jobs of cfg, without querying their metrics. The
+// jobs whose discovery failed are logged and missing from the result, the jobs without resources are not.
+// The result can be reused by CollectMetrics across scrapes, as long as the discovery jobs of cfg don't change.
+func DiscoverResources(
+	ctx context.Context,
+	cfg config.ScrapeConf,
+	tagSemaphore chan struct{},
+	cache session.SessionCache,
+	logger logger.Logger,
+) DiscoveredResources {
+	discovered := make(DiscoveredResources)
+	mux := &sync.Mutex{}
+	var wg sync.WaitGroup
+
+	// Like for a scrape, regions have to be resolved before refreshing the cache
+	allRegions := resolveAllRegions(ctx, cfg, cache, logger)
+	cache.Refresh()
+	defer cache.Clear()
+
+	configCache := services.NewConfigCache()
+	stackCache := services.NewStackCache()
+	accounts := newAccountIds()
+
+	var roles []config.Role
+	for _, discoveryJob := range cfg.Discovery.Jobs {
+		roles = append(roles, discoveryJob.Roles...)
+	}
+	semaphores := newRoleSemaphores(roles, nil, tagSemaphore)
+
+	for jobIdx, discoveryJob := range cfg.Discovery.Jobs {
+		for _, role := range discoveryJob.Roles {
+			for _, region := range expandRegions(discoveryJob.Regions, allRegions[role]) {
+				wg.Add(1)
+				go func(jobIdx int, discoveryJob *config.Job, region string, role config.Role) {
+					defer wg.Done()
+
+					jobCtx, cancel := withJobTimeout(ctx, discoveryJob.Timeout)
+					defer cancel()
+
+					jobLogger := logger.With("job_type", discoveryJob.Type, "region", region, "arn", role.RoleArn)
+					accountId, err := getAccountId(jobCtx, accounts, cache, role, region, jobLogger)
+					if err != nil {
+						return
+					}
+					jobLogger = jobLogger.With("account", *accountId)
+
+					clientTag := newTagsInterface(cache, discoveryJob, region, role, *accountId, configCache, stackCache, cfg.Discovery, jobLogger)
+					resources, err := discoverJobResources(jobCtx, discoveryJob, region, accountId, clientTag, clientTag, semaphores[role].tag, jobLogger)
+					logJobTimeout(ctx, jobCtx, discoveryJob.Timeout, jobLogger)
+					if err != nil {
+						return
+					}
+
+					mux.Lock()
+					discovered[DiscoveryJobKey{JobIndex: jobIdx, Type: discoveryJob.Type, Region: region, Role: role}] = resources
+					mux.Unlock()
+				}(jobIdx, discoveryJob, region, role)
+			}
+		}
+	}
+
+	wg.Wait()
+	return discovered
+}
+
+// newTagsInterface returns the clients discovering the resources of discoveryJob in region with role.
+// Its requests to the tagging API are paced by the rate limiter of the account and region with taggingLimit.
+func newTagsInterface(cache session.SessionCache, discoveryJob *config.Job, region string, role config.Role, accountId string, configCache *services.ConfigCache, stackCache *services.StackCache, discovery config.Discovery, logger logger.Logger) services.TagsInterface {
+	clientTag := services.TagsInterface{
+		Client:               cache.GetTagging(&region, role),
+		ApiGatewayClient:     cache.GetAPIGateway(&region, role),
+		AsgClient:            cache.GetASG(&region, role),
+		DmsClient:            cache.GetDMS(&region, role),
+		Ec2Client:            cache.GetEC2(&region, role),
+		StoragegatewayClient: cache.GetStorageGateway(&region, role),
+		PrometheusClient:     cache.GetPrometheus(&region, role),
+		BedrockClient:        cache.GetBedrock(&region, role),
+		ElastiCacheClient:    cache.GetElastiCache(&region, role),
+		AccountId:            accountId,
+		Logger:               logger,
+		S3Client:             cache.GetS3(&region, role),
+		TaggingOptions:       taggingOptions(accountId, region, discovery),
+	}
+	if discoveryJob.ResourceDiscovery == config.ResourceDiscoveryConfig {
+		configRegion := region
+		if discoveryJob.ConfigAggregator != nil {
+			configRegion = discoveryJob.ConfigAggregator.Region
+		}
+		clientTag.ConfigClient = cache.GetConfigService(&configRegion, role)
+		clientTag.ConfigCache = configCache
+	}
+	if discoveryJob.CloudFormationStack != nil {
+		clientTag.CloudFormationClient = cache.GetCloudFormation(&region, role)
+		clientTag.StackCache = stackCache
+	}
+	return clientTag
+}
+
+// taggingOptions returns the options of the requests to the tagging API of an account and region, pacing them
+// with the rate limiter of the discovery and stopping them with its circuit breaker
+func taggingOptions(accountId string, region string, discovery config.Discovery) []request.Option {
+	return append(
+		getRateLimiter(accountId, region, "GetResources", discovery.RateLimits.Tagging).requestOptions(),
+		getCircuitBreaker(accountId, region, "GetResources", discovery.CircuitBreaker).requestOptions()...,
+	)
+}
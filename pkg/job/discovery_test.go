@@ -0,0 +1,153 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/apigateway/apigatewayiface"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/bedrock/bedrockiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/databasemigrationservice/databasemigrationserviceiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elasticache/elasticacheiface"
+	"github.com/aws/aws-sdk-go/service/prometheusservice/prometheusserviceiface"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/storagegateway/storagegatewayiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+// discoverySessionCache is a testSessionCache with the clients discovering resources, only the tagging API being used
+type discoverySessionCache struct {
+	testSessionCache
+	tagging map[string]resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+}
+
+func (c *discoverySessionCache) GetTagging(region *string, _ config.Role) resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI {
+	return c.tagging[*region]
+}
+func (c *discoverySessionCache) GetASG(*string, config.Role) autoscalingiface.AutoScalingAPI {
+	return nil
+}
+func (c *discoverySessionCache) GetEC2(*string, config.Role) ec2iface.EC2API { return nil }
+func (c *discoverySessionCache) GetDMS(*string, config.Role) databasemigrationserviceiface.DatabaseMigrationServiceAPI {
+	return nil
+}
+func (c *discoverySessionCache) GetAPIGateway(*string, config.Role) apigatewayiface.APIGatewayAPI {
+	return nil
+}
+func (c *discoverySessionCache) GetStorageGateway(*string, config.Role) storagegatewayiface.StorageGatewayAPI {
+	return nil
+}
+func (c *discoverySessionCache) GetPrometheus(*string, config.Role) prometheusserviceiface.PrometheusServiceAPI {
+	return nil
+}
+func (c *discoverySessionCache) GetBedrock(*string, config.Role) bedrockiface.BedrockAPI { return nil }
+func (c *discoverySessionCache) GetElastiCache(*string, config.Role) elasticacheiface.ElastiCacheAPI {
+	return nil
+}
+func (c *discoverySessionCache) GetS3(*string, config.Role) s3iface.S3API { return nil }
+
+// countingTaggingAPI lists arns, or fails with err, and counts its calls
+type countingTaggingAPI struct {
+	resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+	arns []string
+	err  error
+
+	mux   sync.Mutex
+	calls int
+}
+
+func (c *countingTaggingAPI) GetResourcesPagesWithContext(_ aws.Context, _ *resourcegroupstaggingapi.GetResourcesInput, fn func(*resourcegroupstaggingapi.GetResourcesOutput, bool) bool, _ ...request.Option) error {
+	c.mux.Lock()
+	c.calls++
+	c.mux.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	page := &resourcegroupstaggingapi.GetResourcesOutput{}
+	for _, arn := range c.arns {
+		page.ResourceTagMappingList = append(page.ResourceTagMappingList, &resourcegroupstaggingapi.ResourceTagMapping{ResourceARN: aws.String(arn)})
+	}
+	fn(page, true)
+	return nil
+}
+
+func TestDiscoverResourcesAndCollectMetrics(t *testing.T) {
+	cfg := config.ScrapeConf{
+		Discovery: config.Discovery{
+			Jobs: []*config.Job{
+				{
+					Type:    "AWS/SQS",
+					Regions: []string{"us-east-1", "eu-west-1"},
+					Roles:   []config.Role{{}},
+					Metrics: []*config.Metric{{Name: "NumberOfMessagesSent", Statistics: []string{"Sum"}, Period: 300, Length: 300}},
+				},
+			},
+		},
+	}
+	usEast1 := &countingTaggingAPI{arns: []string{"arn:aws:sqs:us-east-1:123456789012:orders"}}
+	cache := &discoverySessionCache{
+		testSessionCache: testSessionCache{
+			sts: accountSTS{},
+			cloudwatch: map[string]cloudwatchiface.CloudWatchAPI{
+				"us-east-1": &concurrencyCloudwatchAPI{metrics: []*cloudwatch.Metric{{
+					MetricName: aws.String("NumberOfMessagesSent"),
+					Namespace:  aws.String("AWS/SQS"),
+					Dimensions: []*cloudwatch.Dimension{{Name: aws.String("QueueName"), Value: aws.String("orders")}},
+				}}},
+			},
+		},
+		tagging: map[string]resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI{
+			"us-east-1": usEast1,
+			"eu-west-1": &countingTaggingAPI{err: errors.New("access denied")},
+		},
+	}
+	l := logger.NewLogrusLogger(log.StandardLogger())
+
+	discovered := DiscoverResources(context.Background(), cfg, make(chan struct{}, 1), cache, l)
+
+	// The region whose discovery failed is missing
+	require.Len(t, discovered, 1)
+	resources := discovered[DiscoveryJobKey{JobIndex: 0, Type: "AWS/SQS", Region: "us-east-1", Role: config.Role{}}]
+	require.Len(t, resources, 1)
+	assert.Equal(t, "arn:aws:sqs:us-east-1:123456789012:orders", resources[0].ARN)
+	assert.True(t, cache.cleared)
+
+	awsInfoData, cwData, jobMetrics := CollectMetrics(context.Background(), cfg, discovered, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, l)
+
+	// The resources aren't discovered again
+	assert.Equal(t, 1, usEast1.calls)
+	assert.Len(t, awsInfoData, 1)
+	require.Len(t, cwData, 1)
+	assert.Equal(t, "us-east-1", *cwData[0].Region)
+
+	success := make(map[string]float64)
+	discoveredGauges := make(map[string]float64)
+	for _, jobMetric := range jobMetrics {
+		switch *jobMetric.Name {
+		case ScrapeJobSuccessMetric:
+			success[jobMetric.Labels["region"]] = *jobMetric.Value
+		case DiscoveredResourcesMetric, DiscoveredResourcesWithMetricsMetric:
+			discoveredGauges[*jobMetric.Name+" "+jobMetric.Labels["region"]] = *jobMetric.Value
+		}
+	}
+	assert.Equal(t, map[string]float64{"us-east-1": 1, "eu-west-1": 0}, success)
+	// The region whose discovery failed is skipped
+	assert.Equal(t, map[string]float64{
+		"yace_discovered_resources us-east-1":              1,
+		"yace_discovered_resources_with_metrics us-east-1": 1,
+	}, discoveredGauges)
+}
@@ -0,0 +1,100 @@
+package job
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// listMetricsCache caches the ListMetrics responses of an account and region.
+// The list of available metrics rarely changes between scrapes, so reusing it
+// saves a lot of API calls on accounts with many metrics.
+type listMetricsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]listMetricsCacheEntry
+}
+
+type listMetricsCacheEntry struct {
+	output *cloudwatch.ListMetricsOutput
+	expiry time.Time
+}
+
+var (
+	listMetricsCachesMu sync.Mutex
+	listMetricsCaches   = map[string]*listMetricsCache{}
+)
+
+// getListMetricsCache returns the ListMetrics cache of an account and region, which
+// is shared by all the scrapes. It returns nil when ttl is zero, disabling caching.
+func getListMetricsCache(accountId string, region string, ttl time.Duration) *listMetricsCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	listMetricsCachesMu.Lock()
+	defer listMetricsCachesMu.Unlock()
+
+	key := accountId + "/" + region
+	cache, ok := listMetricsCaches[key]
+	if !ok {
+		cache = &listMetricsCache{entries: map[string]listMetricsCacheEntry{}}
+		listMetricsCaches[key] = cache
+	}
+	cache.mu.Lock()
+	cache.ttl = ttl
+	cache.mu.Unlock()
+	return cache
+}
+
+// listMetricsCacheKey identifies a ListMetrics query by namespace, metric name, dimension filter and the
+// options narrowing the listed metrics
+func listMetricsCacheKey(input *cloudwatch.ListMetricsInput) string {
+	var b strings.Builder
+	b.WriteString(aws.StringValue(input.Namespace))
+	b.WriteString("/")
+	b.WriteString(aws.StringValue(input.MetricName))
+	if aws.BoolValue(input.IncludeLinkedAccounts) {
+		b.WriteString("/linked")
+	}
+	if input.RecentlyActive != nil {
+		b.WriteString("/")
+		b.WriteString(aws.StringValue(input.RecentlyActive))
+	}
+	for _, dimension := range input.Dimensions {
+		b.WriteString(",")
+		b.WriteString(aws.StringValue(dimension.Name))
+		b.WriteString("=")
+		b.WriteString(aws.StringValue(dimension.Value))
+	}
+	return b.String()
+}
+
+func (c *listMetricsCache) get(input *cloudwatch.ListMetricsInput) (*cloudwatch.ListMetricsOutput, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := listMetricsCacheKey(input)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiry) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.output, true
+}
+
+func (c *listMetricsCache) set(input *cloudwatch.ListMetricsInput, output *cloudwatch.ListMetricsOutput) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[listMetricsCacheKey(input)] = listMetricsCacheEntry{
+		output: output,
+		expiry: time.Now().Add(c.ttl),
+	}
+}
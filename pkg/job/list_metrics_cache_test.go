@@ -0,0 +1,98 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+type listMetricsCountingAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	calls int
+}
+
+func (c *listMetricsCountingAPI) ListMetricsPagesWithContext(_ aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	c.calls++
+	fn(&cloudwatch.ListMetricsOutput{Metrics: []*cloudwatch.Metric{{MetricName: input.MetricName, Namespace: input.Namespace}}}, true)
+	return nil
+}
+
+func TestGetFullMetricsListCache(t *testing.T) {
+	testCases := []struct {
+		name          string
+		ttl           time.Duration
+		expire        bool
+		expectedCalls int
+	}{
+		{
+			name:          "caching disabled",
+			ttl:           0,
+			expectedCalls: 2,
+		},
+		{
+			name:          "cached response is reused",
+			ttl:           time.Hour,
+			expectedCalls: 1,
+		},
+		{
+			name:          "expired response is refreshed",
+			ttl:           time.Hour,
+			expire:        true,
+			expectedCalls: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &listMetricsCountingAPI{}
+			clientCloudwatch := cloudwatchInterface{
+				client:           api,
+				listMetricsCache: getListMetricsCache("123456789012", tc.name, tc.ttl),
+				logger:           logger.NewLogrusLogger(log.StandardLogger()),
+			}
+			metric := &config.Metric{Name: "CPUUtilization"}
+
+			first, err := getFullMetricsList(context.Background(), "AWS/EC2", metric, nil, clientCloudwatch)
+			require.NoError(t, err)
+
+			if tc.expire {
+				for key, entry := range clientCloudwatch.listMetricsCache.entries {
+					entry.expiry = time.Now().Add(-time.Second)
+					clientCloudwatch.listMetricsCache.entries[key] = entry
+				}
+			}
+
+			second, err := getFullMetricsList(context.Background(), "AWS/EC2", metric, nil, clientCloudwatch)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedCalls, api.calls)
+			assert.Equal(t, first.Metrics, second.Metrics)
+		})
+	}
+}
+
+func TestListMetricsCacheKey(t *testing.T) {
+	withoutDimensions := createListMetricsInput(nil, aws.String("AWS/EC2"), aws.String("CPUUtilization"))
+	withDimensions := createListMetricsInput([]*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String("i-1")}}, aws.String("AWS/EC2"), aws.String("CPUUtilization"))
+	otherMetric := createListMetricsInput(nil, aws.String("AWS/EC2"), aws.String("NetworkIn"))
+	withLinkedAccounts := createListMetricsInput(nil, aws.String("AWS/EC2"), aws.String("CPUUtilization"))
+	withLinkedAccounts.IncludeLinkedAccounts = aws.Bool(true)
+	recentlyActive := createListMetricsInput(nil, aws.String("AWS/EC2"), aws.String("CPUUtilization"))
+	recentlyActive.RecentlyActive = aws.String(cloudwatch.RecentlyActivePt3h)
+
+	assert.NotEqual(t, listMetricsCacheKey(withoutDimensions), listMetricsCacheKey(withDimensions))
+	assert.NotEqual(t, listMetricsCacheKey(withoutDimensions), listMetricsCacheKey(otherMetric))
+	assert.NotEqual(t, listMetricsCacheKey(withoutDimensions), listMetricsCacheKey(withLinkedAccounts))
+	assert.NotEqual(t, listMetricsCacheKey(withoutDimensions), listMetricsCacheKey(recentlyActive))
+}
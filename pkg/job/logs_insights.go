@@ -0,0 +1,241 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// LogsInsightsJobType is the job_type label of the logs insights jobs in the metrics about the scrape
+const LogsInsightsJobType = "logs_insights"
+
+// logsInsightsMetricPrefix is the prefix of the gauges of the numeric fields of the query results
+const logsInsightsMetricPrefix = "aws_logs_insights_"
+
+// logsInsightsQueryAbandoned is the status counted for the queries which didn't complete within their
+// QueryTimeout, or before the scrape was done, and were stopped
+const logsInsightsQueryAbandoned = "Abandoned"
+
+// logsInsightsStopTimeout bounds the StopQuery call of an abandoned query
+const logsInsightsStopTimeout = 5 * time.Second
+
+// logsInsightsPollInterval is the delay between two polls of the results of a running query
+var logsInsightsPollInterval = time.Second
+
+// errLogsInsightsQueryTimeout is returned when a query didn't complete within its QueryTimeout
+var errLogsInsightsQueryTimeout = errors.New("logs insights query didn't complete within the query timeout")
+
+type logsInsightsInterface struct {
+	client cloudwatchlogsiface.CloudWatchLogsAPI
+	region string
+	retry  config.Retry
+	logger logger.Logger
+}
+
+func scrapeLogsInsightsJob(ctx context.Context, job *config.LogsInsights, region string, accountId *string, accountAlias *string, clientLogs logsInsightsInterface, cloudwatchSemaphore semaphore, logger logger.Logger) ([]*promutil.PrometheusMetric, error) {
+	endTime := time.Now().Add(-time.Duration(job.Delay) * time.Second)
+	startTime := endTime.Add(-time.Duration(job.Length) * time.Second)
+
+	queryId, err := clientLogs.startQuery(ctx, job, startTime, endTime, cloudwatchSemaphore)
+	if err != nil {
+		logger.Error(err, "Couldn't start logs insights query")
+		return nil, err
+	}
+	logger = logger.With("query_id", queryId)
+
+	results, err := clientLogs.waitForQueryResults(ctx, queryId, job.QueryTimeout, cloudwatchSemaphore)
+	if err != nil {
+		if !errors.Is(err, errLogsInsightsQueryTimeout) || !job.ExportPartialResults {
+			logger.Error(err, "Couldn't get logs insights query results")
+			return nil, err
+		}
+		logger.Warn("Exporting the partial results of a logs insights query which didn't complete in time", "rows", len(results))
+	}
+
+	labels := map[string]string{
+		"query":      job.Name,
+		"log_group":  strings.Join(job.LogGroupNames, ","),
+		"region":     region,
+		"account_id": aws.StringValue(accountId),
+	}
+	if accountAlias != nil {
+		labels["account_alias"] = *accountAlias
+	}
+	metrics := logsInsightsMetrics(results, job.LabelFields, labels, logger)
+	logger.Debug("Got logs insights query results", "rows", len(results), "series", len(metrics))
+	return metrics, err
+}
+
+// logsInsightsMetrics returns a gauge per numeric field of every row of results, named after the field and labeled
+// with labels and the labelFields of the row. The labelFields missing from a row are exported as empty labels, so
+// that all the gauges of a query have the same labels. Non numeric fields which aren't labelFields are skipped.
+func logsInsightsMetrics(results [][]*cloudwatchlogs.ResultField, labelFields []string, labels map[string]string, logger logger.Logger) []*promutil.PrometheusMetric {
+	isLabelField := make(map[string]bool, len(labelFields))
+	for _, field := range labelFields {
+		isLabelField[field] = true
+	}
+
+	var metrics []*promutil.PrometheusMetric
+	for _, row := range results {
+		rowLabels := make(map[string]string, len(labels)+len(labelFields))
+		for k, v := range labels {
+			rowLabels[k] = v
+		}
+		for _, field := range labelFields {
+			rowLabels[promutil.PromString(field)] = ""
+		}
+		for _, field := range row {
+			if isLabelField[aws.StringValue(field.Field)] {
+				rowLabels[promutil.PromString(aws.StringValue(field.Field))] = aws.StringValue(field.Value)
+			}
+		}
+
+		for _, field := range row {
+			name := aws.StringValue(field.Field)
+			// @ptr is the pointer to the log event of the row, returned with every row
+			if isLabelField[name] || name == "@ptr" {
+				continue
+			}
+			value, err := strconv.ParseFloat(aws.StringValue(field.Value), 64)
+			if err != nil {
+				logger.Debug("Skipping non numeric logs insights result field", "field", name)
+				continue
+			}
+
+			metricLabels := make(map[string]string, len(rowLabels))
+			for k, v := range rowLabels {
+				metricLabels[k] = v
+			}
+			metricName := logsInsightsMetricPrefix + promutil.PromString(name)
+			metrics = append(metrics, &promutil.PrometheusMetric{
+				Name:   &metricName,
+				Labels: metricLabels,
+				Value:  &value,
+			})
+		}
+	}
+	return metrics
+}
+
+func (iface logsInsightsInterface) startQuery(ctx context.Context, job *config.LogsInsights, startTime time.Time, endTime time.Time, cloudwatchSemaphore semaphore) (string, error) {
+	input := &cloudwatchlogs.StartQueryInput{
+		LogGroupNames: aws.StringSlice(job.LogGroupNames),
+		QueryString:   aws.String(job.Query),
+		StartTime:     aws.Int64(startTime.Unix()),
+		EndTime:       aws.Int64(endTime.Unix()),
+	}
+	if job.Limit > 0 {
+		input.Limit = aws.Int64(job.Limit)
+	}
+
+	if !cloudwatchSemaphore.acquire(ctx) {
+		return "", ctx.Err()
+	}
+	defer cloudwatchSemaphore.release()
+
+	var output *cloudwatchlogs.StartQueryOutput
+	err := withRetry(ctx, iface.retry, func() error {
+		promutil.CloudwatchAPICounter.WithLabelValues("StartQuery", iface.region).Inc()
+		var err error
+		output, err = iface.client.StartQueryWithContext(ctx, input)
+		if err != nil {
+			iface.countError("StartQuery", err)
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.QueryId), nil
+}
+
+// waitForQueryResults polls the results of the query until it completes and returns them. A query which
+// fails, or is cancelled or timed out by CloudWatch Logs, returns an error. A query which doesn't complete
+// within timeout is stopped, and the results returned so far are returned with errLogsInsightsQueryTimeout.
+func (iface logsInsightsInterface) waitForQueryResults(ctx context.Context, queryId string, timeout time.Duration, cloudwatchSemaphore semaphore) ([][]*cloudwatchlogs.ResultField, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		output, err := iface.getQueryResults(ctx, queryId, cloudwatchSemaphore)
+		if err != nil {
+			iface.stopQuery(queryId)
+			return nil, err
+		}
+
+		status := aws.StringValue(output.Status)
+		switch status {
+		case cloudwatchlogs.QueryStatusComplete:
+			promutil.LogsInsightsQueryCounter.WithLabelValues(status, iface.region).Inc()
+			return output.Results, nil
+		case cloudwatchlogs.QueryStatusScheduled, cloudwatchlogs.QueryStatusRunning:
+		default:
+			// Failed, Cancelled, Timeout or Unknown, the query won't return more results
+			promutil.LogsInsightsQueryCounter.WithLabelValues(status, iface.region).Inc()
+			return nil, fmt.Errorf("logs insights query %s ended with status %s", queryId, status)
+		}
+
+		select {
+		case <-ctx.Done():
+			promutil.LogsInsightsQueryCounter.WithLabelValues(logsInsightsQueryAbandoned, iface.region).Inc()
+			iface.stopQuery(queryId)
+			return nil, ctx.Err()
+		case <-deadline.C:
+			promutil.LogsInsightsQueryCounter.WithLabelValues(logsInsightsQueryAbandoned, iface.region).Inc()
+			iface.stopQuery(queryId)
+			return output.Results, errLogsInsightsQueryTimeout
+		case <-time.After(logsInsightsPollInterval):
+		}
+	}
+}
+
+func (iface logsInsightsInterface) getQueryResults(ctx context.Context, queryId string, cloudwatchSemaphore semaphore) (*cloudwatchlogs.GetQueryResultsOutput, error) {
+	if !cloudwatchSemaphore.acquire(ctx) {
+		return nil, ctx.Err()
+	}
+	defer cloudwatchSemaphore.release()
+
+	var output *cloudwatchlogs.GetQueryResultsOutput
+	err := withRetry(ctx, iface.retry, func() error {
+		promutil.CloudwatchAPICounter.WithLabelValues("GetQueryResults", iface.region).Inc()
+		var err error
+		output, err = iface.client.GetQueryResultsWithContext(ctx, &cloudwatchlogs.GetQueryResultsInput{QueryId: aws.String(queryId)})
+		if err != nil {
+			iface.countError("GetQueryResults", err)
+		}
+		return err
+	})
+	return output, err
+}
+
+// stopQuery stops an abandoned query, which would otherwise keep counting against the limit of concurrent
+// queries of the account. It doesn't use the context of the job, which may be done already.
+func (iface logsInsightsInterface) stopQuery(queryId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), logsInsightsStopTimeout)
+	defer cancel()
+
+	promutil.CloudwatchAPICounter.WithLabelValues("StopQuery", iface.region).Inc()
+	if _, err := iface.client.StopQueryWithContext(ctx, &cloudwatchlogs.StopQueryInput{QueryId: aws.String(queryId)}); err != nil {
+		iface.countError("StopQuery", err)
+		iface.logger.Warn("Couldn't stop logs insights query", "query_id", queryId, "err", err)
+	}
+}
+
+func (iface logsInsightsInterface) countError(api string, err error) {
+	promutil.CloudwatchAPIErrorCounter.Inc()
+	if request.IsErrorThrottle(err) {
+		promutil.CloudwatchAPIThrottleCounter.WithLabelValues(api, iface.region).Inc()
+	}
+}
@@ -0,0 +1,187 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+type logsInsightsAPI struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	startInput *cloudwatchlogs.StartQueryInput
+	// statuses are returned by the successive GetQueryResults calls, the last one repeatedly
+	statuses   []string
+	results    [][]*cloudwatchlogs.ResultField
+	resultsErr error
+	polls      int
+	stopped    bool
+}
+
+func (c *logsInsightsAPI) StartQueryWithContext(_ aws.Context, input *cloudwatchlogs.StartQueryInput, _ ...request.Option) (*cloudwatchlogs.StartQueryOutput, error) {
+	c.startInput = input
+	return &cloudwatchlogs.StartQueryOutput{QueryId: aws.String("query-1")}, nil
+}
+
+func (c *logsInsightsAPI) GetQueryResultsWithContext(_ aws.Context, _ *cloudwatchlogs.GetQueryResultsInput, _ ...request.Option) (*cloudwatchlogs.GetQueryResultsOutput, error) {
+	if c.resultsErr != nil {
+		return nil, c.resultsErr
+	}
+	status := c.statuses[len(c.statuses)-1]
+	if c.polls < len(c.statuses) {
+		status = c.statuses[c.polls]
+	}
+	c.polls++
+	return &cloudwatchlogs.GetQueryResultsOutput{Status: aws.String(status), Results: c.results}, nil
+}
+
+func (c *logsInsightsAPI) StopQueryWithContext(_ aws.Context, _ *cloudwatchlogs.StopQueryInput, _ ...request.Option) (*cloudwatchlogs.StopQueryOutput, error) {
+	c.stopped = true
+	return &cloudwatchlogs.StopQueryOutput{Success: aws.Bool(true)}, nil
+}
+
+func resultRow(fields ...string) []*cloudwatchlogs.ResultField {
+	row := make([]*cloudwatchlogs.ResultField, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		row = append(row, &cloudwatchlogs.ResultField{Field: aws.String(fields[i]), Value: aws.String(fields[i+1])})
+	}
+	return row
+}
+
+func TestScrapeLogsInsightsJob(t *testing.T) {
+	pollInterval := logsInsightsPollInterval
+	logsInsightsPollInterval = time.Millisecond
+	defer func() { logsInsightsPollInterval = pollInterval }()
+
+	results := [][]*cloudwatchlogs.ResultField{
+		resultRow("level", "ERROR", "count", "12", "@ptr", "ptr-1"),
+	}
+
+	testCases := []struct {
+		name                 string
+		statuses             []string
+		resultsErr           error
+		exportPartialResults bool
+		expectedErr          bool
+		expectedMetrics      int
+		expectedStopped      bool
+	}{
+		{
+			name:            "completed query",
+			statuses:        []string{cloudwatchlogs.QueryStatusScheduled, cloudwatchlogs.QueryStatusRunning, cloudwatchlogs.QueryStatusComplete},
+			expectedMetrics: 1,
+		},
+		{
+			name:        "failed query",
+			statuses:    []string{cloudwatchlogs.QueryStatusRunning, cloudwatchlogs.QueryStatusFailed},
+			expectedErr: true,
+		},
+		{
+			name:        "query timed out by CloudWatch Logs",
+			statuses:    []string{cloudwatchlogs.QueryStatusTimeout},
+			expectedErr: true,
+		},
+		{
+			name:            "query not completed within the query timeout",
+			statuses:        []string{cloudwatchlogs.QueryStatusRunning},
+			expectedErr:     true,
+			expectedStopped: true,
+		},
+		{
+			name:                 "partial results of a query not completed within the query timeout",
+			statuses:             []string{cloudwatchlogs.QueryStatusRunning},
+			exportPartialResults: true,
+			expectedErr:          true,
+			expectedMetrics:      1,
+			expectedStopped:      true,
+		},
+		{
+			name:            "results which can't be retrieved",
+			resultsErr:      errors.New("access denied"),
+			expectedErr:     true,
+			expectedStopped: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &logsInsightsAPI{statuses: tc.statuses, results: results, resultsErr: tc.resultsErr}
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			job := &config.LogsInsights{
+				Name:                 "errors",
+				LogGroupNames:        []string{"/app/api", "/app/worker"},
+				Query:                "stats count(*) as count by level",
+				Length:               300,
+				Delay:                60,
+				Limit:                100,
+				LabelFields:          []string{"level"},
+				QueryTimeout:         50 * time.Millisecond,
+				ExportPartialResults: tc.exportPartialResults,
+			}
+			clientLogs := logsInsightsInterface{client: api, region: "us-east-1", logger: l}
+
+			metrics, err := scrapeLogsInsightsJob(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, clientLogs, semaphore{make(chan struct{}, 1)}, l)
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Len(t, metrics, tc.expectedMetrics)
+			assert.Equal(t, tc.expectedStopped, api.stopped)
+
+			require.NotNil(t, api.startInput)
+			assert.Equal(t, []string{"/app/api", "/app/worker"}, aws.StringValueSlice(api.startInput.LogGroupNames))
+			assert.Equal(t, int64(300), *api.startInput.EndTime-*api.startInput.StartTime)
+			assert.InDelta(t, time.Now().Add(-time.Minute).Unix(), *api.startInput.EndTime, 5)
+			assert.Equal(t, int64(100), *api.startInput.Limit)
+
+			if tc.expectedMetrics > 0 {
+				assert.Equal(t, "aws_logs_insights_count", *metrics[0].Name)
+				assert.Equal(t, float64(12), *metrics[0].Value)
+				assert.Equal(t, map[string]string{
+					"query":      "errors",
+					"log_group":  "/app/api,/app/worker",
+					"region":     "us-east-1",
+					"account_id": "123456789012",
+					"level":      "ERROR",
+				}, metrics[0].Labels)
+			}
+		})
+	}
+}
+
+func TestLogsInsightsMetrics(t *testing.T) {
+	results := [][]*cloudwatchlogs.ResultField{
+		resultRow("service", "api", "requestCount", "10", "p99", "0.25", "message", "not a number", "@ptr", "ptr-1"),
+		resultRow("requestCount", "3"),
+	}
+	labels := map[string]string{"query": "latency", "region": "us-east-1"}
+
+	metrics := logsInsightsMetrics(results, []string{"service"}, labels, logger.NewLogrusLogger(log.StandardLogger()))
+
+	values := make(map[string]float64)
+	for _, metric := range metrics {
+		values[*metric.Name+"/"+metric.Labels["service"]] = *metric.Value
+		assert.Equal(t, "latency", metric.Labels["query"])
+		// Label fields missing from a row are exported empty
+		assert.Contains(t, metric.Labels, "service")
+	}
+	assert.Equal(t, map[string]float64{
+		"aws_logs_insights_request_count/api": 10,
+		"aws_logs_insights_p99/api":           0.25,
+		"aws_logs_insights_request_count/":    3,
+	}, values)
+	// labels is shared by all the rows, it isn't modified
+	assert.Equal(t, map[string]string{"query": "latency", "region": "us-east-1"}, labels)
+}
@@ -0,0 +1,115 @@
+package job
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// rateLimiter is a token bucket pacing the requests to an API of an account and region. Up to burst
+// requests are sent at once, the next ones wait for the bucket to refill at rate requests per second.
+type rateLimiter struct {
+	mu     sync.Mutex
+	clock  Clock
+	limit  config.RateLimit
+	tokens float64
+	last   time.Time
+	// labels are the labels of the wait time of the requests, api, region and account
+	labels []string
+}
+
+func newRateLimiter(clock Clock, limit config.RateLimit, labels ...string) *rateLimiter {
+	burst := float64(limit.GetBurst())
+	return &rateLimiter{clock: clock, limit: limit, tokens: burst, last: clock.Now(), labels: labels}
+}
+
+// reserve takes a token and returns how long the request has to wait for it. The tokens are
+// handed out in order even while the bucket is empty, the bucket then holds a debt.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens = math.Min(float64(l.limit.GetBurst()), l.tokens+elapsed.Seconds()*l.limit.RequestsPerSecond)
+		l.last = now
+	}
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.limit.RequestsPerSecond * float64(time.Second))
+}
+
+// cancel gives back a token taken by reserve for a request which is not sent
+func (l *rateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = math.Min(float64(l.limit.GetBurst()), l.tokens+1)
+}
+
+// wait waits until a request can be sent. It returns the error of ctx if it's done before.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	delay := l.reserve()
+	promutil.RateLimiterWaitGauge.WithLabelValues(l.labels...).Set(delay.Seconds())
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// requestOptions returns the options of an AWS SDK call pacing all of its requests with l, every
+// page and retry, before they are signed. A nil limiter doesn't pace them.
+func (l *rateLimiter) requestOptions() []request.Option {
+	if l == nil {
+		return nil
+	}
+	return []request.Option{func(r *request.Request) {
+		r.Handlers.Sign.PushFront(func(r *request.Request) {
+			if err := l.wait(r.Context()); err != nil {
+				r.Error = err
+			}
+		})
+	}}
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = map[string]*rateLimiter{}
+)
+
+// getRateLimiter returns the rate limiter of the requests to api of an account and region, which is
+// shared by all the scrapes. It returns nil when limit is nil, not pacing the requests. The limiter is
+// replaced when its limit changes, e.g. on a configuration reload.
+func getRateLimiter(accountId string, region string, api string, limit *config.RateLimit) *rateLimiter {
+	if limit == nil {
+		return nil
+	}
+
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	key := accountId + "/" + region + "/" + api
+	limiter, ok := rateLimiters[key]
+	if !ok || limiter.limit != *limit {
+		limiter = newRateLimiter(TimeClock{}, *limit, api, region, accountId)
+		rateLimiters[key] = limiter
+	}
+	return limiter
+}
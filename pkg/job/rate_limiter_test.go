@@ -0,0 +1,109 @@
+package job
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &StubClock{currentTime: now}
+	limiter := newRateLimiter(clock, config.RateLimit{RequestsPerSecond: 10, Burst: 2}, "GetMetricData", "us-east-1", "123456789012")
+
+	// The burst is sent at once, the next requests are spaced by 100ms
+	for _, expected := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		assert.Equal(t, expected, limiter.reserve())
+	}
+
+	// The bucket refills up to the burst
+	clock.currentTime = now.Add(time.Second)
+	for _, expected := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		assert.Equal(t, expected, limiter.reserve())
+	}
+}
+
+func TestRateLimiterDefaultBurst(t *testing.T) {
+	limiter := newRateLimiter(&StubClock{}, config.RateLimit{RequestsPerSecond: 2.5}, "ListMetrics", "us-east-1", "123456789012")
+
+	for _, expected := range []time.Duration{0, 0, 0, 400 * time.Millisecond} {
+		assert.Equal(t, expected, limiter.reserve())
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	limiter := newRateLimiter(&StubClock{}, config.RateLimit{RequestsPerSecond: 1, Burst: 1}, "GetMetricData", "us-east-1", "123456789012")
+	require.NoError(t, limiter.wait(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, limiter.wait(ctx), context.Canceled)
+	// The token of the canceled request is given back
+	assert.Equal(t, time.Second, limiter.reserve())
+}
+
+func TestRateLimiterPacesRequests(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "text/xml")
+		_, _ = w.Write([]byte(`<GetMetricDataResponse><GetMetricDataResult><MetricDataResults></MetricDataResults></GetMetricDataResult></GetMetricDataResponse>`))
+	}))
+	defer server.Close()
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	}))
+	iface := cloudwatchInterface{
+		client:               cloudwatch.New(sess),
+		region:               "us-east-1",
+		logger:               logger.NewLogrusLogger(log.StandardLogger()),
+		getMetricDataLimiter: newRateLimiter(TimeClock{}, config.RateLimit{RequestsPerSecond: 20, Burst: 1}, "GetMetricData", "us-east-1", "123456789012"),
+	}
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		_, err := iface.queryMetricData(context.Background(), &cloudwatch.GetMetricDataInput{
+			StartTime: aws.Time(start.Add(-time.Hour)),
+			EndTime:   aws.Time(start),
+			MetricDataQueries: []*cloudwatch.MetricDataQuery{{
+				Id:         aws.String("id_1"),
+				Expression: aws.String("SEARCH('{AWS/EC2,InstanceId} MetricName=\"CPUUtilization\"', 'Average', 300)"),
+			}},
+		})
+		require.NoError(t, err)
+	}
+
+	// The first request is sent right away, the next ones 50ms apart
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&requests))
+}
+
+func TestGetRateLimiter(t *testing.T) {
+	assert.Nil(t, getRateLimiter("123456789012", "us-east-1", "GetMetricData", nil))
+	assert.Nil(t, getRateLimiter("123456789012", "us-east-1", "GetMetricData", nil).requestOptions())
+
+	limit := &config.RateLimit{RequestsPerSecond: 10}
+	limiter := getRateLimiter("123456789012", "us-east-1", "GetMetricData", limit)
+	assert.Same(t, limiter, getRateLimiter("123456789012", "us-east-1", "GetMetricData", limit))
+	assert.NotSame(t, limiter, getRateLimiter("123456789012", "eu-west-1", "GetMetricData", limit))
+	assert.NotSame(t, limiter, getRateLimiter("123456789012", "us-east-1", "ListMetrics", limit))
+	// A changed limit replaces the limiter
+	assert.NotSame(t, limiter, getRateLimiter("123456789012", "us-east-1", "GetMetricData", &config.RateLimit{RequestsPerSecond: 5}))
+}
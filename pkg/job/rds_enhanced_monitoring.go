@@ -0,0 +1,321 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+// RDSEnhancedMonitoringJobType is the job_type label of the RDS enhanced monitoring jobs in the metrics about the scrape
+const RDSEnhancedMonitoringJobType = "rds_enhanced_monitoring"
+
+// RDSEnhancedMonitoringEnabledMetric is the name of the gauge exported for every discovered RDS instance, 1 when
+// Enhanced Monitoring is enabled for it and 0 otherwise
+const RDSEnhancedMonitoringEnabledMetric = "aws_rds_os_enhanced_monitoring_enabled"
+
+// rdsOSMetricPrefix is the prefix of the gauges of the OS metrics of the RDS instances
+const rdsOSMetricPrefix = "aws_rds_os_"
+
+// rdsEnhancedMonitoringLogGroup is the log group to which Enhanced Monitoring publishes the OS metrics of all the
+// RDS instances of a region, in a log stream per instance named after its DbiResourceId
+const rdsEnhancedMonitoringLogGroup = "RDSOSMetrics"
+
+// rdsOSMetricsListLabels are the fields identifying the elements of the groups of OS metrics which are lists,
+// e.g. the disks of diskIO, exported as a label of the metrics of their element
+var rdsOSMetricsListLabels = map[string]string{
+	"diskIO":           "device",
+	"physicalDeviceIO": "device",
+	"fileSys":          "mountPoint",
+	"network":          "interface",
+}
+
+type rdsEnhancedMonitoringInterface struct {
+	rds    rdsiface.RDSAPI
+	logs   cloudwatchlogsiface.CloudWatchLogsAPI
+	region string
+	retry  config.Retry
+	logger logger.Logger
+}
+
+// rdsInstance is the part of the description of an RDS instance locating its Enhanced Monitoring log stream
+type rdsInstance struct {
+	resourceId string
+	// monitoringInterval is the interval of Enhanced Monitoring in seconds, 0 when it's disabled
+	monitoringInterval int64
+}
+
+func scrapeRDSEnhancedMonitoringJob(ctx context.Context, job *config.RDSEnhancedMonitoring, region string, accountId *string, accountAlias *string, clientTag services.TagsInterface, clientRDS rdsEnhancedMonitoringInterface, cloudwatchSemaphore semaphore, tagSemaphore semaphore, logger logger.Logger) ([]*promutil.PrometheusMetric, error) {
+	discoveryJob := &config.Job{Type: "rds", SearchTags: job.SearchTags}
+	resources, err := discoverJobResources(ctx, discoveryJob, region, accountId, clientTag, clientTag, tagSemaphore, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := clientRDS.describeInstances(ctx, cloudwatchSemaphore)
+	if err != nil {
+		logger.Error(err, "Couldn't describe RDS instances")
+		return nil, err
+	}
+
+	startTime := time.Now().Add(-job.MaxAge)
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		metrics []*promutil.PrometheusMetric
+	)
+	for _, resource := range resources {
+		identifier, ok := rdsInstanceIdentifier(resource.ARN)
+		if !ok {
+			// Clusters have no OS metrics of their own, those of their instances are exported
+			continue
+		}
+		instance, ok := instances[identifier]
+		if !ok {
+			logger.Debug("Skipping RDS instance which isn't described, e.g. deleted since its discovery", "instance", identifier)
+			continue
+		}
+
+		labels := rdsInstanceLabels(identifier, resource, job.ExportedTags, region, accountId, accountAlias, logger)
+		enabled := instance.monitoringInterval > 0 && instance.resourceId != ""
+		mu.Lock()
+		metrics = append(metrics, rdsEnhancedMonitoringEnabledMetric(enabled, labels))
+		mu.Unlock()
+		if !enabled {
+			logger.Debug("Enhanced Monitoring isn't enabled for RDS instance, skipping its OS metrics", "instance", identifier)
+			continue
+		}
+
+		wg.Add(1)
+		go func(identifier string, resourceId string, labels map[string]string) {
+			defer wg.Done()
+
+			message, jobErr := clientRDS.latestEvent(ctx, resourceId, startTime, cloudwatchSemaphore)
+			if jobErr != nil {
+				logger.Error(jobErr, "Couldn't get the Enhanced Monitoring OS metrics of RDS instance", "instance", identifier)
+				mu.Lock()
+				err = jobErr
+				mu.Unlock()
+				return
+			}
+			if message == "" {
+				logger.Debug("No recent Enhanced Monitoring OS metrics for RDS instance", "instance", identifier)
+				return
+			}
+			osMetrics, jobErr := rdsOSMetrics(message, job.Groups, labels)
+			if jobErr != nil {
+				logger.Warn("Couldn't parse the Enhanced Monitoring OS metrics of RDS instance", "instance", identifier, "err", jobErr)
+				return
+			}
+
+			mu.Lock()
+			metrics = append(metrics, osMetrics...)
+			mu.Unlock()
+		}(identifier, instance.resourceId, labels)
+	}
+	wg.Wait()
+
+	logger.Debug("Got Enhanced Monitoring OS metrics", "resources", len(resources), "series", len(metrics))
+	return metrics, err
+}
+
+// rdsInstanceIdentifier returns the identifier of the RDS instance of arn, false when arn isn't an RDS instance
+func rdsInstanceIdentifier(arn string) (string, bool) {
+	idx := strings.Index(arn, ":db:")
+	if idx < 0 {
+		return "", false
+	}
+	return arn[idx+len(":db:"):], true
+}
+
+// rdsInstanceLabels returns the labels of the metrics of an RDS instance, with its exportedTags
+// as tag_ labels, empty when the instance doesn't have them
+func rdsInstanceLabels(identifier string, resource *services.TaggedResource, exportedTags []string, region string, accountId *string, accountAlias *string, logger logger.Logger) map[string]string {
+	labels := map[string]string{
+		"db_instance_identifier": identifier,
+		"region":                 region,
+		"account_id":             aws.StringValue(accountId),
+	}
+	if accountAlias != nil {
+		labels["account_alias"] = *accountAlias
+	}
+	for _, tagName := range exportedTags {
+		ok, promTag := promutil.PromStringTag(tagName, false)
+		if !ok {
+			logger.Warn("exported tag name is an invalid prometheus label name", "tag", tagName)
+			continue
+		}
+		labels["tag_"+promTag] = ""
+		for _, tag := range resource.Tags {
+			if tag.Key == tagName {
+				labels["tag_"+promTag] = tag.Value
+				break
+			}
+		}
+	}
+	return labels
+}
+
+func rdsEnhancedMonitoringEnabledMetric(enabled bool, labels map[string]string) *promutil.PrometheusMetric {
+	name := RDSEnhancedMonitoringEnabledMetric
+	var value float64
+	if enabled {
+		value = 1
+	}
+	return &promutil.PrometheusMetric{
+		Name:   &name,
+		Labels: labels,
+		Value:  &value,
+		Help:   "Whether Enhanced Monitoring is enabled for the RDS instance, 1 (enabled) or 0 (disabled).",
+	}
+}
+
+// rdsOSMetrics returns a gauge per numeric field of the groups of the Enhanced Monitoring event message, named after
+// the group and the field and labeled with labels. The elements of the groups which are lists, e.g. the disks of
+// diskIO, are labeled with the field identifying them. The groups missing from the event, e.g. those which are only
+// published for some engines, are skipped.
+func rdsOSMetrics(message string, groups []string, labels map[string]string) ([]*promutil.PrometheusMetric, error) {
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(message), &event); err != nil {
+		return nil, err
+	}
+
+	var metrics []*promutil.PrometheusMetric
+	for _, group := range groups {
+		switch values := event[group].(type) {
+		case map[string]interface{}:
+			metrics = append(metrics, rdsOSGroupMetrics(group, values, labels)...)
+		case []interface{}:
+			labelField := rdsOSMetricsListLabels[group]
+			for _, element := range values {
+				values, ok := element.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				elementLabels := make(map[string]string, len(labels)+1)
+				for k, v := range labels {
+					elementLabels[k] = v
+				}
+				elementLabels[promutil.PromString(labelField)], _ = values[labelField].(string)
+				metrics = append(metrics, rdsOSGroupMetrics(group, values, elementLabels)...)
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// rdsOSGroupMetrics returns a gauge per numeric field of values, sorted by name
+func rdsOSGroupMetrics(group string, values map[string]interface{}, labels map[string]string) []*promutil.PrometheusMetric {
+	fields := make([]string, 0, len(values))
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	metrics := make([]*promutil.PrometheusMetric, 0, len(fields))
+	for _, field := range fields {
+		value, ok := values[field].(float64)
+		if !ok {
+			continue
+		}
+		name := rdsOSMetricPrefix + promutil.PromString(group) + "_" + promutil.PromString(field)
+		metrics = append(metrics, &promutil.PrometheusMetric{
+			Name:   &name,
+			Labels: labels,
+			Value:  &value,
+			Help:   fmt.Sprintf("The %s %s OS metric of the RDS instance, published by Enhanced Monitoring.", group, field),
+		})
+	}
+	return metrics
+}
+
+// describeInstances returns the RDS instances of the region by identifier
+func (iface rdsEnhancedMonitoringInterface) describeInstances(ctx context.Context, cloudwatchSemaphore semaphore) (map[string]rdsInstance, error) {
+	if !cloudwatchSemaphore.acquire(ctx) {
+		return nil, ctx.Err()
+	}
+	defer cloudwatchSemaphore.release()
+
+	var instances map[string]rdsInstance
+	err := withRetry(ctx, iface.retry, func() error {
+		instances = map[string]rdsInstance{}
+		err := iface.rds.DescribeDBInstancesPagesWithContext(ctx, &rds.DescribeDBInstancesInput{}, func(page *rds.DescribeDBInstancesOutput, lastPage bool) bool {
+			promutil.CloudwatchAPICounter.WithLabelValues("DescribeDBInstances", iface.region).Inc()
+			for _, instance := range page.DBInstances {
+				instances[aws.StringValue(instance.DBInstanceIdentifier)] = rdsInstance{
+					resourceId:         aws.StringValue(instance.DbiResourceId),
+					monitoringInterval: aws.Int64Value(instance.MonitoringInterval),
+				}
+			}
+			return !lastPage
+		})
+		if err != nil {
+			iface.countError("DescribeDBInstances", err)
+		}
+		return err
+	})
+	return instances, err
+}
+
+// latestEvent returns the message of the latest Enhanced Monitoring event of the instance with resourceId since
+// startTime, empty when there is none, e.g. when Enhanced Monitoring was just enabled for the instance
+func (iface rdsEnhancedMonitoringInterface) latestEvent(ctx context.Context, resourceId string, startTime time.Time, cloudwatchSemaphore semaphore) (string, error) {
+	if !cloudwatchSemaphore.acquire(ctx) {
+		return "", ctx.Err()
+	}
+	defer cloudwatchSemaphore.release()
+
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(rdsEnhancedMonitoringLogGroup),
+		LogStreamName: aws.String(resourceId),
+		StartTime:     aws.Int64(startTime.UnixNano() / int64(time.Millisecond)),
+		StartFromHead: aws.Bool(false),
+		Limit:         aws.Int64(1),
+	}
+	var output *cloudwatchlogs.GetLogEventsOutput
+	err := withRetry(ctx, iface.retry, func() error {
+		promutil.CloudwatchAPICounter.WithLabelValues("GetLogEvents", iface.region).Inc()
+		var err error
+		output, err = iface.logs.GetLogEventsWithContext(ctx, input)
+		if err != nil {
+			iface.countError("GetLogEvents", err)
+		}
+		return err
+	})
+	if err != nil {
+		// The log stream of an instance is only created with its first event
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
+			return "", nil
+		}
+		return "", err
+	}
+	if len(output.Events) == 0 {
+		return "", nil
+	}
+	return aws.StringValue(output.Events[len(output.Events)-1].Message), nil
+}
+
+func (iface rdsEnhancedMonitoringInterface) countError(api string, err error) {
+	promutil.CloudwatchAPIErrorCounter.Inc()
+	if request.IsErrorThrottle(err) {
+		promutil.CloudwatchAPIThrottleCounter.WithLabelValues(api, iface.region).Inc()
+	}
+}
@@ -0,0 +1,244 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+const rdsOSMetricsEvent = `{
+	"engine": "POSTGRES",
+	"instanceID": "db-1",
+	"uptime": "10 days, 1:02:03",
+	"numVCPUs": 2,
+	"cpuUtilization": {"user": 12.5, "system": 2.5, "total": 15},
+	"loadAverageMinute": {"one": 0.5, "five": 0.25, "fifteen": 0.1},
+	"memory": {"total": 4096, "free": 1024},
+	"diskIO": [
+		{"device": "rdsdev", "readIOsPS": 3, "writeIOsPS": 4},
+		{"device": "filesystem", "readIOsPS": 1}
+	]
+}`
+
+type rdsTaggingAPI struct {
+	resourcegroupstaggingapiiface.ResourceGroupsTaggingAPIAPI
+	resources map[string]map[string]string
+}
+
+func (c rdsTaggingAPI) GetResourcesPagesWithContext(_ aws.Context, _ *resourcegroupstaggingapi.GetResourcesInput, fn func(*resourcegroupstaggingapi.GetResourcesOutput, bool) bool, _ ...request.Option) error {
+	page := &resourcegroupstaggingapi.GetResourcesOutput{}
+	for arn, tags := range c.resources {
+		mapping := &resourcegroupstaggingapi.ResourceTagMapping{ResourceARN: aws.String(arn)}
+		for k, v := range tags {
+			mapping.Tags = append(mapping.Tags, &resourcegroupstaggingapi.Tag{Key: aws.String(k), Value: aws.String(v)})
+		}
+		page.ResourceTagMappingList = append(page.ResourceTagMappingList, mapping)
+	}
+	fn(page, true)
+	return nil
+}
+
+type rdsAPI struct {
+	rdsiface.RDSAPI
+	instances []*rds.DBInstance
+}
+
+func (c rdsAPI) DescribeDBInstancesPagesWithContext(_ aws.Context, _ *rds.DescribeDBInstancesInput, fn func(*rds.DescribeDBInstancesOutput, bool) bool, _ ...request.Option) error {
+	fn(&rds.DescribeDBInstancesOutput{DBInstances: c.instances}, true)
+	return nil
+}
+
+type rdsLogsAPI struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	// messages are the latest events by log stream, the log streams without one don't exist
+	messages map[string]string
+	err      error
+}
+
+func (c rdsLogsAPI) GetLogEventsWithContext(_ aws.Context, input *cloudwatchlogs.GetLogEventsInput, _ ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	message, ok := c.messages[aws.StringValue(input.LogStreamName)]
+	if !ok {
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, "The specified log stream does not exist.", nil)
+	}
+	output := &cloudwatchlogs.GetLogEventsOutput{}
+	if message != "" {
+		output.Events = []*cloudwatchlogs.OutputLogEvent{{Message: aws.String(message)}}
+	}
+	return output, nil
+}
+
+func TestRDSOSMetrics(t *testing.T) {
+	labels := map[string]string{"db_instance_identifier": "db-1"}
+
+	testCases := []struct {
+		name     string
+		groups   []string
+		expected map[string]float64
+	}{
+		{
+			name:   "numeric fields of the groups",
+			groups: []string{"cpuUtilization", "loadAverageMinute", "memory"},
+			expected: map[string]float64{
+				"aws_rds_os_cpu_utilization_system{db_instance_identifier=db-1}":      2.5,
+				"aws_rds_os_cpu_utilization_total{db_instance_identifier=db-1}":       15,
+				"aws_rds_os_cpu_utilization_user{db_instance_identifier=db-1}":        12.5,
+				"aws_rds_os_load_average_minute_fifteen{db_instance_identifier=db-1}": 0.1,
+				"aws_rds_os_load_average_minute_five{db_instance_identifier=db-1}":    0.25,
+				"aws_rds_os_load_average_minute_one{db_instance_identifier=db-1}":     0.5,
+				"aws_rds_os_memory_free{db_instance_identifier=db-1}":                 1024,
+				"aws_rds_os_memory_total{db_instance_identifier=db-1}":                4096,
+			},
+		},
+		{
+			name:   "elements of the list groups labeled",
+			groups: []string{"diskIO"},
+			expected: map[string]float64{
+				"aws_rds_os_disk_io_read_ios_ps{db_instance_identifier=db-1,device=filesystem}": 1,
+				"aws_rds_os_disk_io_read_ios_ps{db_instance_identifier=db-1,device=rdsdev}":     3,
+				"aws_rds_os_disk_io_write_ios_ps{db_instance_identifier=db-1,device=rdsdev}":    4,
+			},
+		},
+		{
+			name:     "groups missing from the event",
+			groups:   []string{"swap", "network"},
+			expected: map[string]float64{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metrics, err := rdsOSMetrics(rdsOSMetricsEvent, tc.groups, labels)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, rdsSeries(metrics))
+		})
+	}
+
+	_, err := rdsOSMetrics("not json", []string{"memory"}, labels)
+	assert.Error(t, err)
+}
+
+func TestScrapeRDSEnhancedMonitoringJob(t *testing.T) {
+	instanceARN := func(identifier string) string {
+		return "arn:aws:rds:us-east-1:123456789012:db:" + identifier
+	}
+	clientTag := services.TagsInterface{
+		Client: rdsTaggingAPI{resources: map[string]map[string]string{
+			instanceARN("monitored"):                            {"team": "payments"},
+			instanceARN("disabled"):                             {"team": "billing"},
+			instanceARN("no-events"):                            {},
+			instanceARN("deleted"):                              {},
+			"arn:aws:rds:us-east-1:123456789012:cluster:aurora": {},
+		}},
+		Logger: logger.NewLogrusLogger(log.StandardLogger()),
+	}
+	instances := []*rds.DBInstance{
+		{DBInstanceIdentifier: aws.String("monitored"), DbiResourceId: aws.String("db-MONITORED"), MonitoringInterval: aws.Int64(60)},
+		{DBInstanceIdentifier: aws.String("disabled"), DbiResourceId: aws.String("db-DISABLED"), MonitoringInterval: aws.Int64(0)},
+		{DBInstanceIdentifier: aws.String("no-events"), DbiResourceId: aws.String("db-NOEVENTS"), MonitoringInterval: aws.Int64(1)},
+	}
+	job := &config.RDSEnhancedMonitoring{
+		Name:         "rds",
+		ExportedTags: []string{"team"},
+		Groups:       []string{"memory"},
+		MaxAge:       time.Minute,
+	}
+
+	testCases := []struct {
+		name        string
+		logsErr     error
+		expectedErr bool
+		expected    map[string]float64
+	}{
+		{
+			name: "OS metrics of the monitored instances",
+			expected: map[string]float64{
+				"aws_rds_os_enhanced_monitoring_enabled{account_id=123456789012,db_instance_identifier=monitored,region=us-east-1,tag_team=payments}": 1,
+				"aws_rds_os_enhanced_monitoring_enabled{account_id=123456789012,db_instance_identifier=disabled,region=us-east-1,tag_team=billing}":   0,
+				"aws_rds_os_enhanced_monitoring_enabled{account_id=123456789012,db_instance_identifier=no-events,region=us-east-1,tag_team=}":         1,
+				"aws_rds_os_memory_free{account_id=123456789012,db_instance_identifier=monitored,region=us-east-1,tag_team=payments}":                 1024,
+				"aws_rds_os_memory_total{account_id=123456789012,db_instance_identifier=monitored,region=us-east-1,tag_team=payments}":                4096,
+			},
+		},
+		{
+			name:        "failing log events",
+			logsErr:     errors.New("access denied"),
+			expectedErr: true,
+			expected: map[string]float64{
+				"aws_rds_os_enhanced_monitoring_enabled{account_id=123456789012,db_instance_identifier=monitored,region=us-east-1,tag_team=payments}": 1,
+				"aws_rds_os_enhanced_monitoring_enabled{account_id=123456789012,db_instance_identifier=disabled,region=us-east-1,tag_team=billing}":   0,
+				"aws_rds_os_enhanced_monitoring_enabled{account_id=123456789012,db_instance_identifier=no-events,region=us-east-1,tag_team=}":         1,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			clientRDS := rdsEnhancedMonitoringInterface{
+				rds:    rdsAPI{instances: instances},
+				logs:   rdsLogsAPI{messages: map[string]string{"db-MONITORED": rdsOSMetricsEvent}, err: tc.logsErr},
+				region: "us-east-1",
+				logger: l,
+			}
+
+			metrics, err := scrapeRDSEnhancedMonitoringJob(context.Background(), job, "us-east-1", aws.String("123456789012"), nil, clientTag, clientRDS, semaphore{make(chan struct{}, 1)}, semaphore{make(chan struct{}, 1)}, l)
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, rdsSeries(metrics))
+		})
+	}
+}
+
+func TestRDSInstanceIdentifier(t *testing.T) {
+	identifier, ok := rdsInstanceIdentifier("arn:aws:rds:us-east-1:123456789012:db:orders")
+	assert.True(t, ok)
+	assert.Equal(t, "orders", identifier)
+
+	_, ok = rdsInstanceIdentifier("arn:aws:rds:us-east-1:123456789012:cluster:orders")
+	assert.False(t, ok)
+}
+
+// rdsSeries returns the values of metrics by series, written as name{labels} with the labels sorted by name
+func rdsSeries(metrics []*promutil.PrometheusMetric) map[string]float64 {
+	series := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		names := make([]string, 0, len(metric.Labels))
+		for name := range metric.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		labels := make([]string, 0, len(names))
+		for _, name := range names {
+			labels = append(labels, name+"="+metric.Labels[name])
+		}
+		series[*metric.Name+"{"+strings.Join(labels, ",")+"}"] = *metric.Value
+	}
+	return series
+}
@@ -0,0 +1,134 @@
+package job
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+// relatedTagsIndex holds the tags of the resources of a RelatedTags type by the value of its dimension
+type relatedTagsIndex struct {
+	dimension string
+	// keys are the exported tag keys, <alias of the type>_<tag>, in the order of the configured tags
+	keys []string
+	// tags are the configured tags of the resources by dimension value, in the order of keys
+	tags map[string][]string
+}
+
+// getRelatedTagsIndexes discovers the resources of every RelatedTags of job once and indexes their tags by the
+// value of the dimension, so that the tags of a metric are found without scanning the related resources. The
+// related types whose discovery fails are logged and skipped, their tags are exported empty by the collector.
+func getRelatedTagsIndexes(ctx context.Context, clientTag services.TagsInterface, job *config.Job, region string, logger logger.Logger) []relatedTagsIndex {
+	indexes := make([]relatedTagsIndex, 0, len(job.RelatedTags))
+	for _, related := range job.RelatedTags {
+		relatedJob := &config.Job{
+			Type:              related.Type,
+			ResourceDiscovery: job.ResourceDiscovery,
+			ConfigAggregator:  job.ConfigAggregator,
+		}
+		resources, err := clientTag.Get(ctx, relatedJob, region)
+		if err != nil {
+			logger.Error(err, "Couldn't describe related resources", "related_type", related.Type)
+			continue
+		}
+		svc := services.SupportedServices.GetService(related.Type)
+		index, ok := newRelatedTagsIndex(related, svc, resources)
+		if !ok {
+			logger.Warn("Related resources have no such dimension", "related_type", related.Type, "dimension", related.Dimension)
+			continue
+		}
+		logger.Debug("Indexed related resources", "related_type", related.Type, "resources", len(index.tags))
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+// newRelatedTagsIndex indexes the tags of resources of svc by the value of the dimension of related, extracted
+// from their ARN with the dimension regexps of svc. It returns false when svc doesn't identify resources by the dimension.
+func newRelatedTagsIndex(related config.RelatedTags, svc *services.ServiceFilter, resources []*services.TaggedResource) (relatedTagsIndex, bool) {
+	index := relatedTagsIndex{
+		dimension: related.Dimension,
+		keys:      make([]string, 0, len(related.Tags)),
+		tags:      make(map[string][]string),
+	}
+	for _, tag := range related.Tags {
+		index.keys = append(index.keys, svc.Alias+"_"+tag)
+	}
+
+	found := false
+	for _, dr := range svc.DimensionRegexps {
+		dimensionRegexp := compileDimensionRegexp(*dr)
+		// Only the regexps identifying the resources by the dimension alone are used, the others match the
+		// resources which merely reference it, e.g. DMS tasks reference their replication instance
+		group := -1
+		named := 0
+		for i, name := range dimensionRegexp.dimensions {
+			if i == 0 || name == "" {
+				continue
+			}
+			named++
+			if name == related.Dimension {
+				group = i
+			}
+		}
+		if group < 0 || named > 1 {
+			continue
+		}
+		found = true
+		for _, r := range resources {
+			match := dimensionRegexp.regexp.FindStringSubmatch(r.ARN)
+			if match == nil {
+				continue
+			}
+			values := make([]string, len(related.Tags))
+			for i, tag := range related.Tags {
+				for _, t := range r.Tags {
+					if t.Key == tag {
+						values[i] = t.Value
+						break
+					}
+				}
+			}
+			index.tags[match[group]] = values
+		}
+	}
+	return index, found
+}
+
+// appendRelatedTags returns tags with the related tags of the resources matching dimensions, or empty values
+// when there is no such resource. tags isn't modified, it may be shared by the metrics of a resource.
+func appendRelatedTags(tags []model.Tag, dimensions []*cloudwatch.Dimension, indexes []relatedTagsIndex) []model.Tag {
+	if len(indexes) == 0 {
+		return tags
+	}
+	size := len(tags)
+	for _, index := range indexes {
+		size += len(index.keys)
+	}
+	output := make([]model.Tag, len(tags), size)
+	copy(output, tags)
+
+	for _, index := range indexes {
+		var values []string
+		for _, dimension := range dimensions {
+			if aws.StringValue(dimension.Name) == index.dimension {
+				values = index.tags[aws.StringValue(dimension.Value)]
+				break
+			}
+		}
+		for i, key := range index.keys {
+			tag := model.Tag{Key: key}
+			if values != nil {
+				tag.Value = values[i]
+			}
+			output = append(output, tag)
+		}
+	}
+	return output
+}
@@ -0,0 +1,126 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+func TestNewRelatedTagsIndex(t *testing.T) {
+	resources := []*services.TaggedResource{
+		{
+			ARN:  "arn:aws:ec2:us-east-1:123456789012:instance/i-1",
+			Tags: []model.Tag{{Key: "Name", Value: "api"}, {Key: "team", Value: "platform"}},
+		},
+		{
+			ARN:  "arn:aws:ec2:us-east-1:123456789012:instance/i-2",
+			Tags: []model.Tag{{Key: "Name", Value: "worker"}},
+		},
+		{
+			ARN:  "arn:aws:dms:us-east-1:123456789012:rep:ABCDEF/replication-instance",
+			Tags: []model.Tag{{Key: "Name", Value: "dms"}, {Key: "team", Value: "data"}},
+		},
+		{
+			ARN:  "arn:aws:dms:us-east-1:123456789012:task:GHIJKL/replication-instance",
+			Tags: []model.Tag{{Key: "Name", Value: "task"}},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		relatedType  string
+		dimension    string
+		expectedOk   bool
+		expectedTags map[string][]string
+	}{
+		{
+			name:        "resources indexed by dimension",
+			relatedType: "ec2",
+			dimension:   "InstanceId",
+			expectedOk:  true,
+			expectedTags: map[string][]string{
+				"i-1": {"api", "platform"},
+				"i-2": {"worker", ""},
+			},
+		},
+		{
+			name:        "resources referencing the dimension are skipped",
+			relatedType: "dms",
+			dimension:   "ReplicationInstanceIdentifier",
+			expectedOk:  true,
+			expectedTags: map[string][]string{
+				"replication-instance": {"dms", "data"},
+			},
+		},
+		{
+			name:        "dimension not extracted by the related type",
+			relatedType: "ec2",
+			dimension:   "VolumeId",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			related := config.RelatedTags{Type: tc.relatedType, Dimension: tc.dimension, Tags: []string{"Name", "team"}}
+			index, ok := newRelatedTagsIndex(related, services.SupportedServices.GetService(tc.relatedType), resources)
+			assert.Equal(t, tc.expectedOk, ok)
+			if !tc.expectedOk {
+				return
+			}
+			assert.Equal(t, []string{tc.relatedType + "_Name", tc.relatedType + "_team"}, index.keys)
+			assert.Equal(t, tc.expectedTags, index.tags)
+		})
+	}
+}
+
+func TestAppendRelatedTags(t *testing.T) {
+	indexes := []relatedTagsIndex{
+		{
+			dimension: "InstanceId",
+			keys:      []string{"ec2_Name"},
+			tags:      map[string][]string{"i-1": {"api"}},
+		},
+	}
+	tags := make([]model.Tag, 1, 4)
+	tags[0] = model.Tag{Key: "team", Value: "platform"}
+
+	testCases := []struct {
+		name       string
+		dimensions []*cloudwatch.Dimension
+		expected   []model.Tag
+	}{
+		{
+			name:       "related resource",
+			dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String("i-1")}},
+			expected:   []model.Tag{{Key: "team", Value: "platform"}, {Key: "ec2_Name", Value: "api"}},
+		},
+		{
+			name:       "unknown related resource",
+			dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String("i-3")}},
+			expected:   []model.Tag{{Key: "team", Value: "platform"}, {Key: "ec2_Name", Value: ""}},
+		},
+		{
+			name:       "metric without the dimension",
+			dimensions: []*cloudwatch.Dimension{{Name: aws.String("AutoScalingGroupName"), Value: aws.String("asg")}},
+			expected:   []model.Tag{{Key: "team", Value: "platform"}, {Key: "ec2_Name", Value: ""}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := appendRelatedTags(tags, tc.dimensions, indexes)
+			assert.Equal(t, tc.expected, output)
+			// The tags shared by the metrics of a resource are left untouched
+			require.Len(t, tags, 1)
+			assert.Equal(t, model.Tag{Key: "team", Value: "platform"}, tags[:2][0])
+			assert.Equal(t, model.Tag{}, tags[:2][1])
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+// configRecordingDelay is subtracted from the time of the previous discovery when asking AWS Config
+// for the resources changed since, as configuration items are recorded some time after their capture
+const configRecordingDelay = 15 * time.Minute
+
+// resourceCache keeps the resources discovered by the jobs with incremental discovery between
+// scrapes, for every job, region and account. The entries due for a full refresh are evicted, e.g.
+// those of the jobs removed or whose search tags changed on reload.
+type resourceCache struct {
+	mu      sync.Mutex
+	entries map[string]resourceCacheEntry
+}
+
+type resourceCacheEntry struct {
+	resources []*services.TaggedResource
+	// lastFullRefresh is the start of the last discovery of all the resources, lastRefresh of the last discovery
+	lastFullRefresh time.Time
+	lastRefresh     time.Time
+	// fullRefreshInterval is the interval between the full discoveries of the job
+	fullRefreshInterval time.Duration
+}
+
+// stale returns whether all the resources have to be discovered again at now
+func (e resourceCacheEntry) stale(now time.Time) bool {
+	return now.Sub(e.lastFullRefresh) >= e.fullRefreshInterval
+}
+
+var resourceCaches = &resourceCache{entries: map[string]resourceCacheEntry{}}
+
+// resourceCacheKey identifies the resources of a job by the settings selecting them
+func resourceCacheKey(job *config.Job, region string, accountId string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s/%s/%s/%s", job.Type, region, accountId, job.ResourceDiscovery)
+	if job.ConfigAggregator != nil {
+		fmt.Fprintf(&b, "/%s/%s", job.ConfigAggregator.Region, job.ConfigAggregator.Name)
+	}
+	for _, tag := range job.SearchTags {
+		fmt.Fprintf(&b, ",%s=%s", tag.Key, tag.Value)
+	}
+	return b.String()
+}
+
+func (c *resourceCache) get(key string) (resourceCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+// set sets the entry of key and evicts the stale entries of the other keys, which would be discovered again anyway
+func (c *resourceCache) set(key string, entry resourceCacheEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if e.stale(now) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = entry
+}
+
+// getResources discovers the resources of job in region. Without incremental discovery, all the resources
+// are discovered every time. With it, which requires AWS Config as the tagging API can't list the changed
+// resources, they are only all discovered on the first scrape and once every full refresh interval. In
+// between, the resources are updated with those changed since the previous discovery.
+func getResources(ctx context.Context, clientTag services.TagsInterface, job *config.Job, region string, accountId string, logger logger.Logger) ([]*services.TaggedResource, error) {
+	if job.IncrementalDiscovery == nil || job.ResourceDiscovery != config.ResourceDiscoveryConfig {
+		return clientTag.Get(ctx, job, region)
+	}
+
+	now := time.Now()
+	key := resourceCacheKey(job, region, accountId)
+	fullRefreshInterval := job.IncrementalDiscovery.GetFullRefreshInterval()
+	entry, ok := resourceCaches.get(key)
+	if !ok || entry.stale(now) || entry.fullRefreshInterval != fullRefreshInterval {
+		resources, err := clientTag.Get(ctx, job, region)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Discovered all the resources", "resources", len(resources))
+		resourceCaches.set(key, resourceCacheEntry{resources: resources, lastFullRefresh: now, lastRefresh: now, fullRefreshInterval: fullRefreshInterval}, now)
+		return resources, nil
+	}
+
+	// Regions sharing an aggregator share its query when their since is the same
+	since := entry.lastRefresh.Add(-configRecordingDelay).Truncate(time.Minute)
+	changed, err := clientTag.GetChangedConfigResources(ctx, job, region, since)
+	if err != nil {
+		return nil, err
+	}
+	resources := mergeChangedResources(entry.resources, changed, job.SearchTags)
+	logger.Debug("Updated the resources changed since the last discovery", "changed", len(changed), "resources", len(resources))
+	entry.resources = resources
+	entry.lastRefresh = now
+	resourceCaches.set(key, entry, now)
+	return resources, nil
+}
+
+// mergeChangedResources returns resources updated with the changed resources: those matching
+// searchTags replace the resource with the same ARN or are added, the others are removed.
+// Neither resources nor its elements are modified, they are shared with the previous scrapes.
+func mergeChangedResources(resources []*services.TaggedResource, changed []*services.TaggedResource, searchTags []model.Tag) []*services.TaggedResource {
+	changedByARN := make(map[string]*services.TaggedResource, len(changed))
+	for _, resource := range changed {
+		changedByARN[resource.ARN] = resource
+	}
+
+	merged := make([]*services.TaggedResource, 0, len(resources)+len(changed))
+	for _, resource := range resources {
+		if _, ok := changedByARN[resource.ARN]; !ok {
+			merged = append(merged, resource)
+		}
+	}
+	for _, resource := range changed {
+		if resource.FilterThroughTags(searchTags) {
+			merged = append(merged, resource)
+		}
+	}
+	return merged
+}
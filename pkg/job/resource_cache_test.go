@@ -0,0 +1,139 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/configservice/configserviceiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+// configCountingAPI lists an EC2 instance with AWS Config, counting the full queries and those of the changed resources
+type configCountingAPI struct {
+	configserviceiface.ConfigServiceAPI
+	fullQueries    int
+	changedQueries int
+}
+
+func (c *configCountingAPI) SelectResourceConfigPagesWithContext(_ aws.Context, input *configservice.SelectResourceConfigInput, fn func(*configservice.SelectResourceConfigOutput, bool) bool, _ ...request.Option) error {
+	if strings.Contains(aws.StringValue(input.Expression), "configurationItemCaptureTime") {
+		c.changedQueries++
+		fn(&configservice.SelectResourceConfigOutput{}, true)
+		return nil
+	}
+	c.fullQueries++
+	fn(&configservice.SelectResourceConfigOutput{Results: []*string{
+		aws.String(`{"arn":"arn:aws:ec2:us-east-1:123456789012:instance/i-1","awsRegion":"us-east-1","tags":[]}`),
+	}}, true)
+	return nil
+}
+
+func TestGetResourcesIncrementalDiscovery(t *testing.T) {
+	testCases := []struct {
+		name                   string
+		resourceDiscovery      string
+		incrementalDiscovery   *config.IncrementalDiscovery
+		expire                 bool
+		expectedFullQueries    int
+		expectedChangedQueries int
+	}{
+		{
+			name:                "incremental discovery disabled",
+			resourceDiscovery:   config.ResourceDiscoveryConfig,
+			expectedFullQueries: 2,
+		},
+		{
+			name:                   "changed resources until the full refresh",
+			resourceDiscovery:      config.ResourceDiscoveryConfig,
+			incrementalDiscovery:   &config.IncrementalDiscovery{},
+			expectedFullQueries:    1,
+			expectedChangedQueries: 1,
+		},
+		{
+			name:                 "resources are all discovered after the full refresh interval",
+			resourceDiscovery:    config.ResourceDiscoveryConfig,
+			incrementalDiscovery: &config.IncrementalDiscovery{FullRefreshInterval: time.Hour},
+			expire:               true,
+			expectedFullQueries:  2,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			api := &configCountingAPI{}
+			l := logger.NewLogrusLogger(log.StandardLogger())
+			// Every case has its own account, not to share the cached resources
+			accountId := fmt.Sprintf("12345678901%d", i)
+			clientTag := services.TagsInterface{ConfigClient: api, AccountId: accountId, Logger: l}
+			job := &config.Job{Type: "AWS/EC2", ResourceDiscovery: tc.resourceDiscovery, IncrementalDiscovery: tc.incrementalDiscovery}
+
+			first, err := getResources(context.Background(), clientTag, job, "us-east-1", accountId, l)
+			require.NoError(t, err)
+			require.Len(t, first, 1)
+
+			if tc.expire {
+				key := resourceCacheKey(job, "us-east-1", accountId)
+				entry, ok := resourceCaches.get(key)
+				require.True(t, ok)
+				entry.lastFullRefresh = time.Now().Add(-2 * time.Hour)
+				resourceCaches.entries[key] = entry
+			}
+
+			second, err := getResources(context.Background(), clientTag, job, "us-east-1", accountId, l)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.expectedFullQueries, api.fullQueries)
+			assert.Equal(t, tc.expectedChangedQueries, api.changedQueries)
+			assert.Equal(t, first, second)
+		})
+	}
+}
+
+func TestResourceCacheEviction(t *testing.T) {
+	now := time.Now()
+	cache := &resourceCache{entries: map[string]resourceCacheEntry{
+		"stale":  {lastFullRefresh: now.Add(-2 * time.Hour), fullRefreshInterval: time.Hour},
+		"recent": {lastFullRefresh: now.Add(-30 * time.Minute), fullRefreshInterval: time.Hour},
+	}}
+
+	cache.set("new", resourceCacheEntry{lastFullRefresh: now, fullRefreshInterval: time.Hour}, now)
+
+	_, ok := cache.get("stale")
+	assert.False(t, ok)
+	_, ok = cache.get("recent")
+	assert.True(t, ok)
+	_, ok = cache.get("new")
+	assert.True(t, ok)
+}
+
+func TestMergeChangedResources(t *testing.T) {
+	unchanged := &services.TaggedResource{ARN: "arn:unchanged", Tags: []model.Tag{{Key: "env", Value: "prod"}}}
+	retagged := &services.TaggedResource{ARN: "arn:retagged", Tags: []model.Tag{{Key: "env", Value: "prod"}}}
+	updated := &services.TaggedResource{ARN: "arn:updated", Tags: []model.Tag{{Key: "env", Value: "prod"}}}
+	resources := []*services.TaggedResource{unchanged, retagged, updated}
+
+	changed := []*services.TaggedResource{
+		{ARN: "arn:retagged", Tags: []model.Tag{{Key: "env", Value: "dev"}}},
+		{ARN: "arn:updated", Tags: []model.Tag{{Key: "env", Value: "prod"}, {Key: "team", Value: "a"}}},
+		{ARN: "arn:created", Tags: []model.Tag{{Key: "env", Value: "prod"}}},
+		{ARN: "arn:other", Tags: []model.Tag{{Key: "env", Value: "dev"}}},
+	}
+
+	merged := mergeChangedResources(resources, changed, []model.Tag{{Key: "env", Value: "prod"}})
+
+	assert.Equal(t, []*services.TaggedResource{unchanged, changed[1], changed[2]}, merged)
+	assert.Equal(t, []*services.TaggedResource{unchanged, retagged, updated}, resources)
+}
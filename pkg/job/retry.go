@@ -0,0 +1,55 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+)
+
+// withRetry calls fn until it succeeds, returns an error which is not worth retrying or
+// retry.MaxAttempts is reached. Between attempts it sleeps for an exponentially growing
+// delay based on retry.BaseDelay with some jitter applied.
+func withRetry(ctx context.Context, retry config.Retry, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || attempt+1 >= retry.MaxAttempts || !isRetryableError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoffDelay(retry.BaseDelay, attempt)):
+		}
+	}
+}
+
+// backoffDelay returns the delay to wait before the next attempt: half of it is
+// fixed (baseDelay * 2^attempt) and half is random to spread out concurrent retries.
+func backoffDelay(baseDelay time.Duration, attempt int) time.Duration {
+	delay := baseDelay << attempt
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// isRetryableError returns true for throttling and server side errors. Anything else,
+// e.g. validation errors, would fail the same way on the next attempt.
+func isRetryableError(err error) bool {
+	if request.IsErrorThrottle(err) {
+		return true
+	}
+	var requestFailure awserr.RequestFailure
+	if errors.As(err, &requestFailure) {
+		return requestFailure.StatusCode() >= 500
+	}
+	return false
+}
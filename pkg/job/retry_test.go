@@ -0,0 +1,69 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+)
+
+func TestWithRetry(t *testing.T) {
+	throttlingErr := awserr.New("Throttling", "Rate exceeded", nil)
+	serverErr := awserr.NewRequestFailure(awserr.New("InternalFailure", "internal failure", nil), 500, "")
+	validationErr := awserr.NewRequestFailure(awserr.New("InvalidParameterValue", "invalid parameter", nil), 400, "")
+
+	testCases := []struct {
+		name          string
+		retry         config.Retry
+		errors        []error
+		expectedCalls int
+		expectedErr   error
+	}{
+		{
+			name:          "no retry configured",
+			retry:         config.Retry{},
+			errors:        []error{throttlingErr, nil},
+			expectedCalls: 1,
+			expectedErr:   throttlingErr,
+		},
+		{
+			name:          "success after throttling",
+			retry:         config.Retry{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errors:        []error{throttlingErr, serverErr, nil},
+			expectedCalls: 3,
+			expectedErr:   nil,
+		},
+		{
+			name:          "give up after max attempts",
+			retry:         config.Retry{MaxAttempts: 2, BaseDelay: time.Millisecond},
+			errors:        []error{throttlingErr, throttlingErr, nil},
+			expectedCalls: 2,
+			expectedErr:   throttlingErr,
+		},
+		{
+			name:          "validation errors are not retried",
+			retry:         config.Retry{MaxAttempts: 3, BaseDelay: time.Millisecond},
+			errors:        []error{validationErr, nil},
+			expectedCalls: 1,
+			expectedErr:   validationErr,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := withRetry(context.Background(), tc.retry, func() error {
+				err := tc.errors[calls]
+				calls++
+				return err
+			})
+			assert.Equal(t, tc.expectedCalls, calls)
+			assert.True(t, errors.Is(err, tc.expectedErr))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package job
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+// sampleResources returns the resources whose metrics are queried with the sampleRatio ratio of a job, all of them
+// when it's 0. A resource is selected when the hash of its ARN, mapped to [0, 1), is below ratio: the same resources
+// are selected every scrape, and raising the ratio only adds resources to them. The ratio and the number of selected
+// resources are reported with labels.
+func sampleResources(resources []*services.TaggedResource, ratio float64, labels prometheus.Labels) []*services.TaggedResource {
+	if ratio <= 0 {
+		return resources
+	}
+
+	sampled := make([]*services.TaggedResource, 0, int(float64(len(resources))*ratio)+1)
+	for _, resource := range resources {
+		if sampleKey(resource.ARN) < ratio {
+			sampled = append(sampled, resource)
+		}
+	}
+	promutil.ResourceSampleRatioGauge.With(labels).Set(ratio)
+	promutil.ResourcesSampledGauge.With(labels).Set(float64(len(sampled)))
+	return sampled
+}
+
+// sampleKey maps arn uniformly to [0, 1) with its SHA-256 hash, which, unlike faster hashes, is spread evenly
+// even for ARNs differing by a single character
+func sampleKey(arn string) float64 {
+	sum := sha256.Sum256([]byte(arn))
+	// 53 bits of the hash are exactly representable as a float64
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
@@ -0,0 +1,66 @@
+package job
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+func TestSampleResources(t *testing.T) {
+	resources := make([]*services.TaggedResource, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		resources = append(resources, &services.TaggedResource{ARN: fmt.Sprintf("arn:aws:ec2:us-east-1:123456789012:instance/i-%08d", i), Namespace: "AWS/EC2", Region: "us-east-1"})
+	}
+	labels := prometheus.Labels{"job_type": "AWS/EC2", "region": "us-east-1", "account": "123456789012"}
+
+	testCases := []struct {
+		name     string
+		ratio    float64
+		min, max int
+	}{
+		{name: "no sampling", ratio: 0, min: 10000, max: 10000},
+		{name: "every resource", ratio: 1, min: 10000, max: 10000},
+		{name: "a tenth", ratio: 0.1, min: 900, max: 1100},
+		{name: "half", ratio: 0.5, min: 4800, max: 5200},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sampled := sampleResources(resources, tc.ratio, labels)
+			assert.GreaterOrEqual(t, len(sampled), tc.min)
+			assert.LessOrEqual(t, len(sampled), tc.max)
+			if tc.ratio > 0 {
+				assert.Equal(t, tc.ratio, testutil.ToFloat64(promutil.ResourceSampleRatioGauge.With(labels)))
+				assert.Equal(t, float64(len(sampled)), testutil.ToFloat64(promutil.ResourcesSampledGauge.With(labels)))
+			}
+
+			// The same resources are selected every scrape, whatever the order they are discovered in
+			reversed := make([]*services.TaggedResource, 0, len(resources))
+			for i := len(resources) - 1; i >= 0; i-- {
+				reversed = append(reversed, resources[i])
+			}
+			assert.Equal(t, sampled, sampleResources(resources, tc.ratio, labels))
+			assert.Equal(t, sampledARNs(sampled), sampledARNs(sampleResources(reversed, tc.ratio, labels)))
+		})
+	}
+
+	// Raising the ratio only adds resources
+	half := sampledARNs(sampleResources(resources, 0.5, labels))
+	for arn := range sampledARNs(sampleResources(resources, 0.1, labels)) {
+		assert.Contains(t, half, arn)
+	}
+}
+
+func sampledARNs(resources []*services.TaggedResource) map[string]struct{} {
+	arns := make(map[string]struct{}, len(resources))
+	for _, resource := range resources {
+		arns[resource.ARN] = struct{}{}
+	}
+	return arns
+}
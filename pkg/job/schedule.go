@@ -0,0 +1,153 @@
+package job
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+// scheduleTolerance is how early a job with a ScrapeInterval is scraped again, so that a scrape starting slightly
+// before the end of its interval, e.g. because of the drift of the ticker, doesn't delay it by a whole scrape
+const scheduleTolerance = 5 * time.Second
+
+// Kinds of the jobs with a ScrapeInterval
+const (
+	scheduleKindDiscovery       = "discovery"
+	scheduleKindStatic          = "static"
+	scheduleKindCustomNamespace = "customNamespace"
+)
+
+// scheduleKey identifies the scrape of a job with a ScrapeInterval in a region with a role. The job is
+// identified by its index and name, its type for discovery jobs, so that it's unlikely to be mistaken for
+// another job after a reload of the configuration.
+type scheduleKey struct {
+	Kind     string
+	JobIndex int
+	JobName  string
+	Region   string
+	Role     config.Role
+}
+
+// scheduledResults are the results a scrape of a job sent to the channels of the scrape
+type scheduledResults struct {
+	resources  []*services.TaggedResource
+	cwData     []*cloudwatchData
+	jobMetrics []*promutil.PrometheusMetric
+}
+
+type scheduleEntry struct {
+	interval time.Duration
+	lastRun  time.Time
+	results  scheduledResults
+}
+
+// jobSchedule tracks when the jobs with a ScrapeInterval were last scraped successfully, and their results
+// then, which the scrapes until their next run export again instead of scraping them
+type jobSchedule struct {
+	mu      sync.Mutex
+	clock   Clock
+	entries map[scheduleKey]scheduleEntry
+}
+
+func newJobSchedule(clock Clock) *jobSchedule {
+	return &jobSchedule{clock: clock, entries: map[scheduleKey]scheduleEntry{}}
+}
+
+// jobSchedules is shared by all the scrapes
+var jobSchedules = newJobSchedule(TimeClock{})
+
+// start starts the scrape of key at now. When the job was scraped less than interval ago, with the same interval,
+// it sends the results of that scrape to the channels and returns true: the job mustn't be scraped. Otherwise it
+// returns the scheduledScrape recording the results of the job, nil when interval is 0.
+func (s *jobSchedule) start(
+	key scheduleKey,
+	interval time.Duration,
+	now time.Time,
+	resourceCh chan<- *services.TaggedResource,
+	cwDataCh chan<- *cloudwatchData,
+	jobMetricCh chan<- *promutil.PrometheusMetric,
+) (*scheduledScrape, bool) {
+	if interval <= 0 {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	if ok && entry.interval == interval && now.Sub(entry.lastRun) < interval-scheduleTolerance {
+		s.mu.Unlock()
+		for _, resource := range entry.results.resources {
+			resourceCh <- resource
+		}
+		for _, data := range entry.results.cwData {
+			cwDataCh <- data
+		}
+		for _, metric := range entry.results.jobMetrics {
+			jobMetricCh <- metric
+		}
+		return nil, true
+	}
+	// The job is due, its results are only kept again if it succeeds
+	delete(s.entries, key)
+	s.mu.Unlock()
+	return &scheduledScrape{schedule: s, key: key, interval: interval, start: now}, false
+}
+
+// scheduledScrape records the results of a scrape of a job with a ScrapeInterval. Its methods are safe
+// to call on a nil scheduledScrape, which only sends the results.
+type scheduledScrape struct {
+	schedule *jobSchedule
+	key      scheduleKey
+	interval time.Duration
+	start    time.Time
+
+	mu      sync.Mutex
+	results scheduledResults
+}
+
+// record records cloudwatch data sent by the job
+func (s *scheduledScrape) record(data *cloudwatchData) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results.cwData = append(s.results.cwData, data)
+}
+
+// sendResource records and sends a resource of the job
+func (s *scheduledScrape) sendResource(resourceCh chan<- *services.TaggedResource, resource *services.TaggedResource) {
+	if s != nil {
+		s.mu.Lock()
+		s.results.resources = append(s.results.resources, resource)
+		s.mu.Unlock()
+	}
+	resourceCh <- resource
+}
+
+// sendJobMetric records and sends a gauge of the job
+func (s *scheduledScrape) sendJobMetric(jobMetricCh chan<- *promutil.PrometheusMetric, metric *promutil.PrometheusMetric) {
+	if s != nil {
+		s.mu.Lock()
+		s.results.jobMetrics = append(s.results.jobMetrics, metric)
+		s.mu.Unlock()
+	}
+	jobMetricCh <- metric
+}
+
+// finish sends the success gauge of the job and, when it succeeded, keeps its results for the next scrapes
+func (s *scheduledScrape) finish(status *jobScrapeStatus, jobMetricCh chan<- *promutil.PrometheusMetric) {
+	s.sendJobMetric(jobMetricCh, status.finish())
+	if s == nil || !status.success {
+		return
+	}
+
+	s.mu.Lock()
+	results := s.results
+	s.mu.Unlock()
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+	s.schedule.entries[s.key] = scheduleEntry{interval: s.interval, lastRun: s.start, results: results}
+}
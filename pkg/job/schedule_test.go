@@ -0,0 +1,178 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/services"
+)
+
+func TestJobScheduleStart(t *testing.T) {
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	key := scheduleKey{Kind: scheduleKindStatic, Region: "us-east-1"}
+	data := &cloudwatchData{Metric: aws.String("CPUUtilization"), GetMetricDataPoint: aws.Float64(1)}
+
+	testCases := []struct {
+		name string
+		// elapsed are the times of the scrapes since the first one
+		elapsed  []time.Duration
+		interval time.Duration
+		// failed are the scrapes of the job which fail
+		failed   map[int]bool
+		expected []bool
+	}{
+		{
+			name:     "every 5th scrape",
+			elapsed:  []time.Duration{0, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 20 * time.Minute, 25 * time.Minute, 30 * time.Minute},
+			interval: 25 * time.Minute,
+			expected: []bool{true, false, false, false, false, true, false},
+		},
+		{
+			name:     "scrape slightly early",
+			elapsed:  []time.Duration{0, 25*time.Minute - time.Second},
+			interval: 25 * time.Minute,
+			expected: []bool{true, true},
+		},
+		{
+			name:     "failed scrape retried",
+			elapsed:  []time.Duration{0, 5 * time.Minute, 10 * time.Minute},
+			interval: 25 * time.Minute,
+			failed:   map[int]bool{0: true},
+			expected: []bool{true, true, false},
+		},
+		{
+			name:     "every scrape without interval",
+			elapsed:  []time.Duration{0, 5 * time.Minute},
+			expected: []bool{true, true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule := newJobSchedule(TimeClock{})
+			for i, elapsed := range tc.elapsed {
+				resourceCh := make(chan *services.TaggedResource, 1)
+				cwDataCh := make(chan *cloudwatchData, 1)
+				jobMetricCh := make(chan *promutil.PrometheusMetric, 1)
+
+				scrape, replayed := schedule.start(key, tc.interval, start.Add(elapsed), resourceCh, cwDataCh, jobMetricCh)
+				assert.Equal(t, tc.expected[i], !replayed, "scrape %d", i)
+				if replayed {
+					// The results of the last successful scrape are sent again
+					require.Len(t, cwDataCh, 1)
+					assert.Same(t, data, <-cwDataCh)
+					require.Len(t, jobMetricCh, 1)
+					assert.Equal(t, float64(1), *(<-jobMetricCh).Value)
+					continue
+				}
+
+				scrape.record(data)
+				status := newJobScrapeStatus("AWS/EC2", "", "us-east-1", config.Role{}, nil)
+				status.setResult(nil)
+				if tc.failed[i] {
+					status.setResult(errors.New("throttled"))
+				}
+				scrape.finish(status, jobMetricCh)
+				require.Len(t, jobMetricCh, 1)
+				assert.Equal(t, ScrapeJobSuccessMetric, *(<-jobMetricCh).Name)
+			}
+		})
+	}
+}
+
+// callCountingCloudwatchAPI answers GetMetricData with the number of GetMetricData calls
+type callCountingCloudwatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	calls int32
+}
+
+func (c *callCountingCloudwatchAPI) ListMetricsPagesWithContext(_ aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, _ ...request.Option) error {
+	fn(&cloudwatch.ListMetricsOutput{Metrics: []*cloudwatch.Metric{{Namespace: input.Namespace, MetricName: input.MetricName}}}, true)
+	return nil
+}
+
+func (c *callCountingCloudwatchAPI) GetMetricDataPagesWithContext(_ aws.Context, input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, _ ...request.Option) error {
+	calls := float64(atomic.AddInt32(&c.calls, 1))
+	output := &cloudwatch.GetMetricDataOutput{}
+	for _, query := range input.MetricDataQueries {
+		output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:         query.Id,
+			Values:     []*float64{aws.Float64(calls)},
+			Timestamps: []*time.Time{input.EndTime},
+		})
+	}
+	fn(output, true)
+	return nil
+}
+
+func TestScrapeAwsDataScrapeInterval(t *testing.T) {
+	clock := &StubClock{currentTime: time.Now()}
+	previous := jobSchedules
+	jobSchedules = newJobSchedule(clock)
+	t.Cleanup(func() { jobSchedules = previous })
+
+	role := config.Role{RoleArn: "arn:aws:iam::123456789012:role/yace"}
+	newJob := func(name string, scrapeInterval time.Duration) *config.CustomNamespace {
+		return &config.CustomNamespace{
+			Name:           name,
+			Namespace:      "CustomEC2Metrics",
+			Regions:        []string{"us-east-1"},
+			Roles:          []config.Role{role},
+			ScrapeInterval: scrapeInterval,
+			Metrics:        []*config.Metric{{Name: name, Statistics: []string{"Average"}, Period: 60, Length: 60, NilToZero: aws.Bool(false)}},
+		}
+	}
+	// The daily job runs every 5th scrape of the exporter, the other one every scrape
+	cfg := config.ScrapeConf{CustomNamespace: []*config.CustomNamespace{newJob("daily", 25*time.Minute), newJob("frequent", 0)}}
+	daily := &callCountingCloudwatchAPI{}
+	frequent := &callCountingCloudwatchAPI{}
+
+	for i := 0; i < 10; i++ {
+		// Both jobs are in the same region, they get their client by metric name
+		cache := &testSessionCache{sts: accountSTS{}, cloudwatch: map[string]cloudwatchiface.CloudWatchAPI{"us-east-1": routingCloudwatchAPI{clients: map[string]cloudwatchiface.CloudWatchAPI{"daily": daily, "frequent": frequent}}}}
+
+		_, cwData, jobMetrics := ScrapeAwsData(context.Background(), cfg, 500, make(chan struct{}, 1), make(chan struct{}, 1), cache, logger.NewLogrusLogger(log.StandardLogger()))
+
+		require.Len(t, cwData, 2, "scrape %d", i)
+		require.Len(t, jobMetrics, 2, "scrape %d", i)
+		for _, data := range cwData {
+			expected := float64(i + 1)
+			if *data.Metric == "daily" {
+				// Exported again from the last scrape of the job, every 5th scrape
+				expected = float64(i/5 + 1)
+			}
+			assert.Equal(t, expected, *data.GetMetricDataPoint, "scrape %d of %s", i, *data.Metric)
+		}
+		clock.currentTime = clock.currentTime.Add(5 * time.Minute)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&daily.calls))
+	assert.Equal(t, int32(10), atomic.LoadInt32(&frequent.calls))
+}
+
+// routingCloudwatchAPI sends the requests of each metric to its own client
+type routingCloudwatchAPI struct {
+	cloudwatchiface.CloudWatchAPI
+	clients map[string]cloudwatchiface.CloudWatchAPI
+}
+
+func (r routingCloudwatchAPI) ListMetricsPagesWithContext(ctx aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, opts ...request.Option) error {
+	return r.clients[aws.StringValue(input.MetricName)].ListMetricsPagesWithContext(ctx, input, fn, opts...)
+}
+
+func (r routingCloudwatchAPI) GetMetricDataPagesWithContext(ctx aws.Context, input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, opts ...request.Option) error {
+	return r.clients[aws.StringValue(input.MetricDataQueries[0].MetricStat.Metric.MetricName)].GetMetricDataPagesWithContext(ctx, input, fn, opts...)
+}
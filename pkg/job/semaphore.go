@@ -0,0 +1,62 @@
+package job
+
+import (
+	"context"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+)
+
+// semaphore bounds the number of concurrent API calls with a set of layered semaphores,
+// a slot of each of them being needed for a call. The semaphores of a role come before
+// the global ones: a role waiting for its own slots never holds global slots which the
+// other roles could use, so that one busy account doesn't starve the others.
+type semaphore []chan struct{}
+
+// acquire takes a slot of every semaphore of s in order. It returns false, without
+// holding any slot, if ctx is done before.
+func (s semaphore) acquire(ctx context.Context) bool {
+	for i, ch := range s {
+		if !acquire(ctx, ch) {
+			s[:i].release()
+			return false
+		}
+	}
+	return true
+}
+
+// release gives back the slots taken by acquire
+func (s semaphore) release() {
+	for i := len(s) - 1; i >= 0; i-- {
+		<-s[i]
+	}
+}
+
+// roleSemaphores are the semaphores of the jobs of a role, layering its own limits,
+// if configured, under the global semaphores
+type roleSemaphores struct {
+	cloudwatch semaphore
+	tag        semaphore
+}
+
+// newRoleSemaphores returns the semaphores of every role of roles. Roles with the same
+// settings, e.g. used by several jobs, share their semaphores.
+func newRoleSemaphores(roles []config.Role, cloudwatchSemaphore, tagSemaphore chan struct{}) map[config.Role]roleSemaphores {
+	semaphores := make(map[config.Role]roleSemaphores, len(roles))
+	for _, role := range roles {
+		if _, ok := semaphores[role]; ok {
+			continue
+		}
+		s := roleSemaphores{
+			cloudwatch: semaphore{cloudwatchSemaphore},
+			tag:        semaphore{tagSemaphore},
+		}
+		if role.CloudwatchConcurrency > 0 {
+			s.cloudwatch = semaphore{make(chan struct{}, role.CloudwatchConcurrency), cloudwatchSemaphore}
+		}
+		if role.TagConcurrency > 0 {
+			s.tag = semaphore{make(chan struct{}, role.TagConcurrency), tagSemaphore}
+		}
+		semaphores[role] = s
+	}
+	return semaphores
+}
@@ -0,0 +1,59 @@
+package job
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+)
+
+func TestRoleSemaphoresIsolation(t *testing.T) {
+	busy := config.Role{RoleArn: "arn:aws:iam::111111111111:role/yace", CloudwatchConcurrency: 1}
+	idle := config.Role{RoleArn: "arn:aws:iam::222222222222:role/yace", CloudwatchConcurrency: 1}
+	unlimited := config.Role{RoleArn: "arn:aws:iam::333333333333:role/yace"}
+	global := make(chan struct{}, 2)
+
+	semaphores := newRoleSemaphores([]config.Role{busy, idle, busy, unlimited}, global, make(chan struct{}, 1))
+	require.Len(t, semaphores, 3)
+	assert.Len(t, semaphores[unlimited].cloudwatch, 1)
+
+	// The busy role saturates its own limit, and keeps waiting for more slots
+	require.True(t, semaphores[busy].cloudwatch.acquire(context.Background()))
+	waiting := make(chan bool)
+	waitCtx, cancelWait := context.WithCancel(context.Background())
+	go func() {
+		waiting <- semaphores[busy].cloudwatch.acquire(waitCtx)
+	}()
+
+	// The waiting call of the busy role holds no global slot, the idle role isn't blocked
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.True(t, semaphores[idle].cloudwatch.acquire(ctx))
+	assert.Len(t, global, 2)
+
+	cancelWait()
+	assert.False(t, <-waiting)
+
+	semaphores[idle].cloudwatch.release()
+	semaphores[busy].cloudwatch.release()
+	assert.Len(t, global, 0)
+	assert.Len(t, semaphores[busy].cloudwatch[0], 0)
+}
+
+func TestSemaphoreAcquireCancelled(t *testing.T) {
+	role := make(chan struct{}, 1)
+	global := make(chan struct{}, 1)
+	global <- struct{}{}
+	sem := semaphore{role, global}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// The slot of the role taken before waiting for the global one is given back
+	assert.False(t, sem.acquire(ctx))
+	assert.Len(t, role, 0)
+}
@@ -1,9 +1,19 @@
 package model
 
+import "time"
+
 const (
 	DefaultPeriodSeconds = int64(300)
 	DefaultLengthSeconds = int64(300)
-	DefaultDelaySeconds  = int64(300)
+	// DefaultLogsInsightsQueryTimeout is how long the results of a Logs Insights query are waited for
+	DefaultLogsInsightsQueryTimeout = 30 * time.Second
+	// DefaultRDSEnhancedMonitoringMaxAge is how old the latest OS metrics of an RDS instance can be to be exported
+	DefaultRDSEnhancedMonitoringMaxAge = 5 * time.Minute
+	// DefaultCredentialExpiryWindow is how long before their expiry the assumed credentials are refreshed
+	DefaultCredentialExpiryWindow = time.Minute
+	// DefaultCircuitBreakerFailures and DefaultCircuitBreakerCooldown are the defaults of the circuit breakers of the APIs
+	DefaultCircuitBreakerFailures = 5
+	DefaultCircuitBreakerCooldown = 5 * time.Minute
 )
 
 type LabelSet map[string]struct{}
@@ -11,4 +21,7 @@ type LabelSet map[string]struct{}
 type Tag struct {
 	Key   string `yaml:"key"`
 	Value string `yaml:"value"`
+	// Exists is only used to filter resources: when set, a resource matches if it
+	// has (or doesn't have) a tag with Key, whatever its value
+	Exists *bool `yaml:"exists"`
 }
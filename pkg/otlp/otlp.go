@@ -0,0 +1,264 @@
+// Package otlp pushes the metrics scraped by YACE to an OpenTelemetry collector, using OTLP over HTTP with JSON encoding.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+const scopeName = "github.com/nerdswords/yet-another-cloudwatch-exporter"
+
+// Exporter pushes metrics to an OTLP/HTTP endpoint, e.g. http://localhost:4318/v1/metrics
+type Exporter struct {
+	endpoint string
+	headers  map[string]string
+	version  string
+	client   *http.Client
+}
+
+func NewExporter(endpoint string, headers map[string]string, version string, timeout time.Duration) *Exporter {
+	return &Exporter{
+		endpoint: endpoint,
+		headers:  headers,
+		version:  version,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Push converts metrics to OTLP and sends them to the endpoint. Metrics without an exported timestamp are
+// reported at now.
+func (e *Exporter) Push(ctx context.Context, metrics []*promutil.PrometheusMetric, now time.Time) error {
+	body, err := json.Marshal(ToRequest(metrics, e.version, now))
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %w", e.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to push metrics to %s: %s: %s", e.endpoint, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// ToRequest converts metrics to an OTLP export request. Samples sharing a name are grouped as data points of a
+// single gauge, summary for the metrics exported as a Prometheus summary, or monotonic cumulative sum for
+// those exported as a Prometheus counter, in order of first appearance.
+func ToRequest(metrics []*promutil.PrometheusMetric, version string, now time.Time) *ExportMetricsServiceRequest {
+	otlpMetrics := []*Metric{}
+	byName := map[string]*Metric{}
+
+	for _, metric := range metrics {
+		timestamp := now
+		if metric.IncludeTimestamp {
+			timestamp = metric.Timestamp
+		}
+		timeUnixNano := strconv.FormatInt(timestamp.UnixNano(), 10)
+		attributes := toAttributes(metric.Labels)
+
+		otlpMetric, ok := byName[*metric.Name]
+		if !ok {
+			otlpMetric = &Metric{Name: *metric.Name}
+			byName[*metric.Name] = otlpMetric
+			otlpMetrics = append(otlpMetrics, otlpMetric)
+		}
+		if otlpMetric.Description == "" {
+			otlpMetric.Description = metric.Help
+		}
+
+		if metric.Summary != nil {
+			if otlpMetric.Summary == nil {
+				otlpMetric.Summary = &Summary{}
+			}
+			// A missing sum is sent as NaN and a missing count left out, as in the Prometheus exposition
+			dataPoint := &SummaryDataPoint{
+				Attributes:     attributes,
+				TimeUnixNano:   timeUnixNano,
+				Sum:            Double(math.NaN()),
+				QuantileValues: toQuantileValues(metric.Summary.Quantiles),
+			}
+			if metric.Summary.SampleCount != nil {
+				dataPoint.Count = strconv.FormatUint(*metric.Summary.SampleCount, 10)
+			}
+			if metric.Summary.Sum != nil {
+				dataPoint.Sum = Double(*metric.Summary.Sum)
+			}
+			otlpMetric.Summary.DataPoints = append(otlpMetric.Summary.DataPoints, dataPoint)
+			continue
+		}
+
+		if metric.Counter {
+			if otlpMetric.Sum == nil {
+				otlpMetric.Sum = &Sum{AggregationTemporality: aggregationTemporalityCumulative, IsMonotonic: true}
+			}
+			otlpMetric.Sum.DataPoints = append(otlpMetric.Sum.DataPoints, &NumberDataPoint{
+				Attributes:   attributes,
+				TimeUnixNano: timeUnixNano,
+				AsDouble:     Double(*metric.Value),
+			})
+			continue
+		}
+
+		if otlpMetric.Gauge == nil {
+			otlpMetric.Gauge = &Gauge{}
+		}
+		otlpMetric.Gauge.DataPoints = append(otlpMetric.Gauge.DataPoints, &NumberDataPoint{
+			Attributes:   attributes,
+			TimeUnixNano: timeUnixNano,
+			AsDouble:     Double(*metric.Value),
+		})
+	}
+
+	return &ExportMetricsServiceRequest{
+		ResourceMetrics: []*ResourceMetrics{{
+			Resource: &Resource{
+				Attributes: []*KeyValue{{Key: "service.name", Value: &AnyValue{StringValue: "yace"}}},
+			},
+			ScopeMetrics: []*ScopeMetrics{{
+				Scope:   &InstrumentationScope{Name: scopeName, Version: version},
+				Metrics: otlpMetrics,
+			}},
+		}},
+	}
+}
+
+func toAttributes(labels map[string]string) []*KeyValue {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]*KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attributes = append(attributes, &KeyValue{Key: key, Value: &AnyValue{StringValue: labels[key]}})
+	}
+	return attributes
+}
+
+func toQuantileValues(quantiles map[float64]float64) []*ValueAtQuantile {
+	values := make([]*ValueAtQuantile, 0, len(quantiles))
+	for quantile, value := range quantiles {
+		values = append(values, &ValueAtQuantile{Quantile: Double(quantile), Value: Double(value)})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Quantile < values[j].Quantile })
+	return values
+}
+
+// The types below follow the JSON mapping of the OTLP protobuf messages, in which 64 bit integers are strings.
+
+type ExportMetricsServiceRequest struct {
+	ResourceMetrics []*ResourceMetrics `json:"resourceMetrics"`
+}
+
+type ResourceMetrics struct {
+	Resource     *Resource       `json:"resource"`
+	ScopeMetrics []*ScopeMetrics `json:"scopeMetrics"`
+}
+
+type Resource struct {
+	Attributes []*KeyValue `json:"attributes"`
+}
+
+type ScopeMetrics struct {
+	Scope   *InstrumentationScope `json:"scope"`
+	Metrics []*Metric             `json:"metrics"`
+}
+
+type InstrumentationScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type Metric struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Gauge       *Gauge   `json:"gauge,omitempty"`
+	Sum         *Sum     `json:"sum,omitempty"`
+	Summary     *Summary `json:"summary,omitempty"`
+}
+
+type Gauge struct {
+	DataPoints []*NumberDataPoint `json:"dataPoints"`
+}
+
+// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE, the temporality of Prometheus counters
+const aggregationTemporalityCumulative = 2
+
+type Sum struct {
+	DataPoints             []*NumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                `json:"aggregationTemporality"`
+	IsMonotonic            bool               `json:"isMonotonic"`
+}
+
+type NumberDataPoint struct {
+	Attributes   []*KeyValue `json:"attributes"`
+	TimeUnixNano string      `json:"timeUnixNano"`
+	AsDouble     Double      `json:"asDouble"`
+}
+
+type Summary struct {
+	DataPoints []*SummaryDataPoint `json:"dataPoints"`
+}
+
+type SummaryDataPoint struct {
+	Attributes     []*KeyValue        `json:"attributes"`
+	TimeUnixNano   string             `json:"timeUnixNano"`
+	Count          string             `json:"count,omitempty"`
+	Sum            Double             `json:"sum"`
+	QuantileValues []*ValueAtQuantile `json:"quantileValues"`
+}
+
+type ValueAtQuantile struct {
+	Quantile Double `json:"quantile"`
+	Value    Double `json:"value"`
+}
+
+type KeyValue struct {
+	Key   string    `json:"key"`
+	Value *AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// Double is a float64 encoded like protobuf JSON does, which, unlike encoding/json, supports NaN and infinities
+type Double float64
+
+func (d Double) MarshalJSON() ([]byte, error) {
+	f := float64(d)
+	switch {
+	case math.IsNaN(f):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(f, 1):
+		return []byte(`"Infinity"`), nil
+	case math.IsInf(f, -1):
+		return []byte(`"-Infinity"`), nil
+	}
+	return json.Marshal(f)
+}
@@ -0,0 +1,169 @@
+package otlp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+func TestToRequest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timestamp := time.Unix(1690000000, 0)
+
+	metrics := []*promutil.PrometheusMetric{
+		{
+			Name:   aws.String("aws_ec2_cpuutilization_average"),
+			Labels: map[string]string{"name": "i-1", "region": "us-east-1"},
+			Value:  aws.Float64(42),
+			Help:   "Percentage of the allocated compute units in use on the instance.",
+		},
+		{
+			Name:             aws.String("aws_ec2_cpuutilization_average"),
+			Labels:           map[string]string{"name": "i-2", "region": "us-east-1"},
+			Value:            aws.Float64(math.NaN()),
+			IncludeTimestamp: true,
+			Timestamp:        timestamp,
+		},
+		{
+			Name:    aws.String("aws_elb_request_count_sum_total"),
+			Labels:  map[string]string{"name": "elb"},
+			Value:   aws.Float64(1234),
+			Counter: true,
+		},
+		{
+			Name:   aws.String("aws_elb_latency"),
+			Labels: map[string]string{"name": "elb"},
+			Summary: &promutil.Summary{
+				Quantiles:   map[float64]float64{0.99: 3, 0.5: 1},
+				SampleCount: aws.Uint64(10),
+				Sum:         aws.Float64(12),
+			},
+		},
+	}
+
+	request := ToRequest(metrics, "v1.0.0", now)
+
+	require.Len(t, request.ResourceMetrics, 1)
+	require.Len(t, request.ResourceMetrics[0].ScopeMetrics, 1)
+	scope := request.ResourceMetrics[0].ScopeMetrics[0]
+	assert.Equal(t, "v1.0.0", scope.Scope.Version)
+	require.Len(t, scope.Metrics, 3)
+
+	gauge := scope.Metrics[0]
+	assert.Equal(t, "aws_ec2_cpuutilization_average", gauge.Name)
+	assert.Equal(t, "Percentage of the allocated compute units in use on the instance.", gauge.Description)
+	assert.Nil(t, gauge.Summary)
+	require.Len(t, gauge.Gauge.DataPoints, 2)
+	assert.Equal(t, []*KeyValue{
+		{Key: "name", Value: &AnyValue{StringValue: "i-1"}},
+		{Key: "region", Value: &AnyValue{StringValue: "us-east-1"}},
+	}, gauge.Gauge.DataPoints[0].Attributes)
+	assert.Equal(t, "1700000000000000000", gauge.Gauge.DataPoints[0].TimeUnixNano)
+	assert.Equal(t, Double(42), gauge.Gauge.DataPoints[0].AsDouble)
+	assert.Equal(t, "1690000000000000000", gauge.Gauge.DataPoints[1].TimeUnixNano)
+
+	sum := scope.Metrics[1]
+	assert.Equal(t, "aws_elb_request_count_sum_total", sum.Name)
+	assert.Empty(t, sum.Description)
+	assert.Nil(t, sum.Gauge)
+	require.Len(t, sum.Sum.DataPoints, 1)
+	assert.Equal(t, Double(1234), sum.Sum.DataPoints[0].AsDouble)
+	assert.Equal(t, aggregationTemporalityCumulative, sum.Sum.AggregationTemporality)
+	assert.True(t, sum.Sum.IsMonotonic)
+
+	summary := scope.Metrics[2]
+	assert.Equal(t, "aws_elb_latency", summary.Name)
+	assert.Nil(t, summary.Gauge)
+	require.Len(t, summary.Summary.DataPoints, 1)
+	assert.Equal(t, "10", summary.Summary.DataPoints[0].Count)
+	assert.Equal(t, Double(12), summary.Summary.DataPoints[0].Sum)
+	assert.Equal(t, []*ValueAtQuantile{{Quantile: 0.5, Value: 1}, {Quantile: 0.99, Value: 3}}, summary.Summary.DataPoints[0].QuantileValues)
+
+	// NaN values can't be encoded by encoding/json
+	_, err := json.Marshal(request)
+	require.NoError(t, err)
+}
+
+func TestDoubleMarshalJSON(t *testing.T) {
+	testCases := []struct {
+		value    float64
+		expected string
+	}{
+		{value: 1.5, expected: `1.5`},
+		{value: math.NaN(), expected: `"NaN"`},
+		{value: math.Inf(1), expected: `"Infinity"`},
+		{value: math.Inf(-1), expected: `"-Infinity"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.expected, func(t *testing.T) {
+			b, err := json.Marshal(Double(tc.value))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, string(b))
+		})
+	}
+}
+
+func TestExporterPush(t *testing.T) {
+	metrics := []*promutil.PrometheusMetric{
+		{
+			Name:   aws.String("aws_ec2_cpuutilization_average"),
+			Labels: map[string]string{"name": "i-1"},
+			Value:  aws.Float64(42),
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		status      int
+		expectedErr bool
+	}{
+		{
+			name:   "accepted",
+			status: http.StatusOK,
+		},
+		{
+			name:        "rejected",
+			status:      http.StatusBadRequest,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received ExportMetricsServiceRequest
+			var headers http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				headers = r.Header
+				body, _ := io.ReadAll(r.Body)
+				_ = json.Unmarshal(body, &received)
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			exporter := NewExporter(server.URL+"/v1/metrics", map[string]string{"Authorization": "Bearer token"}, "v1.0.0", time.Second)
+			err := exporter.Push(context.Background(), metrics, time.Now())
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, "application/json", headers.Get("Content-Type"))
+			assert.Equal(t, "Bearer token", headers.Get("Authorization"))
+			require.Len(t, received.ResourceMetrics, 1)
+			assert.Equal(t, "aws_ec2_cpuutilization_average", received.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name)
+		})
+	}
+}
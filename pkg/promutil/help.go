@@ -0,0 +1,74 @@
+package promutil
+
+// defaultHelp is the help of the metrics without a description
+const defaultHelp = "Help is not implemented yet."
+
+// metricDescriptions are the built-in descriptions of CloudWatch metrics by namespace and metric name,
+// exported as the help of their series. They follow the AWS documentation of the namespaces.
+var metricDescriptions = map[string]map[string]string{
+	"AWS/EC2": {
+		"CPUUtilization":           "Percentage of the allocated compute units in use on the instance.",
+		"NetworkIn":                "Number of bytes received by the instance on all its network interfaces.",
+		"NetworkOut":               "Number of bytes sent out by the instance on all its network interfaces.",
+		"DiskReadOps":              "Completed read operations from all the instance store volumes of the instance.",
+		"DiskWriteOps":             "Completed write operations to all the instance store volumes of the instance.",
+		"StatusCheckFailed":        "Whether the instance has passed both the instance and system status checks, 0 (passed) or 1 (failed).",
+		"CPUCreditBalance":         "Number of earned CPU credits accumulated by a burstable instance.",
+		"EBSIOBalance%":            "Percentage of I/O credits remaining in the burst bucket of the EBS volumes of the instance.",
+		"MetadataNoToken":          "Number of times the instance metadata service was accessed without a token.",
+		"NetworkPacketsIn":         "Number of packets received by the instance on all its network interfaces.",
+		"NetworkPacketsOut":        "Number of packets sent out by the instance on all its network interfaces.",
+		"EBSReadBytes":             "Bytes read from all the EBS volumes attached to the instance.",
+		"EBSWriteBytes":            "Bytes written to all the EBS volumes attached to the instance.",
+		"StatusCheckFailed_System": "Whether the instance has passed the system status check, 0 (passed) or 1 (failed).",
+	},
+	"AWS/S3": {
+		"BucketSizeBytes": "Amount of data stored in the bucket, in bytes, reported once a day per storage class.",
+		"NumberOfObjects": "Total number of objects stored in the bucket, reported once a day.",
+		"AllRequests":     "Number of HTTP requests of any type made to the bucket, with request metrics enabled.",
+		"4xxErrors":       "Number of HTTP 4xx client error responses of the bucket, with request metrics enabled.",
+		"5xxErrors":       "Number of HTTP 5xx server error responses of the bucket, with request metrics enabled.",
+	},
+	"AWS/SQS": {
+		"ApproximateNumberOfMessagesVisible":    "Number of messages available for retrieval from the queue.",
+		"ApproximateNumberOfMessagesNotVisible": "Number of messages in flight, sent to a client but not yet deleted.",
+		"ApproximateNumberOfMessagesDelayed":    "Number of messages in the queue which are delayed and not available for reading yet.",
+		"ApproximateAgeOfOldestMessage":         "Age of the oldest non-deleted message in the queue, in seconds.",
+		"NumberOfMessagesSent":                  "Number of messages added to the queue.",
+		"NumberOfMessagesReceived":              "Number of messages returned by calls to ReceiveMessage.",
+		"NumberOfMessagesDeleted":               "Number of messages deleted from the queue.",
+		"NumberOfEmptyReceives":                 "Number of ReceiveMessage calls which did not return a message.",
+	},
+	"AWS/Lambda": {
+		"Invocations":          "Number of times the function code is invoked, including successful invocations and invocations resulting in an error.",
+		"Errors":               "Number of invocations resulting in a function error.",
+		"Throttles":            "Number of invocation requests which are throttled.",
+		"Duration":             "Time the function code spends processing an event, in milliseconds.",
+		"ConcurrentExecutions": "Number of function instances processing events.",
+		"IteratorAge":          "Age of the last record in the event, in milliseconds, for event source mappings reading from streams.",
+	},
+	"AWS/ApplicationELB": {
+		"RequestCount":              "Number of requests processed over IPv4 and IPv6 by the load balancer.",
+		"TargetResponseTime":        "Time elapsed, in seconds, after the request leaves the load balancer until a response from the target is received.",
+		"HTTPCode_ELB_5XX_Count":    "Number of HTTP 5XX server error codes originating from the load balancer.",
+		"HTTPCode_Target_5XX_Count": "Number of HTTP 5XX response codes generated by the targets.",
+		"HealthyHostCount":          "Number of targets considered healthy.",
+		"UnHealthyHostCount":        "Number of targets considered unhealthy.",
+		"ActiveConnectionCount":     "Total number of concurrent TCP connections active from clients to the load balancer and from the load balancer to targets.",
+		"ProcessedBytes":            "Total number of bytes processed by the load balancer over IPv4 and IPv6.",
+	},
+	"AWS/RDS": {
+		"CPUUtilization":      "Percentage of CPU utilization of the DB instance.",
+		"DatabaseConnections": "Number of client network connections to the database instance.",
+		"FreeStorageSpace":    "Amount of available storage space, in bytes.",
+		"FreeableMemory":      "Amount of available random access memory, in bytes.",
+		"ReadLatency":         "Average amount of time taken per disk read I/O operation, in seconds.",
+		"WriteLatency":        "Average amount of time taken per disk write I/O operation, in seconds.",
+		"ReplicaLag":          "Amount of time a read replica DB instance lags behind the source DB instance, in seconds.",
+	},
+}
+
+// MetricHelp returns the built-in description of the CloudWatch metric of a namespace, empty when it has none
+func MetricHelp(namespace string, metric string) string {
+	return metricDescriptions[namespace][metric]
+}
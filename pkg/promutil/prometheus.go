@@ -1,20 +1,28 @@
 package promutil
 
 import (
+	"math"
 	"regexp"
 	"sort"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/model"
 )
 
 var (
-	CloudwatchAPICounter = prometheus.NewCounter(prometheus.CounterOpts{
+	CloudwatchAPICounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "yace_cloudwatch_requests_total",
-		Help: "Help is not implemented yet.",
-	})
+		Help: "Number of calls made to the CloudWatch and resource tagging APIs, by API and region.",
+	}, []string{"api", "region"})
+	CloudwatchAPIThrottleCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_request_throttles_total",
+		Help: "Number of calls to the CloudWatch and resource tagging APIs which were throttled, by API and region.",
+	}, []string{"api", "region"})
 	CloudwatchAPIErrorCounter = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "yace_cloudwatch_request_errors",
 		Help: "Help is not implemented yet.",
@@ -58,6 +66,103 @@ var (
 		Name: "yace_cloudwatch_dmsapi_requests_total",
 		Help: "Help is not implemented yet.",
 	})
+	BedrockAPICounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_bedrockapi_requests_total",
+		Help: "Help is not implemented yet.",
+	})
+	ElastiCacheAPICounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_elasticacheapi_requests_total",
+		Help: "Number of calls made to the ElastiCache API to list the member clusters of replication groups.",
+	})
+	ConfigServiceAPICounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_configserviceapi_requests_total",
+		Help: "Number of AWS Config advanced queries made to discover resources.",
+	})
+	IAMAPICounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_iamapi_requests_total",
+		Help: "Number of calls made to the IAM API to look up the alias of the accounts.",
+	})
+	S3APICounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_s3api_requests_total",
+		Help: "Number of calls made to the S3 API to discover the request metrics configurations of buckets.",
+	})
+	CloudFormationAPICounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_cloudformationapi_requests_total",
+		Help: "Number of calls made to the CloudFormation API to list the resources of stacks.",
+	})
+	MetricDataPartialCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_metricdata_partial_total",
+		Help: "Number of GetMetricData results which weren't complete after their last page, by status code and region.",
+	}, []string{"status_code", "region"})
+	StaleDatapointsDroppedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_stale_datapoints_dropped_total",
+		Help: "Number of datapoints returned by GetMetricData which weren't exported because they were older than the maxDatapointAge of their job, by namespace and region.",
+	}, []string{"namespace", "region"})
+	LogsInsightsQueryCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_logs_insights_queries_total",
+		Help: "Number of CloudWatch Logs Insights queries run by the logs insights jobs, by final status and region.",
+	}, []string{"status", "region"})
+	ListMetricsCacheHitCounter = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "yace_cloudwatch_listmetrics_cache_hits_total",
+		Help: "Number of ListMetrics calls answered from the cache.",
+	})
+	STSFailuresCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_sts_failures_total",
+		Help: "Number of jobs for which the account id couldn't be determined with STS GetCallerIdentity.",
+	}, []string{"region", "arn"})
+	CredentialRefreshCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_credential_refreshes_total",
+		Help: "Number of times the credentials of an assumed role were retrieved, the refreshes of expiring credentials included, by role and result: success or failure.",
+	}, []string{"arn", "result"})
+	RateLimiterWaitGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yace_rate_limiter_wait_seconds",
+		Help: "Time the last request to a rate limited API waited for the rate limiter, by API, region and account.",
+	}, []string{"api", "region", "account"})
+	CircuitBreakerStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yace_circuit_breaker_state",
+		Help: "State of the circuit breaker of an API, by API, region and account: 0 closed, 1 open, skipping the requests, or 2 half-open, testing whether the API recovered.",
+	}, []string{"api", "region", "account"})
+	SeriesLimitExceededGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yace_series_limit_exceeded",
+		Help: "1 when the last scrape of a job for a region and account exceeded its maxSeries limit, 0 otherwise.",
+	}, []string{"job_type", "job_name", "region", "account"})
+	ResourceSampleRatioGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yace_resource_sample_ratio",
+		Help: "sampleRatio of a discovery job, the share of its discovered resources whose metrics are queried, by job type, region and account.",
+	}, []string{"job_type", "region", "account"})
+	ResourcesSampledGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "yace_resources_sampled",
+		Help: "Number of the resources discovered by the last scrape of a discovery job with a sampleRatio whose metrics are queried, by job type, region and account.",
+	}, []string{"job_type", "region", "account"})
+	ScrapeJobDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "yace_scrape_job_duration_seconds",
+		Help:    "Time spent scraping a single job for a region and role.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"job_type", "job_name", "region", "account", "arn"})
+	ScrapeJobPhaseDurationHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "yace_scrape_job_phase_duration_seconds",
+		Help:    "Time spent in each phase of the scrape of a job for a region: tagging, list_metrics, get_metric_data or get_metric_statistics.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"job_type", "region", "phase"})
+	TriggeredScrapesCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_triggered_scrapes_total",
+		Help: "Number of scrapes triggered on demand with the /scrape endpoint, by result: success or error. Concurrent triggers sharing a scrape count once.",
+	}, []string{"result"})
+	LabelsSanitizedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_labels_sanitized_total",
+		Help: "Number of label values of exported series which were sanitized, by reason: invalid_characters or too_long.",
+	}, []string{"reason"})
+	LabelsDroppedCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "yace_labels_dropped_total",
+		Help: "Number of labels of exported series which were dropped, by reason: invalid_name, invalid_characters or too_long.",
+	}, []string{"reason"})
+)
+
+// Reasons of the sanitized and dropped labels
+const (
+	LabelReasonInvalidName       = "invalid_name"
+	LabelReasonInvalidCharacters = "invalid_characters"
+	LabelReasonTooLong           = "too_long"
 )
 
 var replacer = strings.NewReplacer(
@@ -84,6 +189,20 @@ type PrometheusMetric struct {
 	Value            *float64
 	IncludeTimestamp bool
 	Timestamp        time.Time
+	// Summary is set for metrics exported as a Prometheus summary instead of a gauge, Value is unused then
+	Summary *Summary
+	// Counter is set for metrics exported as a Prometheus counter instead of a gauge
+	Counter bool
+	// Help is the description of the metric, a generic one is exported when empty
+	Help string
+}
+
+// Summary holds the quantiles of a metric exported as a Prometheus summary. SampleCount and Sum
+// are nil when the statistics they come from have no datapoint.
+type Summary struct {
+	Quantiles   map[float64]float64
+	SampleCount *uint64
+	Sum         *float64
 }
 
 type PrometheusCollector struct {
@@ -92,10 +211,26 @@ type PrometheusCollector struct {
 
 func NewPrometheusCollector(metrics []*PrometheusMetric) *PrometheusCollector {
 	return &PrometheusCollector{
-		metrics: removeDuplicatedMetrics(metrics),
+		metrics: unifyHelp(removeDuplicatedMetrics(metrics)),
 	}
 }
 
+// unifyHelp sets the help of all the series of a metric to the first one they have, as the series
+// of a metric can't have different help. Series of different CloudWatch metrics may share a name,
+// e.g. when they are renamed.
+func unifyHelp(metrics []*PrometheusMetric) []*PrometheusMetric {
+	help := make(map[string]string)
+	for _, metric := range metrics {
+		if _, ok := help[*metric.Name]; !ok && metric.Help != "" {
+			help[*metric.Name] = metric.Help
+		}
+	}
+	for _, metric := range metrics {
+		metric.Help = help[*metric.Name]
+	}
+	return metrics
+}
+
 func (p *PrometheusCollector) Describe(descs chan<- *prometheus.Desc) {
 	for _, metric := range p.metrics {
 		descs <- createDesc(metric)
@@ -111,16 +246,40 @@ func (p *PrometheusCollector) Collect(metrics chan<- prometheus.Metric) {
 func createDesc(metric *PrometheusMetric) *prometheus.Desc {
 	return prometheus.NewDesc(
 		*metric.Name,
-		"Help is not implemented yet.",
+		metricHelp(metric),
 		nil,
 		metric.Labels,
 	)
 }
 
+// metricHelp returns the help of metric, or a generic one when it has none
+func metricHelp(metric *PrometheusMetric) string {
+	if metric.Help == "" {
+		return defaultHelp
+	}
+	return metric.Help
+}
+
 func createMetric(metric *PrometheusMetric) prometheus.Metric {
+	if metric.Summary != nil {
+		summary := createSummary(metric)
+		if !metric.IncludeTimestamp {
+			return summary
+		}
+		return prometheus.NewMetricWithTimestamp(metric.Timestamp, summary)
+	}
+
+	if metric.Counter {
+		counter := prometheus.MustNewConstMetric(createDesc(metric), prometheus.CounterValue, *metric.Value)
+		if !metric.IncludeTimestamp {
+			return counter
+		}
+		return prometheus.NewMetricWithTimestamp(metric.Timestamp, counter)
+	}
+
 	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
 		Name:        *metric.Name,
-		Help:        "Help is not implemented yet.",
+		Help:        metricHelp(metric),
 		ConstLabels: metric.Labels,
 	})
 
@@ -133,11 +292,41 @@ func createMetric(metric *PrometheusMetric) prometheus.Metric {
 	return prometheus.NewMetricWithTimestamp(metric.Timestamp, gauge)
 }
 
+// createSummary returns the summary of metric. A missing sum is exported as NaN rather than 0, a missing
+// count is left unset as it can't be NaN.
+func createSummary(metric *PrometheusMetric) prometheus.Metric {
+	sum := math.NaN()
+	if metric.Summary.Sum != nil {
+		sum = *metric.Summary.Sum
+	}
+	if metric.Summary.SampleCount == nil {
+		return unsetCountSummary{prometheus.MustNewConstSummary(createDesc(metric), 0, sum, metric.Summary.Quantiles)}
+	}
+	return prometheus.MustNewConstSummary(createDesc(metric), *metric.Summary.SampleCount, sum, metric.Summary.Quantiles)
+}
+
+// unsetCountSummary is a summary without sample count
+type unsetCountSummary struct {
+	prometheus.Metric
+}
+
+func (s unsetCountSummary) Write(out *dto.Metric) error {
+	if err := s.Metric.Write(out); err != nil {
+		return err
+	}
+	out.Summary.SampleCount = nil
+	return nil
+}
+
 func removeDuplicatedMetrics(metrics []*PrometheusMetric) []*PrometheusMetric {
 	keys := make(map[string]bool)
 	filteredMetrics := []*PrometheusMetric{}
 	for _, metric := range metrics {
 		check := *metric.Name + combineLabels(metric.Labels)
+		// Samples of the same series are only duplicates if they share the same timestamp
+		if metric.IncludeTimestamp {
+			check += metric.Timestamp.String()
+		}
 		if _, value := keys[check]; !value {
 			keys[check] = true
 			filteredMetrics = append(filteredMetrics, metric)
@@ -159,6 +348,20 @@ func combineLabels(labels map[string]string) string {
 	return combinedLabels
 }
 
+// invalidMetricNameRegexp matches the characters of names which aren't valid in metric names
+var invalidMetricNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// NamespaceMetricPrefix returns the part of the names of the metrics of a CloudWatch namespace derived from it,
+// e.g. aws_ec2 for AWS/EC2 or aws_custom_app_v2 for Custom/App.v2. The characters which aren't valid in metric
+// names are replaced by _.
+func NamespaceMetricPrefix(namespace string) string {
+	promNs := strings.ToLower(namespace)
+	if !strings.HasPrefix(promNs, "aws") {
+		promNs = "aws_" + promNs
+	}
+	return invalidMetricNameRegexp.ReplaceAllString(PromString(promNs), "_")
+}
+
 func PromString(text string) string {
 	text = splitString(text)
 	return strings.ToLower(sanitize(text))
@@ -174,6 +377,40 @@ func PromStringTag(text string, labelsSnakeCase bool) (bool, string) {
 	return model.LabelName(s).IsValid(), s
 }
 
+// SanitizeLabelValue returns value with its invalid UTF-8 sequences and control characters replaced by _,
+// and truncated to maxLength bytes, without splitting a character, when maxLength is positive. With drop,
+// it returns false instead for the values which would be changed, whose label should be dropped.
+func SanitizeLabelValue(value string, maxLength int, drop bool) (string, bool) {
+	invalid := !utf8.ValidString(value) || strings.IndexFunc(value, unicode.IsControl) >= 0
+	if invalid {
+		if drop {
+			LabelsDroppedCounter.WithLabelValues(LabelReasonInvalidCharacters).Inc()
+			return "", false
+		}
+		LabelsSanitizedCounter.WithLabelValues(LabelReasonInvalidCharacters).Inc()
+		value = strings.Map(func(r rune) rune {
+			if r == utf8.RuneError || unicode.IsControl(r) {
+				return '_'
+			}
+			return r
+		}, strings.ToValidUTF8(value, string(utf8.RuneError)))
+	}
+
+	if maxLength > 0 && len(value) > maxLength {
+		if drop {
+			LabelsDroppedCounter.WithLabelValues(LabelReasonTooLong).Inc()
+			return "", false
+		}
+		LabelsSanitizedCounter.WithLabelValues(LabelReasonTooLong).Inc()
+		end := maxLength
+		for end > 0 && !utf8.RuneStart(value[end]) {
+			end--
+		}
+		value = value[:end]
+	}
+	return value, true
+}
+
 func sanitize(text string) string {
 	return replacer.Replace(text)
 }
@@ -1,10 +1,18 @@
 package promutil
 
 import (
+	"fmt"
+	"math"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSplitString(t *testing.T) {
@@ -55,6 +63,34 @@ func TestSanitize(t *testing.T) {
 	}
 }
 
+func TestNamespaceMetricPrefix(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			input:  "AWS/EC2",
+			output: "aws_ec2",
+		},
+		{
+			input:  "AWS/ApplicationELB",
+			output: "aws_applicationelb",
+		},
+		{
+			input:  "Custom/App.v2",
+			output: "aws_custom_app_v2",
+		},
+		{
+			input:  "Custom/App+v2 (beta)",
+			output: "aws_custom_app_v2__beta_",
+		},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.output, NamespaceMetricPrefix(tc.input))
+	}
+}
+
 func TestRemoveDuplicateMetrics(t *testing.T) {
 	testCases := []struct {
 		name   string
@@ -188,6 +224,37 @@ func TestRemoveDuplicateMetrics(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "same metric with different timestamps",
+			input: []*PrometheusMetric{
+				{
+					Name:             aws.String("metric1"),
+					Labels:           map[string]string{"label1": "value1"},
+					Timestamp:        time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+					IncludeTimestamp: true,
+				},
+				{
+					Name:             aws.String("metric1"),
+					Labels:           map[string]string{"label1": "value1"},
+					Timestamp:        time.Date(2023, 1, 1, 0, 5, 0, 0, time.UTC),
+					IncludeTimestamp: true,
+				},
+			},
+			output: []*PrometheusMetric{
+				{
+					Name:             aws.String("metric1"),
+					Labels:           map[string]string{"label1": "value1"},
+					Timestamp:        time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+					IncludeTimestamp: true,
+				},
+				{
+					Name:             aws.String("metric1"),
+					Labels:           map[string]string{"label1": "value1"},
+					Timestamp:        time.Date(2023, 1, 1, 0, 5, 0, 0, time.UTC),
+					IncludeTimestamp: true,
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -259,3 +326,152 @@ func TestPromStringTag(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateMetricSummary(t *testing.T) {
+	metric := createMetric(&PrometheusMetric{
+		Name:   aws.String("aws_applicationelb_target_response_time"),
+		Labels: map[string]string{"name": "lb"},
+		Summary: &Summary{
+			Quantiles:   map[float64]float64{0.5: 0.1, 0.99: 0.7},
+			SampleCount: aws.Uint64(42),
+			Sum:         aws.Float64(8.4),
+		},
+	})
+
+	var out dto.Metric
+	require.NoError(t, metric.Write(&out))
+	require.NotNil(t, out.Summary)
+	assert.Equal(t, uint64(42), out.Summary.GetSampleCount())
+	assert.Equal(t, 8.4, out.Summary.GetSampleSum())
+	require.Len(t, out.Summary.Quantile, 2)
+	assert.Equal(t, 0.5, out.Summary.Quantile[0].GetQuantile())
+	assert.Equal(t, 0.1, out.Summary.Quantile[0].GetValue())
+	assert.Equal(t, 0.99, out.Summary.Quantile[1].GetQuantile())
+	assert.Equal(t, 0.7, out.Summary.Quantile[1].GetValue())
+}
+
+func TestCreateMetricSummaryWithoutSumOrCount(t *testing.T) {
+	metric := createMetric(&PrometheusMetric{
+		Name:   aws.String("aws_applicationelb_target_response_time"),
+		Labels: map[string]string{"name": "lb"},
+		Summary: &Summary{
+			Quantiles: map[float64]float64{0.5: 0.1},
+		},
+	})
+
+	var out dto.Metric
+	require.NoError(t, metric.Write(&out))
+	require.NotNil(t, out.Summary)
+	assert.Nil(t, out.Summary.SampleCount)
+	assert.True(t, math.IsNaN(out.Summary.GetSampleSum()))
+	require.Len(t, out.Summary.Quantile, 1)
+	assert.Equal(t, 0.1, out.Summary.Quantile[0].GetValue())
+}
+
+func TestCreateMetricCounter(t *testing.T) {
+	metric := createMetric(&PrometheusMetric{
+		Name:    aws.String("aws_applicationelb_request_count_sum_total"),
+		Labels:  map[string]string{"name": "lb"},
+		Value:   aws.Float64(1234),
+		Counter: true,
+	})
+
+	var out dto.Metric
+	require.NoError(t, metric.Write(&out))
+	require.NotNil(t, out.Counter)
+	assert.Nil(t, out.Gauge)
+	assert.Equal(t, 1234.0, out.Counter.GetValue())
+}
+
+func TestCreateMetricHelp(t *testing.T) {
+	testCases := []struct {
+		name     string
+		help     string
+		expected string
+	}{
+		{name: "with help", help: "Number of requests processed by the load balancer.", expected: "Number of requests processed by the load balancer."},
+		{name: "without help", expected: "Help is not implemented yet."},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			metric := &PrometheusMetric{
+				Name:   aws.String("aws_applicationelb_request_count_sum"),
+				Labels: map[string]string{"name": "lb"},
+				Value:  aws.Float64(1),
+				Help:   tc.help,
+			}
+			assert.Contains(t, createDesc(metric).String(), fmt.Sprintf("help: %q", tc.expected))
+			assert.Contains(t, createMetric(metric).Desc().String(), fmt.Sprintf("help: %q", tc.expected))
+		})
+	}
+}
+
+func TestPrometheusCollectorUnifiesHelp(t *testing.T) {
+	// Series of a metric sharing a name, e.g. renamed, are gathered with the first help
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewPrometheusCollector([]*PrometheusMetric{
+		{Name: aws.String("aws_custom_requests_sum"), Labels: map[string]string{"name": "a"}, Value: aws.Float64(1)},
+		{Name: aws.String("aws_custom_requests_sum"), Labels: map[string]string{"name": "b"}, Value: aws.Float64(2), Help: "Requests of the service."},
+		{Name: aws.String("aws_custom_requests_sum"), Labels: map[string]string{"name": "c"}, Value: aws.Float64(3), Help: "Requests of the other service."},
+	}))
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "Requests of the service.", families[0].GetHelp())
+	assert.Len(t, families[0].Metric, 3)
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	testCases := []struct {
+		name      string
+		value     string
+		maxLength int
+		drop      bool
+		ok        bool
+		out       string
+		sanitized string
+		dropped   string
+	}{
+		{name: "valid value", value: "my-bucket", ok: true, out: "my-bucket"},
+		{name: "empty value", value: "", maxLength: 8, ok: true, out: ""},
+		{name: "unicode value", value: "café 東京 🚀", ok: true, out: "café 東京 🚀"},
+		{name: "invalid utf-8 replaced", value: "a\xffb", ok: true, out: "a_b", sanitized: LabelReasonInvalidCharacters},
+		{name: "control characters replaced", value: "line\nbreak\x00", ok: true, out: "line_break_", sanitized: LabelReasonInvalidCharacters},
+		{name: "invalid utf-8 dropped", value: "a\xffb", drop: true, ok: false, dropped: LabelReasonInvalidCharacters},
+		{name: "long value truncated", value: strings.Repeat("a", 300), maxLength: 256, ok: true, out: strings.Repeat("a", 256), sanitized: LabelReasonTooLong},
+		{name: "long value truncated without splitting a character", value: "東京都", maxLength: 7, ok: true, out: "東京", sanitized: LabelReasonTooLong},
+		{name: "long value dropped", value: strings.Repeat("a", 300), maxLength: 256, drop: true, ok: false, dropped: LabelReasonTooLong},
+		{name: "value of max length kept", value: "東京", maxLength: 6, drop: true, ok: true, out: "東京"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			counters := map[string]float64{}
+			for _, reason := range []string{LabelReasonInvalidCharacters, LabelReasonTooLong} {
+				counters["sanitized/"+reason] = testutil.ToFloat64(LabelsSanitizedCounter.WithLabelValues(reason))
+				counters["dropped/"+reason] = testutil.ToFloat64(LabelsDroppedCounter.WithLabelValues(reason))
+			}
+
+			out, ok := SanitizeLabelValue(tc.value, tc.maxLength, tc.drop)
+			assert.Equal(t, tc.ok, ok)
+			if ok {
+				assert.Equal(t, tc.out, out)
+			}
+
+			for _, reason := range []string{LabelReasonInvalidCharacters, LabelReasonTooLong} {
+				expected := counters["sanitized/"+reason]
+				if tc.sanitized == reason {
+					expected++
+				}
+				assert.Equal(t, expected, testutil.ToFloat64(LabelsSanitizedCounter.WithLabelValues(reason)), "sanitized %s", reason)
+				expected = counters["dropped/"+reason]
+				if tc.dropped == reason {
+					expected++
+				}
+				assert.Equal(t, expected, testutil.ToFloat64(LabelsDroppedCounter.WithLabelValues(reason)), "dropped %s", reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package remotewrite
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The types below are the messages of the remote write protocol, prometheus.WriteRequest and the messages it
+// references, with the fields YACE sends. Marshal encodes them with the field numbers of the protocol.
+
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+type TimeSeries struct {
+	// Labels are sorted by name, __name__ included
+	Labels  []Label
+	Samples []Sample
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value float64
+	// Timestamp is in milliseconds since the epoch
+	Timestamp int64
+}
+
+type MetricMetadata struct {
+	Type             MetricType
+	MetricFamilyName string
+	Help             string
+}
+
+// MetricType is the type of a metric family, its values are those of the MetricMetadata.MetricType enum
+type MetricType int32
+
+const (
+	MetricTypeUnknown MetricType = 0
+	MetricTypeCounter MetricType = 1
+	MetricTypeGauge   MetricType = 2
+	MetricTypeSummary MetricType = 5
+)
+
+func (r *WriteRequest) Marshal() []byte {
+	var b []byte
+	for _, series := range r.Timeseries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, series.marshal())
+	}
+	for _, metadata := range r.Metadata {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, metadata.marshal())
+	}
+	return b
+}
+
+func (s TimeSeries) marshal() []byte {
+	var b []byte
+	for _, label := range s.Labels {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, label.marshal())
+	}
+	for _, sample := range s.Samples {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sample.marshal())
+	}
+	return b
+}
+
+func (l Label) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, l.Name)
+	return appendString(b, 2, l.Value)
+}
+
+func (s Sample) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(s.Value))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(s.Timestamp))
+}
+
+func (m MetricMetadata) marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(m.Type))
+	b = appendString(b, 2, m.MetricFamilyName)
+	return appendString(b, 4, m.Help)
+}
+
+// appendString appends a string field, leaving it out when it's empty like proto3 does
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
@@ -0,0 +1,202 @@
+// Package remotewrite pushes the metrics scraped by YACE to a Prometheus remote write endpoint, e.g. of Prometheus
+// with the remote write receiver enabled, Mimir, Thanos or VictoriaMetrics.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+const (
+	// defaultRetryBackoff is the delay before the first retry of a failed push, doubled for every retry
+	defaultRetryBackoff = time.Second
+	// maxRetryBackoff caps the delay between the retries
+	maxRetryBackoff = 30 * time.Second
+)
+
+// Auth are the credentials of the remote write requests: basic auth when Username is set, or a bearer token
+type Auth struct {
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// Exporter pushes metrics to a remote write endpoint, e.g. http://localhost:9090/api/v1/write
+type Exporter struct {
+	url        string
+	auth       Auth
+	version    string
+	maxRetries int
+	client     *http.Client
+	// retryBackoff is the delay before the first retry
+	retryBackoff time.Duration
+}
+
+func NewExporter(url string, auth Auth, version string, maxRetries int, timeout time.Duration) *Exporter {
+	return &Exporter{
+		url:          url,
+		auth:         auth,
+		version:      version,
+		maxRetries:   maxRetries,
+		client:       &http.Client{Timeout: timeout},
+		retryBackoff: defaultRetryBackoff,
+	}
+}
+
+// Push converts metrics to a remote write request and sends it to the endpoint. Metrics without an exported
+// timestamp are reported at now. The requests failing with a network error, a 5xx or a 429 status are retried
+// up to maxRetries times; the other statuses mean the samples were rejected, sending them again wouldn't help.
+func (e *Exporter) Push(ctx context.Context, metrics []*promutil.PrometheusMetric, now time.Time) error {
+	request, err := ToWriteRequest(metrics, now)
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, request.Marshal())
+
+	backoff := e.retryBackoff
+	for attempt := 0; ; attempt++ {
+		retryable, err := e.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt >= e.maxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// send sends a single remote write request, returning whether it's worth retrying when it fails
+func (e *Exporter) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	req.Header.Set("User-Agent", "yace/"+e.version)
+	switch {
+	case e.auth.Username != "":
+		req.SetBasicAuth(e.auth.Username, e.auth.Password)
+	case e.auth.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+e.auth.BearerToken)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, fmt.Errorf("failed to push metrics to %s: %w", e.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retryable, fmt.Errorf("failed to push metrics to %s: %s: %s", e.url, resp.Status, bytes.TrimSpace(msg))
+	}
+	return false, nil
+}
+
+// ToWriteRequest converts metrics to a remote write request. The metrics are expanded into series by the
+// collector of the /metrics endpoint, so that they are the same as when they are scraped: summaries become a
+// series per quantile, plus _sum and _count series.
+func ToWriteRequest(metrics []*promutil.PrometheusMetric, now time.Time) (*WriteRequest, error) {
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(promutil.NewPrometheusCollector(metrics)); err != nil {
+		return nil, fmt.Errorf("failed to collect the metrics: %w", err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect the metrics: %w", err)
+	}
+
+	request := &WriteRequest{}
+	nowMs := now.UnixMilli()
+	for _, family := range families {
+		name := family.GetName()
+		request.Metadata = append(request.Metadata, MetricMetadata{
+			Type:             metricType(family.GetType()),
+			MetricFamilyName: name,
+			Help:             family.GetHelp(),
+		})
+
+		for _, metric := range family.Metric {
+			timestamp := nowMs
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			sample := func(name string, value float64, extra ...Label) {
+				request.Timeseries = append(request.Timeseries, TimeSeries{
+					Labels:  toLabels(name, metric.Label, extra...),
+					Samples: []Sample{{Value: value, Timestamp: timestamp}},
+				})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.Quantile {
+					sample(name, quantile.GetValue(), Label{Name: model.QuantileLabel, Value: strconv.FormatFloat(quantile.GetQuantile(), 'f', -1, 64)})
+				}
+				sample(name+"_sum", summary.GetSampleSum())
+				// The count is unset when the SampleCount statistic has no datapoint
+				if summary.SampleCount != nil {
+					sample(name+"_count", float64(summary.GetSampleCount()))
+				}
+			case dto.MetricType_COUNTER:
+				sample(name, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				sample(name, metric.GetGauge().GetValue())
+			default:
+				sample(name, metric.GetUntyped().GetValue())
+			}
+		}
+	}
+	return request, nil
+}
+
+// toLabels returns the labels of a series, __name__ included, sorted by name as remote write requires
+func toLabels(name string, pairs []*dto.LabelPair, extra ...Label) []Label {
+	labels := make([]Label, 0, len(pairs)+len(extra)+1)
+	labels = append(labels, Label{Name: model.MetricNameLabel, Value: name})
+	for _, pair := range pairs {
+		labels = append(labels, Label{Name: pair.GetName(), Value: pair.GetValue()})
+	}
+	labels = append(labels, extra...)
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+	return labels
+}
+
+func metricType(t dto.MetricType) MetricType {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return MetricTypeCounter
+	case dto.MetricType_GAUGE:
+		return MetricTypeGauge
+	case dto.MetricType_SUMMARY:
+		return MetricTypeSummary
+	}
+	return MetricTypeUnknown
+}
@@ -0,0 +1,283 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+func TestToWriteRequest(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	timestamp := time.Unix(1690000000, 0)
+
+	metrics := []*promutil.PrometheusMetric{
+		{
+			Name:   aws.String("aws_ec2_cpuutilization_average"),
+			Labels: map[string]string{"name": "i-1", "region": "us-east-1"},
+			Value:  aws.Float64(42),
+			Help:   "Percentage of the allocated compute units in use on the instance.",
+		},
+		{
+			Name:             aws.String("aws_ec2_cpuutilization_average"),
+			Labels:           map[string]string{"name": "i-2", "region": "us-east-1"},
+			Value:            aws.Float64(7),
+			IncludeTimestamp: true,
+			Timestamp:        timestamp,
+		},
+		{
+			Name:    aws.String("aws_elb_request_count_sum_total"),
+			Labels:  map[string]string{"name": "elb"},
+			Value:   aws.Float64(1234),
+			Counter: true,
+		},
+		{
+			Name:   aws.String("aws_elb_latency"),
+			Labels: map[string]string{"name": "elb"},
+			Summary: &promutil.Summary{
+				Quantiles:   map[float64]float64{0.99: 3, 0.5: 1},
+				SampleCount: aws.Uint64(10),
+				Sum:         aws.Float64(12),
+			},
+		},
+	}
+
+	request, err := ToWriteRequest(metrics, now)
+	require.NoError(t, err)
+
+	// The families are sorted by name, like on /metrics
+	assert.Equal(t, []MetricMetadata{
+		{Type: MetricTypeGauge, MetricFamilyName: "aws_ec2_cpuutilization_average", Help: "Percentage of the allocated compute units in use on the instance."},
+		{Type: MetricTypeSummary, MetricFamilyName: "aws_elb_latency", Help: "Help is not implemented yet."},
+		{Type: MetricTypeCounter, MetricFamilyName: "aws_elb_request_count_sum_total", Help: "Help is not implemented yet."},
+	}, request.Metadata)
+	assert.Equal(t, []TimeSeries{
+		{
+			Labels:  []Label{{Name: "__name__", Value: "aws_ec2_cpuutilization_average"}, {Name: "name", Value: "i-1"}, {Name: "region", Value: "us-east-1"}},
+			Samples: []Sample{{Value: 42, Timestamp: 1700000000000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "aws_ec2_cpuutilization_average"}, {Name: "name", Value: "i-2"}, {Name: "region", Value: "us-east-1"}},
+			Samples: []Sample{{Value: 7, Timestamp: 1690000000000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "aws_elb_latency"}, {Name: "name", Value: "elb"}, {Name: "quantile", Value: "0.5"}},
+			Samples: []Sample{{Value: 1, Timestamp: 1700000000000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "aws_elb_latency"}, {Name: "name", Value: "elb"}, {Name: "quantile", Value: "0.99"}},
+			Samples: []Sample{{Value: 3, Timestamp: 1700000000000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "aws_elb_latency_sum"}, {Name: "name", Value: "elb"}},
+			Samples: []Sample{{Value: 12, Timestamp: 1700000000000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "aws_elb_latency_count"}, {Name: "name", Value: "elb"}},
+			Samples: []Sample{{Value: 10, Timestamp: 1700000000000}},
+		},
+		{
+			Labels:  []Label{{Name: "__name__", Value: "aws_elb_request_count_sum_total"}, {Name: "name", Value: "elb"}},
+			Samples: []Sample{{Value: 1234, Timestamp: 1700000000000}},
+		},
+	}, request.Timeseries)
+}
+
+func TestWriteRequestMarshal(t *testing.T) {
+	request := &WriteRequest{
+		Timeseries: []TimeSeries{{
+			Labels:  []Label{{Name: "__name__", Value: "aws_ec2_cpuutilization_average"}, {Name: "name", Value: "i-1"}},
+			Samples: []Sample{{Value: math.NaN(), Timestamp: 1700000000000}},
+		}},
+		Metadata: []MetricMetadata{{Type: MetricTypeGauge, MetricFamilyName: "aws_ec2_cpuutilization_average"}},
+	}
+
+	decoded := unmarshalWriteRequest(t, request.Marshal())
+
+	require.Len(t, decoded.Timeseries, 1)
+	assert.Equal(t, request.Timeseries[0].Labels, decoded.Timeseries[0].Labels)
+	require.Len(t, decoded.Timeseries[0].Samples, 1)
+	assert.True(t, math.IsNaN(decoded.Timeseries[0].Samples[0].Value))
+	assert.Equal(t, int64(1700000000000), decoded.Timeseries[0].Samples[0].Timestamp)
+	assert.Equal(t, request.Metadata, decoded.Metadata)
+}
+
+func TestExporterPush(t *testing.T) {
+	metrics := []*promutil.PrometheusMetric{
+		{
+			Name:   aws.String("aws_ec2_cpuutilization_average"),
+			Labels: map[string]string{"name": "i-1"},
+			Value:  aws.Float64(42),
+		},
+	}
+
+	testCases := []struct {
+		name string
+		auth Auth
+		// statuses are the statuses of the responses to the successive requests, the last one is repeated
+		statuses              []int
+		expectedRequests      int32
+		expectedErr           bool
+		expectedAuthorization string
+	}{
+		{
+			name:                  "accepted with basic auth",
+			auth:                  Auth{Username: "yace", Password: "secret"},
+			statuses:              []int{http.StatusNoContent},
+			expectedRequests:      1,
+			expectedAuthorization: "Basic eWFjZTpzZWNyZXQ=",
+		},
+		{
+			name:                  "accepted with bearer token",
+			auth:                  Auth{BearerToken: "token"},
+			statuses:              []int{http.StatusOK},
+			expectedRequests:      1,
+			expectedAuthorization: "Bearer token",
+		},
+		{
+			name:             "retried after server errors",
+			statuses:         []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent},
+			expectedRequests: 3,
+		},
+		{
+			name:             "retries exhausted",
+			statuses:         []int{http.StatusInternalServerError},
+			expectedRequests: 3,
+			expectedErr:      true,
+		},
+		{
+			name:             "rejected without retry",
+			statuses:         []int{http.StatusBadRequest},
+			expectedRequests: 1,
+			expectedErr:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests int32
+			var body []byte
+			var headers http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := int(atomic.AddInt32(&requests, 1)) - 1
+				if i >= len(tc.statuses) {
+					i = len(tc.statuses) - 1
+				}
+				headers = r.Header
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tc.statuses[i])
+			}))
+			defer server.Close()
+
+			exporter := NewExporter(server.URL+"/api/v1/write", tc.auth, "v1.0.0", 2, time.Second)
+			exporter.retryBackoff = time.Millisecond
+			err := exporter.Push(context.Background(), metrics, time.Now())
+			assert.Equal(t, tc.expectedRequests, atomic.LoadInt32(&requests))
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			assert.Equal(t, "application/x-protobuf", headers.Get("Content-Type"))
+			assert.Equal(t, "snappy", headers.Get("Content-Encoding"))
+			assert.Equal(t, "0.1.0", headers.Get("X-Prometheus-Remote-Write-Version"))
+			assert.Equal(t, tc.expectedAuthorization, headers.Get("Authorization"))
+			decompressed, err := snappy.Decode(nil, body)
+			require.NoError(t, err)
+			received := unmarshalWriteRequest(t, decompressed)
+			require.Len(t, received.Timeseries, 1)
+			assert.Equal(t, Label{Name: "__name__", Value: "aws_ec2_cpuutilization_average"}, received.Timeseries[0].Labels[0])
+			assert.Equal(t, float64(42), received.Timeseries[0].Samples[0].Value)
+		})
+	}
+}
+
+// unmarshalWriteRequest decodes a remote write request like a receiver does
+func unmarshalWriteRequest(t *testing.T, b []byte) *WriteRequest {
+	request := &WriteRequest{}
+	consumeFields(t, b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		value, n := protowire.ConsumeBytes(b)
+		switch num {
+		case 1:
+			var series TimeSeries
+			consumeFields(t, value, func(num protowire.Number, typ protowire.Type, b []byte) int {
+				value, n := protowire.ConsumeBytes(b)
+				switch num {
+				case 1:
+					var label Label
+					consumeFields(t, value, func(num protowire.Number, typ protowire.Type, b []byte) int {
+						value, n := protowire.ConsumeString(b)
+						if num == 1 {
+							label.Name = value
+						} else {
+							label.Value = value
+						}
+						return n
+					})
+					series.Labels = append(series.Labels, label)
+				case 2:
+					var sample Sample
+					consumeFields(t, value, func(num protowire.Number, typ protowire.Type, b []byte) int {
+						if num == 1 {
+							value, n := protowire.ConsumeFixed64(b)
+							sample.Value = math.Float64frombits(value)
+							return n
+						}
+						value, n := protowire.ConsumeVarint(b)
+						sample.Timestamp = int64(value)
+						return n
+					})
+					series.Samples = append(series.Samples, sample)
+				}
+				return n
+			})
+			request.Timeseries = append(request.Timeseries, series)
+		case 3:
+			var metadata MetricMetadata
+			consumeFields(t, value, func(num protowire.Number, typ protowire.Type, b []byte) int {
+				if typ == protowire.VarintType {
+					value, n := protowire.ConsumeVarint(b)
+					metadata.Type = MetricType(value)
+					return n
+				}
+				value, n := protowire.ConsumeString(b)
+				switch num {
+				case 2:
+					metadata.MetricFamilyName = value
+				case 4:
+					metadata.Help = value
+				}
+				return n
+			})
+			request.Metadata = append(request.Metadata, metadata)
+		}
+		return n
+	})
+	return request
+}
+
+// consumeFields calls consume with the number, type and value of every field of a message. consume returns the
+// length of the value.
+func consumeFields(t *testing.T, b []byte, consume func(protowire.Number, protowire.Type, []byte) int) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0, "invalid tag")
+		b = b[n:]
+		n = consume(num, typ, b)
+		require.GreaterOrEqual(t, n, 0, "invalid field %d", num)
+		b = b[n:]
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// StackCache shares the resources of the CloudFormation stacks selected by the jobs of a scrape, so that
+// a stack is listed once instead of once per job. A StackCache is meant to be used for a single scrape.
+type StackCache struct {
+	mu      sync.Mutex
+	entries map[string]*stackCacheEntry
+}
+
+type stackCacheEntry struct {
+	once sync.Once
+	ids  map[string]struct{}
+	err  error
+}
+
+func NewStackCache() *StackCache {
+	return &StackCache{entries: map[string]*stackCacheEntry{}}
+}
+
+// get returns the result of list, calling it only for the first caller of a key
+func (c *StackCache) get(key string, list func() (map[string]struct{}, error)) (map[string]struct{}, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &stackCacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.ids, entry.err = list()
+	})
+	return entry.ids, entry.err
+}
+
+// FilterByStack returns the resources which are resources of the CloudFormation stack of job
+func (iface TagsInterface) FilterByStack(ctx context.Context, job *config.Job, region string, resources []*TaggedResource) ([]*TaggedResource, error) {
+	stack := job.CloudFormationStack
+	list := func() (map[string]struct{}, error) {
+		return iface.listStackResourceIds(ctx, stack.Name, stack.IncludeNestedStacks)
+	}
+
+	var ids map[string]struct{}
+	var err error
+	if iface.StackCache != nil {
+		key := strings.Join([]string{iface.AccountId, region, stack.Name, strconv.FormatBool(stack.IncludeNestedStacks)}, "/")
+		ids, err = iface.StackCache.get(key, list)
+	} else {
+		ids, err = list()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list the resources of CloudFormation stack %s: %w", stack.Name, err)
+	}
+
+	var filtered []*TaggedResource
+	for _, resource := range resources {
+		if stackHasResource(ids, resource.ARN) {
+			filtered = append(filtered, resource)
+		} else {
+			iface.Logger.Debug("Skipping resource which is not a resource of the CloudFormation stack", "arn", resource.ARN, "stack", stack.Name)
+		}
+	}
+	return filtered, nil
+}
+
+// listStackResourceIds returns the physical ids of the resources of stack, and of the stacks nested in it
+// with nested. ListStackResources is used as DescribeStackResources only returns the first 100 resources.
+func (iface TagsInterface) listStackResourceIds(ctx context.Context, stack string, nested bool) (map[string]struct{}, error) {
+	ids := map[string]struct{}{}
+	listed := map[string]bool{}
+	stacks := []string{stack}
+	for len(stacks) > 0 {
+		name := stacks[0]
+		stacks = stacks[1:]
+		if listed[name] {
+			continue
+		}
+		listed[name] = true
+
+		err := iface.CloudFormationClient.ListStackResourcesPagesWithContext(ctx, &cloudformation.ListStackResourcesInput{StackName: aws.String(name)},
+			func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+				promutil.CloudFormationAPICounter.Inc()
+				for _, resource := range page.StackResourceSummaries {
+					// Resources being created or which failed to be don't have a physical id yet
+					id := aws.StringValue(resource.PhysicalResourceId)
+					if id == "" {
+						continue
+					}
+					if nested && aws.StringValue(resource.ResourceType) == "AWS::CloudFormation::Stack" {
+						stacks = append(stacks, id)
+					}
+					ids[stackResourceId(id)] = struct{}{}
+				}
+				return true
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// stackResourceId returns the physical id of a stack resource as found in its ARN. The physical id of an
+// SQS queue is its URL, ending with its name like its ARN.
+func stackResourceId(physicalId string) string {
+	if strings.HasPrefix(physicalId, "https://") {
+		return physicalId[strings.LastIndex(physicalId, "/")+1:]
+	}
+	return physicalId
+}
+
+// stackHasResource returns true when arn is the ARN of a resource whose physical id is in ids. Depending on
+// its type, the physical id of a resource is its ARN, e.g. for load balancers, or its name or id ending its
+// ARN, e.g. for buckets, instances or databases.
+func stackHasResource(ids map[string]struct{}, arn string) bool {
+	if _, ok := ids[arn]; ok {
+		return true
+	}
+	// The resource of the ARN, after arn:partition:service:region:account:
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 {
+		return false
+	}
+	resource := parts[5]
+	for _, id := range []string{
+		resource,
+		resource[strings.LastIndex(resource, "/")+1:],
+		resource[strings.LastIndex(resource, ":")+1:],
+	} {
+		if _, ok := ids[id]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+type stackResourcesClient struct {
+	cloudformationiface.CloudFormationAPI
+	// stacks are the pages of resources of every stack, stacks without pages don't exist
+	stacks map[string][][]*cloudformation.StackResourceSummary
+	calls  int
+}
+
+func (c *stackResourcesClient) ListStackResourcesPagesWithContext(_ aws.Context, input *cloudformation.ListStackResourcesInput, fn func(*cloudformation.ListStackResourcesOutput, bool) bool, _ ...request.Option) error {
+	c.calls++
+	pages, ok := c.stacks[*input.StackName]
+	if !ok {
+		return errors.New("ValidationError: Stack with id " + *input.StackName + " does not exist")
+	}
+	for i, page := range pages {
+		if !fn(&cloudformation.ListStackResourcesOutput{StackResourceSummaries: page}, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func stackResource(resourceType, physicalId string) *cloudformation.StackResourceSummary {
+	summary := &cloudformation.StackResourceSummary{ResourceType: aws.String(resourceType)}
+	if physicalId != "" {
+		summary.PhysicalResourceId = aws.String(physicalId)
+	}
+	return summary
+}
+
+func TestFilterByStack(t *testing.T) {
+	stacks := map[string][][]*cloudformation.StackResourceSummary{
+		"app": {
+			{
+				stackResource("AWS::S3::Bucket", "app-bucket"),
+				stackResource("AWS::SQS::Queue", "https://sqs.eu-west-1.amazonaws.com/123456789012/app-queue"),
+				stackResource("AWS::ElasticLoadBalancingV2::LoadBalancer", "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/app-alb/50dc6c495c0c9188"),
+			},
+			{
+				stackResource("AWS::RDS::DBInstance", "app-db"),
+				stackResource("AWS::CloudFormation::Stack", "arn:aws:cloudformation:eu-west-1:123456789012:stack/app-workers/1a2b3c"),
+				// Being created
+				stackResource("AWS::EC2::Instance", ""),
+			},
+		},
+		"arn:aws:cloudformation:eu-west-1:123456789012:stack/app-workers/1a2b3c": {
+			{stackResource("AWS::EC2::Instance", "i-0123456789abcdef0")},
+		},
+	}
+	resources := []*TaggedResource{
+		{ARN: "arn:aws:s3:::app-bucket"},
+		{ARN: "arn:aws:sqs:eu-west-1:123456789012:app-queue"},
+		{ARN: "arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/app-alb/50dc6c495c0c9188"},
+		{ARN: "arn:aws:rds:eu-west-1:123456789012:db:app-db"},
+		{ARN: "arn:aws:ec2:eu-west-1:123456789012:instance/i-0123456789abcdef0"},
+		{ARN: "arn:aws:s3:::other-bucket"},
+		{ARN: "arn:aws:rds:eu-west-1:123456789012:db:other-db"},
+	}
+
+	testCases := []struct {
+		name     string
+		stack    config.CloudFormationStack
+		expected []string
+		err      bool
+	}{
+		{
+			name:  "stack",
+			stack: config.CloudFormationStack{Name: "app"},
+			expected: []string{
+				"arn:aws:s3:::app-bucket",
+				"arn:aws:sqs:eu-west-1:123456789012:app-queue",
+				"arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/app-alb/50dc6c495c0c9188",
+				"arn:aws:rds:eu-west-1:123456789012:db:app-db",
+			},
+		},
+		{
+			name:  "stack with nested stacks",
+			stack: config.CloudFormationStack{Name: "app", IncludeNestedStacks: true},
+			expected: []string{
+				"arn:aws:s3:::app-bucket",
+				"arn:aws:sqs:eu-west-1:123456789012:app-queue",
+				"arn:aws:elasticloadbalancing:eu-west-1:123456789012:loadbalancer/app/app-alb/50dc6c495c0c9188",
+				"arn:aws:rds:eu-west-1:123456789012:db:app-db",
+				"arn:aws:ec2:eu-west-1:123456789012:instance/i-0123456789abcdef0",
+			},
+		},
+		{
+			name:  "missing stack",
+			stack: config.CloudFormationStack{Name: "missing"},
+			err:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			iface := TagsInterface{
+				CloudFormationClient: &stackResourcesClient{stacks: stacks},
+				Logger:               logger.NewLogrusLogger(log.StandardLogger()),
+			}
+			job := &config.Job{CloudFormationStack: &tc.stack}
+			filtered, err := iface.FilterByStack(context.Background(), job, "eu-west-1", resources)
+			if tc.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %d resources", len(filtered))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var arns []string
+			for _, resource := range filtered {
+				arns = append(arns, resource.ARN)
+			}
+			if !reflect.DeepEqual(arns, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, arns)
+			}
+		})
+	}
+}
+
+func TestFilterByStackCache(t *testing.T) {
+	client := &stackResourcesClient{stacks: map[string][][]*cloudformation.StackResourceSummary{
+		"app": {{stackResource("AWS::S3::Bucket", "app-bucket")}},
+	}}
+	iface := TagsInterface{
+		CloudFormationClient: client,
+		StackCache:           NewStackCache(),
+		AccountId:            "123456789012",
+		Logger:               logger.NewLogrusLogger(log.StandardLogger()),
+	}
+	resources := []*TaggedResource{{ARN: "arn:aws:s3:::app-bucket"}}
+
+	for _, job := range []*config.Job{
+		{Type: "s3", CloudFormationStack: &config.CloudFormationStack{Name: "app"}},
+		{Type: "s3", CloudFormationStack: &config.CloudFormationStack{Name: "app"}},
+		{Type: "s3", CloudFormationStack: &config.CloudFormationStack{Name: "app", IncludeNestedStacks: true}},
+	} {
+		filtered, err := iface.FilterByStack(context.Background(), job, "eu-west-1", resources)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(filtered) != 1 {
+			t.Errorf("expected the bucket of the stack, got %d resources", len(filtered))
+		}
+	}
+	if client.calls != 2 {
+		t.Errorf("expected the stack to be listed once per nesting, got %d calls", client.calls)
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// configQueryLimit is the maximum number of results of an AWS Config advanced query page
+const configQueryLimit = 100
+
+// ConfigCache shares the resources returned by an AWS Config aggregator between the regions of
+// a job, so that the aggregator is queried once instead of once per region. A ConfigCache is
+// meant to be used for a single scrape.
+type ConfigCache struct {
+	mu      sync.Mutex
+	entries map[string]*configCacheEntry
+}
+
+type configCacheEntry struct {
+	once      sync.Once
+	resources []configResource
+	err       error
+}
+
+func NewConfigCache() *ConfigCache {
+	return &ConfigCache{entries: map[string]*configCacheEntry{}}
+}
+
+// get returns the result of query, calling it only for the first caller of a key
+func (c *ConfigCache) get(key string, query func() ([]configResource, error)) ([]configResource, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &configCacheEntry{}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.resources, entry.err = query()
+	})
+	return entry.resources, entry.err
+}
+
+// configResource is a result of the advanced queries built by configQuery
+type configResource struct {
+	ARN       string `json:"arn"`
+	AwsRegion string `json:"awsRegion"`
+	Tags      []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"tags"`
+}
+
+// configQuery returns the advanced query selecting the resources of resourceTypes in accountId
+func configQuery(resourceTypes []string, accountId string) string {
+	quoted := make([]string, 0, len(resourceTypes))
+	for _, resourceType := range resourceTypes {
+		quoted = append(quoted, "'"+resourceType+"'")
+	}
+	return fmt.Sprintf("SELECT arn, awsRegion, tags WHERE resourceType IN (%s) AND accountId = '%s'", strings.Join(quoted, ", "), accountId)
+}
+
+// configQueryChangedSince returns the advanced query selecting the resources of resourceTypes in
+// accountId whose configuration, tags included, was captured by AWS Config after since
+func configQueryChangedSince(resourceTypes []string, accountId string, since time.Time) string {
+	return fmt.Sprintf("%s AND configurationItemCaptureTime > '%s'", configQuery(resourceTypes, accountId), since.UTC().Format(time.RFC3339))
+}
+
+// getConfigResources discovers the resources of job in region with AWS Config, through the
+// aggregator of the job when set. The resources are returned like the tagging API does.
+func (iface TagsInterface) getConfigResources(ctx context.Context, job *config.Job, svc *ServiceFilter, region string) ([]*TaggedResource, error) {
+	if len(svc.ConfigResourceTypes) == 0 {
+		return nil, fmt.Errorf("service %s doesn't support resource discovery with AWS Config", job.Type)
+	}
+	all, err := iface.queryConfigResources(ctx, job, region, configQuery(svc.ConfigResourceTypes, iface.AccountId))
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []*TaggedResource
+	for _, resource := range all {
+		if resource.FilterThroughTags(job.SearchTags) {
+			resources = append(resources, resource)
+		} else {
+			iface.Logger.Debug("Skipping resource because search tags do not match", "arn", resource.ARN)
+		}
+	}
+	return resources, nil
+}
+
+// GetChangedConfigResources returns the resources of job in region whose configuration was captured by
+// AWS Config after since. Unlike Get, they aren't filtered by the search tags of the job, for the
+// caller to know about the resources which no longer match them.
+func (iface TagsInterface) GetChangedConfigResources(ctx context.Context, job *config.Job, region string, since time.Time) ([]*TaggedResource, error) {
+	svc := SupportedServices.GetService(job.Type)
+	if len(svc.ConfigResourceTypes) == 0 {
+		return nil, fmt.Errorf("service %s doesn't support resource discovery with AWS Config", job.Type)
+	}
+	return iface.queryConfigResources(ctx, job, region, configQueryChangedSince(svc.ConfigResourceTypes, iface.AccountId, since))
+}
+
+// queryConfigResources returns the resources of region selected by query, through the aggregator of job when set
+func (iface TagsInterface) queryConfigResources(ctx context.Context, job *config.Job, region string, query string) ([]*TaggedResource, error) {
+	var configResources []configResource
+	var err error
+	if job.ConfigAggregator != nil {
+		key := job.ConfigAggregator.Region + "/" + job.ConfigAggregator.Name + "/" + query
+		selectAggregate := func() ([]configResource, error) {
+			return iface.selectAggregateResourceConfig(ctx, job.ConfigAggregator, query)
+		}
+		if iface.ConfigCache != nil {
+			configResources, err = iface.ConfigCache.get(key, selectAggregate)
+		} else {
+			configResources, err = selectAggregate()
+		}
+	} else {
+		configResources, err = iface.selectResourceConfig(ctx, query, region)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []*TaggedResource
+	for _, configResource := range configResources {
+		if configResource.AwsRegion != region {
+			continue
+		}
+		resource := TaggedResource{
+			ARN:       configResource.ARN,
+			Namespace: job.Type,
+			Region:    region,
+		}
+		for _, t := range configResource.Tags {
+			resource.Tags = append(resource.Tags, model.Tag{Key: t.Key, Value: t.Value})
+		}
+		resources = append(resources, &resource)
+	}
+	return resources, nil
+}
+
+func (iface TagsInterface) selectResourceConfig(ctx context.Context, query string, region string) ([]configResource, error) {
+	var resources []configResource
+	input := &configservice.SelectResourceConfigInput{
+		Expression: aws.String(query),
+		Limit:      aws.Int64(configQueryLimit),
+	}
+	var parseErr error
+	err := iface.ConfigClient.SelectResourceConfigPagesWithContext(ctx, input, func(page *configservice.SelectResourceConfigOutput, lastPage bool) bool {
+		promutil.ConfigServiceAPICounter.Inc()
+		promutil.CloudwatchAPICounter.WithLabelValues("SelectResourceConfig", region).Inc()
+		resources, parseErr = appendConfigResults(resources, page.Results)
+		return parseErr == nil && !lastPage
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resources, parseErr
+}
+
+func (iface TagsInterface) selectAggregateResourceConfig(ctx context.Context, aggregator *config.ConfigAggregator, query string) ([]configResource, error) {
+	var resources []configResource
+	input := &configservice.SelectAggregateResourceConfigInput{
+		ConfigurationAggregatorName: aws.String(aggregator.Name),
+		Expression:                  aws.String(query),
+		Limit:                       aws.Int64(configQueryLimit),
+	}
+	var parseErr error
+	err := iface.ConfigClient.SelectAggregateResourceConfigPagesWithContext(ctx, input, func(page *configservice.SelectAggregateResourceConfigOutput, lastPage bool) bool {
+		promutil.ConfigServiceAPICounter.Inc()
+		promutil.CloudwatchAPICounter.WithLabelValues("SelectAggregateResourceConfig", aggregator.Region).Inc()
+		resources, parseErr = appendConfigResults(resources, page.Results)
+		return parseErr == nil && !lastPage
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resources, parseErr
+}
+
+// appendConfigResults appends the JSON results of an advanced query page to resources
+func appendConfigResults(resources []configResource, results []*string) ([]configResource, error) {
+	for _, result := range results {
+		var resource configResource
+		if err := json.Unmarshal([]byte(aws.StringValue(result)), &resource); err != nil {
+			return nil, fmt.Errorf("failed to parse AWS Config result: %w", err)
+		}
+		resources = append(resources, resource)
+	}
+	return resources, nil
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/configservice/configserviceiface"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
+)
+
+var configResults = []*string{
+	aws.String(`{"arn":"arn:aws:ec2:us-east-1:123456789012:instance/i-1","awsRegion":"us-east-1","tags":[{"key":"env","value":"prod","tag":"env=prod"}]}`),
+	aws.String(`{"arn":"arn:aws:ec2:us-east-1:123456789012:instance/i-2","awsRegion":"us-east-1","tags":[]}`),
+	aws.String(`{"arn":"arn:aws:ec2:eu-west-1:123456789012:instance/i-3","awsRegion":"eu-west-1","tags":[{"key":"env","value":"dev","tag":"env=dev"}]}`),
+}
+
+func TestConfigGet(t *testing.T) {
+	tests := []struct {
+		name                string
+		aggregator          *config.ConfigAggregator
+		searchTags          []model.Tag
+		region              string
+		expectedQueries     int
+		expectedAggregators []string
+		outputResources     []*TaggedResource
+	}{
+		{
+			name:            "resources of the account and region",
+			region:          "us-east-1",
+			expectedQueries: 1,
+			outputResources: []*TaggedResource{
+				{
+					ARN:       "arn:aws:ec2:us-east-1:123456789012:instance/i-1",
+					Namespace: "ec2",
+					Region:    "us-east-1",
+					Tags:      []model.Tag{{Key: "env", Value: "prod"}},
+				},
+				{
+					ARN:       "arn:aws:ec2:us-east-1:123456789012:instance/i-2",
+					Namespace: "ec2",
+					Region:    "us-east-1",
+				},
+			},
+		},
+		{
+			name:                "resources of an aggregator filtered by region and search tags",
+			aggregator:          &config.ConfigAggregator{Name: "aggregator", Region: "us-east-1"},
+			searchTags:          []model.Tag{{Key: "env", Value: "dev"}},
+			region:              "eu-west-1",
+			expectedAggregators: []string{"aggregator"},
+			outputResources: []*TaggedResource{
+				{
+					ARN:       "arn:aws:ec2:eu-west-1:123456789012:instance/i-3",
+					Namespace: "ec2",
+					Region:    "eu-west-1",
+					Tags:      []model.Tag{{Key: "env", Value: "dev"}},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &configClient{results: configResults}
+			iface := TagsInterface{
+				ConfigClient: client,
+				AccountId:    "123456789012",
+				Logger:       logger.NewLogrusLogger(log.StandardLogger()),
+			}
+			job := &config.Job{
+				Type:              "ec2",
+				SearchTags:        test.searchTags,
+				ResourceDiscovery: config.ResourceDiscoveryConfig,
+				ConfigAggregator:  test.aggregator,
+			}
+
+			outputResources, err := iface.Get(context.Background(), job, test.region)
+			if err != nil {
+				t.Fatalf("Error from Get: %v", err)
+			}
+			if !reflect.DeepEqual(outputResources, test.outputResources) {
+				t.Errorf("outputResources = %+v, want %+v", outputResources, test.outputResources)
+			}
+			if client.queries != test.expectedQueries {
+				t.Errorf("SelectResourceConfig calls = %d, want %d", client.queries, test.expectedQueries)
+			}
+			if !reflect.DeepEqual(client.aggregators, test.expectedAggregators) {
+				t.Errorf("SelectAggregateResourceConfig aggregators = %v, want %v", client.aggregators, test.expectedAggregators)
+			}
+			wantExpression := "SELECT arn, awsRegion, tags WHERE resourceType IN ('AWS::EC2::Instance') AND accountId = '123456789012'"
+			if client.expression != wantExpression {
+				t.Errorf("expression = %q, want %q", client.expression, wantExpression)
+			}
+		})
+	}
+}
+
+func TestConfigGetSharedAggregatorQuery(t *testing.T) {
+	client := &configClient{results: configResults}
+	job := &config.Job{
+		Type:              "ec2",
+		ResourceDiscovery: config.ResourceDiscoveryConfig,
+		ConfigAggregator:  &config.ConfigAggregator{Name: "aggregator", Region: "us-east-1"},
+	}
+	iface := TagsInterface{
+		ConfigClient: client,
+		ConfigCache:  NewConfigCache(),
+		AccountId:    "123456789012",
+		Logger:       logger.NewLogrusLogger(log.StandardLogger()),
+	}
+
+	var wg sync.WaitGroup
+	counts := make([]int, 2)
+	for i, region := range []string{"us-east-1", "eu-west-1"} {
+		wg.Add(1)
+		go func(i int, region string) {
+			defer wg.Done()
+			resources, err := iface.Get(context.Background(), job, region)
+			if err != nil {
+				t.Errorf("Error from Get: %v", err)
+			}
+			counts[i] = len(resources)
+		}(i, region)
+	}
+	wg.Wait()
+
+	if !reflect.DeepEqual(counts, []int{2, 1}) {
+		t.Errorf("resources per region = %v, want [2 1]", counts)
+	}
+	if len(client.aggregators) != 1 {
+		t.Errorf("SelectAggregateResourceConfig calls = %d, want 1", len(client.aggregators))
+	}
+}
+
+func TestGetChangedConfigResources(t *testing.T) {
+	client := &configClient{results: configResults}
+	job := &config.Job{
+		Type:              "ec2",
+		ResourceDiscovery: config.ResourceDiscoveryConfig,
+		SearchTags:        []model.Tag{{Key: "env", Value: "prod"}},
+	}
+	iface := TagsInterface{
+		ConfigClient: client,
+		AccountId:    "123456789012",
+		Logger:       logger.NewLogrusLogger(log.StandardLogger()),
+	}
+
+	since := time.Date(2023, 10, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	resources, err := iface.GetChangedConfigResources(context.Background(), job, "us-east-1", since)
+	if err != nil {
+		t.Fatalf("Error from GetChangedConfigResources: %v", err)
+	}
+	// Resources not matching the search tags are returned too
+	if len(resources) != 2 {
+		t.Errorf("resources = %d, want 2", len(resources))
+	}
+	wantExpression := "SELECT arn, awsRegion, tags WHERE resourceType IN ('AWS::EC2::Instance') AND accountId = '123456789012' AND configurationItemCaptureTime > '2023-10-01T10:00:00Z'"
+	if client.expression != wantExpression {
+		t.Errorf("expression = %q, want %q", client.expression, wantExpression)
+	}
+}
+
+func TestConfigGetUnsupportedService(t *testing.T) {
+	iface := TagsInterface{
+		ConfigClient: &configClient{},
+		Logger:       logger.NewLogrusLogger(log.StandardLogger()),
+	}
+	job := &config.Job{Type: "acm", ResourceDiscovery: config.ResourceDiscoveryConfig}
+
+	if _, err := iface.Get(context.Background(), job, "us-east-1"); err == nil {
+		t.Error("expected an error for a service without AWS Config resource types")
+	}
+}
+
+type configClient struct {
+	configserviceiface.ConfigServiceAPI
+	results []*string
+
+	mu          sync.Mutex
+	queries     int
+	aggregators []string
+	expression  string
+}
+
+func (c *configClient) SelectResourceConfigPagesWithContext(ctx aws.Context, input *configservice.SelectResourceConfigInput, fn func(*configservice.SelectResourceConfigOutput, bool) bool, opts ...request.Option) error {
+	c.mu.Lock()
+	c.queries++
+	c.expression = aws.StringValue(input.Expression)
+	c.mu.Unlock()
+	fn(&configservice.SelectResourceConfigOutput{Results: c.results}, true)
+	return nil
+}
+
+func (c *configClient) SelectAggregateResourceConfigPagesWithContext(ctx aws.Context, input *configservice.SelectAggregateResourceConfigInput, fn func(*configservice.SelectAggregateResourceConfigOutput, bool) bool, opts ...request.Option) error {
+	c.mu.Lock()
+	c.aggregators = append(c.aggregators, aws.StringValue(input.ConfigurationAggregatorName))
+	c.expression = aws.StringValue(input.Expression)
+	c.mu.Unlock()
+	fn(&configservice.SelectAggregateResourceConfigOutput{Results: c.results}, true)
+	return nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
+)
+
+// s3RequestMetrics are only published for the buckets with a request metrics configuration, with a
+// FilterId dimension naming the configuration (filter) of the bucket
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/metrics-dimensions.html#s3-request-cloudwatch-metrics
+var s3RequestMetrics = []string{
+	"AllRequests",
+	"GetRequests",
+	"PutRequests",
+	"DeleteRequests",
+	"HeadRequests",
+	"PostRequests",
+	"SelectRequests",
+	"SelectBytesScanned",
+	"SelectBytesReturned",
+	"ListRequests",
+	"BytesDownloaded",
+	"BytesUploaded",
+	"4xxErrors",
+	"5xxErrors",
+	"FirstByteLatency",
+	"TotalRequestLatency",
+}
+
+// s3RequestMetricsDimensions returns the BucketName and FilterId dimensions of every request metrics
+// configuration of the buckets in resources. Buckets without request metrics are skipped, as well as
+// the ones whose configurations can't be listed.
+func s3RequestMetricsDimensions(ctx context.Context, iface TagsInterface, resources []*TaggedResource) ([][]*cloudwatch.Dimension, error) {
+	var dimensions [][]*cloudwatch.Dimension
+	for _, resource := range resources {
+		bucket := s3BucketName(resource.ARN)
+		if bucket == "" {
+			continue
+		}
+
+		filterIds, err := listS3MetricsConfigurations(ctx, iface, bucket)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			iface.Logger.Warn("Couldn't list the request metrics configurations of bucket", "bucket", bucket, "err", err)
+			continue
+		}
+		if len(filterIds) == 0 {
+			iface.Logger.Debug("Skipping bucket without request metrics", "bucket", bucket)
+			continue
+		}
+		for _, filterId := range filterIds {
+			dimensions = append(dimensions, []*cloudwatch.Dimension{
+				{Name: aws.String("BucketName"), Value: aws.String(bucket)},
+				{Name: aws.String("FilterId"), Value: aws.String(filterId)},
+			})
+		}
+	}
+	return dimensions, nil
+}
+
+func listS3MetricsConfigurations(ctx context.Context, iface TagsInterface, bucket string) ([]string, error) {
+	var filterIds []string
+	input := &s3.ListBucketMetricsConfigurationsInput{Bucket: aws.String(bucket)}
+	for {
+		promutil.S3APICounter.Inc()
+		output, err := iface.S3Client.ListBucketMetricsConfigurationsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, configuration := range output.MetricsConfigurationList {
+			filterIds = append(filterIds, aws.StringValue(configuration.Id))
+		}
+		if !aws.BoolValue(output.IsTruncated) || output.NextContinuationToken == nil {
+			return filterIds, nil
+		}
+		input.ContinuationToken = output.NextContinuationToken
+	}
+}
+
+// s3BucketName returns the name of the bucket of an ARN like arn:aws:s3:::bucket
+func s3BucketName(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+)
+
+type s3MetricsConfigurationsClient struct {
+	s3iface.S3API
+	// pages are the pages of metrics configurations of every bucket, buckets without pages fail
+	pages map[string][][]string
+}
+
+func (c *s3MetricsConfigurationsClient) ListBucketMetricsConfigurationsWithContext(_ aws.Context, input *s3.ListBucketMetricsConfigurationsInput, _ ...request.Option) (*s3.ListBucketMetricsConfigurationsOutput, error) {
+	pages, ok := c.pages[*input.Bucket]
+	if !ok {
+		return nil, errors.New("AccessDenied")
+	}
+	page := 0
+	if input.ContinuationToken != nil {
+		page = len(*input.ContinuationToken)
+	}
+	output := &s3.ListBucketMetricsConfigurationsOutput{}
+	if page < len(pages) {
+		for _, id := range pages[page] {
+			output.MetricsConfigurationList = append(output.MetricsConfigurationList, &s3.MetricsConfiguration{Id: aws.String(id)})
+		}
+	}
+	if page+1 < len(pages) {
+		output.IsTruncated = aws.Bool(true)
+		output.NextContinuationToken = aws.String(string(make([]byte, page+1)))
+	}
+	return output, nil
+}
+
+func TestS3RequestMetricsDimensions(t *testing.T) {
+	iface := TagsInterface{
+		S3Client: &s3MetricsConfigurationsClient{pages: map[string][][]string{
+			"bucket-a": {{"EntireBucket"}, {"Documents"}},
+			"bucket-b": {},
+		}},
+		Logger: logger.NewLogrusLogger(log.StandardLogger()),
+	}
+	resources := []*TaggedResource{
+		{ARN: "arn:aws:s3:::bucket-a", Namespace: "s3"},
+		// Without request metrics
+		{ARN: "arn:aws:s3:::bucket-b", Namespace: "s3"},
+		// Whose configurations can't be listed
+		{ARN: "arn:aws:s3:::bucket-c", Namespace: "s3"},
+	}
+
+	dimensions, err := s3RequestMetricsDimensions(context.Background(), iface, resources)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := [][]*cloudwatch.Dimension{
+		{{Name: aws.String("BucketName"), Value: aws.String("bucket-a")}, {Name: aws.String("FilterId"), Value: aws.String("EntireBucket")}},
+		{{Name: aws.String("BucketName"), Value: aws.String("bucket-a")}, {Name: aws.String("FilterId"), Value: aws.String("Documents")}},
+	}
+	if !reflect.DeepEqual(dimensions, expected) {
+		t.Errorf("expected dimensions %v, got %v", expected, dimensions)
+	}
+}
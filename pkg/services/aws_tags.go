@@ -4,16 +4,21 @@ import (
 	"context"
 	"errors"
 	"regexp"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/apigateway/apigatewayiface"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/bedrock/bedrockiface"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/configservice/configserviceiface"
 	"github.com/aws/aws-sdk-go/service/databasemigrationservice/databasemigrationserviceiface"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elasticache/elasticacheiface"
 	"github.com/aws/aws-sdk-go/service/prometheusservice/prometheusserviceiface"
 	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
 	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/storagegateway/storagegatewayiface"
 
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
@@ -35,25 +40,55 @@ type TaggedResource struct {
 
 	// Tags is a set of tags associated to the resource
 	Tags []model.Tag
+
+	// ExportUp exports the ResourceUpMetric of the resource, set for the resources
+	// of the jobs with ExportResourceUp
+	ExportUp bool
 }
 
+// ResourceUpMetric is exported for every resource of the jobs with ExportResourceUp,
+// even without metrics, labeled with its tags
+const ResourceUpMetric = "yace_resource_up"
+
 // filterThroughTags returns true if all filterTags match
 // with tags of the TaggedResource, returns false otherwise.
 func (r TaggedResource) FilterThroughTags(filterTags []model.Tag) bool {
-	tagMatches := 0
+	for _, filterTag := range filterTags {
+		if !r.matchesTag(filterTag) {
+			return false
+		}
+	}
+	return true
+}
 
-	for _, resourceTag := range r.Tags {
-		for _, filterTag := range filterTags {
-			if resourceTag.Key == filterTag.Key {
-				r, _ := regexp.Compile(filterTag.Value)
-				if r.MatchString(resourceTag.Value) {
-					tagMatches++
-				}
-			}
+// ExcludedByTags returns true if any of excludeTags matches
+// with tags of the TaggedResource, returns false otherwise.
+func (r TaggedResource) ExcludedByTags(excludeTags []model.Tag) bool {
+	for _, excludeTag := range excludeTags {
+		if r.matchesTag(excludeTag) {
+			return true
 		}
 	}
+	return false
+}
 
-	return tagMatches == len(filterTags)
+// matchesTag returns true if the TaggedResource has a tag with the key of
+// filterTag and a value matching its regex. When filterTag.Exists is set,
+// only the presence (or absence) of the key matters.
+func (r TaggedResource) matchesTag(filterTag model.Tag) bool {
+	for _, resourceTag := range r.Tags {
+		if resourceTag.Key != filterTag.Key {
+			continue
+		}
+		if filterTag.Exists != nil {
+			return *filterTag.Exists
+		}
+		r, _ := regexp.Compile(filterTag.Value)
+		if r.MatchString(resourceTag.Value) {
+			return true
+		}
+	}
+	return filterTag.Exists != nil && !*filterTag.Exists
 }
 
 // MetricTags returns a list of tags built from the tags of
@@ -91,14 +126,43 @@ type TagsInterface struct {
 	DmsClient            databasemigrationserviceiface.DatabaseMigrationServiceAPI
 	PrometheusClient     prometheusserviceiface.PrometheusServiceAPI
 	StoragegatewayClient storagegatewayiface.StorageGatewayAPI
-	Logger               logger.Logger
+	BedrockClient        bedrockiface.BedrockAPI
+	ElastiCacheClient    elasticacheiface.ElastiCacheAPI
+	// ConfigClient and ConfigCache are used by the jobs discovering their resources
+	// with AWS Config, ConfigClient is in the region of the aggregator if any
+	ConfigClient configserviceiface.ConfigServiceAPI
+	ConfigCache  *ConfigCache
+	// AccountId is the account of the resources, which AWS Config queries and the
+	// ARNs of the resources discovered without the tagging API need
+	AccountId string
+	Logger    logger.Logger
+	// S3Client lists the request metrics configurations of the S3 buckets
+	S3Client s3iface.S3API
+	// CloudFormationClient and StackCache are used by the jobs selecting the resources of a CloudFormation stack
+	CloudFormationClient cloudformationiface.CloudFormationAPI
+	StackCache           *StackCache
+	// TaggingOptions are the options of the requests to the tagging API, e.g. pacing them with a rate limiter
+	TaggingOptions []request.Option
+}
+
+// ResourceDiscoverer discovers the resources of a discovery job in a region. TagsInterface, the default, discovers
+// them with the AWS APIs, other implementations can supply them from another inventory, e.g. a CMDB.
+type ResourceDiscoverer interface {
+	Get(ctx context.Context, job *config.Job, region string) ([]*TaggedResource, error)
 }
 
+// Get discovers the resources of job in region with the tagging API, AWS Config or the API of the service
 func (iface TagsInterface) Get(ctx context.Context, job *config.Job, region string) ([]*TaggedResource, error) {
 	svc := SupportedServices.GetService(job.Type)
 	var resources []*TaggedResource
 
-	if len(svc.ResourceFilters) > 0 {
+	if job.ResourceDiscovery == config.ResourceDiscoveryConfig {
+		configResources, err := iface.getConfigResources(ctx, job, svc, region)
+		if err != nil {
+			return nil, err
+		}
+		resources = configResources
+	} else if len(svc.ResourceFilters) > 0 {
 		inputparams := &resourcegroupstaggingapi.GetResourcesInput{
 			ResourceTypeFilters: svc.ResourceFilters,
 			ResourcesPerPage:    aws.Int64(100), // max allowed value according to API docs
@@ -109,6 +173,7 @@ func (iface TagsInterface) Get(ctx context.Context, job *config.Job, region stri
 		err := c.GetResourcesPagesWithContext(ctx, inputparams, func(page *resourcegroupstaggingapi.GetResourcesOutput, lastPage bool) bool {
 			pageNum++
 			promutil.ResourceGroupTaggingAPICounter.Inc()
+			promutil.CloudwatchAPICounter.WithLabelValues("GetResources", region).Inc()
 
 			if len(page.ResourceTagMappingList) == 0 {
 				iface.Logger.Error(errors.New("resource tag list is empty"), "Account contained no tagged resource. Tags must be defined for resources to be discovered.")
@@ -132,8 +197,11 @@ func (iface TagsInterface) Get(ctx context.Context, job *config.Job, region stri
 				}
 			}
 			return !lastPage
-		})
+		}, iface.TaggingOptions...)
 		if err != nil {
+			if request.IsErrorThrottle(err) {
+				promutil.CloudwatchAPIThrottleCounter.WithLabelValues("GetResources", region).Inc()
+			}
 			return nil, err
 		}
 	}
@@ -157,7 +225,7 @@ func (iface TagsInterface) Get(ctx context.Context, job *config.Job, region stri
 	return resources, nil
 }
 
-func MigrateTagsToPrometheus(tagData []*TaggedResource, labelsSnakeCase bool, logger logger.Logger) []*promutil.PrometheusMetric {
+func MigrateTagsToPrometheus(tagData []*TaggedResource, labelsSnakeCase bool, labelSanitization config.LabelSanitization, logger logger.Logger) []*promutil.PrometheusMetric {
 	output := make([]*promutil.PrometheusMetric, 0)
 
 	tagList := make(map[string][]string)
@@ -170,12 +238,16 @@ func MigrateTagsToPrometheus(tagData []*TaggedResource, labelsSnakeCase bool, lo
 		}
 	}
 
+	// The same resource can be discovered by several jobs, it only gets one info series
+	seen := make(map[string]struct{}, len(tagData))
 	for _, d := range tagData {
-		promNs := strings.ToLower(d.Namespace)
-		if !strings.HasPrefix(promNs, "aws") {
-			promNs = "aws_" + promNs
+		key := d.Namespace + "/" + d.ARN
+		if _, ok := seen[key]; ok {
+			continue
 		}
-		name := promutil.PromString(promNs) + "_info"
+		seen[key] = struct{}{}
+
+		name := promutil.NamespaceMetricPrefix(d.Namespace) + "_info"
 		promLabels := make(map[string]string)
 		promLabels["name"] = d.ARN
 
@@ -183,6 +255,7 @@ func MigrateTagsToPrometheus(tagData []*TaggedResource, labelsSnakeCase bool, lo
 			ok, promTag := promutil.PromStringTag(entry, labelsSnakeCase)
 			if !ok {
 				logger.Warn("tag name is an invalid prometheus label name", "tag", entry)
+				promutil.LabelsDroppedCounter.WithLabelValues(promutil.LabelReasonInvalidName).Inc()
 				continue
 			}
 
@@ -191,7 +264,9 @@ func MigrateTagsToPrometheus(tagData []*TaggedResource, labelsSnakeCase bool, lo
 
 			for _, rTag := range d.Tags {
 				if entry == rTag.Key {
-					promLabels[labelKey] = rTag.Value
+					// A dropped value is exported empty, like the tags the resource doesn't have
+					value, _ := promutil.SanitizeLabelValue(rTag.Value, labelSanitization.MaxLength, labelSanitization.Policy == config.LabelSanitizationDrop)
+					promLabels[labelKey] = value
 				}
 			}
 		}
@@ -208,6 +283,66 @@ func MigrateTagsToPrometheus(tagData []*TaggedResource, labelsSnakeCase bool, lo
 		output = append(output, &p)
 	}
 
+	return append(output, migrateResourcesUpToPrometheus(tagData, labelsSnakeCase, labelSanitization, logger)...)
+}
+
+// migrateResourcesUpToPrometheus returns the ResourceUpMetric of the resources with ExportUp. Every series
+// has the tags of all of them as labels, empty for the tags the resource doesn't have.
+func migrateResourcesUpToPrometheus(tagData []*TaggedResource, labelsSnakeCase bool, labelSanitization config.LabelSanitization, logger logger.Logger) []*promutil.PrometheusMetric {
+	var output []*promutil.PrometheusMetric
+
+	var tagKeys []string
+	for _, d := range tagData {
+		if !d.ExportUp {
+			continue
+		}
+		for _, entry := range d.Tags {
+			if !stringInSlice(entry.Key, tagKeys) {
+				tagKeys = append(tagKeys, entry.Key)
+			}
+		}
+	}
+
+	seen := make(map[string]struct{})
+	for _, d := range tagData {
+		key := d.Namespace + "/" + d.ARN
+		if _, ok := seen[key]; ok || !d.ExportUp {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		name := ResourceUpMetric
+		promLabels := map[string]string{
+			"name":     d.ARN,
+			"job_type": d.Namespace,
+			"region":   d.Region,
+		}
+		for _, entry := range tagKeys {
+			ok, promTag := promutil.PromStringTag(entry, labelsSnakeCase)
+			if !ok {
+				logger.Warn("tag name is an invalid prometheus label name", "tag", entry)
+				promutil.LabelsDroppedCounter.WithLabelValues(promutil.LabelReasonInvalidName).Inc()
+				continue
+			}
+
+			labelKey := "tag_" + promTag
+			promLabels[labelKey] = ""
+			for _, rTag := range d.Tags {
+				if entry == rTag.Key {
+					value, _ := promutil.SanitizeLabelValue(rTag.Value, labelSanitization.MaxLength, labelSanitization.Policy == config.LabelSanitizationDrop)
+					promLabels[labelKey] = value
+				}
+			}
+		}
+
+		value := 1.0
+		output = append(output, &promutil.PrometheusMetric{
+			Name:   &name,
+			Labels: promLabels,
+			Value:  &value,
+		})
+	}
+
 	return output
 }
 
@@ -3,6 +3,7 @@ package services
 import (
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 
@@ -161,6 +162,91 @@ func Test_FilterThroughTags(t *testing.T) {
 			},
 			result: true,
 		},
+		{
+			testName: "tag exists with any value",
+			resourceTags: []model.Tag{
+				{
+					Key:   "k1",
+					Value: "anything",
+				},
+			},
+			filterTags: []model.Tag{
+				{
+					Key:    "k1",
+					Value:  "v1",
+					Exists: aws.Bool(true),
+				},
+			},
+			result: true,
+		},
+		{
+			testName: "tag exists but is missing",
+			resourceTags: []model.Tag{
+				{
+					Key:   "k2",
+					Value: "v2",
+				},
+			},
+			filterTags: []model.Tag{
+				{
+					Key:    "k1",
+					Exists: aws.Bool(true),
+				},
+			},
+			result: false,
+		},
+		{
+			testName: "tag does not exist",
+			resourceTags: []model.Tag{
+				{
+					Key:   "k2",
+					Value: "v2",
+				},
+			},
+			filterTags: []model.Tag{
+				{
+					Key:    "k1",
+					Exists: aws.Bool(false),
+				},
+			},
+			result: true,
+		},
+		{
+			testName: "tag does not exist but is present",
+			resourceTags: []model.Tag{
+				{
+					Key:   "k1",
+					Value: "v1",
+				},
+			},
+			filterTags: []model.Tag{
+				{
+					Key:    "k1",
+					Exists: aws.Bool(false),
+				},
+			},
+			result: false,
+		},
+		{
+			testName: "partial tag set with exists and value filters",
+			resourceTags: []model.Tag{
+				{
+					Key:   "k1",
+					Value: "v1",
+				},
+			},
+			filterTags: []model.Tag{
+				{
+					Key:    "k1",
+					Exists: aws.Bool(true),
+				},
+				{
+					Key:   "k2",
+					Value: "v2",
+				},
+			},
+			result: false,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -177,6 +263,65 @@ func Test_FilterThroughTags(t *testing.T) {
 	}
 }
 
+func Test_ExcludedByTags(t *testing.T) {
+	testCases := []struct {
+		testName     string
+		resourceTags []model.Tag
+		excludeTags  []model.Tag
+		result       bool
+	}{
+		{
+			testName:     "no exclude tags",
+			resourceTags: []model.Tag{{Key: "k1", Value: "v1"}},
+			excludeTags:  nil,
+			result:       false,
+		},
+		{
+			testName:     "excluded by value",
+			resourceTags: []model.Tag{{Key: "k1", Value: "v1"}, {Key: "k2", Value: "v2"}},
+			excludeTags:  []model.Tag{{Key: "k2", Value: "v.*"}},
+			result:       true,
+		},
+		{
+			testName:     "not excluded by another value",
+			resourceTags: []model.Tag{{Key: "k1", Value: "v1"}},
+			excludeTags:  []model.Tag{{Key: "k1", Value: "other"}},
+			result:       false,
+		},
+		{
+			testName:     "excluded by tag presence",
+			resourceTags: []model.Tag{{Key: "k1", Value: "v1"}},
+			excludeTags:  []model.Tag{{Key: "k3", Value: "x"}, {Key: "k1", Exists: aws.Bool(true)}},
+			result:       true,
+		},
+		{
+			testName:     "excluded by tag absence",
+			resourceTags: []model.Tag{{Key: "k1", Value: "v1"}},
+			excludeTags:  []model.Tag{{Key: "k2", Exists: aws.Bool(false)}},
+			result:       true,
+		},
+		{
+			testName:     "untagged resource",
+			resourceTags: nil,
+			excludeTags:  []model.Tag{{Key: "k1", Exists: aws.Bool(true)}},
+			result:       false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			res := TaggedResource{
+				ARN:       "aws::arn",
+				Namespace: "AWS/Service",
+				Region:    "us-east-1",
+				Tags:      tc.resourceTags,
+			}
+
+			require.Equal(t, tc.result, res.ExcludedByTags(tc.excludeTags))
+		})
+	}
+}
+
 func Test_MetricTags(t *testing.T) {
 	testCases := []struct {
 		testName     string
@@ -314,7 +459,64 @@ func Test_MigrateTagsToPrometheus(t *testing.T) {
 		Value: &metricValue,
 	}}
 
-	actual := MigrateTagsToPrometheus(resources, false, logger.NewLogrusLogger(log.StandardLogger()))
+	actual := MigrateTagsToPrometheus(resources, false, config.LabelSanitization{}, logger.NewLogrusLogger(log.StandardLogger()))
 
 	require.Equal(t, expected, actual)
 }
+
+func Test_MigrateTagsToPrometheus_Duplicates(t *testing.T) {
+	resource := func(arn string) *TaggedResource {
+		return &TaggedResource{
+			ARN:       arn,
+			Namespace: "AWS/Service",
+			Region:    "us-east-1",
+			Tags:      []model.Tag{{Key: "Name", Value: "tag_Value"}},
+		}
+	}
+	// The first resource is discovered by two jobs
+	resources := []*TaggedResource{resource("aws::arn1"), resource("aws::arn2"), resource("aws::arn1")}
+
+	actual := MigrateTagsToPrometheus(resources, false, config.LabelSanitization{}, logger.NewLogrusLogger(log.StandardLogger()))
+
+	require.Len(t, actual, 2)
+	require.Equal(t, "aws::arn1", actual[0].Labels["name"])
+	require.Equal(t, "aws::arn2", actual[1].Labels["name"])
+}
+
+func Test_MigrateTagsToPrometheus_ResourceUp(t *testing.T) {
+	resources := []*TaggedResource{
+		{
+			ARN:       "aws::arn1",
+			Namespace: "AWS/Service",
+			Region:    "us-east-1",
+			Tags:      []model.Tag{{Key: "Name", Value: "first"}},
+			ExportUp:  true,
+		},
+		{
+			ARN:       "aws::arn2",
+			Namespace: "AWS/Other",
+			Region:    "eu-west-1",
+			Tags:      []model.Tag{{Key: "team", Value: "a"}},
+			ExportUp:  true,
+		},
+		{
+			ARN:       "aws::arn3",
+			Namespace: "AWS/Service",
+			Region:    "us-east-1",
+			Tags:      []model.Tag{{Key: "env", Value: "prod"}},
+		},
+	}
+
+	actual := MigrateTagsToPrometheus(resources, true, config.LabelSanitization{}, logger.NewLogrusLogger(log.StandardLogger()))
+
+	var up []*promutil.PrometheusMetric
+	for _, metric := range actual {
+		if *metric.Name == ResourceUpMetric {
+			up = append(up, metric)
+		}
+	}
+	require.Len(t, up, 2)
+	require.Equal(t, map[string]string{"name": "aws::arn1", "job_type": "AWS/Service", "region": "us-east-1", "tag_name": "first", "tag_team": ""}, up[0].Labels)
+	require.Equal(t, map[string]string{"name": "aws::arn2", "job_type": "AWS/Other", "region": "eu-west-1", "tag_name": "", "tag_team": "a"}, up[1].Labels)
+	require.Equal(t, 1.0, *up[0].Value)
+}
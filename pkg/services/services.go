@@ -7,10 +7,14 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/service/apigateway"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/bedrock"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elasticache"
 	"github.com/aws/aws-sdk-go/service/prometheusservice"
 	"github.com/aws/aws-sdk-go/service/storagegateway"
 
@@ -23,13 +27,40 @@ type ResourceFunc func(context.Context, TagsInterface, *config.Job, string) ([]*
 
 type FilterFunc func(context.Context, TagsInterface, []*TaggedResource) ([]*TaggedResource, error)
 
+// ConfiguredMetricsFunc returns the dimensions of the metrics which are only published once configured on resources
+type ConfiguredMetricsFunc func(context.Context, TagsInterface, []*TaggedResource) ([][]*cloudwatch.Dimension, error)
+
 type ServiceFilter struct {
 	Namespace        string
 	Alias            string
 	ResourceFilters  []*string
 	DimensionRegexps []*string
-	ResourceFunc     ResourceFunc
-	FilterFunc       FilterFunc
+	// DimensionPriority are the dimensions identifying the resource of a metric by precedence, the metric
+	// belonging to the resource of the first of them with a discovered resource. Without it, the
+	// dimensions of the metric are matched in the order CloudWatch lists them.
+	DimensionPriority []string
+	ResourceFunc      ResourceFunc
+	FilterFunc        FilterFunc
+	// ConfigResourceTypes are the AWS Config resource types matching ResourceFilters, for the
+	// services supporting resource discovery with AWS Config
+	ConfigResourceTypes []string
+	// ConfiguredMetrics are queried for the dimensions returned by ConfiguredMetricsFunc instead of
+	// the ones listed by ListMetrics, which only returns the metrics with recent data points
+	ConfiguredMetrics     []string
+	ConfiguredMetricsFunc ConfiguredMetricsFunc
+}
+
+// IsConfiguredMetric returns whether the dimensions of metricName are returned by ConfiguredMetricsFunc
+func (sf *ServiceFilter) IsConfiguredMetric(metricName string) bool {
+	if sf.ConfiguredMetricsFunc == nil {
+		return false
+	}
+	for _, name := range sf.ConfiguredMetrics {
+		if name == metricName {
+			return true
+		}
+	}
+	return false
 }
 
 type serviceConfig []ServiceFilter
@@ -52,6 +83,16 @@ func (sc serviceConfig) GetService(serviceType string) *ServiceFilter {
 	return nil
 }
 
+// ec2ARN returns the ARN of the EC2 resource of accountId in region, like the tagging API
+// returns it, for the resources discovered with EC2 instead
+func ec2ARN(region, accountId, resource string) string {
+	partition := "aws"
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); ok {
+		partition = p.ID()
+	}
+	return fmt.Sprintf("arn:%s:ec2:%s:%s:%s", partition, region, accountId, resource)
+}
+
 var SupportedServices = serviceConfig{
 	{
 		Namespace: "AWS/CertificateManager",
@@ -75,9 +116,12 @@ var SupportedServices = serviceConfig{
 			aws.String("elasticloadbalancing:targetgroup"),
 		},
 		DimensionRegexps: []*string{
-			aws.String(":(?P<TargetGroup>targetgroup/.+)"),
-			aws.String(":loadbalancer/(?P<LoadBalancer>.+)$"),
+			aws.String(":(?P<TargetGroup>targetgroup/[^/]+/[^/]+)$"),
+			aws.String(":loadbalancer/(?P<LoadBalancer>app/[^/]+/[^/]+)$"),
 		},
+		// The target group metrics also have the LoadBalancer dimension, they belong to the target group,
+		// or to the load balancer when the target group isn't discovered
+		DimensionPriority: []string{"TargetGroup", "LoadBalancer"},
 	},
 	{
 		Namespace: "AWS/AppStream",
@@ -200,6 +244,37 @@ var SupportedServices = serviceConfig{
 		Namespace: "AWS/ElasticBeanstalk",
 		Alias:     "beanstalk",
 	},
+	{
+		Namespace: "AWS/Bedrock",
+		Alias:     "bedrock",
+		DimensionRegexps: []*string{
+			aws.String(":foundation-model/(?P<ModelId>[^/]+)$"),
+		},
+		ResourceFunc: func(ctx context.Context, iface TagsInterface, job *config.Job, region string) (resources []*TaggedResource, err error) {
+			// Foundation models are not resources of the account, so they can't be found
+			// through the tagging API and never have tags
+			output, err := iface.BedrockClient.ListFoundationModelsWithContext(ctx, &bedrock.ListFoundationModelsInput{})
+			if err != nil {
+				return nil, err
+			}
+			promutil.BedrockAPICounter.Inc()
+
+			for _, fm := range output.ModelSummaries {
+				resource := TaggedResource{
+					ARN:       aws.StringValue(fm.ModelArn),
+					Namespace: job.Type,
+					Region:    region,
+				}
+
+				if resource.FilterThroughTags(job.SearchTags) {
+					resources = append(resources, &resource)
+				} else {
+					iface.Logger.Debug("Skipping untagged foundation model because of search tags", "arn", resource.ARN)
+				}
+			}
+			return resources, nil
+		},
+	},
 	{
 		Namespace: "AWS/Billing",
 		Alias:     "billing",
@@ -217,6 +292,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("cloudfront:distribution"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::CloudFront::Distribution",
+		},
 		DimensionRegexps: []*string{
 			aws.String("distribution/(?P<DistributionId>[^/]+)"),
 		},
@@ -328,6 +406,11 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("dynamodb:table"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::DynamoDB::Table",
+		},
+		// Global secondary index metrics carry an additional GlobalSecondaryIndexName dimension,
+		// they are matched to their parent table (and its tags) through TableName.
 		DimensionRegexps: []*string{
 			aws.String(":table/(?P<TableName>[^/]+)"),
 		},
@@ -338,6 +421,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("ec2:volume"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::EC2::Volume",
+		},
 		DimensionRegexps: []*string{
 			aws.String("volume/(?P<VolumeId>[^/]+)"),
 		},
@@ -347,9 +433,71 @@ var SupportedServices = serviceConfig{
 		Alias:     "ec",
 		ResourceFilters: []*string{
 			aws.String("elasticache:cluster"),
+			aws.String("elasticache:replicationgroup"),
 		},
+		// Node metrics carry an additional CacheNodeId dimension, they are matched to their cluster
+		// (and its tags) through CacheClusterId. The nodes of a Redis replication group are clusters of
+		// their own, named <group>-<shard>-<node> in cluster mode, with a single 0001 node each.
 		DimensionRegexps: []*string{
-			aws.String("cluster:(?P<CacheClusterId>[^/]+)"),
+			aws.String(":cluster:(?P<CacheClusterId>[^/]+)$"),
+		},
+		// Replace the Redis replication groups by their member clusters, which carry the tags of
+		// their group unless they are tagged themselves
+		FilterFunc: func(ctx context.Context, iface TagsInterface, inputResources []*TaggedResource) (outputResources []*TaggedResource, err error) {
+			clusters := make(map[string]bool, len(inputResources))
+			groups := make(map[string]*TaggedResource)
+			for _, resource := range inputResources {
+				if strings.Contains(resource.ARN, ":replicationgroup:") {
+					groups[resource.ARN] = resource
+				} else {
+					clusters[resource.ARN] = true
+				}
+			}
+			if len(groups) == 0 {
+				return inputResources, nil
+			}
+
+			members := make(map[string][]string, len(groups))
+			pageNum := 0
+			if err := iface.ElastiCacheClient.DescribeReplicationGroupsPagesWithContext(ctx, &elasticache.DescribeReplicationGroupsInput{},
+				func(page *elasticache.DescribeReplicationGroupsOutput, lastPage bool) bool {
+					pageNum++
+					promutil.ElastiCacheAPICounter.Inc()
+
+					for _, group := range page.ReplicationGroups {
+						if _, ok := groups[aws.StringValue(group.ARN)]; ok {
+							members[aws.StringValue(group.ARN)] = aws.StringValueSlice(group.MemberClusters)
+						}
+					}
+
+					return pageNum < 100
+				},
+			); err != nil {
+				return nil, err
+			}
+
+			for _, resource := range inputResources {
+				if _, ok := groups[resource.ARN]; !ok {
+					outputResources = append(outputResources, resource)
+					continue
+				}
+				prefix := resource.ARN[:strings.LastIndex(resource.ARN, ":replicationgroup:")]
+				for _, member := range members[resource.ARN] {
+					arn := fmt.Sprintf("%s:cluster:%s", prefix, member)
+					if clusters[arn] {
+						continue
+					}
+					clusters[arn] = true
+					outputResources = append(outputResources, &TaggedResource{
+						ARN:       arn,
+						Namespace: resource.Namespace,
+						Region:    resource.Region,
+						Tags:      resource.Tags,
+						ExportUp:  resource.ExportUp,
+					})
+				}
+			}
+			return
 		},
 	},
 	{
@@ -358,6 +506,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("ec2:instance"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::EC2::Instance",
+		},
 		DimensionRegexps: []*string{
 			aws.String("instance/(?P<InstanceId>[^/]+)"),
 		},
@@ -402,6 +553,10 @@ var SupportedServices = serviceConfig{
 			aws.String("ecs:cluster"),
 			aws.String("ecs:service"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::ECS::Cluster",
+			"AWS::ECS::Service",
+		},
 		DimensionRegexps: []*string{
 			aws.String("cluster/(?P<ClusterName>[^/]+)"),
 			aws.String("service/(?P<ClusterName>[^/]+)/([^/]+)"),
@@ -425,6 +580,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("elasticfilesystem:file-system"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::EFS::FileSystem",
+		},
 		DimensionRegexps: []*string{
 			aws.String("file-system/(?P<FileSystemId>[^/]+)"),
 		},
@@ -435,6 +593,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("elasticloadbalancing:loadbalancer"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::ElasticLoadBalancing::LoadBalancer",
+		},
 		DimensionRegexps: []*string{
 			aws.String(":loadbalancer/(?P<LoadBalancerName>.+)$"),
 		},
@@ -465,6 +626,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("es:domain"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::Elasticsearch::Domain",
+		},
 		DimensionRegexps: []*string{
 			aws.String(":domain/(?P<DomainName>[^/]+)"),
 		},
@@ -561,6 +725,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("kinesis:stream"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::Kinesis::Stream",
+		},
 		DimensionRegexps: []*string{
 			aws.String(":stream/(?P<StreamName>[^/]+)"),
 		},
@@ -575,6 +742,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("lambda:function"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::Lambda::Function",
+		},
 		DimensionRegexps: []*string{
 			aws.String(":function:(?P<FunctionName>[^/]+)"),
 		},
@@ -642,6 +812,35 @@ var SupportedServices = serviceConfig{
 		DimensionRegexps: []*string{
 			aws.String(":vpc-endpoint/(?P<VPC_Endpoint_Id>.+)"),
 		},
+		ResourceFunc: func(ctx context.Context, iface TagsInterface, job *config.Job, region string) (resources []*TaggedResource, err error) {
+			// The tagging API only returns the tagged endpoints, the untagged ones are
+			// discovered with EC2
+			pageNum := 0
+			return resources, iface.Ec2Client.DescribeVpcEndpointsPagesWithContext(ctx, &ec2.DescribeVpcEndpointsInput{},
+				func(page *ec2.DescribeVpcEndpointsOutput, more bool) bool {
+					pageNum++
+					promutil.Ec2APICounter.Inc()
+
+					for _, endpoint := range page.VpcEndpoints {
+						if len(endpoint.Tags) > 0 {
+							continue
+						}
+						resource := TaggedResource{
+							ARN:       ec2ARN(region, aws.StringValue(endpoint.OwnerId), "vpc-endpoint/"+aws.StringValue(endpoint.VpcEndpointId)),
+							Namespace: job.Type,
+							Region:    region,
+						}
+
+						if resource.FilterThroughTags(job.SearchTags) {
+							resources = append(resources, &resource)
+						} else {
+							iface.Logger.Debug("Skipping untagged VPC endpoint because of search tags", "arn", resource.ARN)
+						}
+					}
+					return pageNum < 100
+				},
+			)
+		},
 	},
 	{
 		Namespace: "AWS/PrivateLinkServices",
@@ -650,7 +849,36 @@ var SupportedServices = serviceConfig{
 			aws.String("ec2:vpc-endpoint-service"),
 		},
 		DimensionRegexps: []*string{
-			aws.String(":vpc-endpoint-service:(?P<Service_Id>.+)"),
+			aws.String(":vpc-endpoint-service/(?P<Service_Id>.+)"),
+		},
+		ResourceFunc: func(ctx context.Context, iface TagsInterface, job *config.Job, region string) (resources []*TaggedResource, err error) {
+			// The tagging API only returns the tagged endpoint services, the untagged ones
+			// are discovered with EC2
+			pageNum := 0
+			return resources, iface.Ec2Client.DescribeVpcEndpointServiceConfigurationsPagesWithContext(ctx, &ec2.DescribeVpcEndpointServiceConfigurationsInput{},
+				func(page *ec2.DescribeVpcEndpointServiceConfigurationsOutput, more bool) bool {
+					pageNum++
+					promutil.Ec2APICounter.Inc()
+
+					for _, service := range page.ServiceConfigurations {
+						if len(service.Tags) > 0 {
+							continue
+						}
+						resource := TaggedResource{
+							ARN:       ec2ARN(region, iface.AccountId, "vpc-endpoint-service/"+aws.StringValue(service.ServiceId)),
+							Namespace: job.Type,
+							Region:    region,
+						}
+
+						if resource.FilterThroughTags(job.SearchTags) {
+							resources = append(resources, &resource)
+						} else {
+							iface.Logger.Debug("Skipping untagged VPC endpoint service because of search tags", "arn", resource.ARN)
+						}
+					}
+					return pageNum < 100
+				},
+			)
 		},
 	},
 	{
@@ -690,6 +918,10 @@ var SupportedServices = serviceConfig{
 			aws.String("rds:db"),
 			aws.String("rds:cluster"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::RDS::DBInstance",
+			"AWS::RDS::DBCluster",
+		},
 		DimensionRegexps: []*string{
 			aws.String(":cluster:(?P<DBClusterIdentifier>[^/]+)"),
 			aws.String(":db:(?P<DBInstanceIdentifier>[^/]+)"),
@@ -731,9 +963,14 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("s3"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::S3::Bucket",
+		},
 		DimensionRegexps: []*string{
 			aws.String("(?P<BucketName>[^:]+)$"),
 		},
+		ConfiguredMetrics:     s3RequestMetrics,
+		ConfiguredMetricsFunc: s3RequestMetricsDimensions,
 	},
 	{
 		Namespace: "AWS/SES",
@@ -755,6 +992,9 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("sns"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::SNS::Topic",
+		},
 		DimensionRegexps: []*string{
 			aws.String("(?P<TopicName>[^:]+)$"),
 		},
@@ -765,8 +1005,12 @@ var SupportedServices = serviceConfig{
 		ResourceFilters: []*string{
 			aws.String("sqs"),
 		},
+		ConfigResourceTypes: []string{
+			"AWS::SQS::Queue",
+		},
 		DimensionRegexps: []*string{
-			aws.String("(?P<QueueName>[^:]+)$"),
+			// The queue name is the last part of the ARN, FIFO queue names include their .fifo suffix
+			aws.String(":sqs:[^:]*:[0-9]{12}:(?P<QueueName>[^:]+)$"),
 		},
 	},
 	{
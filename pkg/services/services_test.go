@@ -3,15 +3,25 @@ package services
 import (
 	"context"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/apigateway"
 	"github.com/aws/aws-sdk-go/service/apigateway/apigatewayiface"
+	"github.com/aws/aws-sdk-go/service/bedrock"
+	"github.com/aws/aws-sdk-go/service/bedrock/bedrockiface"
 	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
 	"github.com/aws/aws-sdk-go/service/databasemigrationservice/databasemigrationserviceiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/elasticache/elasticacheiface"
+	log "github.com/sirupsen/logrus"
 
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/model"
 )
 
@@ -329,12 +339,408 @@ func TestDMSFilterFunc(t *testing.T) {
 	}
 }
 
+func TestElastiCacheFilterFunc(t *testing.T) {
+	clusterARN := func(id string) string {
+		return "arn:aws:elasticache:us-east-1:123456789012:cluster:" + id
+	}
+	groupARN := func(id string) string {
+		return "arn:aws:elasticache:us-east-1:123456789012:replicationgroup:" + id
+	}
+	iface := TagsInterface{
+		ElastiCacheClient: elastiCacheClient{
+			describeReplicationGroupsOutput: &elasticache.DescribeReplicationGroupsOutput{
+				ReplicationGroups: []*elasticache.ReplicationGroup{
+					{
+						ARN:            aws.String(groupARN("orders")),
+						MemberClusters: aws.StringSlice([]string{"orders-0001-001", "orders-0001-002", "orders-0002-001"}),
+					},
+					{
+						ARN:            aws.String(groupARN("untagged")),
+						MemberClusters: aws.StringSlice([]string{"untagged-001"}),
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		iface           TagsInterface
+		inputResources  []*TaggedResource
+		outputResources []*TaggedResource
+	}{
+		{
+			"memcached clusters",
+			TagsInterface{},
+			[]*TaggedResource{
+				{ARN: clusterARN("sessions"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Team", Value: "web"}}},
+			},
+			[]*TaggedResource{
+				{ARN: clusterARN("sessions"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Team", Value: "web"}}},
+			},
+		},
+		{
+			"redis replication group with a tagged member cluster",
+			iface,
+			[]*TaggedResource{
+				{ARN: clusterARN("sessions"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Team", Value: "web"}}},
+				{ARN: groupARN("orders"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Team", Value: "payments"}}, ExportUp: true},
+				{ARN: clusterARN("orders-0001-002"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Role", Value: "replica"}}},
+			},
+			[]*TaggedResource{
+				{ARN: clusterARN("sessions"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Team", Value: "web"}}},
+				{ARN: clusterARN("orders-0001-001"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Team", Value: "payments"}}, ExportUp: true},
+				{ARN: clusterARN("orders-0002-001"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Team", Value: "payments"}}, ExportUp: true},
+				{ARN: clusterARN("orders-0001-002"), Namespace: "ec", Region: "us-east-1", Tags: []model.Tag{{Key: "Role", Value: "replica"}}},
+			},
+		},
+		{
+			"deleted replication group",
+			iface,
+			[]*TaggedResource{
+				{ARN: groupARN("deleted"), Namespace: "ec", Region: "us-east-1"},
+			},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec := SupportedServices.GetService("ec")
+
+			outputResources, err := ec.FilterFunc(context.Background(), test.iface, test.inputResources)
+			if err != nil {
+				t.Logf("Error from FilterFunc: %v", err)
+				t.FailNow()
+			}
+			if len(outputResources) != len(test.outputResources) {
+				t.Logf("len(outputResources) = %d, want %d", len(outputResources), len(test.outputResources))
+				t.FailNow()
+			}
+			for i, resource := range outputResources {
+				if !reflect.DeepEqual(*resource, *test.outputResources[i]) {
+					t.Errorf("outputResources[%d] = %+v, want %+v", i, *resource, *test.outputResources[i])
+				}
+			}
+		})
+	}
+}
+
+func TestElastiCacheDimensionRegexps(t *testing.T) {
+	clusterRegexp := regexp.MustCompile(*SupportedServices.GetService("ec").DimensionRegexps[0])
+
+	tests := []struct {
+		arn            string
+		cacheClusterId string
+	}{
+		{arn: "arn:aws:elasticache:us-east-1:123456789012:cluster:sessions", cacheClusterId: "sessions"},
+		{arn: "arn:aws:elasticache:us-east-1:123456789012:cluster:orders-0002-001", cacheClusterId: "orders-0002-001"},
+		// Replication groups are replaced by their member clusters
+		{arn: "arn:aws:elasticache:us-east-1:123456789012:replicationgroup:orders"},
+	}
+	for _, test := range tests {
+		match := clusterRegexp.FindStringSubmatch(test.arn)
+		if test.cacheClusterId == "" {
+			if match != nil {
+				t.Errorf("CacheClusterId extracted from %s: %v", test.arn, match)
+			}
+			continue
+		}
+		if len(match) != 2 || match[1] != test.cacheClusterId {
+			t.Errorf("CacheClusterId %s not extracted from %s: %v", test.cacheClusterId, test.arn, match)
+		}
+	}
+}
+
+func TestBedrockResourceFunc(t *testing.T) {
+	iface := TagsInterface{
+		BedrockClient: bedrockClient{
+			listFoundationModelsOutput: &bedrock.ListFoundationModelsOutput{
+				ModelSummaries: []*bedrock.FoundationModelSummary{
+					{
+						ModelArn: aws.String("arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-v2"),
+						ModelId:  aws.String("anthropic.claude-v2"),
+					},
+					{
+						ModelArn: aws.String("arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1"),
+						ModelId:  aws.String("amazon.titan-text-express-v1"),
+					},
+				},
+			},
+		},
+		Logger: logger.NewLogrusLogger(log.StandardLogger()),
+	}
+
+	tests := []struct {
+		name            string
+		searchTags      []model.Tag
+		outputResources []*TaggedResource
+	}{
+		{
+			"untagged foundation models are discovered",
+			nil,
+			[]*TaggedResource{
+				{
+					ARN:       "arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-v2",
+					Namespace: "bedrock",
+					Region:    "us-east-1",
+				},
+				{
+					ARN:       "arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1",
+					Namespace: "bedrock",
+					Region:    "us-east-1",
+				},
+			},
+		},
+		{
+			"untagged foundation models never match search tags",
+			[]model.Tag{{Key: "env", Value: "prod"}},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bedrockService := SupportedServices.GetService("bedrock")
+
+			outputResources, err := bedrockService.ResourceFunc(context.Background(), iface, &config.Job{Type: "bedrock", SearchTags: test.searchTags}, "us-east-1")
+			if err != nil {
+				t.Logf("Error from ResourceFunc: %v", err)
+				t.FailNow()
+			}
+			if !reflect.DeepEqual(outputResources, test.outputResources) {
+				t.Errorf("outputResources = %+v, want %+v", outputResources, test.outputResources)
+			}
+		})
+	}
+}
+
+func TestBedrockDimensionRegexps(t *testing.T) {
+	bedrockService := SupportedServices.GetService("bedrock")
+	regexp := regexp.MustCompile(*bedrockService.DimensionRegexps[0])
+
+	match := regexp.FindStringSubmatch("arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-v2:1")
+	if len(match) != 2 || match[1] != "anthropic.claude-v2:1" {
+		t.Errorf("ModelId not extracted from the model ARN: %v", match)
+	}
+}
+
+func TestPrivateLinkResourceFuncs(t *testing.T) {
+	iface := TagsInterface{
+		Ec2Client: ec2Client{
+			describeVpcEndpointsOutput: &ec2.DescribeVpcEndpointsOutput{
+				VpcEndpoints: []*ec2.VpcEndpoint{
+					{
+						VpcEndpointId: aws.String("vpce-0123456789abcdef0"),
+						OwnerId:       aws.String("123456789012"),
+					},
+					{
+						VpcEndpointId: aws.String("vpce-0123456789abcdef1"),
+						OwnerId:       aws.String("123456789012"),
+						Tags:          []*ec2.Tag{{Key: aws.String("env"), Value: aws.String("prod")}},
+					},
+				},
+			},
+			describeVpcEndpointServiceConfigurationsOutput: &ec2.DescribeVpcEndpointServiceConfigurationsOutput{
+				ServiceConfigurations: []*ec2.ServiceConfiguration{
+					{ServiceId: aws.String("vpce-svc-0123456789abcdef0")},
+					{
+						ServiceId: aws.String("vpce-svc-0123456789abcdef1"),
+						Tags:      []*ec2.Tag{{Key: aws.String("env"), Value: aws.String("prod")}},
+					},
+				},
+			},
+		},
+		AccountId: "123456789012",
+		Logger:    logger.NewLogrusLogger(log.StandardLogger()),
+	}
+
+	tests := []struct {
+		name            string
+		jobType         string
+		region          string
+		searchTags      []model.Tag
+		outputResources []*TaggedResource
+	}{
+		{
+			"untagged endpoints are discovered",
+			"vpc-endpoint",
+			"us-east-1",
+			nil,
+			[]*TaggedResource{
+				{
+					ARN:       "arn:aws:ec2:us-east-1:123456789012:vpc-endpoint/vpce-0123456789abcdef0",
+					Namespace: "vpc-endpoint",
+					Region:    "us-east-1",
+				},
+			},
+		},
+		{
+			"untagged endpoint services are discovered",
+			"vpc-endpoint-service",
+			"cn-north-1",
+			nil,
+			[]*TaggedResource{
+				{
+					ARN:       "arn:aws-cn:ec2:cn-north-1:123456789012:vpc-endpoint-service/vpce-svc-0123456789abcdef0",
+					Namespace: "vpc-endpoint-service",
+					Region:    "cn-north-1",
+				},
+			},
+		},
+		{
+			"untagged endpoints never match search tags",
+			"vpc-endpoint",
+			"us-east-1",
+			[]model.Tag{{Key: "env", Value: "prod"}},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := SupportedServices.GetService(test.jobType)
+
+			outputResources, err := service.ResourceFunc(context.Background(), iface, &config.Job{Type: test.jobType, SearchTags: test.searchTags}, test.region)
+			if err != nil {
+				t.Logf("Error from ResourceFunc: %v", err)
+				t.FailNow()
+			}
+			if !reflect.DeepEqual(outputResources, test.outputResources) {
+				t.Errorf("outputResources = %+v, want %+v", outputResources, test.outputResources)
+			}
+		})
+	}
+}
+
+func TestPrivateLinkDimensionRegexps(t *testing.T) {
+	tests := []struct {
+		jobType string
+		arn     string
+		value   string
+	}{
+		{jobType: "vpc-endpoint", arn: "arn:aws:ec2:us-east-1:123456789012:vpc-endpoint/vpce-0123456789abcdef0", value: "vpce-0123456789abcdef0"},
+		{jobType: "vpc-endpoint-service", arn: "arn:aws:ec2:us-east-1:123456789012:vpc-endpoint-service/vpce-svc-0123456789abcdef0", value: "vpce-svc-0123456789abcdef0"},
+	}
+	for _, test := range tests {
+		service := SupportedServices.GetService(test.jobType)
+		regexp := regexp.MustCompile(*service.DimensionRegexps[0])
+
+		match := regexp.FindStringSubmatch(test.arn)
+		if len(match) != 2 || match[1] != test.value {
+			t.Errorf("%s not extracted from %s: %v", test.value, test.arn, match)
+		}
+	}
+}
+
+func TestALBDimensionRegexps(t *testing.T) {
+	albService := SupportedServices.GetService("alb")
+	targetGroupRegexp := regexp.MustCompile(*albService.DimensionRegexps[0])
+	loadBalancerRegexp := regexp.MustCompile(*albService.DimensionRegexps[1])
+
+	tests := []struct {
+		arn          string
+		targetGroup  string
+		loadBalancer string
+	}{
+		{arn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/app/public/50dc6c495c0c9188", loadBalancer: "app/public/50dc6c495c0c9188"},
+		{arn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/shared/9999666677773333", targetGroup: "targetgroup/shared/9999666677773333"},
+		{arn: "arn:aws-cn:elasticloadbalancing:cn-north-1:123456789012:targetgroup/orders-tg/73e2d6bc24d8a067", targetGroup: "targetgroup/orders-tg/73e2d6bc24d8a067"},
+		// Listeners and network load balancers aren't resources of the service
+		{arn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:listener/app/public/50dc6c495c0c9188/f2f7dc8efc522ab2"},
+		{arn: "arn:aws:elasticloadbalancing:us-east-1:123456789012:loadbalancer/net/private/50dc6c495c0c9188"},
+	}
+	for _, test := range tests {
+		for _, check := range []struct {
+			dimension string
+			regexp    *regexp.Regexp
+			value     string
+		}{
+			{dimension: "TargetGroup", regexp: targetGroupRegexp, value: test.targetGroup},
+			{dimension: "LoadBalancer", regexp: loadBalancerRegexp, value: test.loadBalancer},
+		} {
+			match := check.regexp.FindStringSubmatch(test.arn)
+			if check.value == "" {
+				if match != nil {
+					t.Errorf("%s extracted from %s: %v", check.dimension, test.arn, match)
+				}
+				continue
+			}
+			if len(match) != 2 || match[1] != check.value {
+				t.Errorf("%s %s not extracted from %s: %v", check.dimension, check.value, test.arn, match)
+			}
+		}
+	}
+}
+
+func TestSQSDimensionRegexps(t *testing.T) {
+	sqsService := SupportedServices.GetService("sqs")
+	regexp := regexp.MustCompile(*sqsService.DimensionRegexps[0])
+
+	tests := []struct {
+		arn       string
+		queueName string
+	}{
+		{arn: "arn:aws:sqs:us-east-1:123456789012:orders", queueName: "orders"},
+		{arn: "arn:aws:sqs:us-east-1:123456789012:orders.fifo", queueName: "orders.fifo"},
+		{arn: "arn:aws-us-gov:sqs:us-gov-west-1:123456789012:orders-dlq", queueName: "orders-dlq"},
+		// Queue URLs aren't ARNs
+		{arn: "https://sqs.us-east-1.amazonaws.com/123456789012/orders"},
+	}
+	for _, test := range tests {
+		match := regexp.FindStringSubmatch(test.arn)
+		if test.queueName == "" {
+			if match != nil {
+				t.Errorf("QueueName extracted from %s: %v", test.arn, match)
+			}
+			continue
+		}
+		if len(match) != 2 || match[1] != test.queueName {
+			t.Errorf("QueueName %s not extracted from %s: %v", test.queueName, test.arn, match)
+		}
+	}
+}
+
+type bedrockClient struct {
+	bedrockiface.BedrockAPI
+	listFoundationModelsOutput *bedrock.ListFoundationModelsOutput
+}
+
+func (bedrock bedrockClient) ListFoundationModelsWithContext(ctx aws.Context, input *bedrock.ListFoundationModelsInput, opts ...request.Option) (*bedrock.ListFoundationModelsOutput, error) {
+	return bedrock.listFoundationModelsOutput, nil
+}
+
+type ec2Client struct {
+	ec2iface.EC2API
+	describeVpcEndpointsOutput                     *ec2.DescribeVpcEndpointsOutput
+	describeVpcEndpointServiceConfigurationsOutput *ec2.DescribeVpcEndpointServiceConfigurationsOutput
+}
+
+func (ec2Client ec2Client) DescribeVpcEndpointsPagesWithContext(ctx aws.Context, input *ec2.DescribeVpcEndpointsInput, fn func(*ec2.DescribeVpcEndpointsOutput, bool) bool, opts ...request.Option) error {
+	fn(ec2Client.describeVpcEndpointsOutput, true)
+	return nil
+}
+
+func (ec2Client ec2Client) DescribeVpcEndpointServiceConfigurationsPagesWithContext(ctx aws.Context, input *ec2.DescribeVpcEndpointServiceConfigurationsInput, fn func(*ec2.DescribeVpcEndpointServiceConfigurationsOutput, bool) bool, opts ...request.Option) error {
+	fn(ec2Client.describeVpcEndpointServiceConfigurationsOutput, true)
+	return nil
+}
+
 type dmsClient struct {
 	databasemigrationserviceiface.DatabaseMigrationServiceAPI
 	describeReplicationInstancesOutput *databasemigrationservice.DescribeReplicationInstancesOutput
 	describeReplicationTasksOutput     *databasemigrationservice.DescribeReplicationTasksOutput
 }
 
+type elastiCacheClient struct {
+	elasticacheiface.ElastiCacheAPI
+	describeReplicationGroupsOutput *elasticache.DescribeReplicationGroupsOutput
+}
+
+func (elastiCache elastiCacheClient) DescribeReplicationGroupsPagesWithContext(ctx aws.Context, input *elasticache.DescribeReplicationGroupsInput, fn func(*elasticache.DescribeReplicationGroupsOutput, bool) bool, opts ...request.Option) error {
+	fn(elastiCache.describeReplicationGroupsOutput, true)
+	return nil
+}
+
 type apiGatewayClient struct {
 	apigatewayiface.APIGatewayAPI
 	getRestApisOutput *apigateway.GetRestApisOutput
@@ -1,13 +1,17 @@
 package session
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -15,16 +19,32 @@ import (
 	"github.com/aws/aws-sdk-go/service/apigateway/apigatewayiface"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/bedrock"
+	"github.com/aws/aws-sdk-go/service/bedrock/bedrockiface"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/configservice/configserviceiface"
 	"github.com/aws/aws-sdk-go/service/databasemigrationservice"
 	"github.com/aws/aws-sdk-go/service/databasemigrationservice/databasemigrationserviceiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elasticache"
+	"github.com/aws/aws-sdk-go/service/elasticache/elasticacheiface"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	"github.com/aws/aws-sdk-go/service/prometheusservice"
 	"github.com/aws/aws-sdk-go/service/prometheusservice/prometheusserviceiface"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/rds/rdsiface"
 	r "github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
 	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi/resourcegroupstaggingapiiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/storagegateway"
 	"github.com/aws/aws-sdk-go/service/storagegateway/storagegatewayiface"
 	"github.com/aws/aws-sdk-go/service/sts"
@@ -32,6 +52,7 @@ import (
 
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
 )
 
 // SessionCache is an interface to a cache of sessions and clients for all the
@@ -47,6 +68,15 @@ type SessionCache interface {
 	GetAPIGateway(*string, config.Role) apigatewayiface.APIGatewayAPI
 	GetStorageGateway(*string, config.Role) storagegatewayiface.StorageGatewayAPI
 	GetPrometheus(*string, config.Role) prometheusserviceiface.PrometheusServiceAPI
+	GetBedrock(*string, config.Role) bedrockiface.BedrockAPI
+	GetConfigService(*string, config.Role) configserviceiface.ConfigServiceAPI
+	GetS3(*string, config.Role) s3iface.S3API
+	GetCloudFormation(*string, config.Role) cloudformationiface.CloudFormationAPI
+	GetIAM(config.Role) iamiface.IAMAPI
+	GetCloudwatchLogs(*string, config.Role) cloudwatchlogsiface.CloudWatchLogsAPI
+	GetRDS(*string, config.Role) rdsiface.RDSAPI
+	GetElastiCache(*string, config.Role) elasticacheiface.ElastiCacheAPI
+	GetRegions(context.Context, config.Role) ([]string, error)
 	Refresh()
 	Clear()
 }
@@ -55,13 +85,45 @@ type sessionCache struct {
 	stsRegion        string
 	session          *session.Session
 	endpointResolver endpoints.ResolverFunc
-	stscache         map[config.Role]stsiface.STSAPI
-	clients          map[config.Role]map[string]*clientCache
-	cleared          bool
-	refreshed        bool
-	mu               sync.Mutex
-	fips             bool
-	logger           logger.Logger
+	// partitions and partitionSessions are used instead of endpointResolver and
+	// session for the roles of a partition
+	partitions        map[string]partition
+	partitionSessions map[string]*session.Session
+	stscache          map[config.Role]stsiface.STSAPI
+	clients           map[config.Role]map[string]*clientCache
+	regions           map[config.Role]cachedRegions
+	cleared           bool
+	refreshed         bool
+	mu                sync.Mutex
+	fips              bool
+	logger            logger.Logger
+	// sessionCacheTTL is how long the clients are reused across scrapes, from clientsCreatedAt.
+	// clientsCreatedAt is zero while the clients are cleared.
+	sessionCacheTTL  time.Duration
+	clientsCreatedAt time.Time
+}
+
+// partition resolves the endpoints of the clients of the roles of an AWS partition
+type partition struct {
+	resolver endpoints.ResolverFunc
+	regions  map[string]endpoints.Region
+	// defaultRegion is used for STS and to list the regions when the configured
+	// region isn't part of the partition
+	defaultRegion string
+}
+
+func newPartition(resolver endpoints.ResolverFunc, regions map[string]endpoints.Region) partition {
+	ids := make([]string, 0, len(regions))
+	for id := range regions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	p := partition{resolver: resolver, regions: regions}
+	if len(ids) > 0 {
+		p.defaultRegion = ids[0]
+	}
+	return p
 }
 
 type clientCache struct {
@@ -77,6 +139,24 @@ type clientCache struct {
 	dms            databasemigrationserviceiface.DatabaseMigrationServiceAPI
 	apiGateway     apigatewayiface.APIGatewayAPI
 	storageGateway storagegatewayiface.StorageGatewayAPI
+	bedrock        bedrockiface.BedrockAPI
+	configService  configserviceiface.ConfigServiceAPI
+	s3             s3iface.S3API
+	cloudFormation cloudformationiface.CloudFormationAPI
+	rds            rdsiface.RDSAPI
+	elastiCache    elasticacheiface.ElastiCacheAPI
+	// logsInsights is set for the regions of the logs insights jobs, the only ones using the
+	// CloudWatch Logs client besides the regions of discovery jobs
+	logsInsights bool
+	logs         cloudwatchlogsiface.CloudWatchLogsAPI
+}
+
+// regionsCacheTTL is how long the regions enabled for an account are cached
+const regionsCacheTTL = time.Hour
+
+type cachedRegions struct {
+	regions []string
+	expiry  time.Time
 }
 
 // NewSessionCache creates a new session cache to use when fetching data from
@@ -94,8 +174,18 @@ func NewSessionCache(cfg config.ScrapeConf, fips bool, logger logger.Logger) Ses
 				roleCache[role] = map[string]*clientCache{}
 			}
 			for _, region := range discoveryJob.Regions {
+				// regions of the wildcard are registered once they are known, see GetRegions
+				if region == config.AllRegions {
+					continue
+				}
 				roleCache[role][region] = &clientCache{}
 			}
+			// AWS Config aggregators are queried in their own region
+			if discoveryJob.ConfigAggregator != nil {
+				if _, ok := roleCache[role][discoveryJob.ConfigAggregator.Region]; !ok {
+					roleCache[role][discoveryJob.ConfigAggregator.Region] = &clientCache{}
+				}
+			}
 		}
 	}
 
@@ -110,6 +200,10 @@ func NewSessionCache(cfg config.ScrapeConf, fips bool, logger logger.Logger) Ses
 			}
 
 			for _, region := range staticJob.Regions {
+				// regions of the wildcard are registered once they are known, see GetRegions
+				if region == config.AllRegions {
+					continue
+				}
 				// Only write a new region in if the region does not exist
 				if _, ok := roleCache[role][region]; !ok {
 					roleCache[role][region] = &clientCache{
@@ -131,6 +225,10 @@ func NewSessionCache(cfg config.ScrapeConf, fips bool, logger logger.Logger) Ses
 			}
 
 			for _, region := range customNamespaceJob.Regions {
+				// regions of the wildcard are registered once they are known, see GetRegions
+				if region == config.AllRegions {
+					continue
+				}
 				// Only write a new region in if the region does not exist
 				if _, ok := roleCache[role][region]; !ok {
 					roleCache[role][region] = &clientCache{
@@ -141,6 +239,81 @@ func NewSessionCache(cfg config.ScrapeConf, fips bool, logger logger.Logger) Ses
 		}
 	}
 
+	for _, alarmsJob := range cfg.Alarms {
+		for _, role := range alarmsJob.Roles {
+			if _, ok := stscache[role]; !ok {
+				stscache[role] = nil
+			}
+
+			if _, ok := roleCache[role]; !ok {
+				roleCache[role] = map[string]*clientCache{}
+			}
+
+			for _, region := range alarmsJob.Regions {
+				// regions of the wildcard are registered once they are known, see GetRegions
+				if region == config.AllRegions {
+					continue
+				}
+				// Alarms jobs only use the CloudWatch client, like static jobs
+				if _, ok := roleCache[role][region]; !ok {
+					roleCache[role][region] = &clientCache{
+						onlyStatic: true,
+					}
+				}
+			}
+		}
+	}
+
+	for _, rdsJob := range cfg.RDSEnhancedMonitoring {
+		for _, role := range rdsJob.Roles {
+			if _, ok := stscache[role]; !ok {
+				stscache[role] = nil
+			}
+
+			if _, ok := roleCache[role]; !ok {
+				roleCache[role] = map[string]*clientCache{}
+			}
+
+			for _, region := range rdsJob.Regions {
+				// regions of the wildcard are registered once they are known, see GetRegions
+				if region == config.AllRegions {
+					continue
+				}
+				// RDS enhanced monitoring jobs discover their instances with the tagging API, like discovery jobs
+				if _, ok := roleCache[role][region]; !ok {
+					roleCache[role][region] = &clientCache{}
+				}
+				roleCache[role][region].onlyStatic = false
+			}
+		}
+	}
+
+	for _, logsInsightsJob := range cfg.LogsInsights {
+		for _, role := range logsInsightsJob.Roles {
+			if _, ok := stscache[role]; !ok {
+				stscache[role] = nil
+			}
+
+			if _, ok := roleCache[role]; !ok {
+				roleCache[role] = map[string]*clientCache{}
+			}
+
+			for _, region := range logsInsightsJob.Regions {
+				// regions of the wildcard are registered once they are known, see GetRegions
+				if region == config.AllRegions {
+					continue
+				}
+				// Logs insights jobs only use the CloudWatch Logs client on top of the static ones
+				if _, ok := roleCache[role][region]; !ok {
+					roleCache[role][region] = &clientCache{
+						onlyStatic: true,
+					}
+				}
+				roleCache[role][region].logsInsights = true
+			}
+		}
+	}
+
 	endpointResolver := endpoints.DefaultResolver().EndpointFor
 
 	endpointUrlOverride := os.Getenv("AWS_ENDPOINT_URL")
@@ -153,17 +326,69 @@ func NewSessionCache(cfg config.ScrapeConf, fips bool, logger logger.Logger) Ses
 		}
 	}
 
+	partitions := map[string]partition{}
+	for _, p := range endpoints.DefaultPartitions() {
+		resolver := p.EndpointFor
+		if endpointUrlOverride != "" {
+			resolver = endpointResolver
+		}
+		partitions[p.ID()] = newPartition(resolver, p.Regions())
+	}
+
 	return &sessionCache{
-		stsRegion:        cfg.StsRegion,
-		session:          nil,
-		endpointResolver: endpointResolver,
-		stscache:         stscache,
-		clients:          roleCache,
-		fips:             fips,
-		cleared:          false,
-		refreshed:        false,
-		logger:           logger,
+		stsRegion:         cfg.StsRegion,
+		session:           nil,
+		endpointResolver:  endpointResolver,
+		partitions:        partitions,
+		partitionSessions: map[string]*session.Session{},
+		stscache:          stscache,
+		clients:           roleCache,
+		regions:           map[config.Role]cachedRegions{},
+		fips:              fips,
+		cleared:           false,
+		refreshed:         false,
+		logger:            logger,
+		sessionCacheTTL:   cfg.CredentialRefresh.SessionCacheTTL,
+	}
+}
+
+// sessionFor returns the session to create the clients of role with, which resolves
+// the endpoints of the partition of role. Sessions are created on first use.
+func (s *sessionCache) sessionFor(role config.Role) *session.Session {
+	p, ok := s.partitions[role.Partition]
+	if role.Partition == "" || !ok {
+		if s.session == nil {
+			s.session = createAWSSession(s.endpointResolver, s.logger.IsDebugEnabled())
+		}
+		return s.session
+	}
+
+	if sess, ok := s.partitionSessions[role.Partition]; ok {
+		return sess
+	}
+	sess := createAWSSession(p.resolver, s.logger.IsDebugEnabled())
+	if _, ok := p.regions[aws.StringValue(sess.Config.Region)]; !ok {
+		// Roles are assumed with the STS endpoint of the session's region, which must be part of the partition
+		sess = sess.Copy(&aws.Config{Region: aws.String(p.defaultRegion)})
+	}
+	if s.partitionSessions == nil {
+		s.partitionSessions = map[string]*session.Session{}
+	}
+	s.partitionSessions[role.Partition] = sess
+	return sess
+}
+
+// stsRegionFor returns the STS region of role: the configured one, unless the
+// role is in a partition which doesn't contain it
+func (s *sessionCache) stsRegionFor(role config.Role) string {
+	p, ok := s.partitions[role.Partition]
+	if role.Partition == "" || !ok {
+		return s.stsRegion
+	}
+	if _, ok := p.regions[s.stsRegion]; ok {
+		return s.stsRegion
 	}
+	return p.defaultRegion
 }
 
 // Refresh and Clear help to avoid using lock primitives by asserting that
@@ -173,6 +398,13 @@ func (s *sessionCache) Clear() {
 		return
 	}
 
+	// The clients, with the credentials they assumed, are reused by the next scrapes until the session cache TTL
+	if s.sessionCacheTTL > 0 && time.Since(s.clientsCreatedAt) < s.sessionCacheTTL {
+		s.cleared = true
+		s.refreshed = false
+		return
+	}
+
 	for role := range s.stscache {
 		s.stscache[role] = nil
 	}
@@ -187,8 +419,16 @@ func (s *sessionCache) Clear() {
 			s.clients[role][region].dms = nil
 			s.clients[role][region].apiGateway = nil
 			s.clients[role][region].storageGateway = nil
+			s.clients[role][region].bedrock = nil
+			s.clients[role][region].configService = nil
+			s.clients[role][region].s3 = nil
+			s.clients[role][region].cloudFormation = nil
+			s.clients[role][region].logs = nil
+			s.clients[role][region].rds = nil
+			s.clients[role][region].elastiCache = nil
 		}
 	}
+	s.clientsCreatedAt = time.Time{}
 	s.cleared = true
 	s.refreshed = false
 }
@@ -199,35 +439,62 @@ func (s *sessionCache) Refresh() {
 		return
 	}
 
-	// sessions really only need to be constructed once at runtime
-	if s.session == nil {
-		s.session = createAWSSession(s.endpointResolver, s.logger.IsDebugEnabled())
-	}
+	// Only the clients of the regions registered since, e.g. by GetRegions, are created
+	// when those of the previous scrapes are reused
+	reuse := !s.clientsCreatedAt.IsZero()
 
 	for role := range s.stscache {
-		s.stscache[role] = createStsSession(s.session, role, s.stsRegion, s.fips, s.logger.IsDebugEnabled())
+		for _, hop := range role.Chain() {
+			if hop.WebIdentityTokenFile == "" {
+				continue
+			}
+			if err := checkWebIdentityTokenFile(hop.WebIdentityTokenFile); err != nil {
+				s.logger.Error(err, "Role can't be assumed with a web identity", "arn", hop.RoleArn)
+			}
+		}
+		if reuse && s.stscache[role] != nil {
+			continue
+		}
+		// sessions really only need to be constructed once at runtime
+		s.stscache[role] = createStsSession(s.sessionFor(role), role, s.stsRegionFor(role), s.fips, s.logger.IsDebugEnabled())
 	}
 
 	for role, regions := range s.clients {
+		sess := s.sessionFor(role)
 		for region := range regions {
+			if reuse && s.clients[role][region].cloudwatch != nil {
+				continue
+			}
 			// if the role is just used in static jobs, then we
 			// can skip creating other sessions and potentially running
 			// into permissions errors or taking up needless cycles
-			s.clients[role][region].cloudwatch = createCloudwatchSession(s.session, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].cloudwatch = createCloudwatchSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			if s.clients[role][region].logsInsights || !s.clients[role][region].onlyStatic {
+				s.clients[role][region].logs = createCloudwatchLogsSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			}
 			if s.clients[role][region].onlyStatic {
 				continue
 			}
 
-			s.clients[role][region].tagging = createTagSession(s.session, &region, role, s.logger.IsDebugEnabled())
-			s.clients[role][region].asg = createASGSession(s.session, &region, role, s.logger.IsDebugEnabled())
-			s.clients[role][region].ec2 = createEC2Session(s.session, &region, role, s.fips, s.logger.IsDebugEnabled())
-			s.clients[role][region].dms = createDMSSession(s.session, &region, role, s.fips, s.logger.IsDebugEnabled())
-			s.clients[role][region].apiGateway = createAPIGatewaySession(s.session, &region, role, s.fips, s.logger.IsDebugEnabled())
-			s.clients[role][region].storageGateway = createStorageGatewaySession(s.session, &region, role, s.fips, s.logger.IsDebugEnabled())
-			s.clients[role][region].prometheus = createPrometheusSession(s.session, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].tagging = createTagSession(sess, &region, role, s.logger.IsDebugEnabled())
+			s.clients[role][region].asg = createASGSession(sess, &region, role, s.logger.IsDebugEnabled())
+			s.clients[role][region].ec2 = createEC2Session(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].dms = createDMSSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].apiGateway = createAPIGatewaySession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].storageGateway = createStorageGatewaySession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].prometheus = createPrometheusSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].bedrock = createBedrockSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].configService = createConfigServiceSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].s3 = createS3Session(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].cloudFormation = createCloudFormationSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].rds = createRDSSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
+			s.clients[role][region].elastiCache = createElastiCacheSession(sess, &region, role, s.fips, s.logger.IsDebugEnabled())
 		}
 	}
 
+	if !reuse {
+		s.clientsCreatedAt = time.Now()
+	}
 	s.cleared = false
 	s.refreshed = true
 }
@@ -241,7 +508,7 @@ func (s *sessionCache) GetSTS(role config.Role) stsiface.STSAPI {
 	if sess, ok := s.stscache[role]; ok && sess != nil {
 		return sess
 	}
-	s.stscache[role] = createStsSession(s.session, role, s.stsRegion, s.fips, s.logger.IsDebugEnabled())
+	s.stscache[role] = createStsSession(s.sessionFor(role), role, s.stsRegionFor(role), s.fips, s.logger.IsDebugEnabled())
 	return s.stscache[role]
 }
 
@@ -254,7 +521,7 @@ func (s *sessionCache) GetCloudwatch(region *string, role config.Role) cloudwatc
 	if sess, ok := s.clients[role][*region]; ok && sess.cloudwatch != nil {
 		return sess.cloudwatch
 	}
-	s.clients[role][*region].cloudwatch = createCloudwatchSession(s.session, region, role, s.fips, s.logger.IsDebugEnabled())
+	s.clients[role][*region].cloudwatch = createCloudwatchSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
 	return s.clients[role][*region].cloudwatch
 }
 
@@ -268,7 +535,7 @@ func (s *sessionCache) GetTagging(region *string, role config.Role) resourcegrou
 		return sess.tagging
 	}
 
-	s.clients[role][*region].tagging = createTagSession(s.session, region, role, s.fips)
+	s.clients[role][*region].tagging = createTagSession(s.sessionFor(role), region, role, s.fips)
 	return s.clients[role][*region].tagging
 }
 
@@ -282,7 +549,7 @@ func (s *sessionCache) GetASG(region *string, role config.Role) autoscalingiface
 		return sess.asg
 	}
 
-	s.clients[role][*region].asg = createASGSession(s.session, region, role, s.fips)
+	s.clients[role][*region].asg = createASGSession(s.sessionFor(role), region, role, s.fips)
 	return s.clients[role][*region].asg
 }
 
@@ -296,7 +563,7 @@ func (s *sessionCache) GetEC2(region *string, role config.Role) ec2iface.EC2API
 		return sess.ec2
 	}
 
-	s.clients[role][*region].ec2 = createEC2Session(s.session, region, role, s.fips, s.logger.IsDebugEnabled())
+	s.clients[role][*region].ec2 = createEC2Session(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
 	return s.clients[role][*region].ec2
 }
 
@@ -310,7 +577,7 @@ func (s *sessionCache) GetPrometheus(region *string, role config.Role) prometheu
 		return sess.prometheus
 	}
 
-	s.clients[role][*region].prometheus = createPrometheusSession(s.session, region, role, s.fips, s.logger.IsDebugEnabled())
+	s.clients[role][*region].prometheus = createPrometheusSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
 	return s.clients[role][*region].prometheus
 }
 
@@ -324,7 +591,7 @@ func (s *sessionCache) GetDMS(region *string, role config.Role) databasemigratio
 		return sess.dms
 	}
 
-	s.clients[role][*region].dms = createDMSSession(s.session, region, role, s.fips, s.logger.IsDebugEnabled())
+	s.clients[role][*region].dms = createDMSSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
 	return s.clients[role][*region].dms
 }
 
@@ -338,7 +605,7 @@ func (s *sessionCache) GetAPIGateway(region *string, role config.Role) apigatewa
 		return sess.apiGateway
 	}
 
-	s.clients[role][*region].apiGateway = createAPIGatewaySession(s.session, region, role, s.fips, s.logger.IsDebugEnabled())
+	s.clients[role][*region].apiGateway = createAPIGatewaySession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
 	return s.clients[role][*region].apiGateway
 }
 
@@ -352,10 +619,173 @@ func (s *sessionCache) GetStorageGateway(region *string, role config.Role) stora
 		return sess.storageGateway
 	}
 
-	s.clients[role][*region].storageGateway = createStorageGatewaySession(s.session, region, role, s.fips, s.logger.IsDebugEnabled())
+	s.clients[role][*region].storageGateway = createStorageGatewaySession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
 	return s.clients[role][*region].storageGateway
 }
 
+func (s *sessionCache) GetBedrock(region *string, role config.Role) bedrockiface.BedrockAPI {
+	// if we have not refreshed then we need to lock in case we are accessing concurrently
+	if !s.refreshed {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if sess, ok := s.clients[role][*region]; ok && sess.bedrock != nil {
+		return sess.bedrock
+	}
+
+	s.clients[role][*region].bedrock = createBedrockSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
+	return s.clients[role][*region].bedrock
+}
+
+func (s *sessionCache) GetConfigService(region *string, role config.Role) configserviceiface.ConfigServiceAPI {
+	// if we have not refreshed then we need to lock in case we are accessing concurrently
+	if !s.refreshed {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if sess, ok := s.clients[role][*region]; ok && sess.configService != nil {
+		return sess.configService
+	}
+
+	s.clients[role][*region].configService = createConfigServiceSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
+	return s.clients[role][*region].configService
+}
+
+func (s *sessionCache) GetS3(region *string, role config.Role) s3iface.S3API {
+	// if we have not refreshed then we need to lock in case we are accessing concurrently
+	if !s.refreshed {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if sess, ok := s.clients[role][*region]; ok && sess.s3 != nil {
+		return sess.s3
+	}
+
+	s.clients[role][*region].s3 = createS3Session(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
+	return s.clients[role][*region].s3
+}
+
+func (s *sessionCache) GetCloudFormation(region *string, role config.Role) cloudformationiface.CloudFormationAPI {
+	// if we have not refreshed then we need to lock in case we are accessing concurrently
+	if !s.refreshed {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if sess, ok := s.clients[role][*region]; ok && sess.cloudFormation != nil {
+		return sess.cloudFormation
+	}
+
+	s.clients[role][*region].cloudFormation = createCloudFormationSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
+	return s.clients[role][*region].cloudFormation
+}
+
+func (s *sessionCache) GetCloudwatchLogs(region *string, role config.Role) cloudwatchlogsiface.CloudWatchLogsAPI {
+	// if we have not refreshed then we need to lock in case we are accessing concurrently
+	if !s.refreshed {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if sess, ok := s.clients[role][*region]; ok && sess.logs != nil {
+		return sess.logs
+	}
+
+	s.clients[role][*region].logs = createCloudwatchLogsSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
+	return s.clients[role][*region].logs
+}
+
+func (s *sessionCache) GetRDS(region *string, role config.Role) rdsiface.RDSAPI {
+	// if we have not refreshed then we need to lock in case we are accessing concurrently
+	if !s.refreshed {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if sess, ok := s.clients[role][*region]; ok && sess.rds != nil {
+		return sess.rds
+	}
+
+	s.clients[role][*region].rds = createRDSSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
+	return s.clients[role][*region].rds
+}
+
+func (s *sessionCache) GetElastiCache(region *string, role config.Role) elasticacheiface.ElastiCacheAPI {
+	// if we have not refreshed then we need to lock in case we are accessing concurrently
+	if !s.refreshed {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	}
+	if sess, ok := s.clients[role][*region]; ok && sess.elastiCache != nil {
+		return sess.elastiCache
+	}
+
+	s.clients[role][*region].elastiCache = createElastiCacheSession(s.sessionFor(role), region, role, s.fips, s.logger.IsDebugEnabled())
+	return s.clients[role][*region].elastiCache
+}
+
+// GetIAM returns an IAM client for role. It isn't cached, since IAM is only called to look up the
+// alias of the account, which is cached by the caller.
+func (s *sessionCache) GetIAM(role config.Role) iamiface.IAMAPI {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	region := s.stsRegionFor(role)
+	if region == "" {
+		region = "us-east-1"
+	}
+	return createIAMSession(s.sessionFor(role), &region, role, s.fips, s.logger.IsDebugEnabled())
+}
+
+// GetRegions returns the regions enabled for the account of role, as reported by EC2
+// DescribeRegions. The result is cached for regionsCacheTTL. Clients are registered for
+// every returned region, so GetRegions must be called before Refresh.
+func (s *sessionCache) GetRegions(ctx context.Context, role config.Role) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached, ok := s.regions[role]; ok && time.Now().Before(cached.expiry) {
+		return cached.regions, nil
+	}
+
+	region := s.stsRegionFor(role)
+	if region == "" {
+		region = "us-east-1"
+	}
+	output, err := createEC2Session(s.sessionFor(role), &region, role, s.fips, s.logger.IsDebugEnabled()).DescribeRegionsWithContext(ctx, &ec2.DescribeRegionsInput{
+		AllRegions: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	regions := enabledRegions(output, s.logger.With("arn", role.RoleArn))
+	if _, ok := s.clients[role]; !ok {
+		s.clients[role] = map[string]*clientCache{}
+	}
+	for _, region := range regions {
+		if _, ok := s.clients[role][region]; !ok {
+			s.clients[role][region] = &clientCache{}
+		}
+	}
+	s.regions[role] = cachedRegions{
+		regions: regions,
+		expiry:  time.Now().Add(regionsCacheTTL),
+	}
+	return regions, nil
+}
+
+// enabledRegions returns the names of the regions of output which are enabled for the
+// account. Opt-in regions the account didn't opt in to are skipped.
+func enabledRegions(output *ec2.DescribeRegionsOutput, logger logger.Logger) []string {
+	regions := make([]string, 0, len(output.Regions))
+	for _, region := range output.Regions {
+		if aws.StringValue(region.OptInStatus) == "not-opted-in" {
+			logger.Warn("Skipping region which is not enabled for the account", "region", aws.StringValue(region.RegionName))
+			continue
+		}
+		regions = append(regions, aws.StringValue(region.RegionName))
+	}
+	return regions
+}
+
 func setExternalID(ID string) func(p *stscreds.AssumeRoleProvider) {
 	return func(p *stscreds.AssumeRoleProvider) {
 		if ID != "" {
@@ -364,14 +794,171 @@ func setExternalID(ID string) func(p *stscreds.AssumeRoleProvider) {
 	}
 }
 
+func setRoleSessionName(name string) func(p *stscreds.AssumeRoleProvider) {
+	return func(p *stscreds.AssumeRoleProvider) {
+		if name != "" {
+			p.RoleSessionName = name
+		}
+	}
+}
+
+// setCredentialRefresh sets the duration of the assumed role sessions of refresh, and refreshes
+// their credentials within its expiry window before they expire
+func setCredentialRefresh(refresh config.CredentialRefresh) func(p *stscreds.AssumeRoleProvider) {
+	return func(p *stscreds.AssumeRoleProvider) {
+		p.ExpiryWindow = refresh.ExpiryWindow
+		if refresh.Duration != 0 {
+			p.Duration = refresh.Duration
+		}
+	}
+}
+
 func setSTSCreds(sess *session.Session, config *aws.Config, role config.Role) *aws.Config {
-	if role.RoleArn != "" {
-		config.Credentials = stscreds.NewCredentials(
-			sess, role.RoleArn, setExternalID(role.ExternalID))
+	if role.SourceRole != nil {
+		config.Credentials = chainedCredentials(sess, role.Chain())
+	} else if role.WebIdentityTokenFile != "" {
+		config.Credentials = credentials.NewCredentials(&countingProvider{Provider: newWebIdentityProvider(sts.New(sess), role), roleArn: role.RoleArn})
+	} else if role.RoleArn != "" {
+		provider := &stscreds.AssumeRoleProvider{
+			Client:   sts.New(sess),
+			RoleARN:  role.RoleArn,
+			Duration: stscreds.DefaultDuration,
+		}
+		setExternalID(role.ExternalID)(provider)
+		setRoleSessionName(role.RoleSessionName)(provider)
+		setCredentialRefresh(role.CredentialRefresh)(provider)
+		config.Credentials = credentials.NewCredentials(&countingProvider{Provider: provider, roleArn: role.RoleArn})
 	}
 	return config
 }
 
+// countingProvider counts the retrievals of the credentials of a role in CredentialRefreshCounter,
+// the refreshes of the credentials about to expire included
+type countingProvider struct {
+	credentials.Provider
+	roleArn string
+}
+
+func (p *countingProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(aws.BackgroundContext())
+}
+
+func (p *countingProvider) RetrieveWithContext(ctx credentials.Context) (credentials.Value, error) {
+	var value credentials.Value
+	var err error
+	if provider, ok := p.Provider.(credentials.ProviderWithContext); ok {
+		value, err = provider.RetrieveWithContext(ctx)
+	} else {
+		value, err = p.Provider.Retrieve()
+	}
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	promutil.CredentialRefreshCounter.WithLabelValues(p.roleArn, result).Inc()
+	return value, err
+}
+
+// ExpiresAt returns when the credentials of the provider expire, zero when they don't
+func (p *countingProvider) ExpiresAt() time.Time {
+	if expirer, ok := p.Provider.(credentials.Expirer); ok {
+		return expirer.ExpiresAt()
+	}
+	return time.Time{}
+}
+
+// webIdentityProvider assumes a role with the web identity token of a file. The file is checked
+// before every refresh of the credentials, to tell when it's missing, e.g. because the token
+// volume isn't mounted.
+type webIdentityProvider struct {
+	*stscreds.WebIdentityRoleProvider
+	roleArn   string
+	tokenFile string
+}
+
+func newWebIdentityProvider(client stsiface.STSAPI, role config.Role) *webIdentityProvider {
+	provider := stscreds.NewWebIdentityRoleProviderWithOptions(client, role.RoleArn, role.RoleSessionName, stscreds.FetchTokenPath(role.WebIdentityTokenFile))
+	provider.ExpiryWindow = role.CredentialRefresh.ExpiryWindow
+	provider.Duration = role.CredentialRefresh.Duration
+	return &webIdentityProvider{
+		WebIdentityRoleProvider: provider,
+		roleArn:                 role.RoleArn,
+		tokenFile:               role.WebIdentityTokenFile,
+	}
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(aws.BackgroundContext())
+}
+
+func (p *webIdentityProvider) RetrieveWithContext(ctx credentials.Context) (credentials.Value, error) {
+	if err := checkWebIdentityTokenFile(p.tokenFile); err != nil {
+		return credentials.Value{}, awserr.New("WebIdentityTokenFileError",
+			fmt.Sprintf("failed to assume role %s with a web identity", p.roleArn), err)
+	}
+	return p.WebIdentityRoleProvider.RetrieveWithContext(ctx)
+}
+
+// checkWebIdentityTokenFile returns an error when the web identity token file doesn't exist or is empty
+func checkWebIdentityTokenFile(tokenFile string) error {
+	info, err := os.Stat(tokenFile)
+	if err != nil {
+		return fmt.Errorf("web identity token file %s can't be read: %w", tokenFile, err)
+	}
+	if info.IsDir() || info.Size() == 0 {
+		return fmt.Errorf("web identity token file %s is not a file with a token", tokenFile)
+	}
+	return nil
+}
+
+// roleChainProvider assumes a role of a role chain. Errors are wrapped to tell
+// which hop of the chain could not be assumed.
+type roleChainProvider struct {
+	*stscreds.AssumeRoleProvider
+	hop    int
+	length int
+}
+
+func (p *roleChainProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(aws.BackgroundContext())
+}
+
+func (p *roleChainProvider) RetrieveWithContext(ctx credentials.Context) (credentials.Value, error) {
+	value, err := p.AssumeRoleProvider.RetrieveWithContext(ctx)
+	if err != nil {
+		return value, awserr.New("AssumeRoleChainError",
+			fmt.Sprintf("failed to assume role %s, hop %d of %d of the role chain", p.RoleARN, p.hop, p.length), err)
+	}
+	return value, nil
+}
+
+// chainedCredentials returns the credentials of the last role of chain, every
+// role being assumed with the credentials of the previous one. The credential
+// refresh policy of the last role applies to the whole chain.
+func chainedCredentials(sess *session.Session, chain []config.Role) *credentials.Credentials {
+	refresh := chain[len(chain)-1].CredentialRefresh
+	var creds *credentials.Credentials
+	for i, role := range chain {
+		client := sts.New(sess, &aws.Config{Credentials: creds})
+		role.CredentialRefresh = refresh
+		if role.WebIdentityTokenFile != "" {
+			// Only the first role of the chain can have a web identity
+			creds = credentials.NewCredentials(&countingProvider{Provider: newWebIdentityProvider(client, role), roleArn: role.RoleArn})
+			continue
+		}
+		provider := &stscreds.AssumeRoleProvider{
+			Client:  client,
+			RoleARN: role.RoleArn,
+		}
+		setExternalID(role.ExternalID)(provider)
+		setRoleSessionName(role.RoleSessionName)(provider)
+		setCredentialRefresh(refresh)(provider)
+		creds = credentials.NewCredentials(&countingProvider{Provider: &roleChainProvider{AssumeRoleProvider: provider, hop: i + 1, length: len(chain)}, roleArn: role.RoleArn})
+	}
+	return creds
+}
+
 func getAwsRetryer() aws.RequestRetryer {
 	return client.DefaultRetryer{
 		NumMaxRetries: 5,
@@ -401,6 +988,33 @@ func createAWSSession(resolver endpoints.ResolverFunc, isDebugEnabled bool) *ses
 	return sess
 }
 
+// setCustomEndpoint points the client of config to endpoint, which takes precedence over the FIPS endpoint.
+// The endpoint of the service is still resolved by the resolver of sess, for the signing region and name
+// of the client, unless disableResolution is set.
+func setCustomEndpoint(sess *session.Session, config *aws.Config, endpoint string, disableResolution bool) {
+	if endpoint == "" {
+		return
+	}
+	if disableResolution {
+		config.Endpoint = aws.String(endpoint)
+		return
+	}
+
+	resolver := sess.Config.EndpointResolver
+	if resolver == nil {
+		resolver = endpoints.DefaultResolver()
+	}
+	config.Endpoint = nil
+	config.EndpointResolver = endpoints.ResolverFunc(func(service, region string, optFns ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		resolved, err := resolver.EndpointFor(service, region, optFns...)
+		if err != nil {
+			return resolved, err
+		}
+		resolved.URL = endpoint
+		return resolved, nil
+	})
+}
+
 func createStsSession(sess *session.Session, role config.Role, region string, fips bool, isDebugEnabled bool) *sts.STS {
 	maxStsRetries := 5
 	config := &aws.Config{MaxRetries: &maxStsRetries}
@@ -430,6 +1044,7 @@ func createCloudwatchSession(sess *session.Session, region *string, role config.
 		endpoint := fmt.Sprintf("https://monitoring-fips.%s.amazonaws.com", *region)
 		config.Endpoint = aws.String(endpoint)
 	}
+	setCustomEndpoint(sess, config, role.Endpoints.Cloudwatch, role.Endpoints.DisableEndpointResolution)
 
 	if isDebugEnabled {
 		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
@@ -445,6 +1060,7 @@ func createTagSession(sess *session.Session, region *string, role config.Role, i
 		MaxRetries:                    &maxResourceGroupTaggingRetries,
 		CredentialsChainVerboseErrors: aws.Bool(true),
 	}
+	setCustomEndpoint(sess, config, role.Endpoints.Tagging, role.Endpoints.DisableEndpointResolution)
 
 	if isDebugEnabled {
 		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
@@ -543,3 +1159,127 @@ func createAPIGatewaySession(sess *session.Session, region *string, role config.
 
 	return apigateway.New(sess, setSTSCreds(sess, config, role))
 }
+
+func createBedrockSession(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) bedrockiface.BedrockAPI {
+	maxBedrockAPIRetries := 5
+	config := &aws.Config{Region: region, MaxRetries: &maxBedrockAPIRetries}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/bedrock.html
+		endpoint := fmt.Sprintf("https://bedrock-fips.%s.amazonaws.com", *region)
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return bedrock.New(sess, setSTSCreds(sess, config, role))
+}
+
+func createConfigServiceSession(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) configserviceiface.ConfigServiceAPI {
+	maxConfigServiceAPIRetries := 5
+	config := &aws.Config{Region: region, MaxRetries: &maxConfigServiceAPIRetries}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/awsconfig.html
+		endpoint := fmt.Sprintf("https://config-fips.%s.amazonaws.com", *region)
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return configservice.New(sess, setSTSCreds(sess, config, role))
+}
+
+func createIAMSession(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) iamiface.IAMAPI {
+	maxIAMAPIRetries := 5
+	config := &aws.Config{Region: region, MaxRetries: &maxIAMAPIRetries}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/iam-service.html
+		config.Endpoint = aws.String("https://iam-fips.amazonaws.com")
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return iam.New(sess, setSTSCreds(sess, config, role))
+}
+
+func createCloudwatchLogsSession(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) cloudwatchlogsiface.CloudWatchLogsAPI {
+	config := &aws.Config{Region: region, Retryer: getAwsRetryer()}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/cwl_region.html
+		endpoint := fmt.Sprintf("https://logs-fips.%s.amazonaws.com", *region)
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return cloudwatchlogs.New(sess, setSTSCreds(sess, config, role))
+}
+
+func createS3Session(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) s3iface.S3API {
+	maxS3APIRetries := 5
+	config := &aws.Config{Region: region, MaxRetries: &maxS3APIRetries}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/s3.html
+		endpoint := fmt.Sprintf("https://s3-fips.%s.amazonaws.com", *region)
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return s3.New(sess, setSTSCreds(sess, config, role))
+}
+
+func createCloudFormationSession(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) cloudformationiface.CloudFormationAPI {
+	maxCloudFormationAPIRetries := 5
+	config := &aws.Config{Region: region, MaxRetries: &maxCloudFormationAPIRetries}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/cfn.html
+		endpoint := fmt.Sprintf("https://cloudformation-fips.%s.amazonaws.com", *region)
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return cloudformation.New(sess, setSTSCreds(sess, config, role))
+}
+
+func createRDSSession(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) rdsiface.RDSAPI {
+	config := &aws.Config{Region: region, Retryer: getAwsRetryer()}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/rds-service.html
+		endpoint := fmt.Sprintf("https://rds-fips.%s.amazonaws.com", *region)
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return rds.New(sess, setSTSCreds(sess, config, role))
+}
+
+func createElastiCacheSession(sess *session.Session, region *string, role config.Role, fips bool, isDebugEnabled bool) elasticacheiface.ElastiCacheAPI {
+	config := &aws.Config{Region: region, Retryer: getAwsRetryer()}
+	if fips {
+		// https://docs.aws.amazon.com/general/latest/gr/elasticache-service.html
+		endpoint := fmt.Sprintf("https://elasticache-fips.%s.amazonaws.com", *region)
+		config.Endpoint = aws.String(endpoint)
+	}
+
+	if isDebugEnabled {
+		config.LogLevel = aws.LogLevel(aws.LogDebugWithHTTPBody)
+	}
+
+	return elasticache.New(sess, setSTSCreds(sess, config, role))
+}
@@ -1,20 +1,35 @@
 package session
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/awstesting/mock"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/config"
 	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/logger"
+	"github.com/nerdswords/yet-another-cloudwatch-exporter/pkg/promutil"
 )
 
 func cmpCache(t *testing.T, initialCache *sessionCache, cache *sessionCache) {
@@ -445,6 +460,96 @@ func TestNewSessionCache(t *testing.T) {
 				logger: logger.NewLogrusLogger(log.StandardLogger()),
 			},
 		},
+		{
+			"a ScrapeConf with only alarms jobs creates a cache",
+			config.ScrapeConf{
+				Alarms: []*config.Alarms{
+					{
+						Name:    "alarms",
+						Regions: []string{"us-east-1", "eu-west-2"},
+						Roles: []config.Role{
+							{
+								RoleArn: "some-arn",
+							},
+						},
+					},
+				},
+			},
+			false,
+			&sessionCache{
+				stscache: map[config.Role]stsiface.STSAPI{
+					{RoleArn: "some-arn"}: nil,
+				},
+				clients: map[config.Role]map[string]*clientCache{
+					{RoleArn: "some-arn"}: {
+						"eu-west-2": &clientCache{onlyStatic: true},
+						"us-east-1": &clientCache{onlyStatic: true},
+					},
+				},
+				logger: logger.NewLogrusLogger(log.StandardLogger()),
+			},
+		},
+		{
+			"a ScrapeConf with logs insights jobs creates a cache",
+			config.ScrapeConf{
+				Alarms: []*config.Alarms{
+					{
+						Name:    "alarms",
+						Regions: []string{"us-east-1"},
+						Roles:   []config.Role{{RoleArn: "some-arn"}},
+					},
+				},
+				LogsInsights: []*config.LogsInsights{
+					{
+						Name:    "errors",
+						Regions: []string{"us-east-1", "eu-west-2"},
+						Roles:   []config.Role{{RoleArn: "some-arn"}},
+					},
+				},
+			},
+			false,
+			&sessionCache{
+				stscache: map[config.Role]stsiface.STSAPI{
+					{RoleArn: "some-arn"}: nil,
+				},
+				clients: map[config.Role]map[string]*clientCache{
+					{RoleArn: "some-arn"}: {
+						"eu-west-2": &clientCache{onlyStatic: true, logsInsights: true},
+						"us-east-1": &clientCache{onlyStatic: true, logsInsights: true},
+					},
+				},
+				logger: logger.NewLogrusLogger(log.StandardLogger()),
+			},
+		},
+		{
+			"the region of a config aggregator gets clients",
+			config.ScrapeConf{
+				Discovery: config.Discovery{
+					Jobs: []*config.Job{
+						{
+							Regions:           []string{"eu-west-1", "us-east-1"},
+							Roles:             []config.Role{{RoleArn: "some-arn"}},
+							ResourceDiscovery: config.ResourceDiscoveryConfig,
+							ConfigAggregator:  &config.ConfigAggregator{Name: "aggregator", Region: "us-west-2"},
+						},
+					},
+				},
+			},
+			false,
+			&sessionCache{
+				stscache: map[config.Role]stsiface.STSAPI{
+					{RoleArn: "some-arn"}: nil,
+				},
+				clients: map[config.Role]map[string]*clientCache{
+					{RoleArn: "some-arn"}: {
+						"eu-west-1": &clientCache{},
+						"us-east-1": &clientCache{},
+						"us-west-2": &clientCache{},
+					},
+				},
+				logger: logger.NewLogrusLogger(log.StandardLogger()),
+			},
+		},
 	}
 
 	for _, l := range tests {
@@ -504,6 +609,13 @@ func TestClear(t *testing.T) {
 							apiGateway:     createAPIGatewaySession(mock.Session, &region, role, false, false),
 							storageGateway: createStorageGatewaySession(mock.Session, &region, role, false, false),
 							prometheus:     createPrometheusSession(mock.Session, &region, role, false, false),
+							bedrock:        createBedrockSession(mock.Session, &region, role, false, false),
+							configService:  createConfigServiceSession(mock.Session, &region, role, false, false),
+							s3:             createS3Session(mock.Session, &region, role, false, false),
+							cloudFormation: createCloudFormationSession(mock.Session, &region, role, false, false),
+							rds:            createRDSSession(mock.Session, &region, role, false, false),
+							elastiCache:    createElastiCacheSession(mock.Session, &region, role, false, false),
+							logs:           createCloudwatchLogsSession(mock.Session, &region, role, false, false),
 							onlyStatic:     true,
 						},
 					},
@@ -530,6 +642,9 @@ func TestClear(t *testing.T) {
 							apiGateway:     nil,
 							storageGateway: nil,
 							prometheus:     nil,
+							bedrock:        nil,
+							configService:  nil,
+							s3:             nil,
 						},
 					},
 				},
@@ -592,6 +707,34 @@ func TestClear(t *testing.T) {
 						t.Logf("`storageGateway client` %v in region %v is not nil", role, region)
 						t.Fail()
 					}
+					if client.bedrock != nil {
+						t.Logf("`bedrock client` %v in region %v is not nil", role, region)
+						t.Fail()
+					}
+					if client.configService != nil {
+						t.Logf("`configService client` %v in region %v is not nil", role, region)
+						t.Fail()
+					}
+					if client.s3 != nil {
+						t.Logf("`s3 client` %v in region %v is not nil", role, region)
+						t.Fail()
+					}
+					if client.cloudFormation != nil {
+						t.Logf("`cloudFormation client` %v in region %v is not nil", role, region)
+						t.Fail()
+					}
+					if client.logs != nil {
+						t.Logf("`logs client` %v in region %v is not nil", role, region)
+						t.Fail()
+					}
+					if client.rds != nil {
+						t.Logf("`rds client` %v in region %v is not nil", role, region)
+						t.Fail()
+					}
+					if client.elastiCache != nil {
+						t.Logf("`elastiCache client` %v in region %v is not nil", role, region)
+						t.Fail()
+					}
 				}
 			}
 		})
@@ -627,6 +770,9 @@ func TestRefresh(t *testing.T) {
 							apiGateway:     nil,
 							storageGateway: nil,
 							prometheus:     nil,
+							bedrock:        nil,
+							configService:  nil,
+							s3:             nil,
 						},
 					},
 				},
@@ -654,6 +800,9 @@ func TestRefresh(t *testing.T) {
 							apiGateway:     nil,
 							storageGateway: nil,
 							prometheus:     nil,
+							bedrock:        nil,
+							configService:  nil,
+							s3:             nil,
 							onlyStatic:     true,
 						},
 					},
@@ -662,6 +811,27 @@ func TestRefresh(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"a new refresh with logs insights creates cloudwatch and logs",
+			&sessionCache{
+				session:   mock.Session,
+				refreshed: false,
+				mu:        sync.Mutex{},
+				stscache: map[config.Role]stsiface.STSAPI{
+					{}: nil,
+				},
+				clients: map[config.Role]map[string]*clientCache{
+					{}: {
+						"us-east-1": &clientCache{
+							onlyStatic:   true,
+							logsInsights: true,
+						},
+					},
+				},
+				logger: logger.NewLogrusLogger(log.StandardLogger()),
+			},
+			true,
+		},
 		{
 			"A second call to refreshed does nothing",
 			&sessionCache{
@@ -682,6 +852,13 @@ func TestRefresh(t *testing.T) {
 							apiGateway:     createAPIGatewaySession(mock.Session, &region, role, false, false),
 							storageGateway: createStorageGatewaySession(mock.Session, &region, role, false, false),
 							prometheus:     createPrometheusSession(mock.Session, &region, role, false, false),
+							bedrock:        createBedrockSession(mock.Session, &region, role, false, false),
+							configService:  createConfigServiceSession(mock.Session, &region, role, false, false),
+							s3:             createS3Session(mock.Session, &region, role, false, false),
+							cloudFormation: createCloudFormationSession(mock.Session, &region, role, false, false),
+							rds:            createRDSSession(mock.Session, &region, role, false, false),
+							elastiCache:    createElastiCacheSession(mock.Session, &region, role, false, false),
+							logs:           createCloudwatchLogsSession(mock.Session, &region, role, false, false),
 						},
 					},
 				},
@@ -720,6 +897,10 @@ func TestRefresh(t *testing.T) {
 						t.Logf("`cloudwatch client` %v in region %v still nil", role, region)
 						t.Fail()
 					}
+					if (client.logsInsights || !client.onlyStatic) && client.logs == nil {
+						t.Logf("`logs client` %v in region %v still nil", role, region)
+						t.Fail()
+					}
 					if test.cloudwatch {
 						continue
 					}
@@ -751,6 +932,30 @@ func TestRefresh(t *testing.T) {
 						t.Logf("`storageGateway client` %v in region %v still nil", role, region)
 						t.Fail()
 					}
+					if client.bedrock == nil {
+						t.Logf("`bedrock client` %v in region %v still nil", role, region)
+						t.Fail()
+					}
+					if client.configService == nil {
+						t.Logf("`configService client` %v in region %v still nil", role, region)
+						t.Fail()
+					}
+					if client.s3 == nil {
+						t.Logf("`s3 client` %v in region %v still nil", role, region)
+						t.Fail()
+					}
+					if client.cloudFormation == nil {
+						t.Logf("`cloudFormation client` %v in region %v still nil", role, region)
+						t.Fail()
+					}
+					if client.rds == nil {
+						t.Logf("`rds client` %v in region %v still nil", role, region)
+						t.Fail()
+					}
+					if client.elastiCache == nil {
+						t.Logf("`elastiCache client` %v in region %v still nil", role, region)
+						t.Fail()
+					}
 				}
 			}
 		})
@@ -769,6 +974,18 @@ func TestSessionCacheGetSTS(t *testing.T) {
 		})
 }
 
+func TestSessionCacheGetIAM(t *testing.T) {
+	testGetAWSClient(
+		t, "IAM",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetIAM(role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
 func TestSessionCacheGetCloudwatch(t *testing.T) {
 	testGetAWSClient(
 		t, "Cloudwatch",
@@ -853,6 +1070,90 @@ func TestSessionCacheGetAPIGateway(t *testing.T) {
 		})
 }
 
+func TestSessionCacheGetBedrock(t *testing.T) {
+	testGetAWSClient(
+		t, "Bedrock",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetBedrock(region, role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
+func TestSessionCacheGetConfigService(t *testing.T) {
+	testGetAWSClient(
+		t, "ConfigService",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetConfigService(region, role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
+func TestSessionCacheGetS3(t *testing.T) {
+	testGetAWSClient(
+		t, "S3",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetS3(region, role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
+func TestSessionCacheGetCloudFormation(t *testing.T) {
+	testGetAWSClient(
+		t, "CloudFormation",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetCloudFormation(region, role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
+func TestSessionCacheGetCloudwatchLogs(t *testing.T) {
+	testGetAWSClient(
+		t, "CloudWatch Logs",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetCloudwatchLogs(region, role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
+func TestSessionCacheGetRDS(t *testing.T) {
+	testGetAWSClient(
+		t, "RDS",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetRDS(region, role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
+func TestSessionCacheGetElastiCache(t *testing.T) {
+	testGetAWSClient(
+		t, "ElastiCache",
+		func(t *testing.T, cache *sessionCache, region *string, role config.Role) {
+			iface := cache.GetElastiCache(region, role)
+			if iface == nil {
+				t.Fail()
+				return
+			}
+		})
+}
+
 func testGetAWSClient(
 	t *testing.T,
 	name string,
@@ -885,6 +1186,12 @@ func testGetAWSClient(
 							apiGateway:     createAPIGatewaySession(mock.Session, &region, role, false, false),
 							storageGateway: createStorageGatewaySession(mock.Session, &region, role, false, false),
 							prometheus:     createPrometheusSession(mock.Session, &region, role, false, false),
+							bedrock:        createBedrockSession(mock.Session, &region, role, false, false),
+							configService:  createConfigServiceSession(mock.Session, &region, role, false, false),
+							s3:             createS3Session(mock.Session, &region, role, false, false),
+							cloudFormation: createCloudFormationSession(mock.Session, &region, role, false, false),
+							rds:            createRDSSession(mock.Session, &region, role, false, false),
+							elastiCache:    createElastiCacheSession(mock.Session, &region, role, false, false),
 						},
 					},
 				},
@@ -912,6 +1219,12 @@ func testGetAWSClient(
 							apiGateway:     createAPIGatewaySession(mock.Session, &region, role, false, false),
 							storageGateway: createStorageGatewaySession(mock.Session, &region, role, false, false),
 							prometheus:     createPrometheusSession(mock.Session, &region, role, false, false),
+							bedrock:        createBedrockSession(mock.Session, &region, role, false, false),
+							configService:  createConfigServiceSession(mock.Session, &region, role, false, false),
+							s3:             createS3Session(mock.Session, &region, role, false, false),
+							cloudFormation: createCloudFormationSession(mock.Session, &region, role, false, false),
+							rds:            createRDSSession(mock.Session, &region, role, false, false),
+							elastiCache:    createElastiCacheSession(mock.Session, &region, role, false, false),
 						},
 					},
 				},
@@ -956,6 +1269,42 @@ func testGetAWSClient(
 	}
 }
 
+func TestEnabledRegions(t *testing.T) {
+	output := &ec2.DescribeRegionsOutput{
+		Regions: []*ec2.Region{
+			{RegionName: aws.String("us-east-1"), OptInStatus: aws.String("opt-in-not-required")},
+			{RegionName: aws.String("af-south-1"), OptInStatus: aws.String("not-opted-in")},
+			{RegionName: aws.String("ap-east-1"), OptInStatus: aws.String("opted-in")},
+		},
+	}
+
+	regions := enabledRegions(output, logger.NewLogrusLogger(log.StandardLogger()))
+
+	if fmt.Sprint(regions) != "[us-east-1 ap-east-1]" {
+		t.Errorf("expected the regions enabled for the account but got %v", regions)
+	}
+}
+
+func TestGetRegionsCached(t *testing.T) {
+	role := config.Role{RoleArn: "arn:aws:iam::123456789012:role/yace"}
+	cache := &sessionCache{
+		session: mock.Session,
+		clients: map[config.Role]map[string]*clientCache{},
+		regions: map[config.Role]cachedRegions{
+			role: {regions: []string{"us-east-1", "eu-west-1"}, expiry: time.Now().Add(time.Minute)},
+		},
+		logger: logger.NewLogrusLogger(log.StandardLogger()),
+	}
+
+	regions, err := cache.GetRegions(context.Background(), role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(regions) != "[us-east-1 eu-west-1]" {
+		t.Errorf("expected the cached regions but got %v", regions)
+	}
+}
+
 func TestSetExternalID(t *testing.T) {
 	tests := []struct {
 		descrip string
@@ -1018,6 +1367,24 @@ func TestSetSTSCreds(t *testing.T) {
 			true,
 			"",
 		},
+		{
+			"sets the web identity creds if the role has a web identity token file",
+			config.Role{
+				RoleArn:              "this:arn",
+				WebIdentityTokenFile: "/var/run/secrets/token",
+			},
+			false,
+			"",
+		},
+		{
+			"sets the chained creds if the role has a source role",
+			config.Role{
+				RoleArn:    "this:arn",
+				SourceRole: &config.Role{RoleArn: "intermediate:arn", ExternalID: "thing"},
+			},
+			false,
+			"",
+		},
 	}
 
 	for _, l := range tests {
@@ -1038,6 +1405,218 @@ func TestSetSTSCreds(t *testing.T) {
 	}
 }
 
+type failingAssumeRoler struct{}
+
+func (failingAssumeRoler) AssumeRole(*sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	return nil, awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil)
+}
+
+func TestRoleChainProviderError(t *testing.T) {
+	provider := &roleChainProvider{
+		AssumeRoleProvider: &stscreds.AssumeRoleProvider{
+			Client:  failingAssumeRoler{},
+			RoleARN: "arn:aws:iam::123456789012:role/intermediate",
+		},
+		hop:    1,
+		length: 2,
+	}
+
+	_, err := provider.Retrieve()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, expected := range []string{"failed to assume role arn:aws:iam::123456789012:role/intermediate, hop 1 of 2 of the role chain", "AccessDenied"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("error %q does not contain %q", err, expected)
+		}
+	}
+}
+
+// expiringAssumeRoler returns credentials expiring after duration, from the current time of its clock
+type expiringAssumeRoler struct {
+	now      *time.Time
+	duration time.Duration
+	calls    int
+	err      error
+}
+
+func (c *expiringAssumeRoler) AssumeRole(*sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String(fmt.Sprintf("AKID%d", c.calls)),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(c.now.Add(c.duration)),
+	}}, nil
+}
+
+func TestCredentialRefresh(t *testing.T) {
+	roleArn := "arn:aws:iam::123456789012:role/refresh"
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := &expiringAssumeRoler{now: &now, duration: 15 * time.Minute}
+	provider := &stscreds.AssumeRoleProvider{Client: client, RoleARN: roleArn}
+	provider.Expiry.CurrentTime = func() time.Time { return now }
+	setCredentialRefresh(config.CredentialRefresh{ExpiryWindow: time.Minute, Duration: 15 * time.Minute})(provider)
+	creds := credentials.NewCredentials(&countingProvider{Provider: provider, roleArn: roleArn})
+
+	successes := testutil.ToFloat64(promutil.CredentialRefreshCounter.WithLabelValues(roleArn, "success"))
+	failures := testutil.ToFloat64(promutil.CredentialRefreshCounter.WithLabelValues(roleArn, "failure"))
+
+	get := func() string {
+		value, err := creds.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value.AccessKeyID
+	}
+
+	if provider.Duration != 15*time.Minute {
+		t.Errorf("expected sessions of 15m0s but got %s", provider.Duration)
+	}
+	if key := get(); key != "AKID1" {
+		t.Errorf("expected the credentials of the first session but got %s", key)
+	}
+
+	// The credentials are cached until the expiry window, even within a single scrape
+	now = now.Add(13 * time.Minute)
+	if key := get(); key != "AKID1" {
+		t.Errorf("expected the cached credentials but got %s", key)
+	}
+	now = now.Add(90 * time.Second)
+	if key := get(); key != "AKID2" {
+		t.Errorf("expected the credentials to be refreshed before they expire but got %s", key)
+	}
+	if client.calls != 2 {
+		t.Errorf("expected 2 sessions to be assumed but got %d", client.calls)
+	}
+
+	// A failed refresh is counted and returned
+	client.err = awserr.New("AccessDenied", "not authorized to perform sts:AssumeRole", nil)
+	now = now.Add(15 * time.Minute)
+	if _, err := creds.Get(); err == nil {
+		t.Error("expected the refresh to fail")
+	}
+
+	if delta := testutil.ToFloat64(promutil.CredentialRefreshCounter.WithLabelValues(roleArn, "success")) - successes; delta != 2 {
+		t.Errorf("expected 2 successful refreshes to be counted but got %v", delta)
+	}
+	if delta := testutil.ToFloat64(promutil.CredentialRefreshCounter.WithLabelValues(roleArn, "failure")) - failures; delta != 1 {
+		t.Errorf("expected 1 failed refresh to be counted but got %v", delta)
+	}
+}
+
+func TestSessionCacheTTL(t *testing.T) {
+	role := config.Role{}
+	cache := &sessionCache{
+		session:  mock.Session,
+		stscache: map[config.Role]stsiface.STSAPI{role: nil},
+		clients: map[config.Role]map[string]*clientCache{
+			role: {"us-east-1": &clientCache{onlyStatic: true}},
+		},
+		sessionCacheTTL: time.Hour,
+		logger:          logger.NewLogrusLogger(log.StandardLogger()),
+	}
+
+	cache.Refresh()
+	cloudwatchClient := cache.clients[role]["us-east-1"].cloudwatch
+	if cloudwatchClient == nil {
+		t.Fatal("expected the clients to be created")
+	}
+
+	// The clients are kept by the scrapes within the TTL, new regions get theirs
+	cache.Clear()
+	if !cache.cleared || cache.clients[role]["us-east-1"].cloudwatch != cloudwatchClient {
+		t.Error("expected the clients to be kept within the session cache TTL")
+	}
+	cache.clients[role]["eu-west-1"] = &clientCache{onlyStatic: true}
+	cache.Refresh()
+	if cache.clients[role]["us-east-1"].cloudwatch != cloudwatchClient {
+		t.Error("expected the clients to be reused within the session cache TTL")
+	}
+	if cache.clients[role]["eu-west-1"].cloudwatch == nil {
+		t.Error("expected the clients of a new region to be created")
+	}
+
+	// They are recreated after it
+	cache.clientsCreatedAt = cache.clientsCreatedAt.Add(-2 * time.Hour)
+	cache.Clear()
+	if cache.clients[role]["us-east-1"].cloudwatch != nil {
+		t.Error("expected the clients to be cleared after the session cache TTL")
+	}
+	cache.Refresh()
+	if client := cache.clients[role]["us-east-1"].cloudwatch; client == nil || client == cloudwatchClient {
+		t.Error("expected the clients to be recreated after the session cache TTL")
+	}
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+	var mu sync.Mutex
+	var forms []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		mu.Lock()
+		forms = append(forms, r.Form)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>` +
+			`<AccessKeyId>AKIDWEBIDENTITY</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>` +
+			`<Expiration>2100-01-01T00:00:00Z</Expiration></Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer server.Close()
+
+	client := sts.New(mock.Session, &aws.Config{Endpoint: aws.String(server.URL), Region: aws.String("us-east-1")})
+	tokenFile := filepath.Join(t.TempDir(), "token")
+
+	tests := []struct {
+		descrip       string
+		token         string
+		expectedError string
+	}{
+		{
+			"the role can't be assumed without the token file",
+			"",
+			"web identity token file " + tokenFile + " can't be read",
+		},
+		{
+			"the role is assumed with the token of the file",
+			"eyJhbGciOiJSUzI1NiJ9",
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.descrip, func(t *testing.T) {
+			if test.token != "" {
+				if err := os.WriteFile(tokenFile, []byte(test.token), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			role := config.Role{RoleArn: "arn:aws:iam::123456789012:role/yace", WebIdentityTokenFile: tokenFile, RoleSessionName: "yace"}
+			value, err := newWebIdentityProvider(client, role).Retrieve()
+			if test.expectedError != "" {
+				if err == nil || !strings.Contains(err.Error(), test.expectedError) || !strings.Contains(err.Error(), role.RoleArn) {
+					t.Fatalf("expected an error containing %q and the role arn but got %v", test.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.AccessKeyID != "AKIDWEBIDENTITY" {
+				t.Errorf("expected the credentials of the assumed role but got %s", value.AccessKeyID)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			form := forms[len(forms)-1]
+			if form.Get("WebIdentityToken") != test.token || form.Get("RoleSessionName") != "yace" || form.Get("RoleArn") != role.RoleArn {
+				t.Errorf("unexpected AssumeRoleWithWebIdentity request %v", form)
+			}
+		})
+	}
+}
+
 func TestCreateAWSSession(t *testing.T) {
 	tests := []struct {
 		descrip string
@@ -1171,6 +1750,78 @@ func TestCreatePrometheusSession(t *testing.T) {
 		})
 }
 
+func TestCreateBedrockSession(t *testing.T) {
+	testAWSClient(
+		t,
+		"Bedrock",
+		func(t *testing.T, s *session.Session, region *string, role config.Role, fips bool) {
+			iface := createBedrockSession(s, region, role, fips, false)
+			if iface == nil {
+				t.Fail()
+			}
+		})
+}
+
+func TestCreateConfigServiceSession(t *testing.T) {
+	testAWSClient(
+		t,
+		"ConfigService",
+		func(t *testing.T, s *session.Session, region *string, role config.Role, fips bool) {
+			iface := createConfigServiceSession(s, region, role, fips, false)
+			if iface == nil {
+				t.Fail()
+			}
+		})
+}
+
+func TestCreateS3Session(t *testing.T) {
+	testAWSClient(
+		t,
+		"S3",
+		func(t *testing.T, s *session.Session, region *string, role config.Role, fips bool) {
+			iface := createS3Session(s, region, role, fips, false)
+			if iface == nil {
+				t.Fail()
+			}
+		})
+}
+
+func TestCreateCloudFormationSession(t *testing.T) {
+	testAWSClient(
+		t,
+		"CloudFormation",
+		func(t *testing.T, s *session.Session, region *string, role config.Role, fips bool) {
+			iface := createCloudFormationSession(s, region, role, fips, false)
+			if iface == nil {
+				t.Fail()
+			}
+		})
+}
+
+func TestCreateCloudwatchLogsSession(t *testing.T) {
+	testAWSClient(
+		t,
+		"CloudWatch Logs",
+		func(t *testing.T, s *session.Session, region *string, role config.Role, fips bool) {
+			iface := createCloudwatchLogsSession(s, region, role, fips, false)
+			if iface == nil {
+				t.Fail()
+			}
+		})
+}
+
+func TestCreateIAMSession(t *testing.T) {
+	testAWSClient(
+		t,
+		"IAM",
+		func(t *testing.T, s *session.Session, region *string, role config.Role, fips bool) {
+			iface := createIAMSession(s, region, role, fips, false)
+			if iface == nil {
+				t.Fail()
+			}
+		})
+}
+
 func TestCreateDMSSession(t *testing.T) {
 	testAWSClient(
 		t,
@@ -1207,6 +1858,18 @@ func TestCreateStorageGatewaySession(t *testing.T) {
 		})
 }
 
+func TestCreateElastiCacheSession(t *testing.T) {
+	testAWSClient(
+		t,
+		"ElastiCache",
+		func(t *testing.T, s *session.Session, region *string, role config.Role, fips bool) {
+			iface := createElastiCacheSession(s, region, role, fips, false)
+			if iface == nil {
+				t.Fail()
+			}
+		})
+}
+
 func testAWSClient(
 	t *testing.T,
 	name string,
@@ -1265,3 +1928,205 @@ func testAWSClient(
 		})
 	}
 }
+
+func TestPartitionEndpoints(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+
+	var mu sync.Mutex
+	var scopes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The credential scope of the signature is <key>/<date>/<region>/<service>/aws4_request
+		mu.Lock()
+		authorization := r.Header.Get("Authorization")
+		if i := strings.Index(authorization, "Credential="); i >= 0 {
+			scope := strings.Split(strings.SplitN(authorization[i+len("Credential="):], ",", 2)[0], "/")
+			scopes = append(scopes, scope[3]+"/"+scope[2])
+		}
+		mu.Unlock()
+
+		_ = r.ParseForm()
+		switch r.Form.Get("Action") {
+		case "GetCallerIdentity":
+			_, _ = w.Write([]byte(`<GetCallerIdentityResponse><GetCallerIdentityResult><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`))
+		default:
+			_, _ = w.Write([]byte(`<ListMetricsResponse><ListMetricsResult><Metrics></Metrics></ListMetricsResult></ListMetricsResponse>`))
+		}
+	}))
+	defer server.Close()
+
+	var resolved []string
+	resolver := func(service, region string, _ ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		mu.Lock()
+		resolved = append(resolved, service+"/"+region)
+		mu.Unlock()
+		return endpoints.ResolvedEndpoint{URL: server.URL, SigningRegion: region}, nil
+	}
+
+	govRole := config.Role{Partition: "aws-us-gov"}
+	region := "us-gov-west-1"
+	cfg := config.ScrapeConf{
+		StsRegion: "eu-west-1",
+		CustomNamespace: []*config.CustomNamespace{
+			{Regions: []string{region}, Roles: []config.Role{govRole}},
+		},
+	}
+	cache := NewSessionCache(cfg, false, logger.NewLogrusLogger(log.StandardLogger())).(*sessionCache)
+	// Only the endpoints of the gov partition are pointed to the test server
+	cache.partitions["aws-us-gov"] = newPartition(resolver, map[string]endpoints.Region{"us-gov-east-1": {}, "us-gov-west-1": {}})
+	cache.Refresh()
+
+	if _, err := cache.GetSTS(govRole).GetCallerIdentity(&sts.GetCallerIdentityInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetCloudwatch(&region, govRole).ListMetrics(&cloudwatch.ListMetricsInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// sts-region isn't part of the partition, so STS falls back to its first region
+	expected := []string{"sts/us-gov-east-1", "monitoring/us-gov-west-1"}
+	if fmt.Sprint(resolved) != fmt.Sprint(expected) {
+		t.Errorf("expected endpoints %v to be resolved but got %v", expected, resolved)
+	}
+	if fmt.Sprint(scopes) != fmt.Sprint(expected) {
+		t.Errorf("expected requests signed for %v but got %v", expected, scopes)
+	}
+	if cache.sessionFor(govRole) == cache.sessionFor(config.Role{}) {
+		t.Error("expected the roles of the partition to use their own session")
+	}
+}
+
+func TestCustomEndpoints(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+
+	// newServer returns a server recording the actions it's called with and the region they're signed for
+	newServer := func(requests *[]string, mu *sync.Mutex) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = r.ParseForm()
+			mu.Lock()
+			authorization := r.Header.Get("Authorization")
+			if i := strings.Index(authorization, "Credential="); i >= 0 {
+				scope := strings.Split(strings.SplitN(authorization[i+len("Credential="):], ",", 2)[0], "/")
+				*requests = append(*requests, r.Form.Get("Action")+"/"+scope[2])
+			}
+			mu.Unlock()
+
+			switch r.Form.Get("Action") {
+			case "GetCallerIdentity":
+				_, _ = w.Write([]byte(`<GetCallerIdentityResponse><GetCallerIdentityResult><Account>123456789012</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`))
+			default:
+				_, _ = w.Write([]byte(`<ListMetricsResponse><ListMetricsResult><Metrics></Metrics></ListMetricsResult></ListMetricsResponse>`))
+			}
+		}))
+	}
+
+	tests := []struct {
+		descrip           string
+		disableResolution bool
+		expectedResolved  []string
+	}{
+		{
+			"the endpoint of cloudwatch is resolved and its url replaced",
+			false,
+			[]string{"sts/eu-west-1", "monitoring/us-east-1"},
+		},
+		{
+			"the endpoint of cloudwatch is used as is without resolution",
+			true,
+			[]string{"sts/eu-west-1"},
+		},
+	}
+
+	for _, l := range tests {
+		test := l
+		t.Run(test.descrip, func(t *testing.T) {
+			var mu sync.Mutex
+			var defaultRequests, customRequests []string
+			defaultServer := newServer(&defaultRequests, &mu)
+			defer defaultServer.Close()
+			customServer := newServer(&customRequests, &mu)
+			defer customServer.Close()
+
+			var resolved []string
+			resolver := func(service, region string, _ ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+				mu.Lock()
+				resolved = append(resolved, service+"/"+region)
+				mu.Unlock()
+				return endpoints.ResolvedEndpoint{URL: defaultServer.URL, SigningRegion: region}, nil
+			}
+
+			role := config.Role{Endpoints: config.Endpoints{Cloudwatch: customServer.URL, DisableEndpointResolution: test.disableResolution}}
+			region := "us-east-1"
+			cfg := config.ScrapeConf{
+				StsRegion: "eu-west-1",
+				Static:    []*config.Static{{Regions: []string{region}, Roles: []config.Role{role}}},
+			}
+			cache := NewSessionCache(cfg, false, logger.NewLogrusLogger(log.StandardLogger())).(*sessionCache)
+			cache.endpointResolver = resolver
+			cache.Refresh()
+
+			if _, err := cache.GetSTS(role).GetCallerIdentity(&sts.GetCallerIdentityInput{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cache.GetCloudwatch(&region, role).ListMetrics(&cloudwatch.ListMetricsInput{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if fmt.Sprint(resolved) != fmt.Sprint(test.expectedResolved) {
+				t.Errorf("expected endpoints %v to be resolved but got %v", test.expectedResolved, resolved)
+			}
+			// STS keeps its default endpoint
+			if expected := []string{"GetCallerIdentity/eu-west-1"}; fmt.Sprint(defaultRequests) != fmt.Sprint(expected) {
+				t.Errorf("expected requests %v to the default endpoint but got %v", expected, defaultRequests)
+			}
+			if expected := []string{"ListMetrics/us-east-1"}; fmt.Sprint(customRequests) != fmt.Sprint(expected) {
+				t.Errorf("expected requests %v to the custom endpoint but got %v", expected, customRequests)
+			}
+		})
+	}
+}
+
+func TestStsRegionFor(t *testing.T) {
+	tests := []struct {
+		descrip   string
+		stsRegion string
+		role      config.Role
+		expected  string
+	}{
+		{
+			"the configured sts region is used without partition",
+			"eu-west-1",
+			config.Role{},
+			"eu-west-1",
+		},
+		{
+			"the configured sts region is used when it is in the partition",
+			"us-gov-west-1",
+			config.Role{Partition: "aws-us-gov"},
+			"us-gov-west-1",
+		},
+		{
+			"a region of the partition is used when the configured one is not part of it",
+			"eu-west-1",
+			config.Role{Partition: "aws-cn"},
+			"cn-north-1",
+		},
+	}
+
+	for _, l := range tests {
+		test := l
+		t.Run(test.descrip, func(t *testing.T) {
+			cache := NewSessionCache(config.ScrapeConf{StsRegion: test.stsRegion}, false, logger.NewLogrusLogger(log.StandardLogger())).(*sessionCache)
+			if region := cache.stsRegionFor(test.role); region != test.expected {
+				t.Errorf("expected sts region %s but got %s", test.expected, region)
+			}
+		})
+	}
+}